@@ -46,44 +46,45 @@ func printStruct(s interface{}) string {
 // APIrequest contains all of the possible request fields.
 // Fields must be in alphabetical order
 type APIrequest struct {
-	CensusID     string                         `json:"censusId,omitempty"`
-	CensusURI    string                         `json:"censusUri,omitempty"`
-	CensusKey    []byte                         `json:"censusKey,omitempty"`
-	CensusKeys   [][]byte                       `json:"censusKeys,omitempty"`
-	CensusValue  []byte                         `json:"censusValue,omitempty"`
-	CensusDump   []byte                         `json:"censusDump,omitempty"`
-	CensusType   models.Census_Type             `json:"censusType,omitempty"`
-	Content      []byte                         `json:"content,omitempty"`
-	Digested     bool                           `json:"digested,omitempty"`
-	EntityId     types.HexBytes                 `json:"entityId,omitempty"`
-	EthProof     *ethstorageproof.StorageResult `json:"storageProof,omitempty"`
-	Hash         types.HexBytes                 `json:"hash,omitempty"`
-	Height       uint32                         `json:"height,omitempty"`
-	ID           uint32                         `json:"id,omitempty"`
-	From         int                            `json:"from,omitempty"`
-	ListSize     int                            `json:"listSize,omitempty"`
-	Method       string                         `json:"method"`
-	Name         string                         `json:"name,omitempty"`
-	Namespace    uint32                         `json:"namespace,omitempty"`
-	NewProcess   *NewProcess                    `json:"newProcess,omitempty"`
-	Nullifier    types.HexBytes                 `json:"nullifier,omitempty"`
-	Payload      []byte                         `json:"payload,omitempty"`
-	ProcessID    types.HexBytes                 `json:"processId,omitempty"`
-	ProofData    types.HexBytes                 `json:"proofData,omitempty"`
-	PubKeys      []string                       `json:"pubKeys,omitempty"`
-	RootHash     types.HexBytes                 `json:"rootHash,omitempty"`
-	SearchTerm   string                         `json:"searchTerm,omitempty"`
-	Signature    types.HexBytes                 `json:"signature,omitempty"`
-	SrcNetId     string                         `json:"sourceNetworkId,omitempty"`
-	Status       string                         `json:"status,omitempty"`
-	Timestamp    int32                          `json:"timestamp"`
-	TxIndex      int32                          `json:"txIndex,omitempty"`
-	Type         string                         `json:"type,omitempty"`
-	URI          string                         `json:"uri,omitempty"`
-	Weight       *types.BigInt                  `json:"weight,omitempty"`
-	Weights      []*types.BigInt                `json:"weights,omitempty"`
-	WithResults  bool                           `json:"withResults,omitempty"`
-	VoterAddress types.HexBytes                 `json:"voterAddress,omitempty"`
+	CensusID      string                         `json:"censusId,omitempty"`
+	CensusURI     string                         `json:"censusUri,omitempty"`
+	CensusKey     []byte                         `json:"censusKey,omitempty"`
+	CensusKeys    [][]byte                       `json:"censusKeys,omitempty"`
+	CensusValue   []byte                         `json:"censusValue,omitempty"`
+	CensusDump    []byte                         `json:"censusDump,omitempty"`
+	CensusType    models.Census_Type             `json:"censusType,omitempty"`
+	Content       []byte                         `json:"content,omitempty"`
+	Digested      bool                           `json:"digested,omitempty"`
+	EntityId      types.HexBytes                 `json:"entityId,omitempty"`
+	EthProof      *ethstorageproof.StorageResult `json:"storageProof,omitempty"`
+	Hash          types.HexBytes                 `json:"hash,omitempty"`
+	Height        uint32                         `json:"height,omitempty"`
+	ID            uint32                         `json:"id,omitempty"`
+	From          int                            `json:"from,omitempty"`
+	ListSize      int                            `json:"listSize,omitempty"`
+	Method        string                         `json:"method"`
+	Name          string                         `json:"name,omitempty"`
+	Namespace     uint32                         `json:"namespace,omitempty"`
+	NewProcess    *NewProcess                    `json:"newProcess,omitempty"`
+	Nullifier     types.HexBytes                 `json:"nullifier,omitempty"`
+	Payload       []byte                         `json:"payload,omitempty"`
+	ProcessID     types.HexBytes                 `json:"processId,omitempty"`
+	ProcessSearch *indexertypes.ProcessSearch    `json:"processSearch,omitempty"`
+	ProofData     types.HexBytes                 `json:"proofData,omitempty"`
+	PubKeys       []string                       `json:"pubKeys,omitempty"`
+	RootHash      types.HexBytes                 `json:"rootHash,omitempty"`
+	SearchTerm    string                         `json:"searchTerm,omitempty"`
+	Signature     types.HexBytes                 `json:"signature,omitempty"`
+	SrcNetId      string                         `json:"sourceNetworkId,omitempty"`
+	Status        string                         `json:"status,omitempty"`
+	Timestamp     int32                          `json:"timestamp"`
+	TxIndex       int32                          `json:"txIndex,omitempty"`
+	Type          string                         `json:"type,omitempty"`
+	URI           string                         `json:"uri,omitempty"`
+	Weight        *types.BigInt                  `json:"weight,omitempty"`
+	Weights       []*types.BigInt                `json:"weights,omitempty"`
+	WithResults   bool                           `json:"withResults,omitempty"`
+	VoterAddress  types.HexBytes                 `json:"voterAddress,omitempty"`
 
 	address *common.Address `json:"-"`
 }
@@ -191,22 +192,27 @@ type APIresponse struct {
 	InvalidClaims        []int                            `json:"invalidClaims,omitempty"`
 	InfoURI              string                           `json:"infoURI,omitempty"`
 	Message              string                           `json:"message,omitempty"`
+	NextCursor           string                           `json:"nextCursor,omitempty"`
 	Nonce                *uint32                          `json:"nonce,omitempty"`
 	Nullifier            string                           `json:"nullifier,omitempty"`
 	Nullifiers           *[]string                        `json:"nullifiers,omitempty"`
 	Ok                   bool                             `json:"ok"`
 	OracleList           *[]string                        `json:"oracles,omitempty"`
+	OverwriteCount       *uint32                          `json:"overwriteCount,omitempty"`
 	Paused               *bool                            `json:"paused,omitempty"`
 	Payload              string                           `json:"payload,omitempty"`
 	ProcessSummary       *ProcessSummary                  `json:"processSummary,omitempty"`
 	ProcessID            types.HexBytes                   `json:"processId,omitempty"`
 	ProcessIDs           []string                         `json:"processIds,omitempty"`
 	Process              *indexertypes.Process            `json:"process,omitempty"`
+	ProcessFacets        *indexertypes.ProcessFacets      `json:"processFacets,omitempty"`
 	ProcessList          []string                         `json:"processList,omitempty"`
 	ProcessNonce         *uint32                          `json:"processNonce,omitempty"`
 	Registered           *bool                            `json:"registered,omitempty"`
 	Request              string                           `json:"request"`
 	Results              [][]string                       `json:"results,omitempty"`
+	ResultsCertificate   *indexertypes.ResultsCertificate `json:"resultsCertificate,omitempty"`
+	VoteReceipt          *indexertypes.VoteReceipt        `json:"voteReceipt,omitempty"`
 	Root                 types.HexBytes                   `json:"root,omitempty"`
 	Siblings             types.HexBytes                   `json:"siblings,omitempty"`
 	Size                 *int64                           `json:"size,omitempty"`
@@ -245,12 +251,14 @@ func (r APIresponse) String() string {
 
 type ProcessSummary struct {
 	BlockCount      uint32               `json:"blockCount,omitempty"`
+	EndDate         *time.Time           `json:"endDate,omitempty"`
 	EntityID        string               `json:"entityId,omitempty"`
 	EntityIndex     uint32               `json:"entityIndex,omitempty"`
 	EnvelopeHeight  *uint32              `json:"envelopeHeight,omitempty"`
 	Metadata        string               `json:"metadata,omitempty"`
 	SourceNetworkID string               `json:"sourceNetworkID,omitempty"`
 	StartBlock      uint32               `json:"startBlock,omitempty"`
+	StartDate       *time.Time           `json:"startDate,omitempty"`
 	State           string               `json:"state,omitempty"`
 	EnvelopeType    *models.EnvelopeType `json:"envelopeType,omitempty"`
 }
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/tendermint/tendermint/crypto/ed25519"
 	"github.com/vocdoni/arbo"
 	"go.vocdoni.io/dvote/api"
 	"go.vocdoni.io/dvote/crypto/ethereum"
@@ -75,6 +76,24 @@ func (c *Client) GetEnvelopeStatus(nullifier, pid []byte) (bool, error) {
 	return *resp.Registered, nil
 }
 
+// GetVoteReceipt returns the receipt of a vote registered in the state at
+// height (0 for the latest), which can be verified with VerifyVoteReceipt.
+func (c *Client) GetVoteReceipt(pid, nullifier []byte, height uint32) (*indexertypes.VoteReceipt, error) {
+	var req api.APIrequest
+	req.Method = "getVoteReceipt"
+	req.ProcessID = pid
+	req.Nullifier = nullifier
+	req.Height = height
+	resp, err := c.Request(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Ok || resp.VoteReceipt == nil {
+		return nil, fmt.Errorf("cannot get vote receipt: (%s)", resp.Message)
+	}
+	return resp.VoteReceipt, nil
+}
+
 func (c *Client) GetProof(pubkey, root []byte, digested bool) ([]byte, []byte, error) {
 	var req api.APIrequest
 	req.Method = "genProof"
@@ -93,6 +112,23 @@ func (c *Client) GetProof(pubkey, root []byte, digested bool) ([]byte, []byte, e
 	return resp.Siblings, resp.CensusValue, nil
 }
 
+// GetAccountCensusProof returns the siblings and the account leaf value that
+// prove the account of addr belongs to the vochain accounts census of a process.
+func (c *Client) GetAccountCensusProof(pid []byte, addr common.Address) ([]byte, []byte, error) {
+	var req api.APIrequest
+	req.Method = "getAccountCensusProof"
+	req.ProcessID = pid
+	req.VoterAddress = addr.Bytes()
+	resp, err := c.Request(req, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(resp.Siblings) == 0 || !resp.Ok {
+		return nil, nil, fmt.Errorf("cannot get account census proof: (%s)", resp.Message)
+	}
+	return resp.Siblings, resp.CensusValue, nil
+}
+
 func (c *Client) GetResults(pid []byte) ([][]string, string, bool, error) {
 	var req api.APIrequest
 	req.Method = "getResults"
@@ -110,6 +146,22 @@ func (c *Client) GetResults(pid []byte) ([][]string, string, bool, error) {
 	return resp.Results, resp.State, *resp.Final, nil
 }
 
+// GetResultsCertificate returns the certificate of the final results of a
+// process, which can be verified with scrutinizer.VerifyResultsCertificate.
+func (c *Client) GetResultsCertificate(pid []byte) (*indexertypes.ResultsCertificate, error) {
+	var req api.APIrequest
+	req.Method = "getResultsCertificate"
+	req.ProcessID = pid
+	resp, err := c.Request(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Ok {
+		return nil, fmt.Errorf("cannot get results certificate: (%s)", resp.Message)
+	}
+	return resp.ResultsCertificate, nil
+}
+
 func (c *Client) GetEnvelopeHeight(pid []byte) (uint32, error) {
 	var req api.APIrequest
 	req.Method = "getEnvelopeHeight"
@@ -1454,6 +1506,38 @@ func (c *Client) GetAccount(accountAddr common.Address) (*vochain.Account, error
 	return acc, nil
 }
 
+// SetZkCircuit sends an Add/Remove zk-SNARK circuit transaction for the
+// circuit parameters index.  If circuit is nil the circuit is removed, else it
+// is registered or its verification key replaced.
+func (c *Client) SetZkCircuit(treasurer *ethereum.SignKeys, index uint32,
+	circuit *vochain.ZkCircuit, treasurerNonce uint32) error {
+	tx := &models.AdminTx{
+		Txtype:   vochain.TxTypeAddZkCircuit,
+		Nonce:    treasurerNonce,
+		KeyIndex: &index,
+	}
+	if circuit == nil {
+		tx.Txtype = vochain.TxTypeRemoveZkCircuit
+	} else {
+		vochain.SetAdminTxZkCircuit(tx, circuit)
+	}
+
+	stx := models.SignedTx{}
+	var err error
+	stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_Admin{Admin: tx}})
+	if err != nil {
+		return err
+	}
+	resp, err := c.SubmitRawTx(treasurer, &stx)
+	if err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return fmt.Errorf("submitRawTx failed: %s", resp.Message)
+	}
+	return nil
+}
+
 // GenerateFaucetPackage generates a faucet package
 func (*Client) GenerateFaucetPackage(from *ethereum.SignKeys, to common.Address, value, identifier uint64) (*models.FaucetPackage, error) {
 	return vochain.GenerateFaucetPackage(from, to, value, identifier)
@@ -1621,3 +1705,87 @@ func (c *Client) SetOracle(treasurer *ethereum.SignKeys, oracleAddress common.Ad
 	}
 	return nil
 }
+
+// SetValidator sends an Add/Remove Validator transaction for the tendermint
+// ed25519 public key.  If power is 0 the validator is removed, else it is
+// added or its power updated.
+func (c *Client) SetValidator(treasurer *ethereum.SignKeys, pubKey []byte, power uint64, treasurerNonce uint32) error {
+	tx := &models.AdminTx{
+		Txtype:  models.TxType_ADD_VALIDATOR,
+		Nonce:   treasurerNonce,
+		Address: ed25519.PubKey(pubKey).Address(),
+	}
+	if power == 0 {
+		tx.Txtype = models.TxType_REMOVE_VALIDATOR
+	} else {
+		tx.PublicKey = pubKey
+		tx.Power = &power
+	}
+
+	stx := models.SignedTx{}
+	var err error
+	stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_Admin{Admin: tx}})
+	if err != nil {
+		return err
+	}
+	resp, err := c.SubmitRawTx(treasurer, &stx)
+	if err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return fmt.Errorf("submitRawTx failed: %s", resp.Message)
+	}
+	return nil
+}
+
+// SubmitTreasurerTx sends a treasurer transaction, such as a MintTokensTx or an
+// AdminTx signed by the treasurer.  With the treasurer multisig, the signer
+// must be a multisig signer and the transaction an approved proposal.
+func (c *Client) SubmitTreasurerTx(signer *ethereum.SignKeys, tx *models.Tx) error {
+	stx := models.SignedTx{}
+	var err error
+	stx.Tx, err = proto.Marshal(tx)
+	if err != nil {
+		return err
+	}
+	resp, err := c.SubmitRawTx(signer, &stx)
+	if err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return fmt.Errorf("submitRawTx failed: %s", resp.Message)
+	}
+	return nil
+}
+
+// ProposeTreasurerTx proposes a treasurer transaction to the treasurer
+// multisig, approved by the signer.  It returns the proposal ID.
+func (c *Client) ProposeTreasurerTx(signer *ethereum.SignKeys, tx *models.Tx) ([]byte, error) {
+	id, err := vochain.MultisigProposalID(tx)
+	if err != nil {
+		return nil, err
+	}
+	admin := &models.AdminTx{Txtype: vochain.TxTypeProposeMultisigTx}
+	if err := vochain.SetAdminTxProposal(admin, tx); err != nil {
+		return nil, err
+	}
+	return id, c.SubmitTreasurerTx(signer, &models.Tx{Payload: &models.Tx_Admin{Admin: admin}})
+}
+
+// ApproveTreasurerTx approves the proposal of a treasurer transaction to the
+// treasurer multisig.  It returns the proposal ID.
+func (c *Client) ApproveTreasurerTx(signer *ethereum.SignKeys, tx *models.Tx) ([]byte, error) {
+	id, err := vochain.MultisigProposalID(tx)
+	if err != nil {
+		return nil, err
+	}
+	return id, c.ApproveMultisigProposal(signer, id)
+}
+
+// ApproveMultisigProposal approves the pending multisig proposal identified
+// by id, such as an oracle transaction proposed to the oracle multisig.
+func (c *Client) ApproveMultisigProposal(signer *ethereum.SignKeys, id []byte) error {
+	admin := &models.AdminTx{Txtype: vochain.TxTypeApproveMultisigTx}
+	vochain.SetAdminTxProposalID(admin, id)
+	return c.SubmitTreasurerTx(signer, &models.Tx{Payload: &models.Tx_Admin{Admin: admin}})
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// VerifyVoteReceipt checks a vote receipt end to end without network access.
+// The block header must be committed by +2/3 of the voting power of the
+// validator set, the state proof must match the header AppHash, and the vote
+// leaf of the votes tree of the process must hold the hash of the receipt vote,
+// which must match the envelope.  The verified vote is returned.  The
+// validator set comes with the receipt, so its hash must be the trusted
+// validatorsHash.
+func VerifyVoteReceipt(receipt *indexertypes.VoteReceipt, validatorsHash []byte) (*models.Vote, error) {
+	header, err := vochain.VerifySignedHeader(receipt.ChainID, int64(receipt.StateHeight)+1,
+		receipt.SignedHeader, receipt.Validators, validatorsHash)
+	if err != nil {
+		return nil, err
+	}
+	leafs, err := vochain.VerifyStateProof(receipt.StateProof, header.AppHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state proof: %w", err)
+	}
+	vid, err := vochain.VoteID(receipt.ProcessID, receipt.Nullifier)
+	if err != nil {
+		return nil, err
+	}
+	var sdbVote *models.StateDBVote
+	for _, leaf := range leafs {
+		if leaf.Tree == vochain.ChildTreeVotes && bytes.Equal(leaf.ParentKey, receipt.ProcessID) &&
+			bytes.Equal(leaf.Key, vid) {
+			sdbVote = &models.StateDBVote{}
+			if err := proto.Unmarshal(leaf.Value, sdbVote); err != nil {
+				return nil, fmt.Errorf("cannot decode vote leaf: %w", err)
+			}
+		}
+	}
+	if sdbVote == nil {
+		return nil, fmt.Errorf("vote %x not found in the state proof", receipt.Nullifier)
+	}
+	if !bytes.Equal(sdbVote.VoteHash, ethereum.HashRaw(receipt.Vote)) {
+		return nil, fmt.Errorf("vote hash does not match the registered one")
+	}
+	vote := &models.Vote{}
+	if err := proto.Unmarshal(receipt.Vote, vote); err != nil {
+		return nil, fmt.Errorf("cannot decode vote: %w", err)
+	}
+	if !bytes.Equal(vote.ProcessId, receipt.ProcessID) || !bytes.Equal(vote.Nullifier, receipt.Nullifier) {
+		return nil, fmt.Errorf("vote does not match the receipt process and nullifier")
+	}
+
+	stx := &models.SignedTx{}
+	if err := proto.Unmarshal(receipt.Envelope, stx); err != nil {
+		return nil, fmt.Errorf("cannot decode envelope: %w", err)
+	}
+	tx := &models.Tx{}
+	if err := proto.Unmarshal(stx.Tx, tx); err != nil {
+		return nil, fmt.Errorf("cannot decode envelope: %w", err)
+	}
+	envelope := tx.GetVote()
+	if envelope == nil {
+		return nil, fmt.Errorf("envelope is not a vote transaction")
+	}
+	// the nullifier of the signed votes is derived from the signer address,
+	// so the envelope one may be empty
+	if !bytes.Equal(envelope.ProcessId, vote.ProcessId) ||
+		!bytes.Equal(envelope.VotePackage, vote.VotePackage) ||
+		(len(envelope.Nullifier) > 0 && !bytes.Equal(envelope.Nullifier, vote.Nullifier)) {
+		return nil, fmt.Errorf("envelope does not match the registered vote")
+	}
+	return vote, nil
+}
@@ -10,6 +10,7 @@ import (
 	"os/signal"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -36,9 +37,11 @@ import (
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/urlapi"
 	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/eventstream"
 	"go.vocdoni.io/dvote/vochain/keykeeper"
 	"go.vocdoni.io/dvote/vochain/scrutinizer"
 	"go.vocdoni.io/dvote/vochain/vochaininfo"
+	"go.vocdoni.io/dvote/vochain/webhooks"
 )
 
 func newConfig() (*config.DvoteCfg, config.Error) {
@@ -92,6 +95,35 @@ func newConfig() (*config.DvoteCfg, config.Error) {
 	globalCfg.API.Indexer = *flag.Bool("indexerApi", false,
 		"enable the indexer API (required for explorer)")
 	globalCfg.API.URL = *flag.Bool("urlApi", true, "enable the url API")
+	globalCfg.API.Webhooks = *flag.Bool("apiWebhooks", false,
+		"enable the election event webhooks on the url API")
+	globalCfg.API.AdminToken = *flag.String("apiAdminToken", "",
+		"bearer token allowed to issue, list and revoke URL API auth tokens")
+	globalCfg.API.RequireTokens = *flag.Bool("apiRequireTokens", false,
+		"require an issued auth token for the URL API census write, election creation and wallet methods")
+	globalCfg.API.RateLimits = *flag.StringSlice("apiRateLimits", []string{},
+		"comma-separated API rate limits with the format [method:]rate:burst[:ip|token|signer], "+
+			"rate being requests per second (e.g. 10:50,submitRawTx:0.5:5:signer); the signer limits "+
+			"apply per IP address too. Behind a reverse proxy, set apiTrustedProxies, "+
+			"otherwise all the clients share the proxy IP address limits")
+	globalCfg.API.NamespaceRateLimits = *flag.StringSlice("apiNamespaceRateLimits", []string{},
+		"comma-separated API rate limits applied to a single namespace (rpcAPI or bearerStd) instead of "+
+			"apiRateLimits, with the format namespace=[method:]rate:burst[:ip|token|signer]")
+	globalCfg.API.TrustedProxies = *flag.StringSlice("apiTrustedProxies", []string{},
+		"comma-separated networks of the reverse proxies in front of the API (e.g. 10.0.0.0/8), "+
+			"whose X-Forwarded-For header identifies the client IP address. The proxies must append "+
+			"the client address to X-Forwarded-For, and only trusted proxies should be listed, "+
+			"since anyone else could forge the header")
+	globalCfg.API.MaxRequestSize = *flag.Int64("apiMaxRequestSize", 0,
+		"maximum size of an API request body in bytes (0 means no limit)")
+	globalCfg.API.MaxConcurrentRequests = *flag.Int("apiMaxConcurrentRequests", 0,
+		"maximum number of API requests handled at the same time (0 means no limit)")
+	globalCfg.API.EventsRetention = *flag.Uint32("apiEventsRetention", eventstream.DefaultRetention,
+		"number of blocks kept in the url API event stream (0 means all the blocks)")
+	globalCfg.API.EventsMaxSubscribers = *flag.Int("apiEventsMaxSubscribers", urlapi.DefaultEventsMaxSubscribers,
+		"maximum number of url API event stream subscribers")
+	globalCfg.API.WebhooksMaxPerOwner = *flag.Int("apiWebhooksMaxPerOwner", webhooks.DefaultMaxPerOwner,
+		"maximum number of webhooks registered with an auth token (0 means no limit)")
 	globalCfg.API.Route = *flag.String("apiRoute", "/",
 		"dvote HTTP API base route")
 	globalCfg.API.AllowPrivate = *flag.Bool("apiAllowPrivate", false,
@@ -120,6 +152,18 @@ func newConfig() (*config.DvoteCfg, config.Error) {
 		"enable IPFS cluster synchronization using the given secret key")
 	globalCfg.Ipfs.SyncPeers = *flag.StringSlice("ipfsSyncPeers", []string{},
 		"use custom ipfsSync peers/bootnodes for accessing the DHT (comma-separated)")
+	globalCfg.Ipfs.Backend = *flag.String("storageBackend", "IPFS",
+		"file storage backend: IPFS, FS (local filesystem, no IPFS node) or S3 (S3 compatible object storage)")
+	globalCfg.Ipfs.S3Endpoint = *flag.String("s3Endpoint", "",
+		"S3 compatible object storage URL, used by the S3 storage backend (e.g. http://127.0.0.1:9000)")
+	globalCfg.Ipfs.S3Bucket = *flag.String("s3Bucket", "vocdoni",
+		"S3 bucket where the files are stored")
+	globalCfg.Ipfs.S3Region = *flag.String("s3Region", "us-east-1",
+		"S3 region used to sign the requests")
+	globalCfg.Ipfs.S3AccessKey = *flag.String("s3AccessKey", "",
+		"S3 access key")
+	globalCfg.Ipfs.S3SecretKey = *flag.String("s3SecretKey", "",
+		"S3 secret key")
 	// vochain
 	globalCfg.VochainConfig.P2PListen = *flag.String("vochainP2PListen", "0.0.0.0:26656",
 		"p2p host and port to listent for the voting chain")
@@ -151,6 +195,10 @@ func newConfig() (*config.DvoteCfg, config.Error) {
 		"vohain consensus block time target (in seconds)")
 	globalCfg.VochainConfig.KeyKeeperIndex = *flag.Int8("keyKeeperIndex", 0,
 		"index slot used by this node if it is a key keeper")
+	globalCfg.VochainConfig.KeyKeeperThreshold = *flag.Int("keyKeeperThreshold", 0,
+		"if greater than zero, enables the threshold key mode requiring this number of key keepers to reveal a key")
+	globalCfg.VochainConfig.KeyKeeperPeers = *flag.StringSlice("keyKeeperPeers", []string{},
+		"comma-separated list of key keepers participating on the threshold key mode, as index:sharePublicKey")
 	globalCfg.VochainConfig.ImportPreviousCensus = *flag.Bool("importPreviousCensus", false,
 		"if enabled the census downloader will import all existing census")
 	globalCfg.VochainConfig.EthereumWhiteListAddrs = *flag.StringSlice("ethereumWhiteListAddrs",
@@ -160,6 +208,22 @@ func newConfig() (*config.DvoteCfg, config.Error) {
 		"enables the process archiver component")
 	globalCfg.VochainConfig.ProcessArchiveKey = *flag.String("processArchiveKey", "",
 		"IPFS base64 encoded private key for process archive IPNS")
+	globalCfg.VochainConfig.SnapshotInterval = *flag.Int("vochainSnapshotInterval", 50000,
+		"number of blocks between vochain state snapshots (0 disables them)")
+	globalCfg.VochainConfig.TxIndexer = *flag.Bool("vochainTxIndexer", false,
+		"enables the tendermint transaction indexer, to search the transactions by their events")
+	globalCfg.VochainConfig.StateSync.Enabled = *flag.Bool("vochainStateSync", false,
+		"bootstrap the vochain from a state snapshot served by other peers")
+	globalCfg.VochainConfig.StateSync.RPCServers = *flag.StringSlice("vochainStateSyncRPCServers",
+		[]string{}, "comma-separated list of tendermint RPC servers used to verify the state snapshot")
+	globalCfg.VochainConfig.StateSync.TrustHeight = *flag.Int64("vochainStateSyncTrustHeight", 0,
+		"trusted block height for the state sync light client verification")
+	globalCfg.VochainConfig.StateSync.TrustHash = *flag.String("vochainStateSyncTrustHash", "",
+		"hex encoded block hash at the trusted height for state sync")
+	globalCfg.VochainConfig.StatePruning.KeepRecent = *flag.Int("vochainPruneKeepRecent", 0,
+		"number of most recent vochain state versions to keep (0 disables pruning)")
+	globalCfg.VochainConfig.StatePruning.KeepEvery = *flag.Int("vochainPruneKeepEvery", 0,
+		"keep also every vochain state version multiple of this value when pruning")
 
 	// metrics
 	globalCfg.Metrics.Enabled = *flag.Bool("metricsEnabled", false, "enable prometheus metrics")
@@ -209,6 +273,17 @@ func newConfig() (*config.DvoteCfg, config.Error) {
 	viper.BindPFlag("api.Results", flag.Lookup("resultsApi"))
 	viper.BindPFlag("api.Indexer", flag.Lookup("indexerApi"))
 	viper.BindPFlag("api.Url", flag.Lookup("urlApi"))
+	viper.BindPFlag("api.Webhooks", flag.Lookup("apiWebhooks"))
+	viper.BindPFlag("api.AdminToken", flag.Lookup("apiAdminToken"))
+	viper.BindPFlag("api.RequireTokens", flag.Lookup("apiRequireTokens"))
+	viper.BindPFlag("api.RateLimits", flag.Lookup("apiRateLimits"))
+	viper.BindPFlag("api.NamespaceRateLimits", flag.Lookup("apiNamespaceRateLimits"))
+	viper.BindPFlag("api.TrustedProxies", flag.Lookup("apiTrustedProxies"))
+	viper.BindPFlag("api.MaxRequestSize", flag.Lookup("apiMaxRequestSize"))
+	viper.BindPFlag("api.MaxConcurrentRequests", flag.Lookup("apiMaxConcurrentRequests"))
+	viper.BindPFlag("api.EventsRetention", flag.Lookup("apiEventsRetention"))
+	viper.BindPFlag("api.EventsMaxSubscribers", flag.Lookup("apiEventsMaxSubscribers"))
+	viper.BindPFlag("api.WebhooksMaxPerOwner", flag.Lookup("apiWebhooksMaxPerOwner"))
 	viper.BindPFlag("api.Route", flag.Lookup("apiRoute"))
 	viper.BindPFlag("api.AllowPrivate", flag.Lookup("apiAllowPrivate"))
 	viper.BindPFlag("api.AllowedAddrs", flag.Lookup("apiAllowedAddrs"))
@@ -229,6 +304,12 @@ func newConfig() (*config.DvoteCfg, config.Error) {
 	viper.BindPFlag("ipfs.NoInit", flag.Lookup("ipfsNoInit"))
 	viper.BindPFlag("ipfs.SyncKey", flag.Lookup("ipfsSyncKey"))
 	viper.BindPFlag("ipfs.SyncPeers", flag.Lookup("ipfsSyncPeers"))
+	viper.BindPFlag("ipfs.Backend", flag.Lookup("storageBackend"))
+	viper.BindPFlag("ipfs.S3Endpoint", flag.Lookup("s3Endpoint"))
+	viper.BindPFlag("ipfs.S3Bucket", flag.Lookup("s3Bucket"))
+	viper.BindPFlag("ipfs.S3Region", flag.Lookup("s3Region"))
+	viper.BindPFlag("ipfs.S3AccessKey", flag.Lookup("s3AccessKey"))
+	viper.BindPFlag("ipfs.S3SecretKey", flag.Lookup("s3SecretKey"))
 
 	// vochain
 	viper.Set("vochainConfig.DataDir", globalCfg.DataDir+"/vochain")
@@ -248,11 +329,21 @@ func newConfig() (*config.DvoteCfg, config.Error) {
 	viper.BindPFlag("vochainConfig.MempoolSize", flag.Lookup("vochainMempoolSize"))
 	viper.BindPFlag("vochainConfig.MinerTargetBlockTimeSeconds", flag.Lookup("vochainBlockTime"))
 	viper.BindPFlag("vochainConfig.KeyKeeperIndex", flag.Lookup("keyKeeperIndex"))
+	viper.BindPFlag("vochainConfig.KeyKeeperThreshold", flag.Lookup("keyKeeperThreshold"))
+	viper.BindPFlag("vochainConfig.KeyKeeperPeers", flag.Lookup("keyKeeperPeers"))
 	viper.BindPFlag("vochainConfig.ImportPreviousCensus", flag.Lookup("importPreviousCensus"))
 	viper.BindPFlag("vochainConfig.EthereumWhiteListAddrs", flag.Lookup("ethereumWhiteListAddrs"))
 	viper.Set("vochainConfig.ProcessArchiveDataDir", globalCfg.DataDir+"/archive")
 	viper.BindPFlag("vochainConfig.ProcessArchive", flag.Lookup("processArchive"))
 	viper.BindPFlag("vochainConfig.ProcessArchiveKey", flag.Lookup("processArchiveKey"))
+	viper.BindPFlag("vochainConfig.SnapshotInterval", flag.Lookup("vochainSnapshotInterval"))
+	viper.BindPFlag("vochainConfig.TxIndexer", flag.Lookup("vochainTxIndexer"))
+	viper.BindPFlag("vochainConfig.StateSync.Enabled", flag.Lookup("vochainStateSync"))
+	viper.BindPFlag("vochainConfig.StateSync.RPCServers", flag.Lookup("vochainStateSyncRPCServers"))
+	viper.BindPFlag("vochainConfig.StateSync.TrustHeight", flag.Lookup("vochainStateSyncTrustHeight"))
+	viper.BindPFlag("vochainConfig.StateSync.TrustHash", flag.Lookup("vochainStateSyncTrustHash"))
+	viper.BindPFlag("vochainConfig.StatePruning.KeepRecent", flag.Lookup("vochainPruneKeepRecent"))
+	viper.BindPFlag("vochainConfig.StatePruning.KeepEvery", flag.Lookup("vochainPruneKeepEvery"))
 
 	// metrics
 	viper.BindPFlag("metrics.Enabled", flag.Lookup("metricsEnabled"))
@@ -426,6 +517,7 @@ func main() {
 	var vochainKeykeeper *keykeeper.KeyKeeper
 	var vochainOracle *oracle.Oracle
 	var metricsAgent *metrics.Agent
+	var uAPI *urlapi.URLAPI
 
 	if globalCfg.Dev {
 		log.Warn("developer mode is enabled!")
@@ -466,6 +558,41 @@ func main() {
 		if err = httpRouter.Init(globalCfg.API.ListenHost, globalCfg.API.ListenPort); err != nil {
 			log.Fatal(err)
 		}
+		if err := httpRouter.SetTrustedProxies(globalCfg.API.TrustedProxies); err != nil {
+			log.Fatal(err)
+		}
+		// Apply the abuse protection limits to all the API namespaces,
+		// the namespaces with their own rate limits replace the common ones
+		if len(globalCfg.API.RateLimits) > 0 || len(globalCfg.API.NamespaceRateLimits) > 0 ||
+			globalCfg.API.MaxRequestSize > 0 || globalCfg.API.MaxConcurrentRequests > 0 {
+			newLimits := func() *httprouter.NamespaceLimits {
+				return &httprouter.NamespaceLimits{
+					MaxRequestSize:        globalCfg.API.MaxRequestSize,
+					MaxConcurrentRequests: globalCfg.API.MaxConcurrentRequests,
+				}
+			}
+			limits := map[string]*httprouter.NamespaceLimits{"": newLimits()}
+			for _, l := range globalCfg.API.RateLimits {
+				limit, err := httprouter.ParseRateLimit(l)
+				if err != nil {
+					log.Fatal(err)
+				}
+				limits[""].RateLimits = append(limits[""].RateLimits, limit)
+			}
+			for _, l := range globalCfg.API.NamespaceRateLimits {
+				namespaceID, limit, err := httprouter.ParseNamespaceRateLimit(l)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if limits[namespaceID] == nil {
+					limits[namespaceID] = newLimits()
+				}
+				limits[namespaceID].RateLimits = append(limits[namespaceID].RateLimits, limit)
+			}
+			for namespaceID, nsLimits := range limits {
+				httpRouter.SetNamespaceLimits(namespaceID, nsLimits)
+			}
+		}
 		// Initialize the RPC API
 		if rpc, err = rpcapi.NewAPI(signer, &httpRouter, globalCfg.API.Route+"dvote", metricsAgent, globalCfg.API.AllowPrivate); err != nil {
 			log.Fatal(err)
@@ -562,6 +689,22 @@ func main() {
 				if err != nil {
 					log.Fatal(err)
 				}
+				log.Infof("keykeeper share public key: %s", vochainKeykeeper.SharePublicKey())
+				if globalCfg.VochainConfig.KeyKeeperThreshold > 0 {
+					peers := make(map[int8]string)
+					for _, peer := range globalCfg.VochainConfig.KeyKeeperPeers {
+						index, key, found := strings.Cut(peer, ":")
+						i, err := strconv.ParseInt(index, 10, 8)
+						if !found || err != nil {
+							log.Fatalf("invalid keykeeper peer %q", peer)
+						}
+						peers[int8(i)] = key
+					}
+					if err := vochainKeykeeper.SetThreshold(
+						globalCfg.VochainConfig.KeyKeeperThreshold, peers); err != nil {
+						log.Fatal(err)
+					}
+				}
 				go vochainKeykeeper.RevealUnpublished()
 			}
 
@@ -647,11 +790,31 @@ func main() {
 		}
 		if globalCfg.API.URL {
 			log.Info("enabling URL API")
-			uAPI, err := urlapi.NewURLAPI(&httpRouter, "/v2", globalCfg.DataDir)
+			uAPI, err = urlapi.NewURLAPI(&httpRouter, "/v2", globalCfg.DataDir)
 			if err != nil {
 				log.Fatal(err)
 			}
 			uAPI.Attach(vochainApp, vochainInfo, scrutinizer, storage)
+			eventStream, err := eventstream.NewEventStream(
+				path.Join(globalCfg.VochainConfig.DataDir, "eventstream"), vochainApp)
+			if err != nil {
+				log.Fatal(err)
+			}
+			eventStream.SetRetention(globalCfg.API.EventsRetention)
+			uAPI.AttachEventStream(eventStream)
+			uAPI.SetEventsMaxSubscribers(globalCfg.API.EventsMaxSubscribers)
+			if globalCfg.API.RequireTokens {
+				if globalCfg.API.AdminToken == "" {
+					log.Warn("URL API auth tokens are required but no admin token is set to issue them")
+				}
+				uAPI.RequireAuthTokens()
+			}
+			if globalCfg.API.AdminToken != "" {
+				uAPI.SetAdminToken(globalCfg.API.AdminToken)
+				if err := uAPI.EnableHandlers(urlapi.TokensHandler); err != nil {
+					log.Fatal(err)
+				}
+			}
 			if err := uAPI.EnableHandlers(
 				urlapi.ElectionHandler,
 				urlapi.VoteHandler,
@@ -659,9 +822,28 @@ func main() {
 				urlapi.WalletHandler,
 				urlapi.AccountHandler,
 				urlapi.CensusHandler,
+				urlapi.EventsHandler,
 			); err != nil {
 				log.Fatal(err)
 			}
+			if globalCfg.API.Webhooks {
+				log.Info("enabling webhooks")
+				if globalCfg.API.AdminToken == "" {
+					log.Warn("webhooks require an auth token but no admin token is set to issue them")
+				}
+				wh, err := webhooks.NewWebhooks(
+					path.Join(globalCfg.VochainConfig.DataDir, "webhooks"),
+					signer, vochainApp, scrutinizer)
+				if err != nil {
+					log.Fatal(err)
+				}
+				wh.MaxPerOwner = globalCfg.API.WebhooksMaxPerOwner
+				wh.Start()
+				uAPI.AttachWebhooks(wh)
+				if err := uAPI.EnableHandlers(urlapi.WebhooksHandler); err != nil {
+					log.Fatal(err)
+				}
+			}
 		}
 	}
 
@@ -672,6 +854,11 @@ func main() {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
 	log.Warnf("received SIGTERM, exiting at %s", time.Now().Format(time.RFC850))
+	if uAPI != nil {
+		if err := uAPI.CloseTokenStore(); err != nil {
+			log.Warnf("cannot store auth tokens: %v", err)
+		}
+	}
 	os.Exit(0)
 }
 
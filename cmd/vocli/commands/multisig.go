@@ -0,0 +1,209 @@
+package commands
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+	"go.vocdoni.io/dvote/client"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+const multisigOperations = `Operations:
+	mint <recipient> <amount>
+	txcost <txtype> <cost>
+	oracle <address> <op>                 (op=0 -> DEL, op=1 -> ADD)
+	multisig <threshold> [signers...]     (threshold 0 without signers removes the multisig)
+	oracle-multisig <threshold> [signers...]
+
+The treasurer nonce of the operation is the current one, unless --nonce is given.`
+
+var multisigCmd = &cobra.Command{
+	Use:   "multisig",
+	Short: "Propose, approve and execute treasurer operations governed by a multisig",
+}
+
+var multisigProposeCmd = &cobra.Command{
+	Use:   "propose <signer keystore> <operation> [args...]",
+	Short: "Propose a treasurer operation to the multisig signers, approved by the proposer",
+	Long:  "Propose a treasurer operation to the multisig signers, approved by the proposer.\n\n" + multisigOperations,
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMultisig(cmd, args, func(c *client.Client, signer *ethereum.SignKeys, tx *models.Tx) error {
+			id, err := c.ProposeTreasurerTx(signer, tx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(Stdout, "proposal %x\n", id)
+			return nil
+		})
+	},
+}
+
+var multisigSignCmd = &cobra.Command{
+	Use:   "sign <signer keystore> <operation> [args...]",
+	Short: "Co-sign a treasurer operation proposed to the multisig signers",
+	Long:  "Co-sign a treasurer operation proposed to the multisig signers.\n\n" + multisigOperations,
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMultisig(cmd, args, func(c *client.Client, signer *ethereum.SignKeys, tx *models.Tx) error {
+			id, err := c.ApproveTreasurerTx(signer, tx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(Stdout, "approved proposal %x\n", id)
+			return nil
+		})
+	},
+}
+
+var multisigApproveCmd = &cobra.Command{
+	Use:   "approve <signer keystore> <proposal id>",
+	Short: "Approve an oracle transaction proposed to the oracle multisig signers",
+	Long: "Approve an oracle transaction proposed to the oracle multisig signers.\n" +
+		"The transaction is executed once approved by the multisig threshold.",
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := client.New(v.GetString(urlKey))
+		if err != nil {
+			return err
+		}
+		id, err := hex.DecodeString(util.TrimHex(args[1]))
+		if err != nil {
+			return fmt.Errorf("invalid proposal id: %w", err)
+		}
+		_, signer, err := openKeyfile(args[0], "Please unlock your key: ")
+		if err != nil {
+			return fmt.Errorf("could not open keyfile %s", err)
+		}
+		if err := c.ApproveMultisigProposal(signer, id); err != nil {
+			return err
+		}
+		fmt.Fprintf(Stdout, "approved proposal %x\n", id)
+		return nil
+	},
+}
+
+var multisigExecuteCmd = &cobra.Command{
+	Use:   "execute <signer keystore> <operation> [args...]",
+	Short: "Execute a treasurer operation approved by the multisig threshold",
+	Long: "Execute a treasurer operation approved by the multisig threshold.\n" +
+		"Without multisig, the treasurer executes the operation directly.\n\n" + multisigOperations,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMultisig(cmd, args, func(c *client.Client, signer *ethereum.SignKeys, tx *models.Tx) error {
+			return c.SubmitTreasurerTx(signer, tx)
+		})
+	},
+}
+
+// runMultisig builds the treasurer transaction of the operation and calls fn
+// with the unlocked signer key.
+func runMultisig(cmd *cobra.Command, args []string,
+	fn func(c *client.Client, signer *ethereum.SignKeys, tx *models.Tx) error) error {
+	c, err := client.New(v.GetString(urlKey))
+	if err != nil {
+		return err
+	}
+	treasurerNonce := nonce
+	if !cmd.Flags().Changed("nonce") {
+		if treasurerNonce, err = getTreasurerNonce(c); err != nil {
+			return err
+		}
+	}
+	tx, err := treasurerTx(args[1], args[2:], treasurerNonce)
+	if err != nil {
+		return err
+	}
+	_, signer, err := openKeyfile(args[0], "Please unlock your key: ")
+	if err != nil {
+		return fmt.Errorf("could not open keyfile %s", err)
+	}
+	return fn(c, signer, tx)
+}
+
+// treasurerTx builds the treasurer transaction of an operation
+func treasurerTx(op string, args []string, treasurerNonce uint32) (*models.Tx, error) {
+	switch op {
+	case "mint":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("mint requires <recipient> <amount>")
+		}
+		amount, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("sorry, what amount did you say again? %s", err)
+		}
+		return &models.Tx{Payload: &models.Tx_MintTokens{MintTokens: &models.MintTokensTx{
+			Txtype: models.TxType_MINT_TOKENS,
+			Nonce:  treasurerNonce,
+			To:     common.HexToAddress(args[0]).Bytes(),
+			Value:  amount,
+		}}}, nil
+	case "txcost":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("txcost requires <txtype> <cost>")
+		}
+		txType := vochain.TxCostNameToTxType(args[0])
+		if txType == models.TxType_TX_UNKNOWN {
+			return nil, fmt.Errorf("%s is not a valid TxType; valid TxTypes are %v", args[0], txTypes)
+		}
+		cost, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &models.Tx{Payload: &models.Tx_SetTransactionCosts{
+			SetTransactionCosts: &models.SetTransactionCostsTx{
+				Txtype: txType,
+				Nonce:  treasurerNonce,
+				Value:  cost,
+			}}}, nil
+	case "oracle":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("oracle requires <address> <op>")
+		}
+		add, err := strconv.ParseBool(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid operation, cannot parse bool")
+		}
+		tx := &models.AdminTx{
+			Txtype:  models.TxType_ADD_ORACLE,
+			Nonce:   treasurerNonce,
+			Address: common.HexToAddress(args[0]).Bytes(),
+		}
+		if !add {
+			tx.Txtype = models.TxType_REMOVE_ORACLE
+		}
+		return &models.Tx{Payload: &models.Tx_Admin{Admin: tx}}, nil
+	case "multisig", "oracle-multisig":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("%s requires <threshold> [signers...]", op)
+		}
+		threshold, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold: %s", err)
+		}
+		multisig := &vochain.Multisig{Threshold: uint32(threshold)}
+		for _, signer := range args[1:] {
+			if !common.IsHexAddress(signer) {
+				return nil, fmt.Errorf("invalid signer address %s", signer)
+			}
+			multisig.Signers = append(multisig.Signers, common.HexToAddress(signer))
+		}
+		if err := multisig.Check(); err != nil {
+			return nil, err
+		}
+		tx := &models.AdminTx{Txtype: vochain.TxTypeSetTreasurerMultisig, Nonce: treasurerNonce}
+		if op == "oracle-multisig" {
+			tx.Txtype = vochain.TxTypeSetOracleMultisig
+		}
+		vochain.SetAdminTxMultisig(tx, multisig)
+		return &models.Tx{Payload: &models.Tx_Admin{Admin: tx}}, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op)
+	}
+}
@@ -2,10 +2,16 @@ package commands
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
 	"go.vocdoni.io/dvote/client"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/scrutinizer"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
 	"go.vocdoni.io/proto/build/go/models"
 )
 
@@ -42,3 +48,74 @@ var setProcessCmd = &cobra.Command{
 		return c.SetProcessStatus(key, pid, args[2])
 	},
 }
+
+var processCertificateCmd = &cobra.Command{
+	Use:   "certificate <process id> [output file]",
+	Short: "Get the results certificate of a finished process, which can be checked offline with verify-results",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pid, err := hex.DecodeString(util.TrimHex(args[0]))
+		if err != nil {
+			return fmt.Errorf("could not decode hexstring %s into bytes", args[0])
+		}
+		c, err := client.New(v.GetString(urlKey))
+		if err != nil {
+			return err
+		}
+		cert, err := c.GetResultsCertificate(pid)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(cert, "", "  ")
+		if err != nil {
+			return err
+		}
+		if len(args) == 1 {
+			fmt.Fprintln(Stdout, string(data))
+			return nil
+		}
+		return os.WriteFile(args[1], data, 0o644)
+	},
+}
+
+var verifyResultsCmd = &cobra.Command{
+	Use:   "verify-results <certificate file> <validators hash>",
+	Short: "Verify a results certificate without network access",
+	Long: `Verify a results certificate without network access.
+	The certificate is signed by the validator set it includes, so its hash
+	must be the hex encoded validators hash obtained from a trusted source.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		validatorsHash, err := hex.DecodeString(util.TrimHex(args[1]))
+		if err != nil {
+			return fmt.Errorf("could not decode validators hash %s", args[1])
+		}
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		cert := &indexertypes.ResultsCertificate{}
+		if err := json.Unmarshal(data, cert); err != nil {
+			return fmt.Errorf("cannot decode certificate: %w", err)
+		}
+		if err := scrutinizer.VerifyResultsCertificate(cert, validatorsHash); err != nil {
+			return fmt.Errorf("invalid certificate: %w", err)
+		}
+		var header tmproto.SignedHeader
+		if err := header.Unmarshal(cert.SignedHeader); err != nil {
+			return err
+		}
+		fmt.Fprintf(Stdout, "valid results certificate\n")
+		fmt.Fprintf(Stdout, "chain id:        %s\n", cert.ChainID)
+		fmt.Fprintf(Stdout, "process id:      %x\n", cert.ProcessID)
+		fmt.Fprintf(Stdout, "block height:    %d\n", header.Header.Height)
+		fmt.Fprintf(Stdout, "validators hash: %X\n", header.Header.ValidatorsHash)
+		fmt.Fprintf(Stdout, "votes root:      %x\n", cert.VotesRoot)
+		fmt.Fprintf(Stdout, "vote count:      %d\n", cert.VoteCount)
+		for _, s := range cert.OracleSignatures {
+			fmt.Fprintf(Stdout, "oracle:          0x%x\n", s.Oracle)
+		}
+		fmt.Fprintf(Stdout, "results:         %v\n", scrutinizer.GetFriendlyResults(cert.Results.Votes))
+		return nil
+	},
+}
@@ -71,7 +71,9 @@ func init() {
 	RootCmd.AddCommand(keysCmd)
 	RootCmd.AddCommand(txCostCmd)
 	RootCmd.AddCommand(adminCmd)
+	RootCmd.AddCommand(multisigCmd)
 	RootCmd.AddCommand(processCmd)
+	RootCmd.AddCommand(verifyResultsCmd)
 	accCmd.AddCommand(accInfoCmd)
 	accCmd.AddCommand(accSetInfoCmd)
 	accCmd.AddCommand(accTreasurerCmd)
@@ -85,7 +87,12 @@ func init() {
 	txCostCmd.AddCommand(txCostGetCmd)
 	txCostCmd.AddCommand(txCostSetCmd)
 	adminCmd.AddCommand(setOracleCmd)
+	multisigCmd.AddCommand(multisigProposeCmd)
+	multisigCmd.AddCommand(multisigSignCmd)
+	multisigCmd.AddCommand(multisigApproveCmd)
+	multisigCmd.AddCommand(multisigExecuteCmd)
 	processCmd.AddCommand(setProcessCmd)
+	processCmd.AddCommand(processCertificateCmd)
 
 	keysNewCmd.Flags().StringVar(&faucetHex, "faucet", "", `specify an optional hex-encoded faucet payload to immediately top up
 	the new account with tokens`)
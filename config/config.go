@@ -105,6 +105,37 @@ type API struct {
 	}
 	// Enable HTTP API
 	HTTP bool
+	// Webhooks enables the delivery of the election events to the webhooks
+	// registered on the URL API
+	Webhooks bool
+	// WebhooksMaxPerOwner caps the webhooks registered with an auth token
+	WebhooksMaxPerOwner int
+	// AdminToken is the bearer token allowed to issue, list and revoke the
+	// URL API auth tokens
+	AdminToken string
+	// RequireTokens makes the URL API census write, election creation and
+	// wallet methods require an auth token issued by the admin
+	RequireTokens bool
+	// RateLimits are the token bucket limits applied to the API requests,
+	// with the format [method:]rate:burst[:ip|token|signer]
+	RateLimits []string
+	// NamespaceRateLimits are the rate limits applied to a single API
+	// namespace instead of RateLimits, with the format
+	// namespace=[method:]rate:burst[:ip|token|signer]
+	NamespaceRateLimits []string
+	// TrustedProxies are the networks of the reverse proxies in front of the
+	// API, whose X-Forwarded-For header identifies the client IP address.
+	// Without them, the clients behind a proxy share its IP address limits.
+	TrustedProxies []string
+	// MaxRequestSize is the maximum size of an API request body in bytes
+	MaxRequestSize int64
+	// MaxConcurrentRequests caps the API requests handled at the same time
+	MaxConcurrentRequests int
+	// EventsRetention is the number of blocks kept in the URL API event
+	// stream, 0 keeps all the blocks
+	EventsRetention uint32
+	// EventsMaxSubscribers caps the URL API event stream subscribers
+	EventsMaxSubscribers int
 }
 
 // IPFSCfg includes all possible config params needed by IPFS
@@ -116,6 +147,18 @@ type IPFSCfg struct {
 	NoInit    bool
 	SyncKey   string
 	SyncPeers []string
+	// Backend is the storage implementation: IPFS, FS (local filesystem) or
+	// S3 (S3 compatible object storage)
+	Backend string
+	// S3Endpoint is the URL of the S3 compatible object storage
+	S3Endpoint string
+	// S3Bucket is the bucket where the files are stored
+	S3Bucket string
+	// S3Region is the region used to sign the S3 requests
+	S3Region string
+	// S3AccessKey and S3SecretKey are the S3 credentials
+	S3AccessKey string
+	S3SecretKey string
 }
 
 // EthCfg stores global configs for ethereum bockchain
@@ -175,11 +218,20 @@ type VochainCfg struct {
 	MempoolSize int
 	// KeyKeeperIndex is the index used by the key keeper (usually and oracle)
 	KeyKeeperIndex int8
+	// KeyKeeperThreshold if greater than zero enables the threshold (t-of-n)
+	// key mode, being the number of key keepers needed to recover a key
+	KeyKeeperThreshold int
+	// KeyKeeperPeers is the list of key keepers participating on the
+	// threshold key mode, as index:sharePublicKey
+	KeyKeeperPeers []string
 	// ImportPreviousCensus if true the census downloader will try to download
 	// all census (not only the new ones)
 	ImportPreviousCensus bool
 	// Enable Prometheus metrics from tendermint
 	TendermintMetrics bool
+	// TxIndexer enables the tendermint transaction indexer, so the delivered
+	// transactions can be searched by their events (see vochain.TxEventType)
+	TxIndexer bool
 	// EthereumWhiteListAddrs is a map of ethereum addresses able to create oracle txs
 	// If the ethereum address that modified the source of truth
 	// is not on the EthereumWhiteListAddrs the oracle will ignore the event triggered
@@ -198,6 +250,35 @@ type VochainCfg struct {
 	Scrutinizer ScrutinizerCfg
 	// IsSeedNode specifies if the node is configured to act as a seed node
 	IsSeedNode bool
+	// SnapshotInterval is the number of blocks between state snapshots (0 disables them)
+	SnapshotInterval int
+	// StateSync holds the configuration regarding the state sync bootstrap
+	StateSync StateSyncCfg
+	// StatePruning holds the configuration regarding the pruning of old state versions
+	StatePruning StatePruningCfg
+}
+
+// StatePruningCfg handles the configuration options for pruning the old
+// versions of the vochain state
+type StatePruningCfg struct {
+	// KeepRecent is the number of most recent state versions to keep (0 disables pruning)
+	KeepRecent int
+	// KeepEvery keeps every state version multiple of KeepEvery (0 keeps none)
+	KeepEvery int
+}
+
+// StateSyncCfg handles the configuration options for bootstrapping the node
+// from a state snapshot served by other peers
+type StateSyncCfg struct {
+	// Enabled if true the node is bootstrapped from a snapshot instead of
+	// replaying all the blocks
+	Enabled bool
+	// RPCServers are the tendermint RPC servers used to verify the snapshot (at least 2)
+	RPCServers []string
+	// TrustHeight is a trusted block height used by the light client verification
+	TrustHeight int64
+	// TrustHash is the hex encoded block hash at TrustHeight
+	TrustHash string
 }
 
 // ScrutinizerCfg handles the configuration options of the scrutinizer
@@ -0,0 +1,379 @@
+// Package dkg implements a dealerless t-of-n distributed key generation for
+// the nacl encryption keys used by the key keepers, based on Feldman's
+// verifiable secret sharing over edwards25519.
+//
+// Each participant (identified by its key keeper index, starting at 1) deals
+// a random polynomial f_i of degree t-1: it publishes the commitments to the
+// coefficients and the evaluations f_i(j) for every participant j, encrypted
+// to the participant's nacl key.  The joint secret is the sum of the free
+// terms of all the polynomials, and its public key can be computed from the
+// commitments alone.  Participant j's share of the joint secret is the sum of
+// all the f_i(j) it received, which can be verified against the commitments.
+// Any t valid shares can be combined to recover the joint secret, which is
+// then encoded as a regular nacl private key.
+//
+// Since the shares are encrypted, only participant j can check f_i(j).  If it
+// is not valid, j complains against the dealing and the dealer answers by
+// revealing f_i(j) in clear (see Polynomial.Share), which anyone can verify
+// with VerifyShare.  A dealer that does not answer is disqualified, and its
+// dealing is left out of the joint key.
+package dkg
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"sort"
+
+	"filippo.io/edwards25519"
+	"golang.org/x/crypto/nacl/box"
+
+	"go.vocdoni.io/dvote/crypto"
+	"go.vocdoni.io/dvote/crypto/nacl"
+)
+
+const (
+	// MaxParticipants is the maximum number of participants of a dealing,
+	// so that indexes fit in a byte.
+	MaxParticipants = 255
+	// encryptedShareSize is the size of a share encrypted to a nacl key
+	encryptedShareSize = ScalarLength + box.AnonymousOverhead
+)
+
+// Polynomial is the secret polynomial of a participant.  Its free term is
+// the participant's contribution to the joint secret.
+type Polynomial struct {
+	coeffs []*edwards25519.Scalar
+}
+
+// NewPolynomial deterministically derives a polynomial of degree threshold-1
+// from a secret seed.
+func NewPolynomial(seed []byte, threshold int) (*Polynomial, error) {
+	if threshold < 1 || threshold > MaxParticipants {
+		return nil, fmt.Errorf("invalid threshold %d", threshold)
+	}
+	p := &Polynomial{}
+	for k := 0; k < threshold; k++ {
+		h := sha512.Sum512(append(append([]byte(nil), seed...), byte(k)))
+		c, err := edwards25519.NewScalar().SetUniformBytes(h[:])
+		if err != nil {
+			return nil, err
+		}
+		p.coeffs = append(p.coeffs, c)
+	}
+	return p, nil
+}
+
+// Threshold returns the number of shares needed to recover the secret.
+func (p *Polynomial) Threshold() int {
+	return len(p.coeffs)
+}
+
+// eval returns f(index) mod L.
+func (p *Polynomial) eval(index int) *edwards25519.Scalar {
+	x := scalarFromInt(index)
+	r := edwards25519.NewScalar()
+	for k := len(p.coeffs) - 1; k >= 0; k-- {
+		r.MultiplyAdd(r, x, p.coeffs[k])
+	}
+	return r
+}
+
+// Share returns the share of the participant index, f(index).  It is revealed
+// in clear to answer a complaint against the dealing.
+func (p *Polynomial) Share(index int) []byte {
+	return p.eval(index).Bytes()
+}
+
+// Deal returns the public dealing of the polynomial for the given
+// participants, encrypting each share to its participant's nacl public key.
+func (p *Polynomial) Deal(participants map[int]crypto.PublicKey) (*Dealing, error) {
+	if len(participants) < p.Threshold() {
+		return nil, fmt.Errorf("not enough participants (%d) for threshold %d",
+			len(participants), p.Threshold())
+	}
+	d := &Dealing{Shares: make(map[int][]byte)}
+	for _, c := range p.coeffs {
+		d.Commitments = append(d.Commitments, new(edwards25519.Point).ScalarBaseMult(c).Bytes())
+	}
+	for index, pub := range participants {
+		if index < 1 || index > MaxParticipants {
+			return nil, fmt.Errorf("invalid participant index %d", index)
+		}
+		share, err := nacl.Anonymous.Encrypt(p.eval(index).Bytes(), pub)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encrypt share for participant %d: %w", index, err)
+		}
+		d.Shares[index] = share
+	}
+	return d, nil
+}
+
+// Dealing is the public contribution of a participant to the joint key.
+type Dealing struct {
+	// Commitments are the coefficients of the polynomial multiplied by
+	// the base point, starting with the free term.
+	Commitments [][]byte
+	// Shares are the evaluations of the polynomial for each participant
+	// index, encrypted to the participant's nacl key.
+	Shares map[int][]byte
+}
+
+// Threshold returns the number of shares needed to recover the secret.
+func (d *Dealing) Threshold() int {
+	return len(d.Commitments)
+}
+
+// Marshal encodes the dealing as:
+//
+//	threshold (1 byte) | commitments (threshold*32 bytes) |
+//	participants (1 byte) | {index (1 byte) | encrypted share (80 bytes)}...
+//
+// The shares are sorted by participant index.
+func (d *Dealing) Marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(len(d.Commitments)))
+	for _, c := range d.Commitments {
+		buf.Write(c)
+	}
+	buf.WriteByte(byte(len(d.Shares)))
+	for _, index := range d.Participants() {
+		buf.WriteByte(byte(index))
+		buf.Write(d.Shares[index])
+	}
+	return buf.Bytes()
+}
+
+// Participants returns the sorted indexes of the participants of the dealing.
+func (d *Dealing) Participants() []int {
+	indexes := make([]int, 0, len(d.Shares))
+	for index := range d.Shares {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// UnmarshalDealing decodes and validates a dealing encoded with Marshal.
+func UnmarshalDealing(data []byte) (*Dealing, error) {
+	r := bytes.NewReader(data)
+	threshold, err := r.ReadByte()
+	if err != nil || threshold == 0 {
+		return nil, fmt.Errorf("invalid dealing threshold")
+	}
+	d := &Dealing{Shares: make(map[int][]byte)}
+	for k := 0; k < int(threshold); k++ {
+		c := make([]byte, PointLength)
+		if _, err := io.ReadFull(r, c); err != nil {
+			return nil, fmt.Errorf("cannot read dealing commitment %d: %w", k, err)
+		}
+		if _, err := decodePoint(c); err != nil {
+			return nil, fmt.Errorf("invalid dealing commitment %d: %w", k, err)
+		}
+		d.Commitments = append(d.Commitments, c)
+	}
+	n, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read dealing participants: %w", err)
+	}
+	if int(n) < d.Threshold() {
+		return nil, fmt.Errorf("not enough participants (%d) for threshold %d", n, threshold)
+	}
+	for i := 0; i < int(n); i++ {
+		index, err := r.ReadByte()
+		if err != nil || index == 0 {
+			return nil, fmt.Errorf("invalid dealing participant index")
+		}
+		if _, ok := d.Shares[int(index)]; ok {
+			return nil, fmt.Errorf("duplicated dealing participant %d", index)
+		}
+		share := make([]byte, encryptedShareSize)
+		if _, err := io.ReadFull(r, share); err != nil {
+			return nil, fmt.Errorf("cannot read share for participant %d: %w", index, err)
+		}
+		d.Shares[int(index)] = share
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("trailing data after dealing")
+	}
+	return d, nil
+}
+
+// DecryptShare decrypts the share of the participant index from the dealing
+// and verifies it against the commitments.
+func (d *Dealing) DecryptShare(index int, key crypto.Cipher) ([]byte, error) {
+	encrypted, ok := d.Shares[index]
+	if !ok {
+		return nil, fmt.Errorf("no share for participant %d", index)
+	}
+	share, err := key.Decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt share for participant %d: %w", index, err)
+	}
+	if err := VerifyShare([]*Dealing{d}, index, share); err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// AggregateShares sums the shares received by a participant from all the
+// dealings, returning its share of the joint secret.
+func AggregateShares(shares ...[]byte) ([]byte, error) {
+	sum := edwards25519.NewScalar()
+	for _, share := range shares {
+		s, err := decodeScalar(share)
+		if err != nil {
+			return nil, err
+		}
+		sum.Add(sum, s)
+	}
+	return sum.Bytes(), nil
+}
+
+// VerifyShare checks that share is the aggregated share of the participant
+// index for the given dealings.
+func VerifyShare(dealings []*Dealing, index int, share []byte) error {
+	if len(dealings) == 0 {
+		return fmt.Errorf("no dealings")
+	}
+	s, err := decodeScalar(share)
+	if err != nil {
+		return err
+	}
+	threshold := dealings[0].Threshold()
+	// Aggregate the commitments of all the dealings first, so that the
+	// check costs threshold scalar multiplications.
+	commitments := make([]*edwards25519.Point, threshold)
+	for i := range commitments {
+		commitments[i] = edwards25519.NewIdentityPoint()
+	}
+	for _, d := range dealings {
+		if d.Threshold() != threshold {
+			return fmt.Errorf("dealings have different thresholds")
+		}
+		for k, c := range d.Commitments {
+			p, err := decodePoint(c)
+			if err != nil {
+				return err
+			}
+			commitments[k].Add(commitments[k], p)
+		}
+	}
+	// sum(C_k * index^k) must be share * B, the commitments and the
+	// index are public so the check can be variable time
+	x := scalarFromInt(index)
+	powers := make([]*edwards25519.Scalar, threshold)
+	powers[0] = scalarFromInt(1)
+	for k := 1; k < threshold; k++ {
+		powers[k] = edwards25519.NewScalar().Multiply(powers[k-1], x)
+	}
+	expected := new(edwards25519.Point).VarTimeMultiScalarMult(powers, commitments)
+	if new(edwards25519.Point).ScalarBaseMult(s).Equal(expected) != 1 {
+		return fmt.Errorf("invalid share for participant %d", index)
+	}
+	return nil
+}
+
+// PublicKey returns the joint nacl public key of the dealings.
+func PublicKey(dealings []*Dealing) ([]byte, error) {
+	if len(dealings) == 0 {
+		return nil, fmt.Errorf("no dealings")
+	}
+	pub := edwards25519.NewIdentityPoint()
+	for _, d := range dealings {
+		p, err := decodePoint(d.Commitments[0])
+		if err != nil {
+			return nil, err
+		}
+		pub.Add(pub, p)
+	}
+	if pub.Equal(edwards25519.NewIdentityPoint()) == 1 {
+		return nil, fmt.Errorf("joint public key is the identity")
+	}
+	return pub.BytesMontgomery(), nil
+}
+
+// Combine recovers the joint secret of the dealings from the aggregated
+// shares, indexed by participant, and returns it as a nacl private key.  At
+// least threshold shares are required; the lowest indexes are used, so the
+// result is deterministic.  The shares must have been verified beforehand.
+func Combine(dealings []*Dealing, shares map[int][]byte) ([]byte, error) {
+	if len(dealings) == 0 {
+		return nil, fmt.Errorf("no dealings")
+	}
+	threshold := dealings[0].Threshold()
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("not enough shares (%d) for threshold %d", len(shares), threshold)
+	}
+	indexes := make([]int, 0, len(shares))
+	for index := range shares {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	indexes = indexes[:threshold]
+
+	// Lagrange interpolation at zero
+	secret := edwards25519.NewScalar()
+	for _, j := range indexes {
+		s, err := decodeScalar(shares[j])
+		if err != nil {
+			return nil, err
+		}
+		num, den := scalarFromInt(1), scalarFromInt(1)
+		for _, m := range indexes {
+			if m == j {
+				continue
+			}
+			num.Multiply(num, scalarFromInt(m))
+			den.Multiply(den, edwards25519.NewScalar().Subtract(scalarFromInt(m), scalarFromInt(j)))
+		}
+		lambda := num.Multiply(num, den.Invert(den))
+		secret.MultiplyAdd(lambda, s, secret)
+	}
+
+	priv, err := naclPrivateKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	// verify the recovered key against the joint public key
+	pub, err := PublicKey(dealings)
+	if err != nil {
+		return nil, err
+	}
+	key, err := nacl.DecodePrivate(fmt.Sprintf("%x", priv))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(key.Public().Bytes(), pub) {
+		return nil, fmt.Errorf("recovered key does not match the joint public key")
+	}
+	return priv, nil
+}
+
+// naclPrivateKey encodes the scalar x as a nacl (X25519) private key k such
+// that X25519(k, 9) is the montgomery u-coordinate of x*B.  X25519 clamps
+// the key to 8*y with 2^251 <= y < 2^252, so we look for such y with
+// 8*y = x or 8*y = -x (mod L), since x*B and -x*B share the u-coordinate.
+func naclPrivateKey(x *edwards25519.Scalar) ([]byte, error) {
+	if x.Equal(edwards25519.NewScalar()) == 1 {
+		return nil, fmt.Errorf("invalid secret")
+	}
+	y := edwards25519.NewScalar().Multiply(x, edwards25519.NewScalar().Invert(scalarFromInt(8)))
+	// y < 2^251 if its most significant byte is below 0x08
+	if y.Bytes()[ScalarLength-1] < 0x08 {
+		y.Negate(y)
+	}
+	b := y.Bytes()
+	if b[ScalarLength-1] < 0x08 || b[ScalarLength-1] >= 0x10 {
+		return nil, fmt.Errorf("secret cannot be encoded as a nacl key")
+	}
+	// k = y << 3, which fits in the 32 bytes since y < 2^252
+	key := make([]byte, nacl.KeyLength)
+	var carry byte
+	for i := range b {
+		key[i] = b[i]<<3 | carry
+		carry = b[i] >> 5
+	}
+	return key, nil
+}
@@ -0,0 +1,146 @@
+package dkg
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"filippo.io/edwards25519"
+	qt "github.com/frankban/quicktest"
+
+	"go.vocdoni.io/dvote/crypto"
+	"go.vocdoni.io/dvote/crypto/nacl"
+)
+
+func TestDecodePoint(t *testing.T) {
+	// the X25519 base point is u=9
+	u := make([]byte, 32)
+	u[0] = 9
+	base := edwards25519.NewGeneratorPoint()
+	qt.Assert(t, base.BytesMontgomery(), qt.DeepEquals, u)
+
+	p := new(edwards25519.Point).ScalarBaseMult(scalarFromInt(12345))
+	q, err := decodePoint(p.Bytes())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, q.Equal(p), qt.Equals, 1)
+
+	// a point out of the prime order subgroup is rejected
+	_, err = decodePoint(new(edwards25519.Point).Add(p, lowOrderPoint(t)).Bytes())
+	qt.Assert(t, err, qt.ErrorMatches, ".*prime order subgroup")
+	// a non-canonical encoding of the identity (y = p + 1) is rejected
+	id := []byte{0xee, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}
+	_, err = decodePoint(id)
+	qt.Assert(t, err, qt.ErrorMatches, "invalid point encoding")
+}
+
+// lowOrderPoint returns the point of order 2, (0, -1).
+func lowOrderPoint(t *testing.T) *edwards25519.Point {
+	b := make([]byte, 32)
+	b[0] = 0xec
+	for i := 1; i < 31; i++ {
+		b[i] = 0xff
+	}
+	b[31] = 0x7f
+	p, err := new(edwards25519.Point).SetBytes(b)
+	qt.Assert(t, err, qt.IsNil)
+	return p
+}
+
+func TestDKG(t *testing.T) {
+	const n, threshold = 5, 3
+	keys := make(map[int]crypto.Cipher)
+	pubs := make(map[int]crypto.PublicKey)
+	for i := 1; i <= n; i++ {
+		key, err := nacl.Generate(rand.Reader)
+		qt.Assert(t, err, qt.IsNil)
+		keys[i], pubs[i] = key, key.Public()
+	}
+
+	// each participant deals its polynomial
+	dealings := []*Dealing{}
+	for i := 1; i <= n; i++ {
+		p, err := NewPolynomial([]byte(fmt.Sprintf("seed%d", i)), threshold)
+		qt.Assert(t, err, qt.IsNil)
+		d, err := p.Deal(pubs)
+		qt.Assert(t, err, qt.IsNil)
+		d, err = UnmarshalDealing(d.Marshal())
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, d.Participants(), qt.DeepEquals, []int{1, 2, 3, 4, 5})
+		dealings = append(dealings, d)
+	}
+	pub, err := PublicKey(dealings)
+	qt.Assert(t, err, qt.IsNil)
+
+	// each participant aggregates its shares
+	shares := make(map[int][]byte)
+	for i := 1; i <= n; i++ {
+		received := [][]byte{}
+		for _, d := range dealings {
+			s, err := d.DecryptShare(i, keys[i])
+			qt.Assert(t, err, qt.IsNil)
+			received = append(received, s)
+		}
+		shares[i], err = AggregateShares(received...)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, VerifyShare(dealings, i, shares[i]), qt.IsNil)
+	}
+	// a share is not valid for a different participant
+	qt.Assert(t, VerifyShare(dealings, 2, shares[1]), qt.IsNotNil)
+
+	// encrypt a message with the joint public key
+	joint, err := nacl.DecodePublic(fmt.Sprintf("%x", pub))
+	qt.Assert(t, err, qt.IsNil)
+	msg := []byte("hello threshold")
+	cipher, err := nacl.Anonymous.Encrypt(msg, joint)
+	qt.Assert(t, err, qt.IsNil)
+
+	// any threshold subset of shares recovers the same key
+	var first []byte
+	for _, subset := range [][]int{{1, 2, 3}, {2, 4, 5}, {1, 3, 4, 5}} {
+		sub := make(map[int][]byte)
+		for _, i := range subset {
+			sub[i] = shares[i]
+		}
+		priv, err := Combine(dealings, sub)
+		qt.Assert(t, err, qt.IsNil)
+		if first == nil {
+			first = priv
+		}
+		qt.Assert(t, priv, qt.DeepEquals, first)
+		key, err := nacl.DecodePrivate(fmt.Sprintf("%x", priv))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, key.Public().Bytes(), qt.DeepEquals, pub)
+		plain, err := key.Decrypt(cipher)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, plain, qt.DeepEquals, msg)
+	}
+
+	// two dropouts out of five leave just enough shares, three do not
+	_, err = Combine(dealings, map[int][]byte{1: shares[1], 5: shares[5]})
+	qt.Assert(t, err, qt.ErrorMatches, "not enough shares.*")
+
+	// a wrong share is detected when combining
+	_, err = Combine(dealings, map[int][]byte{1: shares[1], 2: shares[2], 3: shares[4]})
+	qt.Assert(t, err, qt.IsNotNil)
+}
+
+func TestUnmarshalDealing(t *testing.T) {
+	key, err := nacl.Generate(rand.Reader)
+	qt.Assert(t, err, qt.IsNil)
+	p, err := NewPolynomial([]byte("seed"), 2)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = p.Deal(map[int]crypto.PublicKey{1: key.Public()})
+	qt.Assert(t, err, qt.IsNotNil)
+	d, err := p.Deal(map[int]crypto.PublicKey{1: key.Public(), 2: key.Public()})
+	qt.Assert(t, err, qt.IsNil)
+	data := d.Marshal()
+
+	_, err = UnmarshalDealing(data[:len(data)-1])
+	qt.Assert(t, err, qt.IsNotNil)
+	_, err = UnmarshalDealing(append(data, 0))
+	qt.Assert(t, err, qt.IsNotNil)
+	_, err = UnmarshalDealing(key.Public().Bytes())
+	qt.Assert(t, err, qt.IsNotNil)
+}
@@ -0,0 +1,67 @@
+package dkg
+
+import (
+	"bytes"
+	"fmt"
+
+	"filippo.io/edwards25519"
+)
+
+// This file holds the helpers on top of the edwards25519 group arithmetic
+// needed by the distributed key generation.  The arithmetic is provided by
+// filippo.io/edwards25519, which is constant time, so the operations on the
+// polynomial coefficients and the shares do not leak the secrets.
+
+const (
+	// PointLength is the size of a compressed edwards25519 point.
+	PointLength = 32
+	// ScalarLength is the size of an encoded scalar.
+	ScalarLength = 32
+)
+
+// minusOne is the scalar L-1, used to check the order of the points.
+var minusOne = edwards25519.NewScalar().Subtract(edwards25519.NewScalar(), scalarFromInt(1))
+
+// scalarFromInt returns the scalar of a small non-negative integer, such as a
+// participant index.
+func scalarFromInt(n int) *edwards25519.Scalar {
+	b := make([]byte, ScalarLength)
+	for i := 0; n > 0; i, n = i+1, n>>8 {
+		b[i] = byte(n)
+	}
+	s, err := edwards25519.NewScalar().SetCanonicalBytes(b)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// decodeScalar decodes a canonical little endian scalar.
+func decodeScalar(b []byte) (*edwards25519.Scalar, error) {
+	if len(b) != ScalarLength {
+		return nil, fmt.Errorf("scalar length must be %d, not %d", ScalarLength, len(b))
+	}
+	s, err := edwards25519.NewScalar().SetCanonicalBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("scalar is not reduced")
+	}
+	return s, nil
+}
+
+// decodePoint decodes a compressed point and checks that its encoding is
+// canonical and that it belongs to the prime order subgroup.
+func decodePoint(b []byte) (*edwards25519.Point, error) {
+	if len(b) != PointLength {
+		return nil, fmt.Errorf("point length must be %d, not %d", PointLength, len(b))
+	}
+	p, err := new(edwards25519.Point).SetBytes(b)
+	if err != nil || !bytes.Equal(p.Bytes(), b) {
+		return nil, fmt.Errorf("invalid point encoding")
+	}
+	// L*p is the identity if and only if (L-1)*p + p is
+	q := new(edwards25519.Point).ScalarMult(minusOne, p)
+	if q.Add(q, p).Equal(edwards25519.NewIdentityPoint()) != 1 {
+		return nil, fmt.Errorf("point is not in the prime order subgroup")
+	}
+	return p, nil
+}
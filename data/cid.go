@@ -0,0 +1,42 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer"
+)
+
+// CalculateCID returns the content identifier IPFS would assign to content
+// when adding it with the default options (UnixFS, balanced layout, CIDv0).
+// It allows storage backends without an IPFS node to keep the same URIs.
+func CalculateCID(content []byte) (cid.Cid, error) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	node, err := importer.BuildDagFromReader(dag, chunker.DefaultSplitter(bytes.NewReader(content)))
+	if err != nil {
+		return cid.Undef, err
+	}
+	return node.Cid(), nil
+}
+
+// parseCIDPath extracts the content identifier of a storage path, which can
+// be either a plain CID, an ipfs:// URI or an /ipfs/ path.
+func parseCIDPath(path string) (cid.Cid, error) {
+	path = strings.TrimPrefix(path, "ipfs://")
+	path = strings.TrimPrefix(path, "/ipfs/")
+	c, err := cid.Decode(path)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid content identifier %q: %w", path, err)
+	}
+	return c, nil
+}
@@ -1,4 +1,5 @@
-// Package data provides an abstraction layer for distributed data storage providers (currently only IPFS)
+// Package data provides an abstraction layer for data storage providers
+// (IPFS, a local filesystem store and S3 compatible object stores)
 package data
 
 import (
@@ -29,6 +30,10 @@ type StorageID int
 const (
 	IPFS StorageID = iota + 1
 	BZZ
+	// FS is a content addressed store on the local filesystem
+	FS
+	// S3 is a content addressed store on an S3 compatible object storage
+	S3
 )
 
 func StorageIDFromString(i string) StorageID {
@@ -37,6 +42,10 @@ func StorageIDFromString(i string) StorageID {
 		return IPFS
 	case "BZZ":
 		return BZZ
+	case "FS":
+		return FS
+	case "S3":
+		return S3
 	default:
 		return -1
 	}
@@ -56,6 +65,14 @@ func Init(t StorageID, d *types.DataStore) (Storage, error) {
 		s := new(IPFSHandle)
 		err := s.Init(d)
 		return s, err
+	case FS:
+		s := new(FileStore)
+		err := s.Init(d)
+		return s, err
+	case S3:
+		s := new(S3Handle)
+		err := s.Init(d)
+		return s, err
 	default:
 		return nil, errors.New("bad storage type or DataStore specification")
 	}
@@ -0,0 +1,184 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/metrics"
+	"go.vocdoni.io/dvote/types"
+)
+
+// FileStore is a content addressed storage backed by the local filesystem.
+// Files are identified by the same CIDs IPFS would use, so URIs remain valid
+// across backends, but nothing is shared with or fetched from the network.
+// Every stored file is considered pinned, unpinning a file removes it.
+type FileStore struct {
+	DataDir string
+	// lock serializes writes and removals of files
+	lock sync.Mutex
+}
+
+// Init creates the directory where the files are stored.
+func (f *FileStore) Init(d *types.DataStore) error {
+	f.DataDir = filepath.Join(d.Datadir, "files")
+	return os.MkdirAll(f.DataDir, 0o750)
+}
+
+// Stop does nothing, the filesystem store holds no resources.
+func (f *FileStore) Stop() error {
+	return nil
+}
+
+// URIprefix returns the URI prefix which identifies the protocol
+func (f *FileStore) URIprefix() string {
+	return "ipfs://"
+}
+
+func (f *FileStore) filePath(path string) (string, error) {
+	c, err := parseCIDPath(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(f.DataDir, c.String()), nil
+}
+
+// Publish stores msg and returns its content identifier
+func (f *FileStore) Publish(ctx context.Context, msg []byte) (string, error) {
+	c, err := CalculateCID(msg)
+	if err != nil {
+		return "", err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	filePath := filepath.Join(f.DataDir, c.String())
+	if _, err := os.Stat(filePath); err == nil {
+		return c.String(), nil
+	}
+	// write to a temporary file first, so readers never see partial files
+	tmp, err := os.CreateTemp(f.DataDir, ".publish-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(msg); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return "", err
+	}
+	log.Infof("published file %s", c)
+	return c.String(), nil
+}
+
+// Retrieve reads a stored file
+func (f *FileStore) Retrieve(ctx context.Context, path string, maxSize int64) ([]byte, error) {
+	filePath, err := f.filePath(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("file %s not found", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if maxSize == 0 {
+		maxSize = MaxFileSizeBytes
+	}
+	if info.Size() > maxSize {
+		return nil, fmt.Errorf("file too big")
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return nil, fmt.Errorf("retreived file is empty")
+	}
+	return content, nil
+}
+
+// Pin checks the file is stored, since there is no network to fetch it from.
+func (f *FileStore) Pin(ctx context.Context, path string) error {
+	filePath, err := f.filePath(path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		return fmt.Errorf("file %s not found in storage", path)
+	}
+	return nil
+}
+
+// Unpin removes a stored file
+func (f *FileStore) Unpin(ctx context.Context, path string) error {
+	filePath, err := f.filePath(path)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := os.Remove(filePath); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("file %s not found in storage", path)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListPins returns all the stored files, using the same format as IPFS.
+func (f *FileStore) ListPins(ctx context.Context) (map[string]string, error) {
+	entries, err := os.ReadDir(f.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	pins := make(map[string]string)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if _, err := parseCIDPath(e.Name()); err != nil {
+			continue
+		}
+		pins["/ipfs/"+e.Name()] = "recursive"
+	}
+	return pins, nil
+}
+
+func (f *FileStore) Stats(ctx context.Context) (string, error) {
+	pins, err := f.ListPins(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pins:%d", len(pins)), nil
+}
+
+// CollectMetrics constantly updates the metric values for prometheus
+// The function is blocking, should be called in a go routine
+// If the metrics Agent is nil, do nothing
+func (f *FileStore) CollectMetrics(ctx context.Context, ma *metrics.Agent) error {
+	if ma != nil {
+		ma.Register(FilePins)
+		for {
+			time.Sleep(ma.RefreshInterval)
+			pins, err := f.ListPins(ctx)
+			if err != nil {
+				return err
+			}
+			FilePins.Set(float64(len(pins)))
+		}
+	}
+	return nil
+}
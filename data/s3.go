@@ -0,0 +1,402 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"go.vocdoni.io/dvote/db/lru"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/metrics"
+	"go.vocdoni.io/dvote/types"
+)
+
+// DefaultS3Region is the region used to sign requests if none is configured.
+// S3 compatible servers such as MinIO use it by default.
+const DefaultS3Region = "us-east-1"
+
+// S3Handle is a content addressed storage backed by an S3 compatible object
+// store (such as AWS S3 or MinIO). Objects are keyed by the same CIDs IPFS
+// would use, so URIs remain valid across backends. Every stored object is
+// considered pinned, unpinning a file removes it.
+type S3Handle struct {
+	Endpoint  *url.URL
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	client       *http.Client
+	retriveCache *lru.Cache
+}
+
+// s3Error is the error document returned by S3 on failed requests
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// s3ListResult is the response of ListObjectsV2
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Init connects to the object store and creates the bucket if it does not
+// exist yet.
+func (s *S3Handle) Init(d *types.DataStore) error {
+	if d.S3Endpoint == "" || d.S3Bucket == "" {
+		return fmt.Errorf("s3 storage requires an endpoint and a bucket")
+	}
+	endpoint, err := url.Parse(d.S3Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return fmt.Errorf("invalid s3 endpoint scheme %q", endpoint.Scheme)
+	}
+	s.Endpoint = endpoint
+	s.Bucket = d.S3Bucket
+	s.Region = d.S3Region
+	if s.Region == "" {
+		s.Region = DefaultS3Region
+	}
+	s.AccessKey = d.S3AccessKey
+	s.SecretKey = d.S3SecretKey
+	s.client = &http.Client{Timeout: time.Minute}
+	s.retriveCache = lru.New(RetrivedFileCacheSize)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	resp, err := s.request(ctx, http.MethodHead, "", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("cannot access s3 bucket %s: %s", s.Bucket, resp.Status)
+	}
+	log.Infof("creating s3 bucket %s", s.Bucket)
+	var body []byte
+	if s.Region != DefaultS3Region {
+		body = []byte("<CreateBucketConfiguration><LocationConstraint>" +
+			s.Region + "</LocationConstraint></CreateBucketConfiguration>")
+	}
+	resp, err = s.do(ctx, http.MethodPut, "", nil, body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Stop does nothing, requests are stateless.
+func (s *S3Handle) Stop() error {
+	return nil
+}
+
+// URIprefix returns the URI prefix which identifies the protocol
+func (s *S3Handle) URIprefix() string {
+	return "ipfs://"
+}
+
+// Publish stores msg and returns its content identifier
+func (s *S3Handle) Publish(ctx context.Context, msg []byte) (string, error) {
+	c, err := CalculateCID(msg)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.do(ctx, http.MethodPut, c.String(), nil, msg)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	log.Infof("published file %s", c)
+	return c.String(), nil
+}
+
+// Retrieve gets a stored object (either from the object store or from the
+// local cache)
+func (s *S3Handle) Retrieve(ctx context.Context, path string, maxSize int64) ([]byte, error) {
+	c, err := parseCIDPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize == 0 {
+		maxSize = MaxFileSizeBytes
+	}
+	if ccontent := s.retriveCache.Get(c.String()); ccontent != nil {
+		if int64(len(ccontent.([]byte))) > maxSize {
+			return nil, fmt.Errorf("file too big")
+		}
+		log.Debugf("retreived file %s from cache", path)
+		return ccontent.([]byte), nil
+	}
+	resp, err := s.request(ctx, http.MethodGet, c.String(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("file %s not found", path)
+	}
+	if err := s3ResponseError(resp); err != nil {
+		return nil, err
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("file too big")
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, fmt.Errorf("file too big")
+	}
+	if len(content) == 0 {
+		return nil, fmt.Errorf("retreived file is empty")
+	}
+	s.retriveCache.Add(c.String(), content)
+	return content, nil
+}
+
+// Pin checks the object is stored, since there is no network to fetch it from.
+func (s *S3Handle) Pin(ctx context.Context, path string) error {
+	c, err := parseCIDPath(path)
+	if err != nil {
+		return err
+	}
+	resp, err := s.request(ctx, http.MethodHead, c.String(), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("file %s not found in storage", path)
+	}
+	return s3ResponseError(resp)
+}
+
+// Unpin removes a stored object
+func (s *S3Handle) Unpin(ctx context.Context, path string) error {
+	c, err := parseCIDPath(path)
+	if err != nil {
+		return err
+	}
+	if err := s.Pin(ctx, path); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, c.String(), nil, nil)
+	if err != nil {
+		return err
+	}
+	s.retriveCache.Remove(c.String())
+	return resp.Body.Close()
+}
+
+// ListPins returns all the stored objects, using the same format as IPFS.
+func (s *S3Handle) ListPins(ctx context.Context) (map[string]string, error) {
+	pins := make(map[string]string)
+	query := url.Values{"list-type": []string{"2"}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var list s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode s3 object list: %w", err)
+		}
+		for _, object := range list.Contents {
+			if _, err := parseCIDPath(object.Key); err != nil {
+				continue
+			}
+			pins["/ipfs/"+object.Key] = "recursive"
+		}
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			return pins, nil
+		}
+		query.Set("continuation-token", list.NextContinuationToken)
+	}
+}
+
+func (s *S3Handle) Stats(ctx context.Context) (string, error) {
+	pins, err := s.ListPins(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("bucket:%s pins:%d", s.Bucket, len(pins)), nil
+}
+
+// CollectMetrics constantly updates the metric values for prometheus
+// The function is blocking, should be called in a go routine
+// If the metrics Agent is nil, do nothing
+func (s *S3Handle) CollectMetrics(ctx context.Context, ma *metrics.Agent) error {
+	if ma != nil {
+		ma.Register(FilePins)
+		for {
+			time.Sleep(ma.RefreshInterval)
+			tctx, cancel := context.WithTimeout(ctx, time.Minute)
+			pins, err := s.ListPins(tctx)
+			cancel()
+			if err != nil {
+				return err
+			}
+			FilePins.Set(float64(len(pins)))
+		}
+	}
+	return nil
+}
+
+// do performs a signed request and returns an error if the response status
+// is not successful.
+func (s *S3Handle) do(ctx context.Context, method, key string,
+	query url.Values, body []byte) (*http.Response, error) {
+	resp, err := s.request(ctx, method, key, query, body)
+	if err != nil {
+		return nil, err
+	}
+	if err := s3ResponseError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// request performs a path-style request signed with AWS Signature Version 4
+// on the bucket, or on one of its objects if key is not empty.
+func (s *S3Handle) request(ctx context.Context, method, key string,
+	query url.Values, body []byte) (*http.Response, error) {
+	u := *s.Endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = s3CanonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 headers to req.
+func (s *S3Handle) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		s.AccessKey, scope, signedHeaders, hmacSHA256(key, stringToSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3CanonicalQuery encodes the query sorted by key, as required by the
+// signature.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// s3Escape URI-encodes every byte except the unreserved characters. Slashes
+// are kept unless encodeSlash is true.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3ResponseError returns the error described by a failed response, if any.
+func s3ResponseError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	var s3err s3Error
+	if err := xml.NewDecoder(resp.Body).Decode(&s3err); err != nil || s3err.Code == "" {
+		return fmt.Errorf("s3 request failed: %s", resp.Status)
+	}
+	return fmt.Errorf("s3 request failed: %s: %s", s3err.Code, s3err.Message)
+}
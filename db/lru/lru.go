@@ -31,3 +31,8 @@ func (l *Cache) Get(key interface{}) interface{} {
 	}
 	return value
 }
+
+// Remove deletes an element from the cache
+func (l *Cache) Remove(key interface{}) {
+	l.lru.Remove(key)
+}
@@ -186,12 +186,8 @@ func HandleVochainOracle(ctx context.Context, event *ethtypes.Log, e *EthereumEv
 			return fmt.Errorf("set process handle: %w", err)
 		}
 		setProcessTx.Nonce = oracle.GetNonce()
-		stx := &models.SignedTx{}
-		stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_SetProcess{SetProcess: setProcessTx}})
-		if err != nil {
-			return fmt.Errorf("cannot marshal setProcess tx: %w", err)
-		}
-		stx.Signature, err = e.Signer.SignVocdoniTx(stx.Tx, e.VochainApp.ChainID())
+		stx, err := e.VochainApp.SignOracleTx(e.Signer,
+			&models.Tx{Payload: &models.Tx_SetProcess{SetProcess: setProcessTx}})
 		if err != nil {
 			return fmt.Errorf("cannot sign oracle tx: %w", err)
 		}
@@ -245,12 +241,8 @@ func HandleVochainOracle(ctx context.Context, event *ethtypes.Log, e *EthereumEv
 			return fmt.Errorf("set census handle: %w", err)
 		}
 		setProcessTx.Nonce = oracle.GetNonce()
-		stx := &models.SignedTx{}
-		stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_SetProcess{SetProcess: setProcessTx}})
-		if err != nil {
-			return fmt.Errorf("cannot marshal setProcess tx: %w", err)
-		}
-		stx.Signature, err = e.Signer.SignVocdoniTx(stx.Tx, e.VochainApp.ChainID())
+		stx, err := e.VochainApp.SignOracleTx(e.Signer,
+			&models.Tx{Payload: &models.Tx_SetProcess{SetProcess: setProcessTx}})
 		if err != nil {
 			return fmt.Errorf("cannot sign oracle tx: %w", err)
 		}
@@ -10,8 +10,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/go-chi/chi"
+	"go.vocdoni.io/dvote/db"
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
 )
 
 const (
@@ -32,12 +35,24 @@ const (
 
 // BearerStandardAPI is a namespace handler for the httpRouter with Bearer authorization
 type BearerStandardAPI struct {
-	router         *httprouter.HTTProuter
-	basePath       string
-	authTokens     sync.Map
+	router   *httprouter.HTTProuter
+	basePath string
+	// authTokens maps the token hashes to the auth tokens
+	authTokens map[string]*AuthToken
+	// pendingTokens are the token hashes to be written to the database
+	pendingTokens map[string]bool
+	// tokenStoreStop stops the periodic writes of the token store, which
+	// closes tokenStoreDone once stopped
+	tokenStoreStop chan struct{}
+	tokenStoreDone chan struct{}
+	scopes         map[string]map[string]bool
+	tokensLock     sync.RWMutex
+	db             db.Database
 	adminToken     string
 	adminTokenLock sync.RWMutex
 	verboseAuthLog bool
+	methods        []*registeredMethod
+	methodsLock    sync.RWMutex
 }
 
 // BearerStandardAPIdata is the data type used by the BearerStandardAPI.
@@ -45,6 +60,12 @@ type BearerStandardAPI struct {
 type BearerStandardAPIdata struct {
 	Data      []byte
 	AuthToken string
+	// Owner is the entity ID the auth token acts on behalf of, if any.
+	// It is only set on private and quota methods.
+	Owner types.HexBytes
+
+	// pattern is the route pattern matched by the request
+	pattern string
 }
 
 // BearerStdAPIhandler is the handler function used by the bearer std API httprouter implementation
@@ -67,13 +88,20 @@ func NewBearerStandardAPI(router *httprouter.HTTProuter, baseRoute string) (*Bea
 	if len(baseRoute) > 1 {
 		baseRoute = strings.TrimSuffix(baseRoute, "/")
 	}
-	bsa := BearerStandardAPI{router: router, basePath: baseRoute}
+	bsa := BearerStandardAPI{
+		router:        router,
+		basePath:      baseRoute,
+		authTokens:    make(map[string]*AuthToken),
+		pendingTokens: make(map[string]bool),
+		scopes:        make(map[string]map[string]bool),
+	}
 	router.AddNamespace(namespace, &bsa)
 	return &bsa, nil
 }
 
 // AuthorizeRequest is a function for the RouterNamespace interface.
-// On private handlers checks if the supplied bearer token have still request credits
+// On private handlers checks if the supplied bearer token is valid for the method scope,
+// and on quota handlers if it has still request credits.
 func (b *BearerStandardAPI) AuthorizeRequest(data interface{},
 	accessType httprouter.AuthAccessType) (bool, error) {
 	msg, ok := data.(*BearerStandardAPIdata)
@@ -84,28 +112,57 @@ func (b *BearerStandardAPI) AuthorizeRequest(data interface{},
 	case httprouter.AccessTypeAdmin:
 		b.adminTokenLock.RLock()
 		defer b.adminTokenLock.RUnlock()
-		if msg.AuthToken != b.adminToken {
+		if b.adminToken == "" || msg.AuthToken != b.adminToken {
 			return false, fmt.Errorf("admin token not valid")
 		}
 		return true, nil
 	case httprouter.AccessTypePrivate:
-		_, ok = b.authTokens.Load(msg.AuthToken)
-		if !ok {
-			return false, fmt.Errorf("auth token not valid")
+		if err := b.authorizeToken(msg, false); err != nil {
+			return false, err
 		}
 		return true, nil
 	case httprouter.AccessTypeQuota:
-		remainingReqs, ok := b.authTokens.Load(msg.AuthToken)
-		if !ok || remainingReqs.(int64) < 1 {
-			return false, fmt.Errorf("no more requests available")
+		if err := b.authorizeToken(msg, true); err != nil {
+			return false, err
 		}
-		b.authTokens.Store(msg.AuthToken, remainingReqs.(int64)-1)
 		return true, nil
 	default:
 		return true, nil
 	}
 }
 
+// RateLimitKeys is a function for the RateLimitedNamespace interface.
+// Requests are identified by the route pattern and the bearer token, if it
+// is a valid one.
+func (b *BearerStandardAPI) RateLimitKeys(data interface{}) (method, token, signer string) {
+	msg, ok := data.(*BearerStandardAPIdata)
+	if !ok {
+		panic("type is not bearerStandardApi")
+	}
+	if b.validToken(msg.AuthToken) {
+		token = msg.AuthToken
+	}
+	return msg.pattern, token, ""
+}
+
+// validToken returns true if the bearer token is the admin token or an
+// issued auth token not expired.
+func (b *BearerStandardAPI) validToken(bearerToken string) bool {
+	if bearerToken == "" {
+		return false
+	}
+	b.adminTokenLock.RLock()
+	admin := b.adminToken
+	b.adminTokenLock.RUnlock()
+	if bearerToken == admin {
+		return true
+	}
+	b.tokensLock.RLock()
+	defer b.tokensLock.RUnlock()
+	token, ok := b.authTokens[TokenHash(bearerToken)]
+	return ok && !token.expired(time.Now())
+}
+
 // ProcessData is a function for the RouterNamespace interface.
 // The body of the http requests and the bearer auth token are readed.
 func (b *BearerStandardAPI) ProcessData(req *http.Request) (interface{}, error) {
@@ -120,6 +177,9 @@ func (b *BearerStandardAPI) ProcessData(req *http.Request) (interface{}, error)
 		Data:      reqBody,
 		AuthToken: strings.TrimPrefix(req.Header.Get("Authorization"), bearerPrefix),
 	}
+	if rctx := chi.RouteContext(req.Context()); rctx != nil {
+		msg.pattern = rctx.RoutePattern()
+	}
 	if b.verboseAuthLog && msg.AuthToken != "" {
 		fmt.Printf("[BearerAPI/%d/%s] %s {%s}\n", time.Now().Unix(), msg.AuthToken, req.URL.RequestURI(), reqBody)
 	}
@@ -130,8 +190,9 @@ func (b *BearerStandardAPI) ProcessData(req *http.Request) (interface{}, error)
 // The pattern URL can contain variable names by using braces, such as /send/{name}/hello
 // The pattern can also contain wildcard at the end of the path, such as /send/{name}/hello/*
 // The accessType can be of type private, public or admin.
+// The spec describes the method on the OpenAPI specification, it can be nil.
 func (b *BearerStandardAPI) RegisterMethod(pattern, HTTPmethod string,
-	accessType string, handler BearerStdAPIhandler) error {
+	accessType string, handler BearerStdAPIhandler, spec *MethodSpec) error {
 	if pattern[0] != '/' {
 		panic("pattern must start with /")
 	}
@@ -162,6 +223,14 @@ func (b *BearerStandardAPI) RegisterMethod(pattern, HTTPmethod string,
 	default:
 		return fmt.Errorf("method access type not implemented: %s", accessType)
 	}
+	b.methodsLock.Lock()
+	b.methods = append(b.methods, &registeredMethod{
+		pattern:    pattern,
+		HTTPmethod: HTTPmethod,
+		accessType: accessType,
+		spec:       spec,
+	})
+	b.methodsLock.Unlock()
 	log.Infof("registered %s %s method for path %s", HTTPmethod, accessType, path)
 	return nil
 }
@@ -174,22 +243,39 @@ func (b *BearerStandardAPI) SetAdminToken(bearerToken string) {
 }
 
 // AddAuthToken adds a new bearer token capable to perform up to n requests
+// on every private and quota method
 func (b *BearerStandardAPI) AddAuthToken(bearerToken string, requests int64) {
-	b.authTokens.Store(bearerToken, requests)
+	b.tokensLock.Lock()
+	defer b.tokensLock.Unlock()
+	hash := TokenHash(bearerToken)
+	b.authTokens[hash] = &AuthToken{
+		Hash:        hash,
+		Scopes:      []string{ScopeAll},
+		Requests:    requests,
+		MaxRequests: requests,
+	}
+	b.pendingTokens[hash] = true
+	if err := b.storeTokens(); err != nil {
+		log.Warnf("cannot store auth token: %v", err)
+	}
 }
 
 // DelAuthToken removes a bearer token (will be not longer valid)
 func (b *BearerStandardAPI) DelAuthToken(bearerToken string) {
-	b.authTokens.Delete(bearerToken)
+	if err := b.RevokeToken(TokenHash(bearerToken)); err != nil {
+		log.Debugf("cannot delete auth token: %v", err)
+	}
 }
 
 // GetAuthTokens returns the number of pending requests credits for a bearer token
 func (b *BearerStandardAPI) GetAuthTokens(bearerToken string) int64 {
-	ts, ok := b.authTokens.Load(bearerToken)
+	b.tokensLock.RLock()
+	defer b.tokensLock.RUnlock()
+	token, ok := b.authTokens[TokenHash(bearerToken)]
 	if !ok {
 		return 0
 	}
-	return ts.(int64)
+	return token.Requests
 }
 
 // EnableVerboseAuthLog prints on stdout the details of every request performed with auth token.
@@ -2,12 +2,15 @@ package bearerstdapi
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/db/metadb"
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/test/testcommon/testutil"
 )
@@ -28,25 +31,25 @@ func TestRouterWithBearerStdAPI(t *testing.T) {
 	stdAPI.RegisterMethod("/hello/*", "POST", MethodAccessTypePublic,
 		func(msg *BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
 			return ctx.Send([]byte("hello public!"), 200)
-		})
+		}, nil)
 
 	// Add an admin handler to serve requests on std namespace
 	stdAPI.RegisterMethod("/admin/*", "POST", MethodAccessTypeAdmin,
 		func(msg *BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
 			return ctx.Send([]byte("hello admin!"), 200)
-		})
+		}, nil)
 
 	// Add a private handler
 	stdAPI.RegisterMethod("/private/{name}", "POST", MethodAccessTypePrivate,
 		func(msg *BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
 			return ctx.Send([]byte(fmt.Sprintf("hello %s!", ctx.URLParam("name"))), 200)
-		})
+		}, nil)
 
 	// Add a quota handler
 	stdAPI.RegisterMethod("/quota/{name}", "POST", MethodAccessTypeQuota,
 		func(msg *BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
 			return ctx.Send([]byte(fmt.Sprintf("hello %s!", ctx.URLParam("name"))), 200)
-		})
+		}, nil)
 
 	// Set the bearer admin token
 	stdAPI.SetAdminToken("abcd")
@@ -91,6 +94,136 @@ func TestRouterWithBearerStdAPI(t *testing.T) {
 
 }
 
+func TestBearerStdAPITokens(t *testing.T) {
+	// a different prometheus ID avoids registering the metrics twice
+	r := httprouter.HTTProuter{PrometheusID: "bearer_tokens_test"}
+	rng := testutil.NewRandom(125)
+	port := 24100 + rng.RandomIntn(1024)
+	url := fmt.Sprintf("http://127.0.0.1:%d/api", port)
+	qt.Assert(t, r.Init("127.0.0.1", port), qt.IsNil)
+	stdAPI, err := NewBearerStandardAPI(&r, "/api")
+	qt.Assert(t, err, qt.IsNil)
+	database := metadb.NewTest(t)
+	qt.Assert(t, stdAPI.EnableTokenStore(database), qt.IsNil)
+	t.Cleanup(func() { qt.Check(t, stdAPI.CloseTokenStore(), qt.IsNil) })
+	qt.Assert(t, stdAPI.EnableTokenHandlers(), qt.IsNil)
+
+	hello := func(msg *BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+		return ctx.Send([]byte(fmt.Sprintf("hello %x!", msg.Owner)), 200)
+	}
+	qt.Assert(t, stdAPI.RegisterMethod("/census/{id}/add", "POST", MethodAccessTypeQuota, hello, nil), qt.IsNil)
+	qt.Assert(t, stdAPI.RegisterMethod("/wallet", "POST", MethodAccessTypeQuota, hello, nil), qt.IsNil)
+	qt.Assert(t, stdAPI.RegisterMethod("/private", "POST", MethodAccessTypePrivate, hello, nil), qt.IsNil)
+	stdAPI.AddScope("census", "/census/{id}/add")
+	stdAPI.AddScope("wallet", "/wallet")
+
+	// admin methods are disabled until an admin token is set
+	resp := doRequest(t, url+"/tokens/list", "", "GET", nil)
+	qt.Assert(t, string(resp), qt.Contains, "admin token not valid")
+	stdAPI.SetAdminToken("admin")
+
+	resp = doRequest(t, url+"/tokens/issue", "admin", "POST",
+		[]byte(`{"scopes":["unknown"],"requests":1}`))
+	qt.Assert(t, string(resp), qt.Contains, "unknown scope")
+	resp = doRequest(t, url+"/tokens/issue", "admin", "POST",
+		[]byte(`{"scopes":["census"],"requests":1,"owner":"0102"}`))
+	token := &AuthToken{}
+	qt.Assert(t, json.Unmarshal(resp, token), qt.IsNil)
+	qt.Assert(t, token.Token, qt.Not(qt.Equals), "")
+	qt.Assert(t, token.Hash, qt.Equals, TokenHash(token.Token))
+	qt.Assert(t, token.MaxRequests, qt.Equals, int64(1))
+
+	// the token can only call the methods in its scopes
+	resp = doRequest(t, url+"/census/abc/add", token.Token, "POST", nil)
+	qt.Assert(t, string(resp), qt.Equals, "hello 0102!\n")
+	resp = doRequest(t, url+"/census/abc/add", token.Token, "POST", nil)
+	qt.Assert(t, string(resp), qt.Contains, "no more requests available")
+	resp = doRequest(t, url+"/wallet", token.Token, "POST", nil)
+	qt.Assert(t, string(resp), qt.Contains, "auth token not allowed for this method")
+	resp = doRequest(t, url+"/private", token.Token, "POST", nil)
+	qt.Assert(t, string(resp), qt.Contains, "auth token not allowed for this method")
+
+	// only the valid tokens key the rate limits
+	_, limitToken, _ := stdAPI.RateLimitKeys(&BearerStandardAPIdata{AuthToken: token.Token})
+	qt.Assert(t, limitToken, qt.Equals, token.Token)
+	_, limitToken, _ = stdAPI.RateLimitKeys(&BearerStandardAPIdata{AuthToken: "made-up"})
+	qt.Assert(t, limitToken, qt.Equals, "")
+
+	// tokens without scopes are not allowed to call any method
+	unscoped, err := stdAPI.IssueToken(&AuthToken{Requests: 10})
+	qt.Assert(t, err, qt.IsNil)
+	resp = doRequest(t, url+"/private", unscoped.Token, "POST", nil)
+	qt.Assert(t, string(resp), qt.Contains, "auth token not allowed for this method")
+	resp = doRequest(t, url+"/wallet", unscoped.Token, "POST", nil)
+	qt.Assert(t, string(resp), qt.Contains, "auth token not allowed for this method")
+
+	// expired tokens are not valid
+	expired, err := stdAPI.IssueToken(&AuthToken{Scopes: []string{ScopeAll}, Requests: 10,
+		Expiry: time.Now().Add(-time.Minute).Unix()})
+	qt.Assert(t, err, qt.IsNil)
+	resp = doRequest(t, url+"/private", expired.Token, "POST", nil)
+	qt.Assert(t, string(resp), qt.Contains, "auth token expired")
+
+	// the requests spent are stored once the token store is closed, and only
+	// the token hashes are stored
+	wallet, err := stdAPI.IssueToken(&AuthToken{Scopes: []string{"wallet"}, Requests: 2})
+	qt.Assert(t, err, qt.IsNil)
+	resp = doRequest(t, url+"/wallet", wallet.Token, "POST", nil)
+	qt.Assert(t, string(resp), qt.Equals, "hello !\n")
+	restarted := &BearerStandardAPI{
+		authTokens:    make(map[string]*AuthToken),
+		pendingTokens: make(map[string]bool),
+		scopes:        make(map[string]map[string]bool),
+	}
+	qt.Assert(t, restarted.EnableTokenStore(database), qt.IsNil)
+	qt.Assert(t, restarted.GetAuthTokens(wallet.Token), qt.Equals, int64(2))
+	qt.Assert(t, restarted.CloseTokenStore(), qt.IsNil)
+	qt.Assert(t, stdAPI.CloseTokenStore(), qt.IsNil)
+	qt.Assert(t, restarted.EnableTokenStore(database), qt.IsNil)
+	t.Cleanup(func() { qt.Check(t, restarted.CloseTokenStore(), qt.IsNil) })
+	qt.Assert(t, restarted.Tokens(), qt.HasLen, 4)
+	qt.Assert(t, restarted.GetAuthTokens(wallet.Token), qt.Equals, int64(1))
+	qt.Assert(t, restarted.GetAuthTokens(token.Token), qt.Equals, int64(0))
+	qt.Assert(t, database.Iterate([]byte(tokenDBprefix), func(key, value []byte) bool {
+		qt.Assert(t, string(value), qt.Not(qt.Contains), token.Token)
+		return true
+	}), qt.IsNil)
+
+	resp = doRequest(t, url+"/tokens/list", "admin", "GET", nil)
+	var list struct {
+		Tokens []*AuthToken `json:"tokens"`
+	}
+	qt.Assert(t, json.Unmarshal(resp, &list), qt.IsNil)
+	qt.Assert(t, list.Tokens, qt.HasLen, 4)
+	for _, listed := range list.Tokens {
+		qt.Assert(t, listed.Token, qt.Equals, "")
+		qt.Assert(t, listed.Hash, qt.Not(qt.Equals), "")
+	}
+
+	// revoked tokens are not valid anymore
+	doRequest(t, url+"/tokens/"+expired.Hash, "admin", "GET", nil)
+	qt.Assert(t, stdAPI.Tokens(), qt.HasLen, 4)
+	doRequest(t, url+"/tokens/"+expired.Hash, "admin", "DELETE", nil)
+	qt.Assert(t, stdAPI.Tokens(), qt.HasLen, 3)
+	resp = doRequest(t, url+"/tokens/"+expired.Hash, "admin", "DELETE", nil)
+	qt.Assert(t, string(resp), qt.Contains, "auth token not found")
+}
+
+func TestAuthTokenRefill(t *testing.T) {
+	now := time.Unix(1e9, 0)
+	token := &AuthToken{Requests: 0, MaxRequests: 3, RefillRate: 2, LastRefill: now.Unix()}
+	// two requests per hour, half an hour credits one request
+	token.refill(now.Add(45 * time.Minute))
+	qt.Assert(t, token.Requests, qt.Equals, int64(1))
+	// the remaining 15 minutes are kept for the next refill
+	token.refill(now.Add(60 * time.Minute))
+	qt.Assert(t, token.Requests, qt.Equals, int64(2))
+	// the requests never exceed the maximum
+	token.refill(now.Add(10 * time.Hour))
+	qt.Assert(t, token.Requests, qt.Equals, int64(3))
+	qt.Assert(t, token.LastRefill, qt.Equals, now.Add(10*time.Hour).Unix())
+}
+
 func doRequest(t *testing.T, url, authToken, method string, body []byte) []byte {
 	req, err := http.NewRequest(method, url, bytes.NewReader(body))
 	qt.Check(t, err, qt.IsNil)
@@ -103,3 +236,32 @@ func doRequest(t *testing.T, url, authToken, method string, body []byte) []byte
 	qt.Check(t, err, qt.IsNil)
 	return respBody
 }
+
+func TestOpenAPIValidateResponse(t *testing.T) {
+	type reply struct {
+		Name  string   `json:"name"`
+		Count int      `json:"count,omitempty"`
+		Tags  []string `json:"tags,omitempty"`
+	}
+	b := &BearerStandardAPI{basePath: "/api"}
+	b.methods = []*registeredMethod{
+		{pattern: "/items/{id}", HTTPmethod: "GET", accessType: MethodAccessTypePublic,
+			spec: &MethodSpec{Response: &reply{}}},
+		{pattern: "/items/list", HTTPmethod: "GET", accessType: MethodAccessTypePublic,
+			spec: &MethodSpec{Response: &AuthTokenList{}}},
+	}
+	doc := b.OpenAPI("test", "v0")
+	validate := func(urlPath, body string) error {
+		return doc.ValidateResponse("GET", urlPath, 200, []byte(body))
+	}
+	qt.Assert(t, validate("/api/items/1", `{"name":"a","count":2,"tags":["x"]}`), qt.IsNil)
+	qt.Assert(t, validate("/api/items/1", `{"name":"a","tags":null}`), qt.IsNil)
+	qt.Assert(t, validate("/api/items/list", `{"tokens":[]}`), qt.IsNil)
+	// the drifts from the declared type are detected
+	qt.Assert(t, validate("/api/items/1", `{"count":2}`), qt.ErrorMatches, ".*missing required property name")
+	qt.Assert(t, validate("/api/items/1", `{"name":"a","other":1}`), qt.ErrorMatches, ".*unknown property other")
+	qt.Assert(t, validate("/api/items/1", `{"name":"a","count":"2"}`), qt.ErrorMatches, ".*expected an integer.*")
+	qt.Assert(t, validate("/api/items/1", `{"name":"a","tags":[1]}`), qt.ErrorMatches, ".*expected a string.*")
+	qt.Assert(t, validate("/api/items/list", `{"name":"a"}`), qt.IsNotNil)
+	qt.Assert(t, validate("/api/other", `{}`), qt.ErrorMatches, "no operation.*")
+}
@@ -0,0 +1,496 @@
+package bearerstdapi
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/types"
+)
+
+const (
+	// OpenAPIVersion is the version of the OpenAPI specification generated
+	OpenAPIVersion = "3.0.3"
+	// OpenAPIPath is the route, under the base path, serving the specification
+	OpenAPIPath = "/openapi.json"
+
+	openAPIsecurityScheme = "bearerAuth"
+)
+
+// MethodSpec describes a method for the OpenAPI specification.
+// Request and Response are values of the types (or pointers to them) decoded
+// from the request body and encoded in the reply; nil means no body.
+type MethodSpec struct {
+	Summary  string
+	Request  interface{}
+	Response interface{}
+}
+
+// registeredMethod keeps a method registered with RegisterMethod
+type registeredMethod struct {
+	pattern    string
+	HTTPmethod string
+	accessType string
+	spec       *MethodSpec
+}
+
+// OpenAPIDocument is an OpenAPI 3 specification
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+// OpenAPIInfo is the metadata of an OpenAPI specification
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIOperation describes a method on a path
+type OpenAPIOperation struct {
+	Summary     string                      `json:"summary,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+	// AccessType is the bearer access type of the method (public, quota,
+	// private or admin)
+	AccessType string `json:"x-access-type"`
+}
+
+// OpenAPIParameter describes a path parameter
+type OpenAPIParameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// OpenAPIRequestBody describes the body of a request
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse describes a reply
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIMediaType holds the schema of a body
+type OpenAPIMediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// OpenAPIComponents holds the schemas referenced by the operations
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema                `json:"schemas"`
+	SecuritySchemes map[string]*OpenAPISecurityScheme `json:"securitySchemes"`
+}
+
+// OpenAPISecurityScheme describes the bearer authentication
+type OpenAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+// Schema is the subset of the OpenAPI schema object used to describe the
+// JSON encoding of Go types. An empty schema matches any value.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+var (
+	pathParamRegexp = regexp.MustCompile(`{([^}]+)}`)
+
+	timeType          = reflect.TypeOf(time.Time{})
+	hexBytesType      = reflect.TypeOf(types.HexBytes{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// EnableOpenAPIHandler registers a public method serving the OpenAPI
+// specification of the registered methods on OpenAPIPath.
+func (b *BearerStandardAPI) EnableOpenAPIHandler(title, version string) error {
+	return b.RegisterMethod(
+		OpenAPIPath,
+		"GET",
+		MethodAccessTypePublic,
+		func(msg *BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+			data, err := json.Marshal(b.OpenAPI(title, version))
+			if err != nil {
+				return err
+			}
+			return ctx.Send(data, HTTPstatusCodeOK)
+		},
+		&MethodSpec{Summary: "OpenAPI specification of this API"},
+	)
+}
+
+// OpenAPI returns the OpenAPI specification of the registered methods.
+// The schemas of the request and response types are generated from their
+// JSON encoding.
+func (b *BearerStandardAPI) OpenAPI(title, version string) *OpenAPIDocument {
+	b.methodsLock.RLock()
+	defer b.methodsLock.RUnlock()
+	gen := &schemaGenerator{schemas: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+	doc := &OpenAPIDocument{
+		OpenAPI: OpenAPIVersion,
+		Info:    OpenAPIInfo{Title: title, Version: version},
+		Paths:   make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{
+			Schemas: gen.schemas,
+			SecuritySchemes: map[string]*OpenAPISecurityScheme{
+				openAPIsecurityScheme: {Type: "http", Scheme: "bearer"},
+			},
+		},
+	}
+	errorSchema := gen.schema(reflect.TypeOf(ErrorMsg{}))
+	for _, m := range b.methods {
+		p := path.Join(b.basePath, m.pattern)
+		op := &OpenAPIOperation{
+			AccessType: m.accessType,
+			Responses: map[string]*OpenAPIResponse{
+				"200": {Description: "OK"},
+				"400": {Description: "Error", Content: jsonContent(errorSchema)},
+			},
+		}
+		if tag := strings.SplitN(strings.TrimPrefix(m.pattern, "/"), "/", 2)[0]; tag != "" {
+			op.Tags = []string{tag}
+		}
+		for _, param := range pathParamRegexp.FindAllStringSubmatch(m.pattern, -1) {
+			op.Parameters = append(op.Parameters, &OpenAPIParameter{
+				Name: param[1], In: "path", Required: true, Schema: &Schema{Type: "string"},
+			})
+		}
+		if m.accessType != MethodAccessTypePublic {
+			op.Security = []map[string][]string{{openAPIsecurityScheme: {}}}
+			op.Responses["401"] = &OpenAPIResponse{Description: "Unauthorized"}
+		}
+		if m.spec != nil {
+			op.Summary = m.spec.Summary
+			if m.spec.Request != nil {
+				op.RequestBody = &OpenAPIRequestBody{
+					Required: true,
+					Content:  jsonContent(gen.schema(reflect.TypeOf(m.spec.Request))),
+				}
+			}
+			if m.spec.Response != nil {
+				op.Responses["200"].Content = jsonContent(gen.schema(reflect.TypeOf(m.spec.Response)))
+			}
+		}
+		if doc.Paths[p] == nil {
+			doc.Paths[p] = make(map[string]*OpenAPIOperation)
+		}
+		doc.Paths[p][strings.ToLower(m.HTTPmethod)] = op
+	}
+	return doc
+}
+
+// ValidateResponse checks a JSON reply of the method matching HTTPmethod and
+// urlPath against the schema of its status code on the specification, so the
+// replies of the handlers can be tested against their declared types.
+func (d *OpenAPIDocument) ValidateResponse(HTTPmethod, urlPath string, status int, body []byte) error {
+	op := d.operation(HTTPmethod, urlPath)
+	if op == nil {
+		return fmt.Errorf("no operation for %s %s", HTTPmethod, urlPath)
+	}
+	resp, ok := op.Responses[fmt.Sprintf("%d", status)]
+	if !ok {
+		return fmt.Errorf("status %d not specified for %s %s", status, HTTPmethod, urlPath)
+	}
+	media, ok := resp.Content["application/json"]
+	if !ok {
+		return nil
+	}
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return fmt.Errorf("cannot decode %s %s reply: %w", HTTPmethod, urlPath, err)
+	}
+	return d.validate(media.Schema, value, "reply")
+}
+
+// operation returns the operation of the specification matching the HTTP
+// method and URL path, preferring the paths with less parameters.
+func (d *OpenAPIDocument) operation(HTTPmethod, urlPath string) *OpenAPIOperation {
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	var match *OpenAPIOperation
+	matchParams := len(segments) + 1
+	for p, ops := range d.Paths {
+		op, ok := ops[strings.ToLower(HTTPmethod)]
+		if !ok {
+			continue
+		}
+		pattern := strings.Split(strings.Trim(p, "/"), "/")
+		if len(pattern) != len(segments) {
+			continue
+		}
+		params := 0
+		for i, segment := range pattern {
+			if pathParamRegexp.MatchString(segment) && segments[i] != "" {
+				params++
+			} else if segment != segments[i] {
+				params = -1
+				break
+			}
+		}
+		if params >= 0 && params < matchParams {
+			match, matchParams = op, params
+		}
+	}
+	return match
+}
+
+// validate checks a decoded JSON value against a schema.  Null values are
+// valid for any schema, as the nil pointers, slices and maps are encoded.
+func (d *OpenAPIDocument) validate(schema *Schema, value interface{}, field string) error {
+	if schema.Ref != "" {
+		ref, ok := d.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", field, schema.Ref)
+		}
+		schema = ref
+	}
+	if schema.Type == "" || value == nil {
+		return nil
+	}
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %T", field, value)
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required property %s", field, name)
+			}
+		}
+		for name, v := range obj {
+			prop, ok := schema.Properties[name]
+			if !ok {
+				prop = schema.AdditionalProperties
+			}
+			if prop == nil {
+				if schema.Properties == nil {
+					// custom encoding, any property is valid
+					continue
+				}
+				return fmt.Errorf("%s: unknown property %s", field, name)
+			}
+			if err := d.validate(prop, v, field+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %T", field, value)
+		}
+		if (schema.MinItems != nil && len(list) < *schema.MinItems) ||
+			(schema.MaxItems != nil && len(list) > *schema.MaxItems) {
+			return fmt.Errorf("%s: unexpected array length %d", field, len(list))
+		}
+		for i, v := range list {
+			if err := d.validate(schema.Items, v, fmt.Sprintf("%s[%d]", field, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected a string, got %T", field, value)
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok || strings.ContainsAny(n.String(), ".eE") {
+			return fmt.Errorf("%s: expected an integer, got %v", field, value)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return fmt.Errorf("%s: expected a number, got %T", field, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, got %T", field, value)
+		}
+	}
+	return nil
+}
+
+func jsonContent(schema *Schema) map[string]*OpenAPIMediaType {
+	return map[string]*OpenAPIMediaType{"application/json": {Schema: schema}}
+}
+
+// schemaGenerator builds the schemas of Go types, following the rules of
+// encoding/json. Named structs are added to the components and referenced.
+type schemaGenerator struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case hexBytesType:
+		return &Schema{Type: "string", Format: "hex"}
+	case rawMessageType:
+		return &Schema{}
+	}
+	if t.Kind() != reflect.Pointer && (t.Implements(jsonMarshalerType) ||
+		reflect.PointerTo(t).Implements(jsonMarshalerType)) {
+		// custom encoding, such as the protobuf JSON of a wrapped message
+		if t.Kind() == reflect.Struct {
+			return &Schema{Type: "object"}
+		}
+		return &Schema{}
+	}
+	if t.Kind() != reflect.Pointer && (t.Implements(textMarshalerType) ||
+		reflect.PointerTo(t).Implements(textMarshalerType)) {
+		return &Schema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Array:
+		n := t.Len()
+		return &Schema{Type: "array", Items: g.schema(t.Elem()), MinItems: &n, MaxItems: &n}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.componentName(t)
+			g.names[t] = name
+			// register the name before the fields, for recursive types
+			g.schemas[name] = &Schema{}
+			*g.schemas[name] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		// interfaces encode any value
+		return &Schema{}
+	}
+}
+
+// componentName returns a unique name for a struct type, prefixed with its
+// package name if another type has the same name.
+func (g *schemaGenerator) componentName(t reflect.Type) string {
+	if _, ok := g.schemas[t.Name()]; !ok {
+		return t.Name()
+	}
+	pkg := path.Base(t.PkgPath())
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + t.Name()
+}
+
+// structSchema returns the object schema of the exported fields of a struct.
+// The fields of embedded structs are promoted unless shadowed.
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, ft)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if hasOption(opts, "string") {
+			s.Properties[name] = &Schema{Type: "string"}
+		} else {
+			s.Properties[name] = g.schema(f.Type)
+		}
+		if !hasOption(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	for _, et := range embedded {
+		es := g.structSchema(et)
+		for name, prop := range es.Properties {
+			if _, ok := s.Properties[name]; !ok {
+				s.Properties[name] = prop
+			}
+		}
+		for _, name := range es.Required {
+			if !hasString(s.Required, name) {
+				s.Required = append(s.Required, name)
+			}
+		}
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+func hasOption(opts, option string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+func hasString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
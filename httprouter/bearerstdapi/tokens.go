@@ -0,0 +1,398 @@
+package bearerstdapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+)
+
+const (
+	// ScopeAll grants access to every private and quota method.
+	ScopeAll = "*"
+
+	tokenDBprefix = "token/"
+	// tokenStoreInterval is the time between the writes of the token
+	// requests and refills to the database.
+	tokenStoreInterval = time.Minute
+)
+
+// AuthToken is a bearer token allowed to call private and quota methods.
+type AuthToken struct {
+	// Token is the bearer token.  It is only returned when the token is
+	// issued, the token store and the token list only keep its hash.
+	Token string `json:"token,omitempty"`
+	// Hash is the hex encoded SHA-256 hash of the token, which identifies
+	// it on the token list and to revoke it.
+	Hash string `json:"hash,omitempty"`
+	// Scopes restricts the token to the methods registered on them.
+	// A token without scopes cannot call any private or quota method.
+	Scopes []string `json:"scopes,omitempty"`
+	// Owner is the entity ID the token acts on behalf of, if any.
+	// Handlers can use it to restrict the organizations a token can manage.
+	Owner types.HexBytes `json:"owner,omitempty"`
+	// Expiry is the unix timestamp after which the token is not valid.
+	// Zero means the token never expires.
+	Expiry int64 `json:"expiry,omitempty"`
+	// Requests is the number of quota requests left.
+	Requests int64 `json:"requests"`
+	// MaxRequests is the maximum number of requests a refill can reach.
+	MaxRequests int64 `json:"maxRequests,omitempty"`
+	// RefillRate is the number of requests credited every hour, up to
+	// MaxRequests.
+	RefillRate int64 `json:"refillRate,omitempty"`
+	// LastRefill is the unix timestamp of the last refill.
+	LastRefill int64 `json:"lastRefill,omitempty"`
+}
+
+// AuthTokenList is the reply of the method listing the auth tokens.
+type AuthTokenList struct {
+	Tokens []*AuthToken `json:"tokens"`
+}
+
+// TokenHash returns the hex encoded SHA-256 hash of a bearer token.
+func TokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// allowed returns true if one of the token scopes includes the route pattern.
+func (t *AuthToken) allowed(scopes map[string]map[string]bool, pattern string) bool {
+	for _, scope := range t.Scopes {
+		if scope == ScopeAll || scopes[scope][pattern] {
+			return true
+		}
+	}
+	return false
+}
+
+// expired returns true if the token expiry is before now.
+func (t *AuthToken) expired(now time.Time) bool {
+	return t.Expiry > 0 && now.Unix() > t.Expiry
+}
+
+// refill credits the requests accumulated since the last refill.
+func (t *AuthToken) refill(now time.Time) {
+	if t.RefillRate <= 0 || t.Requests >= t.MaxRequests {
+		t.LastRefill = now.Unix()
+		return
+	}
+	credits := (now.Unix() - t.LastRefill) * t.RefillRate / 3600
+	if credits <= 0 {
+		return
+	}
+	t.Requests += credits
+	// keep the time elapsed since the last credit for the next refill
+	t.LastRefill += credits * 3600 / t.RefillRate
+	if t.Requests >= t.MaxRequests {
+		t.Requests = t.MaxRequests
+		t.LastRefill = now.Unix()
+	}
+}
+
+// EnableTokenStore keeps the auth tokens on database, so they survive
+// restarts, and loads the tokens already stored.  The requests spent and
+// refilled are written every tokenStoreInterval and by CloseTokenStore.
+func (b *BearerStandardAPI) EnableTokenStore(database db.Database) error {
+	tokens := make(map[string]*AuthToken)
+	var err error
+	if ierr := database.Iterate([]byte(tokenDBprefix), func(_, value []byte) bool {
+		token := &AuthToken{}
+		if err = json.Unmarshal(value, token); err != nil {
+			return false
+		}
+		tokens[token.Hash] = token
+		return true
+	}); ierr != nil {
+		return ierr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot decode auth token: %w", err)
+	}
+	b.tokensLock.Lock()
+	defer b.tokensLock.Unlock()
+	// the tokens issued before are written on the database
+	for hash, token := range b.authTokens {
+		if _, ok := tokens[hash]; !ok {
+			tokens[hash] = token
+			b.pendingTokens[hash] = true
+		}
+	}
+	b.authTokens = tokens
+	b.db = database
+	if err := b.storeTokens(); err != nil {
+		return err
+	}
+	if b.tokenStoreStop == nil {
+		b.tokenStoreStop, b.tokenStoreDone = make(chan struct{}), make(chan struct{})
+		go b.storeTokensLoop(b.tokenStoreStop, b.tokenStoreDone)
+	}
+	log.Infof("loaded %d auth tokens", len(tokens))
+	return nil
+}
+
+// storeTokensLoop writes the pending tokens to the database periodically,
+// until stop is closed.
+func (b *BearerStandardAPI) storeTokensLoop(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(tokenStoreInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			b.tokensLock.Lock()
+			err := b.storeTokens()
+			b.tokensLock.Unlock()
+			if err != nil {
+				log.Warnf("cannot store auth tokens: %v", err)
+			}
+		}
+	}
+}
+
+// CloseTokenStore stops the periodic writes of the token store and writes
+// the requests spent and refilled since the last write.  It should be called
+// before the process exits.
+func (b *BearerStandardAPI) CloseTokenStore() error {
+	b.tokensLock.Lock()
+	stop, done := b.tokenStoreStop, b.tokenStoreDone
+	b.tokenStoreStop, b.tokenStoreDone = nil, nil
+	b.tokensLock.Unlock()
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+	b.tokensLock.Lock()
+	defer b.tokensLock.Unlock()
+	return b.storeTokens()
+}
+
+// storeTokens writes the pending tokens to the database, if enabled.
+// The caller must hold tokensLock.
+func (b *BearerStandardAPI) storeTokens() error {
+	if b.db == nil || len(b.pendingTokens) == 0 {
+		return nil
+	}
+	wtx := b.db.WriteTx()
+	defer wtx.Discard()
+	for hash := range b.pendingTokens {
+		token, ok := b.authTokens[hash]
+		if !ok {
+			continue
+		}
+		value, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		if err := wtx.Set([]byte(tokenDBprefix+hash), value); err != nil {
+			return err
+		}
+	}
+	if err := wtx.Commit(); err != nil {
+		return err
+	}
+	b.pendingTokens = make(map[string]bool)
+	return nil
+}
+
+// deleteToken removes the token from the database, if enabled.
+// The caller must hold tokensLock.
+func (b *BearerStandardAPI) deleteToken(key string) error {
+	if b.db == nil {
+		return nil
+	}
+	wtx := b.db.WriteTx()
+	defer wtx.Discard()
+	if err := wtx.Delete([]byte(key)); err != nil {
+		return err
+	}
+	return wtx.Commit()
+}
+
+// IssueToken adds a new auth token. If token.Token is empty a random UUID is
+// generated. MaxRequests defaults to the initial number of requests.
+// The returned copy is the only one holding the token, only its hash is kept.
+func (b *BearerStandardAPI) IssueToken(token *AuthToken) (*AuthToken, error) {
+	issued := *token
+	if issued.Token == "" {
+		issued.Token = uuid.New().String()
+	}
+	issued.Hash = TokenHash(issued.Token)
+	if issued.MaxRequests < issued.Requests {
+		issued.MaxRequests = issued.Requests
+	}
+	issued.LastRefill = time.Now().Unix()
+	b.tokensLock.Lock()
+	defer b.tokensLock.Unlock()
+	for _, scope := range issued.Scopes {
+		if _, ok := b.scopes[scope]; !ok && scope != ScopeAll {
+			return nil, fmt.Errorf("unknown scope %s", scope)
+		}
+	}
+	if _, ok := b.authTokens[issued.Hash]; ok {
+		return nil, fmt.Errorf("auth token already exists")
+	}
+	stored := issued
+	stored.Token = ""
+	b.authTokens[stored.Hash] = &stored
+	b.pendingTokens[stored.Hash] = true
+	if err := b.storeTokens(); err != nil {
+		delete(b.authTokens, stored.Hash)
+		delete(b.pendingTokens, stored.Hash)
+		return nil, err
+	}
+	return &issued, nil
+}
+
+// RevokeToken removes the auth token with the given hash, as listed by
+// Tokens, which will be no longer valid.
+func (b *BearerStandardAPI) RevokeToken(hash string) error {
+	b.tokensLock.Lock()
+	defer b.tokensLock.Unlock()
+	if _, ok := b.authTokens[hash]; !ok {
+		return fmt.Errorf("auth token not found")
+	}
+	if err := b.deleteToken(tokenDBprefix + hash); err != nil {
+		return err
+	}
+	delete(b.authTokens, hash)
+	delete(b.pendingTokens, hash)
+	return nil
+}
+
+// Tokens returns a copy of all the auth tokens, sorted by hash.
+func (b *BearerStandardAPI) Tokens() []*AuthToken {
+	b.tokensLock.RLock()
+	defer b.tokensLock.RUnlock()
+	tokens := make([]*AuthToken, 0, len(b.authTokens))
+	for _, token := range b.authTokens {
+		t := *token
+		tokens = append(tokens, &t)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Hash < tokens[j].Hash })
+	return tokens
+}
+
+// AddScope defines a scope granting access to the methods registered with the
+// given patterns (as passed to RegisterMethod).
+func (b *BearerStandardAPI) AddScope(scope string, patterns ...string) {
+	b.tokensLock.Lock()
+	defer b.tokensLock.Unlock()
+	if b.scopes[scope] == nil {
+		b.scopes[scope] = make(map[string]bool)
+	}
+	for _, pattern := range patterns {
+		b.scopes[scope][path.Join(b.basePath, pattern)] = true
+	}
+}
+
+// authorizeToken checks the token is valid for the route pattern and, if
+// consume is true, spends one of its requests.
+func (b *BearerStandardAPI) authorizeToken(msg *BearerStandardAPIdata, consume bool) error {
+	b.tokensLock.Lock()
+	defer b.tokensLock.Unlock()
+	token, ok := b.authTokens[TokenHash(msg.AuthToken)]
+	if !ok {
+		if consume {
+			return fmt.Errorf("no more requests available")
+		}
+		return fmt.Errorf("auth token not valid")
+	}
+	now := time.Now()
+	if token.expired(now) {
+		return fmt.Errorf("auth token expired")
+	}
+	if !token.allowed(b.scopes, msg.pattern) {
+		return fmt.Errorf("auth token not allowed for this method")
+	}
+	msg.Owner = token.Owner
+	if !consume {
+		return nil
+	}
+	token.refill(now)
+	if token.Requests < 1 {
+		return fmt.Errorf("no more requests available")
+	}
+	token.Requests--
+	b.pendingTokens[token.Hash] = true
+	return nil
+}
+
+// EnableTokenHandlers registers the admin methods to issue, list and revoke
+// auth tokens.
+func (b *BearerStandardAPI) EnableTokenHandlers() error {
+	if err := b.RegisterMethod(
+		"/tokens/issue",
+		"POST",
+		MethodAccessTypeAdmin,
+		b.tokenIssueHandler,
+		&MethodSpec{Summary: "Issue a new auth token", Request: &AuthToken{}, Response: &AuthToken{}},
+	); err != nil {
+		return err
+	}
+	if err := b.RegisterMethod(
+		"/tokens/list",
+		"GET",
+		MethodAccessTypeAdmin,
+		b.tokenListHandler,
+		&MethodSpec{Summary: "List all the auth tokens", Response: &AuthTokenList{}},
+	); err != nil {
+		return err
+	}
+	return b.RegisterMethod(
+		"/tokens/{hash}",
+		"DELETE",
+		MethodAccessTypeAdmin,
+		b.tokenRevokeHandler,
+		&MethodSpec{Summary: "Revoke an auth token by its hash"},
+	)
+}
+
+// POST /tokens/issue
+// issue a new auth token
+func (b *BearerStandardAPI) tokenIssueHandler(msg *BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+	token := &AuthToken{}
+	if err := json.Unmarshal(msg.Data, token); err != nil {
+		return fmt.Errorf("could not unmarshal JSON: %w", err)
+	}
+	token, err := b.IssueToken(token)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, HTTPstatusCodeOK)
+}
+
+// GET /tokens/list
+// list the hashes and quotas of all the auth tokens
+func (b *BearerStandardAPI) tokenListHandler(msg *BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+	data, err := json.Marshal(&AuthTokenList{Tokens: b.Tokens()})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, HTTPstatusCodeOK)
+}
+
+// DELETE /tokens/{hash}
+// revoke an auth token
+func (b *BearerStandardAPI) tokenRevokeHandler(msg *BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+	if err := b.RevokeToken(ctx.URLParam("hash")); err != nil {
+		return err
+	}
+	return ctx.Send(nil, HTTPstatusCodeOK)
+}
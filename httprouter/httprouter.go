@@ -44,6 +44,9 @@ type HTTProuter struct {
 	address        net.Addr
 	namespaces     map[string]RouterNamespace
 	namespacesLock sync.RWMutex
+	limiters       map[string]*namespaceLimiter
+	trustedProxies []*net.IPNet
+	limitersLock   sync.RWMutex
 }
 
 type AuthAccessType int
@@ -238,11 +241,45 @@ func (r *HTTProuter) routerHandler(namespaceID string, accessType AuthAccessType
 			log.Errorf("namespace %s is not defined", namespaceID)
 			return
 		}
+
+		// Apply the namespace limits, the ones keyed by IP before
+		// processing the request in order to reject floods early.
+		limiter := r.getLimiter(namespaceID)
+		ip := r.remoteIP(req)
+		if limiter != nil {
+			if max := limiter.limits.MaxRequestSize; max > 0 {
+				if req.ContentLength > max {
+					LimitedRequests.WithLabelValues(namespaceID, "", "size").Inc()
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				req.Body = http.MaxBytesReader(w, req.Body, max)
+			}
+			if !limiter.acquire() {
+				sendLimited(w, namespaceID, "", "concurrency", time.Second)
+				return
+			}
+			defer limiter.release()
+			if ok, wait := limiter.allow(ip, "", "", "", false); !ok {
+				sendLimited(w, namespaceID, "", "rate", wait)
+				return
+			}
+		}
 		data, err := nsProcessor.ProcessData(req)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if limiter != nil {
+			var method, token, signer string
+			if rns, ok := nsProcessor.(RateLimitedNamespace); ok {
+				method, token, signer = rns.RateLimitKeys(data)
+			}
+			if ok, wait := limiter.allow(ip, method, token, signer, true); !ok {
+				sendLimited(w, namespaceID, method, "rate", wait)
+				return
+			}
+		}
 		if ok, err := nsProcessor.AuthorizeRequest(data, accessType); !ok {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
@@ -111,6 +111,19 @@ func (s *SignedJRPC) AuthorizeRequest(data interface{},
 	}
 }
 
+// RateLimitKeys implements the httprouter RateLimitedNamespace interface.
+// Requests are identified by their method and recovered signer address.
+func (s *SignedJRPC) RateLimitKeys(data interface{}) (method, token, signer string) {
+	request, ok := data.(*SignedJRPCdata)
+	if !ok {
+		panic("type is not SignedJRPCdata")
+	}
+	if len(request.SignaturePublicKey) > 0 {
+		signer = request.Address.Hex()
+	}
+	return request.Method, "", signer
+}
+
 // ProcessData implements the httprouter interface
 func (s *SignedJRPC) ProcessData(req *http.Request) (interface{}, error) {
 	reqBody, err := io.ReadAll(req.Body)
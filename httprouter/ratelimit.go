@@ -0,0 +1,327 @@
+package httprouter
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitKey defines the client identifier a rate limit is applied to.
+type RateLimitKey int
+
+const (
+	// RateLimitByIP applies the limit to each client IP address
+	RateLimitByIP RateLimitKey = iota
+	// RateLimitByToken applies the limit to each bearer token.
+	// Requests without token are limited by IP address.
+	RateLimitByToken
+	// RateLimitBySigner applies the limit to each recovered signer address,
+	// and to the IP address too, so a client cannot get a new limit by
+	// signing with a new key.  Requests without signature are limited by IP
+	// address.
+	RateLimitBySigner
+)
+
+const (
+	// limiterIdleTimeout is the time after which an unused limiter is removed
+	limiterIdleTimeout = 10 * time.Minute
+	// maxClientLimiters caps the clients tracked by each namespace.  Once
+	// reached, the new clients share a single limiter per rate limit.
+	maxClientLimiters = 100000
+)
+
+// LimitedRequests counts the requests rejected by the namespace limits
+var LimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "http",
+	Name:      "limited_requests",
+	Help:      "The number of requests rejected by the rate, concurrency and size limits",
+}, []string{"namespace", "method", "reason"})
+
+// RateLimit is a token bucket limit: each client can perform Burst requests at
+// once, refilled at Rate requests per second.
+type RateLimit struct {
+	// Method restricts the limit to a namespace method (such as the JSON-RPC
+	// method or the bearer API route pattern). Empty applies to all methods.
+	Method string
+	Key    RateLimitKey
+	Rate   float64
+	Burst  int
+}
+
+// NamespaceLimits configures the abuse protection of a namespace.
+// Zero values mean no limit.
+type NamespaceLimits struct {
+	// MaxRequestSize is the maximum size of a request body, in bytes
+	MaxRequestSize int64
+	// MaxConcurrentRequests caps the requests handled at the same time
+	MaxConcurrentRequests int
+	RateLimits            []RateLimit
+}
+
+// RateLimitedNamespace can be implemented by a RouterNamespace in order to
+// apply rate limits per method, bearer token or signer. Otherwise only the
+// rate limits for all methods keyed by IP address are applied.
+type RateLimitedNamespace interface {
+	// RateLimitKeys returns the method called by a request processed by
+	// ProcessData, and the bearer token and signer address of the client,
+	// if any.  The token and signer must be validated by the namespace,
+	// otherwise any client could get a new limit by making up a key.
+	RateLimitKeys(data interface{}) (method, token, signer string)
+}
+
+// namespaceLimiter enforces the NamespaceLimits of a namespace
+type namespaceLimiter struct {
+	limits    NamespaceLimits
+	semaphore chan struct{}
+
+	lock     sync.Mutex
+	limiters map[string]*clientLimiter
+	// maxLimiters caps the size of limiters
+	maxLimiters int
+	lastGC      time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ParseRateLimit parses a rate limit with the format
+// [method:]rate:burst[:ip|token|signer], such as submitRawTx:0.5:5:signer.
+func ParseRateLimit(s string) (RateLimit, error) {
+	limit := RateLimit{}
+	parts := strings.Split(s, ":")
+	switch key := parts[len(parts)-1]; key {
+	case "ip", "token", "signer":
+		limit.Key = map[string]RateLimitKey{
+			"ip": RateLimitByIP, "token": RateLimitByToken, "signer": RateLimitBySigner,
+		}[key]
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) == 3 {
+		limit.Method = parts[0]
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return limit, fmt.Errorf("invalid rate limit %q", s)
+	}
+	var err error
+	if limit.Rate, err = strconv.ParseFloat(parts[0], 64); err != nil || limit.Rate <= 0 {
+		return limit, fmt.Errorf("invalid rate limit %q: bad rate", s)
+	}
+	if limit.Burst, err = strconv.Atoi(parts[1]); err != nil || limit.Burst <= 0 {
+		return limit, fmt.Errorf("invalid rate limit %q: bad burst", s)
+	}
+	return limit, nil
+}
+
+// ParseNamespaceRateLimit parses a rate limit applied to a single namespace,
+// with the format namespace=[method:]rate:burst[:ip|token|signer], such as
+// bearerStd=/v2/census/{id}/add:1:10:token.
+func ParseNamespaceRateLimit(s string) (string, RateLimit, error) {
+	namespaceID, limit, ok := strings.Cut(s, "=")
+	if !ok || namespaceID == "" {
+		return "", RateLimit{}, fmt.Errorf("invalid namespace rate limit %q", s)
+	}
+	rl, err := ParseRateLimit(limit)
+	return namespaceID, rl, err
+}
+
+// SetNamespaceLimits configures the limits of a namespace. If namespaceID is
+// empty, the limits apply to the namespaces without their own limits.
+func (r *HTTProuter) SetNamespaceLimits(namespaceID string, limits *NamespaceLimits) {
+	r.limitersLock.Lock()
+	defer r.limitersLock.Unlock()
+	if r.limiters == nil {
+		r.limiters = make(map[string]*namespaceLimiter)
+	}
+	if limits == nil {
+		delete(r.limiters, namespaceID)
+		return
+	}
+	nl := &namespaceLimiter{
+		limits:      *limits,
+		limiters:    make(map[string]*clientLimiter),
+		maxLimiters: maxClientLimiters,
+	}
+	if limits.MaxConcurrentRequests > 0 {
+		nl.semaphore = make(chan struct{}, limits.MaxConcurrentRequests)
+	}
+	r.limiters[namespaceID] = nl
+}
+
+func (r *HTTProuter) getLimiter(namespaceID string) *namespaceLimiter {
+	r.limitersLock.RLock()
+	defer r.limitersLock.RUnlock()
+	if nl, ok := r.limiters[namespaceID]; ok {
+		return nl
+	}
+	return r.limiters[""]
+}
+
+// acquire takes a concurrency slot, returning false if all are in use.
+func (nl *namespaceLimiter) acquire() bool {
+	if nl.semaphore == nil {
+		return true
+	}
+	select {
+	case nl.semaphore <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (nl *namespaceLimiter) release() {
+	if nl.semaphore != nil {
+		<-nl.semaphore
+	}
+}
+
+// allow applies the rate limits matching the request. If processed is false,
+// the namespace data is not yet available and only the limits for all methods
+// keyed by IP address are applied. It returns the time to wait before retrying
+// if the request is rejected.
+func (nl *namespaceLimiter) allow(ip, method, token, signer string, processed bool) (bool, time.Duration) {
+	nl.lock.Lock()
+	defer nl.lock.Unlock()
+	now := time.Now()
+	nl.gc(now)
+	var reservations []*rate.Reservation
+	for i, limit := range nl.limits.RateLimits {
+		ipOnly := limit.Method == "" && limit.Key == RateLimitByIP
+		if ipOnly == processed {
+			continue
+		}
+		if limit.Method != "" && limit.Method != method {
+			continue
+		}
+		clients := []string{"ip/" + ip}
+		switch {
+		case limit.Key == RateLimitByToken && token != "":
+			clients = []string{"token/" + token}
+		case limit.Key == RateLimitBySigner && signer != "":
+			// anyone can sign with a new key, so the IP limit applies too
+			clients = append(clients, "signer/"+signer)
+		}
+		for _, client := range clients {
+			reservation := nl.reserve(fmt.Sprintf("%d/%s", i, client), i, limit, now)
+			reservations = append(reservations, reservation)
+			if delay := reservation.DelayFrom(now); !reservation.OK() || delay > 0 {
+				// give back the tokens, the request is not performed
+				for _, r := range reservations {
+					r.CancelAt(now)
+				}
+				return false, delay
+			}
+		}
+	}
+	return true, 0
+}
+
+// reserve takes a token from the limiter of the client key of the limit with
+// index i.  The caller must hold lock.
+func (nl *namespaceLimiter) reserve(key string, i int, limit RateLimit, now time.Time) *rate.Reservation {
+	cl, ok := nl.limiters[key]
+	if !ok && len(nl.limiters) >= nl.maxLimiters {
+		key = fmt.Sprintf("%d/overflow", i)
+		cl, ok = nl.limiters[key]
+	}
+	if !ok {
+		cl = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		nl.limiters[key] = cl
+	}
+	cl.lastSeen = now
+	return cl.limiter.ReserveN(now, 1)
+}
+
+// gc removes the limiters not used for a while, checked periodically and
+// more often when the limiters are full. The caller must hold lock.
+func (nl *namespaceLimiter) gc(now time.Time) {
+	elapsed := now.Sub(nl.lastGC)
+	if elapsed < limiterIdleTimeout && (len(nl.limiters) < nl.maxLimiters || elapsed < time.Minute) {
+		return
+	}
+	for key, cl := range nl.limiters {
+		if now.Sub(cl.lastSeen) > limiterIdleTimeout {
+			delete(nl.limiters, key)
+		}
+	}
+	nl.lastGC = now
+}
+
+// SetTrustedProxies sets the networks of the reverse proxies in front of the
+// router, given in CIDR notation.  The client IP address of the requests
+// coming from them is taken from the X-Forwarded-For header.
+func (r *HTTProuter) SetTrustedProxies(cidrs []string) error {
+	var proxies []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy: %w", err)
+		}
+		proxies = append(proxies, network)
+	}
+	r.limitersLock.Lock()
+	defer r.limitersLock.Unlock()
+	r.trustedProxies = proxies
+	return nil
+}
+
+// trustedProxy returns true if ip belongs to a trusted proxy network.
+func (r *HTTProuter) trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	r.limitersLock.RLock()
+	defer r.limitersLock.RUnlock()
+	for _, network := range r.trustedProxies {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the client IP address of req.  If the request comes from a
+// trusted proxy, the client address is the last one of X-Forwarded-For not
+// added by a trusted proxy, since the previous ones can be forged.
+func (r *HTTProuter) remoteIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	if !r.trustedProxy(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !r.trustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// sendLimited replies with 429 Too Many Requests
+func sendLimited(w http.ResponseWriter, namespaceID, method, reason string, retryAfter time.Duration) {
+	LimitedRequests.WithLabelValues(namespaceID, method, reason).Inc()
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	http.Error(w, reason+" limit exceeded", http.StatusTooManyRequests)
+}
@@ -0,0 +1,152 @@
+package httprouter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// testNamespace identifies requests by the method and signer headers
+type testNamespace struct{}
+
+func (testNamespace) AuthorizeRequest(data interface{}, accessType AuthAccessType) (bool, error) {
+	return true, nil
+}
+
+func (testNamespace) ProcessData(req *http.Request) (interface{}, error) {
+	if _, err := io.ReadAll(req.Body); err != nil {
+		return nil, err
+	}
+	return req.Header, nil
+}
+
+func (testNamespace) RateLimitKeys(data interface{}) (method, token, signer string) {
+	header := data.(http.Header)
+	return header.Get("Method"), "", header.Get("Signer")
+}
+
+func TestParseRateLimit(t *testing.T) {
+	for s, want := range map[string]RateLimit{
+		"10:50":                         {Rate: 10, Burst: 50},
+		"0.5:5:signer":                  {Rate: 0.5, Burst: 5, Key: RateLimitBySigner},
+		"submitRawTx:1:2":               {Method: "submitRawTx", Rate: 1, Burst: 2},
+		"/v2/census/{id}/add:1:2:token": {Method: "/v2/census/{id}/add", Rate: 1, Burst: 2, Key: RateLimitByToken},
+	} {
+		limit, err := ParseRateLimit(s)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, limit, qt.Equals, want)
+	}
+	for _, s := range []string{"", "10", "a:b:c:d:e", "0:5", "1:0", "1:2:user"} {
+		_, err := ParseRateLimit(s)
+		qt.Assert(t, err, qt.IsNotNil, qt.Commentf("%q", s))
+	}
+}
+
+func TestParseNamespaceRateLimit(t *testing.T) {
+	namespaceID, limit, err := ParseNamespaceRateLimit("bearerStd=/v2/census/{id}/add:1:2:token")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, namespaceID, qt.Equals, "bearerStd")
+	qt.Assert(t, limit, qt.Equals, RateLimit{Method: "/v2/census/{id}/add", Rate: 1, Burst: 2, Key: RateLimitByToken})
+	for _, s := range []string{"10:50", "=10:50", "rpcAPI=10"} {
+		_, _, err := ParseNamespaceRateLimit(s)
+		qt.Assert(t, err, qt.IsNotNil, qt.Commentf("%q", s))
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	r := &HTTProuter{}
+	qt.Assert(t, r.SetTrustedProxies([]string{"10.0.0.0/8"}), qt.IsNil)
+	qt.Assert(t, r.SetTrustedProxies([]string{"10.0.0.1"}), qt.IsNotNil)
+	qt.Assert(t, r.SetTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1/32"}), qt.IsNil)
+	remoteIP := func(remoteAddr string, forwarded ...string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		for _, f := range forwarded {
+			req.Header.Add("X-Forwarded-For", f)
+		}
+		return r.remoteIP(req)
+	}
+	// the header is ignored if the request does not come from a trusted proxy
+	qt.Assert(t, remoteIP("1.2.3.4:1234", "5.6.7.8"), qt.Equals, "1.2.3.4")
+	qt.Assert(t, remoteIP("10.0.0.1:1234"), qt.Equals, "10.0.0.1")
+	qt.Assert(t, remoteIP("10.0.0.1:1234", "5.6.7.8"), qt.Equals, "5.6.7.8")
+	// the addresses before the last untrusted one can be forged
+	qt.Assert(t, remoteIP("10.0.0.1:1234", "9.9.9.9, 5.6.7.8, 192.168.1.1"), qt.Equals, "5.6.7.8")
+	qt.Assert(t, remoteIP("10.0.0.1:1234", "9.9.9.9", "5.6.7.8"), qt.Equals, "5.6.7.8")
+	qt.Assert(t, remoteIP("10.0.0.1:1234", "garbage, 10.0.0.2"), qt.Equals, "10.0.0.2")
+}
+
+func TestNamespaceLimitsMaxClients(t *testing.T) {
+	r := &HTTProuter{}
+	r.SetNamespaceLimits("", &NamespaceLimits{RateLimits: []RateLimit{{Rate: 0.001, Burst: 1}}})
+	nl := r.getLimiter("test")
+	nl.maxLimiters = 2
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		ok, _ := nl.allow(ip, "", "", "", false)
+		qt.Assert(t, ok, qt.IsTrue)
+	}
+	// once full, the new clients share a limiter
+	ok, _ := nl.allow("10.0.0.3", "", "", "", false)
+	qt.Assert(t, ok, qt.IsTrue)
+	ok, _ = nl.allow("10.0.0.4", "", "", "", false)
+	qt.Assert(t, ok, qt.IsFalse)
+	qt.Assert(t, nl.limiters, qt.HasLen, 3)
+}
+
+func TestNamespaceLimits(t *testing.T) {
+	r := &HTTProuter{namespaces: map[string]RouterNamespace{"test": testNamespace{}}}
+	handler := r.routerHandler("test", AccessTypePublic, func(msg Message) {
+		msg.Context.Send(nil, http.StatusOK)
+	})
+	do := func(ip, method, signer, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("Method", method)
+		req.Header.Set("Signer", signer)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// without limits every request is served
+	for i := 0; i < 5; i++ {
+		qt.Assert(t, do("10.0.0.1", "genProof", "", "").Code, qt.Equals, http.StatusOK)
+	}
+
+	r.SetNamespaceLimits("", &NamespaceLimits{
+		MaxRequestSize: 8,
+		RateLimits: []RateLimit{
+			{Rate: 0.001, Burst: 4},
+			{Method: "submitRawTx", Key: RateLimitBySigner, Rate: 0.001, Burst: 1},
+		},
+	})
+	qt.Assert(t, do("10.0.0.1", "genProof", "", "too large body").Code,
+		qt.Equals, http.StatusRequestEntityTooLarge)
+
+	// the method limit is keyed by signer, and by IP address too
+	qt.Assert(t, do("10.0.0.1", "submitRawTx", "0xa", "").Code, qt.Equals, http.StatusOK)
+	rec := do("10.0.0.1", "submitRawTx", "0xa", "")
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusTooManyRequests)
+	qt.Assert(t, rec.Header().Get("Retry-After"), qt.Not(qt.Equals), "")
+	qt.Assert(t, do("10.0.0.1", "submitRawTx", "0xb", "").Code, qt.Equals, http.StatusTooManyRequests)
+	qt.Assert(t, do("10.0.0.2", "submitRawTx", "0xa", "").Code, qt.Equals, http.StatusTooManyRequests)
+	qt.Assert(t, do("10.0.0.2", "submitRawTx", "0xb", "").Code, qt.Equals, http.StatusOK)
+
+	// the IP limit applies to all the requests, including the ones rejected
+	// by the method limits
+	qt.Assert(t, do("10.0.0.1", "genProof", "", "").Code, qt.Equals, http.StatusOK)
+	qt.Assert(t, do("10.0.0.1", "genProof", "", "").Code, qt.Equals, http.StatusTooManyRequests)
+	qt.Assert(t, do("10.0.0.2", "genProof", "", "").Code, qt.Equals, http.StatusOK)
+
+	// the concurrency cap rejects requests while all slots are in use
+	r.SetNamespaceLimits("test", &NamespaceLimits{MaxConcurrentRequests: 1})
+	limiter := r.getLimiter("test")
+	qt.Assert(t, limiter.acquire(), qt.IsTrue)
+	qt.Assert(t, do("10.0.0.3", "genProof", "", "").Code, qt.Equals, http.StatusTooManyRequests)
+	limiter.release()
+	qt.Assert(t, do("10.0.0.3", "genProof", "", "").Code, qt.Equals, http.StatusOK)
+}
@@ -20,6 +20,7 @@ func NewAgent(path string, interval time.Duration, router *httprouter.HTTProuter
 	ma := Agent{Path: path, RefreshInterval: interval}
 	router.AddRawHTTPHandler(path, "GET", promhttp.Handler().ServeHTTP)
 	log.Infof("prometheus metrics ready at: %s", path)
+	ma.Register(httprouter.LimitedRequests)
 	return &ma
 }
 
@@ -1,10 +1,8 @@
 package oracle
 
 import (
-	"encoding/json"
 	"fmt"
 
-	"github.com/ethereum/go-ethereum/common"
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
@@ -20,13 +18,8 @@ type Oracle struct {
 	signer     *ethereum.SignKeys
 }
 
-type OracleResults struct {
-	ChainID       string         `json:"chainId"`
-	EntityID      types.HexBytes `json:"entityId"`
-	OracleAddress common.Address `json:"oracleAddress"`
-	ProcessID     types.HexBytes `json:"processId"`
-	Results       [][]string     `json:"results"`
-}
+// OracleResults is the message signed by the oracle on the process results
+type OracleResults = vochain.OracleResults
 
 func NewOracle(app *vochain.BaseApplication, signer *ethereum.SignKeys) (*Oracle, error) {
 	return &Oracle{VochainApp: app, signer: signer}, nil
@@ -152,13 +145,7 @@ func (o *Oracle) OnComputeResults(results *indexertypes.Results, proc *indexerty
 
 	// add the signature to the results and own address
 	setprocessTxArgs.Results.OracleAddress = o.signer.Address().Bytes()
-	signedResultsPayload := OracleResults{
-		ChainID:   o.VochainApp.ChainID(),
-		EntityID:  vocProcessData.EntityId,
-		ProcessID: results.ProcessID,
-		Results:   vochain.GetFriendlyResults(setprocessTxArgs.Results.GetVotes()),
-	}
-	resultsPayload, err := json.Marshal(signedResultsPayload)
+	resultsPayload, err := vochain.OracleResultsPayload(o.VochainApp.ChainID(), setprocessTxArgs.Results)
 	if err != nil {
 		log.Warnf("cannot marshal signed results: %v", err)
 		return
@@ -169,16 +156,12 @@ func (o *Oracle) OnComputeResults(results *indexertypes.Results, proc *indexerty
 	}
 
 	// sign and send the transaction
-	stx := &models.SignedTx{}
-	if stx.Tx, err = proto.Marshal(&models.Tx{
+	stx, err := o.VochainApp.SignOracleTx(o.signer, &models.Tx{
 		Payload: &models.Tx_SetProcess{
 			SetProcess: setprocessTxArgs,
 		},
-	}); err != nil {
-		log.Errorf("cannot marshal setProcessResults tx: %v", err)
-		return
-	}
-	if stx.Signature, err = o.signer.SignVocdoniTx(stx.Tx, o.VochainApp.ChainID()); err != nil {
+	})
+	if err != nil {
 		log.Errorf("cannot sign oracle tx: %v", err)
 		return
 	}
@@ -18,7 +18,7 @@ func (r *RPCAPI) getAccount(request *api.APIrequest) (*api.APIresponse, error) {
 	}
 	// check account and send reply
 	entityIdAddr := common.BytesToAddress(request.EntityId)
-	acc, err := r.vocapp.State.GetAccount(entityIdAddr, true)
+	acc, err := r.vocapp.State.GetAccountAt(entityIdAddr, request.Height)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get account: %w", err)
 	}
@@ -40,6 +40,24 @@ func (r *RPCAPI) getAccount(request *api.APIrequest) (*api.APIresponse, error) {
 	return &response, nil
 }
 
+func (r *RPCAPI) getAccountCensusProof(request *api.APIrequest) (*api.APIresponse, error) {
+	if len(request.VoterAddress) != common.AddressLength {
+		return nil, fmt.Errorf("cannot get account census proof: (malformed voterAddress)")
+	}
+	if len(request.ProcessID) != types.ProcessIDsize {
+		return nil, fmt.Errorf("cannot get account census proof: (malformed processId)")
+	}
+	proof, err := r.vocapp.State.AccountCensusProof(request.ProcessID,
+		common.BytesToAddress(request.VoterAddress))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get account census proof: %w", err)
+	}
+	return &api.APIresponse{
+		Siblings:    proof.GetArbo().Siblings,
+		CensusValue: proof.GetArbo().Value,
+	}, nil
+}
+
 func (r *RPCAPI) getTreasurer(request *api.APIrequest) (*api.APIresponse, error) {
 	// try get treasurer and send reply
 	t, err := r.vocapp.State.Treasurer(true)
@@ -63,7 +81,7 @@ func (r *RPCAPI) getTransactionCost(request *api.APIrequest) (*api.APIresponse,
 	if txType == models.TxType_TX_UNKNOWN {
 		return nil, fmt.Errorf("invalid tx type: %s", request.Type)
 	}
-	c, err := r.vocapp.State.TxCost(txType, true)
+	c, err := r.vocapp.State.TxCostAt(txType, request.Height)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get tx cost: %w", err)
 	}
@@ -107,15 +107,19 @@ func (r *RPCAPI) EnableResultsAPI(vocapp *vochain.BaseApplication, scrutinizer *
 	r.APIs = append(r.APIs, "results")
 
 	r.RegisterPublic("getProcessList", false, r.getProcessList)
+	r.RegisterPublic("searchProcesses", false, r.searchProcesses)
 	r.RegisterPublic("getProcessInfo", false, r.getProcessInfo)
 	r.RegisterPublic("getProcessSummary", false, r.getProcessSummary)
 	r.RegisterPublic("getProcessCount", false, r.getProcessCount)
 	r.RegisterPublic("getResults", false, r.getResults)
 	r.RegisterPublic("getResultsWeight", false, r.getResultsWeight)
+	r.RegisterPublic("getResultsCertificate", false, r.getResultsCertificate)
 	r.RegisterPublic("getEntityList", false, r.getEntityList)
 	r.RegisterPublic("getEntityCount", false, r.getEntityCount)
 	r.RegisterPublic("getEnvelope", false, r.getEnvelope)
+	r.RegisterPublic("getVoteReceipt", false, r.getVoteReceipt)
 	r.RegisterPublic("getAccount", false, r.getAccount)
+	r.RegisterPublic("getAccountCensusProof", false, r.getAccountCensusProof)
 	r.RegisterPublic("getTreasurer", false, r.getTreasurer)
 	r.RegisterPublic("getTxCost", false, r.getTransactionCost)
 	return nil
@@ -71,12 +71,12 @@ func (r *RPCAPI) getValidatorList(request *api.APIrequest) (*api.APIresponse, er
 func (r *RPCAPI) getOracleList(request *api.APIrequest) (*api.APIresponse, error) {
 	var response api.APIresponse
 	var err error
-	oracleList, err := r.vocapp.State.Oracles(true)
+	oracleList, err := r.vocapp.State.OraclesAt(request.Height)
 	if err != nil {
-		return nil, fmt.Errorf("cannot get oracle list")
+		return nil, fmt.Errorf("cannot get oracle list: %w", err)
 	}
 	response.OracleList = new([]string)
-	*response.OracleList = make([]string, len(oracleList))
+	*response.OracleList = make([]string, 0, len(oracleList))
 	for _, oracle := range oracleList {
 		*response.OracleList = append(*response.OracleList, oracle.String())
 	}
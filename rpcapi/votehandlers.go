@@ -84,6 +84,7 @@ func (r *RPCAPI) getEnvelopeStatus(request *api.APIrequest) (*api.APIresponse, e
 	response.Height = &vr.Height
 	response.BlockTimestamp = int32(vr.CreationTime.Unix())
 	response.ProcessID = vr.ProcessID
+	response.OverwriteCount = &vr.OverwriteCount
 	return &response, nil
 }
 
@@ -102,6 +103,22 @@ func (r *RPCAPI) getEnvelope(request *api.APIrequest) (*api.APIresponse, error)
 	return &response, nil
 }
 
+func (r *RPCAPI) getVoteReceipt(request *api.APIrequest) (*api.APIresponse, error) {
+	if len(request.Nullifier) != types.VoteNullifierSize {
+		return nil, fmt.Errorf("cannot get vote receipt: (malformed nullifier)")
+	}
+	if len(request.ProcessID) != types.ProcessIDsize {
+		return nil, fmt.Errorf("cannot get vote receipt: (malformed processId)")
+	}
+	var response api.APIresponse
+	var err error
+	response.VoteReceipt, err = r.scrutinizer.VoteReceipt(request.ProcessID, request.Nullifier, request.Height)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get vote receipt: %w", err)
+	}
+	return &response, nil
+}
+
 func (r *RPCAPI) getEnvelopeHeight(request *api.APIrequest) (*api.APIresponse, error) {
 	// check pid
 	if len(request.ProcessID) != types.ProcessIDsize && len(request.ProcessID) != 0 {
@@ -156,6 +173,29 @@ func (r *RPCAPI) getProcessList(request *api.APIrequest) (*api.APIresponse, erro
 	return &response, nil
 }
 
+func (r *RPCAPI) searchProcesses(request *api.APIrequest) (*api.APIresponse, error) {
+	if request.ProcessSearch == nil {
+		return nil, fmt.Errorf("cannot search processes: missing search parameters")
+	}
+	search := *request.ProcessSearch
+	if search.Limit > MaxListSize || search.Limit <= 0 {
+		search.Limit = MaxListSize
+	}
+	result, err := r.scrutinizer.SearchProcesses(&search)
+	if err != nil {
+		return nil, fmt.Errorf("cannot search processes: %w", err)
+	}
+	var response api.APIresponse
+	for _, p := range result.ProcessIDs {
+		response.ProcessList = append(response.ProcessList, fmt.Sprintf("%x", p))
+	}
+	response.NextCursor = result.NextCursor
+	response.ProcessFacets = result.Facets
+	response.Size = new(int64)
+	*response.Size = int64(len(response.ProcessList))
+	return &response, nil
+}
+
 func (r *RPCAPI) getProcessInfo(request *api.APIrequest) (*api.APIresponse, error) {
 	var response api.APIresponse
 	var err error
@@ -197,6 +237,9 @@ func (r *RPCAPI) getProcessSummary(request *api.APIrequest) (*api.APIresponse, e
 		StartBlock:      procInfo.StartBlock,
 		State:           models.ProcessStatus(procInfo.Status).String(),
 		EnvelopeType:    procInfo.Envelope,
+		// only set for time-based processes, whose blocks are estimations
+		StartDate: procInfo.StartDate,
+		EndDate:   procInfo.EndDate,
 	}
 	return &response, nil
 }
@@ -221,7 +264,7 @@ func (r *RPCAPI) getProcessKeys(request *api.APIrequest) (*api.APIresponse, erro
 	if len(request.ProcessID) != types.ProcessIDsize {
 		return nil, fmt.Errorf("cannot get envelope status: (malformed processId)")
 	}
-	process, err := r.vocapp.State.Process(request.ProcessID, true)
+	process, err := r.vocapp.State.ProcessAt(request.ProcessID, request.Height)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get process encryption public keys: %w", err)
 	}
@@ -306,6 +349,19 @@ func (r *RPCAPI) getResultsWeight(request *api.APIrequest) (*api.APIresponse, er
 	return &response, nil
 }
 
+func (r *RPCAPI) getResultsCertificate(request *api.APIrequest) (*api.APIresponse, error) {
+	if len(request.ProcessID) != types.ProcessIDsize {
+		return nil, fmt.Errorf("cannot get results certificate: (malformed processId)")
+	}
+	var response api.APIresponse
+	var err error
+	response.ResultsCertificate, err = r.scrutinizer.ResultsCertificate(request.ProcessID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get results certificate: %w", err)
+	}
+	return &response, nil
+}
+
 func (r *RPCAPI) getOracleResults(request *api.APIrequest) (*api.APIresponse, error) {
 	var response api.APIresponse
 	if len(request.ProcessID) != types.ProcessIDsize {
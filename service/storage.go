@@ -15,12 +15,24 @@ import (
 
 func IPFS(ipfsconfig *config.IPFSCfg, signer *ethereum.SignKeys,
 	ma *metrics.Agent) (storage data.Storage, err error) {
-	log.Info("creating ipfs service")
+	backend := ipfsconfig.Backend
+	if backend == "" {
+		backend = "IPFS"
+	}
+	log.Infof("creating %s storage service", backend)
 	var storageSync ipfssync.IPFSsync
 	if !ipfsconfig.NoInit {
-		os.Setenv("IPFS_FD_MAX", "1024")
+		storageID := data.StorageIDFromString(backend)
+		if storageID == data.IPFS {
+			os.Setenv("IPFS_FD_MAX", "1024")
+		}
 		ipfsStore := data.IPFSNewConfig(ipfsconfig.ConfigPath)
-		storage, err = data.Init(data.StorageIDFromString("IPFS"), ipfsStore)
+		ipfsStore.S3Endpoint = ipfsconfig.S3Endpoint
+		ipfsStore.S3Bucket = ipfsconfig.S3Bucket
+		ipfsStore.S3Region = ipfsconfig.S3Region
+		ipfsStore.S3AccessKey = ipfsconfig.S3AccessKey
+		ipfsStore.S3SecretKey = ipfsconfig.S3SecretKey
+		storage, err = data.Init(storageID, ipfsStore)
 		if err != nil {
 			return
 		}
@@ -32,15 +44,17 @@ func IPFS(ipfsconfig *config.IPFSCfg, signer *ethereum.SignKeys,
 				stats, err := storage.Stats(tctx)
 				cancel()
 				if err != nil {
-					log.Warnf("IPFS node returned an error: %s", err)
+					log.Warnf("%s storage returned an error: %s", backend, err)
 				}
-				log.Infof("[ipfs info] %s", stats)
+				log.Infof("[storage info] %s", stats)
 			}
 		}()
 
 		go storage.CollectMetrics(context.Background(), ma)
 
-		if len(ipfsconfig.SyncKey) > 0 {
+		if len(ipfsconfig.SyncKey) > 0 && storageID != data.IPFS {
+			log.Warnf("ipfs synchronization is not available on %s storage", backend)
+		} else if len(ipfsconfig.SyncKey) > 0 {
 			log.Info("enabling ipfs synchronization")
 			_, priv := signer.HexString()
 			storageSync = *ipfssync.NewIPFSsync(ipfsconfig.ConfigPath+"/.ipfsSync", ipfsconfig.SyncKey, priv, "libp2p", storage)
@@ -137,6 +137,9 @@ func Vochain(vs *VochainService) (
 		); err != nil {
 			return nil, nil, nil, err
 		}
+		if vs.Storage != nil {
+			sc.SetMetadataStorage(vs.Storage)
+		}
 		go sc.AfterSyncBootstrap()
 	}
 
@@ -0,0 +1,487 @@
+package statedb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
+
+	"github.com/vocdoni/arbo"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/pebbledb"
+	"go.vocdoni.io/dvote/log"
+)
+
+// minPruneKeepRecent is the minimum number of recent versions kept when
+// pruning.  The previous version is always kept because TreeViews opened
+// before the last Commit may still be in use.
+const minPruneKeepRecent = 2
+
+// pruneDeleteBatch is the maximum number of nodes deleted in a single db
+// transaction while pruning.  The StateDB Commits are blocked during the
+// deletion of each batch.
+const pruneDeleteBatch = 4096
+
+// pruneMarkBatch is the maximum number of marked node keys kept in memory
+// while pruning, before they are written to the temporary marks database.
+var pruneMarkBatch = 16384
+
+// PruneMode defines which versions of the StateDB are kept when pruning.  A
+// version that is not kept can't be opened anymore, and the arbo nodes that
+// are only reachable from versions not kept are removed from the database.
+type PruneMode struct {
+	// KeepRecent is the number of most recent versions to keep.  A value
+	// of 0 disables the pruning.  Values below 2 are raised to 2.
+	KeepRecent uint32
+	// KeepEvery keeps, in addition to the most recent ones, every version
+	// which is a multiple of KeepEvery.  A value of 0 keeps no additional
+	// versions.
+	KeepEvery uint32
+	// TempDir is the directory where the temporary database holding the
+	// nodes reachable from the kept versions is created while pruning.  If
+	// empty, the default directory for temporary files is used.
+	TempDir string
+}
+
+// Enabled returns true if the PruneMode removes any version.
+func (m PruneMode) Enabled() bool {
+	return m.KeepRecent > 0
+}
+
+// keep returns true if the version must be kept when current is the last
+// version.
+func (m PruneMode) keep(version, current uint32) bool {
+	keepRecent := m.KeepRecent
+	if keepRecent < minPruneKeepRecent {
+		keepRecent = minPruneKeepRecent
+	}
+	if version+keepRecent > current {
+		return true
+	}
+	return m.KeepEvery > 0 && version%m.KeepEvery == 0
+}
+
+// SubTreeSchema describes a subTree that can be found under the leafs of a
+// tree.  The pruning needs to know the hierarchy of subTrees of the StateDB in
+// order to find all the nodes reachable from the root of a version.
+type SubTreeSchema struct {
+	// Singleton is the configuration of a singleton subTree.  Only used
+	// when NonSingleton is nil.
+	Singleton TreeConfig
+	// NonSingleton is the configuration of a non-singleton subTree, which
+	// can be found under any leaf of the parent tree (identified by the
+	// leaf key).
+	NonSingleton *TreeNonSingletonConfig
+	// SubTrees are the subTrees found under the leafs of this subTree.
+	SubTrees []SubTreeSchema
+}
+
+// SetPruning sets the PruneMode and the schema of subTrees hanging from the
+// mainTree used to prune old versions.  When mode is enabled, a pruning is
+// started in the background after each TreeTx.Commit.
+func (s *StateDB) SetPruning(mode PruneMode, schema []SubTreeSchema) {
+	s.pruneLock.Lock()
+	defer s.pruneLock.Unlock()
+	s.pruneMode = mode
+	s.pruneSchema = schema
+}
+
+// pruneConfig returns the current PruneMode and subTrees schema.
+func (s *StateDB) pruneConfig() (PruneMode, []SubTreeSchema) {
+	s.pruneLock.Lock()
+	defer s.pruneLock.Unlock()
+	return s.pruneMode, s.pruneSchema
+}
+
+// schedulePrune starts a background pruning if enabled.  If a pruning is
+// already running, a new one is started once it finishes.  It never blocks on
+// a running pruning, since it is called on each Commit.
+func (s *StateDB) schedulePrune() {
+	if mode, _ := s.pruneConfig(); !mode.Enabled() {
+		return
+	}
+	atomic.StoreInt32(&s.prunePending, 1)
+	if !atomic.CompareAndSwapInt32(&s.pruneRunning, 0, 1) {
+		return
+	}
+	s.pruneWait.Add(1)
+	go func() {
+		defer s.pruneWait.Done()
+		for {
+			for atomic.SwapInt32(&s.prunePending, 0) == 1 {
+				if _, err := s.Prune(); err != nil {
+					log.Warnf("cannot prune statedb: %v", err)
+				}
+			}
+			atomic.StoreInt32(&s.pruneRunning, 0)
+			// a pruning scheduled right before the running flag was
+			// cleared would be lost otherwise
+			if atomic.LoadInt32(&s.prunePending) == 0 ||
+				!atomic.CompareAndSwapInt32(&s.pruneRunning, 0, 1) {
+				return
+			}
+		}
+	}()
+}
+
+// WaitPrune blocks until the background pruning (if any) finishes.  It should
+// be called before closing the StateDB database.
+func (s *StateDB) WaitPrune() {
+	s.pruneWait.Wait()
+}
+
+// Prune removes the versions not kept by the PruneMode and deletes all the
+// arbo nodes that are not reachable from the kept versions, returning the
+// number of deleted nodes.  Prune can run concurrently with a TreeTx and with
+// TreeViews opened at kept versions: Commits are only blocked while deleting
+// each batch of nodes, and the versions committed in the meantime are marked
+// before deleting.  Subtrees only reachable from pruned versions whose
+// database prefix is not reachable from any kept version are not deleted.
+// Concurrent calls to Prune are serialized.
+func (s *StateDB) Prune() (int, error) {
+	s.pruneRunLock.Lock()
+	defer s.pruneRunLock.Unlock()
+	mode, schema := s.pruneConfig()
+	if !mode.Enabled() {
+		return 0, nil
+	}
+	marks, err := newPruneMarks(mode.TempDir)
+	if err != nil {
+		return 0, fmt.Errorf("cannot create prune marks database: %w", err)
+	}
+	defer marks.close()
+	p := &pruner{
+		sdb:         s,
+		schema:      schema,
+		marked:      marks,
+		markedRoots: make(map[string]struct{}),
+		trees:       make(map[string]int),
+	}
+
+	// remove the versions not kept, so that they can't be opened anymore
+	s.commitLock.Lock()
+	current, err := s.Version()
+	if err == nil {
+		err = s.removeVersions(mode, current)
+	}
+	s.commitLock.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("cannot remove versions: %w", err)
+	}
+
+	// mark the nodes reachable from the kept versions
+	if err := p.markVersions(); err != nil {
+		return 0, err
+	}
+
+	// delete the nodes not marked
+	deleted := 0
+	for treePrefix, hashLen := range p.trees {
+		var candidates [][]byte
+		var markErr error
+		if err := s.db.Iterate([]byte(treePrefix), func(key, value []byte) bool {
+			// the arbo tree stores some metadata (root, number of
+			// leafs) that must be kept, whose keys are never
+			// hashes.
+			if len(key) != hashLen {
+				return true
+			}
+			dbKey := []byte(treePrefix + string(key))
+			marked, err := p.marked.has(dbKey)
+			if err != nil {
+				markErr = err
+				return false
+			}
+			if !marked {
+				candidates = append(candidates, dbKey)
+			}
+			return true
+		}); err != nil {
+			return deleted, err
+		}
+		if markErr != nil {
+			return deleted, markErr
+		}
+		for len(candidates) > 0 {
+			n := pruneDeleteBatch
+			if n > len(candidates) {
+				n = len(candidates)
+			}
+			count, err := p.deleteNodes(candidates[:n])
+			deleted += count
+			if err != nil {
+				return deleted, err
+			}
+			candidates = candidates[n:]
+		}
+	}
+	log.Debugf("statedb pruned %d nodes from %d trees", deleted, len(p.trees))
+	return deleted, nil
+}
+
+// removeVersions deletes the version to root mappings of the versions not
+// kept by the PruneMode.
+func (s *StateDB) removeVersions(mode PruneMode, current uint32) error {
+	versionsPrefix := path.Join(subKeyMeta, pathVersion) + "/"
+	var remove []uint32
+	if err := s.db.Iterate([]byte(versionsPrefix), func(key, value []byte) bool {
+		if len(key) != 4 {
+			return true
+		}
+		if version := binary.LittleEndian.Uint32(key); !mode.keep(version, current) {
+			remove = append(remove, version)
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	if len(remove) == 0 {
+		return nil
+	}
+	tx := s.db.WriteTx()
+	defer tx.Discard()
+	txMetaVer := subWriteTx(tx, path.Join(subKeyMeta, pathVersion))
+	for _, version := range remove {
+		if err := txMetaVer.Delete(uint32ToBytes(version)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// pruner holds the state of a single StateDB pruning.
+type pruner struct {
+	sdb    *StateDB
+	schema []SubTreeSchema
+	// marked contains the database keys of the reachable nodes.
+	marked *pruneMarks
+	// markedRoots contains the version roots already marked.
+	markedRoots map[string]struct{}
+	// trees contains the database prefix of the arbo trees found while
+	// marking, with their hash length.
+	trees map[string]int
+}
+
+// markVersions marks the nodes reachable from the roots of all the stored
+// versions that haven't been marked yet.
+func (p *pruner) markVersions() error {
+	versionsPrefix := path.Join(subKeyMeta, pathVersion) + "/"
+	var roots [][]byte
+	if err := p.sdb.db.Iterate([]byte(versionsPrefix), func(key, value []byte) bool {
+		if len(key) != 4 {
+			return true
+		}
+		if _, ok := p.markedRoots[string(value)]; !ok {
+			roots = append(roots, append([]byte{}, value...))
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	tx := p.sdb.db.ReadTx()
+	defer tx.Discard()
+	for _, root := range roots {
+		if err := p.markTree(tx, "", mainTreeCfg.hashFunc.Len(), root, p.schema); err != nil {
+			return fmt.Errorf("cannot mark version root %x: %w", root, err)
+		}
+		p.markedRoots[string(root)] = struct{}{}
+	}
+	return nil
+}
+
+// deleteNodes deletes the nodes at keys which are still not reachable.  The
+// Commits are blocked meanwhile, and any version committed since the last
+// marking is marked before deleting.
+func (p *pruner) deleteNodes(keys [][]byte) (int, error) {
+	p.sdb.commitLock.Lock()
+	defer p.sdb.commitLock.Unlock()
+	if err := p.markVersions(); err != nil {
+		return 0, err
+	}
+	tx := p.sdb.db.WriteTx()
+	defer tx.Discard()
+	count := 0
+	for _, key := range keys {
+		marked, err := p.marked.has(key)
+		if err != nil {
+			return 0, err
+		}
+		if marked {
+			continue
+		}
+		if err := tx.Delete(key); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, tx.Commit()
+}
+
+// markTree marks the nodes of the arbo tree stored under dbPrefix starting
+// from root, and the subTrees described in schema found under its leafs.
+func (p *pruner) markTree(tx db.ReadTx, dbPrefix string, hashLen int, root []byte,
+	schema []SubTreeSchema) error {
+	if isEmptyHash(root) {
+		return nil
+	}
+	treePrefix := dbPrefix + subKeyTree + "/"
+	// a non-singleton subTree may share its root with a sibling subTree
+	// (for instance when only one of them is used), so a root not found
+	// means that the tree is not stored under this prefix.
+	if _, err := tx.Get([]byte(treePrefix + string(root))); errors.Is(err, db.ErrKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	p.trees[treePrefix] = hashLen
+	return p.markNode(tx, treePrefix, root, func(key, value []byte) error {
+		for _, sub := range schema {
+			cfg := sub.Singleton
+			if sub.NonSingleton != nil {
+				cfg = sub.NonSingleton.WithKey(key)
+			} else if !bytes.Equal(key, cfg.parentLeafKey) {
+				continue
+			}
+			subRoot, err := cfg.parentLeafGetRoot(value)
+			if err != nil {
+				// this leaf doesn't hold this subTree
+				continue
+			}
+			subPrefix := dbPrefix + path.Join(subKeySubTree, cfg.prefix) + "/"
+			if err := p.markTree(tx, subPrefix, cfg.hashFunc.Len(), subRoot,
+				sub.SubTrees); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// markNode marks the node at key and all its descendants, calling onLeaf for
+// each leaf.  Nodes already marked are skipped, since all their descendants
+// have been marked too.
+func (p *pruner) markNode(tx db.ReadTx, treePrefix string, key []byte,
+	onLeaf func(key, value []byte) error) error {
+	if isEmptyHash(key) {
+		return nil
+	}
+	dbKey := []byte(treePrefix + string(key))
+	if marked, err := p.marked.has(dbKey); err != nil || marked {
+		return err
+	}
+	node, err := tx.Get(dbKey)
+	if err != nil {
+		return fmt.Errorf("cannot get node %x: %w", key, err)
+	}
+	if len(node) == 0 {
+		return fmt.Errorf("empty node %x", key)
+	}
+	switch node[0] {
+	case arbo.PrefixValueLeaf:
+		k, v := arbo.ReadLeafValue(node)
+		if err := onLeaf(k, v); err != nil {
+			return err
+		}
+	case arbo.PrefixValueIntermediate:
+		l, r := arbo.ReadIntermediateChilds(node)
+		if err := p.markNode(tx, treePrefix, l, onLeaf); err != nil {
+			return err
+		}
+		if err := p.markNode(tx, treePrefix, r, onLeaf); err != nil {
+			return err
+		}
+	}
+	return p.marked.add(dbKey)
+}
+
+// pruneMarks is the set of the database keys of the nodes marked as reachable
+// while pruning.  The keys are written in batches to a temporary database, so
+// the memory used doesn't grow with the size of the StateDB.
+type pruneMarks struct {
+	dir     string
+	db      db.Database
+	pending map[string]struct{}
+}
+
+// newPruneMarks creates an empty pruneMarks with its database in a new
+// directory under tempDir.
+func newPruneMarks(tempDir string) (*pruneMarks, error) {
+	if tempDir != "" {
+		if err := os.MkdirAll(tempDir, 0o750); err != nil {
+			return nil, err
+		}
+	}
+	dir, err := os.MkdirTemp(tempDir, "statedb-prune-")
+	if err != nil {
+		return nil, err
+	}
+	database, err := pebbledb.New(db.Options{Path: dir})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &pruneMarks{
+		dir:     dir,
+		db:      database,
+		pending: make(map[string]struct{}),
+	}, nil
+}
+
+// has returns true if key has been marked.
+func (m *pruneMarks) has(key []byte) (bool, error) {
+	if _, ok := m.pending[string(key)]; ok {
+		return true, nil
+	}
+	tx := m.db.ReadTx()
+	defer tx.Discard()
+	if _, err := tx.Get(key); errors.Is(err, db.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// add marks key, writing the pending marks to the database once there are
+// pruneMarkBatch of them.
+func (m *pruneMarks) add(key []byte) error {
+	m.pending[string(key)] = struct{}{}
+	if len(m.pending) < pruneMarkBatch {
+		return nil
+	}
+	tx := m.db.WriteTx()
+	defer tx.Discard()
+	for k := range m.pending {
+		if err := tx.Set([]byte(k), nil); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.pending = make(map[string]struct{})
+	return nil
+}
+
+// close closes and removes the temporary database.
+func (m *pruneMarks) close() {
+	if err := m.db.Close(); err != nil {
+		log.Warnf("cannot close prune marks database: %v", err)
+	}
+	if err := os.RemoveAll(m.dir); err != nil {
+		log.Warnf("cannot remove prune marks database: %v", err)
+	}
+}
+
+// isEmptyHash returns true if the hash is the empty node hash (all zeroes).
+func isEmptyHash(hash []byte) bool {
+	for _, b := range hash {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
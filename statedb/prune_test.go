@@ -0,0 +1,195 @@
+package statedb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/metadb"
+)
+
+var pruneSchemaForTest = []SubTreeSchema{
+	{Singleton: singleCfg},
+	{NonSingleton: multiACfg},
+	{NonSingleton: multiBCfg},
+}
+
+// countNodes returns the number of keys stored in the database.
+func countNodes(t *testing.T, database db.Database) int {
+	count := 0
+	qt.Assert(t, database.Iterate(nil, func(key, value []byte) bool {
+		count++
+		return true
+	}), qt.IsNil)
+	return count
+}
+
+// commitVersionForTest updates the mainTree, a singleton subTree and two
+// non-singleton subTrees, and commits the version.
+func commitVersionForTest(t *testing.T, sdb *StateDB, version uint32) {
+	mainTree, err := sdb.BeginTx()
+	qt.Assert(t, err, qt.IsNil)
+	defer mainTree.Discard()
+	id := []byte("01234567")
+	if version == 1 {
+		qt.Assert(t, mainTree.Add(singleCfg.Key(), emptyHash), qt.IsNil)
+		qt.Assert(t, mainTree.Add(id, make([]byte, 32*2)), qt.IsNil)
+	}
+	qt.Assert(t, mainTree.Set([]byte(fmt.Sprintf("key%d", version%4)),
+		[]byte(fmt.Sprintf("value%d", version))), qt.IsNil)
+	single, err := mainTree.SubTree(singleCfg)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, single.Set([]byte(fmt.Sprintf("key%d", version%8)),
+		[]byte(fmt.Sprintf("value%d", version))), qt.IsNil)
+	multiA, err := mainTree.SubTree(multiACfg.WithKey(id))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, multiA.Set([]byte(fmt.Sprintf("key%d", version%8)),
+		[]byte(fmt.Sprintf("value%d", version))), qt.IsNil)
+	multiB, err := mainTree.SubTree(multiBCfg.WithKey(id))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, multiB.Set([]byte(fmt.Sprintf("key%d", version%8)),
+		[]byte(fmt.Sprintf("value%d", version))), qt.IsNil)
+	qt.Assert(t, mainTree.Commit(version), qt.IsNil)
+}
+
+// checkVersionForTest reads all the leafs of all the trees of a version.
+func checkVersionForTest(t *testing.T, sdb *StateDB, version uint32) {
+	root, err := sdb.VersionRoot(version)
+	qt.Assert(t, err, qt.IsNil)
+	mainTree, err := sdb.TreeView(root)
+	qt.Assert(t, err, qt.IsNil)
+	id := []byte("01234567")
+	for _, cfg := range []TreeConfig{singleCfg, multiACfg.WithKey(id), multiBCfg.WithKey(id)} {
+		tree, err := mainTree.SubTree(cfg)
+		qt.Assert(t, err, qt.IsNil)
+		leafs := 0
+		qt.Assert(t, tree.Iterate(func(key, value []byte) bool {
+			leafs++
+			return false
+		}), qt.IsNil)
+		expected := int(version)
+		if expected > 8 {
+			expected = 8
+		}
+		qt.Assert(t, leafs, qt.Equals, expected)
+		value, err := tree.Get([]byte(fmt.Sprintf("key%d", version%8)))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, value, qt.DeepEquals, []byte(fmt.Sprintf("value%d", version)))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	// write the marked nodes to the temporary database in small batches
+	defer func(size int) { pruneMarkBatch = size }(pruneMarkBatch)
+	pruneMarkBatch = 16
+
+	database := metadb.NewTest(t)
+	sdb := NewStateDB(database)
+	for version := uint32(1); version <= 30; version++ {
+		commitVersionForTest(t, sdb, version)
+	}
+	root, err := sdb.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	nodes := countNodes(t, database)
+
+	// pruning disabled
+	deleted, err := sdb.Prune()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, deleted, qt.Equals, 0)
+
+	sdb.SetPruning(PruneMode{KeepRecent: 5, KeepEvery: 10, TempDir: t.TempDir()},
+		pruneSchemaForTest)
+	deleted, err = sdb.Prune()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, deleted > 0, qt.IsTrue)
+	prunedNodes := countNodes(t, database)
+	qt.Assert(t, prunedNodes < nodes, qt.IsTrue,
+		qt.Commentf("nodes before %d, after %d", nodes, prunedNodes))
+
+	// the state root doesn't change
+	root2, err := sdb.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, root2, qt.DeepEquals, root)
+
+	// the kept versions can still be read entirely
+	for _, version := range []uint32{10, 20, 26, 27, 28, 29, 30} {
+		checkVersionForTest(t, sdb, version)
+	}
+	// the pruned versions can't be opened
+	for _, version := range []uint32{1, 9, 11, 25} {
+		_, err := sdb.VersionRoot(version)
+		qt.Assert(t, err, qt.ErrorIs, db.ErrKeyNotFound)
+	}
+
+	// pruning again removes nothing
+	deleted, err = sdb.Prune()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, deleted, qt.Equals, 0)
+
+	// new versions can be committed on top of the pruned state, and the
+	// background pruning keeps the number of nodes bounded
+	for version := uint32(31); version <= 60; version++ {
+		commitVersionForTest(t, sdb, version)
+	}
+	sdb.WaitPrune()
+	_, err = sdb.Prune()
+	qt.Assert(t, err, qt.IsNil)
+	for _, version := range []uint32{10, 20, 30, 40, 50, 56, 60} {
+		checkVersionForTest(t, sdb, version)
+	}
+	_, err = sdb.VersionRoot(55)
+	qt.Assert(t, err, qt.ErrorIs, db.ErrKeyNotFound)
+}
+
+func TestPruneConcurrent(t *testing.T) {
+	database := metadb.NewTest(t)
+	sdb := NewStateDB(database)
+	for version := uint32(1); version <= 20; version++ {
+		commitVersionForTest(t, sdb, version)
+	}
+	sdb.SetPruning(PruneMode{KeepRecent: 2}, pruneSchemaForTest)
+
+	// commit new versions and read the last ones while pruning
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			if _, err := sdb.Prune(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for version := uint32(21); version <= 60; version++ {
+		commitVersionForTest(t, sdb, version)
+		checkVersionForTest(t, sdb, version)
+	}
+	wg.Wait()
+	sdb.WaitPrune()
+
+	_, err := sdb.Prune()
+	qt.Assert(t, err, qt.IsNil)
+	checkVersionForTest(t, sdb, 59)
+	checkVersionForTest(t, sdb, 60)
+	_, err = sdb.VersionRoot(58)
+	qt.Assert(t, err, qt.ErrorIs, db.ErrKeyNotFound)
+
+	// a running pruning doesn't block the commits
+	sdb.pruneRunLock.Lock()
+	committed := make(chan struct{})
+	go func() {
+		commitVersionForTest(t, sdb, 61)
+		close(committed)
+	}()
+	select {
+	case <-committed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("commit blocked by a running pruning")
+	}
+	sdb.pruneRunLock.Unlock()
+	sdb.WaitPrune()
+}
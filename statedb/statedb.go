@@ -149,6 +149,11 @@ func (c *TreeNonSingletonConfig) HashFunc() arbo.HashFunction {
 	return c.hashFunc
 }
 
+// MaxLevels returns the maxLevels set for this SubTreeConfig
+func (c *TreeNonSingletonConfig) MaxLevels() int {
+	return c.maxLevels
+}
+
 // WithKey returns a unified subTree configuration type for opening a singleton
 // subTree that is identified by `key`.  `key` is the path in the parent tree
 // to the leaf that contains the subTree root.
@@ -205,6 +210,21 @@ var mainTreeCfg = NewTreeSingletonConfig(TreeParams{
 type StateDB struct {
 	hashLen int
 	db      db.Database
+
+	// commitLock is held while a TreeTx is committed, so that the pruning
+	// can mark the new versions before deleting nodes.
+	commitLock sync.Mutex
+	// pruneLock protects the pruning configuration.
+	pruneLock   sync.Mutex
+	pruneMode   PruneMode
+	pruneSchema []SubTreeSchema
+	// pruneRunLock prevents concurrent prunings.
+	pruneRunLock sync.Mutex
+	// pruneRunning and prunePending schedule the background prunings
+	// without blocking the Commits, accessed atomically.
+	pruneRunning int32
+	prunePending int32
+	pruneWait    sync.WaitGroup
 }
 
 // NewStateDB returns an instance of the StateDB.
@@ -1,6 +1,7 @@
 package statedb
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"strings"
@@ -310,6 +311,62 @@ func TestSubTree(t *testing.T) {
 	}
 }
 
+func TestImport(t *testing.T) {
+	sdb := NewStateDB(metadb.NewTest(t))
+	mainTree, err := sdb.BeginTx()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, mainTree.Add(singleCfg.Key(), emptyHash), qt.IsNil)
+	single, err := mainTree.SubTree(singleCfg)
+	qt.Assert(t, err, qt.IsNil)
+	for i := 0; i < 10; i++ {
+		qt.Assert(t, single.Add([]byte(fmt.Sprintf("key%d", i)),
+			[]byte(fmt.Sprintf("value%d", i))), qt.IsNil)
+	}
+	qt.Assert(t, mainTree.Commit(1), qt.IsNil)
+	root, err := sdb.Hash()
+	qt.Assert(t, err, qt.IsNil)
+
+	// dump the main tree and the subTree
+	mainTreeView, err := sdb.TreeView(nil)
+	qt.Assert(t, err, qt.IsNil)
+	var mainDump, singleDump bytes.Buffer
+	qt.Assert(t, mainTreeView.Dump(&mainDump), qt.IsNil)
+	singleView, err := mainTreeView.SubTree(singleCfg)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, singleView.Dump(&singleDump), qt.IsNil)
+
+	// import into a new StateDB which already contains some data
+	sdb2 := NewStateDB(metadb.NewTest(t))
+	mainTree2, err := sdb2.BeginTx()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, mainTree2.Add([]byte("other"), []byte("value")), qt.IsNil)
+	qt.Assert(t, mainTree2.Commit(1), qt.IsNil)
+
+	mainTree2, err = sdb2.BeginTx()
+	qt.Assert(t, err, qt.IsNil)
+	defer mainTree2.Discard()
+	// the subTree can't be imported before its parent
+	qt.Assert(t, mainTree2.ImportSubTree(singleCfg, bytes.NewReader(singleDump.Bytes())),
+		qt.ErrorIs, arbo.ErrKeyNotFound)
+	qt.Assert(t, mainTree2.Import(&mainDump), qt.IsNil)
+	qt.Assert(t, mainTree2.ImportSubTree(singleCfg, &singleDump), qt.IsNil)
+	qt.Assert(t, mainTree2.Commit(5), qt.IsNil)
+
+	root2, err := sdb2.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, root2, qt.DeepEquals, root)
+	version, err := sdb2.Version()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, version, qt.Equals, uint32(5))
+	v, err := sdb2.TreeView(nil)
+	qt.Assert(t, err, qt.IsNil)
+	value, err := v.DeepGet([]byte("key7"), singleCfg)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, value, qt.DeepEquals, []byte("value7"))
+	_, err = v.Get([]byte("other"))
+	qt.Assert(t, err, qt.ErrorIs, arbo.ErrKeyNotFound)
+}
+
 func TestNoState(t *testing.T) {
 	sdb := NewStateDB(metadb.NewTest(t))
 
@@ -2,6 +2,8 @@ package statedb
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"path"
 	"sync"
@@ -98,14 +100,88 @@ func (u *TreeUpdate) Dump(w io.Writer) error {
 	return u.tree.DumpWriter(w)
 }
 
-// Import writes the content exported with Dump.
-// TODO: use io.Reader once implemented in Arbo.
+// Import replaces the content of this tree with the leafs exported with Dump.
 func (u *TreeUpdate) Import(r io.Reader) error {
-	var buf bytes.Buffer
-	if _, err := buf.ReadFrom(r); err != nil {
+	if err := importDump(u.tree, u.cfg.hashFunc.Len(), r); err != nil {
 		return err
 	}
-	return u.tree.ImportDump(buf.Bytes())
+	u.dirtyTree = true
+	return nil
+}
+
+// ImportSubTree replaces the content of the subTree defined by cfg with the
+// leafs exported with Dump.  The resulting root must match the root found in
+// the parent leaf, so the parent tree must be imported first.  This is used
+// to rebuild a StateDB from a snapshot, where the subTree can't be opened via
+// SubTree because its nodes don't exist yet in the database.
+func (u *TreeUpdate) ImportSubTree(cfg TreeConfig, r io.Reader) error {
+	parentLeaf, err := u.tree.Get(u.tree.tx, cfg.parentLeafKey)
+	if err != nil {
+		return err
+	}
+	root, err := cfg.parentLeafGetRoot(parentLeaf)
+	if err != nil {
+		return err
+	}
+	tx := subWriteTx(u.tx, path.Join(subKeySubTree, cfg.prefix))
+	txTree := subWriteTx(tx, subKeyTree)
+	tree, err := tree.New(txTree,
+		tree.Options{DB: nil, MaxLevels: cfg.maxLevels, HashFunc: cfg.hashFunc})
+	if err != nil {
+		return err
+	}
+	if err := importDump(treeWithTx{Tree: tree, tx: txTree}, cfg.hashFunc.Len(), r); err != nil {
+		return err
+	}
+	importedRoot, err := tree.Root(txTree)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(root, importedRoot) {
+		return fmt.Errorf("imported root %x does not match parent leaf root %x",
+			importedRoot, root)
+	}
+	// Any previously opened instance of this subTree is now stale
+	u.openSubs.Delete(cfg.prefix)
+	return nil
+}
+
+// importDump empties the tree and adds all the leafs read from r, which must
+// follow the format used by Dump.
+func importDump(t treeWithTx, hashLen int, r io.Reader) error {
+	if err := t.SetRoot(t.tx, make([]byte, hashLen)); err != nil {
+		return err
+	}
+	var keys, values [][]byte
+	header := make([]byte, 3)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		k := make([]byte, int(header[0]))
+		if _, err := io.ReadFull(r, k); err != nil {
+			return err
+		}
+		v := make([]byte, binary.LittleEndian.Uint16(header[1:3]))
+		if _, err := io.ReadFull(r, v); err != nil {
+			return err
+		}
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	invalid, err := t.AddBatch(t.tx, keys, values)
+	if err != nil {
+		return err
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("cannot import %d leafs", len(invalid))
+	}
+	return nil
 }
 
 // NoState returns a key-value database associated with this tree that doesn't
@@ -313,7 +389,9 @@ func propagateRoot(treeUpdate *TreeUpdate) ([]byte, error) {
 // (identified by the mainTree root).  The specified version will be stored as
 // the last version of the StateDB.  In general, Commits should use sequential
 // version numbers, but overwritting an existing version can be useful in some
-// cases (for example, overwritting version 0 to setup a genesis state).
+// cases (for example, overwritting version 0 to setup a genesis state).  If
+// pruning is enabled (see StateDB.SetPruning), a background pruning of the old
+// versions is started after the commit.
 func (t *TreeTx) Commit(version uint32) error {
 	root, err := propagateRoot(&t.TreeUpdate)
 	if err != nil {
@@ -334,7 +412,14 @@ func (t *TreeTx) Commit(version uint32) error {
 	if err := setVersionRoot(t.tx, version, root); err != nil {
 		return err
 	}
-	return t.tx.Commit()
+	t.sdb.commitLock.Lock()
+	err = t.tx.Commit()
+	t.sdb.commitLock.Unlock()
+	if err != nil {
+		return err
+	}
+	t.sdb.schedulePrune()
+	return nil
 }
 
 // Discard all the changes that have been made from the TreeTx.  After calling
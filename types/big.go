@@ -55,6 +55,11 @@ func (i *BigInt) Add(x *BigInt, y *BigInt) *BigInt {
 	return (*BigInt)(i.ToInt().Add(x.ToInt(), y.ToInt()))
 }
 
+// Sub subtracts x-y
+func (i *BigInt) Sub(x *BigInt, y *BigInt) *BigInt {
+	return (*BigInt)(i.ToInt().Sub(x.ToInt(), y.ToInt()))
+}
+
 // Mul multiplies x*y
 func (i *BigInt) Mul(x *BigInt, y *BigInt) *BigInt {
 	return (*BigInt)(i.ToInt().Mul(x.ToInt(), y.ToInt()))
@@ -8,6 +8,12 @@ import (
 
 type DataStore struct {
 	Datadir string
+	// S3 options, only used by the S3 compatible storage backend
+	S3Endpoint  string
+	S3Bucket    string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
 }
 
 // TODO: use an array, and possibly declare methods to encode/decode as hex.
@@ -20,6 +20,16 @@ func (u *URLAPI) enableAccountHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.accountHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get an account", Response: &Account{}},
+	); err != nil {
+		return err
+	}
+	if err := u.api.RegisterMethod(
+		"/account/{address}/height/{height}",
+		"GET",
+		bearerstdapi.MethodAccessTypePublic,
+		u.accountHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get an account at a block height", Response: &Account{}},
 	); err != nil {
 		return err
 	}
@@ -27,13 +37,18 @@ func (u *URLAPI) enableAccountHandlers() error {
 }
 
 // /account/{address}
+// /account/{address}/height/{height}
 // get the account information
 func (u *URLAPI) accountHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
 	if len(util.TrimHex(ctx.URLParam("address"))) != common.AddressLength*2 {
 		return fmt.Errorf("address malformed")
 	}
 	addr := common.HexToAddress(ctx.URLParam("address"))
-	acc, err := u.vocapp.State.GetAccount(addr, true)
+	height, err := heightParam(ctx)
+	if err != nil {
+		return err
+	}
+	acc, err := u.vocapp.State.GetAccountAt(addr, height)
 	if err != nil || acc == nil {
 		return fmt.Errorf("account %s does not exist", addr.Hex())
 	}
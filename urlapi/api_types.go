@@ -6,6 +6,9 @@ import (
 	"github.com/google/uuid"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/eventstream"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	"go.vocdoni.io/dvote/vochain/webhooks"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/encoding/protojson"
 )
@@ -28,6 +31,8 @@ type ElectionSummary struct {
 	Status       string         `json:"status"`
 	StartDate    time.Time      `json:"startDate"`
 	EndDate      time.Time      `json:"endDate"`
+	StartBlock   uint32         `json:"startBlock"`
+	EndBlock     uint32         `json:"endBlock"`
 	VoteCount    uint64         `json:"voteCount"`
 	FinalResults bool           `json:"finalResults"`
 	Results      []Result       `json:"result,omitempty"`
@@ -44,6 +49,13 @@ type Election struct {
 	VoteMode      VoteMode        `json:"voteMode,omitempty"`
 	ElectionMode  ElectionMode    `json:"electionMode,omitempty"`
 	TallyMode     TallyMode       `json:"tallyMode,omitempty"`
+	// RankedRounds are the instant runoff rounds of the final results of
+	// a ranked choice election.
+	RankedRounds []*indexertypes.RankedRound `json:"rankedRounds,omitempty"`
+}
+
+type ElectionCount struct {
+	Count uint32 `json:"count"`
 }
 
 type ElectionCensus struct {
@@ -94,6 +106,7 @@ type Vote struct {
 	VoteNumber           *uint32        `json:"number,omitempty"`
 	ElectionID           types.HexBytes `json:"electionID,omitempty"`
 	VoterID              types.HexBytes `json:"voterID,omitempty"`
+	OverwriteCount       *uint32        `json:"overwriteCount,omitempty"`
 }
 
 type CensusTypeDescription struct {
@@ -191,3 +204,34 @@ func (t TallyMode) MarshalJSON() ([]byte, error) {
 	m := protojson.MarshalOptions{EmitUnpopulated: true, UseEnumNumbers: false}
 	return m.Marshal(&t)
 }
+
+type ElectionSearch struct {
+	Elections  []*ElectionSummary          `json:"elections"`
+	NextCursor string                      `json:"nextCursor,omitempty"`
+	Facets     *indexertypes.ProcessFacets `json:"facets,omitempty"`
+}
+
+type Events struct {
+	Events      []*eventstream.Event `json:"events"`
+	FirstHeight uint32               `json:"firstHeight"`
+	LastHeight  uint32               `json:"lastHeight"`
+}
+
+type Webhook struct {
+	WebhookID  string         `json:"webhookId,omitempty"`
+	URL        string         `json:"url,omitempty"`
+	EntityID   types.HexBytes `json:"entityId,omitempty"`
+	ElectionID types.HexBytes `json:"electionId,omitempty"`
+	Events     []string       `json:"events,omitempty"`
+	Created    *time.Time     `json:"created,omitempty"`
+	Signer     string         `json:"signer,omitempty"`
+}
+
+type Webhooks struct {
+	Webhooks []*Webhook `json:"webhooks"`
+	Signer   string     `json:"signer"`
+}
+
+type WebhookDeliveries struct {
+	Deliveries []*webhooks.Delivery `json:"deliveries"`
+}
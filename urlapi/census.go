@@ -40,24 +40,27 @@ func (u *URLAPI) enableCensusHandlers() error {
 	if err := u.api.RegisterMethod(
 		"/census/create/{type}",
 		"GET",
-		bearerstdapi.MethodAccessTypePublic, // must be private in some moment
+		u.scopedAccessType(),
 		u.censusCreateHandler,
+		&bearerstdapi.MethodSpec{Summary: "Create a census of the given type (weighted or zkindexed)", Response: &Census{}},
 	); err != nil {
 		return err
 	}
 	if err := u.api.RegisterMethod(
 		"/census/{censusID}/add/{key}/{weight}",
 		"GET",
-		bearerstdapi.MethodAccessTypePublic,
+		u.scopedAccessType(),
 		u.censusAddHandler,
+		&bearerstdapi.MethodSpec{Summary: "Add a key with a weight to a census"},
 	); err != nil {
 		return err
 	}
 	if err := u.api.RegisterMethod(
 		"/census/{censusID}/add/{key}",
 		"GET",
-		bearerstdapi.MethodAccessTypePublic,
+		u.scopedAccessType(),
 		u.censusAddHandler,
+		&bearerstdapi.MethodSpec{Summary: "Add a key to a census"},
 	); err != nil {
 		return err
 	}
@@ -66,6 +69,7 @@ func (u *URLAPI) enableCensusHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.censusRootHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the root of a census", Response: &Census{}},
 	); err != nil {
 		return err
 	}
@@ -74,14 +78,16 @@ func (u *URLAPI) enableCensusHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.censusDumpHandler,
+		&bearerstdapi.MethodSpec{Summary: "Export a census", Response: &CensusDump{}},
 	); err != nil {
 		return err
 	}
 	if err := u.api.RegisterMethod(
 		"/census/{censusID}/import",
 		"POST",
-		bearerstdapi.MethodAccessTypePublic,
+		u.scopedAccessType(),
 		u.censusImportHandler,
+		&bearerstdapi.MethodSpec{Summary: "Import a census dump", Request: &CensusDump{}},
 	); err != nil {
 		return err
 	}
@@ -90,6 +96,7 @@ func (u *URLAPI) enableCensusHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.censusWeightHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the total weight of a census", Response: &Census{}},
 	); err != nil {
 		return err
 	}
@@ -98,30 +105,34 @@ func (u *URLAPI) enableCensusHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.censusSizeHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the number of keys of a census", Response: &Census{}},
 	); err != nil {
 		return err
 	}
 	if err := u.api.RegisterMethod(
 		"/census/{censusID}/publish",
 		"GET",
-		bearerstdapi.MethodAccessTypePublic,
+		u.scopedAccessType(),
 		u.censusPublishHandler,
+		&bearerstdapi.MethodSpec{Summary: "Publish a census, making it immutable", Response: &Census{}},
 	); err != nil {
 		return err
 	}
 	if err := u.api.RegisterMethod(
 		"/census/{censusID}/publish/{root}",
 		"GET",
-		bearerstdapi.MethodAccessTypePublic,
+		u.scopedAccessType(),
 		u.censusPublishHandler,
+		&bearerstdapi.MethodSpec{Summary: "Publish a census with the given root", Response: &Census{}},
 	); err != nil {
 		return err
 	}
 	if err := u.api.RegisterMethod(
 		"/census/{censusID}/delete",
 		"GET",
-		bearerstdapi.MethodAccessTypePublic,
+		u.scopedAccessType(),
 		u.censusDeleteHandler,
+		&bearerstdapi.MethodSpec{Summary: "Delete a census"},
 	); err != nil {
 		return err
 	}
@@ -130,6 +141,7 @@ func (u *URLAPI) enableCensusHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.censusProofHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the proof of a key in a census", Response: &Census{}},
 	); err != nil {
 		return err
 	}
@@ -138,10 +150,20 @@ func (u *URLAPI) enableCensusHandlers() error {
 		"POST",
 		bearerstdapi.MethodAccessTypePublic,
 		u.censusVerifyHandler,
+		&bearerstdapi.MethodSpec{Summary: "Verify the proof of a key in a census", Request: &Census{}, Response: &Census{}},
 	); err != nil {
 		return err
 	}
 
+	u.api.AddScope(ScopeCensusWrite,
+		"/census/create/{type}",
+		"/census/{censusID}/add/{key}/{weight}",
+		"/census/{censusID}/add/{key}",
+		"/census/{censusID}/import",
+		"/census/{censusID}/publish",
+		"/census/{censusID}/publish/{root}",
+		"/census/{censusID}/delete",
+	)
 	return nil
 }
 
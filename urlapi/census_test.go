@@ -13,6 +13,7 @@ import (
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/test/testcommon/testutil"
+	"go.vocdoni.io/dvote/types"
 )
 
 func TestCensus(t *testing.T) {
@@ -27,8 +28,9 @@ func TestCensus(t *testing.T) {
 	api, err := NewURLAPI(&router, "/", t.TempDir())
 	qt.Assert(t, err, qt.IsNil)
 
-	storage := data.IPFSHandle{}
-	api.Attach(nil, nil, nil, data.Storage(&storage))
+	storage, err := data.Init(data.FS, &types.DataStore{Datadir: t.TempDir()})
+	qt.Assert(t, err, qt.IsNil)
+	api.Attach(nil, nil, nil, storage)
 	qt.Assert(t, api.EnableHandlers(CensusHandler), qt.IsNil)
 
 	token1 := uuid.New()
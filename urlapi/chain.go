@@ -21,6 +21,7 @@ func (u *URLAPI) enableChainHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.organizationListHandler,
+		&bearerstdapi.MethodSpec{Summary: "List the organizations", Response: &Organization{}},
 	); err != nil {
 		return err
 	}
@@ -29,6 +30,7 @@ func (u *URLAPI) enableChainHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.organizationListHandler,
+		&bearerstdapi.MethodSpec{Summary: "List the organizations of a page", Response: &Organization{}},
 	); err != nil {
 		return err
 	}
@@ -37,6 +39,7 @@ func (u *URLAPI) enableChainHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.organizationCountHandler,
+		&bearerstdapi.MethodSpec{Summary: "Count the organizations", Response: &Organization{}},
 	); err != nil {
 		return err
 	}
@@ -45,6 +48,7 @@ func (u *URLAPI) enableChainHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.chainInfoHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the blockchain information", Response: &ChainInfo{}},
 	); err != nil {
 		return err
 	}
@@ -53,6 +57,16 @@ func (u *URLAPI) enableChainHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.chainTxCostHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the transaction costs", Response: &Transaction{}},
+	); err != nil {
+		return err
+	}
+	if err := u.api.RegisterMethod(
+		"/chain/transaction/cost/height/{height}",
+		"GET",
+		bearerstdapi.MethodAccessTypePublic,
+		u.chainTxCostHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the transaction costs at a block height", Response: &Transaction{}},
 	); err != nil {
 		return err
 	}
@@ -61,6 +75,7 @@ func (u *URLAPI) enableChainHandlers() error {
 		"POST",
 		bearerstdapi.MethodAccessTypePublic,
 		u.chainSendTxHandler,
+		&bearerstdapi.MethodSpec{Summary: "Submit a signed transaction", Request: &Transaction{}, Response: &Transaction{}},
 	); err != nil {
 		return err
 	}
@@ -160,14 +175,18 @@ func (u *URLAPI) chainSendTxHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx
 }
 
 // /chain/transaction/cost
+// /chain/transaction/cost/height/{height}
 // returns de list of transactions and its cost
 func (u *URLAPI) chainTxCostHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
 	txCosts := &Transaction{
 		Costs: make(map[string]uint64),
 	}
-	var err error
+	height, err := heightParam(ctx)
+	if err != nil {
+		return err
+	}
 	for k, v := range vochain.TxCostNameToTxTypeMap {
-		txCosts.Costs[k], err = u.vocapp.State.TxCost(v, true)
+		txCosts.Costs[k], err = u.vocapp.State.TxCostAt(v, height)
 		if err != nil {
 			return err
 		}
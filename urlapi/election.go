@@ -15,6 +15,7 @@ import (
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
 	"go.vocdoni.io/dvote/vochain/scrutinizer"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
 	"go.vocdoni.io/proto/build/go/models"
 )
 
@@ -26,6 +27,7 @@ func (u *URLAPI) enableElectionHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.electionListHandler,
+		&bearerstdapi.MethodSpec{Summary: "List the elections of an organization by status, for a page", Response: &Organization{}},
 	); err != nil {
 		return err
 	}
@@ -34,6 +36,7 @@ func (u *URLAPI) enableElectionHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.electionListHandler,
+		&bearerstdapi.MethodSpec{Summary: "List the elections of an organization by status", Response: &Organization{}},
 	); err != nil {
 		return err
 	}
@@ -42,6 +45,7 @@ func (u *URLAPI) enableElectionHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.electionListHandler,
+		&bearerstdapi.MethodSpec{Summary: "List the elections of an organization", Response: &Organization{}},
 	); err != nil {
 		return err
 	}
@@ -50,6 +54,7 @@ func (u *URLAPI) enableElectionHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.electionListHandler,
+		&bearerstdapi.MethodSpec{Summary: "List the elections of an organization, for a page", Response: &Organization{}},
 	); err != nil {
 		return err
 	}
@@ -58,6 +63,7 @@ func (u *URLAPI) enableElectionHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.electionHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get an election", Response: &Election{}},
 	); err != nil {
 		return err
 	}
@@ -66,6 +72,7 @@ func (u *URLAPI) enableElectionHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.electionCountHandler,
+		&bearerstdapi.MethodSpec{Summary: "Count the elections of an organization", Response: &ElectionCount{}},
 	); err != nil {
 		return err
 	}
@@ -74,6 +81,25 @@ func (u *URLAPI) enableElectionHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.electionKeysHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the encryption keys of an election", Response: &Election{}},
+	); err != nil {
+		return err
+	}
+	if err := u.api.RegisterMethod(
+		"/election/{electionID}/keys/height/{height}",
+		"GET",
+		bearerstdapi.MethodAccessTypePublic,
+		u.electionKeysHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the encryption keys of an election at a block height", Response: &Election{}},
+	); err != nil {
+		return err
+	}
+	if err := u.api.RegisterMethod(
+		"/election/{electionID}/certificate",
+		"GET",
+		bearerstdapi.MethodAccessTypePublic,
+		u.electionCertificateHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the results certificate of a finished election", Response: &indexertypes.ResultsCertificate{}},
 	); err != nil {
 		return err
 	}
@@ -82,18 +108,30 @@ func (u *URLAPI) enableElectionHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.electionVotesHandler,
+		&bearerstdapi.MethodSpec{Summary: "List the votes of an election"},
 	); err != nil {
 		return err
 	}
 	if err := u.api.RegisterMethod(
-		"/election/create",
+		"/election/search",
 		"POST",
 		bearerstdapi.MethodAccessTypePublic,
+		u.electionSearchHandler,
+		&bearerstdapi.MethodSpec{Summary: "Search the elections", Request: &indexertypes.ProcessSearch{}, Response: &ElectionSearch{}},
+	); err != nil {
+		return err
+	}
+	if err := u.api.RegisterMethod(
+		"/election/create",
+		"POST",
+		u.scopedAccessType(),
 		u.electionCreateHandler,
+		&bearerstdapi.MethodSpec{Summary: "Create an election from a signed transaction", Request: &ElectionCreate{}, Response: &ElectionCreate{}},
 	); err != nil {
 		return err
 	}
 
+	u.api.AddScope(ScopeElectionCreate, "/election/create")
 	return nil
 }
 
@@ -162,6 +200,40 @@ func (u *URLAPI) electionListHandler(msg *bearerstdapi.BearerStandardAPIdata, ct
 	return nil
 }
 
+// /election/search
+// search the elections by text, organization, census origin, envelope type,
+// creation date and vote count, with cursor pagination
+func (u *URLAPI) electionSearchHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+	search := &indexertypes.ProcessSearch{}
+	if err := json.Unmarshal(msg.Data, search); err != nil {
+		return fmt.Errorf("cannot decode search parameters: %w", err)
+	}
+	if search.Limit <= 0 || search.Limit > MaxPageSize {
+		search.Limit = MaxPageSize
+	}
+	result, err := u.scrutinizer.SearchProcesses(search)
+	if err != nil {
+		return fmt.Errorf("cannot search elections: %w", err)
+	}
+	pids := [][]byte{}
+	for _, pid := range result.ProcessIDs {
+		pids = append(pids, pid)
+	}
+	elections, err := u.getProcessSummaryList(pids...)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&ElectionSearch{
+		Elections:  elections,
+		NextCursor: result.NextCursor,
+		Facets:     result.Facets,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %w", err)
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
 // /election/electionID/<electionID>
 // get election information
 func (u *URLAPI) electionHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
@@ -178,13 +250,16 @@ func (u *URLAPI) electionHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *h
 		return fmt.Errorf("cannot get envelope height: %w", err)
 	}
 
+	startDate, endDate := u.electionDates(proc)
 	election := Election{
 		ElectionSummary: ElectionSummary{
 			ElectionID:   electionID,
 			Status:       models.ProcessStatus_name[proc.Status],
 			Type:         u.formatElectionType(proc.Envelope),
-			StartDate:    u.vocinfo.HeightTime(int64(proc.StartBlock)),
-			EndDate:      u.vocinfo.HeightTime(int64(proc.EndBlock)),
+			StartDate:    startDate,
+			EndDate:      endDate,
+			StartBlock:   proc.StartBlock,
+			EndBlock:     proc.EndBlock,
 			FinalResults: proc.FinalResults,
 			VoteCount:    count,
 		},
@@ -212,6 +287,7 @@ func (u *URLAPI) electionHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *h
 		for _, r := range scrutinizer.GetFriendlyResults(results.Votes) {
 			election.Results = append(election.Results, Result{Value: r})
 		}
+		election.RankedRounds = results.RankedRounds
 	}
 
 	data, err := json.Marshal(election)
@@ -234,11 +310,7 @@ func (u *URLAPI) electionCountHandler(msg *bearerstdapi.BearerStandardAPIdata, c
 	if err != nil {
 		return err
 	}
-	data, err := json.Marshal(
-		struct {
-			Count uint32 `json:"count"`
-		}{Count: acc.GetProcessIndex()},
-	)
+	data, err := json.Marshal(&ElectionCount{Count: acc.GetProcessIndex()})
 	if err != nil {
 		return fmt.Errorf("error marshaling JSON: %w", err)
 	}
@@ -246,6 +318,7 @@ func (u *URLAPI) electionCountHandler(msg *bearerstdapi.BearerStandardAPIdata, c
 }
 
 // /election/<electionID>/keys
+// /election/<electionID>/keys/height/<height>
 // returns the list of public/private encryption keys
 func (u *URLAPI) electionKeysHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
 	electionID, err := hex.DecodeString(util.TrimHex(ctx.URLParam("electionID")))
@@ -253,7 +326,11 @@ func (u *URLAPI) electionKeysHandler(msg *bearerstdapi.BearerStandardAPIdata, ct
 		return fmt.Errorf("electionID (%q) cannot be decoded", ctx.URLParam("electionID"))
 	}
 
-	process, err := u.vocapp.State.Process(electionID, true)
+	height, err := heightParam(ctx)
+	if err != nil {
+		return err
+	}
+	process, err := u.vocapp.State.ProcessAt(electionID, height)
 	if err != nil {
 		return fmt.Errorf("cannot get election keys: %w", err)
 	}
@@ -285,6 +362,25 @@ func (u *URLAPI) electionKeysHandler(msg *bearerstdapi.BearerStandardAPIdata, ct
 	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
 }
 
+// /election/<electionID>/certificate
+// returns the results certificate of a finished election, which can be
+// verified offline (see scrutinizer.VerifyResultsCertificate)
+func (u *URLAPI) electionCertificateHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+	electionID, err := hex.DecodeString(util.TrimHex(ctx.URLParam("electionID")))
+	if err != nil || electionID == nil {
+		return fmt.Errorf("electionID (%q) cannot be decoded", ctx.URLParam("electionID"))
+	}
+	cert, err := u.scrutinizer.ResultsCertificate(electionID)
+	if err != nil {
+		return fmt.Errorf("cannot get results certificate: %w", err)
+	}
+	data, err := json.Marshal(cert)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %w", err)
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
 // election/<electionID>/votes
 // returns the list of voteIDs for an election (paginated)
 func (u *URLAPI) electionVotesHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
@@ -306,6 +402,16 @@ func (u *URLAPI) electionCreateHandler(msg *bearerstdapi.BearerStandardAPIdata,
 			return fmt.Errorf("wrong metadata format: %w", err)
 		}
 	}
+	// if the auth token belongs to an organization, it can only create its elections
+	if len(msg.Owner) > 0 {
+		entityID, err := newProcessEntityID(req.TxPayload)
+		if err != nil {
+			return err
+		}
+		if err := checkTokenOwner(msg, entityID); err != nil {
+			return err
+		}
+	}
 	// send the transaction
 	res, err := u.vocapp.SendTx(req.TxPayload)
 	if err != nil {
@@ -0,0 +1,276 @@
+package urlapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/vochain/eventstream"
+)
+
+const (
+	EventsHandler = "events"
+
+	// DefaultEventsMaxSubscribers is the default maximum number of event
+	// stream subscribers
+	DefaultEventsMaxSubscribers = 1000
+
+	// eventsKeepAlive is the interval for sending keep alive messages to
+	// the stream subscribers
+	eventsKeepAlive = 20 * time.Second
+	// eventsWriteTimeout is the maximum time for writing an event
+	eventsWriteTimeout = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	// the API is public and CORS allows any origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func (u *URLAPI) enableEventsHandlers() error {
+	if err := u.api.RegisterMethod(
+		"/events/block/{height}",
+		"GET",
+		bearerstdapi.MethodAccessTypePublic,
+		u.eventsBlockHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the events of a block", Response: &Events{}},
+	); err != nil {
+		return err
+	}
+	u.router.AddRawHTTPHandler(path.Join(u.BaseRoute, "/events/sse"), "GET", u.eventsSSEHandler)
+	u.router.AddRawHTTPHandler(path.Join(u.BaseRoute, "/events/ws"), "GET", u.eventsWSHandler)
+	return nil
+}
+
+// /events/block/<height>
+// returns the events of a block
+func (u *URLAPI) eventsBlockHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+	height, err := strconv.ParseUint(ctx.URLParam("height"), 10, 32)
+	if err != nil {
+		return fmt.Errorf("cannot parse height")
+	}
+	if uint32(height) > u.eventstream.LastHeight() {
+		return fmt.Errorf("block %d not yet available", height)
+	}
+	events, err := u.eventstream.Events(uint32(height))
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&Events{
+		Events:      events,
+		FirstHeight: u.eventstream.FirstHeight(),
+		LastHeight:  u.eventstream.LastHeight(),
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// eventsRequest returns the cursor and the event filter of a stream request.
+// The stream starts at the height in the from query parameter, or at the last
+// height if not provided.  On server-sent events, the Last-Event-ID header set
+// by the client on reconnection takes precedence.  The types query parameter
+// is a comma-separated list of event types to stream, all if empty.
+func (u *URLAPI) eventsRequest(r *http.Request) (eventstream.Cursor, func(*eventstream.Event) bool, error) {
+	cursor := eventstream.Cursor{Height: u.eventstream.LastHeight() + 1}
+	if from := r.URL.Query().Get("from"); from != "" {
+		var err error
+		if cursor, err = eventstream.ParseCursor(from); err != nil {
+			return cursor, nil, err
+		}
+	}
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		c, err := eventstream.ParseCursor(last)
+		if err != nil {
+			return cursor, nil, err
+		}
+		cursor = eventstream.Cursor{Height: c.Height, Index: c.Index + 1}
+	}
+	eventTypes := make(map[string]bool)
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if t != "" {
+			eventTypes[t] = true
+		}
+	}
+	filter := func(e *eventstream.Event) bool {
+		return len(eventTypes) == 0 || eventTypes[e.Type]
+	}
+	return cursor, filter, nil
+}
+
+// addEventSubscriber reserves a place for a new event stream subscriber.
+// Returns false if the maximum number of subscribers is reached.
+func (u *URLAPI) addEventSubscriber() bool {
+	if atomic.AddInt32(&u.eventSubscribers, 1) > atomic.LoadInt32(&u.eventsMaxSubscribers) {
+		atomic.AddInt32(&u.eventSubscribers, -1)
+		return false
+	}
+	return true
+}
+
+// delEventSubscriber releases the place of an event stream subscriber.
+func (u *URLAPI) delEventSubscriber() {
+	atomic.AddInt32(&u.eventSubscribers, -1)
+}
+
+// SetEventsMaxSubscribers sets the maximum number of event stream subscribers,
+// counting both the server-sent events and the WebSocket streams.
+func (u *URLAPI) SetEventsMaxSubscribers(max int) {
+	atomic.StoreInt32(&u.eventsMaxSubscribers, int32(max))
+}
+
+// /events/sse?from=<height>&types=<type1,type2>
+// streams the events as server-sent events, with the cursor as event id.  The
+// stream lasts until the client disconnects or the server write timeout
+// closes it, the client is expected to reconnect using the Last-Event-ID.
+func (u *URLAPI) eventsSSEHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, filter, err := u.eventsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	if !u.addEventSubscriber() {
+		http.Error(w, "too many event subscribers", http.StatusServiceUnavailable)
+		return
+	}
+	defer u.delEventSubscriber()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var lock sync.Mutex
+	write := func(msg string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if _, err := io.WriteString(w, msg); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	if err := write("retry: 1000\n\n"); err != nil {
+		return
+	}
+	// send a comment periodically until the stream is closed
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(eventsKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := write(": keep-alive\n\n"); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	if err := u.eventstream.Stream(ctx, cursor, func(e *eventstream.Event) error {
+		if !filter(e) {
+			return nil
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return write(fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", e.Cursor(), e.Type, data))
+	}); err != nil && err != context.Canceled {
+		log.Debugf("event stream closed: %v", err)
+	}
+}
+
+// /events/ws?from=<height>&types=<type1,type2>
+// streams the events as JSON WebSocket messages
+func (u *URLAPI) eventsWSHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, filter, err := u.eventsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !u.addEventSubscriber() {
+		http.Error(w, "too many event subscribers", http.StatusServiceUnavailable)
+		return
+	}
+	defer u.delEventSubscriber()
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an error
+		log.Debugf("cannot upgrade to websocket: %v", err)
+		return
+	}
+	defer conn.Close()
+	if err := conn.UnderlyingConn().SetDeadline(time.Time{}); err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// read until the connection is closed, so control messages are handled
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+	var lock sync.Mutex
+	go func() {
+		ticker := time.NewTicker(eventsKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				lock.Lock()
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventsWriteTimeout))
+				lock.Unlock()
+				if err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	if err := u.eventstream.Stream(ctx, cursor, func(e *eventstream.Event) error {
+		if !filter(e) {
+			return nil
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if err := conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout)); err != nil {
+			return err
+		}
+		return conn.WriteJSON(e)
+	}); err != nil && err != context.Canceled {
+		log.Debugf("event stream closed: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package urlapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/eventstream"
+)
+
+// readSSEEvent reads the lines of the next server-sent event, skipping the
+// comments and the retry field.
+func readSSEEvent(t *testing.T, r *bufio.Reader) map[string]string {
+	event := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		qt.Assert(t, err, qt.IsNil)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(event) > 0 {
+				return event
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") || strings.HasPrefix(line, "retry:") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		event[field] = value
+	}
+}
+
+func TestEventsSSE(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	es, err := eventstream.NewEventStream(t.TempDir(), app)
+	qt.Assert(t, err, qt.IsNil)
+	t.Cleanup(func() { es.Close() })
+	u := &URLAPI{eventstream: es, eventsMaxSubscribers: 1}
+
+	pid := util.RandomBytes(32)
+	es.OnProcess(pid, util.RandomBytes(20), "root", "uri", 0)
+	qt.Assert(t, es.Commit(3), qt.IsNil)
+
+	srv := httptest.NewServer(http.HandlerFunc(u.eventsSSEHandler))
+	t.Cleanup(srv.Close)
+
+	// invalid cursor
+	resp, err := http.Get(srv.URL + "?from=x")
+	qt.Assert(t, err, qt.IsNil)
+	resp.Body.Close()
+	qt.Assert(t, resp.StatusCode, qt.Equals, http.StatusBadRequest)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"?from=3&types=process,vote", nil)
+	qt.Assert(t, err, qt.IsNil)
+	resp, err = http.DefaultClient.Do(req)
+	qt.Assert(t, err, qt.IsNil)
+	defer resp.Body.Close()
+	qt.Assert(t, resp.StatusCode, qt.Equals, http.StatusOK)
+	qt.Assert(t, resp.Header.Get("Content-Type"), qt.Equals, "text/event-stream")
+	stream := bufio.NewReader(resp.Body)
+
+	event := readSSEEvent(t, stream)
+	qt.Assert(t, event["id"], qt.Equals, "3-0")
+	qt.Assert(t, event["event"], qt.Equals, eventstream.EventProcess)
+	e := &eventstream.Event{}
+	qt.Assert(t, json.Unmarshal([]byte(event["data"]), e), qt.IsNil)
+	qt.Assert(t, []byte(e.ProcessID), qt.DeepEquals, pid)
+
+	// a new block is streamed once committed, filtering the event types
+	es.OnCancel(pid, 0)
+	es.OnProcessesStart([][]byte{pid})
+	es.OnProcess(pid, util.RandomBytes(20), "root", "uri", 1)
+	qt.Assert(t, es.Commit(4), qt.IsNil)
+	event = readSSEEvent(t, stream)
+	qt.Assert(t, event["id"], qt.Equals, "4-2")
+	qt.Assert(t, event["event"], qt.Equals, eventstream.EventProcess)
+
+	// a single subscriber is allowed
+	resp2, err := http.Get(srv.URL)
+	qt.Assert(t, err, qt.IsNil)
+	resp2.Body.Close()
+	qt.Assert(t, resp2.StatusCode, qt.Equals, http.StatusServiceUnavailable)
+
+	// the place is released once the subscriber disconnects
+	cancel()
+	resp.Body.Close()
+	for i := 0; ; i++ {
+		if u.addEventSubscriber() {
+			u.delEventSubscriber()
+			break
+		}
+		qt.Assert(t, i < 1000, qt.IsTrue, qt.Commentf("subscriber not released"))
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEventsSSEReconnect(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	es, err := eventstream.NewEventStream(t.TempDir(), app)
+	qt.Assert(t, err, qt.IsNil)
+	t.Cleanup(func() { es.Close() })
+	u := &URLAPI{eventstream: es, eventsMaxSubscribers: DefaultEventsMaxSubscribers}
+
+	pid := util.RandomBytes(32)
+	es.OnProcess(pid, util.RandomBytes(20), "root", "uri", 0)
+	es.OnCancel(pid, 1)
+	qt.Assert(t, es.Commit(3), qt.IsNil)
+
+	srv := httptest.NewServer(http.HandlerFunc(u.eventsSSEHandler))
+	t.Cleanup(srv.Close)
+
+	// the Last-Event-ID resumes the stream after the last received event
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"?from=0", nil)
+	qt.Assert(t, err, qt.IsNil)
+	req.Header.Set("Last-Event-ID", "3-0")
+	resp, err := http.DefaultClient.Do(req)
+	qt.Assert(t, err, qt.IsNil)
+	defer resp.Body.Close()
+	event := readSSEEvent(t, bufio.NewReader(resp.Body))
+	qt.Assert(t, event["id"], qt.Equals, "3-1")
+	qt.Assert(t, event["event"], qt.Equals, eventstream.EventProcessCancel)
+}
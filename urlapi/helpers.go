@@ -8,12 +8,15 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/google/uuid"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
 	"go.vocdoni.io/proto/build/go/models"
 )
 
@@ -24,16 +27,46 @@ func (u *URLAPI) getProcessSummaryList(pids ...[]byte) ([]*ElectionSummary, erro
 		if err != nil {
 			return nil, fmt.Errorf("cannot fetch election info: %w", err)
 		}
-		processes = append(processes, &ElectionSummary{
+		summary := &ElectionSummary{
 			ElectionID: procInfo.ID,
 			Status:     models.ProcessStatus_name[procInfo.Status],
 			StartDate:  procInfo.CreationTime,
 			EndDate:    u.vocinfo.HeightTime(int64(procInfo.EndBlock)),
-		})
+			StartBlock: procInfo.StartBlock,
+			EndBlock:   procInfo.EndBlock,
+		}
+		if procInfo.StartDate != nil && procInfo.EndDate != nil {
+			summary.StartDate, summary.EndDate = *procInfo.StartDate, *procInfo.EndDate
+		}
+		processes = append(processes, summary)
 	}
 	return processes, nil
 }
 
+// electionDates returns the start and end dates of an election.  The dates of
+// a time-based election are the declared ones, else they are estimated from
+// its start and end blocks.
+func (u *URLAPI) electionDates(proc *indexertypes.Process) (time.Time, time.Time) {
+	if proc.StartDate != nil && proc.EndDate != nil {
+		return *proc.StartDate, *proc.EndDate
+	}
+	return u.vocinfo.HeightTime(int64(proc.StartBlock)), u.vocinfo.HeightTime(int64(proc.EndBlock))
+}
+
+// heightParam returns the optional {height} URL parameter, used to read the
+// state as it was committed at a past block.  If not present, 0 is returned
+// which means the last committed state.
+func heightParam(ctx *httprouter.HTTPContext) (uint32, error) {
+	if ctx.URLParam("height") == "" {
+		return 0, nil
+	}
+	height, err := strconv.ParseUint(ctx.URLParam("height"), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse height: %w", err)
+	}
+	return uint32(height), nil
+}
+
 func (u *URLAPI) formatElectionType(et *models.EnvelopeType) string {
 	ptype := strings.Builder{}
 
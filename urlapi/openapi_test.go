@@ -0,0 +1,190 @@
+package urlapi
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/google/uuid"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/data"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/dvote/internal"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/eventstream"
+	"go.vocdoni.io/dvote/vochain/scrutinizer"
+	"go.vocdoni.io/dvote/vochain/vochaininfo"
+	"go.vocdoni.io/dvote/vochain/webhooks"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// openAPIFile is the OpenAPI specification of the API, used by the clients
+const openAPIFile = "openapi.json"
+
+var updateOpenAPI = flag.Bool("update", false, "update the OpenAPI specification file")
+
+// TestOpenAPI fails if the request and response types of the handlers do not
+// match the OpenAPI specification file. Run with -update after changing them
+// on purpose.
+func TestOpenAPI(t *testing.T) {
+	router := httprouter.HTTProuter{PrometheusID: "urlapi_openapi_test"}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	api, err := NewURLAPI(&router, "/v2", t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+
+	// registering the handlers does not require the modules
+	for _, enable := range []func() error{
+		api.enableAccountHandlers,
+		api.enableCensusHandlers,
+		api.enableChainHandlers,
+		api.enableElectionHandlers,
+		api.enableEventsHandlers,
+		api.enableVoteHandlers,
+		api.enableWalletHandlers,
+		api.enableWebhooksHandlers,
+		api.api.EnableTokenHandlers,
+	} {
+		qt.Assert(t, enable(), qt.IsNil)
+	}
+
+	spec, err := json.MarshalIndent(api.api.OpenAPI(OpenAPITitle, internal.Version), "", "  ")
+	qt.Assert(t, err, qt.IsNil)
+	spec = append(spec, '\n')
+	if *updateOpenAPI {
+		qt.Assert(t, os.WriteFile(openAPIFile, spec, 0o644), qt.IsNil)
+	}
+	expected, err := os.ReadFile(openAPIFile)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, string(spec), qt.Equals, string(expected),
+		qt.Commentf("the API types changed, run go test ./urlapi -run TestOpenAPI -update"))
+
+	// the specification is served by the API
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "v2"))
+	qt.Assert(t, err, qt.IsNil)
+	c := newTestHTTPclient(t, addr, nil)
+	resp, code := c.request("GET", nil, "openapi.json")
+	qt.Assert(t, code, qt.Equals, 200)
+	var served, generated interface{}
+	qt.Assert(t, json.Unmarshal(resp, &served), qt.IsNil)
+	qt.Assert(t, json.Unmarshal(spec, &generated), qt.IsNil)
+	qt.Assert(t, served, qt.DeepEquals, generated)
+}
+
+// TestOpenAPIResponses fails if the replies of the handlers do not match the
+// response types declared on the OpenAPI specification.
+func TestOpenAPIResponses(t *testing.T) {
+	router := httprouter.HTTProuter{PrometheusID: "urlapi_openapi_responses_test"}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	api, err := NewURLAPI(&router, "/v2", t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+
+	app := vochain.TestBaseApplication(t)
+	sc, err := scrutinizer.NewScrutinizer(t.TempDir(), app, true)
+	qt.Assert(t, err, qt.IsNil)
+	storage, err := data.Init(data.FS, &types.DataStore{Datadir: t.TempDir()})
+	qt.Assert(t, err, qt.IsNil)
+	api.Attach(app, vochaininfo.NewVochainInfo(app), sc, storage)
+	es, err := eventstream.NewEventStream(t.TempDir(), app)
+	qt.Assert(t, err, qt.IsNil)
+	t.Cleanup(func() { es.Close() })
+	api.AttachEventStream(es)
+	signer := ethereum.NewSignKeys()
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	wh, err := webhooks.NewWebhooks(t.TempDir(), signer, app, sc)
+	qt.Assert(t, err, qt.IsNil)
+	t.Cleanup(func() { wh.Close() })
+	api.AttachWebhooks(wh)
+	adminToken := uuid.New()
+	api.SetAdminToken(adminToken.String())
+	qt.Assert(t, api.EnableHandlers(ElectionHandler, ChainHandler, AccountHandler,
+		CensusHandler, EventsHandler, WebhooksHandler, TokensHandler), qt.IsNil)
+
+	// an organization with an election
+	account := ethereum.NewSignKeys()
+	qt.Assert(t, account.Generate(), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(account.Address(), "ipfs://info", nil, 10), qt.IsNil)
+	for _, txType := range vochain.TxCostNameToTxTypeMap {
+		qt.Assert(t, app.State.SetTxCost(txType, 1), qt.IsNil)
+	}
+	pid := util.RandomBytes(32)
+	censusURI := "ipfs://census"
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EntityId:     account.Address().Bytes(),
+		BlockCount:   10,
+		CensusURI:    &censusURI,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{AutoStart: true},
+		Status:       models.ProcessStatus_READY,
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+	qt.Assert(t, es.Commit(1), qt.IsNil)
+
+	spec := api.api.OpenAPI(OpenAPITitle, internal.Version)
+	addr, err := url.Parse("http://" + router.Address().String() + "/")
+	qt.Assert(t, err, qt.IsNil)
+	admin := newTestHTTPclient(t, addr, &adminToken)
+	check := func(c *testHTTPclient, method string, body []byte, urlPath ...string) []byte {
+		resp, code := c.request(method, body, urlPath...)
+		p := path.Join(urlPath...)
+		qt.Assert(t, code, qt.Equals, 200, qt.Commentf("%s %s: %s", method, p, resp))
+		qt.Assert(t, spec.ValidateResponse(method, p, code, resp), qt.IsNil)
+		return resp
+	}
+
+	org := fmt.Sprintf("%x", account.Address().Bytes())
+	election := fmt.Sprintf("%x", pid)
+	check(admin, "GET", nil, "v2", "account", account.Address().Hex())
+	check(admin, "GET", nil, "v2", "chain", "info")
+	check(admin, "GET", nil, "v2", "chain", "organization", "list")
+	check(admin, "GET", nil, "v2", "chain", "organization", "count")
+	check(admin, "GET", nil, "v2", "chain", "transaction", "cost")
+	check(admin, "GET", nil, "v2", "election", election)
+	check(admin, "GET", nil, "v2", "election", "list", org)
+	check(admin, "GET", nil, "v2", "election", "count", org)
+	check(admin, "POST", []byte(`{"text":"census"}`), "v2", "election", "search")
+	check(admin, "GET", nil, "v2", "events", "block", "1")
+
+	// the census life cycle
+	resp := check(admin, "GET", nil, "v2", "census", "create", "weighted")
+	census := &Census{}
+	qt.Assert(t, json.Unmarshal(resp, census), qt.IsNil)
+	censusID := fmt.Sprintf("%x", census.CensusID)
+	key := util.RandomBytes(32)
+	check(admin, "GET", nil, "v2", "census", censusID, "add", fmt.Sprintf("%x", key), "10")
+	check(admin, "GET", nil, "v2", "census", censusID, "size")
+	check(admin, "GET", nil, "v2", "census", censusID, "weight")
+	check(admin, "GET", nil, "v2", "census", censusID, "dump")
+	resp = check(admin, "GET", nil, "v2", "census", censusID, "publish")
+	qt.Assert(t, json.Unmarshal(resp, census), qt.IsNil)
+	censusID = fmt.Sprintf("%x", census.CensusID)
+	check(admin, "GET", nil, "v2", "census", censusID, "root")
+	resp = check(admin, "GET", nil, "v2", "census", censusID, "proof", fmt.Sprintf("%x", key))
+	qt.Assert(t, json.Unmarshal(resp, census), qt.IsNil)
+	proof, err := json.Marshal(&Census{Key: key, Proof: census.Proof, Value: census.Value})
+	qt.Assert(t, err, qt.IsNil)
+	check(admin, "POST", proof, "v2", "census", censusID, "verify")
+
+	// the token and webhooks management
+	resp = check(admin, "POST", []byte(`{"scopes":["*"],"requests":10}`), "v2", "tokens", "issue")
+	issued := &bearerstdapi.AuthToken{}
+	qt.Assert(t, json.Unmarshal(resp, issued), qt.IsNil)
+	check(admin, "GET", nil, "v2", "tokens", "list")
+	token, err := uuid.Parse(issued.Token)
+	qt.Assert(t, err, qt.IsNil)
+	c := newTestHTTPclient(t, addr, &token)
+	resp = check(c, "POST", []byte(`{"url":"http://192.0.2.1/hook"}`), "v2", "webhooks", "register")
+	webhook := &Webhook{}
+	qt.Assert(t, json.Unmarshal(resp, webhook), qt.IsNil)
+	check(c, "GET", nil, "v2", "webhooks", "list")
+	check(c, "GET", nil, "v2", "webhooks", webhook.WebhookID, "deliveries")
+}
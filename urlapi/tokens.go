@@ -0,0 +1,81 @@
+package urlapi
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// TokensHandler enables the admin methods to issue, list and revoke auth tokens
+	TokensHandler = "tokens"
+
+	// ScopeCensusWrite allows creating, modifying, publishing and deleting census
+	ScopeCensusWrite = "census:write"
+	// ScopeElectionCreate allows creating elections
+	ScopeElectionCreate = "election:create"
+	// ScopeWallet allows using the wallet methods
+	ScopeWallet = "wallet"
+	// ScopeWebhooks allows registering and managing webhooks
+	ScopeWebhooks = "webhooks"
+
+	tokensDBprefix = "bearer/"
+)
+
+// SetAdminToken sets the bearer token allowed to manage the auth tokens.
+func (u *URLAPI) SetAdminToken(token string) {
+	u.api.SetAdminToken(token)
+}
+
+// CloseTokenStore writes to the database the requests spent by the auth
+// tokens since they were last stored, and stops the periodic writes.  It
+// should be called before the node exits.
+func (u *URLAPI) CloseTokenStore() error {
+	return u.api.CloseTokenStore()
+}
+
+// RequireAuthTokens makes the scoped methods (census write, election
+// creation and wallet) require an auth token issued by the admin, which
+// spends one of its requests on every call. It must be called before
+// EnableHandlers.
+func (u *URLAPI) RequireAuthTokens() {
+	u.requireTokens = true
+}
+
+// scopedAccessType returns the access type of the methods included in a scope.
+func (u *URLAPI) scopedAccessType() string {
+	if u.requireTokens {
+		return bearerstdapi.MethodAccessTypeQuota
+	}
+	return bearerstdapi.MethodAccessTypePublic
+}
+
+// checkTokenOwner returns an error if the auth token is restricted to an
+// organization other than entityID.
+func checkTokenOwner(msg *bearerstdapi.BearerStandardAPIdata, entityID []byte) error {
+	if len(msg.Owner) > 0 && !bytes.Equal(msg.Owner, entityID) {
+		return fmt.Errorf("auth token not allowed to act on behalf of organization %x", entityID)
+	}
+	return nil
+}
+
+// newProcessEntityID returns the organization of a signed new process
+// transaction.
+func newProcessEntityID(txPayload []byte) ([]byte, error) {
+	stx := &models.SignedTx{}
+	if err := proto.Unmarshal(txPayload, stx); err != nil {
+		return nil, fmt.Errorf("could not decode signed transaction: %w", err)
+	}
+	tx := &models.Tx{}
+	if err := proto.Unmarshal(stx.GetTx(), tx); err != nil {
+		return nil, fmt.Errorf("could not decode transaction: %w", err)
+	}
+	newProcess := tx.GetNewProcess()
+	if newProcess == nil || newProcess.GetProcess() == nil {
+		return nil, fmt.Errorf("transaction is not a new process")
+	}
+	return newProcess.GetProcess().GetEntityId(), nil
+}
@@ -9,16 +9,24 @@ import (
 	"go.vocdoni.io/dvote/data"
 	"go.vocdoni.io/dvote/db"
 	"go.vocdoni.io/dvote/db/metadb"
+	"go.vocdoni.io/dvote/db/prefixeddb"
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/dvote/internal"
 	"go.vocdoni.io/dvote/metrics"
 	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/eventstream"
 	"go.vocdoni.io/dvote/vochain/scrutinizer"
 	"go.vocdoni.io/dvote/vochain/vochaininfo"
+	"go.vocdoni.io/dvote/vochain/webhooks"
 )
 
-// MaxPageSize defines the maximum number of results returned by the paginated endpoints
-const MaxPageSize = 10
+const (
+	// MaxPageSize defines the maximum number of results returned by the paginated endpoints
+	MaxPageSize = 10
+	// OpenAPITitle is the title of the OpenAPI specification served by the API
+	OpenAPITitle = "Vocdoni URL API"
+)
 
 // URLAPI is the URL based REST API supporting bearer authentication.
 type URLAPI struct {
@@ -34,9 +42,17 @@ type URLAPI struct {
 	//lint:ignore U1000 unused
 	metricsagent *metrics.Agent
 	vocinfo      *vochaininfo.VochainInfo
+	eventstream  *eventstream.EventStream
+	webhooks     *webhooks.Webhooks
 	censusMap    sync.Map
 
-	db db.Database
+	// eventSubscribers is the number of event stream subscribers, up to
+	// eventsMaxSubscribers
+	eventSubscribers     int32
+	eventsMaxSubscribers int32
+
+	db            db.Database
+	requireTokens bool
 }
 
 // NewURLAPI creates a new instance of the URLAPI.  Attach must be called next.
@@ -52,8 +68,9 @@ func NewURLAPI(router *httprouter.HTTProuter, baseRoute, dataDir string) (*URLAP
 		baseRoute = strings.TrimSuffix(baseRoute, "/")
 	}
 	urlapi := URLAPI{
-		BaseRoute: baseRoute,
-		router:    router,
+		BaseRoute:            baseRoute,
+		router:               router,
+		eventsMaxSubscribers: DefaultEventsMaxSubscribers,
 	}
 	var err error
 	urlapi.api, err = bearerstdapi.NewBearerStandardAPI(router, baseRoute)
@@ -65,6 +82,15 @@ func NewURLAPI(router *httprouter.HTTProuter, baseRoute, dataDir string) (*URLAP
 	if err != nil {
 		return nil, err
 	}
+	// Keep the auth tokens across restarts
+	if err := urlapi.api.EnableTokenStore(
+		prefixeddb.NewPrefixedDatabase(urlapi.db, []byte(tokensDBprefix))); err != nil {
+		return nil, err
+	}
+	// Serve the OpenAPI specification of the enabled handlers
+	if err := urlapi.api.EnableOpenAPIHandler(OpenAPITitle, internal.Version); err != nil {
+		return nil, err
+	}
 
 	return &urlapi, nil
 }
@@ -79,6 +105,18 @@ func (u *URLAPI) Attach(vocdoniAPP *vochain.BaseApplication, vocdoniInfo *vochai
 	u.storage = data
 }
 
+// AttachEventStream attaches the event stream used by the events handler.
+// It must be called before EnableHandlers.
+func (u *URLAPI) AttachEventStream(es *eventstream.EventStream) {
+	u.eventstream = es
+}
+
+// AttachWebhooks attaches the webhooks used by the webhooks handler.
+// It must be called before EnableHandlers.
+func (u *URLAPI) AttachWebhooks(wh *webhooks.Webhooks) {
+	u.webhooks = wh
+}
+
 // EnableHandlers enables the list of handlers. Attach must be called before.
 func (u *URLAPI) EnableHandlers(handlers ...string) error {
 	for _, h := range handlers {
@@ -113,6 +151,20 @@ func (u *URLAPI) EnableHandlers(handlers ...string) error {
 				return fmt.Errorf("missing modules attached for enabling census handler")
 			}
 			u.enableCensusHandlers()
+		case EventsHandler:
+			if u.eventstream == nil {
+				return fmt.Errorf("missing modules attached for enabling events handler")
+			}
+			u.enableEventsHandlers()
+		case WebhooksHandler:
+			if u.webhooks == nil {
+				return fmt.Errorf("missing modules attached for enabling webhooks handler")
+			}
+			u.enableWebhooksHandlers()
+		case TokensHandler:
+			if err := u.api.EnableTokenHandlers(); err != nil {
+				return err
+			}
 		default:
 			return fmt.Errorf("handler unknown %s", h)
 		}
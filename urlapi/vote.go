@@ -9,6 +9,7 @@ import (
 	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
 )
 
 const VoteHandler = "vote"
@@ -19,6 +20,7 @@ func (u *URLAPI) enableVoteHandlers() error {
 		"POST",
 		bearerstdapi.MethodAccessTypePublic,
 		u.submitVoteHandler,
+		&bearerstdapi.MethodSpec{Summary: "Submit a signed vote transaction", Request: &Vote{}, Response: &Vote{}},
 	); err != nil {
 		return err
 	}
@@ -27,6 +29,7 @@ func (u *URLAPI) enableVoteHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.getVoteHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get a vote", Response: &Vote{}},
 	); err != nil {
 		return err
 	}
@@ -35,6 +38,16 @@ func (u *URLAPI) enableVoteHandlers() error {
 		"GET",
 		bearerstdapi.MethodAccessTypePublic,
 		u.verifyVoteHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the receipt of a vote", Response: &indexertypes.VoteReceipt{}},
+	); err != nil {
+		return err
+	}
+	if err := u.api.RegisterMethod(
+		"/vote/{voteID}/{electionID}/verify/height/{height}",
+		"GET",
+		bearerstdapi.MethodAccessTypePublic,
+		u.verifyVoteHandler,
+		&bearerstdapi.MethodSpec{Summary: "Get the receipt of a vote at a block height", Response: &indexertypes.VoteReceipt{}},
 	); err != nil {
 		return err
 	}
@@ -90,17 +103,24 @@ func (u *URLAPI) getVoteHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *ht
 		VoteNumber:           &voteData.Meta.Height,
 		ElectionID:           voteData.Meta.ProcessId,
 		VoterID:              voteData.Meta.VoterID,
+		OverwriteCount:       &voteData.Meta.OverwriteCount,
 	}
 
 	// check if votePackage is not encrypted
 	if _, err := json.Marshal(voteData.VotePackage); err != nil {
 		vote.VotePackage = string(voteData.VotePackage)
 	}
-	return nil
+	var data []byte
+	if data, err = json.Marshal(vote); err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
 }
 
 // /vote/<voteID>/<electionID>/verify
-// verify a vote (get basic information)
+// /vote/<voteID>/<electionID>/verify/height/<height>
+// returns the receipt of a vote registered in the state, which can be
+// verified offline (see client.VerifyVoteReceipt)
 func (u *URLAPI) verifyVoteHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
 	voteID, err := hex.DecodeString(util.TrimHex(ctx.URLParam("voteID")))
 	if err != nil {
@@ -113,11 +133,20 @@ func (u *URLAPI) verifyVoteHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx
 	if err != nil {
 		return fmt.Errorf("cannot decode electionID: %w", err)
 	}
-	if len(voteID) != types.ProcessIDsize {
-		return fmt.Errorf("malformed voteId")
+	if len(electionID) != types.ProcessIDsize {
+		return fmt.Errorf("malformed electionId")
 	}
-	if ok, err := u.vocapp.State.EnvelopeExists(electionID, voteID, true); !ok || err != nil {
-		return fmt.Errorf("not registered")
+	height, err := heightParam(ctx)
+	if err != nil {
+		return err
 	}
-	return nil
+	receipt, err := u.scrutinizer.VoteReceipt(electionID, voteID, height)
+	if err != nil {
+		return fmt.Errorf("cannot get vote receipt: %w", err)
+	}
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %w", err)
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
 }
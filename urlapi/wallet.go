@@ -17,6 +17,7 @@ import (
 	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
 )
@@ -33,36 +34,46 @@ func (u *URLAPI) enableWalletHandlers() error {
 	if err := u.api.RegisterMethod(
 		"/wallet/add/{privateKey}",
 		"GET",
-		bearerstdapi.MethodAccessTypePublic,
+		u.scopedAccessType(),
 		u.walletAddHandler,
+		&bearerstdapi.MethodSpec{Summary: "Add a wallet from its private key", Response: &Account{}},
 	); err != nil {
 		return err
 	}
 	if err := u.api.RegisterMethod(
 		"/wallet/bootstrap",
 		"GET",
-		bearerstdapi.MethodAccessTypePublic,
+		u.scopedAccessType(),
 		u.walletCreateHandler,
+		&bearerstdapi.MethodSpec{Summary: "Create the account of the wallet", Response: &Transaction{}},
 	); err != nil {
 		return err
 	}
 	if err := u.api.RegisterMethod(
 		"/wallet/transfer/{dstAddress}/{amount}",
 		"GET",
-		bearerstdapi.MethodAccessTypePublic,
+		u.scopedAccessType(),
 		u.walletTransferHandler,
+		&bearerstdapi.MethodSpec{Summary: "Transfer tokens from the wallet", Response: &Transaction{}},
 	); err != nil {
 		return err
 	}
 	if err := u.api.RegisterMethod(
 		"/wallet/election",
 		"POST",
-		bearerstdapi.MethodAccessTypePublic,
+		u.scopedAccessType(),
 		u.walletElectionHandler,
+		&bearerstdapi.MethodSpec{Summary: "Create an election signed by the wallet", Request: &ElectionDescription{}, Response: &Transaction{}},
 	); err != nil {
 		return err
 	}
 
+	u.api.AddScope(ScopeWallet,
+		"/wallet/add/{privateKey}",
+		"/wallet/bootstrap",
+		"/wallet/transfer/{dstAddress}/{amount}",
+		"/wallet/election",
+	)
 	return nil
 }
 
@@ -290,6 +301,9 @@ func (u *URLAPI) walletElectionHandler(msg *bearerstdapi.BearerStandardAPIdata,
 	if err != nil {
 		return err
 	}
+	if err := checkTokenOwner(msg, wallet.Address().Bytes()); err != nil {
+		return err
+	}
 	acc, err := u.vocapp.State.GetAccount(wallet.Address(), true)
 	if err != nil {
 		return err
@@ -300,37 +314,16 @@ func (u *URLAPI) walletElectionHandler(msg *bearerstdapi.BearerStandardAPIdata,
 		return fmt.Errorf("could not unmarshal JSON: %w", err)
 	}
 
-	// Set startBlock and endBlock
-	var startBlock uint32
-	// if start date is empty, do not attempt to parse it. Set startBlock to 0, starting the
-	// election immediately. Otherwise, ensure the startBlock is in the future
-	if !description.StartDate.IsZero() {
-		if startBlock, err = u.vocinfo.EstimateBlockHeight(description.StartDate); err != nil {
-			return fmt.Errorf("unable to estimate startDate block height: %w", err)
-		}
-	} else {
-		description.StartDate = time.Now().Add(time.Minute * 10)
-	}
-
+	// The election is scheduled by its start and end dates, compared by the
+	// Vochain against the block time.  An empty start date starts the election
+	// on the next block.
 	if description.EndDate.Before(time.Now()) {
 		return fmt.Errorf("election end date cannot be in the past")
 	}
-	endBlock, err := u.vocinfo.EstimateBlockHeight(description.EndDate)
-	if err != nil {
-		return fmt.Errorf("unable to estimate endDate block height: %w", err)
-	}
-	if description.EndDate.Before(description.StartDate) {
+	if !description.StartDate.IsZero() && !description.EndDate.After(description.StartDate) {
 		return fmt.Errorf("end date must be after start date")
 	}
 
-	// Calculate block count
-	blockCount := endBlock - startBlock
-	if startBlock == 0 {
-		// If startBlock is set to 0 (process starts asap), set the blockcount to the desired
-		// end block, minus the expected start block of the process
-		blockCount = blockCount - uint32(u.vocinfo.Height()) + 3
-	}
-
 	// Set the envelope and process models
 	envelopeType := &models.EnvelopeType{
 		Serial:         false,
@@ -413,8 +406,6 @@ func (u *URLAPI) walletElectionHandler(msg *bearerstdapi.BearerStandardAPIdata,
 	// Build the process transaction
 	process := &models.Process{
 		EntityId:     wallet.Address().Bytes(),
-		StartBlock:   startBlock,
-		BlockCount:   blockCount,
 		CensusRoot:   root,
 		CensusURI:    &description.Census.URL,
 		Status:       models.ProcessStatus_READY,
@@ -424,6 +415,7 @@ func (u *URLAPI) walletElectionHandler(msg *bearerstdapi.BearerStandardAPIdata,
 		CensusOrigin: censusOrigin,
 		Metadata:     &metadataURI,
 	}
+	vochain.SetProcessTimes(process, description.StartDate, description.EndDate)
 
 	log.Debugf(log.FormatProto(process))
 
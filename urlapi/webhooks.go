@@ -0,0 +1,170 @@
+package urlapi
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/dvote/vochain/webhooks"
+)
+
+const WebhooksHandler = "webhooks"
+
+func (u *URLAPI) enableWebhooksHandlers() error {
+	if err := u.api.RegisterMethod(
+		"/webhooks/register",
+		"POST",
+		bearerstdapi.MethodAccessTypePrivate,
+		u.webhooksRegisterHandler,
+		&bearerstdapi.MethodSpec{Summary: "Register a webhook", Request: &Webhook{}, Response: &Webhook{}},
+	); err != nil {
+		return err
+	}
+	if err := u.api.RegisterMethod(
+		"/webhooks/list",
+		"GET",
+		bearerstdapi.MethodAccessTypePrivate,
+		u.webhooksListHandler,
+		&bearerstdapi.MethodSpec{Summary: "List the webhooks", Response: &Webhooks{}},
+	); err != nil {
+		return err
+	}
+	if err := u.api.RegisterMethod(
+		"/webhooks/{webhookID}/deliveries",
+		"GET",
+		bearerstdapi.MethodAccessTypePrivate,
+		u.webhooksDeliveriesHandler,
+		&bearerstdapi.MethodSpec{Summary: "List the deliveries of a webhook", Response: &WebhookDeliveries{}},
+	); err != nil {
+		return err
+	}
+	if err := u.api.RegisterMethod(
+		"/webhooks/{webhookID}",
+		"DELETE",
+		bearerstdapi.MethodAccessTypePrivate,
+		u.webhooksDeleteHandler,
+		&bearerstdapi.MethodSpec{Summary: "Delete a webhook"},
+	); err != nil {
+		return err
+	}
+
+	u.api.AddScope(ScopeWebhooks,
+		"/webhooks/register",
+		"/webhooks/list",
+		"/webhooks/{webhookID}/deliveries",
+		"/webhooks/{webhookID}",
+	)
+	return nil
+}
+
+// webhookOwner returns the owner of the webhooks registered with the auth
+// token, already validated by the router.  The token itself is not stored.
+func webhookOwner(authToken string) (string, error) {
+	if authToken == "" {
+		return "", fmt.Errorf("missing auth token")
+	}
+	return fmt.Sprintf("%x", ethereum.HashRaw([]byte(authToken))), nil
+}
+
+// loadWebhook returns the webhook if it was registered with the bearer token.
+func (u *URLAPI) loadWebhook(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext) (*webhooks.Webhook, error) {
+	owner, err := webhookOwner(msg.AuthToken)
+	if err != nil {
+		return nil, err
+	}
+	w, err := u.webhooks.Webhook(ctx.URLParam("webhookID"))
+	if err != nil {
+		return nil, err
+	}
+	if w.Owner != owner {
+		return nil, fmt.Errorf("wrong authentication token")
+	}
+	return w, nil
+}
+
+// /webhooks/register
+// register a new webhook for the election events
+func (u *URLAPI) webhooksRegisterHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+	owner, err := webhookOwner(msg.AuthToken)
+	if err != nil {
+		return err
+	}
+	req := &Webhook{}
+	if err := json.Unmarshal(msg.Data, req); err != nil {
+		return err
+	}
+	id, err := u.webhooks.Add(&webhooks.Webhook{
+		URL:       req.URL,
+		EntityID:  req.EntityID,
+		ProcessID: req.ElectionID,
+		Events:    req.Events,
+		Owner:     owner,
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&Webhook{WebhookID: id, Signer: u.webhooks.Signer()})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// /webhooks/list
+// list the webhooks registered with the bearer token
+func (u *URLAPI) webhooksListHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+	owner, err := webhookOwner(msg.AuthToken)
+	if err != nil {
+		return err
+	}
+	list := []*Webhook{}
+	for _, w := range u.webhooks.List(owner) {
+		list = append(list, &Webhook{
+			WebhookID:  w.ID,
+			URL:        w.URL,
+			EntityID:   w.EntityID,
+			ElectionID: w.ProcessID,
+			Events:     w.Events,
+			Created:    &w.Created,
+		})
+	}
+	data, err := json.Marshal(&Webhooks{Webhooks: list, Signer: u.webhooks.Signer()})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// /webhooks/{webhookID}/deliveries
+// list the pending and finished deliveries of a webhook
+func (u *URLAPI) webhooksDeliveriesHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+	w, err := u.loadWebhook(msg, ctx)
+	if err != nil {
+		return err
+	}
+	deliveries, err := u.webhooks.Deliveries(w.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&WebhookDeliveries{Deliveries: deliveries})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// DELETE /webhooks/{webhookID}
+// delete a webhook, its pending deliveries are discarded
+func (u *URLAPI) webhooksDeleteHandler(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+	w, err := u.loadWebhook(msg, ctx)
+	if err != nil {
+		return err
+	}
+	if err := u.webhooks.Remove(w.ID); err != nil {
+		return err
+	}
+	return ctx.Send(nil, bearerstdapi.HTTPstatusCodeOK)
+}
@@ -0,0 +1,83 @@
+package urlapi
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/google/uuid"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/webhooks"
+)
+
+func TestWebhooksHandlers(t *testing.T) {
+	router := httprouter.HTTProuter{PrometheusID: "urlapi_webhooks_test"}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "webhooks"))
+	qt.Assert(t, err, qt.IsNil)
+	api, err := NewURLAPI(&router, "/", t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+
+	signer := ethereum.NewSignKeys()
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	wh, err := webhooks.NewWebhooks(t.TempDir(), signer, vochain.TestBaseApplication(t), nil)
+	qt.Assert(t, err, qt.IsNil)
+	t.Cleanup(func() { wh.Close() })
+	api.AttachWebhooks(wh)
+	qt.Assert(t, api.EnableHandlers(WebhooksHandler), qt.IsNil)
+
+	register, err := json.Marshal(&Webhook{URL: "http://93.184.216.34/hook"})
+	qt.Assert(t, err, qt.IsNil)
+
+	// a token not issued is not allowed
+	random := uuid.New()
+	_, code := newTestHTTPclient(t, addr, &random).request("POST", register, "register")
+	qt.Assert(t, code, qt.Not(qt.Equals), 200)
+
+	// nor a token issued for another scope
+	api.api.AddScope("other", "/other")
+	other := uuid.New()
+	_, err = api.api.IssueToken(&bearerstdapi.AuthToken{Token: other.String(), Scopes: []string{"other"}})
+	qt.Assert(t, err, qt.IsNil)
+	_, code = newTestHTTPclient(t, addr, &other).request("POST", register, "register")
+	qt.Assert(t, code, qt.Not(qt.Equals), 200)
+
+	token := uuid.New()
+	_, err = api.api.IssueToken(&bearerstdapi.AuthToken{Token: token.String(), Scopes: []string{ScopeWebhooks}})
+	qt.Assert(t, err, qt.IsNil)
+	c := newTestHTTPclient(t, addr, &token)
+
+	// the private addresses are rejected
+	private, err := json.Marshal(&Webhook{URL: "http://169.254.169.254/latest/meta-data"})
+	qt.Assert(t, err, qt.IsNil)
+	_, code = c.request("POST", private, "register")
+	qt.Assert(t, code, qt.Not(qt.Equals), 200)
+
+	resp, code := c.request("POST", register, "register")
+	qt.Assert(t, code, qt.Equals, 200)
+	registered := &Webhook{}
+	qt.Assert(t, json.Unmarshal(resp, registered), qt.IsNil)
+	qt.Assert(t, registered.Signer, qt.Equals, signer.AddressString())
+
+	resp, code = c.request("GET", nil, "list")
+	qt.Assert(t, code, qt.Equals, 200)
+	list := &Webhooks{}
+	qt.Assert(t, json.Unmarshal(resp, list), qt.IsNil)
+	qt.Assert(t, list.Webhooks, qt.HasLen, 1)
+	qt.Assert(t, list.Webhooks[0].WebhookID, qt.Equals, registered.WebhookID)
+
+	// the webhook is deleted with the DELETE method
+	_, code = c.request("GET", nil, registered.WebhookID, "delete")
+	qt.Assert(t, code, qt.Not(qt.Equals), 200)
+	_, code = c.request("DELETE", nil, registered.WebhookID)
+	qt.Assert(t, code, qt.Equals, 200)
+	resp, code = c.request("GET", nil, "list")
+	qt.Assert(t, code, qt.Equals, 200)
+	qt.Assert(t, json.Unmarshal(resp, list), qt.IsNil)
+	qt.Assert(t, list.Webhooks, qt.HasLen, 0)
+}
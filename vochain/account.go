@@ -13,6 +13,7 @@ import (
 	"github.com/vocdoni/arbo"
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/statedb"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
@@ -139,12 +140,27 @@ func (v *State) MintBalance(address common.Address, amount uint64) error {
 // Returns a nil account and no error if the account does not exist.
 // committed is relative to the state on which the function is executed
 func (v *State) GetAccount(address common.Address, committed bool) (*Account, error) {
-	var acc Account
 	if !committed {
 		v.Tx.RLock()
 		defer v.Tx.RUnlock()
 	}
-	raw, err := v.mainTreeViewer(committed).DeepGet(address.Bytes(), StateTreeCfg(TreeAccounts))
+	return getAccount(v.mainTreeViewer(committed), address)
+}
+
+// GetAccountAt retrives the Account for an address as it was committed at the
+// given height.  If height is 0, the last committed version is used.
+// Returns a nil account and no error if the account does not exist.
+func (v *State) GetAccountAt(address common.Address, height uint32) (*Account, error) {
+	mainTreeView, err := v.mainTreeViewAt(height)
+	if err != nil {
+		return nil, err
+	}
+	return getAccount(mainTreeView, address)
+}
+
+func getAccount(mainTreeView statedb.TreeViewer, address common.Address) (*Account, error) {
+	var acc Account
+	raw, err := mainTreeView.DeepGet(address.Bytes(), StateTreeCfg(TreeAccounts))
 	if errors.Is(err, arbo.ErrKeyNotFound) {
 		return nil, nil
 	} else if err != nil {
@@ -557,14 +573,10 @@ func MintTokensTxCheck(vtx *models.Tx, txBytes, signature []byte, state *State)
 	if err != nil {
 		return common.Address{}, 0, fmt.Errorf("cannot extract address from public key: %w", err)
 	}
-	// check signature recovered address
-	treasurerAddress := common.BytesToAddress(treasurer.Address)
-	if treasurerAddress != sigAddress {
-		return common.Address{}, 0, fmt.Errorf(
-			"address recovered not treasurer: expected %s got %s",
-			treasurerAddress.String(),
-			sigAddress.String(),
-		)
+	// check signature recovered address is the treasurer, or a multisig
+	// signer executing an approved proposal
+	if err := state.VerifyTreasurerTx(vtx, sigAddress, tx.Nonce); err != nil {
+		return common.Address{}, 0, fmt.Errorf("address recovered %s not authorized: %w", sigAddress, err)
 	}
 	return toAddr, tx.Value, nil
 }
@@ -5,7 +5,10 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
 	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	tmtypes "github.com/tendermint/tendermint/types"
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
@@ -162,3 +165,247 @@ func testRemoveOracle(t *testing.T, oracle *ethereum.SignKeys,
 	app.Commit()
 	return nil
 }
+
+func TestValidators(t *testing.T) {
+	app := TestBaseApplication(t)
+	treasurer := ethereum.SignKeys{}
+	qt.Assert(t, treasurer.Generate(), qt.IsNil)
+	qt.Assert(t, app.State.SetTreasurer(treasurer.Address(), 0), qt.IsNil)
+	genesis := ed25519.GenPrivKey().PubKey()
+	qt.Assert(t, app.State.AddValidator(&models.Validator{
+		Address: genesis.Address(),
+		PubKey:  genesis.Bytes(),
+		Power:   10,
+	}), qt.IsNil)
+	newValidator := ed25519.GenPrivKey().PubKey()
+	power := func(p uint64) *uint64 { return &p }
+
+	// only the treasurer can add validators
+	other := ethereum.SignKeys{}
+	qt.Assert(t, other.Generate(), qt.IsNil)
+	qt.Assert(t, testValidatorTx(t, &other, app, &models.AdminTx{
+		Txtype:    models.TxType_ADD_VALIDATOR,
+		PublicKey: newValidator.Bytes(),
+		Power:     power(5),
+	}), qt.IsNotNil)
+
+	// invalid public key, power or address should fail
+	for _, tx := range []*models.AdminTx{
+		{PublicKey: newValidator.Bytes()[1:], Power: power(5)},
+		{PublicKey: newValidator.Bytes()},
+		{PublicKey: newValidator.Bytes(), Power: power(0)},
+		{PublicKey: newValidator.Bytes(), Power: power(5), Address: genesis.Address()},
+	} {
+		tx.Txtype = models.TxType_ADD_VALIDATOR
+		qt.Assert(t, testValidatorTx(t, &treasurer, app, tx), qt.IsNotNil)
+	}
+
+	// add a validator and reweight it on the same block, tendermint
+	// receives a single update
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:    models.TxType_ADD_VALIDATOR,
+		PublicKey: newValidator.Bytes(),
+		Power:     power(5),
+		Nonce:     0,
+	}), qt.IsNil)
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:    models.TxType_ADD_VALIDATOR,
+		Address:   newValidator.Address(),
+		PublicKey: newValidator.Bytes(),
+		Power:     power(20),
+		Nonce:     1,
+	}), qt.IsNil)
+	// same power should fail
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:    models.TxType_ADD_VALIDATOR,
+		PublicKey: newValidator.Bytes(),
+		Power:     power(20),
+		Nonce:     2,
+	}), qt.IsNotNil)
+	resp := app.EndBlock(abcitypes.RequestEndBlock{Height: 1})
+	qt.Assert(t, resp.ValidatorUpdates, qt.HasLen, 1)
+	qt.Assert(t, resp.ValidatorUpdates[0].PubKey.GetEd25519(), qt.DeepEquals, newValidator.Bytes())
+	qt.Assert(t, resp.ValidatorUpdates[0].Power, qt.Equals, int64(20))
+	app.Commit()
+	qt.Assert(t, app.State.ValidatorUpdates(), qt.HasLen, 0)
+	validators, err := app.State.Validators(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, validators, qt.HasLen, 2)
+
+	// a rolled back block does not update the validators
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:  models.TxType_REMOVE_VALIDATOR,
+		Address: genesis.Address(),
+		Nonce:   2,
+	}), qt.IsNil)
+	app.State.Rollback()
+	qt.Assert(t, app.EndBlock(abcitypes.RequestEndBlock{Height: 2}).ValidatorUpdates, qt.HasLen, 0)
+
+	// remove a validator; unknown validators and the last one cannot be removed
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:  models.TxType_REMOVE_VALIDATOR,
+		Address: ed25519.GenPrivKey().PubKey().Address(),
+		Nonce:   2,
+	}), qt.IsNotNil)
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:  models.TxType_REMOVE_VALIDATOR,
+		Address: genesis.Address(),
+		Nonce:   2,
+	}), qt.IsNil)
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:  models.TxType_REMOVE_VALIDATOR,
+		Address: newValidator.Address(),
+		Nonce:   3,
+	}), qt.IsNotNil)
+	resp = app.EndBlock(abcitypes.RequestEndBlock{Height: 2})
+	qt.Assert(t, resp.ValidatorUpdates, qt.HasLen, 1)
+	qt.Assert(t, resp.ValidatorUpdates[0].PubKey.GetEd25519(), qt.DeepEquals, genesis.Bytes())
+	qt.Assert(t, resp.ValidatorUpdates[0].Power, qt.Equals, int64(0))
+	app.Commit()
+	validators, err = app.State.Validators(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, validators, qt.HasLen, 1)
+	qt.Assert(t, validators[0].Address, qt.DeepEquals, []byte(newValidator.Address()))
+}
+
+func TestValidatorsTotalPower(t *testing.T) {
+	app := TestBaseApplication(t)
+	treasurer := ethereum.SignKeys{}
+	qt.Assert(t, treasurer.Generate(), qt.IsNil)
+	qt.Assert(t, app.State.SetTreasurer(treasurer.Address(), 0), qt.IsNil)
+	genesis := ed25519.GenPrivKey().PubKey()
+	qt.Assert(t, app.State.AddValidator(&models.Validator{
+		Address: genesis.Address(),
+		PubKey:  genesis.Bytes(),
+		Power:   10,
+	}), qt.IsNil)
+	validator1 := ed25519.GenPrivKey().PubKey()
+	validator2 := ed25519.GenPrivKey().PubKey()
+	power := func(p uint64) *uint64 { return &p }
+	max := uint64(tmtypes.MaxTotalVotingPower)
+
+	// the total power can reach the maximum
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:    models.TxType_ADD_VALIDATOR,
+		PublicKey: validator1.Bytes(),
+		Power:     power(max - 10),
+		Nonce:     0,
+	}), qt.IsNil)
+	// the updates queued on the same block are counted
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:    models.TxType_ADD_VALIDATOR,
+		PublicKey: validator2.Bytes(),
+		Power:     power(1),
+		Nonce:     1,
+	}), qt.ErrorMatches, ".*exceeds the maximum.*")
+	app.AdvanceTestBlock()
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:    models.TxType_ADD_VALIDATOR,
+		PublicKey: genesis.Bytes(),
+		Power:     power(11),
+		Nonce:     1,
+	}), qt.ErrorMatches, ".*exceeds the maximum.*")
+
+	// reweighting a validator does not count its previous power
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:    models.TxType_ADD_VALIDATOR,
+		PublicKey: validator1.Bytes(),
+		Power:     power(max - 11),
+		Nonce:     1,
+	}), qt.IsNil)
+	qt.Assert(t, testValidatorTx(t, &treasurer, app, &models.AdminTx{
+		Txtype:    models.TxType_ADD_VALIDATOR,
+		PublicKey: validator2.Bytes(),
+		Power:     power(1),
+		Nonce:     2,
+	}), qt.IsNil)
+}
+
+func TestValidatorsOracleQuorum(t *testing.T) {
+	app := TestBaseApplication(t)
+	keys := make([]ethereum.SignKeys, 5)
+	for i := range keys {
+		qt.Assert(t, keys[i].Generate(), qt.IsNil)
+	}
+	treasurer, oracles, other := &keys[0], keys[1:4], &keys[4]
+	qt.Assert(t, app.State.SetTreasurer(treasurer.Address(), 0), qt.IsNil)
+	for i := range oracles {
+		qt.Assert(t, app.State.AddOracle(oracles[i].Address()), qt.IsNil)
+	}
+	genesis := ed25519.GenPrivKey().PubKey()
+	qt.Assert(t, app.State.AddValidator(&models.Validator{
+		Address: genesis.Address(),
+		PubKey:  genesis.Bytes(),
+		Power:   10,
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	newValidator := ed25519.GenPrivKey().PubKey()
+	power := uint64(5)
+	addValidator := &models.AdminTx{
+		Txtype:    models.TxType_ADD_VALIDATOR,
+		PublicKey: newValidator.Bytes(),
+		Power:     &power,
+		Nonce:     0,
+	}
+	updates := func() []abcitypes.ValidatorUpdate {
+		return app.EndBlock(abcitypes.RequestEndBlock{Height: int64(app.Height())}).ValidatorUpdates
+	}
+
+	// the quorum of three oracles is three, the approvals are kept across
+	// blocks and other signers cannot approve
+	qt.Assert(t, testValidatorTx(t, other, app, addValidator), qt.IsNotNil)
+	qt.Assert(t, testValidatorTx(t, &oracles[0], app, addValidator), qt.IsNil)
+	qt.Assert(t, testValidatorTx(t, &oracles[0], app, addValidator), qt.ErrorMatches, ".*already approved.*")
+	qt.Assert(t, updates(), qt.HasLen, 0)
+	app.AdvanceTestBlock()
+	qt.Assert(t, testValidatorTx(t, &oracles[1], app, addValidator), qt.IsNil)
+	qt.Assert(t, updates(), qt.HasLen, 0)
+	qt.Assert(t, testValidatorTx(t, &oracles[2], app, addValidator), qt.IsNil)
+	resp := updates()
+	qt.Assert(t, resp, qt.HasLen, 1)
+	qt.Assert(t, resp[0].PubKey.GetEd25519(), qt.DeepEquals, newValidator.Bytes())
+	app.AdvanceTestBlock()
+	treasurerAcc, err := app.State.Treasurer(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, treasurerAcc.Nonce, qt.Equals, uint32(1))
+
+	// the approvals of a removed oracle do not count
+	removeValidator := &models.AdminTx{
+		Txtype:  models.TxType_REMOVE_VALIDATOR,
+		Address: newValidator.Address(),
+		Nonce:   1,
+	}
+	qt.Assert(t, testValidatorTx(t, &oracles[0], app, removeValidator), qt.IsNil)
+	qt.Assert(t, app.State.RemoveOracle(oracles[0].Address()), qt.IsNil)
+	qt.Assert(t, testValidatorTx(t, &oracles[1], app, removeValidator), qt.IsNil)
+	qt.Assert(t, updates(), qt.HasLen, 0)
+	// the treasurer executes the transaction at once
+	qt.Assert(t, testValidatorTx(t, treasurer, app, removeValidator), qt.IsNil)
+	resp = updates()
+	qt.Assert(t, resp, qt.HasLen, 1)
+	qt.Assert(t, resp[0].Power, qt.Equals, int64(0))
+}
+
+func testValidatorTx(t *testing.T, treasurer *ethereum.SignKeys,
+	app *BaseApplication, tx *models.AdminTx) error {
+	var stx models.SignedTx
+	var err error
+	if stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_Admin{Admin: tx}}); err != nil {
+		t.Fatal(err)
+	}
+	if stx.Signature, err = treasurer.SignVocdoniTx(stx.Tx, app.chainID); err != nil {
+		t.Fatal(err)
+	}
+	txBytes, err := proto.Marshal(&stx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := app.CheckTx(abcitypes.RequestCheckTx{Tx: txBytes}); resp.Code != 0 {
+		return fmt.Errorf("checkTx failed: %s", resp.Data)
+	}
+	if resp := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: txBytes}); resp.Code != 0 {
+		return fmt.Errorf("deliverTx failed: %s", resp.Data)
+	}
+	return nil
+}
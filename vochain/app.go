@@ -1,11 +1,14 @@
 package vochain
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strconv"
 	"sync"
@@ -20,7 +23,9 @@ import (
 	tmprototypes "github.com/tendermint/tendermint/proto/tendermint/types"
 
 	// ctypes "github.com/tendermint/tendermint/rpc/coretypes" // TENDERMINT 0.35
+	rpccore "github.com/tendermint/tendermint/rpc/core"
 	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	rpctypes "github.com/tendermint/tendermint/rpc/jsonrpc/types"
 
 	tmtypes "github.com/tendermint/tendermint/types"
 	snarkTypes "github.com/vocdoni/go-snark/types"
@@ -54,6 +59,7 @@ type BaseApplication struct {
 	fnGetTx            func(height uint32, txIndex int32) (*models.SignedTx, error)
 	fnGetTxHash        func(height uint32, txIndex int32) (*models.SignedTx, []byte, error)
 	fnMempoolSize      func() int
+	fnGetSignedHeader  func(height int64) (*tmtypes.SignedHeader, *tmtypes.ValidatorSet, error)
 
 	blockCache *lru.AtomicCache
 	// height of the last ended block
@@ -67,6 +73,28 @@ type BaseApplication struct {
 	dataDir             string
 	// ZkVKs contains the VerificationKey for each circuit parameters index
 	ZkVKs []*snarkTypes.Vk
+
+	// snapshotInterval is the number of blocks between state snapshots
+	snapshotInterval uint32
+	// snapshots caches the state sync description of the snapshots in disk,
+	// keyed by file path
+	snapshots     map[string]cachedSnapshot
+	snapshotsLock sync.Mutex
+	// restore holds the snapshot being restored via state sync, if any
+	restore *snapshotRestore
+	// mempoolAccounts tracks the account nonces used by the mempool transactions
+	mempoolAccounts *mempoolAccounts
+	// zkVerifier verifies the zk-SNARK proofs of the anonymous votes
+	zkVerifier *zkVerifier
+}
+
+// snapshotRestore keeps track of a snapshot offered by Tendermint state sync
+// while its chunks are being received.
+type snapshotRestore struct {
+	snapshot  *abcitypes.Snapshot
+	appHash   []byte
+	file      *os.File
+	nextChunk uint32
 }
 
 // Ensure that BaseApplication implements abcitypes.Application.
@@ -82,9 +110,13 @@ func NewBaseApplication(dbType, dbpath string) (*BaseApplication, error) {
 	}
 
 	return &BaseApplication{
-		State:      state,
-		blockCache: lru.NewAtomic(32),
-		dataDir:    dbpath,
+		State:            state,
+		blockCache:       lru.NewAtomic(32),
+		dataDir:          dbpath,
+		snapshotInterval: defaultSnapshotInterval,
+		snapshots:        make(map[string]cachedSnapshot),
+		mempoolAccounts:  newMempoolAccounts(),
+		zkVerifier:       newZkVerifier(),
 	}, nil
 }
 
@@ -105,14 +137,7 @@ func TestBaseApplication(tb testing.TB) *BaseApplication {
 // verifying their cryptographic hahes.
 func (app *BaseApplication) LoadZkVKs(ctx context.Context) error {
 	app.ZkVKs = []*snarkTypes.Vk{}
-	var circuits []zkartifacts.CircuitConfig
-	if genesis, ok := Genesis[app.chainID]; ok {
-		circuits = genesis.CircuitsConfig
-	} else {
-		log.Info("using dev genesis zkSnarks circuits")
-		circuits = Genesis["dev"].CircuitsConfig
-	}
-	for i, cc := range circuits {
+	for i, cc := range app.genesisCircuits() {
 		log.Infof("downloading zk-circuits-artifacts index: %d", i)
 
 		// download VKs from CircuitsConfig
@@ -177,6 +202,17 @@ func (app *BaseApplication) SetDefaultMethods() {
 	app.IsSynchronizing = app.isSynchronizingTendermint
 	app.SetFnGetTx(app.getTxTendermint)
 	app.SetFnGetTxHash(app.getTxHashTendermint)
+	app.SetFnGetSignedHeader(func(height int64) (*tmtypes.SignedHeader, *tmtypes.ValidatorSet, error) {
+		commit, err := app.Node.Commit(context.Background(), &height)
+		if err != nil {
+			return nil, nil, err
+		}
+		vals, err := app.Node.Validators(context.Background(), &height, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &commit.SignedHeader, tmtypes.NewValidatorSet(vals.Validators), nil
+	})
 	app.SetFnMempoolSize(func() int {
 		// TODO: find the way to return correctly the mempool size
 		return 0
@@ -265,6 +301,7 @@ func (app *BaseApplication) SetDefaultMethods() {
 	app.IsSynchronizing = app.isSynchronizingTendermint
 	app.SetFnGetTx(app.getTxTendermint)
 	app.SetFnGetTxHash(app.getTxHashTendermint)
+	app.SetFnGetSignedHeader(app.getSignedHeaderTendermint)
 	app.SetFnMempoolSize(app.Node.Mempool().Size)
 	app.SetFnSendTx(func(tx []byte) (*ctypes.ResultBroadcastTx, error) {
 		resCh := make(chan *abcitypes.Response, 1)
@@ -420,6 +457,42 @@ func (app *BaseApplication) getTxHashTendermint(height uint32,
 	return tx, block.Txs[txIndex].Hash(), proto.Unmarshal(block.Txs[txIndex], tx)
 }
 
+// GetSignedHeader returns the header of the block at height, with the commit
+// signed by the validators, and the validator set of that block.
+func (app *BaseApplication) GetSignedHeader(height int64) (*tmtypes.SignedHeader, *tmtypes.ValidatorSet, error) {
+	if app.fnGetSignedHeader == nil {
+		return nil, nil, fmt.Errorf("block headers are not available")
+	}
+	return app.fnGetSignedHeader(height)
+}
+
+// ONLY TENDERMINT 0.34
+// getSignedHeaderTendermint uses the node RPC environment, which is always
+// configured since the RPC server is enabled.
+func (app *BaseApplication) getSignedHeaderTendermint(height int64) (*tmtypes.SignedHeader,
+	*tmtypes.ValidatorSet, error) {
+	commit, err := rpccore.Commit(&rpctypes.Context{}, &height)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot get commit of block %d: %w", height, err)
+	}
+	if commit == nil {
+		return nil, nil, fmt.Errorf("block %d not found", height)
+	}
+	var validators []*tmtypes.Validator
+	perPage := 100
+	for page := 1; ; page++ {
+		res, err := rpccore.Validators(&rpctypes.Context{}, &height, &page, &perPage)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot get validators of block %d: %w", height, err)
+		}
+		validators = append(validators, res.Validators...)
+		if len(validators) >= res.Total || res.Count == 0 {
+			break
+		}
+	}
+	return &commit.SignedHeader, tmtypes.NewValidatorSet(validators), nil
+}
+
 // SendTx sends a transaction to the mempool (sync)
 func (app *BaseApplication) SendTx(tx []byte) (*ctypes.ResultBroadcastTx, error) {
 	if app.fnSendTx == nil {
@@ -545,6 +618,7 @@ func (app *BaseApplication) BeginBlock(
 	height := uint32(req.Header.GetHeight())
 	app.State.SetHeight(height)
 	go app.State.CachePurge(height)
+	app.prefetchZkProofs(req.Header.GetHeight())
 
 	return abcitypes.ResponseBeginBlock{}
 }
@@ -572,16 +646,24 @@ func (*BaseApplication) SetOption(req abcitypes.RequestSetOption) abcitypes.Resp
 	return abcitypes.ResponseSetOption{}
 }
 
-// CheckTx unmarshals req.Tx and checks its validity
+// CheckTx unmarshals req.Tx and checks its validity.  On Recheck, the
+// transactions that are no longer valid are evicted from the mempool.
 func (app *BaseApplication) CheckTx(req abcitypes.RequestCheckTx) abcitypes.ResponseCheckTx {
 	var response *AddTxResponse
 	var err error
+	tx := new(VochainTx)
 	if req.Type == abcitypes.CheckTxType_Recheck {
+		if err = tx.Unmarshal(req.Tx, app.ChainID()); err == nil {
+			err = app.recheckTx(tx)
+		}
+		if err != nil {
+			log.Debugf("recheckTx error: %v", err)
+			return abcitypes.ResponseCheckTx{Code: 1, Data: []byte("recheckTx " + err.Error())}
+		}
 		return abcitypes.ResponseCheckTx{Code: 0}
 	}
-	tx := new(VochainTx)
 	if err = tx.Unmarshal(req.Tx, app.ChainID()); err == nil {
-		if response, err = app.AddTx(tx, false); err != nil {
+		if response, err = app.checkTx(tx); err != nil {
 			if errors.Is(err, ErrorAlreadyExistInCache) {
 				return abcitypes.ResponseCheckTx{Code: 0}
 			}
@@ -608,6 +690,7 @@ func (app *BaseApplication) DeliverTx(req abcitypes.RequestDeliverTx) abcitypes.
 	tx := new(VochainTx)
 	if err = tx.Unmarshal(req.Tx, app.ChainID()); err == nil {
 		log.Debugf("deliver tx: %s", log.FormatProto(tx.Tx))
+		app.mempoolAccounts.remove(tx.TxID)
 		if response, err = app.AddTx(tx, true); err != nil {
 			log.Debugf("rejected tx: %v", err)
 			return abcitypes.ResponseDeliverTx{Code: 1, Data: []byte(err.Error())}
@@ -619,10 +702,11 @@ func (app *BaseApplication) DeliverTx(req abcitypes.RequestDeliverTx) abcitypes.
 		return abcitypes.ResponseDeliverTx{Code: 1, Data: []byte(err.Error())}
 	}
 	return abcitypes.ResponseDeliverTx{
-		Code: 0,
-		Data: response.Data,
-		Info: fmt.Sprintf("%x", response.TxHash),
-		Log:  response.Log,
+		Code:   0,
+		Data:   response.Data,
+		Info:   fmt.Sprintf("%x", response.TxHash),
+		Log:    response.Log,
+		Events: response.Events,
 	}
 }
 
@@ -632,55 +716,236 @@ func (app *BaseApplication) Commit() abcitypes.ResponseCommit {
 	if err != nil {
 		log.Fatalf("cannot save state: %v", err)
 	}
-	if app.Height()%50000 == 0 && !app.IsSynchronizing() {
+	app.mempoolAccounts.prune(app.Height(), pendingTxMaxAge)
+	if app.snapshotInterval > 0 && app.Height()%app.snapshotInterval == 0 && !app.IsSynchronizing() {
 		startTime := time.Now()
 		log.Infof("performing a state snapshot on block %d", app.Height())
 		if _, err := app.State.snapshot(); err != nil {
 			log.Fatalf("cannot make state snapshot: %v", err)
 		}
 		log.Infof("snapshot created successfully, took %s", time.Since(startTime))
-		log.Debugf("%+v", app.State.listSnapshots())
+		if err := app.State.removeOldSnapshots(snapshotsToKeep); err != nil {
+			log.Warnf("cannot remove old snapshots: %v", err)
+		}
+		list := app.State.listSnapshots()
+		app.evictSnapshots(list)
+		log.Debugf("%+v", list)
 	}
 	return abcitypes.ResponseCommit{
 		Data: data,
 	}
 }
 
-// Query does nothing
-func (app *BaseApplication) Query(req abcitypes.RequestQuery) abcitypes.ResponseQuery {
-	return abcitypes.ResponseQuery{}
-}
-
 // EndBlock updates the app height and timestamp at the end of the current block
+// and returns the validator set changes made by the block transactions
 func (app *BaseApplication) EndBlock(req abcitypes.RequestEndBlock) abcitypes.ResponseEndBlock {
-	app.endBlock(req.Height, time.Now())
-	return abcitypes.ResponseEndBlock{}
+	return app.endBlock(req.Height, time.Now())
 }
 
 func (app *BaseApplication) endBlock(height int64, timestamp time.Time) abcitypes.ResponseEndBlock {
 	atomic.StoreUint32(&app.height, uint32(height))
 	atomic.StoreInt64(&app.endBlockTimestamp, timestamp.Unix())
-	return abcitypes.ResponseEndBlock{}
+	// the time-based processes are opened and closed by the block header time
+	if err := app.State.UpdateTimeBasedProcesses(uint32(height),
+		time.Unix(app.TimestampStartBlock(), 0)); err != nil {
+		log.Fatalf("cannot update time-based processes: %v", err)
+	}
+	var updates []abcitypes.ValidatorUpdate
+	for _, v := range app.State.ValidatorUpdates() {
+		log.Infof("updating validator %x with power %d", v.Address, v.Power)
+		updates = append(updates, abcitypes.Ed25519ValidatorUpdate(v.PubKey, int64(v.Power)))
+	}
+	return abcitypes.ResponseEndBlock{ValidatorUpdates: updates}
 }
 
-func (app *BaseApplication) ApplySnapshotChunk(
-	req abcitypes.RequestApplySnapshotChunk) abcitypes.ResponseApplySnapshotChunk {
-	return abcitypes.ResponseApplySnapshotChunk{}
+// cachedSnapshot is the state sync description of a snapshot file, valid
+// while the file modification time and size do not change.
+type cachedSnapshot struct {
+	modTime  time.Time
+	size     int64
+	snapshot abcitypes.Snapshot
 }
 
+// ListSnapshots returns the state snapshots available in disk, so they can be
+// served to other nodes via state sync.
 func (app *BaseApplication) ListSnapshots(
 	req abcitypes.RequestListSnapshots) abcitypes.ResponseListSnapshots {
-	return abcitypes.ResponseListSnapshots{}
+	var snapshots []*abcitypes.Snapshot
+	list := app.State.listSnapshots()
+	for _, info := range list {
+		snapshot, err := app.snapshotDescription(info)
+		if err != nil {
+			log.Warnf("cannot describe snapshot %d: %v", info.Height, err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	app.evictSnapshots(list)
+	return abcitypes.ResponseListSnapshots{Snapshots: snapshots}
+}
+
+// snapshotDescription returns the state sync description of the snapshot stored
+// for height.  The metadata contains the concatenated sha256 hashes of all
+// chunks and the snapshot hash is the sha256 of the metadata, so each chunk
+// can be verified independently once the snapshot is accepted.
+func (app *BaseApplication) snapshotDescription(info diskSnapshotInfo) (*abcitypes.Snapshot, error) {
+	app.snapshotsLock.Lock()
+	defer app.snapshotsLock.Unlock()
+	path := app.State.snapshotPath(info.Height)
+	if cached, ok := app.snapshots[path]; ok &&
+		cached.modTime.Equal(info.ModTime) && cached.size == info.Size {
+		return &cached.snapshot, nil
+	}
+	hashes, err := app.State.snapshotChunkHashes(info.Height)
+	if err != nil {
+		return nil, err
+	}
+	metadata := bytes.Join(hashes, nil)
+	hash := sha256.Sum256(metadata)
+	snapshot := abcitypes.Snapshot{
+		Height:   uint64(info.Height),
+		Format:   snapshotHeaderVersion,
+		Chunks:   uint32(len(hashes)),
+		Hash:     hash[:],
+		Metadata: metadata,
+	}
+	app.snapshots[path] = cachedSnapshot{modTime: info.ModTime, size: info.Size, snapshot: snapshot}
+	return &snapshot, nil
+}
+
+// evictSnapshots removes from the cache the descriptions of the snapshots
+// that are not in list anymore.
+func (app *BaseApplication) evictSnapshots(list []diskSnapshotInfo) {
+	app.snapshotsLock.Lock()
+	defer app.snapshotsLock.Unlock()
+	kept := make(map[string]bool, len(list))
+	for _, info := range list {
+		kept[app.State.snapshotPath(info.Height)] = true
+	}
+	for path := range app.snapshots {
+		if !kept[path] {
+			delete(app.snapshots, path)
+		}
+	}
 }
 
+// LoadSnapshotChunk returns a chunk of a state snapshot stored in disk.
 func (app *BaseApplication) LoadSnapshotChunk(
 	req abcitypes.RequestLoadSnapshotChunk) abcitypes.ResponseLoadSnapshotChunk {
-	return abcitypes.ResponseLoadSnapshotChunk{}
+	if req.Format != snapshotHeaderVersion {
+		return abcitypes.ResponseLoadSnapshotChunk{}
+	}
+	chunk, err := app.State.snapshotChunk(uint32(req.Height), req.Chunk)
+	if err != nil {
+		log.Warnf("cannot load snapshot %d chunk %d: %v", req.Height, req.Chunk, err)
+		return abcitypes.ResponseLoadSnapshotChunk{}
+	}
+	return abcitypes.ResponseLoadSnapshotChunk{Chunk: chunk}
 }
 
+// OfferSnapshot is called by state sync when a snapshot is found among the
+// peers.  The snapshot is accepted if its format and metadata are valid, and
+// then its chunks are received with ApplySnapshotChunk.
 func (app *BaseApplication) OfferSnapshot(
 	req abcitypes.RequestOfferSnapshot) abcitypes.ResponseOfferSnapshot {
-	return abcitypes.ResponseOfferSnapshot{}
+	reject := func(result abcitypes.ResponseOfferSnapshot_Result) abcitypes.ResponseOfferSnapshot {
+		return abcitypes.ResponseOfferSnapshot{Result: result}
+	}
+	snapshot := req.Snapshot
+	if snapshot == nil {
+		return reject(abcitypes.ResponseOfferSnapshot_REJECT)
+	}
+	if snapshot.Format != snapshotHeaderVersion {
+		return reject(abcitypes.ResponseOfferSnapshot_REJECT_FORMAT)
+	}
+	hash := sha256.Sum256(snapshot.Metadata)
+	if snapshot.Chunks == 0 ||
+		len(snapshot.Metadata) != int(snapshot.Chunks)*sha256.Size ||
+		!bytes.Equal(hash[:], snapshot.Hash) {
+		log.Warnf("rejecting snapshot %d: invalid metadata", snapshot.Height)
+		return reject(abcitypes.ResponseOfferSnapshot_REJECT)
+	}
+	app.discardRestore()
+	file, err := os.Create(filepath.Join(app.State.snapshotsDir(), snapshotRestoreFile))
+	if err != nil {
+		log.Errorf("cannot create snapshot restore file: %v", err)
+		return reject(abcitypes.ResponseOfferSnapshot_ABORT)
+	}
+	log.Infof("accepted snapshot offer for height %d with %d chunks", snapshot.Height, snapshot.Chunks)
+	app.restore = &snapshotRestore{
+		snapshot: snapshot,
+		appHash:  req.AppHash,
+		file:     file,
+	}
+	return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_ACCEPT}
+}
+
+// ApplySnapshotChunk verifies and stores a chunk of the snapshot accepted by
+// OfferSnapshot.  Once the last chunk is received the snapshot is installed in
+// the State.
+func (app *BaseApplication) ApplySnapshotChunk(
+	req abcitypes.RequestApplySnapshotChunk) abcitypes.ResponseApplySnapshotChunk {
+	result := func(r abcitypes.ResponseApplySnapshotChunk_Result) abcitypes.ResponseApplySnapshotChunk {
+		return abcitypes.ResponseApplySnapshotChunk{Result: r}
+	}
+	r := app.restore
+	if r == nil {
+		return result(abcitypes.ResponseApplySnapshotChunk_ABORT)
+	}
+	if req.Index != r.nextChunk {
+		log.Warnf("unexpected snapshot chunk %d, expected %d", req.Index, r.nextChunk)
+		return result(abcitypes.ResponseApplySnapshotChunk_RETRY_SNAPSHOT)
+	}
+	hash := sha256.Sum256(req.Chunk)
+	if !bytes.Equal(hash[:], r.snapshot.Metadata[req.Index*sha256.Size:(req.Index+1)*sha256.Size]) {
+		log.Warnf("snapshot chunk %d hash mismatch, sent by %s", req.Index, req.Sender)
+		return abcitypes.ResponseApplySnapshotChunk{
+			Result:        abcitypes.ResponseApplySnapshotChunk_RETRY,
+			RefetchChunks: []uint32{req.Index},
+			RejectSenders: []string{req.Sender},
+		}
+	}
+	if _, err := r.file.Write(req.Chunk); err != nil {
+		log.Errorf("cannot write snapshot chunk: %v", err)
+		app.discardRestore()
+		return result(abcitypes.ResponseApplySnapshotChunk_ABORT)
+	}
+	r.nextChunk++
+	if r.nextChunk < r.snapshot.Chunks {
+		return result(abcitypes.ResponseApplySnapshotChunk_ACCEPT)
+	}
+
+	// all chunks received, install the snapshot
+	startTime := time.Now()
+	height := uint32(r.snapshot.Height)
+	err := app.State.installSnapshot(r.file.Name(), height, r.appHash)
+	app.discardRestore()
+	if err != nil {
+		log.Warnf("cannot install snapshot %d: %v", height, err)
+		return result(abcitypes.ResponseApplySnapshotChunk_REJECT_SNAPSHOT)
+	}
+	atomic.StoreUint32(&app.height, height)
+	log.Infof("snapshot %d restored, took %s", height, time.Since(startTime))
+	return result(abcitypes.ResponseApplySnapshotChunk_ACCEPT)
+}
+
+// discardRestore closes and removes the restore file of the snapshot being
+// restored, if any.
+func (app *BaseApplication) discardRestore() {
+	if app.restore == nil {
+		return
+	}
+	app.restore.file.Close()
+	if err := os.Remove(app.restore.file.Name()); err != nil {
+		log.Warnf("cannot remove snapshot restore file: %v", err)
+	}
+	app.restore = nil
+}
+
+// SetSnapshotInterval sets the number of blocks between state snapshots.
+// If interval is 0, no snapshots are taken.
+func (app *BaseApplication) SetSnapshotInterval(interval uint32) {
+	app.snapshotInterval = interval
 }
 
 // SetFnGetBlockByHash sets the getter for blocks by hash
@@ -708,6 +973,12 @@ func (app *BaseApplication) SetFnGetTxHash(fn func(height uint32, txIndex int32)
 	app.fnGetTxHash = fn
 }
 
+// SetFnGetSignedHeader sets the getter for the signed block headers
+func (app *BaseApplication) SetFnGetSignedHeader(fn func(height int64) (*tmtypes.SignedHeader,
+	*tmtypes.ValidatorSet, error)) {
+	app.fnGetSignedHeader = fn
+}
+
 // SetFnMempoolSize sets the mempool size method method
 func (app *BaseApplication) SetFnMempoolSize(fn func() int) {
 	app.fnMempoolSize = fn
@@ -13,6 +13,7 @@ import (
 	"go.vocdoni.io/dvote/config"
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
@@ -226,6 +227,27 @@ func GetFriendlyResults(results []*models.QuestionResult) [][]string {
 	return r
 }
 
+// OracleResults is the message signed by an oracle on the results of a process
+type OracleResults struct {
+	ChainID       string            `json:"chainId"`
+	EntityID      types.HexBytes    `json:"entityId"`
+	OracleAddress ethcommon.Address `json:"oracleAddress"`
+	ProcessID     types.HexBytes    `json:"processId"`
+	Results       [][]string        `json:"results"`
+}
+
+// OracleResultsPayload returns the OracleResults message an oracle signs for
+// the results of a process.  The oracle address is not part of the payload,
+// since it is recovered from the signature.
+func OracleResultsPayload(chainID string, result *models.ProcessResult) ([]byte, error) {
+	return json.Marshal(OracleResults{
+		ChainID:   chainID,
+		EntityID:  result.EntityId,
+		ProcessID: result.ProcessId,
+		Results:   GetFriendlyResults(result.GetVotes()),
+	})
+}
+
 func printPrettierDelegates(delegates [][]byte) []string {
 	prettierDelegates := make([]string, len(delegates))
 	for _, delegate := range delegates {
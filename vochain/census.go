@@ -290,3 +290,43 @@ func (v *State) GetPreRegisterAddrUsedWeight(pid []byte, addr common.Address) (*
 	}
 	return new(big.Int).SetBytes(weightBytes), nil
 }
+
+// AccountCensusProof returns the census proof of the account of addr for a
+// process whose census is backed by the Vochain accounts.  The proof is
+// generated on the Accounts tree committed just before the process start, so it
+// fails if that state version has been pruned.
+func (v *State) AccountCensusProof(pid []byte, addr common.Address) (*models.Proof, error) {
+	process, err := v.Process(pid, true)
+	if err != nil {
+		return nil, err
+	}
+	if !IsVochainAccountsCensus(process.CensusOrigin) {
+		return nil, fmt.Errorf("process %x census is not backed by the vochain accounts", pid)
+	}
+	if len(process.CensusRoot) == 0 {
+		return nil, fmt.Errorf("process %x accounts census not yet available", pid)
+	}
+	mainTreeView, err := v.mainTreeViewAt(process.StartBlock - 1)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := mainTreeView.SubTree(StateTreeCfg(TreeAccounts))
+	if err != nil {
+		return nil, err
+	}
+	root, err := accounts.Root()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(root, process.CensusRoot) {
+		return nil, fmt.Errorf("accounts root %x does not match the process census root", root)
+	}
+	value, siblings, err := accounts.GenProof(addr.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate account %s proof: %w", addr.Hex(), err)
+	}
+	return &models.Proof{Payload: &models.Proof_Arbo{Arbo: &models.ProofArbo{
+		Siblings: siblings,
+		Value:    value,
+	}}}, nil
+}
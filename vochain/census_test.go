@@ -0,0 +1,105 @@
+package vochain
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/util"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestVochainAccountsCensus(t *testing.T) {
+	app := TestBaseApplication(t)
+	keys := util.CreateEthRandomKeysBatch(3)
+	withBalance, withoutBalance, late := keys[0], keys[1], keys[2]
+	qt.Assert(t, app.State.CreateAccount(withBalance.Address(), "", nil, 50), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(withoutBalance.Address(), "", nil, 0), qt.IsNil)
+
+	newProcess := func(origin models.CensusOrigin) []byte {
+		pid := util.RandomBytes(32)
+		qt.Assert(t, app.State.AddProcess(&models.Process{
+			ProcessId:    pid,
+			EntityId:     util.RandomBytes(20),
+			StartBlock:   app.Height() + 3,
+			BlockCount:   100,
+			EnvelopeType: &models.EnvelopeType{},
+			Status:       models.ProcessStatus_READY,
+			VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+			Mode:         &models.ProcessMode{AutoStart: true},
+			CensusOrigin: origin,
+		}), qt.IsNil)
+		return pid
+	}
+	pid := newProcess(CensusOriginVochainAccounts)
+	weightedPid := newProcess(CensusOriginVochainAccountsWeighted)
+
+	// the census is not available until the process starts
+	app.AdvanceTestBlock()
+	_, err := app.State.AccountCensusProof(pid, withBalance.Address())
+	qt.Assert(t, err, qt.ErrorMatches, ".*not yet available")
+	app.AdvanceTestBlock()
+	process, err := app.State.Process(pid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, process.CensusRoot, qt.Not(qt.HasLen), 0)
+
+	// accounts created after the snapshot are not part of the census
+	qt.Assert(t, app.State.CreateAccount(late.Address(), "", nil, 10), qt.IsNil)
+	app.AdvanceTestBlock()
+	_, err = app.State.AccountCensusProof(pid, late.Address())
+	qt.Assert(t, err, qt.IsNotNil)
+	// SetProcessCensus cannot replace the snapshot
+	process.Mode.DynamicCensus = true
+	qt.Assert(t, app.State.updateProcess(process, pid), qt.IsNil)
+	qt.Assert(t, app.State.SetProcessCensus(pid, util.RandomBytes(32), "", false),
+		qt.ErrorMatches, ".*invalid census origin.*")
+
+	vote := func(pid []byte, voter *ethereum.SignKeys, proof *models.Proof) error {
+		stx := &models.SignedTx{}
+		stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: &models.VoteEnvelope{
+			Nonce:       util.RandomBytes(32),
+			ProcessId:   pid,
+			Proof:       proof,
+			VotePackage: []byte(`{"votes":[1]}`),
+		}}})
+		qt.Assert(t, err, qt.IsNil)
+		return sendTx(app, voter, stx)
+	}
+	proof := func(pid []byte, voter *ethereum.SignKeys) *models.Proof {
+		proof, err := app.State.AccountCensusProof(pid, voter.Address())
+		qt.Assert(t, err, qt.IsNil)
+		return proof
+	}
+	weight := func(pid []byte, voter *ethereum.SignKeys) string {
+		process, err := app.State.Process(pid, false)
+		qt.Assert(t, err, qt.IsNil)
+		valid, weight, err := VerifyProof(process, proof(pid, voter), process.CensusOrigin,
+			process.CensusRoot, pid, voter.PublicKey(), voter.Address())
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, valid, qt.IsTrue)
+		return weight.String()
+	}
+
+	// the late account can't use a proof of the current accounts tree
+	lateProof := proof(pid, withBalance)
+	lateProof.GetArbo().Value, err = proto.Marshal(&models.Account{Balance: 10})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, vote(pid, late, lateProof), qt.ErrorMatches, ".*proof not valid")
+	// the proof of an account is not valid for another one
+	qt.Assert(t, vote(pid, withoutBalance, proof(pid, withBalance)), qt.ErrorMatches, ".*proof not valid")
+
+	// every account has a weight of 1
+	qt.Assert(t, vote(pid, withBalance, proof(pid, withBalance)), qt.IsNil)
+	qt.Assert(t, vote(pid, withoutBalance, proof(pid, withoutBalance)), qt.IsNil)
+	qt.Assert(t, weight(pid, withBalance), qt.Equals, "1")
+	qt.Assert(t, weight(pid, withoutBalance), qt.Equals, "1")
+
+	// the weight is the balance at the process start
+	qt.Assert(t, app.State.TransferBalance(withBalance.Address(),
+		withoutBalance.Address(), 20), qt.IsNil)
+	qt.Assert(t, vote(weightedPid, withBalance, proof(weightedPid, withBalance)), qt.IsNil)
+	qt.Assert(t, weight(weightedPid, withBalance), qt.Equals, "50")
+	qt.Assert(t, vote(weightedPid, withoutBalance, proof(weightedPid, withoutBalance)),
+		qt.ErrorMatches, ".*has no balance.*")
+}
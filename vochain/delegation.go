@@ -0,0 +1,404 @@
+package vochain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// In delegated voting processes (liquid democracy), an account listed in the
+// DelegateAddrs of other accounts can cast a single envelope that counts with
+// its own census weight plus the census weight of its delegators.  Delegations
+// can be chained: the delegators of a delegator are carried too.  Each
+// delegator signs the process and the delegate that carries it, so a delegate
+// cannot cast the vote of its delegators without their consent.
+//
+// The census weight of a delegator is held by a single vote.  A delegator
+// that votes by itself always holds its own share, otherwise the last vote
+// that carries it holds it.  The holders are recorded by the consensus when
+// the votes are delivered, and the scrutinizer subtracts from each vote the
+// shares it does not hold when the results are computed.
+//
+// The delegated voting flag and the delegations of an envelope are not part of
+// the models yet, so they are kept as unknown fields of the ProcessMode and
+// VoteEnvelope messages.
+
+const (
+	// processModeDelegatedVotingField is the protobuf field number of the
+	// delegated voting flag in the ProcessMode message.
+	processModeDelegatedVotingField = 6
+	// voteEnvelopeDelegationsField is the protobuf field number of the
+	// delegations in the VoteEnvelope message.
+	voteEnvelopeDelegationsField = 7
+	// voteDelegationPublicKeyField and voteDelegationProofField are the
+	// protobuf field numbers of the VoteDelegation message.
+	voteDelegationPublicKeyField = 1
+	voteDelegationProofField     = 2
+	voteDelegationSignatureField = 3
+
+	// pathVoteDelegationHolders is the db path prefix of the holders of the
+	// census weight of the voters of delegated voting processes.
+	pathVoteDelegationHolders = "voteDelegationHolders/"
+
+	// MaxVoteDelegations is the maximum number of delegations an envelope can
+	// carry.
+	MaxVoteDelegations = 128
+)
+
+// VoteDelegation is the census proof of an account whose vote is cast by a
+// delegate, and its signature of VoteDelegationMessage.
+type VoteDelegation struct {
+	PublicKey []byte
+	Proof     *models.Proof
+	Signature []byte
+}
+
+// VoteDelegationMessage returns the message a delegator signs, as a vocdoni
+// message, to let the delegate cast its vote on the process.
+func VoteDelegationMessage(processID []byte, delegate common.Address) []byte {
+	return []byte(fmt.Sprintf("Vocdoni vote delegation:\n%x\n%x", processID, delegate.Bytes()))
+}
+
+// Verify checks the signature and the census proof of the delegator, and
+// returns its address and census weight.
+func (d *VoteDelegation) Verify(process *models.Process, delegate common.Address) (common.Address, *big.Int, error) {
+	addr, weight, err := d.CensusWeight(process)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	signer, err := ethereum.AddrFromSignature(ethereum.BuildVocdoniMessage(
+		VoteDelegationMessage(process.ProcessId, delegate)), d.Signature)
+	if err != nil {
+		return common.Address{}, nil, fmt.Errorf("delegator %s: cannot extract signer: %w", addr.Hex(), err)
+	}
+	if signer != addr {
+		return common.Address{}, nil, fmt.Errorf("delegator %s did not sign the delegation to %s",
+			addr.Hex(), delegate.Hex())
+	}
+	return addr, weight, nil
+}
+
+// CensusWeight checks the census proof of the delegator and returns its
+// address and census weight.
+func (d *VoteDelegation) CensusWeight(process *models.Process) (common.Address, *big.Int, error) {
+	if d.Proof == nil {
+		return common.Address{}, nil, fmt.Errorf("delegation without census proof")
+	}
+	addr, err := ethereum.AddrFromPublicKey(d.PublicKey)
+	if err != nil {
+		return common.Address{}, nil, fmt.Errorf("cannot extract delegator address: %w", err)
+	}
+	valid, weight, err := VerifyProof(process, d.Proof,
+		process.CensusOrigin, process.CensusRoot, process.ProcessId,
+		d.PublicKey, addr)
+	if err != nil {
+		return common.Address{}, nil, fmt.Errorf("delegator %s: %w", addr.Hex(), err)
+	}
+	if !valid {
+		return common.Address{}, nil, fmt.Errorf("delegator %s: proof not valid", addr.Hex())
+	}
+	return addr, weight, nil
+}
+
+// ProcessDelegatedVoting returns true if the process mode allows delegates to
+// vote on behalf of their delegators.
+func ProcessDelegatedVoting(mode *models.ProcessMode) bool {
+	if mode == nil {
+		return false
+	}
+	enabled := false
+	_ = walkUnknownFields(mode, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != processModeDelegatedVotingField || typ != protowire.VarintType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		v, n := protowire.ConsumeVarint(b)
+		enabled = v != 0
+		return n, nil
+	})
+	return enabled
+}
+
+// SetProcessDelegatedVoting sets the delegated voting flag of the process mode.
+func SetProcessDelegatedVoting(mode *models.ProcessMode, enabled bool) {
+	b := withoutUnknownField(mode, processModeDelegatedVotingField)
+	if enabled {
+		b = protowire.AppendTag(b, processModeDelegatedVotingField, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	mode.ProtoReflect().SetUnknown(b)
+}
+
+// VoteEnvelopeDelegations returns the delegations carried by the envelope.
+func VoteEnvelopeDelegations(ve *models.VoteEnvelope) ([]*VoteDelegation, error) {
+	delegations := []*VoteDelegation{}
+	err := walkUnknownFields(ve, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != voteEnvelopeDelegationsField || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+		if len(delegations) >= MaxVoteDelegations {
+			return 0, fmt.Errorf("too many delegations, the maximum is %d", MaxVoteDelegations)
+		}
+		d, err := unmarshalVoteDelegation(v)
+		if err != nil {
+			return 0, err
+		}
+		delegations = append(delegations, d)
+		return n, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return delegations, nil
+}
+
+// SetVoteEnvelopeDelegations sets the delegations carried by the envelope.
+func SetVoteEnvelopeDelegations(ve *models.VoteEnvelope, delegations []*VoteDelegation) error {
+	b := withoutUnknownField(ve, voteEnvelopeDelegationsField)
+	for _, d := range delegations {
+		proof, err := proto.Marshal(d.Proof)
+		if err != nil {
+			return err
+		}
+		var v []byte
+		v = protowire.AppendTag(v, voteDelegationPublicKeyField, protowire.BytesType)
+		v = protowire.AppendBytes(v, d.PublicKey)
+		v = protowire.AppendTag(v, voteDelegationProofField, protowire.BytesType)
+		v = protowire.AppendBytes(v, proof)
+		v = protowire.AppendTag(v, voteDelegationSignatureField, protowire.BytesType)
+		v = protowire.AppendBytes(v, d.Signature)
+		b = protowire.AppendTag(b, voteEnvelopeDelegationsField, protowire.BytesType)
+		b = protowire.AppendBytes(b, v)
+	}
+	ve.ProtoReflect().SetUnknown(b)
+	return nil
+}
+
+// unmarshalVoteDelegation decodes a VoteDelegation message.
+func unmarshalVoteDelegation(b []byte) (*VoteDelegation, error) {
+	d := &VoteDelegation{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("cannot decode delegation: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if typ != protowire.BytesType || (num != voteDelegationPublicKeyField &&
+			num != voteDelegationProofField && num != voteDelegationSignatureField) {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return nil, fmt.Errorf("cannot decode delegation: %w", protowire.ParseError(n))
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, fmt.Errorf("cannot decode delegation: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch num {
+		case voteDelegationPublicKeyField:
+			d.PublicKey = v
+			continue
+		case voteDelegationSignatureField:
+			d.Signature = v
+			continue
+		}
+		d.Proof = &models.Proof{}
+		if err := proto.Unmarshal(v, d.Proof); err != nil {
+			return nil, fmt.Errorf("cannot decode delegation proof: %w", err)
+		}
+	}
+	return d, nil
+}
+
+// walkUnknownFields calls fn for each unknown field of the message, with the
+// bytes after the field tag.  fn returns the length of the field value, or a
+// negative number if it can't be decoded.
+func walkUnknownFields(m proto.Message,
+	fn func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	b := m.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("cannot decode unknown fields: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return fmt.Errorf("cannot decode unknown field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// withoutUnknownField returns the unknown fields of the message, except the
+// ones with field number num.
+func withoutUnknownField(m proto.Message, num protowire.Number) []byte {
+	var out []byte
+	b := m.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		fnum, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			break
+		}
+		m := protowire.ConsumeFieldValue(fnum, typ, b[n:])
+		if m < 0 {
+			break
+		}
+		if fnum != num {
+			out = append(out, b[:n+m]...)
+		}
+		b = b[n+m:]
+	}
+	return out
+}
+
+// VoteDelegationsWeight checks the delegations carried by an envelope of the
+// delegate and returns their combined census weight.  Each delegator must sign
+// the delegation to the delegate, list the delegate, or another delegator of
+// the envelope, in its account DelegateAddrs, and must not have voted yet on
+// the process.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) VoteDelegationsWeight(process *models.Process, delegate common.Address,
+	delegations []*VoteDelegation, committed bool) (*big.Int, error) {
+	if len(delegations) > MaxVoteDelegations {
+		return nil, fmt.Errorf("too many delegations, the maximum is %d", MaxVoteDelegations)
+	}
+	weight := new(big.Int)
+	accounts := make(map[common.Address]*Account, len(delegations))
+	for _, d := range delegations {
+		addr, dweight, err := d.Verify(process, delegate)
+		if err != nil {
+			return nil, err
+		}
+		if addr == delegate {
+			return nil, fmt.Errorf("delegate %s cannot be its own delegator", addr.Hex())
+		}
+		if _, ok := accounts[addr]; ok {
+			return nil, fmt.Errorf("duplicated delegator %s", addr.Hex())
+		}
+		voted, err := v.EnvelopeExists(process.ProcessId, GenerateNullifier(addr, process.ProcessId), committed)
+		if err != nil {
+			return nil, err
+		}
+		if voted {
+			return nil, fmt.Errorf("delegator %s has already voted", addr.Hex())
+		}
+		acc, err := v.GetAccount(addr, committed)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get delegator %s account: %w", addr.Hex(), err)
+		}
+		if acc == nil {
+			return nil, fmt.Errorf("delegator %s: %w", addr.Hex(), ErrAccountNotExist)
+		}
+		accounts[addr] = acc
+		weight.Add(weight, dweight)
+	}
+	// every delegator must reach the delegate through a chain of delegations
+	reached := map[common.Address]bool{delegate: true}
+	for progress := true; progress; {
+		progress = false
+		for addr, acc := range accounts {
+			if reached[addr] {
+				continue
+			}
+			for _, d := range acc.DelegateAddrs {
+				if reached[common.BytesToAddress(d)] {
+					reached[addr] = true
+					progress = true
+					break
+				}
+			}
+		}
+	}
+	for addr := range accounts {
+		if !reached[addr] {
+			return nil, fmt.Errorf("delegator %s does not delegate to %s", addr.Hex(), delegate.Hex())
+		}
+	}
+	return weight, nil
+}
+
+// setVoteDelegationHolders records a delivered vote of a delegated voting
+// process as the holder of the census weight of its voter and of the
+// delegators it carries.  The last vote that carries a delegator holds its
+// share, unless the delegator voted by itself.
+func (app *BaseApplication) setVoteDelegationHolders(ve *models.VoteEnvelope, vote *models.Vote) error {
+	process, err := app.State.Process(vote.ProcessId, false)
+	if err != nil {
+		return err
+	}
+	if !ProcessDelegatedVoting(process.Mode) || process.EnvelopeType.Anonymous {
+		return nil
+	}
+	delegations, err := VoteEnvelopeDelegations(ve)
+	if err != nil {
+		return err
+	}
+	app.State.Tx.Lock()
+	defer app.State.Tx.Unlock()
+	noState := app.State.Tx.NoState()
+	if err := noState.Set(voteDelegationHolderKey(vote.ProcessId, vote.Nullifier), vote.Nullifier); err != nil {
+		return err
+	}
+	for _, d := range delegations {
+		addr, err := ethereum.AddrFromPublicKey(d.PublicKey)
+		if err != nil {
+			return fmt.Errorf("cannot extract delegator address: %w", err)
+		}
+		delegator := GenerateNullifier(addr, vote.ProcessId)
+		key := voteDelegationHolderKey(vote.ProcessId, delegator)
+		holder, err := noState.Get(key)
+		if err != nil && !errors.Is(err, db.ErrKeyNotFound) {
+			return err
+		}
+		if bytes.Equal(holder, delegator) {
+			continue
+		}
+		if err := noState.Set(key, vote.Nullifier); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VoteDelegationHolder returns the nullifier of the vote that holds the census
+// weight of the voter with nullifier on a delegated voting process, or nil if
+// no vote holds it.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) VoteDelegationHolder(processID, nullifier []byte, committed bool) ([]byte, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	holder, err := v.mainTreeViewer(committed).NoState().Get(voteDelegationHolderKey(processID, nullifier))
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return nil, nil
+	}
+	return holder, err
+}
+
+func voteDelegationHolderKey(processID, nullifier []byte) []byte {
+	key := append([]byte(pathVoteDelegationHolders), processID...)
+	return append(key, nullifier...)
+}
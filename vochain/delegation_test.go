@@ -0,0 +1,62 @@
+package vochain
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/util"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDelegationFields(t *testing.T) {
+	// the delegated voting flag survives the process encoding
+	mode := &models.ProcessMode{AutoStart: true}
+	qt.Assert(t, ProcessDelegatedVoting(mode), qt.IsFalse)
+	SetProcessDelegatedVoting(mode, true)
+	SetProcessDelegatedVoting(mode, true)
+	data, err := proto.Marshal(&models.Process{Mode: mode})
+	qt.Assert(t, err, qt.IsNil)
+	process := &models.Process{}
+	qt.Assert(t, proto.Unmarshal(data, process), qt.IsNil)
+	qt.Assert(t, process.Mode.AutoStart, qt.IsTrue)
+	qt.Assert(t, ProcessDelegatedVoting(process.Mode), qt.IsTrue)
+	SetProcessDelegatedVoting(process.Mode, false)
+	qt.Assert(t, ProcessDelegatedVoting(process.Mode), qt.IsFalse)
+	qt.Assert(t, process.Mode.ProtoReflect().GetUnknown(), qt.HasLen, 0)
+
+	// the delegations survive the envelope encoding
+	ve := &models.VoteEnvelope{ProcessId: util.RandomBytes(32)}
+	delegations := []*VoteDelegation{}
+	for i := 0; i < 3; i++ {
+		delegations = append(delegations, &VoteDelegation{
+			PublicKey: util.RandomBytes(33),
+			Proof: &models.Proof{Payload: &models.Proof_Arbo{Arbo: &models.ProofArbo{
+				Siblings: util.RandomBytes(64),
+				Value:    util.RandomBytes(4),
+			}}},
+			Signature: util.RandomBytes(65),
+		})
+	}
+	qt.Assert(t, SetVoteEnvelopeDelegations(ve, delegations), qt.IsNil)
+	data, err = proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: ve}})
+	qt.Assert(t, err, qt.IsNil)
+	tx := &models.Tx{}
+	qt.Assert(t, proto.Unmarshal(data, tx), qt.IsNil)
+	decoded, err := VoteEnvelopeDelegations(tx.GetVote())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, decoded, qt.HasLen, len(delegations))
+	for i := range delegations {
+		qt.Assert(t, decoded[i].PublicKey, qt.DeepEquals, delegations[i].PublicKey)
+		qt.Assert(t, decoded[i].Signature, qt.DeepEquals, delegations[i].Signature)
+		qt.Assert(t, proto.Equal(decoded[i].Proof, delegations[i].Proof), qt.IsTrue)
+	}
+
+	// too many delegations
+	for len(delegations) <= MaxVoteDelegations {
+		delegations = append(delegations, delegations[0])
+	}
+	qt.Assert(t, SetVoteEnvelopeDelegations(ve, delegations), qt.IsNil)
+	_, err = VoteEnvelopeDelegations(ve)
+	qt.Assert(t, err, qt.ErrorMatches, "too many delegations.*")
+}
@@ -0,0 +1,433 @@
+// Package eventstream turns the vochain.EventListener events into a durable
+// and ordered stream of events, which can be followed from any height.
+package eventstream
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/metadb"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+/*
+ KV database scheme:
+   e_{height}{index} = {Event} // the events of each block, by their index
+   h = {height} // last committed height
+   f = {height} // first height of the stream
+*/
+
+const (
+	dbPrefixEvent    = "e_"
+	dbKeyLastHeight  = "h"
+	dbKeyFirstHeight = "f"
+	heightKeyLength  = 4
+	eventIndexLength = 4
+
+	// DefaultRetention is the default number of blocks kept in the stream,
+	// about two weeks of blocks.
+	DefaultRetention = 100000
+	// pruneMaxHeights is the maximum number of blocks removed from the
+	// stream on each commit, so that lowering the retention doesn't stall
+	// a commit.
+	pruneMaxHeights = 100
+)
+
+// Event types
+const (
+	EventVote           = "vote"
+	EventTransaction    = "transaction"
+	EventProcess        = "process"
+	EventProcessStatus  = "processStatus"
+	EventProcessCancel  = "processCancel"
+	EventProcessKeys    = "processKeys"
+	EventRevealKeys     = "revealKeys"
+	EventProcessResults = "processResults"
+	EventProcessStart   = "processStart"
+)
+
+// Event is a vochain event included in a block.  Only the fields relevant to
+// each event type are set.
+type Event struct {
+	Height     uint32            `json:"height"`
+	Index      uint32            `json:"index"`
+	Type       string            `json:"type"`
+	TxIndex    int32             `json:"txIndex"`
+	TxHash     types.HexBytes    `json:"txHash,omitempty"`
+	ProcessID  types.HexBytes    `json:"processId,omitempty"`
+	EntityID   types.HexBytes    `json:"entityId,omitempty"`
+	Nullifier  types.HexBytes    `json:"nullifier,omitempty"`
+	Weight     *types.BigInt     `json:"weight,omitempty"`
+	Status     string            `json:"status,omitempty"`
+	CensusRoot string            `json:"censusRoot,omitempty"`
+	CensusURI  string            `json:"censusUri,omitempty"`
+	Key        string            `json:"key,omitempty"`
+	Results    [][]*types.BigInt `json:"results,omitempty"`
+}
+
+// Cursor is a position in the stream.  A stream started at a cursor returns
+// the events of the block Height starting at the event Index.
+type Cursor struct {
+	Height uint32
+	Index  uint32
+}
+
+// String encodes the cursor as height-index.
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d-%d", c.Height, c.Index)
+}
+
+// ParseCursor decodes a cursor encoded as height or height-index.
+func ParseCursor(s string) (Cursor, error) {
+	heightStr, indexStr, found := strings.Cut(s, "-")
+	height, err := strconv.ParseUint(heightStr, 10, 32)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor height: %w", err)
+	}
+	c := Cursor{Height: uint32(height)}
+	if found {
+		index, err := strconv.ParseUint(indexStr, 10, 32)
+		if err != nil {
+			return Cursor{}, fmt.Errorf("invalid cursor index: %w", err)
+		}
+		c.Index = uint32(index)
+	}
+	return c, nil
+}
+
+// Cursor returns the cursor of the event.
+func (e *Event) Cursor() Cursor {
+	return Cursor{Height: e.Height, Index: e.Index}
+}
+
+// Next returns the cursor following the event.
+func (e *Event) Next() Cursor {
+	return Cursor{Height: e.Height, Index: e.Index + 1}
+}
+
+// EventStream is a vochain event listener storing the events of each
+// committed block, so they can be streamed to the subscribers.  The stream
+// contains the blocks committed since the EventStream was first created, up
+// to the retention window: older blocks are removed as new ones are committed.
+type EventStream struct {
+	db db.Database
+	// retention is the number of blocks kept in the stream, 0 keeps all
+	retention uint32
+	// pending are the events of the block being processed
+	pending []*Event
+	// notify is closed and replaced on each commit
+	notify chan struct{}
+	lock   sync.RWMutex
+}
+
+// NewEventStream creates a new EventStream, storing the events on dataDir,
+// and registers it as a vochain event listener.
+func NewEventStream(dataDir string, v *vochain.BaseApplication) (*EventStream, error) {
+	if v == nil {
+		return nil, fmt.Errorf("missing vochain for creating an event stream")
+	}
+	database, err := metadb.New(db.TypePebble, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	es := &EventStream{
+		db:        database,
+		retention: DefaultRetention,
+		notify:    make(chan struct{}),
+	}
+	v.State.AddEventListener(es)
+	return es, nil
+}
+
+// Close closes the event stream database.
+func (es *EventStream) Close() error {
+	return es.db.Close()
+}
+
+// SetRetention sets the number of blocks kept in the stream.  If zero, the
+// blocks are never removed.
+func (es *EventStream) SetRetention(blocks uint32) {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	es.retention = blocks
+}
+
+// LastHeight returns the last committed height of the stream.  Returns 0 if
+// no block has been committed yet.
+func (es *EventStream) LastHeight() uint32 {
+	return es.getHeight(dbKeyLastHeight)
+}
+
+// FirstHeight returns the first height of the stream.
+func (es *EventStream) FirstHeight() uint32 {
+	return es.getHeight(dbKeyFirstHeight)
+}
+
+func (es *EventStream) getHeight(key string) uint32 {
+	tx := es.db.ReadTx()
+	defer tx.Discard()
+	data, err := tx.Get([]byte(key))
+	if err != nil {
+		if !errors.Is(err, db.ErrKeyNotFound) {
+			log.Warnf("cannot get event stream height: %v", err)
+		}
+		return 0
+	}
+	return binary.BigEndian.Uint32(data)
+}
+
+// Events returns the events of a committed block.
+func (es *EventStream) Events(height uint32) ([]*Event, error) {
+	events := []*Event{}
+	err := es.iterate(Cursor{Height: height}, func(e *Event) error {
+		events = append(events, e)
+		return nil
+	})
+	return events, err
+}
+
+// iterate calls fn for each event of the block at cursor.Height, starting on
+// cursor.Index.
+func (es *EventStream) iterate(cursor Cursor, fn func(*Event) error) error {
+	var fnErr error
+	if err := es.db.Iterate(heightKey(cursor.Height), func(key, value []byte) bool {
+		if binary.BigEndian.Uint32(key[len(key)-eventIndexLength:]) < cursor.Index {
+			return true
+		}
+		e := &Event{}
+		if fnErr = json.Unmarshal(value, e); fnErr != nil {
+			return false
+		}
+		fnErr = fn(e)
+		return fnErr == nil
+	}); err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// Stream calls fn for each committed event, in order, starting at cursor.
+// Once the committed events are exhausted, it waits for new blocks.  It
+// returns when the context is done or fn returns an error.  If the cursor is
+// older than the first height of the stream, the stream starts there.
+func (es *EventStream) Stream(ctx context.Context, cursor Cursor, fn func(*Event) error) error {
+	if first := es.FirstHeight(); cursor.Height < first {
+		cursor = Cursor{Height: first}
+	}
+	for {
+		// get the notify channel before reading, so that we don't miss
+		// a commit while reading the blocks
+		es.lock.RLock()
+		notify := es.notify
+		es.lock.RUnlock()
+
+		for last := es.LastHeight(); last > 0 && cursor.Height <= last; {
+			if err := es.iterate(cursor, func(e *Event) error {
+				if err := fn(e); err != nil {
+					return err
+				}
+				cursor = e.Next()
+				return nil
+			}); err != nil {
+				return err
+			}
+			cursor = Cursor{Height: cursor.Height + 1}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// add appends an event to the pending block.
+func (es *EventStream) add(e *Event) {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	es.pending = append(es.pending, e)
+}
+
+// Rollback discards the events of the block being processed.
+func (es *EventStream) Rollback() {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	es.pending = nil
+}
+
+// Commit stores the events of the block and notifies the subscribers.
+func (es *EventStream) Commit(height uint32) error {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	wTx := es.db.WriteTx()
+	defer wTx.Discard()
+	for i, e := range es.pending {
+		e.Height = height
+		e.Index = uint32(i)
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := wTx.Set(eventKey(height, uint32(i)), data); err != nil {
+			return err
+		}
+	}
+	h := make([]byte, heightKeyLength)
+	binary.BigEndian.PutUint32(h, height)
+	if _, err := wTx.Get([]byte(dbKeyFirstHeight)); errors.Is(err, db.ErrKeyNotFound) {
+		if err := wTx.Set([]byte(dbKeyFirstHeight), h); err != nil {
+			return err
+		}
+	} else if err := es.prune(wTx, height); err != nil {
+		return err
+	}
+	if err := wTx.Set([]byte(dbKeyLastHeight), h); err != nil {
+		return err
+	}
+	if err := wTx.Commit(); err != nil {
+		return err
+	}
+	es.pending = nil
+	close(es.notify)
+	es.notify = make(chan struct{})
+	return nil
+}
+
+// prune removes the blocks older than the retention window, up to
+// pruneMaxHeights blocks, and moves the first height of the stream.
+func (es *EventStream) prune(wTx db.WriteTx, height uint32) error {
+	if es.retention == 0 || height < es.retention {
+		return nil
+	}
+	first := es.FirstHeight()
+	newFirst := height - es.retention + 1
+	if newFirst <= first {
+		return nil
+	}
+	if newFirst-first > pruneMaxHeights {
+		newFirst = first + pruneMaxHeights
+	}
+	for h := first; h < newFirst; h++ {
+		keys := [][]byte{}
+		if err := es.db.Iterate(heightKey(h), func(key, _ []byte) bool {
+			keys = append(keys, append(heightKey(h), key...))
+			return true
+		}); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := wTx.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	f := make([]byte, heightKeyLength)
+	binary.BigEndian.PutUint32(f, newFirst)
+	return wTx.Set([]byte(dbKeyFirstHeight), f)
+}
+
+// OnVote adds a vote event.
+func (es *EventStream) OnVote(v *models.Vote, voterID types.VoterID, txIndex int32) {
+	e := &Event{
+		Type:      EventVote,
+		TxIndex:   txIndex,
+		ProcessID: v.ProcessId,
+		Nullifier: v.Nullifier,
+	}
+	if v.Weight != nil {
+		e.Weight = (*types.BigInt)(new(big.Int).SetBytes(v.Weight))
+	}
+	es.add(e)
+}
+
+// OnNewTx adds a transaction event.
+func (es *EventStream) OnNewTx(hash []byte, blockHeight uint32, txIndex int32) {
+	es.add(&Event{Type: EventTransaction, TxIndex: txIndex, TxHash: hash})
+}
+
+// OnProcess adds a new process event.
+func (es *EventStream) OnProcess(pid, eid []byte, censusRoot, censusURI string, txIndex int32) {
+	es.add(&Event{
+		Type:       EventProcess,
+		TxIndex:    txIndex,
+		ProcessID:  pid,
+		EntityID:   eid,
+		CensusRoot: censusRoot,
+		CensusURI:  censusURI,
+	})
+}
+
+// OnProcessStatusChange adds a process status event.
+func (es *EventStream) OnProcessStatusChange(pid []byte, status models.ProcessStatus, txIndex int32) {
+	es.add(&Event{
+		Type:      EventProcessStatus,
+		TxIndex:   txIndex,
+		ProcessID: pid,
+		Status:    status.String(),
+	})
+}
+
+// OnCancel adds a process cancel event.
+func (es *EventStream) OnCancel(pid []byte, txIndex int32) {
+	es.add(&Event{Type: EventProcessCancel, TxIndex: txIndex, ProcessID: pid})
+}
+
+// OnProcessKeys adds a process encryption key event.
+func (es *EventStream) OnProcessKeys(pid []byte, encryptionPub string, txIndex int32) {
+	es.add(&Event{Type: EventProcessKeys, TxIndex: txIndex, ProcessID: pid, Key: encryptionPub})
+}
+
+// OnRevealKeys adds a process key reveal event.
+func (es *EventStream) OnRevealKeys(pid []byte, encryptionPriv string, txIndex int32) {
+	es.add(&Event{Type: EventRevealKeys, TxIndex: txIndex, ProcessID: pid, Key: encryptionPriv})
+}
+
+// OnProcessResults adds a process results event.
+func (es *EventStream) OnProcessResults(pid []byte, results *models.ProcessResult,
+	txIndex int32) error {
+	e := &Event{Type: EventProcessResults, TxIndex: txIndex, ProcessID: pid}
+	for _, q := range results.GetVotes() {
+		question := []*types.BigInt{}
+		for _, v := range q.GetQuestion() {
+			question = append(question, (*types.BigInt)(new(big.Int).SetBytes(v)))
+		}
+		e.Results = append(e.Results, question)
+	}
+	es.add(e)
+	return nil
+}
+
+// OnProcessesStart adds a start event for each process.
+func (es *EventStream) OnProcessesStart(pids [][]byte) {
+	for _, pid := range pids {
+		es.add(&Event{Type: EventProcessStart, ProcessID: pid})
+	}
+}
+
+func heightKey(height uint32) []byte {
+	key := make([]byte, len(dbPrefixEvent)+heightKeyLength)
+	copy(key, dbPrefixEvent)
+	binary.BigEndian.PutUint32(key[len(dbPrefixEvent):], height)
+	return key
+}
+
+func eventKey(height, index uint32) []byte {
+	key := make([]byte, len(dbPrefixEvent)+heightKeyLength+eventIndexLength)
+	copy(key, heightKey(height))
+	binary.BigEndian.PutUint32(key[len(dbPrefixEvent)+heightKeyLength:], index)
+	return key
+}
@@ -0,0 +1,119 @@
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+func TestEventStream(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	es, err := NewEventStream(t.TempDir(), app)
+	qt.Assert(t, err, qt.IsNil)
+	t.Cleanup(func() { es.Close() })
+
+	pid := util.RandomBytes(32)
+	// block 5: a new process
+	es.OnProcess(pid, util.RandomBytes(20), "root", "uri", 0)
+	qt.Assert(t, es.Commit(5), qt.IsNil)
+	// block 6: discarded
+	es.OnCancel(pid, 0)
+	es.Rollback()
+	qt.Assert(t, es.Commit(6), qt.IsNil)
+	// block 7: two votes
+	es.OnVote(&models.Vote{ProcessId: pid, Nullifier: []byte{1}}, nil, 0)
+	es.OnVote(&models.Vote{ProcessId: pid, Nullifier: []byte{2}, Weight: []byte{3}}, nil, 1)
+	qt.Assert(t, es.Commit(7), qt.IsNil)
+
+	qt.Assert(t, es.FirstHeight(), qt.Equals, uint32(5))
+	qt.Assert(t, es.LastHeight(), qt.Equals, uint32(7))
+	events, err := es.Events(6)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, events, qt.HasLen, 0)
+	events, err = es.Events(7)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, events, qt.HasLen, 2)
+	qt.Assert(t, events[1].Weight.String(), qt.Equals, "3")
+
+	// stream from the start, then wait for a new block
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	received := make(chan *Event)
+	go func() {
+		_ = es.Stream(ctx, Cursor{}, func(e *Event) error {
+			received <- e
+			return nil
+		})
+	}()
+	for _, want := range []Cursor{{5, 0}, {7, 0}, {7, 1}} {
+		e := <-received
+		qt.Assert(t, e.Cursor(), qt.Equals, want)
+	}
+	es.OnProcessStatusChange(pid, models.ProcessStatus_ENDED, 0)
+	qt.Assert(t, es.Commit(8), qt.IsNil)
+	e := <-received
+	qt.Assert(t, e.Cursor(), qt.Equals, Cursor{8, 0})
+	qt.Assert(t, e.Type, qt.Equals, EventProcessStatus)
+	qt.Assert(t, e.Status, qt.Equals, "ENDED")
+	cancel()
+
+	// resume after the first vote
+	c, err := ParseCursor(Cursor{7, 0}.String())
+	qt.Assert(t, err, qt.IsNil)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	events = nil
+	err = es.Stream(ctx, Cursor{c.Height, c.Index + 1}, func(e *Event) error {
+		events = append(events, e)
+		if e.Height == 8 {
+			cancel()
+		}
+		return nil
+	})
+	qt.Assert(t, err, qt.Equals, context.Canceled)
+	qt.Assert(t, events, qt.HasLen, 2)
+	qt.Assert(t, []byte(events[0].Nullifier), qt.DeepEquals, []byte{2})
+}
+
+func TestEventStreamRetention(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	es, err := NewEventStream(t.TempDir(), app)
+	qt.Assert(t, err, qt.IsNil)
+	t.Cleanup(func() { es.Close() })
+	es.SetRetention(3)
+
+	pid := util.RandomBytes(32)
+	for height := uint32(1); height <= 5; height++ {
+		es.OnProcessesStart([][]byte{pid})
+		qt.Assert(t, es.Commit(height), qt.IsNil)
+	}
+	qt.Assert(t, es.FirstHeight(), qt.Equals, uint32(3))
+	qt.Assert(t, es.LastHeight(), qt.Equals, uint32(5))
+	for height := uint32(1); height <= 5; height++ {
+		want := 0
+		if height >= 3 {
+			want = 1
+		}
+		events, err := es.Events(height)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, events, qt.HasLen, want, qt.Commentf("height %d", height))
+	}
+
+	// lowering the retention removes up to pruneMaxHeights blocks per commit
+	es.SetRetention(0)
+	for height := uint32(6); height <= 2*pruneMaxHeights+10; height++ {
+		qt.Assert(t, es.Commit(height), qt.IsNil)
+	}
+	qt.Assert(t, es.FirstHeight(), qt.Equals, uint32(3))
+	es.SetRetention(1)
+	qt.Assert(t, es.Commit(2*pruneMaxHeights+11), qt.IsNil)
+	qt.Assert(t, es.FirstHeight(), qt.Equals, uint32(3+pruneMaxHeights))
+	events, err := es.Events(5)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, events, qt.HasLen, 0)
+}
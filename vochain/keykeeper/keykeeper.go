@@ -1,11 +1,15 @@
 package keykeeper
 
 import (
+	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strconv"
 	"sync"
 
+	"go.vocdoni.io/dvote/crypto"
+	"go.vocdoni.io/dvote/crypto/dkg"
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/crypto/nacl"
 	"go.vocdoni.io/dvote/db"
@@ -41,12 +45,22 @@ type KeyKeeper struct {
 	signer    *ethereum.SignKeys
 	lock      sync.Mutex
 	myIndex   int8
+	// threshold mode, see SetThreshold
+	threshold int
+	peers     map[int]crypto.PublicKey
+	shareKey  crypto.Cipher
+	// dealingPool holds the processes whose threshold key is not frozen
+	// yet, with the complaints and answers already sent
+	dealingPool map[string]map[string]bool
 }
 
 type processKeys struct {
 	pubKey  []byte
 	privKey []byte
 	index   int8
+	// dealing is published instead of pubKey on threshold mode, it is not
+	// stored since it can be re-created at any time
+	dealing []byte
 }
 
 // Encode encodes processKeys to bytes
@@ -91,8 +105,9 @@ func NewKeyKeeper(dbPath string, v *vochain.BaseApplication,
 		return nil, fmt.Errorf("index 0 cannot be used")
 	}
 	k := &KeyKeeper{
-		vochain: v,
-		signer:  signer,
+		vochain:     v,
+		signer:      signer,
+		dealingPool: make(map[string]map[string]bool),
 	}
 	var err error
 	k.storage, err = badgerdb.New(db.Options{Path: dbPath})
@@ -100,10 +115,54 @@ func NewKeyKeeper(dbPath string, v *vochain.BaseApplication,
 		return nil, err
 	}
 	k.myIndex = index
+	// Share key = hash(signer.privKey + "dkg"), used to receive the
+	// threshold key shares from the other key keepers.
+	if k.shareKey, err = nacl.DecodePrivate(fmt.Sprintf("%x",
+		ethereum.HashRaw(append(k.signer.Private.D.Bytes(), []byte("dkg")...)))); err != nil {
+		return nil, fmt.Errorf("cannot generate share key: (%s)", err)
+	}
 	k.vochain.State.AddEventListener(k)
 	return k, nil
 }
 
+// SharePublicKey returns the hex encoded public key this key keeper uses to
+// receive threshold key shares, to be configured as a peer on the others.
+func (k *KeyKeeper) SharePublicKey() string {
+	return fmt.Sprintf("%x", k.shareKey.Public().Bytes())
+}
+
+// SetThreshold enables the threshold (t-of-n) key mode.  Instead of its own
+// key, the key keeper publishes a dealing of a distributed key generation for
+// the given peers (key keeper index to share public key, including or not
+// itself), so that the process key is the joint key of all the dealings and
+// any threshold of the peers can recover it by revealing their shares.  All
+// the peers must use the same threshold and peer list.
+func (k *KeyKeeper) SetThreshold(threshold int, peers map[int8]string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	participants := map[int]crypto.PublicKey{int(k.myIndex): k.shareKey.Public()}
+	for index, key := range peers {
+		if index < 1 || index >= types.KeyKeeperMaxKeyIndex {
+			return fmt.Errorf("invalid peer index %d", index)
+		}
+		pub, err := nacl.DecodePublic(key)
+		if err != nil {
+			return fmt.Errorf("invalid share key for peer %d: (%s)", index, err)
+		}
+		if index == k.myIndex && !bytes.Equal(pub.Bytes(), k.shareKey.Public().Bytes()) {
+			return fmt.Errorf("share key for peer %d does not match ours", index)
+		}
+		participants[int(index)] = pub
+	}
+	if threshold < 1 || threshold > len(participants) {
+		return fmt.Errorf("invalid threshold %d for %d peers", threshold, len(participants))
+	}
+	k.threshold = threshold
+	k.peers = participants
+	log.Infof("keykeeper threshold mode enabled, %d of %d", threshold, len(participants))
+	return nil
+}
+
 // RevealUnpublished is a rescue function for revealing keys that should be already revealed.
 // It should be callend once the Vochain is syncronized in order to have the correct height.
 func (k *KeyKeeper) RevealUnpublished() {
@@ -209,13 +268,24 @@ func (k *KeyKeeper) OnProcess(pid, eid []byte, censusRoot, censusURI string, txi
 	}
 
 	// Generate keys
-	if k.keyPool[string(pid)], err = k.generateKeys(pid); err != nil {
+	if k.threshold > 0 {
+		k.keyPool[string(pid)], err = k.generateDealing(pid)
+		k.lock.Lock()
+		k.dealingPool[string(pid)] = make(map[string]bool)
+		k.lock.Unlock()
+	} else {
+		k.keyPool[string(pid)], err = k.generateKeys(pid)
+	}
+	if err != nil {
 		log.Errorf("cannot generate process keys: (%s)", err)
 		return
 	}
 
-	// Add keys to the pool queue
-	k.blockPool[string(pid)] = int64(p.StartBlock + p.BlockCount)
+	// Add keys to the pool queue.  The end block of a time-based process is
+	// an estimation, so its keys are revealed once the process is ended.
+	if !vochain.IsTimeBasedProcess(p) {
+		k.blockPool[string(pid)] = int64(p.StartBlock + p.BlockCount)
+	}
 }
 
 // OnCancel will publish the private and reveal keys of the canceled process, if required
@@ -229,7 +299,7 @@ func (k *KeyKeeper) OnCancel(pid []byte, txindex int32) { // LEGACY
 		return
 	}
 
-	if p.EncryptionPublicKeys[k.myIndex] != "" {
+	if k.hasProcessKeys(p) {
 		log.Infof("process canceled, scheduling reveal keys for next block")
 		k.blockPool[string(pid)] = int64(k.vochain.State.CurrentHeight()) + 1
 	}
@@ -240,6 +310,7 @@ func (k *KeyKeeper) Commit(height uint32) error {
 	k.scheduleRevealKeys()
 	go k.checkRevealProcess(height)
 	go k.publishPendingKeys()
+	go k.checkThresholdDealings(height)
 	return nil
 }
 
@@ -264,7 +335,7 @@ func (k *KeyKeeper) OnProcessStatusChange(pid []byte, status models.ProcessStatu
 	if !(p.EnvelopeType.Anonymous || p.EnvelopeType.EncryptedVotes) {
 		return
 	}
-	if p.EncryptionPublicKeys[k.myIndex] != "" {
+	if k.hasProcessKeys(p) {
 		if status == models.ProcessStatus_ENDED {
 			log.Infof("process ended, scheduling reveal keys for next block")
 			k.blockPool[string(pid)] = int64(k.vochain.State.CurrentHeight()) + 1
@@ -312,6 +383,74 @@ func (k *KeyKeeper) generateKeys(pid []byte) (*processKeys, error) {
 	return pk, nil
 }
 
+// polynomial returns the threshold key polynomial for a process.  The seed is
+// derived the same way as generateKeys' private key.
+func (k *KeyKeeper) polynomial(pid []byte) (*dkg.Polynomial, error) {
+	pb := append(append([]byte(nil), pid...), byte(k.myIndex))
+	return dkg.NewPolynomial(ethereum.HashRaw(append(k.signer.Private.D.Bytes(), pb...)),
+		k.threshold)
+}
+
+// generateDealing generates the threshold key dealing for a process.
+func (k *KeyKeeper) generateDealing(pid []byte) (*processKeys, error) {
+	poly, err := k.polynomial(pid)
+	if err != nil {
+		return nil, err
+	}
+	dealing, err := poly.Deal(k.peers)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate key dealing: (%s)", err)
+	}
+	return &processKeys{index: k.myIndex, dealing: dealing.Marshal()}, nil
+}
+
+// hasProcessKeys returns true if the key keeper has keys to reveal for the
+// process: its own key or dealing, or a share of the threshold key.
+func (k *KeyKeeper) hasProcessKeys(p *models.Process) bool {
+	return p.EncryptionPublicKeys[k.myIndex] != "" ||
+		(k.threshold > 0 && vochain.IsThresholdKeyProcess(p))
+}
+
+// thresholdShare decrypts the shares sent to this key keeper on all the
+// dealings of a process and returns its share of the joint key.  The shares
+// revealed by the dealers answering our complaints are used as they are.
+func (k *KeyKeeper) thresholdShare(process *models.Process) ([]byte, error) {
+	complaints, err := k.vochain.State.ProcessKeyComplaints(process.ProcessId)
+	if err != nil {
+		return nil, err
+	}
+	answers := make(map[int][]byte)
+	for _, c := range complaints {
+		if c.Complainer == uint32(k.myIndex) && c.Share != nil {
+			answers[int(c.Dealer)] = c.Share
+		}
+	}
+	shares := [][]byte{}
+	for i := 1; i < len(process.EncryptionPublicKeys); i++ {
+		if len(process.EncryptionPublicKeys[i]) == 0 {
+			continue
+		}
+		data, err := hex.DecodeString(process.EncryptionPublicKeys[i])
+		if err != nil {
+			return nil, err
+		}
+		dealing, err := dkg.UnmarshalDealing(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode key dealing %d: (%s)", i, err)
+		}
+		if share, ok := answers[i]; ok {
+			shares = append(shares, share)
+			continue
+		}
+		share, err := dealing.DecryptShare(int(k.myIndex), k.shareKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get share from key dealing %d: (%s)", i, err)
+		}
+		shares = append(shares, share)
+	}
+	return dkg.AggregateShares(shares...)
+}
+
 // scheduleRevealKeys takes the pids from the blockPool and add them to the schedule storage
 func (k *KeyKeeper) scheduleRevealKeys() {
 	k.lock.Lock()
@@ -388,7 +527,7 @@ func (k *KeyKeeper) checkRevealProcess(height uint32) {
 		if !(process.EnvelopeType.Anonymous || process.EnvelopeType.EncryptedVotes) {
 			return
 		}
-		if process.EncryptionPublicKeys[k.myIndex] != "" {
+		if k.hasProcessKeys(process) {
 			log.Infof("revealing keys for process %x on block %d", p, height)
 			if err := k.revealKeys(string(p)); err != nil {
 				log.Errorf("cannot reveal process keys for %x: (%s)", p, err)
@@ -404,6 +543,99 @@ func (k *KeyKeeper) checkRevealProcess(height uint32) {
 	}
 }
 
+// checkThresholdDealings complains against the threshold key dealings whose
+// share for us is not valid, and answers the complaints against our dealing,
+// until the threshold key of the process is frozen.  Only the key keepers
+// whose dealing is published can complain.
+func (k *KeyKeeper) checkThresholdDealings(height uint32) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	for pid, sent := range k.dealingPool {
+		process, err := k.vochain.State.Process([]byte(pid), false)
+		if err != nil {
+			log.Errorf("cannot get process from state: (%s)", err)
+			delete(k.dealingPool, pid)
+			continue
+		}
+		// the transactions sent now are included on the next block at least
+		if vochain.IsThresholdKeyProcess(process) || height+1 >= process.StartBlock {
+			delete(k.dealingPool, pid)
+			continue
+		}
+		if len(process.EncryptionPublicKeys[k.myIndex]) == 0 {
+			continue
+		}
+		complaints, err := k.vochain.State.ProcessKeyComplaints([]byte(pid))
+		if err != nil {
+			log.Errorf("cannot get process key complaints: (%s)", err)
+			continue
+		}
+		complained := make(map[uint32]bool)
+		for _, c := range complaints {
+			if c.Complainer == uint32(k.myIndex) {
+				complained[c.Dealer] = true
+			}
+		}
+		for i := 1; i < len(process.EncryptionPublicKeys); i++ {
+			if i == int(k.myIndex) || len(process.EncryptionPublicKeys[i]) == 0 ||
+				complained[uint32(i)] {
+				continue
+			}
+			data, err := hex.DecodeString(process.EncryptionPublicKeys[i])
+			if err != nil {
+				continue
+			}
+			dealing, err := dkg.UnmarshalDealing(data)
+			if err != nil {
+				continue
+			}
+			if _, ok := dealing.Shares[int(k.myIndex)]; !ok {
+				continue
+			}
+			if _, err := dealing.DecryptShare(int(k.myIndex), k.shareKey); err == nil {
+				continue
+			}
+			log.Warnf("complaining against the key dealing %d of process %x: (%s)", i, []byte(pid), err)
+			k.sendComplaintTx(sent, pid, vochain.TxTypeComplainProcessKeys, uint32(i), nil)
+		}
+		for _, c := range complaints {
+			if c.Dealer != uint32(k.myIndex) || c.Share != nil {
+				continue
+			}
+			poly, err := k.polynomial([]byte(pid))
+			if err != nil {
+				log.Errorf("cannot generate key polynomial: (%s)", err)
+				break
+			}
+			log.Infof("answering the complaint of key keeper %d on process %x", c.Complainer, []byte(pid))
+			k.sendComplaintTx(sent, pid, vochain.TxTypeAnswerProcessKeysComplaint,
+				c.Complainer, poly.Share(int(c.Complainer)))
+		}
+	}
+}
+
+// sendComplaintTx sends a complaint or an answer transaction on the process
+// threshold key, unless it was already sent.
+func (k *KeyKeeper) sendComplaintTx(sent map[string]bool, pid string,
+	txtype models.TxType, index uint32, share []byte) {
+	id := fmt.Sprintf("%d/%d", txtype, index)
+	if sent[id] {
+		return
+	}
+	tx := &models.AdminTx{
+		Txtype:               txtype,
+		KeyIndex:             &index,
+		Nonce:                uint32(util.RandomInt(0, 1000000000)),
+		ProcessId:            []byte(pid),
+		EncryptionPrivateKey: share,
+	}
+	if err := k.signAndSendTx(tx); err != nil {
+		log.Errorf("cannot send %s transaction for process %x: (%s)", txtype, []byte(pid), err)
+		return
+	}
+	sent[id] = true
+}
+
 // publishPendingKeys publishes each key in the keyPool
 func (k *KeyKeeper) publishPendingKeys() {
 	k.lock.Lock()
@@ -426,6 +658,9 @@ func (k *KeyKeeper) publishKeys(pk *processKeys, pid string) error {
 		ProcessId:           []byte(pid),
 		EncryptionPublicKey: pk.pubKey,
 	}
+	if pk.dealing != nil {
+		tx.EncryptionPublicKey = pk.dealing
+	}
 	if err := k.signAndSendTx(tx); err != nil {
 		return err
 	}
@@ -450,10 +685,24 @@ func (k *KeyKeeper) publishKeys(pk *processKeys, pid string) error {
 // Insecure
 // revealKeys reveals the keys for a given process
 func (k *KeyKeeper) revealKeys(pid string) error {
-	pk, err := k.generateKeys([]byte(pid))
+	process, err := k.vochain.State.Process([]byte(pid), false)
 	if err != nil {
 		return err
 	}
+	var pk *processKeys
+	if vochain.IsThresholdKeyProcess(process) {
+		// threshold key, reveal our share unless the key is already recovered
+		if len(process.EncryptionPrivateKeys[0]) > 0 {
+			log.Infof("threshold key for process %x already recovered", pid)
+			return nil
+		}
+		pk = &processKeys{index: k.myIndex}
+		if pk.privKey, err = k.thresholdShare(process); err != nil {
+			return err
+		}
+	} else if pk, err = k.generateKeys([]byte(pid)); err != nil {
+		return err
+	}
 	kindex := new(uint32)
 	*kindex = uint32(pk.index)
 	tx := &models.AdminTx{
@@ -479,13 +728,9 @@ func (k *KeyKeeper) revealKeys(pid string) error {
 }
 
 func (k *KeyKeeper) signAndSendTx(tx *models.AdminTx) error {
-	var err error
-	stx := &models.SignedTx{}
-	// sign the transaction
-	if stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_Admin{Admin: tx}}); err != nil {
-		return err
-	}
-	if stx.Signature, err = k.signer.SignVocdoniTx(stx.Tx, k.vochain.ChainID()); err != nil {
+	// sign the transaction, proposed to the oracle multisig if any
+	stx, err := k.vochain.SignOracleTx(k.signer, &models.Tx{Payload: &models.Tx_Admin{Admin: tx}})
+	if err != nil {
 		return err
 	}
 	vtxBytes, err := proto.Marshal(stx)
@@ -2,12 +2,21 @@ package keykeeper
 
 import (
 	"crypto/rand"
+	"sync"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
 
+	"go.vocdoni.io/dvote/crypto/dkg"
+	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/crypto/nacl"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	models "go.vocdoni.io/proto/build/go/models"
 )
 
 func TestEncodeDecode(t *testing.T) {
@@ -27,3 +36,171 @@ func TestEncodeDecode(t *testing.T) {
 
 	qt.Assert(t, pk, qt.CmpEquals(cmpopts.IgnoreUnexported(processKeys{})), pk2)
 }
+
+func TestThresholdKeys(t *testing.T) {
+	const n, threshold = 5, 3
+	app := vochain.TestBaseApplication(t)
+	var txs [][]byte
+	var txsLock sync.Mutex
+	app.SetFnSendTx(func(tx []byte) (*ctypes.ResultBroadcastTx, error) {
+		txsLock.Lock()
+		defer txsLock.Unlock()
+		txs = append(txs, tx)
+		return &ctypes.ResultBroadcastTx{}, nil
+	})
+	deliver := func() {
+		txsLock.Lock()
+		defer txsLock.Unlock()
+		for _, tx := range txs {
+			cr := app.CheckTx(abcitypes.RequestCheckTx{Tx: tx})
+			qt.Assert(t, cr.Code, qt.Equals, uint32(0), qt.Commentf("%s", cr.Data))
+			dr := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: tx})
+			qt.Assert(t, dr.Code, qt.Equals, uint32(0), qt.Commentf("%s", dr.Data))
+		}
+		txs = nil
+	}
+
+	// create the key keepers, all of them oracles
+	kks := make([]*KeyKeeper, n+1)
+	peers := make(map[int8]string)
+	for i := 1; i <= n; i++ {
+		signer := ethereum.NewSignKeys()
+		qt.Assert(t, signer.Generate(), qt.IsNil)
+		qt.Assert(t, app.State.AddOracle(signer.Address()), qt.IsNil)
+		qt.Assert(t, app.State.CreateAccount(signer.Address(), "", nil, 0), qt.IsNil)
+		kk, err := NewKeyKeeper(t.TempDir(), app, signer, int8(i))
+		qt.Assert(t, err, qt.IsNil)
+		kk.Rollback()
+		kks[i] = kk
+		peers[int8(i)] = kk.SharePublicKey()
+	}
+	for i := 1; i <= n; i++ {
+		qt.Assert(t, kks[i].SetThreshold(threshold, peers), qt.IsNil)
+	}
+
+	// each key keeper generates its dealing on the new process, which
+	// starts on the next block
+	pid := util.RandomBytes(types.ProcessIDsize)
+	err := app.State.AddProcess(&models.Process{
+		ProcessId:             pid,
+		EntityId:              util.RandomBytes(types.EntityIDsize),
+		StartBlock:            app.State.CurrentHeight() + 1,
+		BlockCount:            100,
+		Status:                models.ProcessStatus_READY,
+		EnvelopeType:          &models.EnvelopeType{EncryptedVotes: true},
+		Mode:                  &models.ProcessMode{Interruptible: true},
+		VoteOptions:           &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+		EncryptionPublicKeys:  make([]string, types.KeyKeeperMaxKeyIndex),
+		EncryptionPrivateKeys: make([]string, types.KeyKeeperMaxKeyIndex),
+	})
+	qt.Assert(t, err, qt.IsNil)
+
+	// the dealings of 2 and 4 have a wrong share for 3 and 1
+	corrupt := func(dealer, participant int) {
+		pk := kks[dealer].keyPool[string(pid)]
+		dealing, err := dkg.UnmarshalDealing(pk.dealing)
+		qt.Assert(t, err, qt.IsNil)
+		poly, err := dkg.NewPolynomial([]byte("wrong"), threshold)
+		qt.Assert(t, err, qt.IsNil)
+		dealing.Shares[participant], err = nacl.Anonymous.Encrypt(poly.Share(participant),
+			kks[participant].shareKey.Public())
+		qt.Assert(t, err, qt.IsNil)
+		pk.dealing = dealing.Marshal()
+	}
+	corrupt(2, 3)
+	corrupt(4, 1)
+
+	// the last key keeper drops out before dealing
+	for i := 1; i < n; i++ {
+		kks[i].publishPendingKeys()
+	}
+	deliver()
+	process, err := app.State.Process(pid, false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *process.KeyIndex, qt.Equals, uint32(n-1))
+	qt.Assert(t, process.EncryptionPublicKeys[0], qt.Equals, "")
+	qt.Assert(t, process.EncryptionPublicKeys[n], qt.Equals, "")
+
+	// the key keepers with a wrong share complain
+	for i := 1; i < n; i++ {
+		kks[i].checkThresholdDealings(app.Height())
+	}
+	qt.Assert(t, txs, qt.HasLen, 2)
+	deliver()
+	complaints, err := app.State.ProcessKeyComplaints(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, complaints, qt.HasLen, 2)
+
+	// a wrong answer is rejected
+	poly, err := kks[4].polynomial(pid)
+	qt.Assert(t, err, qt.IsNil)
+	kks[4].sendComplaintTx(map[string]bool{}, string(pid),
+		vochain.TxTypeAnswerProcessKeysComplaint, 1, poly.Share(2))
+	qt.Assert(t, txs, qt.HasLen, 1)
+	cr := app.CheckTx(abcitypes.RequestCheckTx{Tx: txs[0]})
+	qt.Assert(t, cr.Code, qt.Not(qt.Equals), uint32(0))
+	txs = nil
+
+	// 2 answers revealing the share of 3, while 4 does not answer
+	kks[2].checkThresholdDealings(app.Height())
+	qt.Assert(t, txs, qt.HasLen, 1)
+	deliver()
+	kks[2].checkThresholdDealings(app.Height())
+	qt.Assert(t, txs, qt.HasLen, 0)
+
+	// the key is frozen at the end of the block, 4 is disqualified
+	for i := 1; i <= n; i++ {
+		kks[i].Rollback()
+	}
+	app.AdvanceTestBlock()
+	process, err = app.State.Process(pid, false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *process.KeyIndex, qt.Equals, uint32(n-2))
+	qt.Assert(t, process.EncryptionPublicKeys[4], qt.Equals, "")
+	qt.Assert(t, process.EncryptionPublicKeys[0], qt.HasLen, nacl.KeyLength*2)
+
+	// a late dealing cannot change the frozen key
+	late, err := kks[n].generateDealing(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, kks[n].publishKeys(late, string(pid)), qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 1)
+	cr = app.CheckTx(abcitypes.RequestCheckTx{Tx: txs[0]})
+	qt.Assert(t, cr.Code, qt.Not(qt.Equals), uint32(0))
+	txs = nil
+
+	// encrypt a vote with the joint key
+	pub, err := nacl.DecodePublic(process.EncryptionPublicKeys[0])
+	qt.Assert(t, err, qt.IsNil)
+	vote, err := nacl.Anonymous.Encrypt([]byte("vote"), pub)
+	qt.Assert(t, err, qt.IsNil)
+
+	qt.Assert(t, app.State.SetProcessStatus(pid, models.ProcessStatus_ENDED, true), qt.IsNil)
+
+	// below the threshold the key is not recovered, the key keeper without
+	// a dealing reveals its share too
+	qt.Assert(t, kks[n].hasProcessKeys(process), qt.IsTrue)
+	for _, i := range []int{n, 3} {
+		qt.Assert(t, kks[i].revealKeys(string(pid)), qt.IsNil)
+	}
+	deliver()
+	process, err = app.State.Process(pid, false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *process.KeyIndex, qt.Equals, uint32(n-2))
+	qt.Assert(t, process.EncryptionPrivateKeys[0], qt.Equals, "")
+
+	// the third share recovers the key
+	qt.Assert(t, kks[1].revealKeys(string(pid)), qt.IsNil)
+	deliver()
+	process, err = app.State.Process(pid, false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *process.KeyIndex, qt.Equals, uint32(0))
+	priv, err := nacl.DecodePrivate(process.EncryptionPrivateKeys[0])
+	qt.Assert(t, err, qt.IsNil)
+	plain, err := priv.Decrypt(vote)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, string(plain), qt.Equals, "vote")
+
+	// a late key keeper does not need to reveal anymore
+	qt.Assert(t, kks[2].revealKeys(string(pid)), qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 0)
+}
@@ -0,0 +1,239 @@
+package vochain
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// pendingTxMaxAge is the number of blocks a pending transaction is kept
+	// without being checked again.  The mempool transactions are rechecked
+	// on every block, so the ones not rechecked were evicted by Tendermint.
+	pendingTxMaxAge = 5
+)
+
+// accountNonce returns a pointer to the account nonce of a transaction, its
+// type and the amount of tokens it transfers apart from its cost.  The nonce
+// is nil if the transaction does not use the nonce of the sender account.
+func accountNonce(tx *models.Tx) (*uint32, models.TxType, uint64) {
+	switch t := tx.Payload.(type) {
+	case *models.Tx_SendTokens:
+		return &t.SendTokens.Nonce, models.TxType_SEND_TOKENS, t.SendTokens.Value
+	case *models.Tx_SetAccountInfo:
+		return &t.SetAccountInfo.Nonce, models.TxType_SET_ACCOUNT_INFO, 0
+	case *models.Tx_NewProcess:
+		return &t.NewProcess.Nonce, models.TxType_NEW_PROCESS, 0
+	case *models.Tx_SetProcess:
+		return &t.SetProcess.Nonce, t.SetProcess.Txtype, 0
+	case *models.Tx_SetAccountDelegateTx:
+		return &t.SetAccountDelegateTx.Nonce, t.SetAccountDelegateTx.Txtype, 0
+	case *models.Tx_CollectFaucet:
+		// the cost is paid by the faucet account, not the sender
+		return nil, models.TxType_COLLECT_FAUCET, 0
+	case *models.Tx_MintTokens:
+		return nil, models.TxType_MINT_TOKENS, 0
+	case *models.Tx_SetTransactionCosts:
+		return nil, models.TxType_SET_TRANSACTION_COSTS, 0
+	}
+	return nil, models.TxType_TX_UNKNOWN, 0
+}
+
+// pendingTx is a transaction accepted in the mempool but not yet delivered
+type pendingTx struct {
+	key   [32]byte
+	nonce uint32
+	// spend is the balance spent by the transaction, including its cost
+	spend uint64
+	// height is the block height when the transaction was last checked
+	height uint32
+}
+
+// mempoolAccounts keeps the nonces and balance spent by the mempool
+// transactions of each account, so an account can have several transactions
+// with consecutive nonces waiting to be delivered.
+type mempoolAccounts struct {
+	lock    sync.Mutex
+	txs     map[common.Address]map[uint32]*pendingTx
+	senders map[[32]byte]common.Address
+}
+
+func newMempoolAccounts() *mempoolAccounts {
+	return &mempoolAccounts{
+		txs:     make(map[common.Address]map[uint32]*pendingTx),
+		senders: make(map[[32]byte]common.Address),
+	}
+}
+
+// next returns the next nonce of the account, after the consecutive nonces
+// pending from stateNonce, and the balance spent by those transactions.
+func (m *mempoolAccounts) next(addr common.Address, stateNonce uint32) (uint32, uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	nonce, spent := stateNonce, uint64(0)
+	for {
+		tx, ok := m.txs[addr][nonce]
+		if !ok {
+			return nonce, spent
+		}
+		spent += tx.spend
+		nonce++
+	}
+}
+
+// add adds a pending transaction of the account, and removes the ones with a
+// nonce lower than the account state nonce, which are already delivered.
+func (m *mempoolAccounts) add(addr common.Address, stateNonce uint32, tx *pendingTx) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.txs[addr] == nil {
+		m.txs[addr] = make(map[uint32]*pendingTx)
+	}
+	for nonce, old := range m.txs[addr] {
+		if nonce < stateNonce {
+			delete(m.txs[addr], nonce)
+			delete(m.senders, old.key)
+		}
+	}
+	m.txs[addr][tx.nonce] = tx
+	m.senders[tx.key] = addr
+}
+
+// remove removes a pending transaction, if any.
+func (m *mempoolAccounts) remove(key [32]byte) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	addr, ok := m.senders[key]
+	if !ok {
+		return
+	}
+	delete(m.senders, key)
+	for nonce, tx := range m.txs[addr] {
+		if tx.key == key {
+			delete(m.txs[addr], nonce)
+		}
+	}
+	if len(m.txs[addr]) == 0 {
+		delete(m.txs, addr)
+	}
+}
+
+// prune removes the pending transactions not checked since maxAge blocks
+// before height, which are no longer in the mempool.
+func (m *mempoolAccounts) prune(height, maxAge uint32) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for addr, txs := range m.txs {
+		for nonce, tx := range txs {
+			if tx.height+maxAge < height {
+				delete(txs, nonce)
+				delete(m.senders, tx.key)
+			}
+		}
+		if len(txs) == 0 {
+			delete(m.txs, addr)
+		}
+	}
+}
+
+// checkTx checks a new mempool transaction.  Transactions with an account
+// nonce ahead of the state nonce are accepted if all the nonces in between
+// are pending in the mempool, and the account balance covers all of them.
+func (app *BaseApplication) checkTx(vtx *VochainTx) (*AddTxResponse, error) {
+	nonce, txType, value := accountNonce(vtx.Tx)
+	if nonce == nil {
+		return app.AddTx(vtx, false)
+	}
+	sender, err := ethereum.AddrFromSignature(vtx.SignedBody, vtx.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("cannot extract address from signature: %w", err)
+	}
+	acc, err := app.State.GetAccount(sender, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get account %s: %w", sender, err)
+	}
+	// new accounts and already used nonces are handled by the regular checks
+	if acc == nil || *nonce < acc.Nonce {
+		return app.AddTx(vtx, false)
+	}
+	next, spent := app.mempoolAccounts.next(sender, acc.Nonce)
+	if *nonce < next {
+		return nil, fmt.Errorf("a transaction with nonce %d is already in the mempool", *nonce)
+	}
+	if *nonce > next {
+		return nil, fmt.Errorf("nonce gap, expected nonce %d got %d", next, *nonce)
+	}
+	checkTx := vtx
+	if *nonce > acc.Nonce {
+		// the transaction is checked as if the pending ones were already
+		// delivered; the signature is verified against the signed body,
+		// so only the decoded copy is modified
+		checkTx = &VochainTx{
+			Tx:         proto.Clone(vtx.Tx).(*models.Tx),
+			SignedBody: vtx.SignedBody,
+			Signature:  vtx.Signature,
+			TxID:       vtx.TxID,
+		}
+		checkNonce, _, _ := accountNonce(checkTx.Tx)
+		*checkNonce = acc.Nonce
+	}
+	response, err := app.AddTx(checkTx, false)
+	if err != nil {
+		return nil, err
+	}
+	cost, err := app.State.TxCost(txType, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get %s transaction cost: %w", txType, err)
+	}
+	if acc.Balance < spent+cost+value {
+		return nil, fmt.Errorf("%w, the mempool transactions of the account spend %d", ErrNotEnoughBalance, spent)
+	}
+	app.mempoolAccounts.add(sender, acc.Nonce, &pendingTx{
+		key:    vtx.TxID,
+		nonce:  *nonce,
+		spend:  cost + value,
+		height: app.Height(),
+	})
+	return response, nil
+}
+
+// recheckTx checks again a mempool transaction after a new block is committed,
+// so the transactions that are no longer valid are evicted.  The pending
+// account transaction is removed and only added back if it is still valid.
+func (app *BaseApplication) recheckTx(vtx *VochainTx) error {
+	app.mempoolAccounts.remove(vtx.TxID)
+	// the votes are fully checked (and cached) only once, since checking
+	// the census proof is expensive
+	if vtx.Tx.GetVote() != nil {
+		if vote := app.State.CacheGetCopy(vtx.TxID); vote != nil {
+			if err := app.recheckVote(vote); err != nil {
+				app.State.CacheDel(vtx.TxID)
+				return err
+			}
+			return nil
+		}
+	}
+	_, err := app.checkTx(vtx)
+	return err
+}
+
+// recheckVote checks that a vote already verified can still be delivered.
+func (app *BaseApplication) recheckVote(vote *models.Vote) error {
+	process, err := app.State.Process(vote.ProcessId, false)
+	if err != nil {
+		return fmt.Errorf("cannot fetch processId: %w", err)
+	}
+	if process.Status != models.ProcessStatus_READY {
+		return fmt.Errorf("process %x not in READY state - current state: %s",
+			vote.ProcessId, process.Status.String())
+	}
+	if height := app.State.CurrentHeight(); height > process.StartBlock+process.BlockCount {
+		return fmt.Errorf("process %x finished at height %d, current height is %d",
+			vote.ProcessId, process.StartBlock+process.BlockCount, height)
+	}
+	return app.State.CheckVoteOverwrite(process, vote.Nullifier, false)
+}
@@ -0,0 +1,99 @@
+package vochain
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMempoolAccountNonces(t *testing.T) {
+	app := TestBaseApplication(t)
+	signer := ethereum.SignKeys{}
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	to := ethereum.SignKeys{}
+	qt.Assert(t, to.Generate(), qt.IsNil)
+	app.State.SetAccount(BurnAddress, &Account{})
+	qt.Assert(t, app.State.SetTxCost(models.TxType_SEND_TOKENS, 10), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(signer.Address(), "", nil, 0), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(to.Address(), "", nil, 0), qt.IsNil)
+	qt.Assert(t, app.State.MintBalance(signer.Address(), 121), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	sendTokens := func(nonce uint32, value uint64) []byte {
+		return testSignedTx(t, app, &signer, &models.Tx{Payload: &models.Tx_SendTokens{
+			SendTokens: &models.SendTokensTx{
+				Txtype: models.TxType_SEND_TOKENS,
+				From:   signer.Address().Bytes(),
+				To:     to.Address().Bytes(),
+				Value:  value,
+				Nonce:  nonce,
+			}}})
+	}
+	checkTx := func(tx []byte, recheck bool) uint32 {
+		req := abcitypes.RequestCheckTx{Tx: tx}
+		if recheck {
+			req.Type = abcitypes.CheckTxType_Recheck
+		}
+		return app.CheckTx(req).Code
+	}
+
+	// consecutive nonces are accepted, each one spending 20 tokens
+	txs := [][]byte{sendTokens(0, 10), sendTokens(1, 10), sendTokens(2, 10)}
+	for _, tx := range txs {
+		qt.Assert(t, checkTx(tx, false), qt.Equals, uint32(0))
+	}
+	// nonce gaps and nonces already in the mempool are rejected
+	qt.Assert(t, checkTx(sendTokens(4, 10), false), qt.Equals, uint32(1))
+	qt.Assert(t, checkTx(sendTokens(1, 20), false), qt.Equals, uint32(1))
+	// the balance must cover all the mempool transactions of the account
+	qt.Assert(t, checkTx(sendTokens(3, 52), false), qt.Equals, uint32(1))
+	txs = append(txs, sendTokens(3, 40))
+	qt.Assert(t, checkTx(txs[3], false), qt.Equals, uint32(0))
+
+	// deliver the first two transactions on the next block
+	for _, tx := range txs[:2] {
+		qt.Assert(t, app.DeliverTx(abcitypes.RequestDeliverTx{Tx: tx}).Code, qt.Equals, uint32(0))
+	}
+	app.AdvanceTestBlock()
+
+	// on recheck, the delivered transactions are evicted and the rest kept
+	qt.Assert(t, checkTx(txs[0], true), qt.Equals, uint32(1))
+	qt.Assert(t, checkTx(txs[1], true), qt.Equals, uint32(1))
+	qt.Assert(t, checkTx(txs[2], true), qt.Equals, uint32(0))
+	qt.Assert(t, checkTx(txs[3], true), qt.Equals, uint32(0))
+	qt.Assert(t, checkTx(sendTokens(4, 1), false), qt.Equals, uint32(0))
+
+	// if a transaction is not valid anymore, the following ones are evicted
+	qt.Assert(t, app.State.SetTxCost(models.TxType_SEND_TOKENS, 50), qt.IsNil)
+	app.AdvanceTestBlock()
+	qt.Assert(t, checkTx(txs[2], true), qt.Equals, uint32(0))
+	qt.Assert(t, checkTx(txs[3], true), qt.Equals, uint32(1))
+	qt.Assert(t, checkTx(sendTokens(4, 1), true), qt.Equals, uint32(1))
+	// and the evicted transactions are no longer pending
+	next, _ := app.mempoolAccounts.next(signer.Address(), 2)
+	qt.Assert(t, next, qt.Equals, uint32(3))
+
+	// the transactions evicted without recheck are pruned after some blocks
+	for i := 0; i <= pendingTxMaxAge; i++ {
+		app.AdvanceTestBlock()
+	}
+	qt.Assert(t, app.mempoolAccounts.txs, qt.HasLen, 0)
+	qt.Assert(t, app.mempoolAccounts.senders, qt.HasLen, 0)
+}
+
+// testSignedTx returns the signed transaction bytes
+func testSignedTx(t *testing.T, app *BaseApplication, signer *ethereum.SignKeys, tx *models.Tx) []byte {
+	stx := &models.SignedTx{}
+	var err error
+	stx.Tx, err = proto.Marshal(tx)
+	qt.Assert(t, err, qt.IsNil)
+	stx.Signature, err = signer.SignVocdoniTx(stx.Tx, app.ChainID())
+	qt.Assert(t, err, qt.IsNil)
+	stxBytes, err := proto.Marshal(stx)
+	qt.Assert(t, err, qt.IsNil)
+	return stxBytes
+}
@@ -0,0 +1,895 @@
+package vochain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/vocdoni/arbo"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// The treasurer can be governed by an M-of-N multisig, so a single compromised
+// key cannot mint tokens or manage the oracles and validators.  When the
+// multisig is set, the treasurer transactions (mint tokens, set transaction
+// costs and the treasurer admin transactions) are proposed by a signer and
+// approved by the others, and once the threshold is reached any signer can
+// execute the proposed transaction.  The pending proposals are kept in the
+// state until the treasurer nonce moves past them.
+//
+// The treasurer can also set an oracle multisig governing the per-process
+// oracle transactions: adding and revealing the process keys, setting the
+// results and setting the status or census of a process on behalf of its
+// entity.  An oracle signer proposes its signed transaction, and the
+// consensus executes it once approved by the threshold, removing the
+// proposal.  The complaints on the threshold keys are not governed, so the
+// multisig cannot keep a dealer from being disqualified.
+//
+// The multisig transactions and messages are not part of the models yet, so
+// they are kept as unknown fields of the AdminTx message.
+
+const (
+	// TxTypeSetTreasurerMultisig sets the multisig carried by the AdminTx.
+	// A multisig without signers removes it, so the treasurer key signs the
+	// treasurer transactions again.
+	TxTypeSetTreasurerMultisig models.TxType = 25
+	// TxTypeProposeMultisigTx adds a pending proposal of the treasurer or
+	// oracle transaction carried by the AdminTx, approved by its signer.
+	TxTypeProposeMultisigTx models.TxType = 26
+	// TxTypeApproveMultisigTx approves the pending proposal identified by
+	// the proposal ID carried by the AdminTx.
+	TxTypeApproveMultisigTx models.TxType = 27
+	// TxTypeSetOracleMultisig sets the oracle multisig carried by the
+	// AdminTx.  A multisig without signers removes it.
+	TxTypeSetOracleMultisig models.TxType = 30
+
+	// adminTxMultisigField, adminTxProposalField, adminTxProposalIDField
+	// and adminTxProposalSignatureField are the protobuf field numbers of
+	// the multisig, the proposed transaction, the proposal ID and the
+	// signature of the proposed oracle transaction in the AdminTx message.
+	adminTxMultisigField          = 13
+	adminTxProposalField          = 14
+	adminTxProposalIDField        = 15
+	adminTxProposalSignatureField = 16
+	// multisigSignersField and multisigThresholdField are the protobuf field
+	// numbers of the Multisig message.
+	multisigSignersField   = 1
+	multisigThresholdField = 2
+	// multisigProposalTxField, multisigProposalApprovalsField and
+	// multisigProposalSignatureField are the protobuf field numbers of the
+	// MultisigProposal message.
+	multisigProposalTxField        = 1
+	multisigProposalApprovalsField = 2
+	multisigProposalSignatureField = 3
+
+	// MaxMultisigSigners is the maximum number of signers of a multisig.
+	MaxMultisigSigners = 32
+
+	// treasurerMultisigKey is the key of the treasurer multisig on the Extra
+	// subtree
+	treasurerMultisigKey = "treasurerMultisig"
+	// oracleMultisigKey is the key of the oracle multisig on the Extra
+	// subtree
+	oracleMultisigKey = "oracleMultisig"
+	// multisigProposalKeyPrefix is the prefix of the Extra tree keys of the
+	// pending proposals, followed by the proposal ID truncated to fit the
+	// tree key length.
+	multisigProposalKeyPrefix = "multisig/"
+	// multisigProposalKeyLen is the length of the Extra tree keys
+	multisigProposalKeyLen = 32
+)
+
+// Multisig is an M-of-N multisig: Threshold of the Signers must approve a
+// transaction.
+type Multisig struct {
+	Signers   []common.Address
+	Threshold uint32
+}
+
+// Check verifies the signers are unique and the threshold can be reached.
+// A multisig without signers and threshold is valid, meaning no multisig.
+func (m *Multisig) Check() error {
+	if len(m.Signers) == 0 && m.Threshold == 0 {
+		return nil
+	}
+	if len(m.Signers) > MaxMultisigSigners {
+		return fmt.Errorf("too many signers, the maximum is %d", MaxMultisigSigners)
+	}
+	if m.Threshold == 0 || int(m.Threshold) > len(m.Signers) {
+		return fmt.Errorf("invalid threshold %d for %d signers", m.Threshold, len(m.Signers))
+	}
+	seen := make(map[common.Address]bool, len(m.Signers))
+	for _, signer := range m.Signers {
+		if signer == (common.Address{}) {
+			return fmt.Errorf("invalid signer address: %s", signer)
+		}
+		if seen[signer] {
+			return fmt.Errorf("duplicated signer %s", signer)
+		}
+		seen[signer] = true
+	}
+	return nil
+}
+
+// IsSigner returns true if addr is one of the signers.
+func (m *Multisig) IsSigner(addr common.Address) bool {
+	for _, signer := range m.Signers {
+		if signer == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Approvals returns the number of approvals of the proposal made by the
+// current signers.
+func (m *Multisig) Approvals(p *MultisigProposal) uint32 {
+	var approvals uint32
+	for _, addr := range p.Approvals {
+		if m.IsSigner(addr) {
+			approvals++
+		}
+	}
+	return approvals
+}
+
+// Marshal encodes the multisig as a Multisig message.
+func (m *Multisig) Marshal() []byte {
+	var b []byte
+	for _, signer := range m.Signers {
+		b = protowire.AppendTag(b, multisigSignersField, protowire.BytesType)
+		b = protowire.AppendBytes(b, signer.Bytes())
+	}
+	b = protowire.AppendTag(b, multisigThresholdField, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.Threshold))
+	return b
+}
+
+// Unmarshal decodes a Multisig message.
+func (m *Multisig) Unmarshal(b []byte) error {
+	*m = Multisig{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("cannot decode multisig: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch {
+		case num == multisigSignersField && typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			if n >= 0 && len(v) != types.EthereumAddressSize {
+				return fmt.Errorf("invalid signer address: %x", v)
+			}
+			m.Signers = append(m.Signers, common.BytesToAddress(v))
+		case num == multisigThresholdField && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			m.Threshold = uint32(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("cannot decode multisig: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// MultisigProposal is a pending treasurer or oracle transaction and the
+// addresses of the signers that approved it.  The oracle transactions keep
+// the signature of the proposer, so the consensus can execute them.
+type MultisigProposal struct {
+	Tx        *models.Tx
+	Approvals []common.Address
+	Signature []byte
+}
+
+// Marshal encodes the proposal as a MultisigProposal message.
+func (p *MultisigProposal) Marshal() ([]byte, error) {
+	tx, err := proto.MarshalOptions{Deterministic: true}.Marshal(p.Tx)
+	if err != nil {
+		return nil, err
+	}
+	b := protowire.AppendTag(nil, multisigProposalTxField, protowire.BytesType)
+	b = protowire.AppendBytes(b, tx)
+	for _, addr := range p.Approvals {
+		b = protowire.AppendTag(b, multisigProposalApprovalsField, protowire.BytesType)
+		b = protowire.AppendBytes(b, addr.Bytes())
+	}
+	if p.Signature != nil {
+		b = protowire.AppendTag(b, multisigProposalSignatureField, protowire.BytesType)
+		b = protowire.AppendBytes(b, p.Signature)
+	}
+	return b, nil
+}
+
+// Unmarshal decodes a MultisigProposal message.
+func (p *MultisigProposal) Unmarshal(b []byte) error {
+	*p = MultisigProposal{Tx: &models.Tx{}}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("cannot decode proposal: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if typ != protowire.BytesType || (num != multisigProposalTxField &&
+			num != multisigProposalApprovalsField && num != multisigProposalSignatureField) {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return fmt.Errorf("cannot decode proposal: %w", protowire.ParseError(n))
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return fmt.Errorf("cannot decode proposal: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch num {
+		case multisigProposalApprovalsField:
+			p.Approvals = append(p.Approvals, common.BytesToAddress(v))
+		case multisigProposalSignatureField:
+			p.Signature = v
+		default:
+			if err := proto.Unmarshal(v, p.Tx); err != nil {
+				return fmt.Errorf("cannot decode proposed transaction: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// MultisigProposalID returns the ID of the proposal of a treasurer
+// transaction, which is the sha256 hash of its deterministic encoding.
+func MultisigProposalID(tx *models.Tx) ([]byte, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
+	id := sha256.Sum256(b)
+	return id[:], nil
+}
+
+// TreasurerTxNonce returns the treasurer nonce of a transaction signed by the
+// treasurer, or false if the transaction is not a treasurer one.
+func TreasurerTxNonce(tx *models.Tx) (uint32, bool) {
+	switch payload := tx.GetPayload().(type) {
+	case *models.Tx_MintTokens:
+		return payload.MintTokens.GetNonce(), true
+	case *models.Tx_SetTransactionCosts:
+		return payload.SetTransactionCosts.GetNonce(), true
+	case *models.Tx_Admin:
+		switch payload.Admin.GetTxtype() {
+		case models.TxType_ADD_ORACLE, models.TxType_REMOVE_ORACLE,
+			models.TxType_ADD_VALIDATOR, models.TxType_REMOVE_VALIDATOR,
+			TxTypeAddZkCircuit, TxTypeRemoveZkCircuit,
+			TxTypeSetTreasurerMultisig, TxTypeSetOracleMultisig:
+			return payload.Admin.GetNonce(), true
+		}
+	}
+	return 0, false
+}
+
+// IsOracleProcessTx returns true if the transaction is a per-process oracle
+// one, governed by the oracle multisig when sent by an oracle.
+func IsOracleProcessTx(tx *models.Tx) bool {
+	switch payload := tx.GetPayload().(type) {
+	case *models.Tx_SetProcess:
+		return true
+	case *models.Tx_Admin:
+		switch payload.Admin.GetTxtype() {
+		case models.TxType_ADD_PROCESS_KEYS, models.TxType_REVEAL_PROCESS_KEYS:
+			return true
+		}
+	}
+	return false
+}
+
+// AdminTxMultisig returns the multisig carried by the admin transaction, or nil
+// if there is none.
+func AdminTxMultisig(tx *models.AdminTx) (*Multisig, error) {
+	var multisig *Multisig
+	err := walkUnknownFields(tx, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != adminTxMultisigField || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+		multisig = &Multisig{}
+		return n, multisig.Unmarshal(v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return multisig, nil
+}
+
+// SetAdminTxMultisig sets the multisig carried by the admin transaction.
+func SetAdminTxMultisig(tx *models.AdminTx, multisig *Multisig) {
+	b := withoutUnknownField(tx, adminTxMultisigField)
+	if multisig != nil {
+		b = protowire.AppendTag(b, adminTxMultisigField, protowire.BytesType)
+		b = protowire.AppendBytes(b, multisig.Marshal())
+	}
+	tx.ProtoReflect().SetUnknown(b)
+}
+
+// AdminTxProposal returns the treasurer transaction proposed by the admin
+// transaction, or nil if there is none.
+func AdminTxProposal(tx *models.AdminTx) (*models.Tx, error) {
+	var proposed *models.Tx
+	err := walkUnknownFields(tx, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != adminTxProposalField || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+		proposed = &models.Tx{}
+		if err := proto.Unmarshal(v, proposed); err != nil {
+			return n, fmt.Errorf("cannot decode proposed transaction: %w", err)
+		}
+		return n, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proposed, nil
+}
+
+// SetAdminTxProposal sets the treasurer transaction proposed by the admin
+// transaction.
+func SetAdminTxProposal(tx *models.AdminTx, proposed *models.Tx) error {
+	b := withoutUnknownField(tx, adminTxProposalField)
+	if proposed != nil {
+		v, err := proto.MarshalOptions{Deterministic: true}.Marshal(proposed)
+		if err != nil {
+			return err
+		}
+		b = protowire.AppendTag(b, adminTxProposalField, protowire.BytesType)
+		b = protowire.AppendBytes(b, v)
+	}
+	tx.ProtoReflect().SetUnknown(b)
+	return nil
+}
+
+// AdminTxProposalID returns the proposal ID approved by the admin
+// transaction, or nil if there is none.
+func AdminTxProposalID(tx *models.AdminTx) ([]byte, error) {
+	var id []byte
+	err := walkUnknownFields(tx, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != adminTxProposalIDField || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		var n int
+		id, n = protowire.ConsumeBytes(b)
+		return n, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// SetAdminTxProposalID sets the proposal ID approved by the admin transaction.
+func SetAdminTxProposalID(tx *models.AdminTx, id []byte) {
+	b := withoutUnknownField(tx, adminTxProposalIDField)
+	if id != nil {
+		b = protowire.AppendTag(b, adminTxProposalIDField, protowire.BytesType)
+		b = protowire.AppendBytes(b, id)
+	}
+	tx.ProtoReflect().SetUnknown(b)
+}
+
+// AdminTxProposalSignature returns the signature of the oracle transaction
+// proposed by the admin transaction, or nil if there is none.
+func AdminTxProposalSignature(tx *models.AdminTx) ([]byte, error) {
+	var signature []byte
+	err := walkUnknownFields(tx, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != adminTxProposalSignatureField || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		var n int
+		signature, n = protowire.ConsumeBytes(b)
+		return n, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signature, nil
+}
+
+// SetAdminTxProposalSignature sets the signature of the oracle transaction
+// proposed by the admin transaction.
+func SetAdminTxProposalSignature(tx *models.AdminTx, signature []byte) {
+	b := withoutUnknownField(tx, adminTxProposalSignatureField)
+	if signature != nil {
+		b = protowire.AppendTag(b, adminTxProposalSignatureField, protowire.BytesType)
+		b = protowire.AppendBytes(b, signature)
+	}
+	tx.ProtoReflect().SetUnknown(b)
+}
+
+func multisigProposalKey(id []byte) []byte {
+	key := append([]byte(multisigProposalKeyPrefix), id...)
+	return key[:multisigProposalKeyLen]
+}
+
+// SetTreasurerMultisig sets the multisig governing the treasurer transactions.
+// A multisig without signers, or nil, removes it.
+func (v *State) SetTreasurerMultisig(multisig *Multisig) error {
+	return v.setMultisig(treasurerMultisigKey, multisig)
+}
+
+// TreasurerMultisig returns the multisig governing the treasurer
+// transactions, or nil if the treasurer key signs them.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) TreasurerMultisig(committed bool) (*Multisig, error) {
+	return v.multisig(treasurerMultisigKey, committed)
+}
+
+// SetOracleMultisig sets the multisig governing the per-process oracle
+// transactions.  A multisig without signers, or nil, removes it.
+func (v *State) SetOracleMultisig(multisig *Multisig) error {
+	return v.setMultisig(oracleMultisigKey, multisig)
+}
+
+// OracleMultisig returns the multisig governing the per-process oracle
+// transactions, or nil if any oracle signs them.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) OracleMultisig(committed bool) (*Multisig, error) {
+	return v.multisig(oracleMultisigKey, committed)
+}
+
+func (v *State) setMultisig(key string, multisig *Multisig) error {
+	var value []byte
+	if multisig != nil && len(multisig.Signers) > 0 {
+		value = multisig.Marshal()
+	}
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	return v.Tx.DeepSet([]byte(key), value, StateTreeCfg(TreeExtra))
+}
+
+func (v *State) multisig(key string, committed bool) (*Multisig, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	value, err := v.mainTreeViewer(committed).DeepGet([]byte(key), StateTreeCfg(TreeExtra))
+	if errors.Is(err, arbo.ErrKeyNotFound) || (err == nil && len(value) == 0) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	multisig := &Multisig{}
+	if err := multisig.Unmarshal(value); err != nil {
+		return nil, err
+	}
+	return multisig, nil
+}
+
+// SetMultisigProposal adds or updates a pending proposal.
+func (v *State) SetMultisigProposal(proposal *MultisigProposal) error {
+	id, err := MultisigProposalID(proposal.Tx)
+	if err != nil {
+		return err
+	}
+	value, err := proposal.Marshal()
+	if err != nil {
+		return err
+	}
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	return v.Tx.DeepSet(multisigProposalKey(id), value, StateTreeCfg(TreeExtra))
+}
+
+// MultisigProposal returns the pending proposal identified by id, or nil if it
+// does not exist.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) MultisigProposal(id []byte, committed bool) (*MultisigProposal, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	value, err := v.mainTreeViewer(committed).DeepGet(multisigProposalKey(id), StateTreeCfg(TreeExtra))
+	if errors.Is(err, arbo.ErrKeyNotFound) || (err == nil && len(value) == 0) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	proposal := &MultisigProposal{}
+	if err := proposal.Unmarshal(value); err != nil {
+		return nil, err
+	}
+	return proposal, nil
+}
+
+// MultisigProposals returns the pending proposals by hex encoded proposal ID.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) MultisigProposals(committed bool) (map[string]*MultisigProposal, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	extraTree, err := v.mainTreeViewer(committed).SubTree(StateTreeCfg(TreeExtra))
+	if err != nil {
+		return nil, err
+	}
+	proposals := make(map[string]*MultisigProposal)
+	var ierr error
+	if err := extraTree.Iterate(func(key, value []byte) bool {
+		if !strings.HasPrefix(string(key), multisigProposalKeyPrefix) || len(value) == 0 {
+			return false
+		}
+		proposal := &MultisigProposal{}
+		if ierr = proposal.Unmarshal(value); ierr != nil {
+			return true
+		}
+		id, err := MultisigProposalID(proposal.Tx)
+		if err != nil {
+			ierr = err
+			return true
+		}
+		proposals[fmt.Sprintf("%x", id)] = proposal
+		return false
+	}); err != nil {
+		return nil, err
+	}
+	if ierr != nil {
+		return nil, ierr
+	}
+	return proposals, nil
+}
+
+// deleteMultisigProposal removes the pending proposal identified by id.
+func (v *State) deleteMultisigProposal(id []byte) error {
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	return v.Tx.DeepSet(multisigProposalKey(id), nil, StateTreeCfg(TreeExtra))
+}
+
+// pruneMultisigProposals removes the pending proposals with a treasurer nonce
+// lower than nonce, which can no longer be executed.  The oracle proposals
+// are removed once executed.
+func (v *State) pruneMultisigProposals(nonce uint32) error {
+	proposals, err := v.MultisigProposals(false)
+	if err != nil {
+		return err
+	}
+	for id, proposal := range proposals {
+		txNonce, ok := TreasurerTxNonce(proposal.Tx)
+		if !ok || txNonce >= nonce {
+			continue
+		}
+		log.Debugf("removing multisig proposal %s with treasurer nonce %d", id, txNonce)
+		key, err := hex.DecodeString(id)
+		if err != nil {
+			return err
+		}
+		if err := v.deleteMultisigProposal(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyMultisigProposal checks the transaction is a pending proposal
+// approved by the multisig threshold.
+func (v *State) verifyMultisigProposal(multisig *Multisig, tx *models.Tx) error {
+	id, err := MultisigProposalID(tx)
+	if err != nil {
+		return err
+	}
+	proposal, err := v.MultisigProposal(id, false)
+	if err != nil {
+		return fmt.Errorf("cannot get multisig proposal: %w", err)
+	}
+	if proposal == nil {
+		return fmt.Errorf("transaction not proposed to the multisig, proposal %x", id)
+	}
+	if approvals := multisig.Approvals(proposal); approvals < multisig.Threshold {
+		return fmt.Errorf("proposal %x approved by %d signers, %d required", id, approvals, multisig.Threshold)
+	}
+	return nil
+}
+
+// VerifyTreasurerTx checks the treasurer transaction is authorized and its
+// nonce is the expected one.  Without multisig, addr must be the treasurer.
+// With multisig, addr must be one of its signers and the transaction must be
+// a pending proposal approved by the multisig threshold.
+func (v *State) VerifyTreasurerTx(tx *models.Tx, addr common.Address, txNonce uint32) error {
+	multisig, err := v.TreasurerMultisig(false)
+	if err != nil {
+		return fmt.Errorf("cannot get treasurer multisig: %w", err)
+	}
+	if multisig == nil {
+		return v.VerifyTreasurer(addr, txNonce)
+	}
+	treasurer, err := v.Treasurer(false)
+	if err != nil {
+		return fmt.Errorf("cannot check authorization")
+	}
+	if !multisig.IsSigner(addr) {
+		return fmt.Errorf("not authorized for executing treasurer multisig transactions")
+	}
+	if treasurer.Nonce != txNonce {
+		return ErrAccountNonceInvalid
+	}
+	return v.verifyMultisigProposal(multisig, tx)
+}
+
+// VerifyOracleTx checks the per-process oracle transaction signed by addr is
+// authorized by the oracle multisig.  Without multisig, any oracle signs it.
+// With multisig, addr must be one of its signers and the transaction must be
+// a pending proposal approved by the multisig threshold.
+func (v *State) VerifyOracleTx(tx *models.Tx, addr common.Address) error {
+	multisig, err := v.OracleMultisig(false)
+	if err != nil {
+		return fmt.Errorf("cannot get oracle multisig: %w", err)
+	}
+	if multisig == nil {
+		return nil
+	}
+	if !multisig.IsSigner(addr) {
+		return fmt.Errorf("not authorized for executing oracle multisig transactions")
+	}
+	return v.verifyMultisigProposal(multisig, tx)
+}
+
+// proposalMultisig returns the multisig governing the proposed transaction
+// and its name.
+func proposalMultisig(state *State, tx *models.Tx) (*Multisig, string, error) {
+	if _, ok := TreasurerTxNonce(tx); ok {
+		multisig, err := state.TreasurerMultisig(false)
+		return multisig, "treasurer", err
+	}
+	if IsOracleProcessTx(tx) {
+		multisig, err := state.OracleMultisig(false)
+		return multisig, "oracle", err
+	}
+	return nil, "", fmt.Errorf("proposed transaction is not a treasurer or oracle transaction")
+}
+
+// multisigTxCheck checks the admin transactions setting a multisig and
+// proposing or approving treasurer or oracle transactions, signed by addr.
+func multisigTxCheck(vtx *models.Tx, addr common.Address, state *State) error {
+	tx := vtx.GetAdmin()
+	if tx.Txtype == TxTypeSetTreasurerMultisig || tx.Txtype == TxTypeSetOracleMultisig {
+		if err := state.VerifyTreasurerTx(vtx, addr, tx.Nonce); err != nil {
+			return fmt.Errorf("tx sender not authorized: %w", err)
+		}
+		multisig, err := AdminTxMultisig(tx)
+		if err != nil {
+			return err
+		}
+		if multisig == nil {
+			return fmt.Errorf("missing multisig")
+		}
+		if err := multisig.Check(); err != nil {
+			return fmt.Errorf("invalid multisig: %w", err)
+		}
+		return nil
+	}
+	// the proposed transaction, from the admin transaction or the proposal
+	var proposed *models.Tx
+	var proposal *MultisigProposal
+	var id []byte
+	var err error
+	switch tx.Txtype {
+	case TxTypeProposeMultisigTx:
+		if proposed, err = AdminTxProposal(tx); err != nil {
+			return err
+		}
+		if proposed == nil {
+			return fmt.Errorf("missing proposed transaction")
+		}
+		if id, err = MultisigProposalID(proposed); err != nil {
+			return err
+		}
+	case TxTypeApproveMultisigTx:
+		if id, err = AdminTxProposalID(tx); err != nil {
+			return err
+		}
+		if len(id) != sha256.Size {
+			return fmt.Errorf("invalid proposal ID %x", id)
+		}
+	}
+	if proposal, err = state.MultisigProposal(id, false); err != nil {
+		return fmt.Errorf("cannot get multisig proposal: %w", err)
+	}
+	if proposal != nil {
+		proposed = proposal.Tx
+	} else if tx.Txtype == TxTypeApproveMultisigTx {
+		return fmt.Errorf("multisig proposal %x not found", id)
+	}
+	multisig, name, err := proposalMultisig(state, proposed)
+	if err != nil {
+		return err
+	}
+	if multisig == nil {
+		return fmt.Errorf("the %s multisig is not set", name)
+	}
+	if !multisig.IsSigner(addr) {
+		return fmt.Errorf("%s is not a signer of the %s multisig", addr, name)
+	}
+	if tx.Txtype == TxTypeApproveMultisigTx {
+		for _, approval := range proposal.Approvals {
+			if approval == addr {
+				return fmt.Errorf("proposal %x already approved by %s", id, addr)
+			}
+		}
+		return nil
+	}
+	if proposal != nil {
+		return fmt.Errorf("transaction already proposed, proposal %x", id)
+	}
+	if nonce, ok := TreasurerTxNonce(proposed); ok {
+		treasurer, err := state.Treasurer(false)
+		if err != nil {
+			return fmt.Errorf("cannot get treasurer: %w", err)
+		}
+		if nonce < treasurer.Nonce {
+			return fmt.Errorf("proposed transaction nonce %d already used, expected at least %d",
+				nonce, treasurer.Nonce)
+		}
+		return nil
+	}
+	// the oracle transactions are executed with the proposer signature
+	signature, err := AdminTxProposalSignature(tx)
+	if err != nil {
+		return err
+	}
+	txBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(proposed)
+	if err != nil {
+		return err
+	}
+	signer, err := ethereum.AddrFromSignature(ethereum.BuildVocdoniTransaction(txBytes, state.chainID), signature)
+	if err != nil {
+		return fmt.Errorf("invalid proposed transaction signature: %w", err)
+	}
+	if signer != addr {
+		return fmt.Errorf("proposed transaction signed by %s, not by the proposer", signer)
+	}
+	return nil
+}
+
+// addMultisigApproval adds a proposal, or the approval of an existing one,
+// signed by addr.  The transaction must be checked by multisigTxCheck.
+func (v *State) addMultisigApproval(tx *models.AdminTx, addr common.Address) ([]byte, error) {
+	var proposal *MultisigProposal
+	switch tx.Txtype {
+	case TxTypeProposeMultisigTx:
+		proposed, err := AdminTxProposal(tx)
+		if err != nil {
+			return nil, err
+		}
+		signature, err := AdminTxProposalSignature(tx)
+		if err != nil {
+			return nil, err
+		}
+		proposal = &MultisigProposal{Tx: proposed, Signature: signature}
+	case TxTypeApproveMultisigTx:
+		id, err := AdminTxProposalID(tx)
+		if err != nil {
+			return nil, err
+		}
+		if proposal, err = v.MultisigProposal(id, false); err != nil || proposal == nil {
+			return nil, fmt.Errorf("cannot get multisig proposal %x: %v", id, err)
+		}
+	}
+	proposal.Approvals = append(proposal.Approvals, addr)
+	if err := v.SetMultisigProposal(proposal); err != nil {
+		return nil, err
+	}
+	id, err := MultisigProposalID(proposal.Tx)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("multisig proposal %x approved by %s, %d approvals", id, addr, len(proposal.Approvals))
+	return id, nil
+}
+
+// executeOracleProposal executes the oracle transaction of the proposal
+// identified by id, once approved by the oracle multisig threshold, and
+// removes the proposal.  The proposals of treasurer transactions are
+// executed by their signers.
+func (app *BaseApplication) executeOracleProposal(id []byte) (*AddTxResponse, error) {
+	proposal, err := app.State.MultisigProposal(id, false)
+	if err != nil || proposal == nil {
+		return nil, fmt.Errorf("cannot get multisig proposal %x: %v", id, err)
+	}
+	if !IsOracleProcessTx(proposal.Tx) {
+		return nil, nil
+	}
+	multisig, err := app.State.OracleMultisig(false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get oracle multisig: %w", err)
+	}
+	if multisig == nil || multisig.Approvals(proposal) < multisig.Threshold {
+		return nil, nil
+	}
+	txBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(proposal.Tx)
+	if err != nil {
+		return nil, err
+	}
+	stxBytes, err := proto.Marshal(&models.SignedTx{Tx: txBytes, Signature: proposal.Signature})
+	if err != nil {
+		return nil, err
+	}
+	vtx := new(VochainTx)
+	if err := vtx.Unmarshal(stxBytes, app.ChainID()); err != nil {
+		return nil, err
+	}
+	// a failed check keeps the proposal, executed by a later approval
+	if _, err := app.AddTx(vtx, false); err != nil {
+		log.Warnf("cannot execute oracle proposal %x: %v", id, err)
+		return nil, nil
+	}
+	response, err := app.AddTx(vtx, true)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("executed oracle proposal %x", id)
+	return response, app.State.deleteMultisigProposal(id)
+}
+
+// SignOracleTx signs the per-process oracle transaction for sending it to the
+// Vochain.  When the oracle multisig is set, the transaction is proposed to
+// the multisig signers instead, and executed once approved.
+func (app *BaseApplication) SignOracleTx(signer *ethereum.SignKeys, tx *models.Tx) (*models.SignedTx, error) {
+	multisig, err := app.State.OracleMultisig(true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get oracle multisig: %w", err)
+	}
+	if multisig != nil && IsOracleProcessTx(tx) {
+		admin := &models.AdminTx{Txtype: TxTypeProposeMultisigTx}
+		if err := SetAdminTxProposal(admin, tx); err != nil {
+			return nil, err
+		}
+		txBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(tx)
+		if err != nil {
+			return nil, err
+		}
+		signature, err := signer.SignVocdoniTx(txBytes, app.ChainID())
+		if err != nil {
+			return nil, err
+		}
+		SetAdminTxProposalSignature(admin, signature)
+		id, err := MultisigProposalID(tx)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("proposing oracle transaction to the multisig signers, proposal %x", id)
+		tx = &models.Tx{Payload: &models.Tx_Admin{Admin: admin}}
+	}
+	stx := &models.SignedTx{}
+	if stx.Tx, err = proto.Marshal(tx); err != nil {
+		return nil, err
+	}
+	if stx.Signature, err = signer.SignVocdoniTx(stx.Tx, app.ChainID()); err != nil {
+		return nil, err
+	}
+	return stx, nil
+}
@@ -0,0 +1,256 @@
+package vochain
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTreasurerMultisig(t *testing.T) {
+	app := TestBaseApplication(t)
+	keys := make([]ethereum.SignKeys, 4)
+	for i := range keys {
+		qt.Assert(t, keys[i].Generate(), qt.IsNil)
+	}
+	treasurer, signer1, signer2, other := &keys[0], &keys[1], &keys[2], &keys[3]
+	qt.Assert(t, app.State.SetTreasurer(treasurer.Address(), 0), qt.IsNil)
+	recipient := common.HexToAddress(randomEthAccount)
+	qt.Assert(t, app.State.CreateAccount(recipient, "", nil, 0), qt.IsNil)
+
+	send := func(signer *ethereum.SignKeys, tx *models.Tx) error {
+		stx := &models.SignedTx{}
+		var err error
+		stx.Tx, err = proto.Marshal(tx)
+		qt.Assert(t, err, qt.IsNil)
+		return sendTx(app, signer, stx)
+	}
+	adminTx := func(tx *models.AdminTx) *models.Tx {
+		return &models.Tx{Payload: &models.Tx_Admin{Admin: tx}}
+	}
+	propose := func(signer *ethereum.SignKeys, proposed *models.Tx) error {
+		tx := &models.AdminTx{Txtype: TxTypeProposeMultisigTx}
+		qt.Assert(t, SetAdminTxProposal(tx, proposed), qt.IsNil)
+		return send(signer, adminTx(tx))
+	}
+	approve := func(signer *ethereum.SignKeys, proposed *models.Tx) error {
+		id, err := MultisigProposalID(proposed)
+		qt.Assert(t, err, qt.IsNil)
+		tx := &models.AdminTx{Txtype: TxTypeApproveMultisigTx}
+		SetAdminTxProposalID(tx, id)
+		return send(signer, adminTx(tx))
+	}
+	mint := func(value uint64, nonce uint32) *models.Tx {
+		return &models.Tx{Payload: &models.Tx_MintTokens{MintTokens: &models.MintTokensTx{
+			Txtype: models.TxType_MINT_TOKENS,
+			To:     recipient.Bytes(),
+			Value:  value,
+			Nonce:  nonce,
+		}}}
+	}
+
+	// proposals require a multisig
+	qt.Assert(t, propose(treasurer, mint(100, 0)), qt.ErrorMatches, ".*multisig is not set.*")
+
+	// invalid multisigs are rejected
+	setMultisig := &models.AdminTx{Txtype: TxTypeSetTreasurerMultisig}
+	SetAdminTxMultisig(setMultisig, &Multisig{
+		Signers:   []common.Address{signer1.Address(), signer1.Address()},
+		Threshold: 2,
+	})
+	qt.Assert(t, send(treasurer, adminTx(setMultisig)), qt.ErrorMatches, ".*duplicated signer.*")
+	SetAdminTxMultisig(setMultisig, &Multisig{
+		Signers:   []common.Address{signer1.Address(), signer2.Address()},
+		Threshold: 3,
+	})
+	qt.Assert(t, send(treasurer, adminTx(setMultisig)), qt.ErrorMatches, ".*invalid threshold.*")
+
+	// the treasurer sets a 2-of-3 multisig
+	multisig := &Multisig{
+		Signers:   []common.Address{treasurer.Address(), signer1.Address(), signer2.Address()},
+		Threshold: 2,
+	}
+	SetAdminTxMultisig(setMultisig, multisig)
+	qt.Assert(t, send(treasurer, adminTx(setMultisig)), qt.IsNil)
+	app.AdvanceTestBlock()
+	stored, err := app.State.TreasurerMultisig(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stored, qt.DeepEquals, multisig)
+
+	// the treasurer key alone can no longer mint
+	qt.Assert(t, send(treasurer, mint(100, 1)), qt.ErrorMatches, ".*not proposed.*")
+
+	// only the signers can propose
+	qt.Assert(t, propose(other, mint(100, 1)), qt.ErrorMatches, ".*not a signer of the treasurer multisig.*")
+	qt.Assert(t, propose(signer1, mint(100, 1)), qt.IsNil)
+	qt.Assert(t, propose(signer2, mint(100, 1)), qt.ErrorMatches, ".*already proposed.*")
+
+	// the proposer approval is not enough
+	qt.Assert(t, send(signer1, mint(100, 1)), qt.ErrorMatches, ".*approved by 1 signers, 2 required.*")
+	qt.Assert(t, approve(signer1, mint(100, 1)), qt.ErrorMatches, ".*already approved.*")
+	qt.Assert(t, approve(other, mint(100, 1)), qt.IsNotNil)
+	qt.Assert(t, approve(signer2, mint(100, 1)), qt.IsNil)
+
+	// any signer executes the approved proposal, but not others
+	qt.Assert(t, send(other, mint(100, 1)), qt.IsNotNil)
+	qt.Assert(t, send(treasurer, mint(100, 1)), qt.IsNil)
+	app.AdvanceTestBlock()
+	acc, err := app.State.GetAccount(recipient, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, acc.Balance, qt.Equals, uint64(100))
+
+	// the executed proposal is pruned and cannot be proposed again
+	proposals, err := app.State.MultisigProposals(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proposals, qt.HasLen, 0)
+	qt.Assert(t, propose(signer1, mint(100, 1)), qt.ErrorMatches, ".*nonce 1 already used.*")
+
+	// admin transactions are approved the same way
+	oracle := &models.AdminTx{Txtype: models.TxType_ADD_ORACLE, Address: other.Address().Bytes(), Nonce: 2}
+	qt.Assert(t, propose(signer2, adminTx(oracle)), qt.IsNil)
+	qt.Assert(t, send(signer2, adminTx(oracle)), qt.IsNotNil)
+	qt.Assert(t, approve(treasurer, adminTx(oracle)), qt.IsNil)
+	qt.Assert(t, send(signer2, adminTx(oracle)), qt.IsNil)
+	app.AdvanceTestBlock()
+	oracles, err := app.State.Oracles(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, oracles, qt.Contains, other.Address())
+
+	// removing the multisig restores the treasurer key
+	remove := &models.AdminTx{Txtype: TxTypeSetTreasurerMultisig, Nonce: 3}
+	SetAdminTxMultisig(remove, &Multisig{})
+	qt.Assert(t, propose(signer1, adminTx(remove)), qt.IsNil)
+	qt.Assert(t, approve(signer2, adminTx(remove)), qt.IsNil)
+	qt.Assert(t, send(signer1, adminTx(remove)), qt.IsNil)
+	app.AdvanceTestBlock()
+	stored, err = app.State.TreasurerMultisig(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stored, qt.IsNil)
+	qt.Assert(t, send(treasurer, mint(50, 4)), qt.IsNil)
+}
+
+func TestOracleMultisig(t *testing.T) {
+	app := TestBaseApplication(t)
+	keys := make([]ethereum.SignKeys, 4)
+	for i := range keys {
+		qt.Assert(t, keys[i].Generate(), qt.IsNil)
+	}
+	treasurer, oracle1, oracle2, oracle3 := &keys[0], &keys[1], &keys[2], &keys[3]
+	qt.Assert(t, app.State.SetTreasurer(treasurer.Address(), 0), qt.IsNil)
+	qt.Assert(t, app.State.SetAccount(BurnAddress, &Account{}), qt.IsNil)
+	qt.Assert(t, app.State.SetTxCost(models.TxType_SET_PROCESS_STATUS, 10), qt.IsNil)
+	for _, oracle := range []*ethereum.SignKeys{oracle1, oracle2, oracle3} {
+		qt.Assert(t, app.State.AddOracle(oracle.Address()), qt.IsNil)
+		qt.Assert(t, app.State.SetAccount(oracle.Address(), &Account{models.Account{Balance: 1000}}), qt.IsNil)
+	}
+	pid := util.RandomBytes(types.ProcessIDsize)
+	censusURI := ipfsUrl
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{Interruptible: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 16, MaxValue: 16},
+		Status:       models.ProcessStatus_READY,
+		EntityId:     util.RandomBytes(types.EthereumAddressSize),
+		CensusRoot:   util.RandomBytes(32),
+		CensusURI:    &censusURI,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		BlockCount:   1024,
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	send := func(signer *ethereum.SignKeys, tx *models.Tx) error {
+		stx := &models.SignedTx{}
+		var err error
+		stx.Tx, err = proto.Marshal(tx)
+		qt.Assert(t, err, qt.IsNil)
+		return sendTx(app, signer, stx)
+	}
+	setStatus := func(signer *ethereum.SignKeys, status models.ProcessStatus) *models.Tx {
+		acc, err := app.State.GetAccount(signer.Address(), false)
+		qt.Assert(t, err, qt.IsNil)
+		return &models.Tx{Payload: &models.Tx_SetProcess{SetProcess: &models.SetProcessTx{
+			Txtype:    models.TxType_SET_PROCESS_STATUS,
+			Nonce:     acc.Nonce,
+			ProcessId: pid,
+			Status:    &status,
+		}}}
+	}
+	propose := func(signer *ethereum.SignKeys, tx *models.Tx) error {
+		stx, err := app.SignOracleTx(signer, tx)
+		qt.Assert(t, err, qt.IsNil)
+		return sendTx(app, signer, stx)
+	}
+	approve := func(signer *ethereum.SignKeys, tx *models.Tx) error {
+		id, err := MultisigProposalID(tx)
+		qt.Assert(t, err, qt.IsNil)
+		admin := &models.AdminTx{Txtype: TxTypeApproveMultisigTx}
+		SetAdminTxProposalID(admin, id)
+		return send(signer, &models.Tx{Payload: &models.Tx_Admin{Admin: admin}})
+	}
+	status := func() models.ProcessStatus {
+		process, err := app.State.Process(pid, false)
+		qt.Assert(t, err, qt.IsNil)
+		return process.Status
+	}
+
+	// without multisig, any oracle sets the process status
+	qt.Assert(t, send(oracle3, setStatus(oracle3, models.ProcessStatus_PAUSED)), qt.IsNil)
+	qt.Assert(t, status(), qt.Equals, models.ProcessStatus_PAUSED)
+
+	// the treasurer sets a 2-of-2 oracle multisig
+	setMultisig := &models.AdminTx{Txtype: TxTypeSetOracleMultisig}
+	multisig := &Multisig{
+		Signers:   []common.Address{oracle1.Address(), oracle2.Address()},
+		Threshold: 2,
+	}
+	SetAdminTxMultisig(setMultisig, multisig)
+	qt.Assert(t, send(oracle1, &models.Tx{Payload: &models.Tx_Admin{Admin: setMultisig}}),
+		qt.ErrorMatches, ".*not authorized.*")
+	qt.Assert(t, send(treasurer, &models.Tx{Payload: &models.Tx_Admin{Admin: setMultisig}}), qt.IsNil)
+	app.AdvanceTestBlock()
+	stored, err := app.State.OracleMultisig(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stored, qt.DeepEquals, multisig)
+
+	// a single oracle can no longer set the process status
+	resume := setStatus(oracle1, models.ProcessStatus_READY)
+	qt.Assert(t, send(oracle1, resume), qt.ErrorMatches, ".*not proposed.*")
+	qt.Assert(t, send(oracle3, setStatus(oracle3, models.ProcessStatus_READY)),
+		qt.ErrorMatches, ".*not authorized for executing oracle multisig transactions.*")
+	qt.Assert(t, propose(oracle3, setStatus(oracle3, models.ProcessStatus_READY)),
+		qt.ErrorMatches, ".*not a signer of the oracle multisig.*")
+
+	// the proposal carries the signature of its proposer
+	unsigned := &models.AdminTx{Txtype: TxTypeProposeMultisigTx}
+	qt.Assert(t, SetAdminTxProposal(unsigned, resume), qt.IsNil)
+	qt.Assert(t, send(oracle1, &models.Tx{Payload: &models.Tx_Admin{Admin: unsigned}}),
+		qt.ErrorMatches, ".*invalid proposed transaction signature.*")
+	stx, err := app.SignOracleTx(oracle1, resume)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, sendTx(app, oracle2, stx), qt.ErrorMatches, ".*not by the proposer.*")
+
+	// the proposal is executed once approved by the threshold
+	qt.Assert(t, propose(oracle1, resume), qt.IsNil)
+	app.AdvanceTestBlock()
+	qt.Assert(t, status(), qt.Equals, models.ProcessStatus_PAUSED)
+	qt.Assert(t, send(oracle1, resume), qt.ErrorMatches, ".*approved by 1 signers, 2 required.*")
+	qt.Assert(t, approve(oracle3, resume), qt.IsNotNil)
+	qt.Assert(t, approve(oracle2, resume), qt.IsNil)
+	app.AdvanceTestBlock()
+	qt.Assert(t, status(), qt.Equals, models.ProcessStatus_READY)
+	acc, err := app.State.GetAccount(oracle1.Address(), true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, acc.Nonce, qt.Equals, uint32(1))
+
+	// the executed proposal is removed and cannot be replayed
+	proposals, err := app.State.MultisigProposals(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proposals, qt.HasLen, 0)
+	qt.Assert(t, send(oracle1, resume), qt.ErrorMatches, ".*invalid account nonce.*")
+}
@@ -4,10 +4,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/vocdoni/arbo"
-	"go.vocdoni.io/dvote/crypto/zk/artifacts"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/statedb"
 	"go.vocdoni.io/dvote/types"
@@ -59,6 +59,11 @@ func (v *State) AddProcess(p *models.Process) error {
 				return err
 			}
 		}
+		if IsTimeBasedProcess(p) {
+			if err := v.addTimeBasedProcessID(p.ProcessId); err != nil {
+				return err
+			}
+		}
 		return v.setProcessIDByStartBlock(p.ProcessId, p.StartBlock)
 	}()
 	v.Tx.Unlock()
@@ -125,6 +130,16 @@ func (v *State) Process(pid []byte, committed bool) (*models.Process, error) {
 	return getProcess(v.mainTreeViewer(committed), pid)
 }
 
+// ProcessAt returns a process info given a processId as it was committed at
+// the given height.  If height is 0, the last committed version is used.
+func (v *State) ProcessAt(pid []byte, height uint32) (*models.Process, error) {
+	mainTreeView, err := v.mainTreeViewAt(height)
+	if err != nil {
+		return nil, err
+	}
+	return getProcess(mainTreeView, pid)
+}
+
 // CountProcesses returns the overall number of processes the vochain has
 func (v *State) CountProcesses(committed bool) (uint64, error) {
 	// TODO: Once statedb.TreeView.Size() works, replace this by that.
@@ -423,8 +438,15 @@ func (app *BaseApplication) NewProcessTxCheck(vtx *models.Tx, txBytes,
 		return nil, common.Address{}, fmt.Errorf("missing signature or new process transaction")
 	}
 	// start and block count sanity check
-	// if startBlock is zero or one, the process will be enabled on the next block
-	if tx.Process.StartBlock == 0 || tx.Process.StartBlock == 1 {
+	// time-based processes get their start block and block count estimated
+	// from the block time, else if startBlock is zero or one, the process will
+	// be enabled on the next block
+	if IsTimeBasedProcess(tx.Process) {
+		if err := checkProcessTimes(tx.Process, state.CurrentHeight(),
+			time.Unix(app.TimestampStartBlock(), 0)); err != nil {
+			return nil, common.Address{}, err
+		}
+	} else if tx.Process.StartBlock == 0 || tx.Process.StartBlock == 1 {
 		tx.Process.StartBlock = state.CurrentHeight() + 1
 	} else if tx.Process.StartBlock < state.CurrentHeight() {
 		return nil, common.Address{}, fmt.Errorf(
@@ -497,29 +519,42 @@ func (app *BaseApplication) NewProcessTxCheck(vtx *models.Tx, txBytes,
 		return nil, common.Address{}, fmt.Errorf("pre-register mode only supported " +
 			"with anonymous envelope type and viceversa")
 	}
+	if ProcessDelegatedVoting(tx.Process.Mode) && tx.Process.EnvelopeType.Anonymous {
+		return nil, common.Address{}, fmt.Errorf("delegated voting is not supported " +
+			"with anonymous envelope type")
+	}
+	if IsVochainAccountsCensus(tx.Process.CensusOrigin) {
+		if tx.Process.EnvelopeType.Anonymous {
+			return nil, common.Address{}, fmt.Errorf("vochain accounts census is not " +
+				"supported with anonymous envelope type")
+		}
+		if tx.Process.StartBlock <= state.CurrentHeight() {
+			return nil, common.Address{}, fmt.Errorf("vochain accounts census requires " +
+				"a start block greater than the current height")
+		}
+		// the census root is the Accounts tree root at the start block
+		tx.Process.CensusRoot = nil
+		tx.Process.CensusURI = nil
+	}
 	if tx.Process.Mode.PreRegister &&
 		(tx.Process.MaxCensusSize == nil || *tx.Process.MaxCensusSize <= 0) {
 		return nil, common.Address{}, fmt.Errorf("pre-register mode requires setting " +
 			"maxCensusSize to be > 0")
 	}
 	if tx.Process.Mode.PreRegister && tx.Process.EnvelopeType.Anonymous {
-		var circuits []artifacts.CircuitConfig
-		if genesis, ok := Genesis[app.chainID]; ok {
-			circuits = genesis.CircuitsConfig
-		} else {
-			log.Warn("Using dev network genesis CircuitsConfig")
-			circuits = Genesis["dev"].CircuitsConfig
-		}
-		if len(circuits) == 0 {
-			return nil, common.Address{}, fmt.Errorf("no circuit configs in the %v genesis", app.chainID)
-		}
 		if tx.Process.MaxCensusSize == nil {
 			return nil, common.Address{}, fmt.Errorf("maxCensusSize is not provided")
 		}
-		if *tx.Process.MaxCensusSize > uint64(circuits[len(circuits)-1].Parameters[0]) {
+		maxCensusSize, err := app.zkMaxCensusSize()
+		if err != nil {
+			return nil, common.Address{}, fmt.Errorf("cannot get zk circuits: %w", err)
+		}
+		if maxCensusSize == 0 {
+			return nil, common.Address{}, fmt.Errorf("no zk circuits available in the %v chain", app.chainID)
+		}
+		if *tx.Process.MaxCensusSize > maxCensusSize {
 			return nil, common.Address{}, fmt.Errorf("maxCensusSize for anonymous envelope "+
-				"cannot be bigger than the parameter for the biggest circuit (%v)",
-				circuits[len(circuits)-1].Parameters[0])
+				"cannot be bigger than the parameter for the biggest circuit (%v)", maxCensusSize)
 		}
 	}
 
@@ -578,6 +613,10 @@ func SetProcessTxCheck(vtx *models.Tx, txBytes, signature []byte, state *State)
 		if !isOracle {
 			return common.Address{}, fmt.Errorf("unauthorized to set process status, recovered addr is %s", addr.Hex())
 		}
+		// the oracle transactions are governed by the oracle multisig, if any
+		if err := state.VerifyOracleTx(vtx, *addr); err != nil {
+			return common.Address{}, fmt.Errorf("tx sender not authorized: %w", err)
+		}
 	}
 	switch tx.Txtype {
 	case models.TxType_SET_PROCESS_RESULTS:
@@ -0,0 +1,305 @@
+package vochain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/crypto/dkg"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// The threshold key dealings of a process (see crypto/dkg) are published by
+// the key keepers until the process starts.  Meanwhile, a key keeper whose
+// share of a dealing is not valid complains against it, and the dealer must
+// answer revealing the share in clear.  At the end of the block before the
+// process starts the joint key is frozen: the dealers with unanswered
+// complaints are disqualified, their dealings removed, and the joint public
+// key of the remaining ones is stored on index 0.  The key keepers are
+// identified by the oracle that signed their dealing, so only the dealers can
+// complain.  The complaint transactions are not part of the models yet, so
+// they are admin transactions of new types.
+
+const (
+	// TxTypeComplainProcessKeys complains against the threshold key
+	// dealing at the AdminTx KeyIndex, signed by the oracle of another
+	// dealing whose share is not valid.
+	TxTypeComplainProcessKeys models.TxType = 28
+	// TxTypeAnswerProcessKeysComplaint answers a complaint against the
+	// dealing of the signer oracle, revealing the share of the complainer at
+	// the AdminTx KeyIndex as the AdminTx EncryptionPrivateKey.
+	TxTypeAnswerProcessKeysComplaint models.TxType = 29
+)
+
+const (
+	// pathProcessKeyDealers is the db path prefix of the oracles that
+	// published the threshold key dealings of a process.
+	pathProcessKeyDealers = "processKeyDealers/"
+	// pathProcessKeyComplaints is the db path prefix of the complaints
+	// against the threshold key dealings of a process.
+	pathProcessKeyComplaints = "processKeyComplaints/"
+)
+
+// ProcessKeyComplaint is a complaint of a key keeper against the threshold key
+// dealing of another one.
+type ProcessKeyComplaint struct {
+	Dealer     uint32
+	Complainer uint32
+	// Share is the share of the complainer revealed by the dealer, nil
+	// while the complaint is not answered.
+	Share []byte
+}
+
+// processKeyComplaintSize is the size of an encoded complaint:
+// dealer (1 byte) | complainer (1 byte) | answered (1 byte) | share.
+const processKeyComplaintSize = 3 + dkg.ScalarLength
+
+// IsThresholdKeyProcess returns true if the process keys are a threshold key,
+// whose joint public key was frozen on index 0 when the process started.
+func IsThresholdKeyProcess(p *models.Process) bool {
+	return len(p.EncryptionPublicKeys) > 0 && len(p.EncryptionPublicKeys[0]) > 0
+}
+
+// processKeyDealing decodes the threshold key dealing at index.
+func processKeyDealing(process *models.Process, index uint32) (*dkg.Dealing, error) {
+	if int(index) >= len(process.EncryptionPublicKeys) ||
+		!isKeyDealing(process.EncryptionPublicKeys[index]) {
+		return nil, fmt.Errorf("no threshold key dealing at index %d", index)
+	}
+	data, err := hex.DecodeString(process.EncryptionPublicKeys[index])
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode threshold key dealing %d: %w", index, err)
+	}
+	return dkg.UnmarshalDealing(data)
+}
+
+// processKeyDealer returns the index of the threshold key dealing published
+// by the oracle addr on the process, if any.
+func (v *State) processKeyDealer(pid []byte, addr common.Address) (uint32, bool, error) {
+	v.Tx.RLock()
+	defer v.Tx.RUnlock()
+	dealers, err := v.Tx.NoState().Get(append([]byte(pathProcessKeyDealers), pid...))
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	for i := 0; i+1+common.AddressLength <= len(dealers); i += 1 + common.AddressLength {
+		if bytes.Equal(dealers[i+1:i+1+common.AddressLength], addr.Bytes()) {
+			return uint32(dealers[i]), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// addProcessKeyDealer records that the oracle addr published the threshold key
+// dealing at index.
+func (v *State) addProcessKeyDealer(pid []byte, index uint32, addr common.Address) error {
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	key := append([]byte(pathProcessKeyDealers), pid...)
+	dealers, err := v.Tx.NoState().Get(key)
+	if err != nil && !errors.Is(err, db.ErrKeyNotFound) {
+		return err
+	}
+	dealers = append(append(dealers, byte(index)), addr.Bytes()...)
+	return v.Tx.NoState().Set(key, dealers)
+}
+
+// ProcessKeyComplaints returns the complaints against the threshold key
+// dealings of the process.
+func (v *State) ProcessKeyComplaints(pid []byte) ([]*ProcessKeyComplaint, error) {
+	v.Tx.RLock()
+	defer v.Tx.RUnlock()
+	return v.processKeyComplaints(pid)
+}
+
+// processKeyComplaints returns the complaints against the threshold key
+// dealings of the process.  The caller must hold the Tx lock.
+func (v *State) processKeyComplaints(pid []byte) ([]*ProcessKeyComplaint, error) {
+	data, err := v.Tx.NoState().Get(append([]byte(pathProcessKeyComplaints), pid...))
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var complaints []*ProcessKeyComplaint
+	for i := 0; i+processKeyComplaintSize <= len(data); i += processKeyComplaintSize {
+		c := &ProcessKeyComplaint{Dealer: uint32(data[i]), Complainer: uint32(data[i+1])}
+		if data[i+2] == 1 {
+			c.Share = append([]byte(nil), data[i+3:i+processKeyComplaintSize]...)
+		}
+		complaints = append(complaints, c)
+	}
+	return complaints, nil
+}
+
+// setProcessKeyComplaints stores the complaints against the threshold key
+// dealings of the process.
+func (v *State) setProcessKeyComplaints(pid []byte, complaints []*ProcessKeyComplaint) error {
+	data := []byte{}
+	for _, c := range complaints {
+		entry := make([]byte, processKeyComplaintSize)
+		entry[0], entry[1] = byte(c.Dealer), byte(c.Complainer)
+		if c.Share != nil {
+			entry[2] = 1
+			copy(entry[3:], c.Share)
+		}
+		data = append(data, entry...)
+	}
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	return v.Tx.NoState().Set(append([]byte(pathProcessKeyComplaints), pid...), data)
+}
+
+// processKeyComplaint returns the complaint of complainer against the dealing
+// of dealer, or nil if there is none.
+func processKeyComplaint(complaints []*ProcessKeyComplaint, dealer, complainer uint32) *ProcessKeyComplaint {
+	for _, c := range complaints {
+		if c.Dealer == dealer && c.Complainer == complainer {
+			return c
+		}
+	}
+	return nil
+}
+
+// checkProcessKeysComplaint checks a complaint or an answer transaction
+// signed by addr, returning the dealer and the complainer indexes.
+func checkProcessKeysComplaint(state *State, tx *models.AdminTx,
+	process *models.Process, addr common.Address) (uint32, uint32, error) {
+	if tx.KeyIndex == nil {
+		return 0, 0, fmt.Errorf("missing keyIndex on AdminTxCheck")
+	}
+	if state.CurrentHeight() >= process.StartBlock || IsThresholdKeyProcess(process) {
+		return 0, 0, fmt.Errorf("threshold key for process %x already frozen", tx.ProcessId)
+	}
+	signerIndex, ok, err := state.processKeyDealer(tx.ProcessId, addr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot get process key dealers: %w", err)
+	}
+	if !ok {
+		return 0, 0, fmt.Errorf("%s has no threshold key dealing on process %x", addr.Hex(), tx.ProcessId)
+	}
+	dealer, complainer := *tx.KeyIndex, signerIndex
+	if tx.Txtype == TxTypeAnswerProcessKeysComplaint {
+		dealer, complainer = signerIndex, *tx.KeyIndex
+	}
+	if dealer == complainer {
+		return 0, 0, fmt.Errorf("cannot complain against the own dealing")
+	}
+	dealing, err := processKeyDealing(process, dealer)
+	if err != nil {
+		return 0, 0, err
+	}
+	complaints, err := state.ProcessKeyComplaints(tx.ProcessId)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot get process key complaints: %w", err)
+	}
+	complaint := processKeyComplaint(complaints, dealer, complainer)
+	switch tx.Txtype {
+	case TxTypeComplainProcessKeys:
+		if complaint != nil {
+			return 0, 0, fmt.Errorf("dealing %d already complained by %d", dealer, complainer)
+		}
+		if _, ok := dealing.Shares[int(complainer)]; !ok {
+			return 0, 0, fmt.Errorf("dealing %d has no share for %d", dealer, complainer)
+		}
+	case TxTypeAnswerProcessKeysComplaint:
+		if complaint == nil || complaint.Share != nil {
+			return 0, 0, fmt.Errorf("no pending complaint of %d against dealing %d", complainer, dealer)
+		}
+		if err := dkg.VerifyShare([]*dkg.Dealing{dealing}, int(complainer),
+			tx.EncryptionPrivateKey); err != nil {
+			return 0, 0, err
+		}
+	}
+	return dealer, complainer, nil
+}
+
+// ProcessKeysComplaint records a complaint or an answer transaction signed by
+// addr, checked by checkProcessKeysComplaint.
+func (v *State) ProcessKeysComplaint(tx *models.AdminTx, addr common.Address) error {
+	process, err := v.Process(tx.ProcessId, false)
+	if err != nil {
+		return err
+	}
+	dealer, complainer, err := checkProcessKeysComplaint(v, tx, process, addr)
+	if err != nil {
+		return err
+	}
+	complaints, err := v.ProcessKeyComplaints(tx.ProcessId)
+	if err != nil {
+		return err
+	}
+	if tx.Txtype == TxTypeComplainProcessKeys {
+		log.Infof("key keeper %d complains against the dealing %d of process %x",
+			complainer, dealer, tx.ProcessId)
+		complaints = append(complaints, &ProcessKeyComplaint{Dealer: dealer, Complainer: complainer})
+	} else {
+		log.Infof("key keeper %d answers the complaint of %d on process %x",
+			dealer, complainer, tx.ProcessId)
+		processKeyComplaint(complaints, dealer, complainer).Share = tx.EncryptionPrivateKey
+	}
+	return v.setProcessKeyComplaints(tx.ProcessId, complaints)
+}
+
+// freezeThresholdKeys disqualifies the threshold key dealers with unanswered
+// complaints of the processes starting in the next block, and stores their
+// joint public key on index 0.  The caller must hold the Tx lock.
+func (v *State) freezeThresholdKeys(pids [][]byte) error {
+	mainTreeView := v.mainTreeViewer(false)
+	for _, pid := range pids {
+		process, err := getProcess(mainTreeView, pid)
+		if err != nil {
+			return err
+		}
+		if IsThresholdKeyProcess(process) || process.KeyIndex == nil {
+			continue
+		}
+		dealings, _, err := processKeyDealings(process)
+		if err != nil {
+			return err
+		}
+		if len(dealings) == 0 {
+			continue
+		}
+		complaints, err := v.processKeyComplaints(pid)
+		if err != nil {
+			return err
+		}
+		for _, c := range complaints {
+			if c.Share != nil || len(process.EncryptionPublicKeys[c.Dealer]) == 0 {
+				continue
+			}
+			log.Warnf("threshold key dealing %d of process %x disqualified", c.Dealer, pid)
+			process.EncryptionPublicKeys[c.Dealer] = ""
+			*process.KeyIndex--
+		}
+		if dealings, _, err = processKeyDealings(process); err != nil {
+			return err
+		}
+		if len(dealings) > 0 {
+			pub, err := dkg.PublicKey(dealings)
+			if err != nil {
+				// without keys the votes are rejected
+				log.Warnf("cannot freeze the threshold key of process %x: %v", pid, err)
+				for i := 1; i < len(process.EncryptionPublicKeys); i++ {
+					process.EncryptionPublicKeys[i] = ""
+				}
+				*process.KeyIndex = 0
+			} else {
+				process.EncryptionPublicKeys[0] = fmt.Sprintf("%x", pub)
+				log.Infof("joint encryption key for process %x frozen from %d dealings: %x",
+					pid, len(dealings), pub)
+			}
+		}
+		if err := updateProcess(&v.Tx, process, pid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
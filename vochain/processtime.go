@@ -0,0 +1,277 @@
+package vochain
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// A time-based process declares its start and end timestamps instead of a
+// start block and a block count.  The application opens and closes it by
+// comparing them against the block header time, so the organizers do not
+// need to estimate the block heights.  Its StartBlock and BlockCount are kept
+// as estimations: the start block is moved to the next block once the start
+// time is reached, and the block count is extended while the end time is not
+// reached, so the height based checks of the process remain valid.  Once the
+// end time is reached, the block count is fixed and the process is ended.
+//
+// The timestamps are not part of the models yet, so they are kept as unknown
+// fields of the Process message.
+
+const (
+	// processStartTimeField and processEndTimeField are the protobuf field
+	// numbers of the start and end unix timestamps in the Process message.
+	processStartTimeField = 34
+	processEndTimeField   = 35
+
+	// TimeBasedProcessBlockTime is the block time used to estimate the start
+	// block and block count of the time-based processes.
+	TimeBasedProcessBlockTime = 10 * time.Second
+
+	// pathTimeBasedProcessIDs is the db path used to store the ProcessIDs of
+	// the time-based processes that are not yet ended.
+	pathTimeBasedProcessIDs = "timeBasedPids"
+)
+
+// ProcessStartTime returns the start time of a time-based process, or the
+// zero time if the process is scheduled by block height.
+func ProcessStartTime(p *models.Process) time.Time {
+	return processTimeField(p, processStartTimeField)
+}
+
+// ProcessEndTime returns the end time of a time-based process, or the zero
+// time if the process is scheduled by block height.
+func ProcessEndTime(p *models.Process) time.Time {
+	return processTimeField(p, processEndTimeField)
+}
+
+// IsTimeBasedProcess returns true if the process is opened and closed by the
+// block time instead of the block height, that is, if it declares any of its
+// start or end times.
+func IsTimeBasedProcess(p *models.Process) bool {
+	return !ProcessStartTime(p).IsZero() || !ProcessEndTime(p).IsZero()
+}
+
+// SetProcessTimes sets the start and end times of the process.  A zero start
+// time starts the process on the next block.  Zero start and end times make
+// the process scheduled by block height.
+func SetProcessTimes(p *models.Process, start, end time.Time) {
+	p.ProtoReflect().SetUnknown(withoutUnknownField(p, processStartTimeField))
+	b := withoutUnknownField(p, processEndTimeField)
+	if !start.IsZero() {
+		b = protowire.AppendTag(b, processStartTimeField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(start.Unix()))
+	}
+	if !end.IsZero() {
+		b = protowire.AppendTag(b, processEndTimeField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(end.Unix()))
+	}
+	p.ProtoReflect().SetUnknown(b)
+}
+
+func processTimeField(p *models.Process, field protowire.Number) time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	var t time.Time
+	_ = walkUnknownFields(p, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != field || typ != protowire.VarintType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		v, n := protowire.ConsumeVarint(b)
+		if v > 0 {
+			t = time.Unix(int64(v), 0)
+		}
+		return n, nil
+	})
+	return t
+}
+
+// EstimateBlockCount returns the number of blocks, at least one, expected to
+// be produced during d.
+func EstimateBlockCount(d time.Duration) uint32 {
+	if d <= TimeBasedProcessBlockTime {
+		return 1
+	}
+	return uint32((d + TimeBasedProcessBlockTime - 1) / TimeBasedProcessBlockTime)
+}
+
+// checkProcessTimes validates the timestamps of a new time-based process and
+// sets its estimated start block and block count.  height and blockTime are
+// the height and time of the current block.
+func checkProcessTimes(p *models.Process, height uint32, blockTime time.Time) error {
+	start, end := ProcessStartTime(p), ProcessEndTime(p)
+	if end.IsZero() {
+		return fmt.Errorf("cannot add process with start time %s but without end time",
+			start.UTC())
+	}
+	if start.IsZero() {
+		// the process starts on the next block
+		start = blockTime
+		SetProcessTimes(p, start, end)
+	} else if start.Before(blockTime) {
+		return fmt.Errorf("cannot add process with start time %s before the current block time %s",
+			start.UTC(), blockTime.UTC())
+	}
+	if !end.After(start) {
+		return fmt.Errorf("cannot add process with end time %s before its start time %s",
+			end.UTC(), start.UTC())
+	}
+	p.StartBlock = height + 1
+	if start.After(blockTime) {
+		p.StartBlock += EstimateBlockCount(start.Sub(blockTime))
+	}
+	p.BlockCount = EstimateBlockCount(end.Sub(start))
+	return nil
+}
+
+// timeBasedProcessIDs returns the ProcessIDs of the time-based processes not
+// yet ended.  The caller must hold the Tx lock.
+func (v *State) timeBasedProcessIDs() ([][]byte, error) {
+	pidsBytes, err := v.Tx.NoState().Get([]byte(pathTimeBasedProcessIDs))
+	if err == db.ErrKeyNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var pids models.ProcessIdList
+	if err := proto.Unmarshal(pidsBytes, &pids); err != nil {
+		return nil, fmt.Errorf("cannot proto.Unmarshal pids: %w", err)
+	}
+	return pids.ProcessIds, nil
+}
+
+// setTimeBasedProcessIDs stores the ProcessIDs of the time-based processes
+// not yet ended.  The caller must hold the Tx lock.
+func (v *State) setTimeBasedProcessIDs(pids [][]byte) error {
+	pidsBytes, err := proto.Marshal(&models.ProcessIdList{ProcessIds: pids})
+	if err != nil {
+		return err
+	}
+	return v.Tx.NoState().Set([]byte(pathTimeBasedProcessIDs), pidsBytes)
+}
+
+// addTimeBasedProcessID schedules a time-based process.  The caller must hold
+// the Tx lock.
+func (v *State) addTimeBasedProcessID(pid []byte) error {
+	pids, err := v.timeBasedProcessIDs()
+	if err != nil {
+		return err
+	}
+	return v.setTimeBasedProcessIDs(append(pids, pid))
+}
+
+// removeProcessIDByStartBlock removes the processID from the index of the
+// processes by startBlock.  The caller must hold the Tx lock.
+func (v *State) removeProcessIDByStartBlock(processID []byte, startBlock uint32) error {
+	pids, err := v.processIDsByStartBlock(startBlock)
+	if err != nil {
+		return err
+	}
+	var kept [][]byte
+	for _, pid := range pids {
+		if !bytes.Equal(pid, processID) {
+			kept = append(kept, pid)
+		}
+	}
+	pidsBytes, err := proto.Marshal(&models.ProcessIdList{ProcessIds: kept})
+	if err != nil {
+		return err
+	}
+	return v.Tx.NoState().Set(keyProcessIDsByStartBlock(startBlock), pidsBytes)
+}
+
+// UpdateTimeBasedProcesses opens and closes the time-based processes at the
+// end of the block at height, whose header time is blockTime.  A process whose
+// start time is reached starts on the next block, and a process whose end
+// time is reached is ended, notifying the listeners of the status change.
+func (v *State) UpdateTimeBasedProcesses(height uint32, blockTime time.Time) error {
+	v.Tx.RLock()
+	pids, err := v.timeBasedProcessIDs()
+	v.Tx.RUnlock()
+	if err != nil || len(pids) == 0 {
+		return err
+	}
+	var scheduled, ended [][]byte
+	for _, pid := range pids {
+		p, err := v.Process(pid, false)
+		if err != nil {
+			return fmt.Errorf("cannot get time-based process %x: %w", pid, err)
+		}
+		switch p.Status {
+		case models.ProcessStatus_ENDED, models.ProcessStatus_CANCELED, models.ProcessStatus_RESULTS:
+			// ended by its owner or canceled
+			continue
+		}
+		startBlock, blockCount := p.StartBlock, p.BlockCount
+		start, end := ProcessStartTime(p), ProcessEndTime(p)
+		switch {
+		case p.StartBlock > height:
+			// not started yet, keep the start block after the next block
+			// until the start time is reached
+			if !start.After(blockTime) {
+				p.StartBlock = height + 1
+				p.BlockCount = EstimateBlockCount(end.Sub(blockTime))
+			} else if p.StartBlock == height+1 {
+				p.StartBlock = height + 1 + EstimateBlockCount(start.Sub(blockTime))
+				p.BlockCount = EstimateBlockCount(end.Sub(start))
+			}
+		case !end.After(blockTime):
+			// the last votes are the ones of this block, and at least the
+			// start block is kept open
+			p.BlockCount = 1
+			if height > p.StartBlock {
+				p.BlockCount = height - p.StartBlock
+			}
+			p.Status = models.ProcessStatus_ENDED
+			ended = append(ended, pid)
+		case p.StartBlock+p.BlockCount <= height:
+			// still open, keep the end block after the next block
+			p.BlockCount = height + 1 - p.StartBlock + EstimateBlockCount(end.Sub(blockTime))
+		}
+		if p.Status != models.ProcessStatus_ENDED {
+			scheduled = append(scheduled, pid)
+		}
+		if p.StartBlock == startBlock && p.BlockCount == blockCount &&
+			p.Status != models.ProcessStatus_ENDED {
+			continue
+		}
+		v.Tx.Lock()
+		err = func() error {
+			if p.StartBlock != startBlock {
+				if err := v.removeProcessIDByStartBlock(pid, startBlock); err != nil {
+					return err
+				}
+				if err := v.setProcessIDByStartBlock(pid, p.StartBlock); err != nil {
+					return err
+				}
+			}
+			return updateProcess(&v.Tx, p, pid)
+		}()
+		v.Tx.Unlock()
+		if err != nil {
+			return fmt.Errorf("cannot update time-based process %x: %w", pid, err)
+		}
+		log.Debugf("time-based process %x scheduled on blocks %d to %d, status %s",
+			pid, p.StartBlock, p.StartBlock+p.BlockCount, p.Status)
+	}
+	v.Tx.Lock()
+	err = v.setTimeBasedProcessIDs(scheduled)
+	v.Tx.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, pid := range ended {
+		log.Infof("time-based process %x ended on block %d", pid, height)
+		for _, l := range v.eventListeners {
+			l.OnProcessStatusChange(pid, models.ProcessStatus_ENDED, v.TxCounter())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package vochain
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTimeBasedProcess(t *testing.T) {
+	app := TestBaseApplication(t)
+	signer := ethereum.SignKeys{}
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	app.State.SetAccount(BurnAddress, &Account{})
+	qt.Assert(t, app.State.SetTxCost(models.TxType_NEW_PROCESS, 10), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(signer.Address(), "", nil, 0), qt.IsNil)
+	qt.Assert(t, app.State.MintBalance(signer.Address(), 100), qt.IsNil)
+	qt.Assert(t, app.State.AddOracle(signer.Address()), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	now := time.Unix(app.TimestampStartBlock(), 0)
+	newProcess := func(nonce uint32, start, end time.Time) ([]byte, error) {
+		p := &models.Process{
+			EntityId:      signer.Address().Bytes(),
+			VoteOptions:   &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+			EnvelopeType:  &models.EnvelopeType{},
+			Mode:          &models.ProcessMode{AutoStart: true},
+			Status:        models.ProcessStatus_READY,
+			CensusRoot:    util.RandomBytes(32),
+			CensusOrigin:  models.CensusOrigin_OFF_CHAIN_TREE,
+			CensusURI:     new(string),
+			MaxCensusSize: new(uint64),
+		}
+		SetProcessTimes(p, start, end)
+		pid, err := app.BuildProcessID(p)
+		qt.Assert(t, err, qt.IsNil)
+		stx := &models.SignedTx{}
+		stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_NewProcess{
+			NewProcess: &models.NewProcessTx{
+				Txtype:  models.TxType_NEW_PROCESS,
+				Nonce:   nonce,
+				Process: p,
+			}}})
+		qt.Assert(t, err, qt.IsNil)
+		return pid.Marshal(), sendTx(app, &signer, stx)
+	}
+
+	// the start time cannot be in the past, nor the end time before the start
+	_, err := newProcess(0, now.Add(-time.Minute), now.Add(time.Minute))
+	qt.Assert(t, err, qt.ErrorMatches, ".*before the current block time.*")
+	_, err = newProcess(0, now.Add(time.Minute), now.Add(30*time.Second))
+	qt.Assert(t, err, qt.ErrorMatches, ".*before its start time.*")
+	// nor a start time declared without an end time
+	_, err = newProcess(0, now.Add(time.Minute), time.Time{})
+	qt.Assert(t, err, qt.ErrorMatches, ".*without end time.*")
+
+	// a process scheduled 30 seconds ahead, open for a minute
+	start, end := now.Add(30*time.Second), now.Add(90*time.Second)
+	pid, err := newProcess(0, start, end)
+	qt.Assert(t, err, qt.IsNil)
+	app.AdvanceTestBlock()
+
+	p, err := app.State.Process(pid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, IsTimeBasedProcess(p), qt.IsTrue)
+	qt.Assert(t, ProcessStartTime(p).Equal(start), qt.IsTrue)
+	qt.Assert(t, ProcessEndTime(p).Equal(end), qt.IsTrue)
+	qt.Assert(t, p.StartBlock > app.State.CurrentHeight(), qt.IsTrue)
+
+	// each block spans 12 seconds, the process starts on the block following
+	// the first block whose time reaches the start time
+	blockTime := func() time.Time { return time.Unix(app.TimestampStartBlock(), 0) }
+	for blockTime().Before(start) {
+		app.AdvanceTestBlock()
+	}
+	app.AdvanceTestBlock()
+	p, err = app.State.Process(pid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, p.StartBlock, qt.Equals, app.State.CurrentHeight())
+	qt.Assert(t, p.Status, qt.Equals, models.ProcessStatus_READY)
+
+	// the block count is extended until the end time is reached
+	for blockTime().Before(end) {
+		p, err = app.State.Process(pid, true)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, p.Status, qt.Equals, models.ProcessStatus_READY)
+		qt.Assert(t, p.StartBlock+p.BlockCount > app.State.CurrentHeight(), qt.IsTrue)
+		app.AdvanceTestBlock()
+	}
+	app.AdvanceTestBlock()
+	p, err = app.State.Process(pid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, p.Status, qt.Equals, models.ProcessStatus_ENDED)
+	qt.Assert(t, p.StartBlock+p.BlockCount, qt.Equals, app.State.CurrentHeight()-1)
+
+	// the ended process is not scheduled anymore
+	app.State.Tx.RLock()
+	pids, err := app.State.timeBasedProcessIDs()
+	app.State.Tx.RUnlock()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pids, qt.HasLen, 0)
+
+	// a process ending on its start block is kept open for that block
+	now = blockTime()
+	pid, err = newProcess(1, now.Add(time.Second), now.Add(2*time.Second))
+	qt.Assert(t, err, qt.IsNil)
+	for i := 0; i < 3; i++ {
+		app.AdvanceTestBlock()
+	}
+	p, err = app.State.Process(pid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, p.Status, qt.Equals, models.ProcessStatus_ENDED)
+	qt.Assert(t, p.BlockCount, qt.Equals, uint32(1))
+}
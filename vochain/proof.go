@@ -49,6 +49,8 @@ func VerifyProof(process *models.Process, proof *models.Proof,
 		verifyProof = VerifyProofERC20
 	case models.CensusOrigin_MINI_ME:
 		verifyProof = VerifyProofMiniMe
+	case CensusOriginVochainAccounts, CensusOriginVochainAccountsWeighted:
+		verifyProof = VerifyProofVochainAccount
 	default:
 		return false, nil, fmt.Errorf("census origin not compatible")
 	}
@@ -103,6 +105,39 @@ func VerifyProofOffChainTree(process *models.Process, proof *models.Proof,
 	}
 }
 
+// VerifyProofVochainAccount verifies a proof with a census origin backed by the
+// Vochain accounts.  The proof is an arbo proof of the account leaf of addr in
+// the Accounts tree snapshotted at the process start, whose root is the process
+// censusRoot.  Returns verification result and weight.
+func VerifyProofVochainAccount(process *models.Process, proof *models.Proof,
+	censusOrigin models.CensusOrigin,
+	censusRoot, processID, pubKey []byte, addr ethcommon.Address) (bool, *big.Int, error) {
+	if len(censusRoot) == 0 {
+		return false, nil, fmt.Errorf("accounts census not yet available")
+	}
+	p := proof.GetArbo()
+	if p == nil {
+		return false, nil, fmt.Errorf("arbo proof is empty")
+	}
+	accountsCfg := StateTreeCfg(TreeAccounts)
+	valid, err := tree.VerifyProof(accountsCfg.HashFunc(),
+		addr.Bytes(), p.Value, p.Siblings, censusRoot)
+	if err != nil || !valid {
+		return false, nil, err
+	}
+	var acc Account
+	if err := acc.Unmarshal(p.Value); err != nil {
+		return false, nil, fmt.Errorf("cannot unmarshal account: %w", err)
+	}
+	if censusOrigin != CensusOriginVochainAccountsWeighted {
+		return true, bigOne, nil
+	}
+	if acc.Balance == 0 {
+		return false, nil, fmt.Errorf("account %s has no balance", addr.Hex())
+	}
+	return true, new(big.Int).SetUint64(acc.Balance), nil
+}
+
 // VerifyProofOffChainCA verifies a proof with census origin OFF_CHAIN_CA.
 // Returns verification result and weight.
 func VerifyProofOffChainCSP(process *models.Process, proof *models.Proof,
@@ -0,0 +1,565 @@
+package vochain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+	"github.com/vocdoni/arbo"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/metadb"
+	"go.vocdoni.io/dvote/statedb"
+	"go.vocdoni.io/dvote/tree"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// Paths served by BaseApplication.Query.  The path arguments are hex encoded.
+const (
+	QueryPathProcess = "process" // /process/{pid}
+	QueryPathAccount = "account" // /account/{addr}
+	QueryPathVote    = "vote"    // /vote/{pid}/{nullifier}
+	QueryPathOracles = "oracles" // /oracles
+	QueryPathTxCosts = "txcosts" // /txcosts
+)
+
+// Response codes returned by BaseApplication.Query.
+const (
+	QueryCodeOK       = 0
+	QueryCodeInvalid  = 1
+	QueryCodeNotFound = 2
+)
+
+// ProofOpTypeArbo is the tendermint ProofOp type used for the arbo inclusion
+// proofs of the state merkle trees.
+const ProofOpTypeArbo = "vochain:arbo"
+
+// treeMain is the name used in the proofs for the StateDB mainTree.
+const treeMain = "Main"
+
+var errQueryNotFound = errors.New("not found")
+
+// stateProofData is the content of the ProofOp.Data field of a ProofOpTypeArbo
+// proof.  The ProofOp.Key contains the key of the leaf in the tree.
+type stateProofData struct {
+	// Tree is the name of the tree containing the leaf.
+	Tree string `json:"tree"`
+	// ParentKey is the key of the leaf in the parent tree holding the root
+	// of Tree.  Only set for child trees (as the Votes tree of a process).
+	ParentKey []byte `json:"parentKey,omitempty"`
+	Value     []byte `json:"value"`
+	Siblings  []byte `json:"siblings"`
+}
+
+// StateProofLeaf is a key-value from a state tree whose inclusion has been
+// verified by VerifyStateProof.
+type StateProofLeaf struct {
+	Tree      string
+	ParentKey []byte
+	Key       []byte
+	Value     []byte
+}
+
+// proofStep identifies a subTree in the path from the mainTree to a leaf.
+type proofStep struct {
+	name string
+	cfg  statedb.TreeConfig
+	// key is the key of the leaf in the parent tree holding the root.
+	key []byte
+	// child is true if the tree is a child tree (keyed by its parent leaf).
+	child bool
+}
+
+func mainTreeStep(name string) proofStep {
+	cfg := StateTreeCfg(name)
+	return proofStep{name: name, cfg: cfg, key: cfg.Key()}
+}
+
+func childTreeStep(name string, key []byte) proofStep {
+	return proofStep{name: name, cfg: StateChildTreeCfg(name).WithKey(key), key: key, child: true}
+}
+
+// newProofOp builds a ProofOpTypeArbo tendermint ProofOp.
+func newProofOp(treeName string, parentKey, key, value, siblings []byte) (tmcrypto.ProofOp, error) {
+	data, err := json.Marshal(stateProofData{
+		Tree:      treeName,
+		ParentKey: parentKey,
+		Value:     value,
+		Siblings:  siblings,
+	})
+	if err != nil {
+		return tmcrypto.ProofOp{}, err
+	}
+	return tmcrypto.ProofOp{Type: ProofOpTypeArbo, Key: key, Data: data}, nil
+}
+
+// proveParents returns the proof ops of the leafs holding the roots of the
+// trees opened following steps, from the deepest one up to the mainTree.
+// trees must contain the mainTree followed by each opened subTree.
+func proveParents(trees []statedb.TreeViewer, steps []proofStep) ([]tmcrypto.ProofOp, error) {
+	var ops []tmcrypto.ProofOp
+	for i := len(steps) - 1; i >= 0; i-- {
+		value, siblings, err := trees[i].GenProof(steps[i].key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate proof for %s: %w", steps[i].name, err)
+		}
+		name, parentKey := treeMain, []byte(nil)
+		if i > 0 {
+			name = steps[i-1].name
+			if steps[i-1].child {
+				parentKey = steps[i-1].key
+			}
+		}
+		op, err := newProofOp(name, parentKey, steps[i].key, value, siblings)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// openTrees opens the subTrees defined by steps, returning the mainTree followed
+// by each of them.
+func openTrees(mainTree statedb.TreeViewer, steps ...proofStep) ([]statedb.TreeViewer, error) {
+	trees := []statedb.TreeViewer{mainTree}
+	for _, step := range steps {
+		t, err := trees[len(trees)-1].SubTree(step.cfg)
+		if errors.Is(err, arbo.ErrKeyNotFound) {
+			return nil, errQueryNotFound
+		} else if err != nil {
+			return nil, fmt.Errorf("cannot open tree %s: %w", step.name, err)
+		}
+		trees = append(trees, t)
+	}
+	return trees, nil
+}
+
+// proveKey returns the value of key found in the tree defined by steps,
+// together with the proof ops from the leaf up to the mainTree root.
+func proveKey(mainTree statedb.TreeViewer, key []byte, steps ...proofStep) ([]byte, []tmcrypto.ProofOp, error) {
+	trees, err := openTrees(mainTree, steps...)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, siblings, err := trees[len(trees)-1].GenProof(key)
+	if err != nil {
+		return nil, nil, errQueryNotFound
+	}
+	last := steps[len(steps)-1]
+	var parentKey []byte
+	if last.child {
+		parentKey = last.key
+	}
+	op, err := newProofOp(last.name, parentKey, key, value, siblings)
+	if err != nil {
+		return nil, nil, err
+	}
+	parentOps, err := proveParents(trees, steps)
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, append([]tmcrypto.ProofOp{op}, parentOps...), nil
+}
+
+// proveLeafs returns all the leafs of the main subTree name, together with the
+// proof ops of each one of them followed by the proof ops up to the mainTree
+// root.  Leafs with an empty value are skipped.
+func proveLeafs(mainTree statedb.TreeViewer, name string) ([][]byte, [][]byte, []tmcrypto.ProofOp, error) {
+	step := mainTreeStep(name)
+	trees, err := openTrees(mainTree, step)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var keys, values [][]byte
+	if err := trees[1].Iterate(func(key, value []byte) bool {
+		if len(value) == 0 {
+			return false
+		}
+		keys = append(keys, append([]byte{}, key...))
+		values = append(values, append([]byte{}, value...))
+		return false
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+	var ops []tmcrypto.ProofOp
+	for _, key := range keys {
+		value, siblings, err := trees[1].GenProof(key)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		op, err := newProofOp(name, nil, key, value, siblings)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		ops = append(ops, op)
+	}
+	parentOps, err := proveParents(trees, []proofStep{step})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return keys, values, append(ops, parentOps...), nil
+}
+
+// ProcessResultsProof returns the proof ops of the StateDBProcess leaf of a
+// process, which holds its results and the votes tree root, followed by the
+// proof ops of the oracle list.  The proofs are built on the state committed
+// at height, so they can be checked with VerifyStateProof against the AppHash
+// of the block at height+1.
+func (v *State) ProcessResultsProof(pid []byte, height uint32) ([]tmcrypto.ProofOp, error) {
+	mainTree, err := v.mainTreeViewAt(height)
+	if err != nil {
+		return nil, err
+	}
+	_, ops, err := proveKey(mainTree, pid, mainTreeStep(TreeProcess))
+	if errors.Is(err, errQueryNotFound) {
+		return nil, ErrProcessNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	_, _, oracleOps, err := proveLeafs(mainTree, TreeOracles)
+	if err != nil {
+		return nil, fmt.Errorf("cannot prove oracles: %w", err)
+	}
+	return append(ops, oracleOps...), nil
+}
+
+// ProcessVoteLeafs returns all the leafs of the votes tree of a process, as
+// committed at height.  They rebuild the votes tree root with VotesTreeRoot,
+// which proves the number of votes of the process.
+func (v *State) ProcessVoteLeafs(pid []byte, height uint32) ([][]byte, [][]byte, error) {
+	mainTree, err := v.mainTreeViewAt(height)
+	if err != nil {
+		return nil, nil, err
+	}
+	votesTree, err := mainTree.DeepSubTree(StateTreeCfg(TreeProcess),
+		StateChildTreeCfg(ChildTreeVotes).WithKey(pid))
+	if errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, nil, ErrProcessNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+	var keys, values [][]byte
+	if err := votesTree.Iterate(func(key, value []byte) bool {
+		keys = append(keys, append([]byte{}, key...))
+		values = append(values, append([]byte{}, value...))
+		return false
+	}); err != nil {
+		return nil, nil, err
+	}
+	return keys, values, nil
+}
+
+// VotesTreeRoot returns the root of the votes tree of a process holding the
+// given leafs.  The tree is built on a temporary database, so it can be used
+// to verify the proofs offline.
+func VotesTreeRoot(keys, values [][]byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "votes")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	database, err := metadb.New(db.TypePebble, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer database.Close()
+	cfg := StateChildTreeCfg(ChildTreeVotes)
+	votesTree, err := tree.New(nil, tree.Options{
+		DB:        database,
+		MaxLevels: cfg.MaxLevels(),
+		HashFunc:  cfg.HashFunc(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalid, err := votesTree.AddBatch(nil, keys, values)
+	if err != nil {
+		return nil, err
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("cannot add %d vote leafs to the tree", len(invalid))
+	}
+	return votesTree.Root(nil)
+}
+
+// VoteProof returns the proof ops of the StateDBVote leaf of a vote, followed
+// by the proof ops of the process leaf holding the votes tree root up to the
+// mainTree.  The proofs are built on the state committed at height, so they
+// can be checked with VerifyStateProof against the AppHash of the block at
+// height+1.
+func (v *State) VoteProof(pid, nullifier []byte, height uint32) ([]tmcrypto.ProofOp, error) {
+	vid, err := VoteID(pid, nullifier)
+	if err != nil {
+		return nil, err
+	}
+	mainTree, err := v.mainTreeViewAt(height)
+	if err != nil {
+		return nil, err
+	}
+	votesTree, err := mainTree.DeepSubTree(StateTreeCfg(TreeProcess),
+		StateChildTreeCfg(ChildTreeVotes).WithKey(pid))
+	if errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, ErrProcessNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := votesTree.Get(vid); errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, ErrVoteDoesNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	value, siblings, err := votesTree.GenProof(vid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate proof for vote %x: %w", vid, err)
+	}
+	op, err := newProofOp(ChildTreeVotes, pid, vid, value, siblings)
+	if err != nil {
+		return nil, err
+	}
+	_, processOps, err := proveKey(mainTree, pid, mainTreeStep(TreeProcess))
+	if err != nil {
+		return nil, err
+	}
+	return append([]tmcrypto.ProofOp{op}, processOps...), nil
+}
+
+// queryHandler resolves a query path (without the route name) against the
+// mainTree, returning the key and value found and the proof ops.
+type queryHandler func(v *State, mainTree statedb.TreeViewer, args []string) (key, value []byte,
+	ops []tmcrypto.ProofOp, err error)
+
+// queryHandlers maps the first element of a query path to its handler.
+var queryHandlers = map[string]queryHandler{
+	QueryPathProcess: (*State).queryProcess,
+	QueryPathAccount: (*State).queryAccount,
+	QueryPathVote:    (*State).queryVote,
+	QueryPathOracles: (*State).queryOracles,
+	QueryPathTxCosts: (*State).queryTxCosts,
+}
+
+// decodeQueryArgs decodes the hex encoded query path arguments, which must be
+// exactly n.
+func decodeQueryArgs(args []string, n int) ([][]byte, error) {
+	if len(args) != n {
+		return nil, fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+	decoded := make([][]byte, n)
+	for i, arg := range args {
+		b, err := hex.DecodeString(strings.TrimPrefix(arg, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode argument %d: %w", i, err)
+		}
+		decoded[i] = b
+	}
+	return decoded, nil
+}
+
+// queryProcess returns the StateDBProcess leaf of a process.
+func (v *State) queryProcess(mainTree statedb.TreeViewer, args []string) ([]byte, []byte, []tmcrypto.ProofOp, error) {
+	decoded, err := decodeQueryArgs(args, 1)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pid := decoded[0]
+	value, ops, err := proveKey(mainTree, pid, mainTreeStep(TreeProcess))
+	return pid, value, ops, err
+}
+
+// queryAccount returns the Account leaf of an address.
+func (v *State) queryAccount(mainTree statedb.TreeViewer, args []string) ([]byte, []byte, []tmcrypto.ProofOp, error) {
+	decoded, err := decodeQueryArgs(args, 1)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(decoded[0]) != common.AddressLength {
+		return nil, nil, nil, fmt.Errorf("invalid address length")
+	}
+	addr := decoded[0]
+	value, ops, err := proveKey(mainTree, addr, mainTreeStep(TreeAccounts))
+	return addr, value, ops, err
+}
+
+// queryVote returns the StateDBVote leaf of a vote.
+func (v *State) queryVote(mainTree statedb.TreeViewer, args []string) ([]byte, []byte, []tmcrypto.ProofOp, error) {
+	decoded, err := decodeQueryArgs(args, 2)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pid, nullifier := decoded[0], decoded[1]
+	vid, err := VoteID(pid, nullifier)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	value, ops, err := proveKey(mainTree, vid,
+		mainTreeStep(TreeProcess), childTreeStep(ChildTreeVotes, pid))
+	return vid, value, ops, err
+}
+
+// queryOracles returns the list of oracles encoded as a models.OracleList.
+func (v *State) queryOracles(mainTree statedb.TreeViewer, args []string) ([]byte, []byte, []tmcrypto.ProofOp, error) {
+	if len(args) != 0 {
+		return nil, nil, nil, fmt.Errorf("unexpected arguments")
+	}
+	keys, _, ops, err := proveLeafs(mainTree, TreeOracles)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	value, err := proto.Marshal(&models.OracleList{Oracles: keys})
+	return nil, value, ops, err
+}
+
+// queryTxCosts returns the transaction costs as a JSON map of the
+// models.TxType name to its cost.
+func (v *State) queryTxCosts(mainTree statedb.TreeViewer, args []string) ([]byte, []byte, []tmcrypto.ProofOp, error) {
+	if len(args) != 0 {
+		return nil, nil, nil, fmt.Errorf("unexpected arguments")
+	}
+	keys, values, ops, err := proveLeafs(mainTree, TreeExtra)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// the Extra tree holds other values than the tx costs, so only
+	// the known cost keys are kept.
+	costs := make(map[string]uint64)
+	var costOps []tmcrypto.ProofOp
+	for i, key := range keys {
+		for txType, costKey := range TxTypeCostToStateKey {
+			if string(key) == costKey && len(values[i]) == 8 {
+				costs[txType.String()] = binary.LittleEndian.Uint64(values[i])
+				costOps = append(costOps, ops[i])
+			}
+		}
+	}
+	// the last op is the proof of the Extra tree root in the mainTree
+	costOps = append(costOps, ops[len(keys):]...)
+	value, err := json.Marshal(costs)
+	return nil, value, costOps, err
+}
+
+// Query resolves a state lookup.  The path selects the data (see the QueryPath
+// constants) and req.Height the committed version to read from (0 means the
+// last one).  If req.Prove is set, the response contains the arbo inclusion
+// proofs from the leafs up to the StateDB root of that version, which can be
+// checked with VerifyStateProof against the AppHash of the block at Height+1.
+func (app *BaseApplication) Query(req abcitypes.RequestQuery) abcitypes.ResponseQuery {
+	height := uint32(req.Height)
+	if height == 0 {
+		lastHeight, err := app.State.LastHeight()
+		if err != nil {
+			return abcitypes.ResponseQuery{Code: QueryCodeInvalid, Log: err.Error()}
+		}
+		height = lastHeight
+	}
+	path := strings.Split(strings.Trim(req.Path, "/"), "/")
+	handler, ok := queryHandlers[path[0]]
+	if !ok {
+		return abcitypes.ResponseQuery{
+			Code:   QueryCodeInvalid,
+			Log:    fmt.Sprintf("unknown query path %s", req.Path),
+			Height: int64(height),
+		}
+	}
+	mainTree, err := app.State.mainTreeViewAt(height)
+	if err != nil {
+		return abcitypes.ResponseQuery{Code: QueryCodeInvalid, Log: err.Error(), Height: int64(height)}
+	}
+	key, value, ops, err := handler(app.State, mainTree, path[1:])
+	if errors.Is(err, errQueryNotFound) {
+		return abcitypes.ResponseQuery{Code: QueryCodeNotFound, Log: err.Error(), Height: int64(height)}
+	} else if err != nil {
+		return abcitypes.ResponseQuery{Code: QueryCodeInvalid, Log: err.Error(), Height: int64(height)}
+	}
+	resp := abcitypes.ResponseQuery{
+		Code:   QueryCodeOK,
+		Key:    key,
+		Value:  value,
+		Height: int64(height),
+	}
+	if req.Prove {
+		resp.ProofOps = &tmcrypto.ProofOps{Ops: ops}
+	}
+	return resp
+}
+
+// treeHashFunction returns the hash function used by the state tree name.
+func treeHashFunction(name string) (arbo.HashFunction, error) {
+	if name == treeMain {
+		return arbo.HashFunctionSha256, nil
+	}
+	if cfg, ok := MainTrees[name]; ok {
+		return cfg.HashFunc(), nil
+	}
+	if cfg, ok := ChildTrees[name]; ok {
+		return cfg.HashFunc(), nil
+	}
+	return nil, fmt.Errorf("unknown tree %s", name)
+}
+
+// VerifyStateProof verifies the proof ops returned by BaseApplication.Query
+// against the StateDB root (the block AppHash).  The ops are checked from the
+// last one (which must belong to the mainTree) to the first one, so each leaf
+// is verified against a root already proven.  The verified leafs are returned
+// in the same order as the ops.
+func VerifyStateProof(ops []tmcrypto.ProofOp, root []byte) ([]StateProofLeaf, error) {
+	// roots indexed by tree name and parent key
+	roots := make(map[string][]byte)
+	rootsKey := func(name string, parentKey []byte) string {
+		return name + "/" + string(parentKey)
+	}
+	roots[rootsKey(treeMain, nil)] = root
+	leafs := make([]StateProofLeaf, len(ops))
+	for i := len(ops) - 1; i >= 0; i-- {
+		op := ops[i]
+		if op.Type != ProofOpTypeArbo {
+			return nil, fmt.Errorf("unexpected proof op type %s", op.Type)
+		}
+		var data stateProofData
+		if err := json.Unmarshal(op.Data, &data); err != nil {
+			return nil, fmt.Errorf("cannot decode proof op %d: %w", i, err)
+		}
+		treeRoot, ok := roots[rootsKey(data.Tree, data.ParentKey)]
+		if !ok {
+			return nil, fmt.Errorf("root of tree %s not proven", data.Tree)
+		}
+		hashFunc, err := treeHashFunction(data.Tree)
+		if err != nil {
+			return nil, err
+		}
+		valid, err := tree.VerifyProof(hashFunc, op.Key, data.Value, data.Siblings, treeRoot)
+		if err != nil {
+			return nil, fmt.Errorf("cannot verify proof op %d: %w", i, err)
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid proof for key %x in tree %s", op.Key, data.Tree)
+		}
+		// register the roots of the trees hanging from this leaf
+		switch data.Tree {
+		case treeMain:
+			for name, cfg := range MainTrees {
+				if bytes.Equal(cfg.Key(), op.Key) {
+					roots[rootsKey(name, nil)] = data.Value
+				}
+			}
+		case TreeProcess:
+			if votesRoot, err := processGetVotesRoot(data.Value); err == nil {
+				roots[rootsKey(ChildTreeVotes, op.Key)] = votesRoot
+			}
+		}
+		leafs[i] = StateProofLeaf{
+			Tree:      data.Tree,
+			ParentKey: data.ParentKey,
+			Key:       op.Key,
+			Value:     data.Value,
+		}
+	}
+	return leafs, nil
+}
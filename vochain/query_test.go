@@ -0,0 +1,145 @@
+package vochain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"go.vocdoni.io/dvote/test/testcommon/testutil"
+	"go.vocdoni.io/dvote/types"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestQueryProofs(t *testing.T) {
+	rng := testutil.NewRandom(0)
+	app := TestBaseApplication(t)
+
+	oracles := []common.Address{
+		common.BytesToAddress(rng.RandomBytes(20)),
+		common.BytesToAddress(rng.RandomBytes(20)),
+	}
+	for _, oracle := range oracles {
+		qt.Assert(t, app.State.AddOracle(oracle), qt.IsNil)
+	}
+	account := common.BytesToAddress(rng.RandomBytes(20))
+	qt.Assert(t, app.State.CreateAccount(account, "ipfs://account", nil, 100), qt.IsNil)
+	qt.Assert(t, app.State.SetTxCost(models.TxType_NEW_PROCESS, 10), qt.IsNil)
+	qt.Assert(t, app.State.SetTxCost(models.TxType_SET_ACCOUNT_INFO, 20), qt.IsNil)
+
+	pid := rng.RandomBytes(32)
+	censusURI := "ipfs://foobar"
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		EntityId:     rng.RandomBytes(20),
+		CensusURI:    &censusURI,
+		ProcessId:    pid,
+		StartBlock:   100,
+		Mode:         &models.ProcessMode{},
+		EnvelopeType: &models.EnvelopeType{},
+	}), qt.IsNil)
+	nullifier := rng.RandomBytes(32)
+	qt.Assert(t, app.State.AddVote(&models.Vote{
+		ProcessId:   pid,
+		Nullifier:   nullifier,
+		VotePackage: []byte("vote"),
+	}, types.VoterID{}.Nil()), qt.IsNil)
+	app.AdvanceTestBlock()
+	appHash, err := app.State.Store.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	height, err := app.State.LastHeight()
+	qt.Assert(t, err, qt.IsNil)
+
+	query := func(path string) abcitypes.ResponseQuery {
+		return app.Query(abcitypes.RequestQuery{Path: path, Prove: true})
+	}
+	verify := func(resp abcitypes.ResponseQuery, leafs int) []StateProofLeaf {
+		qt.Assert(t, resp.Code, qt.Equals, uint32(QueryCodeOK), qt.Commentf("%s", resp.Log))
+		qt.Assert(t, resp.Height, qt.Equals, int64(height))
+		qt.Assert(t, resp.ProofOps, qt.IsNotNil)
+		proven, err := VerifyStateProof(resp.ProofOps.Ops, appHash)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, proven, qt.HasLen, leafs)
+		qt.Assert(t, proven[len(proven)-1].Tree, qt.Equals, treeMain)
+		return proven
+	}
+
+	// process
+	resp := query(fmt.Sprintf("/process/%x", pid))
+	proven := verify(resp, 2)
+	qt.Assert(t, proven[0].Tree, qt.Equals, TreeProcess)
+	qt.Assert(t, proven[0].Value, qt.DeepEquals, resp.Value)
+	var sdbProcess models.StateDBProcess
+	qt.Assert(t, proto.Unmarshal(resp.Value, &sdbProcess), qt.IsNil)
+	qt.Assert(t, sdbProcess.Process.ProcessId, qt.DeepEquals, pid)
+
+	// account
+	resp = query("/account/" + hex.EncodeToString(account.Bytes()))
+	proven = verify(resp, 2)
+	qt.Assert(t, proven[0].Key, qt.DeepEquals, account.Bytes())
+	var acc models.Account
+	qt.Assert(t, proto.Unmarshal(resp.Value, &acc), qt.IsNil)
+	qt.Assert(t, acc.Balance, qt.Equals, uint64(100))
+
+	// vote
+	resp = query(fmt.Sprintf("/vote/%x/%x", pid, nullifier))
+	proven = verify(resp, 3)
+	qt.Assert(t, proven[0].Tree, qt.Equals, ChildTreeVotes)
+	qt.Assert(t, proven[0].ParentKey, qt.DeepEquals, pid)
+	var sdbVote models.StateDBVote
+	qt.Assert(t, proto.Unmarshal(resp.Value, &sdbVote), qt.IsNil)
+	qt.Assert(t, sdbVote.Nullifier, qt.DeepEquals, nullifier)
+
+	// oracles
+	resp = query("/oracles")
+	proven = verify(resp, 3)
+	var oracleList models.OracleList
+	qt.Assert(t, proto.Unmarshal(resp.Value, &oracleList), qt.IsNil)
+	qt.Assert(t, oracleList.Oracles, qt.HasLen, 2)
+
+	// tx costs
+	resp = query("/txcosts")
+	verify(resp, 3)
+	var costs map[string]uint64
+	qt.Assert(t, json.Unmarshal(resp.Value, &costs), qt.IsNil)
+	qt.Assert(t, costs[models.TxType_NEW_PROCESS.String()], qt.Equals, uint64(10))
+	qt.Assert(t, costs[models.TxType_SET_ACCOUNT_INFO.String()], qt.Equals, uint64(20))
+
+	// the proofs are only returned when requested
+	resp = app.Query(abcitypes.RequestQuery{Path: "/account/" + hex.EncodeToString(account.Bytes())})
+	qt.Assert(t, resp.Code, qt.Equals, uint32(QueryCodeOK))
+	qt.Assert(t, resp.ProofOps, qt.IsNil)
+
+	// tampered proofs must fail
+	resp = query(fmt.Sprintf("/vote/%x/%x", pid, nullifier))
+	_, err = VerifyStateProof(resp.ProofOps.Ops, rng.RandomBytes(32))
+	qt.Assert(t, err, qt.IsNotNil)
+	var data stateProofData
+	qt.Assert(t, json.Unmarshal(resp.ProofOps.Ops[0].Data, &data), qt.IsNil)
+	data.Value = []byte("tampered")
+	resp.ProofOps.Ops[0].Data, err = json.Marshal(data)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = VerifyStateProof(resp.ProofOps.Ops, appHash)
+	qt.Assert(t, err, qt.IsNotNil)
+
+	// errors
+	resp = query(fmt.Sprintf("/account/%x", rng.RandomBytes(20)))
+	qt.Assert(t, resp.Code, qt.Equals, uint32(QueryCodeNotFound))
+	resp = query(fmt.Sprintf("/vote/%x/%x", rng.RandomBytes(32), nullifier))
+	qt.Assert(t, resp.Code, qt.Equals, uint32(QueryCodeNotFound))
+	resp = query("/account/zz")
+	qt.Assert(t, resp.Code, qt.Equals, uint32(QueryCodeInvalid))
+	resp = query("/unknown")
+	qt.Assert(t, resp.Code, qt.Equals, uint32(QueryCodeInvalid))
+
+	// older versions can be queried by height
+	qt.Assert(t, app.State.SetTxCost(models.TxType_NEW_PROCESS, 30), qt.IsNil)
+	app.AdvanceTestBlock()
+	resp = app.Query(abcitypes.RequestQuery{Path: "/txcosts", Height: int64(height), Prove: true})
+	verify(resp, 3)
+	qt.Assert(t, json.Unmarshal(resp.Value, &costs), qt.IsNil)
+	qt.Assert(t, costs[models.TxType_NEW_PROCESS.String()], qt.Equals, uint64(10))
+}
@@ -0,0 +1,214 @@
+package scrutinizer
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// ResultsCertificate returns the certificate of the final results of a
+// process, which can be verified offline with VerifyResultsCertificate.  The
+// certificate is built on the state of the previous block, so the oracle
+// results must have been committed at least one block ago.
+func (s *Scrutinizer) ResultsCertificate(pid []byte) (*indexertypes.ResultsCertificate, error) {
+	results, err := s.GetResults(pid)
+	if err != nil {
+		return nil, err
+	}
+	if !results.Final {
+		return nil, fmt.Errorf("results of process %x are not final yet", pid)
+	}
+	// the AppHash of the last block is the root of the previous state version
+	lastHeight, err := s.App.State.LastHeight()
+	if err != nil {
+		return nil, err
+	}
+	if lastHeight < 2 {
+		return nil, fmt.Errorf("not enough blocks to build a certificate")
+	}
+	height := lastHeight - 1
+	ops, err := s.App.State.ProcessResultsProof(pid, height)
+	if err != nil {
+		return nil, fmt.Errorf("cannot prove process %x: %w", pid, err)
+	}
+	header, validators, err := s.App.GetSignedHeader(int64(height) + 1)
+	if err != nil {
+		return nil, err
+	}
+	leafs, err := vochain.VerifyStateProof(ops, header.AppHash)
+	if err != nil {
+		return nil, fmt.Errorf("state proof does not match block %d: %w", header.Height, err)
+	}
+	sdbProc, err := provenProcess(leafs, pid)
+	if err != nil {
+		return nil, err
+	}
+	if sdbProc.Process.Status != models.ProcessStatus_RESULTS {
+		return nil, fmt.Errorf("results of process %x are not yet set by the oracles", pid)
+	}
+	keys, values, err := s.App.State.ProcessVoteLeafs(pid, height)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the votes of process %x: %w", pid, err)
+	}
+	headerBytes, validatorsBytes, err := vochain.EncodeSignedHeader(header, validators)
+	if err != nil {
+		return nil, err
+	}
+	cert := &indexertypes.ResultsCertificate{
+		ChainID:      header.ChainID,
+		ProcessID:    pid,
+		Process:      sdbProc.Process,
+		Results:      results,
+		VoteCount:    uint64(len(keys)),
+		VotesRoot:    sdbProc.VotesRoot,
+		StateHeight:  height,
+		StateProof:   ops,
+		SignedHeader: headerBytes,
+		Validators:   validatorsBytes,
+	}
+	for i := range keys {
+		cert.VoteLeafs = append(cert.VoteLeafs, &indexertypes.VoteLeaf{Key: keys[i], Value: values[i]})
+	}
+	for _, r := range sdbProc.Process.Results {
+		// the results list is preallocated, so some entries are empty
+		if len(r.GetOracleAddress()) == 0 {
+			continue
+		}
+		cert.OracleSignatures = append(cert.OracleSignatures, &indexertypes.OracleSignature{
+			Oracle:    r.OracleAddress,
+			Signature: r.Signature,
+		})
+	}
+	if err := VerifyResultsCertificate(cert, header.ValidatorsHash); err != nil {
+		return nil, fmt.Errorf("cannot certify results: %w", err)
+	}
+	return cert, nil
+}
+
+// VerifyResultsCertificate checks a results certificate without network
+// access.  The validator set that comes with the certificate must have the
+// trusted validatorsHash, and the block header must be committed by +2/3 of
+// its voting power.  The state proofs must match the header AppHash, the vote
+// leafs must rebuild the proven votes root, and the results must match the
+// ones signed by the oracles in the state.
+func VerifyResultsCertificate(cert *indexertypes.ResultsCertificate, validatorsHash []byte) error {
+	header, err := vochain.VerifySignedHeader(cert.ChainID, int64(cert.StateHeight)+1,
+		cert.SignedHeader, cert.Validators, validatorsHash)
+	if err != nil {
+		return err
+	}
+	leafs, err := vochain.VerifyStateProof(cert.StateProof, header.AppHash)
+	if err != nil {
+		return fmt.Errorf("invalid state proof: %w", err)
+	}
+	sdbProc, err := provenProcess(leafs, cert.ProcessID)
+	if err != nil {
+		return err
+	}
+	if !proto.Equal(sdbProc.Process, cert.Process) {
+		return fmt.Errorf("process does not match the proven one")
+	}
+	if !bytes.Equal(sdbProc.VotesRoot, cert.VotesRoot) {
+		return fmt.Errorf("votes root does not match the proven one")
+	}
+	if err := verifyVoteLeafs(cert, sdbProc.VotesRoot); err != nil {
+		return err
+	}
+	oracles := make(map[common.Address]bool)
+	for _, leaf := range leafs {
+		if leaf.Tree == vochain.TreeOracles {
+			oracles[common.BytesToAddress(leaf.Key)] = true
+		}
+	}
+
+	if cert.Results == nil || !bytes.Equal(cert.Results.ProcessID, cert.ProcessID) {
+		return fmt.Errorf("results do not belong to process %x", cert.ProcessID)
+	}
+	votes := BuildProcessResult(cert.Results, sdbProc.Process.EntityId).Votes
+	signatures := 0
+	for _, r := range sdbProc.Process.Results {
+		if len(r.GetOracleAddress()) == 0 {
+			continue
+		}
+		oracle := common.BytesToAddress(r.OracleAddress)
+		if !oracles[oracle] {
+			return fmt.Errorf("results signed by %s, which is not an oracle", oracle)
+		}
+		if !proto.Equal(&models.ProcessResult{Votes: r.Votes}, &models.ProcessResult{Votes: votes}) {
+			return fmt.Errorf("results do not match the ones of oracle %s", oracle)
+		}
+		payload, err := vochain.OracleResultsPayload(cert.ChainID, r)
+		if err != nil {
+			return err
+		}
+		signer, err := ethereum.AddrFromSignature(payload, r.Signature)
+		if err != nil {
+			return fmt.Errorf("invalid signature of oracle %s: %w", oracle, err)
+		}
+		if signer != oracle {
+			return fmt.Errorf("results of oracle %s are signed by %s", oracle, signer)
+		}
+		if signatures >= len(cert.OracleSignatures) ||
+			!bytes.Equal(cert.OracleSignatures[signatures].Oracle, r.OracleAddress) ||
+			!bytes.Equal(cert.OracleSignatures[signatures].Signature, r.Signature) {
+			return fmt.Errorf("oracle signatures do not match the proven ones")
+		}
+		signatures++
+	}
+	if signatures == 0 {
+		return fmt.Errorf("results of process %x are not signed by any oracle", cert.ProcessID)
+	}
+	if signatures != len(cert.OracleSignatures) {
+		return fmt.Errorf("oracle signatures do not match the proven ones")
+	}
+	return nil
+}
+
+// verifyVoteLeafs checks that the vote leafs of the certificate rebuild the
+// proven votes root, and that they are as many as the certificate VoteCount.
+func verifyVoteLeafs(cert *indexertypes.ResultsCertificate, votesRoot []byte) error {
+	if uint64(len(cert.VoteLeafs)) != cert.VoteCount {
+		return fmt.Errorf("vote count %d does not match the %d vote leafs", cert.VoteCount, len(cert.VoteLeafs))
+	}
+	keys := make([][]byte, len(cert.VoteLeafs))
+	values := make([][]byte, len(cert.VoteLeafs))
+	for i, leaf := range cert.VoteLeafs {
+		keys[i], values[i] = leaf.Key, leaf.Value
+	}
+	root, err := vochain.VotesTreeRoot(keys, values)
+	if err != nil {
+		return fmt.Errorf("invalid vote leafs: %w", err)
+	}
+	// the votes root of a process without votes may not be set yet
+	if len(votesRoot) == 0 && len(keys) == 0 {
+		return nil
+	}
+	if !bytes.Equal(root, votesRoot) {
+		return fmt.Errorf("vote leafs do not match the proven votes root")
+	}
+	return nil
+}
+
+// provenProcess returns the process with key pid from the verified leafs.
+func provenProcess(leafs []vochain.StateProofLeaf, pid []byte) (*models.StateDBProcess, error) {
+	for _, leaf := range leafs {
+		if leaf.Tree != vochain.TreeProcess || !bytes.Equal(leaf.Key, pid) {
+			continue
+		}
+		sdbProc := &models.StateDBProcess{}
+		if err := proto.Unmarshal(leaf.Value, sdbProc); err != nil {
+			return nil, fmt.Errorf("cannot decode process: %w", err)
+		}
+		if sdbProc.Process == nil {
+			return nil, fmt.Errorf("process %x is empty", pid)
+		}
+		return sdbProc, nil
+	}
+	return nil, fmt.Errorf("process %x not found in the state proof", pid)
+}
@@ -0,0 +1,207 @@
+package scrutinizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tmversionproto "github.com/tendermint/tendermint/proto/tendermint/version"
+	tmtypes "github.com/tendermint/tendermint/types"
+	tmversion "github.com/tendermint/tendermint/version"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestResultsCertificate(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	app.SetChainID("test")
+	sc, err := NewScrutinizer(t.TempDir(), app, true)
+	qt.Assert(t, err, qt.IsNil)
+
+	validatorsHash := testSignedHeaders(app)
+
+	oracle := ethereum.NewSignKeys()
+	qt.Assert(t, oracle.Generate(), qt.IsNil)
+	qt.Assert(t, app.State.AddOracle(oracle.Address()), qt.IsNil)
+
+	pid, eid := util.RandomBytes(32), util.RandomBytes(20)
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EntityId:     eid,
+		StartBlock:   app.Height(),
+		BlockCount:   2,
+		EnvelopeType: &models.EnvelopeType{},
+		Status:       models.ProcessStatus_READY,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 2},
+		Mode:         &models.ProcessMode{AutoStart: true, Interruptible: true},
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	// three votes, counted as live results; there is no block store, so
+	// the vote transactions are kept to compute the final results
+	txs := make(map[string]*models.SignedTx)
+	txKey := func(height uint32, txIndex int32) string { return fmt.Sprintf("%d/%d", height, txIndex) }
+	getTx := func(height uint32, txIndex int32) (*models.SignedTx, error) {
+		stx, ok := txs[txKey(height, txIndex)]
+		if !ok {
+			return nil, fmt.Errorf("tx %d/%d not found", height, txIndex)
+		}
+		return stx, nil
+	}
+	app.SetFnGetTx(getTx)
+	app.SetFnGetTxHash(func(height uint32, txIndex int32) (*models.SignedTx, []byte, error) {
+		stx, err := getTx(height, txIndex)
+		return stx, nil, err
+	})
+	vp, err := json.Marshal(vochain.VotePackage{Votes: []int{1, 2}})
+	qt.Assert(t, err, qt.IsNil)
+	nullifiers := [][]byte{}
+	for i := 0; i < 3; i++ {
+		nullifier := util.RandomBytes(32)
+		nullifiers = append(nullifiers, nullifier)
+		app.State.TxCounterAdd()
+		qt.Assert(t, app.State.AddVote(&models.Vote{
+			ProcessId:   pid,
+			Nullifier:   nullifier,
+			VotePackage: vp,
+			Weight:      big.NewInt(1).Bytes(),
+		}, types.VoterID{}.Nil()), qt.IsNil)
+	}
+	app.AdvanceTestBlock()
+	for _, nullifier := range nullifiers {
+		tx, err := proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: &models.VoteEnvelope{
+			ProcessId:   pid,
+			Nullifier:   nullifier,
+			VotePackage: vp,
+		}}})
+		qt.Assert(t, err, qt.IsNil)
+		ref, err := sc.GetEnvelopeReference(nullifier)
+		qt.Assert(t, err, qt.IsNil)
+		txs[txKey(ref.Height, ref.TxIndex)] = &models.SignedTx{Tx: tx}
+	}
+
+	// no certificate before the oracle results
+	_, err = sc.ResultsCertificate(pid)
+	qt.Assert(t, err, qt.IsNotNil)
+
+	qt.Assert(t, app.State.SetProcessStatus(pid, models.ProcessStatus_ENDED, true), qt.IsNil)
+	app.AdvanceTestBlock()
+	qt.Assert(t, sc.ComputeResult(pid), qt.IsNil)
+	results, err := sc.GetResults(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results.Final, qt.IsTrue)
+
+	processResult := BuildProcessResult(results, eid)
+	processResult.OracleAddress = oracle.Address().Bytes()
+	payload, err := vochain.OracleResultsPayload(app.ChainID(), processResult)
+	qt.Assert(t, err, qt.IsNil)
+	processResult.Signature, err = oracle.SignEthereum(payload)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, app.State.SetProcessResults(pid, processResult, true), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	// the results are committed, but not yet in the state of the last header
+	_, err = sc.ResultsCertificate(pid)
+	qt.Assert(t, err, qt.IsNotNil)
+	app.AdvanceTestBlock()
+
+	cert, err := sc.ResultsCertificate(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, cert.VoteCount, qt.Equals, uint64(3))
+	qt.Assert(t, cert.VoteLeafs, qt.HasLen, 3)
+	qt.Assert(t, cert.OracleSignatures, qt.HasLen, 1)
+	qt.Assert(t, []byte(cert.OracleSignatures[0].Oracle), qt.DeepEquals, oracle.Address().Bytes())
+	qt.Assert(t, GetFriendlyResults(cert.Results.Votes), qt.DeepEquals, [][]string{{"0", "3", "0"}, {"0", "0", "3"}})
+
+	// the certificate is verified after a JSON round trip
+	data, err := json.Marshal(cert)
+	qt.Assert(t, err, qt.IsNil)
+	decode := func() *indexertypes.ResultsCertificate {
+		cert := &indexertypes.ResultsCertificate{}
+		qt.Assert(t, json.Unmarshal(data, cert), qt.IsNil)
+		return cert
+	}
+	qt.Assert(t, VerifyResultsCertificate(decode(), validatorsHash), qt.IsNil)
+
+	// a trusted validators hash is required
+	qt.Assert(t, VerifyResultsCertificate(decode(), nil), qt.ErrorMatches, "a trusted validators hash.*")
+	qt.Assert(t, VerifyResultsCertificate(decode(), util.RandomBytes(32)), qt.ErrorMatches,
+		"validator set hash .* is not the trusted one")
+
+	// tampered certificates
+	tampered := decode()
+	tampered.VoteCount++
+	qt.Assert(t, VerifyResultsCertificate(tampered, validatorsHash), qt.ErrorMatches, "vote count.*")
+
+	tampered = decode()
+	tampered.VoteLeafs = tampered.VoteLeafs[1:]
+	tampered.VoteCount--
+	qt.Assert(t, VerifyResultsCertificate(tampered, validatorsHash), qt.ErrorMatches, "vote leafs do not match.*")
+
+	tampered = decode()
+	tampered.Results.Votes[0][1] = new(types.BigInt).SetUint64(4)
+	qt.Assert(t, VerifyResultsCertificate(tampered, validatorsHash), qt.ErrorMatches, "results do not match.*")
+
+	tampered = decode()
+	tampered.VotesRoot = util.RandomBytes(32)
+	qt.Assert(t, VerifyResultsCertificate(tampered, validatorsHash), qt.ErrorMatches, "votes root.*")
+
+	tampered = decode()
+	tampered.StateHeight--
+	qt.Assert(t, VerifyResultsCertificate(tampered, validatorsHash), qt.IsNotNil)
+
+	tampered = decode()
+	tampered.ChainID = "other"
+	qt.Assert(t, VerifyResultsCertificate(tampered, validatorsHash), qt.IsNotNil)
+
+	tampered = decode()
+	otherValidators, _ := tmtypes.RandValidatorSet(1, 10)
+	validatorsProto, err := otherValidators.ToProto()
+	qt.Assert(t, err, qt.IsNil)
+	tampered.Validators, err = validatorsProto.Marshal()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, VerifyResultsCertificate(tampered, validatorsHash), qt.ErrorMatches, "validator set.*")
+}
+
+// testSignedHeaders mocks the block headers of app, signed by a single
+// validator, with the state root of the previous height as AppHash.  It
+// returns the hash of the validator set.
+func testSignedHeaders(app *vochain.BaseApplication) []byte {
+	validators, privValidators := tmtypes.RandValidatorSet(1, 10)
+	app.SetFnGetSignedHeader(func(height int64) (*tmtypes.SignedHeader, *tmtypes.ValidatorSet, error) {
+		appHash, err := app.State.Store.VersionRoot(uint32(height - 1))
+		if err != nil {
+			return nil, nil, err
+		}
+		header := &tmtypes.Header{
+			Version:            tmversionproto.Consensus{Block: tmversion.BlockProtocol},
+			ChainID:            app.ChainID(),
+			Height:             height,
+			Time:               time.Now(),
+			AppHash:            appHash,
+			ValidatorsHash:     validators.Hash(),
+			NextValidatorsHash: validators.Hash(),
+			ProposerAddress:    validators.Proposer.Address,
+		}
+		blockID := tmtypes.BlockID{
+			Hash:          header.Hash(),
+			PartSetHeader: tmtypes.PartSetHeader{Total: 1, Hash: util.RandomBytes(32)},
+		}
+		voteSet := tmtypes.NewVoteSet(app.ChainID(), height, 0, tmproto.PrecommitType, validators)
+		commit, err := tmtypes.MakeCommit(blockID, height, 0, voteSet, privValidators, time.Now())
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tmtypes.SignedHeader{Header: header, Commit: commit}, validators, nil
+	})
+	return validators.Hash()
+}
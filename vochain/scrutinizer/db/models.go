@@ -42,14 +42,21 @@ type Process struct {
 	ResultsEnvelopeHeight int64
 	ResultsSignatures     string
 	ResultsBlockHeight    int64
+	ResultsRankedRounds   string
+	TallyStrategy         string
+	EnvelopeType          int64
+	VoteCount             int64
+	StartDate             time.Time
+	EndDate               time.Time
 }
 
 type VoteReference struct {
-	Nullifier    types.Nullifier
-	ProcessID    types.ProcessID
-	Height       int64
-	Weight       string
-	TxIndex      int64
-	CreationTime time.Time
-	VoterID      types.VoterID
+	Nullifier      types.Nullifier
+	ProcessID      types.ProcessID
+	Height         int64
+	Weight         string
+	TxIndex        int64
+	CreationTime   time.Time
+	VoterID        types.VoterID
+	OverwriteCount int64
 }
@@ -13,6 +13,108 @@ import (
 	"go.vocdoni.io/dvote/types"
 )
 
+const countProcessFacets = `-- name: CountProcessFacets :many
+WITH matching AS (
+	SELECT status, census_origin, envelope_type, have_results
+	FROM processes
+	WHERE (? = "" OR rowid IN (
+			SELECT docid FROM processes_fts WHERE processes_fts MATCH ?))
+		AND (LENGTH(?) = 0 OR LOWER(HEX(entity_id)) = ?)
+		AND (? = 0 OR namespace = ?)
+		AND (? = 0 OR status = ?)
+		AND (? = 0 OR census_origin = ?)
+		AND (envelope_type & ?) = ?
+		AND (? = 0
+			OR CAST(STRFTIME('%s', creation_time) AS INTEGER) >= ?)
+		AND (? = 0
+			OR CAST(STRFTIME('%s', creation_time) AS INTEGER) < ?)
+		AND vote_count >= ?
+		AND (? < 0 OR vote_count <= ?)
+		AND (? = FALSE OR have_results)
+)
+SELECT 'status' AS facet, status AS value, COUNT(*) AS count
+FROM matching GROUP BY status
+UNION ALL
+SELECT 'censusOrigin', census_origin, COUNT(*)
+FROM matching GROUP BY census_origin
+UNION ALL
+SELECT 'envelopeType', bits.bit, COUNT(*)
+FROM matching JOIN (
+	SELECT 1 AS bit UNION ALL SELECT 2 UNION ALL SELECT 4 UNION ALL SELECT 8 UNION ALL SELECT 16
+) AS bits ON envelope_type & bits.bit != 0
+GROUP BY bits.bit
+UNION ALL
+SELECT 'withResults', have_results, COUNT(*)
+FROM matching GROUP BY have_results
+`
+
+type CountProcessFacetsParams struct {
+	Text          interface{}
+	EntityID      string
+	Namespace     int64
+	Status        int64
+	CensusOrigin  int64
+	EnvelopeMask  interface{}
+	EnvelopeFlags interface{}
+	CreatedAfter  interface{}
+	CreatedBefore interface{}
+	MinVotes      int64
+	MaxVotes      int64
+	WithResults   interface{}
+}
+
+type CountProcessFacetsRow struct {
+	Facet string
+	Value int64
+	Count int64
+}
+
+// Counts the processes matching the FindProcesses filters by status, census
+// origin, envelope type flag and results availability.
+func (q *Queries) CountProcessFacets(ctx context.Context, arg CountProcessFacetsParams) ([]CountProcessFacetsRow, error) {
+	rows, err := q.db.QueryContext(ctx, countProcessFacets,
+		arg.Text,
+		arg.Text,
+		arg.EntityID,
+		arg.EntityID,
+		arg.Namespace,
+		arg.Namespace,
+		arg.Status,
+		arg.Status,
+		arg.CensusOrigin,
+		arg.CensusOrigin,
+		arg.EnvelopeMask,
+		arg.EnvelopeFlags,
+		arg.CreatedAfter,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.CreatedBefore,
+		arg.MinVotes,
+		arg.MaxVotes,
+		arg.MaxVotes,
+		arg.WithResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountProcessFacetsRow
+	for rows.Next() {
+		var i CountProcessFacetsRow
+		if err := rows.Scan(&i.Facet, &i.Value, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createProcess = `-- name: CreateProcess :execresult
 INSERT INTO processes (
 	id, entity_id, entity_index, start_block, end_block,
@@ -24,6 +126,8 @@ INSERT INTO processes (
 	private_keys, public_keys,
 	question_index, creation_time,
 	source_block_height, source_network_id,
+	tally_strategy, envelope_type,
+	start_date, end_date,
 
 	results_votes, results_weight, results_envelope_height,
 	results_signatures, results_block_height
@@ -37,6 +141,8 @@ INSERT INTO processes (
 	?, ?,
 	?, ?,
 	?, ?,
+	?, ?,
+	?, ?,
 
 	?, "0", 0,
 	"", 0
@@ -70,6 +176,10 @@ type CreateProcessParams struct {
 	CreationTime      time.Time
 	SourceBlockHeight int64
 	SourceNetworkID   string
+	TallyStrategy     string
+	EnvelopeType      int64
+	StartDate         time.Time
+	EndDate           time.Time
 	ResultsVotes      string
 }
 
@@ -101,12 +211,138 @@ func (q *Queries) CreateProcess(ctx context.Context, arg CreateProcessParams) (s
 		arg.CreationTime,
 		arg.SourceBlockHeight,
 		arg.SourceNetworkID,
+		arg.TallyStrategy,
+		arg.EnvelopeType,
+		arg.StartDate,
+		arg.EndDate,
 		arg.ResultsVotes,
 	)
 }
 
+const findProcesses = `-- name: FindProcesses :many
+SELECT id, sort_key FROM (
+	SELECT id, CASE ?
+		WHEN 'startBlock' THEN start_block
+		WHEN 'endBlock' THEN end_block
+		WHEN 'voteCount' THEN vote_count
+		ELSE CAST(STRFTIME('%s', creation_time) AS INTEGER)
+	END AS sort_key
+	FROM processes
+	WHERE (? = "" OR rowid IN (
+			SELECT docid FROM processes_fts WHERE processes_fts MATCH ?))
+		AND (LENGTH(?) = 0 OR LOWER(HEX(entity_id)) = ?)
+		AND (? = 0 OR namespace = ?)
+		AND (? = 0 OR status = ?)
+		AND (? = 0 OR census_origin = ?)
+		AND (envelope_type & ?) = ?
+		AND (? = 0
+			OR CAST(STRFTIME('%s', creation_time) AS INTEGER) >= ?)
+		AND (? = 0
+			OR CAST(STRFTIME('%s', creation_time) AS INTEGER) < ?)
+		AND vote_count >= ?
+		AND (? < 0 OR vote_count <= ?)
+		AND (? = FALSE OR have_results)
+)
+WHERE COALESCE(LENGTH(?), 0) = 0
+	OR (? = FALSE AND (sort_key > ?
+		OR (sort_key = ? AND id > ?)))
+	OR (? = TRUE AND (sort_key < ?
+		OR (sort_key = ? AND id < ?)))
+ORDER BY
+	CASE WHEN ? THEN -sort_key ELSE sort_key END,
+	CASE WHEN ? THEN NULL ELSE id END ASC,
+	CASE WHEN ? THEN id ELSE NULL END DESC
+LIMIT ?
+`
+
+type FindProcessesParams struct {
+	SortBy        interface{}
+	Text          interface{}
+	EntityID      string
+	Namespace     int64
+	Status        int64
+	CensusOrigin  int64
+	EnvelopeMask  interface{}
+	EnvelopeFlags interface{}
+	CreatedAfter  interface{}
+	CreatedBefore interface{}
+	MinVotes      int64
+	MaxVotes      int64
+	WithResults   interface{}
+	AfterID       types.ProcessID
+	Descending    interface{}
+	AfterKey      interface{}
+	Limit         int32
+}
+
+type FindProcessesRow struct {
+	ID      types.ProcessID
+	SortKey interface{}
+}
+
+// The sort key is one of creationTime (the default), startBlock, endBlock or
+// voteCount; ties are broken by id.  The cursor is the sort key and id of the
+// last process of the previous page.
+func (q *Queries) FindProcesses(ctx context.Context, arg FindProcessesParams) ([]FindProcessesRow, error) {
+	rows, err := q.db.QueryContext(ctx, findProcesses,
+		arg.SortBy,
+		arg.Text,
+		arg.Text,
+		arg.EntityID,
+		arg.EntityID,
+		arg.Namespace,
+		arg.Namespace,
+		arg.Status,
+		arg.Status,
+		arg.CensusOrigin,
+		arg.CensusOrigin,
+		arg.EnvelopeMask,
+		arg.EnvelopeFlags,
+		arg.CreatedAfter,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.CreatedBefore,
+		arg.MinVotes,
+		arg.MaxVotes,
+		arg.MaxVotes,
+		arg.WithResults,
+		arg.AfterID,
+		arg.Descending,
+		arg.AfterKey,
+		arg.AfterKey,
+		arg.AfterID,
+		arg.Descending,
+		arg.AfterKey,
+		arg.AfterKey,
+		arg.AfterID,
+		arg.Descending,
+		arg.Descending,
+		arg.Descending,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FindProcessesRow
+	for rows.Next() {
+		var i FindProcessesRow
+		if err := rows.Scan(&i.ID, &i.SortKey); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getProcess = `-- name: GetProcess :one
-SELECT id, entity_id, entity_index, start_block, end_block, results_height, have_results, final_results, census_root, rolling_census_root, rolling_census_size, max_census_size, census_uri, metadata, census_origin, status, namespace, envelope_pb, mode_pb, vote_opts_pb, private_keys, public_keys, question_index, creation_time, source_block_height, source_network_id, results_votes, results_weight, results_envelope_height, results_signatures, results_block_height FROM processes
+SELECT id, entity_id, entity_index, start_block, end_block, results_height, have_results, final_results, census_root, rolling_census_root, rolling_census_size, max_census_size, census_uri, metadata, census_origin, status, namespace, envelope_pb, mode_pb, vote_opts_pb, private_keys, public_keys, question_index, creation_time, source_block_height, source_network_id, results_votes, results_weight, results_envelope_height, results_signatures, results_block_height, results_ranked_rounds, tally_strategy, envelope_type, vote_count, start_date, end_date FROM processes
 WHERE id = ?
 LIMIT 1
 `
@@ -146,6 +382,12 @@ func (q *Queries) GetProcess(ctx context.Context, id types.ProcessID) (Process,
 		&i.ResultsEnvelopeHeight,
 		&i.ResultsSignatures,
 		&i.ResultsBlockHeight,
+		&i.ResultsRankedRounds,
+		&i.TallyStrategy,
+		&i.EnvelopeType,
+		&i.VoteCount,
+		&i.StartDate,
+		&i.EndDate,
 	)
 	return i, err
 }
@@ -163,6 +405,39 @@ func (q *Queries) GetProcessStatus(ctx context.Context, id types.ProcessID) (int
 	return status, err
 }
 
+const getProcessesWithoutEnvelopeType = `-- name: GetProcessesWithoutEnvelopeType :many
+SELECT id, envelope_pb FROM processes
+WHERE envelope_type < 0
+`
+
+type GetProcessesWithoutEnvelopeTypeRow struct {
+	ID         types.ProcessID
+	EnvelopePb types.EncodedProtoBuf
+}
+
+func (q *Queries) GetProcessesWithoutEnvelopeType(ctx context.Context) ([]GetProcessesWithoutEnvelopeTypeRow, error) {
+	rows, err := q.db.QueryContext(ctx, getProcessesWithoutEnvelopeType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetProcessesWithoutEnvelopeTypeRow
+	for rows.Next() {
+		var i GetProcessesWithoutEnvelopeTypeRow
+		if err := rows.Scan(&i.ID, &i.EnvelopePb); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const searchProcesses = `-- name: SearchProcesses :many
 SELECT ID FROM processes
 WHERE (LENGTH(?) = 0 OR LOWER(HEX(entity_id)) = ?)
@@ -229,6 +504,21 @@ func (q *Queries) SearchProcesses(ctx context.Context, arg SearchProcessesParams
 	return items, nil
 }
 
+const setProcessEnvelopeType = `-- name: SetProcessEnvelopeType :execresult
+UPDATE processes
+SET envelope_type = ?
+WHERE id = ?
+`
+
+type SetProcessEnvelopeTypeParams struct {
+	EnvelopeType int64
+	ID           types.ProcessID
+}
+
+func (q *Queries) SetProcessEnvelopeType(ctx context.Context, arg SetProcessEnvelopeTypeParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, setProcessEnvelopeType, arg.EnvelopeType, arg.ID)
+}
+
 const setProcessResultsCancelled = `-- name: SetProcessResultsCancelled :execresult
 UPDATE processes
 SET have_results = FALSE, final_results = TRUE
@@ -261,7 +551,9 @@ SET have_results = TRUE, final_results = TRUE,
 	results_weight = ?,
 	results_envelope_height = ?,
 	results_signatures = ?,
-	results_block_height = ?
+	results_block_height = ?,
+	results_ranked_rounds = ?,
+	tally_strategy = ?
 WHERE id = ?
 `
 
@@ -271,6 +563,8 @@ type SetProcessResultsReadyParams struct {
 	EnvelopeHeight int64
 	Signatures     string
 	BlockHeight    int64
+	RankedRounds   string
+	TallyStrategy  string
 	ID             types.ProcessID
 }
 
@@ -281,13 +575,59 @@ func (q *Queries) SetProcessResultsReady(ctx context.Context, arg SetProcessResu
 		arg.EnvelopeHeight,
 		arg.Signatures,
 		arg.BlockHeight,
+		arg.RankedRounds,
+		arg.TallyStrategy,
+		arg.ID,
+	)
+}
+
+const setProcessSearchText = `-- name: SetProcessSearchText :execresult
+UPDATE processes_fts
+SET title = ?, description = ?
+WHERE docid = (SELECT rowid FROM processes WHERE id = ?)
+`
+
+type SetProcessSearchTextParams struct {
+	Title       string
+	Description string
+	ID          types.ProcessID
+}
+
+func (q *Queries) SetProcessSearchText(ctx context.Context, arg SetProcessSearchTextParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, setProcessSearchText, arg.Title, arg.Description, arg.ID)
+}
+
+const setProcessTallyStrategy = `-- name: SetProcessTallyStrategy :execresult
+UPDATE processes
+SET tally_strategy = ?,
+	results_votes = ?,
+	results_weight = ?,
+	results_envelope_height = ?
+WHERE id = ? AND final_results = FALSE
+`
+
+type SetProcessTallyStrategyParams struct {
+	TallyStrategy  string
+	Votes          string
+	Weight         string
+	EnvelopeHeight int64
+	ID             types.ProcessID
+}
+
+func (q *Queries) SetProcessTallyStrategy(ctx context.Context, arg SetProcessTallyStrategyParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, setProcessTallyStrategy,
+		arg.TallyStrategy,
+		arg.Votes,
+		arg.Weight,
+		arg.EnvelopeHeight,
 		arg.ID,
 	)
 }
 
 const updateProcessFromState = `-- name: UpdateProcessFromState :execresult
 UPDATE processes
-SET end_block           = ?,
+SET start_block         = ?,
+	end_block           = ?,
 	census_root         = ?,
 	rolling_census_root = ?,
 	census_uri          = ?,
@@ -300,6 +640,7 @@ WHERE id = ?
 `
 
 type UpdateProcessFromStateParams struct {
+	StartBlock        int64
 	EndBlock          int64
 	CensusRoot        types.CensusRoot
 	RollingCensusRoot types.CensusRoot
@@ -314,6 +655,7 @@ type UpdateProcessFromStateParams struct {
 
 func (q *Queries) UpdateProcessFromState(ctx context.Context, arg UpdateProcessFromStateParams) (sql.Result, error) {
 	return q.db.ExecContext(ctx, updateProcessFromState,
+		arg.StartBlock,
 		arg.EndBlock,
 		arg.CensusRoot,
 		arg.RollingCensusRoot,
@@ -16,21 +16,29 @@ import (
 const createVoteReference = `-- name: CreateVoteReference :execresult
 INSERT INTO vote_references (
 	nullifier, process_id, height, weight,
-	tx_index, voter_id, creation_time
+	tx_index, voter_id, creation_time, overwrite_count
 ) VALUES (
 	?, ?, ?, ?,
-	?, ?, ?
+	?, ?, ?, ?
 )
+ON CONFLICT (nullifier) DO UPDATE SET
+	height = excluded.height,
+	weight = excluded.weight,
+	tx_index = excluded.tx_index,
+	voter_id = excluded.voter_id,
+	creation_time = excluded.creation_time,
+	overwrite_count = excluded.overwrite_count
 `
 
 type CreateVoteReferenceParams struct {
-	Nullifier    types.Nullifier
-	ProcessID    types.ProcessID
-	Height       int64
-	Weight       string
-	TxIndex      int64
-	VoterID      types.VoterID
-	CreationTime time.Time
+	Nullifier      types.Nullifier
+	ProcessID      types.ProcessID
+	Height         int64
+	Weight         string
+	TxIndex        int64
+	VoterID        types.VoterID
+	CreationTime   time.Time
+	OverwriteCount int64
 }
 
 func (q *Queries) CreateVoteReference(ctx context.Context, arg CreateVoteReferenceParams) (sql.Result, error) {
@@ -42,11 +50,12 @@ func (q *Queries) CreateVoteReference(ctx context.Context, arg CreateVoteReferen
 		arg.TxIndex,
 		arg.VoterID,
 		arg.CreationTime,
+		arg.OverwriteCount,
 	)
 }
 
 const getVoteReference = `-- name: GetVoteReference :one
-SELECT nullifier, process_id, height, weight, tx_index, creation_time, voter_id FROM vote_references
+SELECT nullifier, process_id, height, weight, tx_index, creation_time, voter_id, overwrite_count FROM vote_references
 WHERE nullifier = ?
 LIMIT 1
 `
@@ -62,6 +71,7 @@ func (q *Queries) GetVoteReference(ctx context.Context, nullifier types.Nullifie
 		&i.TxIndex,
 		&i.CreationTime,
 		&i.VoterID,
+		&i.OverwriteCount,
 	)
 	return i, err
 }
@@ -0,0 +1,87 @@
+package scrutinizer
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// delegatedBallot is a vote of a delegated voting process, along with the
+// census weight of each delegator it carries.
+type delegatedBallot struct {
+	nullifier []byte
+	weight    *big.Int
+	// shares is the census weight of the delegators, by their nullifier
+	shares map[string]*big.Int
+}
+
+// isDelegatedVotingProcess returns true if the process allows delegates to
+// vote on behalf of their delegators.
+func (s *Scrutinizer) isDelegatedVotingProcess(pid []byte) bool {
+	process, err := s.App.State.Process(pid, false)
+	if err != nil {
+		log.Warnf("cannot get process %x: %v", pid, err)
+		return false
+	}
+	return vochain.ProcessDelegatedVoting(process.GetMode())
+}
+
+// delegatedWeights returns the weight of each vote of a delegated voting
+// process, by nullifier.  The share of a delegator is only counted on the
+// vote that holds it, as recorded by the consensus, so it is subtracted from
+// the weight of the other votes that carry it.
+func (s *Scrutinizer) delegatedWeights(pid []byte) (map[string]*big.Int, error) {
+	process, err := s.App.State.Process(pid, true)
+	if err != nil {
+		return nil, err
+	}
+	ballots := []*delegatedBallot{}
+	if err := s.walkVotes(pid, false, func(txRef *indexertypes.VoteReference,
+		envelope *models.VoteEnvelope) {
+		ballot := &delegatedBallot{
+			nullifier: txRef.Nullifier,
+			weight:    new(big.Int).Set(txRef.Weight.ToInt()),
+			shares:    make(map[string]*big.Int),
+		}
+		ballots = append(ballots, ballot)
+		delegations, err := vochain.VoteEnvelopeDelegations(envelope)
+		if err != nil {
+			log.Warnf("cannot decode delegations of vote %x: %v", txRef.Nullifier, err)
+			return
+		}
+		// the delegation signatures were verified when the vote was delivered
+		for _, d := range delegations {
+			addr, weight, err := d.CensusWeight(process)
+			if err != nil {
+				log.Warnf("invalid delegation on vote %x: %v", txRef.Nullifier, err)
+				continue
+			}
+			ballot.shares[string(vochain.GenerateNullifier(addr, pid))] = weight
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	weights := make(map[string]*big.Int, len(ballots))
+	for _, ballot := range ballots {
+		for delegator, weight := range ballot.shares {
+			holder, err := s.App.State.VoteDelegationHolder(pid, []byte(delegator), true)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(holder, ballot.nullifier) {
+				ballot.weight.Sub(ballot.weight, weight)
+			}
+		}
+		if ballot.weight.Sign() < 0 {
+			return nil, fmt.Errorf("vote %x has a negative weight", ballot.nullifier)
+		}
+		weights[string(ballot.nullifier)] = ballot.weight
+	}
+	return weights, nil
+}
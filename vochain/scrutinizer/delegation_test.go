@@ -0,0 +1,207 @@
+package scrutinizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"go.vocdoni.io/dvote/censustree"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/db/metadb"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDelegatedVoting(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	sc, err := NewScrutinizer(t.TempDir(), app, true)
+	qt.Assert(t, err, qt.IsNil)
+
+	// weighted census of five voters: a=1, b=2, c=4, d=8, e=16
+	tr, err := censustree.New(censustree.Options{Name: "delegation", ParentDB: metadb.NewTest(t),
+		MaxLevels: 256, CensusType: models.Census_ARBO_BLAKE2B})
+	qt.Assert(t, err, qt.IsNil)
+	keys := util.CreateEthRandomKeysBatch(5)
+	a, b, c, d, e := keys[0], keys[1], keys[2], keys[3], keys[4]
+	for i, k := range keys {
+		key, err := tr.Hash(k.PublicKey())
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, tr.Add(key, tr.BigIntToBytes(big.NewInt(1<<i))), qt.IsNil)
+	}
+	root, err := tr.Root()
+	qt.Assert(t, err, qt.IsNil)
+	censusProof := func(k *ethereum.SignKeys) *models.Proof {
+		key, err := tr.Hash(k.PublicKey())
+		qt.Assert(t, err, qt.IsNil)
+		value, siblings, err := tr.GenProof(key)
+		qt.Assert(t, err, qt.IsNil)
+		return &models.Proof{Payload: &models.Proof_Arbo{Arbo: &models.ProofArbo{
+			Type:     models.ProofArbo_BLAKE2B,
+			Siblings: siblings,
+			Value:    value,
+		}}}
+	}
+
+	// a delegates to b, and b and d delegate to c
+	delegates := map[*ethereum.SignKeys]*ethereum.SignKeys{a: b, b: c, d: c}
+	for _, k := range keys {
+		accDelegates := []common.Address{}
+		if delegate, ok := delegates[k]; ok {
+			accDelegates = append(accDelegates, delegate.Address())
+		}
+		qt.Assert(t, app.State.CreateAccount(k.Address(), "", accDelegates, 0), qt.IsNil)
+	}
+
+	newProcess := func(delegated bool) []byte {
+		pid := util.RandomBytes(32)
+		mode := &models.ProcessMode{AutoStart: true, Interruptible: true}
+		vochain.SetProcessDelegatedVoting(mode, delegated)
+		qt.Assert(t, app.State.AddProcess(&models.Process{
+			ProcessId:    pid,
+			EntityId:     util.RandomBytes(20),
+			StartBlock:   app.Height(),
+			BlockCount:   100,
+			EnvelopeType: &models.EnvelopeType{},
+			Status:       models.ProcessStatus_READY,
+			VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 2},
+			Mode:         mode,
+			CensusRoot:   root,
+			CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE_WEIGHTED,
+		}), qt.IsNil)
+		return pid
+	}
+	pid := newProcess(true)
+	otherPid := newProcess(false)
+	app.AdvanceTestBlock()
+
+	// there is no block store, so the vote transactions are kept to
+	// compute the results
+	txs := make(map[string]*models.SignedTx)
+	txKey := func(height uint32, txIndex int32) string { return fmt.Sprintf("%d/%d", height, txIndex) }
+	app.SetFnGetTx(func(height uint32, txIndex int32) (*models.SignedTx, error) {
+		stx, ok := txs[txKey(height, txIndex)]
+		if !ok {
+			return nil, fmt.Errorf("tx %d/%d not found", height, txIndex)
+		}
+		return stx, nil
+	})
+	delegationSignature := func(delegator *ethereum.SignKeys, pid []byte,
+		delegate *ethereum.SignKeys) []byte {
+		signature, err := delegator.SignVocdoniMsg(
+			vochain.VoteDelegationMessage(pid, delegate.Address()))
+		qt.Assert(t, err, qt.IsNil)
+		return signature
+	}
+	pending := make(map[string]*models.SignedTx)
+	vote := func(pid []byte, voter *ethereum.SignKeys, option int,
+		delegators ...*ethereum.SignKeys) error {
+		vp, err := json.Marshal(vochain.VotePackage{Votes: []int{option}})
+		qt.Assert(t, err, qt.IsNil)
+		ve := &models.VoteEnvelope{
+			Nonce:       util.RandomBytes(32),
+			ProcessId:   pid,
+			Proof:       censusProof(voter),
+			VotePackage: vp,
+		}
+		delegations := []*vochain.VoteDelegation{}
+		for _, k := range delegators {
+			delegations = append(delegations, &vochain.VoteDelegation{
+				PublicKey: k.PublicKey(),
+				Proof:     censusProof(k),
+				Signature: delegationSignature(k, pid, voter),
+			})
+		}
+		qt.Assert(t, vochain.SetVoteEnvelopeDelegations(ve, delegations), qt.IsNil)
+		stx := &models.SignedTx{}
+		stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: ve}})
+		qt.Assert(t, err, qt.IsNil)
+		stx.Signature, err = voter.SignVocdoniTx(stx.Tx, app.ChainID())
+		qt.Assert(t, err, qt.IsNil)
+		txBytes, err := proto.Marshal(stx)
+		qt.Assert(t, err, qt.IsNil)
+		if resp := app.CheckTx(abcitypes.RequestCheckTx{Tx: txBytes}); resp.Code != 0 {
+			return fmt.Errorf("%s", resp.Data)
+		}
+		if resp := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: txBytes}); resp.Code != 0 {
+			return fmt.Errorf("%s", resp.Data)
+		}
+		pending[string(vochain.GenerateNullifier(voter.Address(), pid))] = stx
+		return nil
+	}
+	advance := func() {
+		app.AdvanceTestBlock()
+		for nullifier, stx := range pending {
+			ref, err := sc.GetEnvelopeReference([]byte(nullifier))
+			qt.Assert(t, err, qt.IsNil)
+			txs[txKey(ref.Height, ref.TxIndex)] = stx
+			delete(pending, nullifier)
+		}
+	}
+	weight := func(voter *ethereum.SignKeys) string {
+		ref, err := sc.GetEnvelopeReference(vochain.GenerateNullifier(voter.Address(), pid))
+		qt.Assert(t, err, qt.IsNil)
+		return ref.Weight.String()
+	}
+
+	// delegations are only allowed on delegated voting processes
+	qt.Assert(t, vote(otherPid, c, 0, b), qt.ErrorMatches, ".*does not allow delegated votes.*")
+	// e is not a delegate of a
+	qt.Assert(t, vote(pid, e, 0, a), qt.ErrorMatches, ".*does not delegate to.*")
+	// the delegators must sign the delegation to the delegate that carries them
+	process, err := app.State.Process(pid, false)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = app.State.VoteDelegationsWeight(process, c.Address(),
+		[]*vochain.VoteDelegation{{PublicKey: b.PublicKey(), Proof: censusProof(b),
+			Signature: delegationSignature(b, pid, e)}}, false)
+	qt.Assert(t, err, qt.ErrorMatches, ".*did not sign the delegation.*")
+	// c carries a through b, and d
+	qt.Assert(t, vote(pid, c, 0, a, b, d), qt.IsNil)
+	advance()
+	qt.Assert(t, weight(c), qt.Equals, "15")
+
+	// b overrides c for its share, and carries a again
+	qt.Assert(t, vote(pid, b, 1, a), qt.IsNil)
+	// d overrides c for its share
+	qt.Assert(t, vote(pid, d, 2), qt.IsNil)
+	qt.Assert(t, vote(pid, e, 1), qt.IsNil)
+	advance()
+	qt.Assert(t, weight(b), qt.Equals, "3")
+
+	// a delegator that already voted can't be carried
+	_, err = app.State.VoteDelegationsWeight(process, c.Address(),
+		[]*vochain.VoteDelegation{{PublicKey: d.PublicKey(), Proof: censusProof(d),
+			Signature: delegationSignature(d, pid, c)}}, false)
+	qt.Assert(t, err, qt.ErrorMatches, ".*has already voted")
+
+	// the consensus records the vote holding the share of each voter
+	holder := func(voter *ethereum.SignKeys) []byte {
+		h, err := app.State.VoteDelegationHolder(pid,
+			vochain.GenerateNullifier(voter.Address(), pid), true)
+		qt.Assert(t, err, qt.IsNil)
+		return h
+	}
+	qt.Assert(t, holder(a), qt.DeepEquals, vochain.GenerateNullifier(b.Address(), pid))
+	qt.Assert(t, holder(b), qt.DeepEquals, vochain.GenerateNullifier(b.Address(), pid))
+	qt.Assert(t, holder(d), qt.DeepEquals, vochain.GenerateNullifier(d.Address(), pid))
+
+	// the votes of delegated voting processes are not live counted
+	results, err := sc.GetResults(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results.Weight.String(), qt.Equals, "0")
+
+	qt.Assert(t, app.State.SetProcessStatus(pid, models.ProcessStatus_ENDED, true), qt.IsNil)
+	app.AdvanceTestBlock()
+	qt.Assert(t, sc.ComputeResult(pid), qt.IsNil)
+	results, err = sc.GetResults(pid)
+	qt.Assert(t, err, qt.IsNil)
+	// c keeps its own share only, as a is carried later by b, and b and d
+	// voted by themselves
+	qt.Assert(t, GetFriendlyResults(results.Votes), qt.DeepEquals, [][]string{{"4", "19", "8"}})
+	qt.Assert(t, results.Weight.String(), qt.Equals, "31")
+}
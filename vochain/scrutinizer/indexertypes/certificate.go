@@ -0,0 +1,67 @@
+package indexertypes
+
+import (
+	tmcrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// ResultsCertificate bundles the final results of a process with everything
+// needed to verify them offline.  The state proof contains the process leaf,
+// which holds the results signed by the oracles and the votes tree root, and
+// the oracle list.  It is verified against the AppHash of the block header
+// at StateHeight+1, whose commit is signed by the validators.
+type ResultsCertificate struct {
+	ChainID   string          `json:"chainId"`
+	ProcessID types.HexBytes  `json:"processId"`
+	Process   *models.Process `json:"process"`
+	Results   *Results        `json:"results"`
+	// VoteCount is the number of votes in the votes tree of the process
+	VoteCount uint64         `json:"voteCount"`
+	VotesRoot types.HexBytes `json:"votesRoot"`
+	// VoteLeafs are all the leafs of the votes tree of the process, which
+	// rebuild VotesRoot and so prove VoteCount.
+	VoteLeafs        []*VoteLeaf        `json:"voteLeafs"`
+	OracleSignatures []*OracleSignature `json:"oracleSignatures"`
+	// StateHeight is the state version the proof is built on
+	StateHeight uint32             `json:"stateHeight"`
+	StateProof  []tmcrypto.ProofOp `json:"stateProof"`
+	// SignedHeader is the protobuf encoded tendermint SignedHeader of the
+	// block at StateHeight+1, and Validators its protobuf encoded
+	// ValidatorSet.
+	SignedHeader types.HexBytes `json:"signedHeader"`
+	Validators   types.HexBytes `json:"validators"`
+}
+
+// VoteLeaf is a leaf of the votes tree of a process, the vote id and the
+// encoded StateDBVote.
+type VoteLeaf struct {
+	Key   types.HexBytes `json:"key"`
+	Value types.HexBytes `json:"value"`
+}
+
+// OracleSignature is the signature of an oracle on the process results
+type OracleSignature struct {
+	Oracle    types.HexBytes `json:"oracle"`
+	Signature types.HexBytes `json:"signature"`
+}
+
+// VoteReceipt proves that a vote is registered in the state of a block
+// committed by the validators.  The state proof contains the vote leaf of the
+// votes tree of the process, followed by the process leaf holding the votes
+// tree root, and is verified against the AppHash of the block header at
+// StateHeight+1.
+type VoteReceipt struct {
+	ChainID   string         `json:"chainId"`
+	ProcessID types.HexBytes `json:"processId"`
+	Nullifier types.HexBytes `json:"nullifier"`
+	// Envelope is the protobuf encoded models.SignedTx of the vote
+	Envelope types.HexBytes `json:"envelope"`
+	// Vote is the protobuf encoded models.Vote, whose hash is stored in the
+	// votes tree
+	Vote         types.HexBytes     `json:"vote"`
+	StateHeight  uint32             `json:"stateHeight"`
+	StateProof   []tmcrypto.ProofOp `json:"stateProof"`
+	SignedHeader types.HexBytes     `json:"signedHeader"`
+	Validators   types.HexBytes     `json:"validators"`
+}
@@ -0,0 +1,142 @@
+package indexertypes
+
+import (
+	"math/big"
+
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// IsRankedChoice returns true if the ballot protocol options can hold a ranked
+// choice ballot, tallied by instant runoff.  The options alone don't make a
+// process ranked, the strategy must be picked explicitly.  In a ranked ballot
+// each vote value is a candidate and its position in the vote is the
+// preference (the first value is the preferred candidate), so that a vote can
+// rank up to MaxCount different candidates out of MaxValue+1.  That is:
+//
+//	uniqueValues=true, maxCount=N, maxValue=N-1, maxTotalCost=0, costFromWeight=false
+//
+// The per-question results matrix keeps counting the weight of the candidates
+// chosen at each preference position.
+func IsRankedChoice(envelope *models.EnvelopeType, opts *models.ProcessVoteOptions) bool {
+	if envelope == nil || opts == nil {
+		return false
+	}
+	return envelope.UniqueValues && !envelope.CostFromWeight &&
+		opts.MaxCount > 1 && opts.MaxValue+1 == opts.MaxCount && opts.MaxTotalCost == 0
+}
+
+// Ballot is an individual counted vote: its values and weight (1 if nil).  In
+// a ranked ballot the values are the candidates in order of preference.
+type Ballot struct {
+	Values []int
+	Weight *big.Int
+}
+
+// RankedRound is a round of an instant runoff tally.
+type RankedRound struct {
+	// Tally is the weight of the ballots counted for each candidate in
+	// this round.  Eliminated candidates have a tally of zero.
+	Tally []*types.BigInt `json:"tally"`
+	// Exhausted is the weight of the ballots without any preference left
+	// for the remaining candidates.
+	Exhausted *types.BigInt `json:"exhausted"`
+	// Eliminated is the candidate eliminated at the end of this round, or
+	// -1 if this is the last round.
+	Eliminated int `json:"eliminated"`
+	// Winner is the candidate holding the majority of the non exhausted
+	// ballots in this round, or -1 if there is no winner yet.
+	Winner int `json:"winner"`
+}
+
+// InstantRunoff tallies the ranked ballots for the given number of candidates
+// and returns the elimination rounds.  On each round every ballot counts for
+// its most preferred candidate still in the race.  The round ends if a
+// candidate holds more than half of the counted weight, or if a single
+// candidate is left.  Otherwise the candidate with the lowest tally is
+// eliminated; ties are broken by the tallies of the previous rounds (from
+// the latest to the first) and then by eliminating the highest candidate
+// index.  Returns nil if there are no ballots.
+func InstantRunoff(ballots []Ballot, candidates int) []*RankedRound {
+	if len(ballots) == 0 || candidates == 0 {
+		return nil
+	}
+	eliminated := make([]bool, candidates)
+	remaining := candidates
+	rounds := []*RankedRound{}
+	for {
+		round := &RankedRound{
+			Tally:      make([]*types.BigInt, candidates),
+			Exhausted:  new(types.BigInt).SetUint64(0),
+			Eliminated: -1,
+			Winner:     -1,
+		}
+		for i := range round.Tally {
+			round.Tally[i] = new(types.BigInt).SetUint64(0)
+		}
+		counted := new(types.BigInt).SetUint64(0)
+		for _, ballot := range ballots {
+			weight := (*types.BigInt)(ballot.Weight)
+			if weight == nil {
+				weight = new(types.BigInt).SetUint64(1)
+			}
+			candidate := -1
+			for _, c := range ballot.Values {
+				if c >= 0 && c < candidates && !eliminated[c] {
+					candidate = c
+					break
+				}
+			}
+			if candidate < 0 {
+				round.Exhausted.Add(round.Exhausted, weight)
+				continue
+			}
+			round.Tally[candidate].Add(round.Tally[candidate], weight)
+			counted.Add(counted, weight)
+		}
+		rounds = append(rounds, round)
+
+		// check for a majority winner
+		half := new(big.Int).Rsh(counted.ToInt(), 1)
+		for c, tally := range round.Tally {
+			if !eliminated[c] && tally.ToInt().Cmp(half) > 0 {
+				round.Winner = c
+			}
+		}
+		if remaining == 1 {
+			for c := range eliminated {
+				if !eliminated[c] {
+					round.Winner = c
+				}
+			}
+		}
+		if round.Winner >= 0 || remaining == 1 {
+			return rounds
+		}
+
+		// eliminate the candidate with the lowest tally
+		loser := -1
+		for c := candidates - 1; c >= 0; c-- {
+			if eliminated[c] {
+				continue
+			}
+			if loser < 0 || rankedLess(rounds, c, loser) {
+				loser = c
+			}
+		}
+		round.Eliminated = loser
+		eliminated[loser] = true
+		remaining--
+	}
+}
+
+// rankedLess returns true if candidate a has a lower tally than b in the last
+// round, using the previous rounds to break ties.
+func rankedLess(rounds []*RankedRound, a, b int) bool {
+	for i := len(rounds) - 1; i >= 0; i-- {
+		if cmp := rounds[i].Tally[a].ToInt().Cmp(rounds[i].Tally[b].ToInt()); cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return false
+}
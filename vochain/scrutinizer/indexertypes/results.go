@@ -28,6 +28,31 @@ type Results struct {
 	Signatures     []types.HexBytes           `json:"signatures"`
 	Final          bool                       `json:"final"`
 	BlockHeight    uint32                     `json:"blockHeight"`
+	// RankedRounds holds the instant runoff rounds of the final results
+	// of a ranked choice process (see IsRankedChoice).
+	RankedRounds []*RankedRound `json:"rankedRounds,omitempty"`
+	// TallyStrategy is the name of the strategy counting the votes.  If
+	// empty, the strategy is picked from the ballot protocol options.
+	TallyStrategy string `json:"tallyStrategy,omitempty"`
+}
+
+// voteCounter counts the votes into the results, see SetVoteCounter.
+var voteCounter func(r *Results, voteValues []int, weight *big.Int, mutex *sync.Mutex) error
+
+// SetVoteCounter sets the function used by AddVote to count the votes.  The
+// scrutinizer package sets it to count the votes with the tally strategy of
+// the results.
+func SetVoteCounter(counter func(r *Results, voteValues []int, weight *big.Int, mutex *sync.Mutex) error) {
+	voteCounter = counter
+}
+
+// AddVote adds the voteValues and weight to the Results struct.
+// Checks are performed according the Ballot Protocol.
+func (r *Results) AddVote(voteValues []int, weight *big.Int, mutex *sync.Mutex) error {
+	if voteCounter == nil {
+		return fmt.Errorf("addVote: no vote counter set")
+	}
+	return voteCounter(r, voteValues, weight, mutex)
 }
 
 // String formats the results in a human-readable string
@@ -77,113 +102,30 @@ func (r *Results) Add(new *Results) error {
 	return nil
 }
 
-// AddVote adds the voteValues and weight to the Results struct.
-// Checks are performed according the Ballot Protocol.
-func (r *Results) AddVote(voteValues []int, weight *big.Int, mutex *sync.Mutex) error {
-	if r.VoteOpts == nil {
-		return fmt.Errorf("addVote: processVoteOptions is nil")
-	}
-
-	if len(r.Votes) > 0 && len(r.Votes) != int(r.VoteOpts.MaxCount) {
-		return fmt.Errorf("addVote: currentResults size mismatch %d != %d",
-			len(r.Votes), r.VoteOpts.MaxCount)
-	}
-
-	if r.EnvelopeType == nil {
-		return fmt.Errorf("addVote: envelopeType is nil")
-	}
-	// MaxCount
-	if len(voteValues) > int(r.VoteOpts.MaxCount) || len(voteValues) > MaxOptions {
-		return fmt.Errorf("max count overflow %d", len(voteValues))
-	}
-
-	// UniqueValues
-	if r.EnvelopeType.UniqueValues {
-		votes := make(map[int]bool, len(voteValues))
-		for _, v := range voteValues {
-			if votes[v] {
-				return fmt.Errorf("values are not unique")
-			}
-			votes[v] = true
-		}
-	}
-
-	// Max Value, check it only if greather than zero
-	if r.VoteOpts.MaxValue > 0 {
-		for _, v := range voteValues {
-			if uint32(v) > r.VoteOpts.MaxValue {
-				return fmt.Errorf("max value overflow %d", v)
-			}
-		}
-	}
-
-	// Max total cost
-	if r.VoteOpts.MaxTotalCost > 0 || r.EnvelopeType.CostFromWeight {
-		cost := new(big.Int).SetUint64(0)
-		exponent := new(big.Int).SetUint64(uint64(r.VoteOpts.CostExponent))
-		var maxCost *big.Int
-		if r.EnvelopeType.CostFromWeight {
-			maxCost = weight
-		} else {
-			maxCost = new(big.Int).SetUint64(uint64(r.VoteOpts.MaxTotalCost))
-		}
-		for _, v := range voteValues {
-			cost.Add(cost, new(big.Int).Exp(new(big.Int).SetUint64(uint64(v)), exponent, nil))
-			if cost.Cmp(maxCost) > 0 {
-				return fmt.Errorf("max total cost overflow: %s", cost)
-			}
-		}
-	}
-
-	// If Mutex provided, Lock it
-	if mutex != nil {
-		mutex.Lock()
-		defer mutex.Unlock()
-	}
-
-	// If weight not provided, assume weight = 1
-	if weight == nil {
-		weight = new(big.Int).SetUint64(1)
+// Sub subtracts the total weight and votes from the given Results to the
+// containing Results making the method call.  It is used to remove the
+// overwritten votes from the live results.  Note that the EnvelopeHeight of
+// partial results may wrap around, which is fixed once they are added to the
+// complete results.
+func (r *Results) Sub(old *Results) error {
+	r.Weight.Sub(r.Weight, old.Weight)
+	r.EnvelopeHeight -= old.EnvelopeHeight
+	// Update votes only if present
+	if len(old.Votes) == 0 {
+		return nil
 	}
-
-	// Add the Election weight (tells how much voting power have already been processed)
-	r.Weight.Add(r.Weight, (*types.BigInt)(weight))
 	if len(r.Votes) == 0 {
-		r.Votes = NewEmptyVotes(int(r.VoteOpts.MaxCount), int(r.VoteOpts.MaxValue)+1)
+		r.Votes = NewEmptyVotes(len(old.Votes), len(old.Votes[0]))
 	}
-	// Increase EnvelopeHeight by the number of votes added
-	r.EnvelopeHeight++
-
-	// If MaxValue is zero, consider discrete value couting. So for each questoin, the value
-	// is aggregated. The weight is multiplied for the value if costFromWeight=False.
-	// This is a special case for Quadratic voting where maxValue should be 0 (no limit).
-	// The results are aggregated, so we use only the first column of the results matrix.
-	if r.VoteOpts.MaxValue == 0 {
-		// If CostFromWeight, the Weight is used for computing the cost and not as a value multiplier
-		if r.EnvelopeType.CostFromWeight {
-			weight = new(big.Int).SetUint64(1)
-		}
-		// Example if maxValue=0 and CostFromWeight=false
-		// Vote1: [1, 2, 3] w=10
-		// Vote2: [0, 3, 1] w=5
-		//  [ [1*10+0*5], [2*10+3*5], [3*10+1*5] ]
-		// Results: [ [10], [35], [35] ]
-		//
-		// If CostFromWeight=true then we assume the weight is already represented on the vote value.
-		// This is why we set weight=1.
-		for q, value := range voteValues {
-			r.Votes[q][0].Add(
-				r.Votes[q][0],
-				new(types.BigInt).Mul(
-					new(types.BigInt).SetUint64(uint64(value)),
-					(*types.BigInt)(weight)),
-			)
+	if len(old.Votes) != len(r.Votes) {
+		return fmt.Errorf("results.Sub: incorrect number of fields")
+	}
+	for i := range old.Votes {
+		if len(r.Votes[i]) < len(old.Votes[i]) {
+			return fmt.Errorf("results.Sub: values overflow (%d)", i)
 		}
-	} else {
-		// For the other cases, we use the results matrix index weighted
-		// as described in the Ballot Protocol.
-		for q, opt := range voteValues {
-			r.Votes[q][opt].Add(r.Votes[q][opt], (*types.BigInt)(weight))
+		for j := range old.Votes[i] {
+			r.Votes[i][j].Sub(r.Votes[i][j], old.Votes[i][j])
 		}
 	}
 	return nil
@@ -0,0 +1,107 @@
+package indexertypes
+
+import (
+	"time"
+
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// Process search sort keys
+const (
+	SortByCreationTime = "creationTime"
+	SortByStartBlock   = "startBlock"
+	SortByEndBlock     = "endBlock"
+	SortByVoteCount    = "voteCount"
+)
+
+// Envelope type flags, as stored in the envelope_type bitmask of the
+// processes table.
+const (
+	EnvelopeSerial = 1 << iota
+	EnvelopeAnonymous
+	EnvelopeEncryptedVotes
+	EnvelopeUniqueValues
+	EnvelopeCostFromWeight
+)
+
+// EnvelopeTypeBits returns the envelope type flags as a bitmask.
+func EnvelopeTypeBits(e *models.EnvelopeType) int64 {
+	bits := int64(0)
+	if e.GetSerial() {
+		bits |= EnvelopeSerial
+	}
+	if e.GetAnonymous() {
+		bits |= EnvelopeAnonymous
+	}
+	if e.GetEncryptedVotes() {
+		bits |= EnvelopeEncryptedVotes
+	}
+	if e.GetUniqueValues() {
+		bits |= EnvelopeUniqueValues
+	}
+	if e.GetCostFromWeight() {
+		bits |= EnvelopeCostFromWeight
+	}
+	return bits
+}
+
+// EnvelopeTypeNames are the names of the envelope type flags used in the
+// search filters and facets.
+var EnvelopeTypeNames = map[int64]string{
+	EnvelopeSerial:         "serial",
+	EnvelopeAnonymous:      "anonymous",
+	EnvelopeEncryptedVotes: "encryptedVotes",
+	EnvelopeUniqueValues:   "uniqueValues",
+	EnvelopeCostFromWeight: "costFromWeight",
+}
+
+// ProcessSearch holds the filters, sort order and page of a process search.
+// The zero values of the filters are ignored.
+type ProcessSearch struct {
+	// Text is a full-text query over the process and entity ids (in
+	// lowercase hex), the census URI and the title and description of the
+	// process metadata.  It follows the SQLite FTS4 syntax, i.e. "abc*"
+	// matches the words starting with abc and "title:abc" only the titles.
+	Text         string         `json:"text,omitempty"`
+	EntityID     types.HexBytes `json:"entityId,omitempty"`
+	Namespace    uint32         `json:"namespace,omitempty"`
+	Status       string         `json:"status,omitempty"`
+	CensusOrigin string         `json:"censusOrigin,omitempty"`
+	// Envelope filters on the envelope type flags by their name (see
+	// EnvelopeTypeNames), to be either set or unset.
+	Envelope      map[string]bool `json:"envelope,omitempty"`
+	CreatedAfter  *time.Time      `json:"createdAfter,omitempty"`
+	CreatedBefore *time.Time      `json:"createdBefore,omitempty"`
+	MinVotes      uint64          `json:"minVotes,omitempty"`
+	MaxVotes      *uint64         `json:"maxVotes,omitempty"`
+	WithResults   bool            `json:"withResults,omitempty"`
+	// SortBy is one of creationTime (the default), startBlock, endBlock
+	// and voteCount.
+	SortBy     string `json:"sortBy,omitempty"`
+	Descending bool   `json:"descending,omitempty"`
+	// Cursor is the NextCursor of the previous page, with the same filters
+	// and sort order.  Empty for the first page.
+	Cursor string `json:"cursor,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	// Facets requests the facet counts of the processes matching the filters
+	Facets bool `json:"facets,omitempty"`
+}
+
+// ProcessSearchResult is a page of the processes matching a ProcessSearch.
+type ProcessSearchResult struct {
+	ProcessIDs []types.HexBytes `json:"processIds"`
+	// NextCursor is the cursor of the next page, empty on the last page.
+	NextCursor string         `json:"nextCursor,omitempty"`
+	Facets     *ProcessFacets `json:"facets,omitempty"`
+}
+
+// ProcessFacets are the number of processes matching a search by each value
+// of the facet fields.  On Envelope, a process is counted for each flag set.
+type ProcessFacets struct {
+	Status       map[string]uint64 `json:"status"`
+	CensusOrigin map[string]uint64 `json:"censusOrigin"`
+	Envelope     map[string]uint64 `json:"envelope"`
+	WithResults  uint64            `json:"withResults"`
+	Total        uint64            `json:"total"`
+}
@@ -62,6 +62,9 @@ type Process struct {
 	SourceNetworkId   string                     `badgerholdIndex:"SourceNetworkId" json:"sourceNetworkId"`
 	MaxCensusSize     uint64                     `json:"maxCensusSize"`
 	RollingCensusSize uint64                     `json:"rollingCensusSize"`
+	TallyStrategy     string                     `json:"tallyStrategy"`
+	StartDate         *time.Time                 `json:"startDate,omitempty"`
+	EndDate           *time.Time                 `json:"endDate,omitempty"`
 }
 
 func ProcessFromDB(dbproc *scrutinizerdb.Process) *Process {
@@ -88,6 +91,9 @@ func ProcessFromDB(dbproc *scrutinizerdb.Process) *Process {
 		SourceBlockHeight: uint64(dbproc.SourceBlockHeight),
 		SourceNetworkId:   dbproc.SourceNetworkID,
 		Metadata:          dbproc.Metadata,
+		TallyStrategy:     dbproc.TallyStrategy,
+		StartDate:         NonZeroTime(dbproc.StartDate),
+		EndDate:           NonZeroTime(dbproc.EndDate),
 	}
 	// Note that the old DB does not seem to keep a nil Envelope.
 	// TODO(mvdan): when we drop badgerhold, consider removing this alloc.
@@ -128,6 +134,19 @@ func decodeVotes(input string) [][]*types.BigInt {
 	return votes
 }
 
+func decodeRankedRounds(input string) []*RankedRound {
+	// JSON list of rounds, or empty if not a ranked choice process
+	if input == "" {
+		return nil
+	}
+	var rounds []*RankedRound
+	if err := json.Unmarshal([]byte(input), &rounds); err != nil {
+		log.Error(err)
+		return nil
+	}
+	return rounds
+}
+
 func ResultsFromDB(dbproc *scrutinizerdb.Process) *Results {
 	results := &Results{
 		ProcessID:      dbproc.ID,
@@ -137,6 +156,8 @@ func ResultsFromDB(dbproc *scrutinizerdb.Process) *Results {
 		Signatures:     hexSplit(dbproc.ResultsSignatures),
 		Final:          dbproc.FinalResults,
 		BlockHeight:    uint32(dbproc.ResultsBlockHeight),
+		RankedRounds:   decodeRankedRounds(dbproc.ResultsRankedRounds),
+		TallyStrategy:  dbproc.TallyStrategy,
 	}
 	// Note that the old DB does not seem to keep a nil Envelope.
 	// TODO(mvdan): when we drop badgerhold, consider removing this alloc.
@@ -169,6 +190,15 @@ func nonEmptyBytes(p []byte) []byte {
 	return p
 }
 
+// NonZeroTime returns a pointer to t, or nil if t is the zero time, so that
+// the dates of the processes scheduled by block height are omitted.
+func NonZeroTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
 func nonEmptySplit(s, sep string) []string {
 	list := strings.Split(s, sep)
 	if len(list) == 1 && list[0] == "" {
@@ -220,6 +250,8 @@ type VoteReference struct {
 	Weight       *types.BigInt
 	TxIndex      int32
 	CreationTime time.Time
+	// OverwriteCount is the number of times the vote has been overwritten
+	OverwriteCount uint32
 }
 
 func VoteReferenceFromDB(dbvote *scrutinizerdb.VoteReference) *VoteReference {
@@ -228,13 +260,14 @@ func VoteReferenceFromDB(dbvote *scrutinizerdb.VoteReference) *VoteReference {
 		panic(err) // should never happen
 	}
 	return &VoteReference{
-		Nullifier:    dbvote.Nullifier,
-		ProcessID:    dbvote.ProcessID,
-		VoterID:      dbvote.VoterID,
-		Height:       uint32(dbvote.Height),
-		Weight:       weightInt,
-		TxIndex:      int32(dbvote.TxIndex),
-		CreationTime: dbvote.CreationTime,
+		Nullifier:      dbvote.Nullifier,
+		ProcessID:      dbvote.ProcessID,
+		VoterID:        dbvote.VoterID,
+		Height:         uint32(dbvote.Height),
+		Weight:         weightInt,
+		TxIndex:        int32(dbvote.TxIndex),
+		CreationTime:   dbvote.CreationTime,
+		OverwriteCount: uint32(dbvote.OverwriteCount),
 	}
 }
 
@@ -246,6 +279,8 @@ type EnvelopeMetadata struct {
 	TxIndex   int32          `json:"tx_index"`
 	Height    uint32         `json:"height"`
 	TxHash    types.HexBytes `json:"tx_hash"`
+	// OverwriteCount is the number of times the vote has been overwritten
+	OverwriteCount uint32 `json:"overwrite_count"`
 }
 
 // EnvelopePackage contains a VoteEnvelope and auxiliary information for the Envelope api
@@ -0,0 +1,288 @@
+package scrutinizer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/timshannon/badgerhold/v3"
+	"go.vocdoni.io/dvote/data"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	scrutinizerdb "go.vocdoni.io/dvote/vochain/scrutinizer/db"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+const (
+	// metadataRetrieveTimeout is the maximum time to retrieve the metadata of a process
+	metadataRetrieveTimeout = time.Minute
+	// metadataMaxSize is the maximum size of the metadata of a process
+	metadataMaxSize = 1 << 20
+)
+
+// metadataTallyStrategies maps the results aggregation of the process metadata
+// to the tally strategy of the process.  The other aggregations are counted
+// with the DefaultTallyStrategy.
+var metadataTallyStrategies = map[string]string{
+	"ranked-choice": TallyRankedChoice,
+	"borda":         TallyBorda,
+	"condorcet":     TallyCondorcet,
+	"dhondt":        TallyDHondt,
+}
+
+// processMetadata holds the fields of the process metadata, as stored on the
+// remote storage, used by the indexer.  The title and description map each
+// language to its text.
+type processMetadata struct {
+	Title       map[string]string `json:"title"`
+	Description map[string]string `json:"description"`
+	Results     struct {
+		Aggregation string `json:"aggregation"`
+		// Seats is the number of seats allocated by the dhondt aggregation
+		Seats int `json:"seats,omitempty"`
+	} `json:"results"`
+}
+
+// tallyStrategy returns the name of the tally strategy picked by the
+// metadata, or an empty string if the process uses the DefaultTallyStrategy.
+func (m *processMetadata) tallyStrategy() (string, error) {
+	name, ok := metadataTallyStrategies[m.Results.Aggregation]
+	if !ok {
+		return "", nil
+	}
+	if name == TallyDHondt {
+		if m.Results.Seats <= 0 {
+			return "", fmt.Errorf("dhondt aggregation without seats")
+		}
+		name = DHondtTallyName(m.Results.Seats)
+	}
+	return name, nil
+}
+
+// languageText joins the texts of all the languages, sorted by language and
+// without repetitions, to be indexed for the full-text search.
+func languageText(texts map[string]string) string {
+	langs := make([]string, 0, len(texts))
+	for lang := range texts {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	seen := make(map[string]bool)
+	joined := []string{}
+	for _, lang := range langs {
+		if text := texts[lang]; text != "" && !seen[text] {
+			seen[text] = true
+			joined = append(joined, text)
+		}
+	}
+	return strings.Join(joined, " ")
+}
+
+// fetchedMetadata is the process metadata retrieved by fetchMetadata, pending
+// to be applied on the next commit.
+type fetchedMetadata struct {
+	tallyStrategy string
+	title         string
+	description   string
+}
+
+// SetMetadataStorage sets the storage used to retrieve the metadata of the
+// processes, which picks their tally strategy and is indexed for the
+// full-text search.  If not set, all the processes use the
+// DefaultTallyStrategy and only their ids and census URI are searchable.
+func (s *Scrutinizer) SetMetadataStorage(storage data.Storage) {
+	s.storage = storage
+}
+
+// retrieveMetadata retrieves and decodes the metadata of a process.
+func (s *Scrutinizer) retrieveMetadata(uri string) (*processMetadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataRetrieveTimeout)
+	defer cancel()
+	content, err := s.storage.Retrieve(ctx, strings.TrimPrefix(uri, s.storage.URIprefix()), metadataMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve metadata %s: %w", uri, err)
+	}
+	metadata := &processMetadata{}
+	if err := json.Unmarshal(content, metadata); err != nil {
+		return nil, fmt.Errorf("cannot decode metadata %s: %w", uri, err)
+	}
+	return metadata, nil
+}
+
+// metadataTallyStrategy retrieves the metadata of the process and returns the
+// name of its tally strategy.  The strategy must fit the ballot protocol
+// options of the process.
+func (s *Scrutinizer) metadataTallyStrategy(uri string, envelope *models.EnvelopeType,
+	opts *models.ProcessVoteOptions) (string, error) {
+	if s.storage == nil || uri == "" {
+		return "", nil
+	}
+	metadata, err := s.retrieveMetadata(uri)
+	if err != nil {
+		return "", err
+	}
+	return metadata.validTallyStrategy(envelope, opts)
+}
+
+// validTallyStrategy returns the name of the tally strategy picked by the
+// metadata, if it fits the ballot protocol options of the process.
+func (m *processMetadata) validTallyStrategy(envelope *models.EnvelopeType,
+	opts *models.ProcessVoteOptions) (string, error) {
+	name, err := m.tallyStrategy()
+	if err != nil || name == "" {
+		return "", err
+	}
+	if name == TallyRankedChoice && !indexertypes.IsRankedChoice(envelope, opts) {
+		return "", fmt.Errorf("vote options are not a ranked ballot")
+	}
+	if _, err := GetTallyStrategy(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// fetchMetadata retrieves the metadata of a new process and schedules its
+// tally strategy and search text to be set on the next commit.
+func (s *Scrutinizer) fetchMetadata(pid []byte, uri string, envelope *models.EnvelopeType,
+	opts *models.ProcessVoteOptions) {
+	metadata, err := s.retrieveMetadata(uri)
+	if err != nil {
+		log.Warnf("cannot retrieve the metadata of process %x: %v", pid, err)
+		return
+	}
+	fetched := &fetchedMetadata{
+		title:       languageText(metadata.Title),
+		description: languageText(metadata.Description),
+	}
+	if fetched.tallyStrategy, err = metadata.validTallyStrategy(envelope, opts); err != nil {
+		log.Warnf("cannot pick the tally strategy of process %x: %v", pid, err)
+	}
+	s.pendingMetadataLock.Lock()
+	defer s.pendingMetadataLock.Unlock()
+	s.pendingMetadata[string(pid)] = fetched
+}
+
+// applyProcessMetadata sets the tally strategies fetched since the last commit,
+// counting again the live results of those processes, and indexes their
+// search text.  It is called by Commit before counting the votes of the block.
+func (s *Scrutinizer) applyProcessMetadata() {
+	s.pendingMetadataLock.Lock()
+	pending := s.pendingMetadata
+	s.pendingMetadata = make(map[string]*fetchedMetadata)
+	s.pendingMetadataLock.Unlock()
+
+	for pid, fetched := range pending {
+		if fetched.tallyStrategy != "" {
+			if err := s.setTallyStrategy([]byte(pid), fetched.tallyStrategy); err != nil {
+				log.Warnf("cannot set the tally strategy of process %x: %v", []byte(pid), err)
+			}
+		}
+		if fetched.title != "" || fetched.description != "" {
+			if err := s.setSearchText([]byte(pid), fetched.title, fetched.description); err != nil {
+				log.Warnf("cannot index the metadata of process %x: %v", []byte(pid), err)
+			}
+		}
+	}
+}
+
+// setSearchText indexes the title and description of a process for the
+// full-text search.
+func (s *Scrutinizer) setSearchText(pid []byte, title, description string) error {
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	_, err := queries.SetProcessSearchText(ctx, scrutinizerdb.SetProcessSearchTextParams{
+		ID:          pid,
+		Title:       title,
+		Description: description,
+	})
+	return err
+}
+
+// setTallyStrategy changes the tally strategy of a process without final
+// results, and counts again its live results with the new strategy.
+func (s *Scrutinizer) setTallyStrategy(pid []byte, name string) error {
+	strategy, err := GetTallyStrategy(name)
+	if err != nil {
+		return err
+	}
+	// If the recovery bootstrap is running, wait
+	s.recoveryBootLock.RLock()
+	defer s.recoveryBootLock.RUnlock()
+
+	proc, err := s.ProcessInfo(pid)
+	if err != nil {
+		return err
+	}
+	if proc.FinalResults || proc.TallyStrategy == name {
+		return nil
+	}
+	stored, err := s.GetResults(pid)
+	if err != nil {
+		return err
+	}
+	results := &indexertypes.Results{
+		ProcessID:      pid,
+		Votes:          strategy.NewVotes(proc.VoteOpts),
+		Weight:         stored.Weight,
+		EnvelopeHeight: stored.EnvelopeHeight,
+		BlockHeight:    stored.BlockHeight,
+		Signatures:     []types.HexBytes{},
+		VoteOpts:       proc.VoteOpts,
+		EnvelopeType:   proc.Envelope,
+		TallyStrategy:  name,
+	}
+	if s.isProcessLiveResults(pid) {
+		// the votes counted so far are counted again from scratch
+		results.Weight = new(types.BigInt).SetUint64(0)
+		results.EnvelopeHeight = 0
+		if err := s.WalkEnvelopes(pid, false, func(vote *models.VoteEnvelope, weight *big.Int) {
+			if err := s.addLiveVote(pid, vote.VotePackage, weight, nil, results); err != nil {
+				log.Debugf("vote cannot be added: %v", err)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+
+	// The next lock avoid Transaction Conflicts
+	s.addVoteLock.Lock()
+	defer s.addVoteLock.Unlock()
+	if enableBadgerhold {
+		if err := s.queryWithRetries(func() error {
+			return s.db.UpdateMatching(&indexertypes.Process{},
+				badgerhold.Where(badgerhold.Key).Eq(pid),
+				func(record interface{}) error {
+					update, ok := record.(*indexertypes.Process)
+					if !ok {
+						return fmt.Errorf("record isn't the correct type! Wanted Process, got %T", record)
+					}
+					update.TallyStrategy = name
+					return nil
+				},
+			)
+		}); err != nil {
+			return err
+		}
+		if err := s.queryWithRetries(func() error { return s.db.Upsert(pid, results) }); err != nil {
+			return err
+		}
+	}
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	if _, err := queries.SetProcessTallyStrategy(ctx, scrutinizerdb.SetProcessTallyStrategyParams{
+		ID:             pid,
+		TallyStrategy:  name,
+		Votes:          encodeVotes(results.Votes),
+		Weight:         results.Weight.String(),
+		EnvelopeHeight: int64(results.EnvelopeHeight),
+	}); err != nil {
+		return err
+	}
+	log.Infof("process %x tally strategy set to %s", pid, name)
+	return nil
+}
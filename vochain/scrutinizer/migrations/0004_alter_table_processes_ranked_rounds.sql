@@ -0,0 +1,6 @@
+-- +goose Up
+-- JSON list of the instant runoff rounds of ranked choice processes
+ALTER TABLE processes ADD results_ranked_rounds TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE processes DROP COLUMN results_ranked_rounds;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- name of the TallyStrategy used to count the votes; empty means picked by the vote options
+ALTER TABLE processes ADD tally_strategy TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE processes DROP COLUMN tally_strategy;
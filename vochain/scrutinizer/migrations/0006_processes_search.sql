@@ -0,0 +1,78 @@
+-- +goose Up
+-- bitmask of the envelope type flags, see indexertypes.EnvelopeTypeBits;
+-- -1 means not yet computed, the scrutinizer fills it from envelope_pb on start
+ALTER TABLE processes ADD envelope_type INTEGER NOT NULL DEFAULT -1;
+
+-- number of indexed votes, kept up to date by the triggers below
+ALTER TABLE processes ADD vote_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX index_vote_references_process_id
+ON vote_references(process_id);
+
+UPDATE processes
+SET vote_count = (SELECT COUNT(*) FROM vote_references WHERE process_id = processes.id);
+
+-- +goose StatementBegin
+CREATE TRIGGER vote_references_count_insert AFTER INSERT ON vote_references
+BEGIN
+	UPDATE processes SET vote_count = vote_count + 1 WHERE id = new.process_id;
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER vote_references_count_delete AFTER DELETE ON vote_references
+BEGIN
+	UPDATE processes SET vote_count = vote_count - 1 WHERE id = old.process_id;
+END;
+-- +goose StatementEnd
+
+CREATE INDEX index_processes_creation_time
+ON processes(creation_time);
+
+CREATE INDEX index_processes_start_block
+ON processes(start_block);
+
+-- full-text index over the process and entity ids, the census URI and the
+-- title and description of the process metadata, which are set once the
+-- metadata is retrieved from the storage; the rows are keyed by the processes
+-- rowid, and the ids are stored in lowercase hex, like the search parameters
+CREATE VIRTUAL TABLE processes_fts USING fts4(id_hex, entity_id_hex, census_uri, title, description);
+
+INSERT INTO processes_fts (docid, id_hex, entity_id_hex, census_uri)
+SELECT rowid, LOWER(HEX(id)), LOWER(HEX(entity_id)), census_uri FROM processes;
+
+-- +goose StatementBegin
+CREATE TRIGGER processes_fts_insert AFTER INSERT ON processes
+BEGIN
+	INSERT INTO processes_fts (docid, id_hex, entity_id_hex, census_uri)
+	VALUES (new.rowid, LOWER(HEX(new.id)), LOWER(HEX(new.entity_id)), new.census_uri);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER processes_fts_update AFTER UPDATE OF census_uri ON processes
+WHEN old.census_uri != new.census_uri
+BEGIN
+	UPDATE processes_fts SET census_uri = new.census_uri WHERE docid = new.rowid;
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER processes_fts_delete AFTER DELETE ON processes
+BEGIN
+	DELETE FROM processes_fts WHERE docid = old.rowid;
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER processes_fts_delete;
+DROP TRIGGER processes_fts_update;
+DROP TRIGGER processes_fts_insert;
+DROP TABLE processes_fts;
+DROP INDEX index_processes_start_block;
+DROP INDEX index_processes_creation_time;
+DROP TRIGGER vote_references_count_delete;
+DROP TRIGGER vote_references_count_insert;
+DROP INDEX index_vote_references_process_id;
+ALTER TABLE processes DROP COLUMN vote_count;
+ALTER TABLE processes DROP COLUMN envelope_type;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- number of times the vote of the nullifier has been overwritten
+ALTER TABLE vote_references ADD overwrite_count INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE vote_references DROP COLUMN overwrite_count;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- start and end times of the time-based processes; the zero time means the
+-- process is scheduled by block height
+ALTER TABLE processes ADD start_date DATETIME NOT NULL DEFAULT '0001-01-01 00:00:00+00:00';
+ALTER TABLE processes ADD end_date DATETIME NOT NULL DEFAULT '0001-01-01 00:00:00+00:00';
+
+-- +goose Down
+ALTER TABLE processes DROP COLUMN end_date;
+ALTER TABLE processes DROP COLUMN start_date;
@@ -2,6 +2,7 @@ package scrutinizer
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"strings"
@@ -16,6 +17,7 @@ import (
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
 	scrutinizerdb "go.vocdoni.io/dvote/vochain/scrutinizer/db"
 	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
 )
@@ -60,6 +62,17 @@ func encodeVotes(votes [][]*types.BigInt) string {
 	return b.String()
 }
 
+func encodeRankedRounds(rounds []*indexertypes.RankedRound) string {
+	if len(rounds) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(rounds)
+	if err != nil {
+		panic(err) // should never happen
+	}
+	return string(data)
+}
+
 func sqliteWarnf(format string, args ...interface{}) {
 	if flag.Lookup("test.run") != nil {
 		log.Fatalf(format, args...)
@@ -396,6 +409,17 @@ func (s *Scrutinizer) newEmptyProcess(pid []byte) error {
 		return fmt.Errorf("maxCount or maxValue overflows hardcoded maximums")
 	}
 
+	// The tally strategy picked by the process metadata, and its search text,
+	// are set once retrieved
+	tallyStrategyName := DefaultTallyStrategy(p.GetEnvelopeType(), options)
+	tallyStrategy, err := GetTallyStrategy(tallyStrategyName)
+	if err != nil {
+		return fmt.Errorf("newEmptyProcess: %w", err)
+	}
+	if s.storage != nil && p.GetMetadata() != "" {
+		go s.fetchMetadata(pid, p.GetMetadata(), p.GetEnvelopeType(), options)
+	}
+
 	eid := p.GetEntityId()
 	// Get the block time from the Header
 	currentBlockTime := time.Unix(s.App.TimestampStartBlock(), 0)
@@ -405,13 +429,13 @@ func (s *Scrutinizer) newEmptyProcess(pid []byte) error {
 		s.addVoteLock.Lock()
 		if err := s.queryWithRetries(func() error {
 			return s.db.Insert(pid, &indexertypes.Results{
-				ProcessID: pid,
-				// MaxValue requires +1 since 0 is also an option
-				Votes:        indexertypes.NewEmptyVotes(int(options.MaxCount), int(options.MaxValue)+1),
-				Weight:       new(types.BigInt).SetUint64(0),
-				Signatures:   []types.HexBytes{},
-				VoteOpts:     p.GetVoteOptions(),
-				EnvelopeType: p.GetEnvelopeType(),
+				ProcessID:     pid,
+				Votes:         tallyStrategy.NewVotes(options),
+				Weight:        new(types.BigInt).SetUint64(0),
+				Signatures:    []types.HexBytes{},
+				VoteOpts:      p.GetVoteOptions(),
+				EnvelopeType:  p.GetEnvelopeType(),
+				TallyStrategy: tallyStrategyName,
 			})
 		}); err != nil {
 			s.addVoteLock.Unlock()
@@ -461,12 +485,14 @@ func (s *Scrutinizer) newEmptyProcess(pid []byte) error {
 	}
 
 	compResultsHeight := uint32(0)
-	if live, err := s.isOpenProcess(pid); err != nil {
+	live, err := s.isOpenProcess(pid)
+	if err != nil {
 		return fmt.Errorf("cannot check if process is live: %w", err)
-	} else {
-		if live {
-			compResultsHeight = p.GetBlockCount() + p.GetStartBlock() + 1
-		}
+	}
+	// the end block of a time-based process is an estimation, so its results
+	// are scheduled once it is ended
+	if live && !vochain.IsTimeBasedProcess(p) {
+		compResultsHeight = p.GetBlockCount() + p.GetStartBlock() + 1
 	}
 
 	// Create and store process in the indexer database
@@ -476,7 +502,7 @@ func (s *Scrutinizer) newEmptyProcess(pid []byte) error {
 		StartBlock:        p.GetStartBlock(),
 		EndBlock:          p.GetBlockCount() + p.GetStartBlock(),
 		Rheight:           compResultsHeight,
-		HaveResults:       compResultsHeight > 0,
+		HaveResults:       live,
 		CensusRoot:        p.GetCensusRoot(),
 		RollingCensusRoot: p.GetRollingCensusRoot(),
 		CensusURI:         p.GetCensusURI(),
@@ -495,6 +521,9 @@ func (s *Scrutinizer) newEmptyProcess(pid []byte) error {
 		// EntityIndex: entity.ProcessCount, // TODO(sqlite): replace with a COUNT
 		MaxCensusSize:     p.GetMaxCensusSize(),
 		RollingCensusSize: p.GetRollingCensusSize(),
+		TallyStrategy:     tallyStrategyName,
+		StartDate:         indexertypes.NonZeroTime(vochain.ProcessStartTime(p)),
+		EndDate:           indexertypes.NonZeroTime(vochain.ProcessEndTime(p)),
 	}
 	log.Debugf("new indexer process %s", proc.String())
 
@@ -513,7 +542,7 @@ func (s *Scrutinizer) newEmptyProcess(pid []byte) error {
 		StartBlock:        int64(p.GetStartBlock()),
 		EndBlock:          int64(p.GetBlockCount() + p.GetStartBlock()),
 		ResultsHeight:     int64(compResultsHeight),
-		HaveResults:       compResultsHeight > 0,
+		HaveResults:       live,
 		CensusRoot:        nonNullBytes(p.GetCensusRoot()),
 		RollingCensusRoot: nonNullBytes(p.GetRollingCensusRoot()),
 		RollingCensusSize: int64(p.GetRollingCensusSize()),
@@ -523,6 +552,7 @@ func (s *Scrutinizer) newEmptyProcess(pid []byte) error {
 		Status:            int64(p.GetStatus()),
 		Namespace:         int64(p.GetNamespace()),
 		EnvelopePb:        encodedPb(p.GetEnvelopeType()),
+		EnvelopeType:      indexertypes.EnvelopeTypeBits(p.GetEnvelopeType()),
 		ModePb:            encodedPb(p.GetMode()),
 		VoteOptsPb:        encodedPb(p.GetVoteOptions()),
 		PrivateKeys:       strings.Join(p.EncryptionPrivateKeys, ","),
@@ -531,8 +561,11 @@ func (s *Scrutinizer) newEmptyProcess(pid []byte) error {
 		SourceBlockHeight: int64(p.GetSourceBlockHeight()),
 		SourceNetworkID:   p.SourceNetworkId.String(), // TODO: store the integer?
 		Metadata:          p.GetMetadata(),
+		TallyStrategy:     tallyStrategyName,
+		StartDate:         vochain.ProcessStartTime(p),
+		EndDate:           vochain.ProcessEndTime(p),
 
-		ResultsVotes: encodeVotes(indexertypes.NewEmptyVotes(int(options.MaxCount), int(options.MaxValue)+1)),
+		ResultsVotes: encodeVotes(tallyStrategy.NewVotes(options)),
 	}); err != nil {
 		return fmt.Errorf("sql create process: %w", err)
 	}
@@ -554,6 +587,7 @@ func (s *Scrutinizer) updateProcess(pid []byte) error {
 			if !ok {
 				return fmt.Errorf("record isn't the correct type! Wanted Process, got %T", record)
 			}
+			update.StartBlock = p.GetStartBlock()
 			update.EndBlock = p.GetBlockCount() + p.GetStartBlock()
 			update.CensusRoot = p.GetCensusRoot()
 			update.RollingCensusRoot = p.GetRollingCensusRoot()
@@ -609,6 +643,7 @@ func (s *Scrutinizer) updateProcess(pid []byte) error {
 	}
 	if _, err := queries.UpdateProcessFromState(ctx, scrutinizerdb.UpdateProcessFromStateParams{
 		ID:                pid,
+		StartBlock:        int64(p.GetStartBlock()),
 		EndBlock:          int64(p.GetBlockCount() + p.GetStartBlock()),
 		CensusRoot:        nonNullBytes(p.GetCensusRoot()),
 		RollingCensusRoot: nonNullBytes(p.GetRollingCensusRoot()),
@@ -9,6 +9,8 @@ INSERT INTO processes (
 	private_keys, public_keys,
 	question_index, creation_time,
 	source_block_height, source_network_id,
+	tally_strategy, envelope_type,
+	start_date, end_date,
 
 	results_votes, results_weight, results_envelope_height,
 	results_signatures, results_block_height
@@ -22,6 +24,8 @@ INSERT INTO processes (
 	?, ?,
 	?, ?,
 	?, ?,
+	?, ?,
+	?, ?,
 
 	?, "0", 0,
 	"", 0
@@ -50,9 +54,100 @@ LIMIT ?
 OFFSET ?
 ;
 
+-- name: FindProcesses :many
+-- The sort key is one of creationTime (the default), startBlock, endBlock or
+-- voteCount; ties are broken by id.  The cursor is the sort key and id of the
+-- last process of the previous page.
+SELECT id, sort_key FROM (
+	SELECT id, CASE sqlc.arg(sort_by)
+		WHEN 'startBlock' THEN start_block
+		WHEN 'endBlock' THEN end_block
+		WHEN 'voteCount' THEN vote_count
+		ELSE CAST(STRFTIME('%s', creation_time) AS INTEGER)
+	END AS sort_key
+	FROM processes
+	WHERE (sqlc.arg(text) = "" OR rowid IN (
+			SELECT docid FROM processes_fts WHERE processes_fts MATCH sqlc.arg(text)))
+		AND (LENGTH(sqlc.arg(entity_id)) = 0 OR LOWER(HEX(entity_id)) = sqlc.arg(entity_id))
+		AND (sqlc.arg(namespace) = 0 OR namespace = sqlc.arg(namespace))
+		AND (sqlc.arg(status) = 0 OR status = sqlc.arg(status))
+		AND (sqlc.arg(census_origin) = 0 OR census_origin = sqlc.arg(census_origin))
+		AND (envelope_type & sqlc.arg(envelope_mask)) = sqlc.arg(envelope_flags)
+		AND (sqlc.arg(created_after) = 0
+			OR CAST(STRFTIME('%s', creation_time) AS INTEGER) >= sqlc.arg(created_after))
+		AND (sqlc.arg(created_before) = 0
+			OR CAST(STRFTIME('%s', creation_time) AS INTEGER) < sqlc.arg(created_before))
+		AND vote_count >= sqlc.arg(min_votes)
+		AND (sqlc.arg(max_votes) < 0 OR vote_count <= sqlc.arg(max_votes))
+		AND (sqlc.arg(with_results) = FALSE OR have_results)
+)
+WHERE sqlc.arg(after_id) = ""
+	OR (sqlc.arg(descending) = FALSE AND (sort_key > sqlc.arg(after_key)
+		OR (sort_key = sqlc.arg(after_key) AND id > sqlc.arg(after_id))))
+	OR (sqlc.arg(descending) = TRUE AND (sort_key < sqlc.arg(after_key)
+		OR (sort_key = sqlc.arg(after_key) AND id < sqlc.arg(after_id))))
+ORDER BY
+	CASE WHEN sqlc.arg(descending) THEN -sort_key ELSE sort_key END,
+	CASE WHEN sqlc.arg(descending) THEN NULL ELSE id END ASC,
+	CASE WHEN sqlc.arg(descending) THEN id ELSE NULL END DESC
+LIMIT ?
+;
+
+-- name: CountProcessFacets :many
+-- Counts the processes matching the FindProcesses filters by status, census
+-- origin, envelope type flag and results availability.
+WITH matching AS (
+	SELECT status, census_origin, envelope_type, have_results
+	FROM processes
+	WHERE (sqlc.arg(text) = "" OR rowid IN (
+			SELECT docid FROM processes_fts WHERE processes_fts MATCH sqlc.arg(text)))
+		AND (LENGTH(sqlc.arg(entity_id)) = 0 OR LOWER(HEX(entity_id)) = sqlc.arg(entity_id))
+		AND (sqlc.arg(namespace) = 0 OR namespace = sqlc.arg(namespace))
+		AND (sqlc.arg(status) = 0 OR status = sqlc.arg(status))
+		AND (sqlc.arg(census_origin) = 0 OR census_origin = sqlc.arg(census_origin))
+		AND (envelope_type & sqlc.arg(envelope_mask)) = sqlc.arg(envelope_flags)
+		AND (sqlc.arg(created_after) = 0
+			OR CAST(STRFTIME('%s', creation_time) AS INTEGER) >= sqlc.arg(created_after))
+		AND (sqlc.arg(created_before) = 0
+			OR CAST(STRFTIME('%s', creation_time) AS INTEGER) < sqlc.arg(created_before))
+		AND vote_count >= sqlc.arg(min_votes)
+		AND (sqlc.arg(max_votes) < 0 OR vote_count <= sqlc.arg(max_votes))
+		AND (sqlc.arg(with_results) = FALSE OR have_results)
+)
+SELECT 'status' AS facet, status AS value, COUNT(*) AS count
+FROM matching GROUP BY status
+UNION ALL
+SELECT 'censusOrigin', census_origin, COUNT(*)
+FROM matching GROUP BY census_origin
+UNION ALL
+SELECT 'envelopeType', bits.bit, COUNT(*)
+FROM matching JOIN (
+	SELECT 1 AS bit UNION ALL SELECT 2 UNION ALL SELECT 4 UNION ALL SELECT 8 UNION ALL SELECT 16
+) AS bits ON envelope_type & bits.bit != 0
+GROUP BY bits.bit
+UNION ALL
+SELECT 'withResults', have_results, COUNT(*)
+FROM matching GROUP BY have_results
+;
+
+-- name: GetProcessesWithoutEnvelopeType :many
+SELECT id, envelope_pb FROM processes
+WHERE envelope_type < 0;
+
+-- name: SetProcessEnvelopeType :execresult
+UPDATE processes
+SET envelope_type = sqlc.arg(envelope_type)
+WHERE id = sqlc.arg(id);
+
+-- name: SetProcessSearchText :execresult
+UPDATE processes_fts
+SET title = sqlc.arg(title), description = sqlc.arg(description)
+WHERE docid = (SELECT rowid FROM processes WHERE id = sqlc.arg(id));
+
 -- name: UpdateProcessFromState :execresult
 UPDATE processes
-SET end_block           = sqlc.arg(end_block),
+SET start_block         = sqlc.arg(start_block),
+	end_block           = sqlc.arg(end_block),
 	census_root         = sqlc.arg(census_root),
 	rolling_census_root = sqlc.arg(rolling_census_root),
 	census_uri          = sqlc.arg(census_uri),
@@ -88,9 +183,19 @@ SET have_results = TRUE, final_results = TRUE,
 	results_weight = sqlc.arg(weight),
 	results_envelope_height = sqlc.arg(envelope_height),
 	results_signatures = sqlc.arg(signatures),
-	results_block_height = sqlc.arg(block_height)
+	results_block_height = sqlc.arg(block_height),
+	results_ranked_rounds = sqlc.arg(ranked_rounds),
+	tally_strategy = sqlc.arg(tally_strategy)
 WHERE id = sqlc.arg(id);
 
+-- name: SetProcessTallyStrategy :execresult
+UPDATE processes
+SET tally_strategy = sqlc.arg(tally_strategy),
+	results_votes = sqlc.arg(votes),
+	results_weight = sqlc.arg(weight),
+	results_envelope_height = sqlc.arg(envelope_height)
+WHERE id = sqlc.arg(id) AND final_results = FALSE;
+
 -- name: SetProcessResultsCancelled :execresult
 UPDATE processes
 SET have_results = FALSE, final_results = TRUE
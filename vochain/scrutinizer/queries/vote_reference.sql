@@ -1,11 +1,18 @@
 -- name: CreateVoteReference :execresult
 INSERT INTO vote_references (
 	nullifier, process_id, height, weight,
-	tx_index, voter_id, creation_time
+	tx_index, voter_id, creation_time, overwrite_count
 ) VALUES (
 	?, ?, ?, ?,
-	?, ?, ?
-);
+	?, ?, ?, ?
+)
+ON CONFLICT (nullifier) DO UPDATE SET
+	height = excluded.height,
+	weight = excluded.weight,
+	tx_index = excluded.tx_index,
+	voter_id = excluded.voter_id,
+	creation_time = excluded.creation_time,
+	overwrite_count = excluded.overwrite_count;
 
 -- name: GetVoteReference :one
 SELECT * FROM vote_references
@@ -0,0 +1,101 @@
+package scrutinizer
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// VoteReceipt returns the receipt of a vote, which can be verified offline
+// with client.VerifyVoteReceipt.  The receipt is built on the state committed
+// at height, or if height is 0 on the state of the previous block, which is
+// the last one with a signed block header.
+func (s *Scrutinizer) VoteReceipt(pid, nullifier []byte, height uint32) (*indexertypes.VoteReceipt, error) {
+	voteRef, err := s.GetEnvelopeReference(nullifier)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(voteRef.ProcessID, pid) {
+		return nil, fmt.Errorf("vote %x does not belong to process %x", nullifier, pid)
+	}
+	if height == 0 {
+		lastHeight, err := s.App.State.LastHeight()
+		if err != nil {
+			return nil, err
+		}
+		if lastHeight < 2 {
+			return nil, fmt.Errorf("not enough blocks to build a receipt")
+		}
+		height = lastHeight - 1
+	}
+	stx, err := s.App.GetTx(voteRef.Height, voteRef.TxIndex)
+	if err != nil {
+		return nil, err
+	}
+	tx := &models.Tx{}
+	if err := proto.Unmarshal(stx.Tx, tx); err != nil {
+		return nil, err
+	}
+	envelope := tx.GetVote()
+	if envelope == nil {
+		return nil, fmt.Errorf("transaction is not an Envelope")
+	}
+	process, err := s.App.State.Process(pid, true)
+	if err != nil {
+		return nil, err
+	}
+	// the vote as stored by State.AddVote
+	vote := &models.Vote{
+		Height:      voteRef.Height,
+		ProcessId:   pid,
+		Nullifier:   nullifier,
+		VotePackage: envelope.VotePackage,
+		Weight:      voteRef.Weight.Bytes(),
+	}
+	if process.EnvelopeType.EncryptedVotes {
+		vote.EncryptionKeyIndexes = envelope.EncryptionKeyIndexes
+	}
+	voteBytes, err := proto.Marshal(vote)
+	if err != nil {
+		return nil, err
+	}
+	voteHash, err := s.App.State.EnvelopeAt(pid, nullifier, height)
+	if err != nil {
+		return nil, fmt.Errorf("vote %x is not registered at height %d: %w", nullifier, height, err)
+	}
+	if !bytes.Equal(voteHash, ethereum.HashRaw(voteBytes)) {
+		return nil, fmt.Errorf("vote %x was overwritten or differs from the registered one", nullifier)
+	}
+	ops, err := s.App.State.VoteProof(pid, nullifier, height)
+	if err != nil {
+		return nil, fmt.Errorf("cannot prove vote %x: %w", nullifier, err)
+	}
+	header, validators, err := s.App.GetSignedHeader(int64(height) + 1)
+	if err != nil {
+		return nil, err
+	}
+	headerBytes, validatorsBytes, err := vochain.EncodeSignedHeader(header, validators)
+	if err != nil {
+		return nil, err
+	}
+	envelopeBytes, err := proto.Marshal(stx)
+	if err != nil {
+		return nil, err
+	}
+	return &indexertypes.VoteReceipt{
+		ChainID:      header.ChainID,
+		ProcessID:    pid,
+		Nullifier:    nullifier,
+		Envelope:     envelopeBytes,
+		Vote:         voteBytes,
+		StateHeight:  height,
+		StateProof:   ops,
+		SignedHeader: headerBytes,
+		Validators:   validatorsBytes,
+	}, nil
+}
@@ -0,0 +1,120 @@
+package scrutinizer
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/client"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestVoteReceipt(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	app.SetChainID("test")
+	sc, err := NewScrutinizer(t.TempDir(), app, true)
+	qt.Assert(t, err, qt.IsNil)
+	validatorsHash := testSignedHeaders(app)
+
+	pid := util.RandomBytes(32)
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EntityId:     util.RandomBytes(20),
+		StartBlock:   app.Height(),
+		BlockCount:   10,
+		EnvelopeType: &models.EnvelopeType{},
+		Status:       models.ProcessStatus_READY,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 2},
+		Mode:         &models.ProcessMode{AutoStart: true},
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	vp, err := json.Marshal(vochain.VotePackage{Votes: []int{1, 2}})
+	qt.Assert(t, err, qt.IsNil)
+	nullifier := util.RandomBytes(32)
+	app.State.TxCounterAdd()
+	qt.Assert(t, app.State.AddVote(&models.Vote{
+		ProcessId:   pid,
+		Nullifier:   nullifier,
+		VotePackage: vp,
+		Weight:      big.NewInt(1).Bytes(),
+	}, types.VoterID{}.Nil()), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	// there is no block store, so the vote transaction is mocked
+	tx, err := proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: &models.VoteEnvelope{
+		ProcessId:   pid,
+		Nullifier:   nullifier,
+		VotePackage: vp,
+	}}})
+	qt.Assert(t, err, qt.IsNil)
+	app.SetFnGetTx(func(height uint32, txIndex int32) (*models.SignedTx, error) {
+		return &models.SignedTx{Tx: tx}, nil
+	})
+
+	// the vote is not yet in the state of the last header
+	_, err = sc.VoteReceipt(pid, nullifier, 0)
+	qt.Assert(t, err, qt.IsNotNil)
+	app.AdvanceTestBlock()
+
+	receipt, err := sc.VoteReceipt(pid, nullifier, 0)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = sc.VoteReceipt(util.RandomBytes(32), nullifier, 0)
+	qt.Assert(t, err, qt.IsNotNil)
+
+	// the receipt is verified after a JSON round trip
+	data, err := json.Marshal(receipt)
+	qt.Assert(t, err, qt.IsNil)
+	decode := func() *indexertypes.VoteReceipt {
+		receipt := &indexertypes.VoteReceipt{}
+		qt.Assert(t, json.Unmarshal(data, receipt), qt.IsNil)
+		return receipt
+	}
+	vote, err := client.VerifyVoteReceipt(decode(), validatorsHash)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, vote.VotePackage, qt.DeepEquals, vp)
+
+	// the validator set must be the trusted one
+	_, err = client.VerifyVoteReceipt(decode(), nil)
+	qt.Assert(t, err, qt.ErrorMatches, "a trusted validators hash.*")
+	_, err = client.VerifyVoteReceipt(decode(), util.RandomBytes(32))
+	qt.Assert(t, err, qt.ErrorMatches, "validator set hash .* is not the trusted one")
+
+	// tampered receipts
+	tampered := decode()
+	tampered.Nullifier = util.RandomBytes(32)
+	_, err = client.VerifyVoteReceipt(tampered, validatorsHash)
+	qt.Assert(t, err, qt.ErrorMatches, "vote .* not found in the state proof")
+
+	tampered = decode()
+	otherVote := &models.Vote{}
+	qt.Assert(t, proto.Unmarshal(tampered.Vote, otherVote), qt.IsNil)
+	otherVote.VotePackage = []byte(`{"votes":[2,2]}`)
+	tampered.Vote, err = proto.Marshal(otherVote)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = client.VerifyVoteReceipt(tampered, validatorsHash)
+	qt.Assert(t, err, qt.ErrorMatches, "vote hash.*")
+
+	tampered = decode()
+	otherTx, err := proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: &models.VoteEnvelope{
+		ProcessId:   pid,
+		Nullifier:   nullifier,
+		VotePackage: []byte(`{"votes":[2,2]}`),
+	}}})
+	qt.Assert(t, err, qt.IsNil)
+	tampered.Envelope, err = proto.Marshal(&models.SignedTx{Tx: otherTx})
+	qt.Assert(t, err, qt.IsNil)
+	_, err = client.VerifyVoteReceipt(tampered, validatorsHash)
+	qt.Assert(t, err, qt.ErrorMatches, "envelope does not match.*")
+
+	tampered = decode()
+	tampered.StateHeight--
+	_, err = client.VerifyVoteReceipt(tampered, validatorsHash)
+	qt.Assert(t, err, qt.IsNotNil)
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/dgraph-io/badger/v3"
 	"github.com/timshannon/badgerhold/v3"
+	"go.vocdoni.io/dvote/data"
 	"go.vocdoni.io/dvote/db/lru"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
@@ -68,6 +69,9 @@ type Scrutinizer struct {
 	voteIndexPool []*VoteWithIndex
 	// votePool is the list of votes that should be live counted, grouped by processId
 	votePool map[string][]*models.Vote
+	// overwrittenVotes holds the votes already committed that are overwritten
+	// on the current block, by nullifier, to subtract them from the live results
+	overwrittenVotes map[string]*models.Vote
 	// newProcessPool is the list of new process IDs on the current block
 	newProcessPool []*indexertypes.ScrutinizerOnProcessData
 	// updateProcessPool is the list of process IDs that require sync with the state database
@@ -101,19 +105,31 @@ type Scrutinizer struct {
 	recoveryBootLock sync.RWMutex
 	// ignoreLiveResults if true, partial/live results won't be calculated (only final results)
 	ignoreLiveResults bool
+	// storage retrieves the process metadata, which picks the tally strategy
+	// and is indexed for the full-text search
+	storage data.Storage
+	// pendingMetadata holds the process metadata retrieved since the last
+	// commit, by processId
+	pendingMetadata     map[string]*fetchedMetadata
+	pendingMetadataLock sync.Mutex
 }
 
 // VoteWithIndex holds a Vote and a txIndex. Model for the VotePool.
 type VoteWithIndex struct {
-	vote    *models.Vote
-	voterID types.VoterID
-	txIndex int32
+	vote           *models.Vote
+	voterID        types.VoterID
+	txIndex        int32
+	overwriteCount uint32
 }
 
 // NewScrutinizer returns an instance of the Scrutinizer
 // using the local storage database of dbPath and integrated into the state vochain instance
 func NewScrutinizer(dbPath string, app *vochain.BaseApplication, countLiveResults bool) (*Scrutinizer, error) {
-	s := &Scrutinizer{App: app, ignoreLiveResults: !countLiveResults}
+	s := &Scrutinizer{
+		App:               app,
+		ignoreLiveResults: !countLiveResults,
+		pendingMetadata:   make(map[string]*fetchedMetadata),
+	}
 	var err error
 	s.db, err = InitDB(dbPath)
 	if err != nil {
@@ -150,6 +166,9 @@ func NewScrutinizer(dbPath string, app *vochain.BaseApplication, countLiveResult
 	if err := goose.Up(s.sqlDB, "migrations"); err != nil {
 		return nil, fmt.Errorf("goose up: %w", err)
 	}
+	if err := s.indexEnvelopeTypes(); err != nil {
+		return nil, fmt.Errorf("could not index envelope types: %w", err)
+	}
 
 	// Subscrive to events
 	s.App.State.AddEventListener(s)
@@ -301,31 +320,45 @@ func (s *Scrutinizer) AfterSyncBootstrap() {
 			log.Errorf("cannot fetch process: %v", err)
 			continue
 		}
+		if vochain.ProcessDelegatedVoting(process.Mode) {
+			continue
+		}
+		indexedProcess, err := s.ProcessInfo(p)
+		if err != nil {
+			log.Errorf("cannot fetch indexed process: %v", err)
+			continue
+		}
 		options := process.GetVoteOptions()
+		// Count the votes, add them to results (in memory, without any db transaction)
+		results := &indexertypes.Results{
+			Weight:        new(types.BigInt).SetUint64(0),
+			VoteOpts:      options,
+			EnvelopeType:  process.EnvelopeType,
+			TallyStrategy: indexedProcess.TallyStrategy,
+		}
+		strategy, err := resultsTallyStrategy(results)
+		if err != nil {
+			log.Errorf("cannot get tally strategy: %v", err)
+			continue
+		}
 		if err := s.queryWithRetries(func() error {
 			return s.db.Upsert(p, &indexertypes.Results{
-				ProcessID: p,
-				// MaxValue requires +1 since 0 is also an option
-				Votes:        indexertypes.NewEmptyVotes(int(options.MaxCount), int(options.MaxValue)+1),
-				Weight:       new(types.BigInt).SetUint64(0),
-				VoteOpts:     options,
-				EnvelopeType: process.GetEnvelopeType(),
-				Signatures:   []types.HexBytes{},
+				ProcessID:     p,
+				Votes:         strategy.NewVotes(options),
+				Weight:        new(types.BigInt).SetUint64(0),
+				VoteOpts:      options,
+				EnvelopeType:  process.GetEnvelopeType(),
+				Signatures:    []types.HexBytes{},
+				TallyStrategy: indexedProcess.TallyStrategy,
 			})
 		}); err != nil {
 			log.Errorf("cannot upsert results to db: %v", err)
 			continue
 		}
 
-		// Count the votes, add them to results (in memory, without any db transaction)
-		results := &indexertypes.Results{
-			Weight:       new(types.BigInt).SetUint64(0),
-			VoteOpts:     options,
-			EnvelopeType: process.EnvelopeType,
-		}
 		if err := s.WalkEnvelopes(p, false, func(vote *models.VoteEnvelope, weight *big.Int) {
 			if err := s.addLiveVote(vote.ProcessId, vote.VotePackage,
-				weight, results); err != nil {
+				weight, nil, results); err != nil {
 				log.Warn(err)
 			}
 		}); err != nil {
@@ -345,13 +378,18 @@ func (s *Scrutinizer) AfterSyncBootstrap() {
 
 // Commit is called by the APP when a block is confirmed and included into the chain
 func (s *Scrutinizer) Commit(height uint32) error {
+	// Set the tally strategies and search text of the process metadata
+	s.applyProcessMetadata()
+
 	// Add Entity and register new active process
 	for _, p := range s.newProcessPool {
 		if err := s.newEmptyProcess(p.ProcessID); err != nil {
 			log.Errorf("commit: cannot create new empty process: %v", err)
 			continue
 		}
-		if !s.App.IsSynchronizing() {
+		// the votes of delegated voting processes are counted once the
+		// process ends, as a later vote can override a delegated one
+		if !s.App.IsSynchronizing() && !s.isDelegatedVotingProcess(p.ProcessID) {
 			s.addProcessToLiveResults(p.ProcessID)
 		}
 	}
@@ -386,11 +424,19 @@ func (s *Scrutinizer) Commit(height uint32) error {
 			v.vote.Weight,
 			v.txIndex,
 			v.voterID,
+			v.overwriteCount,
 			txn); err != nil {
 			log.Warn(err)
 		}
 	}
 	if len(s.voteIndexPool) > 0 {
+		// the overwritten votes don't add new envelopes
+		newEnvelopes := uint64(0)
+		for _, v := range s.voteIndexPool {
+			if v.overwriteCount == 0 {
+				newEnvelopes++
+			}
+		}
 		s.voteTxLock.Lock()
 		wg := sync.WaitGroup{}
 		wg.Add(1)
@@ -413,7 +459,7 @@ func (s *Scrutinizer) Commit(height uint32) error {
 					if !ok {
 						return fmt.Errorf("record isn't the correct type! Wanted CountStore, got %T", record)
 					}
-					update.Count += uint64(len(s.voteIndexPool))
+					update.Count += newEnvelopes
 					return nil
 				},
 			); err != nil {
@@ -437,15 +483,17 @@ func (s *Scrutinizer) Commit(height uint32) error {
 		// This is a temporary "results" for computing votes
 		// of a single processId for the current block.
 		results := &indexertypes.Results{
-			Weight:       new(types.BigInt).SetUint64(0),
-			VoteOpts:     proc.VoteOpts,
-			EnvelopeType: proc.Envelope,
+			Weight:        new(types.BigInt).SetUint64(0),
+			VoteOpts:      proc.VoteOpts,
+			EnvelopeType:  proc.Envelope,
+			TallyStrategy: proc.TallyStrategy,
 		}
 		for _, v := range votes {
 			if err := s.addLiveVote(v.ProcessId,
 				v.VotePackage,
 				// TBD: Not 100% sure what happens if weight=nil
 				new(big.Int).SetBytes(v.GetWeight()),
+				s.overwrittenVotes[string(v.Nullifier)],
 				results); err != nil {
 				log.Warnf("vote cannot be added: %v", err)
 			} else {
@@ -476,6 +524,7 @@ func (s *Scrutinizer) Commit(height uint32) error {
 // Rollback removes the non committed pending operations
 func (s *Scrutinizer) Rollback() {
 	s.votePool = make(map[string][]*models.Vote)
+	s.overwrittenVotes = make(map[string]*models.Vote)
 	s.voteIndexPool = []*VoteWithIndex{}
 	s.newProcessPool = []*indexertypes.ScrutinizerOnProcessData{}
 	s.resultsPool = []*indexertypes.ScrutinizerOnProcessData{}
@@ -493,11 +542,45 @@ func (s *Scrutinizer) OnProcess(pid, eid []byte, censusRoot, censusURI string, t
 // and the blockchain is not synchronizing.
 // voterID is the identifier of the voter, the most common case is an ethereum address
 // but can be any kind of id expressed as bytes.
+// If the vote overwrites a previous vote of the same nullifier, the previous
+// vote is replaced on the live results.
 func (s *Scrutinizer) OnVote(v *models.Vote, voterID types.VoterID, txIndex int32) {
+	overwriteCount, err := s.App.State.VoteOverwriteCount(v.ProcessId, v.Nullifier, false)
+	if err != nil && !errors.Is(err, vochain.ErrVoteDoesNotExist) &&
+		!errors.Is(err, vochain.ErrProcessNotFound) {
+		log.Warnf("cannot get overwrite count of vote %x: %v", v.Nullifier, err)
+	}
 	if !s.ignoreLiveResults && s.isProcessLiveResults(v.ProcessId) {
+		if overwriteCount > 0 {
+			s.overwriteLiveVote(v)
+		}
 		s.votePool[string(v.ProcessId)] = append(s.votePool[string(v.ProcessId)], v)
 	}
-	s.voteIndexPool = append(s.voteIndexPool, &VoteWithIndex{vote: v, voterID: voterID, txIndex: txIndex})
+	s.voteIndexPool = append(s.voteIndexPool, &VoteWithIndex{
+		vote:           v,
+		voterID:        voterID,
+		txIndex:        txIndex,
+		overwriteCount: overwriteCount,
+	})
+}
+
+// overwriteLiveVote removes the previous vote of the nullifier from the votes
+// pending to be live counted.  If the previous vote is already committed, it
+// is kept in overwrittenVotes to subtract it from the live results.
+func (s *Scrutinizer) overwriteLiveVote(v *models.Vote) {
+	votes := s.votePool[string(v.ProcessId)]
+	for i, pending := range votes {
+		if bytes.Equal(pending.Nullifier, v.Nullifier) {
+			s.votePool[string(v.ProcessId)] = append(votes[:i], votes[i+1:]...)
+			return
+		}
+	}
+	previous, err := s.committedVote(v.Nullifier)
+	if err != nil {
+		log.Warnf("cannot get overwritten vote %x: %v", v.Nullifier, err)
+		return
+	}
+	s.overwrittenVotes[string(v.Nullifier)] = previous
 }
 
 // OnCancel scrutinizer stores the processID and entityID
@@ -246,6 +246,41 @@ func testProcessList(t *testing.T, procsCount int) {
 	}
 }
 
+func TestProcessDates(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	sc, err := NewScrutinizer(t.TempDir(), app, true)
+	qt.Assert(t, err, qt.IsNil)
+
+	newProcess := func() *models.Process {
+		return &models.Process{
+			ProcessId:    util.RandomBytes(32),
+			EntityId:     util.RandomBytes(20),
+			VoteOptions:  &models.ProcessVoteOptions{MaxCount: 8, MaxValue: 3},
+			EnvelopeType: &models.EnvelopeType{},
+		}
+	}
+	byHeight := newProcess()
+	qt.Assert(t, app.State.AddProcess(byHeight), qt.IsNil)
+	byTime := newProcess()
+	start, end := time.Unix(1700000000, 0), time.Unix(1700003600, 0)
+	vochain.SetProcessTimes(byTime, start, end)
+	qt.Assert(t, app.State.AddProcess(byTime), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	// the processes scheduled by block height have no dates
+	proc, err := sc.ProcessInfo(byHeight.ProcessId)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proc.StartDate, qt.IsNil)
+	qt.Assert(t, proc.EndDate, qt.IsNil)
+
+	proc, err = sc.ProcessInfo(byTime.ProcessId)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proc.StartDate, qt.Not(qt.IsNil))
+	qt.Assert(t, proc.StartDate.Equal(start), qt.IsTrue)
+	qt.Assert(t, proc.EndDate, qt.Not(qt.IsNil))
+	qt.Assert(t, proc.EndDate.Equal(end), qt.IsTrue)
+}
+
 func TestProcessSearch(t *testing.T) {
 	app := vochain.TestBaseApplication(t)
 	sc, err := NewScrutinizer(t.TempDir(), app, true)
@@ -637,6 +672,7 @@ func TestLiveResults(t *testing.T) {
 			pid,
 			vp,
 			new(big.Int).SetUint64(1),
+			nil,
 			r),
 			qt.IsNil)
 	}
@@ -669,6 +705,106 @@ func TestLiveResults(t *testing.T) {
 	}
 }
 
+func TestLiveResultsVoteOverwrite(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	sc, err := NewScrutinizer(t.TempDir(), app, true)
+	qt.Assert(t, err, qt.IsNil)
+
+	// there is no block store, so keep the vote transactions of each block
+	txs := make(map[string]*models.SignedTx)
+	pending := make(map[string]*models.SignedTx)
+	txKey := func(height uint32, txIndex int32) string { return fmt.Sprintf("%d/%d", height, txIndex) }
+	getTx := func(height uint32, txIndex int32) (*models.SignedTx, error) {
+		stx, ok := txs[txKey(height, txIndex)]
+		if !ok {
+			return nil, fmt.Errorf("tx %d/%d not found", height, txIndex)
+		}
+		return stx, nil
+	}
+	app.SetFnGetTx(getTx)
+	app.SetFnGetTxHash(func(height uint32, txIndex int32) (*models.SignedTx, []byte, error) {
+		stx, err := getTx(height, txIndex)
+		return stx, nil, err
+	})
+
+	pid := util.RandomBytes(32)
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EnvelopeType: &models.EnvelopeType{},
+		Status:       models.ProcessStatus_READY,
+		BlockCount:   10,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 2, MaxVoteOverwrites: 2},
+		Mode:         &models.ProcessMode{AutoStart: true},
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	vote := func(nullifier []byte, value int) error {
+		vp, err := json.Marshal(vochain.VotePackage{Votes: []int{value}})
+		qt.Assert(t, err, qt.IsNil)
+		tx, err := proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: &models.VoteEnvelope{
+			ProcessId:   pid,
+			Nullifier:   nullifier,
+			VotePackage: vp,
+		}}})
+		qt.Assert(t, err, qt.IsNil)
+		app.State.TxCounterAdd()
+		if err := app.State.AddVote(&models.Vote{
+			ProcessId:   pid,
+			Nullifier:   nullifier,
+			VotePackage: vp,
+			Weight:      big.NewInt(1).Bytes(),
+		}, types.VoterID{}.Nil()); err != nil {
+			return err
+		}
+		pending[string(nullifier)] = &models.SignedTx{Tx: tx}
+		return nil
+	}
+	commit := func() {
+		app.AdvanceTestBlock()
+		for nullifier, stx := range pending {
+			ref, err := sc.GetEnvelopeReference([]byte(nullifier))
+			qt.Assert(t, err, qt.IsNil)
+			txs[txKey(ref.Height, ref.TxIndex)] = stx
+		}
+		pending = make(map[string]*models.SignedTx)
+	}
+	results := func() []string {
+		r, err := sc.GetResults(pid)
+		qt.Assert(t, err, qt.IsNil)
+		return GetFriendlyResults(r.Votes)[0]
+	}
+
+	n1, n2 := util.RandomBytes(32), util.RandomBytes(32)
+	qt.Assert(t, vote(n1, 0), qt.IsNil)
+	qt.Assert(t, vote(n2, 0), qt.IsNil)
+	commit()
+	qt.Assert(t, results(), qt.DeepEquals, []string{"2", "0", "0"})
+
+	// overwrite a vote of a previous block
+	qt.Assert(t, vote(n1, 1), qt.IsNil)
+	commit()
+	qt.Assert(t, results(), qt.DeepEquals, []string{"1", "1", "0"})
+
+	// overwrite twice on the same block, only the last one is counted
+	qt.Assert(t, vote(n2, 2), qt.IsNil)
+	qt.Assert(t, vote(n2, 1), qt.IsNil)
+	qt.Assert(t, vote(n2, 0), qt.ErrorMatches, ".*maximum number of overwrites.*")
+	commit()
+	qt.Assert(t, results(), qt.DeepEquals, []string{"0", "2", "0"})
+
+	height, err := sc.GetEnvelopeHeight(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, height, qt.Equals, uint64(2))
+	height, err = sc.GetEnvelopeHeight(nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, height, qt.Equals, uint64(2))
+	for nullifier, count := range map[string]uint32{string(n1): 1, string(n2): 2} {
+		envelope, err := sc.GetEnvelope([]byte(nullifier))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, envelope.Meta.OverwriteCount, qt.Equals, count)
+	}
+}
+
 func TestAddVote(t *testing.T) {
 	app := vochain.TestBaseApplication(t)
 
@@ -762,16 +898,15 @@ var vote = func(v []int, sc *Scrutinizer, pid []byte, weight *big.Int) error {
 		return err
 	}
 	r := &indexertypes.Results{
-		ProcessID: pid,
-		Votes: indexertypes.NewEmptyVotes(
-			int(proc.VoteOpts.MaxCount), int(proc.VoteOpts.MaxValue)+1),
-		Weight:       new(types.BigInt).SetUint64(0),
-		Signatures:   []types.HexBytes{},
-		VoteOpts:     proc.VoteOpts,
-		EnvelopeType: proc.Envelope,
+		ProcessID:     pid,
+		Weight:        new(types.BigInt).SetUint64(0),
+		Signatures:    []types.HexBytes{},
+		VoteOpts:      proc.VoteOpts,
+		EnvelopeType:  proc.Envelope,
+		TallyStrategy: proc.TallyStrategy,
 	}
 	sc.addProcessToLiveResults(pid)
-	if err := sc.addLiveVote(pid, vp, weight, r); err != nil {
+	if err := sc.addLiveVote(pid, vp, weight, nil, r); err != nil {
 		return err
 	}
 	return sc.commitVotes(pid, r, 1)
@@ -911,6 +1046,111 @@ func TestBallotProtocolMultiChoice(t *testing.T) {
 	qt.Assert(t, votes[4], qt.DeepEquals, []string{"3", "0"})
 }
 
+func TestBallotProtocolRankedChoice(t *testing.T) {
+	// Rank 4 candidates, tallied by instant runoff
+	app := vochain.TestBaseApplication(t)
+
+	sc, err := NewScrutinizer(t.TempDir(), app, true)
+	qt.Assert(t, err, qt.IsNil)
+	storage, uri := testMetadataStorage(t, "ranked-choice", 0)
+	sc.SetMetadataStorage(storage)
+
+	pid := util.RandomBytes(32)
+	err = app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EnvelopeType: &models.EnvelopeType{UniqueValues: true},
+		Status:       models.ProcessStatus_READY,
+		Mode:         &models.ProcessMode{AutoStart: true},
+		BlockCount:   10,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 4, MaxValue: 3},
+		Metadata:     &uri,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	app.AdvanceTestBlock()
+
+	// Ballots (candidates in order of preference):
+	//  4 x [0,1,2], 3 x [1,2], 2 x [2,1], 1 x [3,2]
+	//
+	// Round 1: [4,3,2,1] no majority, candidate 3 is eliminated
+	// Round 2: [4,3,3,0] candidates 1 and 2 tie, 2 had less votes in round 1
+	// Round 3: [4,5,0,0] one ballot exhausted, candidate 1 wins with 5 of 9
+	ballots := [][]int{
+		{0, 1, 2}, {0, 1, 2}, {0, 1, 2}, {0, 1, 2},
+		{1, 2}, {1, 2}, {1, 2},
+		{2, 1}, {2, 1},
+		{3, 2},
+	}
+	for i, ballot := range ballots {
+		sc.Rollback()
+		vp, err := json.Marshal(vochain.VotePackage{
+			Nonce: fmt.Sprintf("%x", util.RandomBytes(32)),
+			Votes: ballot,
+		})
+		qt.Assert(t, err, qt.IsNil)
+		vote := &models.VoteEnvelope{
+			Nonce:       util.RandomBytes(32),
+			ProcessId:   pid,
+			VotePackage: vp,
+			Nullifier:   util.RandomBytes(32),
+		}
+		voteTx, err := proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: vote}})
+		qt.Assert(t, err, qt.IsNil)
+		signedTx, err := proto.Marshal(&models.SignedTx{Tx: voteTx, Signature: []byte{}})
+		qt.Assert(t, err, qt.IsNil)
+		_, err = app.SendTx(signedTx)
+		qt.Assert(t, err, qt.IsNil)
+		sc.voteIndexPool = append(sc.voteIndexPool, &VoteWithIndex{
+			vote: &models.Vote{
+				Nullifier: vote.Nullifier,
+				ProcessId: pid,
+				Weight:    big.NewInt(1).Bytes(),
+			},
+			voterID: types.VoterID{}.Nil(),
+			txIndex: 0,
+		})
+		qt.Assert(t, sc.Commit(uint32(i)), qt.IsNil)
+	}
+
+	qt.Assert(t, sc.setResultsHeight(pid, app.State.CurrentHeight()), qt.IsNil)
+	qt.Assert(t, sc.ComputeResult(pid), qt.IsNil)
+	result, err := sc.GetResults(pid)
+	qt.Assert(t, err, qt.IsNil)
+
+	// The results matrix counts the candidates chosen at each position
+	votes := GetFriendlyResults(result.Votes)
+	qt.Assert(t, votes[0], qt.DeepEquals, []string{"4", "3", "2", "1"})
+	qt.Assert(t, votes[1], qt.DeepEquals, []string{"0", "6", "4", "0"})
+	qt.Assert(t, votes[2], qt.DeepEquals, []string{"0", "0", "4", "0"})
+	qt.Assert(t, votes[3], qt.DeepEquals, []string{"0", "0", "0", "0"})
+
+	rounds := []struct {
+		tally      []string
+		exhausted  string
+		eliminated int
+		winner     int
+	}{
+		{[]string{"4", "3", "2", "1"}, "0", 3, -1},
+		{[]string{"4", "3", "3", "0"}, "0", 2, -1},
+		{[]string{"4", "5", "0", "0"}, "1", -1, 1},
+	}
+	qt.Assert(t, result.RankedRounds, qt.HasLen, len(rounds))
+	for i, expected := range rounds {
+		round := result.RankedRounds[i]
+		tally := []string{}
+		for _, v := range round.Tally {
+			tally = append(tally, v.String())
+		}
+		qt.Assert(t, tally, qt.DeepEquals, expected.tally, qt.Commentf("round %d", i))
+		qt.Assert(t, round.Exhausted.String(), qt.Equals, expected.exhausted)
+		qt.Assert(t, round.Eliminated, qt.Equals, expected.eliminated)
+		qt.Assert(t, round.Winner, qt.Equals, expected.winner)
+	}
+
+	// The rounds are not part of the ProcessResult sent to the Vochain
+	processResult := BuildProcessResult(result, nil)
+	qt.Assert(t, vochain.GetFriendlyResults(processResult.Votes), qt.DeepEquals, votes)
+}
+
 func TestCountVotes(t *testing.T) {
 	app := vochain.TestBaseApplication(t)
 	sc, err := NewScrutinizer(t.TempDir(), app, true)
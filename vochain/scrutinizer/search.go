@@ -0,0 +1,223 @@
+package scrutinizer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	scrutinizerdb "go.vocdoni.io/dvote/vochain/scrutinizer/db"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// MaxSearchLimit is the maximum number of processes returned by a search
+const MaxSearchLimit = 100
+
+// SearchProcesses returns a page of the processes matching the search
+// filters, and optionally the facet counts of all the matching processes.
+// Only the sqlite database supports searching.
+func (s *Scrutinizer) SearchProcesses(search *indexertypes.ProcessSearch) (*indexertypes.ProcessSearchResult, error) {
+	if search.Limit <= 0 || search.Limit > MaxSearchLimit {
+		return nil, fmt.Errorf("searchProcesses: limit must be between 1 and %d", MaxSearchLimit)
+	}
+	params, err := searchParams(search)
+	if err != nil {
+		return nil, fmt.Errorf("searchProcesses: %w", err)
+	}
+	afterKey, afterID := int64(0), types.ProcessID(nil)
+	if search.Cursor != "" {
+		if afterKey, afterID, err = parseSearchCursor(search.Cursor); err != nil {
+			return nil, fmt.Errorf("searchProcesses: %w", err)
+		}
+	}
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+
+	startTime := time.Now()
+	// fetch one more process to know if there is a next page
+	rows, err := queries.FindProcesses(ctx, scrutinizerdb.FindProcessesParams{
+		SortBy:        search.SortBy,
+		Text:          params.Text,
+		EntityID:      params.EntityID,
+		Namespace:     params.Namespace,
+		Status:        params.Status,
+		CensusOrigin:  params.CensusOrigin,
+		EnvelopeMask:  params.EnvelopeMask,
+		EnvelopeFlags: params.EnvelopeFlags,
+		CreatedAfter:  params.CreatedAfter,
+		CreatedBefore: params.CreatedBefore,
+		MinVotes:      params.MinVotes,
+		MaxVotes:      params.MaxVotes,
+		WithResults:   params.WithResults,
+		AfterID:       afterID,
+		Descending:    search.Descending,
+		AfterKey:      afterKey,
+		Limit:         int32(search.Limit + 1),
+	})
+	log.Debugf("SearchProcesses sqlite took %s", time.Since(startTime))
+	if err != nil {
+		return nil, fmt.Errorf("searchProcesses: %w", err)
+	}
+	result := &indexertypes.ProcessSearchResult{ProcessIDs: []types.HexBytes{}}
+	for i, row := range rows {
+		if i == search.Limit {
+			last := rows[i-1]
+			key, ok := last.SortKey.(int64)
+			if !ok {
+				return nil, fmt.Errorf("searchProcesses: unexpected sort key %v", last.SortKey)
+			}
+			result.NextCursor = fmt.Sprintf("%d-%x", key, last.ID)
+			break
+		}
+		result.ProcessIDs = append(result.ProcessIDs, types.HexBytes(row.ID))
+	}
+	if search.Facets {
+		if result.Facets, err = s.processFacets(params); err != nil {
+			return nil, fmt.Errorf("searchProcesses: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// processFacets returns the facet counts of the processes matching params.
+func (s *Scrutinizer) processFacets(params *scrutinizerdb.CountProcessFacetsParams) (*indexertypes.ProcessFacets, error) {
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	rows, err := queries.CountProcessFacets(ctx, *params)
+	if err != nil {
+		return nil, err
+	}
+	facets := &indexertypes.ProcessFacets{
+		Status:       make(map[string]uint64),
+		CensusOrigin: make(map[string]uint64),
+		Envelope:     make(map[string]uint64),
+	}
+	for _, row := range rows {
+		count := uint64(row.Count)
+		switch row.Facet {
+		case "status":
+			facets.Status[models.ProcessStatus(row.Value).String()] = count
+			facets.Total += count
+		case "censusOrigin":
+			facets.CensusOrigin[models.CensusOrigin(row.Value).String()] = count
+		case "envelopeType":
+			facets.Envelope[indexertypes.EnvelopeTypeNames[row.Value]] = count
+		case "withResults":
+			if row.Value != 0 {
+				facets.WithResults = count
+			}
+		}
+	}
+	return facets, nil
+}
+
+// searchParams validates the search filters and returns them as query
+// parameters, which are shared by the process and facet queries.
+func searchParams(search *indexertypes.ProcessSearch) (*scrutinizerdb.CountProcessFacetsParams, error) {
+	switch search.SortBy {
+	case "", indexertypes.SortByCreationTime, indexertypes.SortByStartBlock,
+		indexertypes.SortByEndBlock, indexertypes.SortByVoteCount:
+	default:
+		return nil, fmt.Errorf("unknown sort key %q", search.SortBy)
+	}
+	params := &scrutinizerdb.CountProcessFacetsParams{
+		Text:          search.Text,
+		EntityID:      hex.EncodeToString(search.EntityID), // NOTE: we search as hex string instead of []byte; see sqlc.yaml
+		Namespace:     int64(search.Namespace),
+		MinVotes:      int64(search.MinVotes),
+		MaxVotes:      -1,
+		WithResults:   search.WithResults,
+		CreatedAfter:  int64(0),
+		CreatedBefore: int64(0),
+	}
+	if search.Status != "" {
+		status, ok := models.ProcessStatus_value[search.Status]
+		if !ok {
+			return nil, fmt.Errorf("status %s is unknown", search.Status)
+		}
+		params.Status = int64(status)
+	}
+	if search.CensusOrigin != "" {
+		origin, ok := models.CensusOrigin_value[search.CensusOrigin]
+		if !ok {
+			return nil, fmt.Errorf("census origin %s is unknown", search.CensusOrigin)
+		}
+		params.CensusOrigin = int64(origin)
+	}
+	mask, flags := int64(0), int64(0)
+	for name, set := range search.Envelope {
+		bit := int64(0)
+		for b, n := range indexertypes.EnvelopeTypeNames {
+			if n == name {
+				bit = b
+			}
+		}
+		if bit == 0 {
+			return nil, fmt.Errorf("envelope type %s is unknown", name)
+		}
+		mask |= bit
+		if set {
+			flags |= bit
+		}
+	}
+	params.EnvelopeMask, params.EnvelopeFlags = mask, flags
+	if search.CreatedAfter != nil {
+		params.CreatedAfter = search.CreatedAfter.Unix()
+	}
+	if search.CreatedBefore != nil {
+		params.CreatedBefore = search.CreatedBefore.Unix()
+	}
+	if search.MaxVotes != nil {
+		params.MaxVotes = int64(*search.MaxVotes)
+	}
+	return params, nil
+}
+
+// parseSearchCursor decodes a search cursor, encoded as sortKey-processID.
+func parseSearchCursor(cursor string) (int64, types.ProcessID, error) {
+	keyStr, idStr, found := strings.Cut(cursor, "-")
+	if !found {
+		return 0, nil, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	key, err := strconv.ParseInt(keyStr, 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid cursor key: %w", err)
+	}
+	id, err := hex.DecodeString(idStr)
+	if err != nil || len(id) == 0 {
+		return 0, nil, fmt.Errorf("invalid cursor process id %q", idStr)
+	}
+	return key, id, nil
+}
+
+// indexEnvelopeTypes sets the envelope type bitmask of the processes indexed
+// before it was added to the database.
+func (s *Scrutinizer) indexEnvelopeTypes() error {
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	procs, err := queries.GetProcessesWithoutEnvelopeType(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range procs {
+		envelope := &models.EnvelopeType{}
+		if err := proto.Unmarshal(p.EnvelopePb, envelope); err != nil {
+			return fmt.Errorf("cannot decode envelope type of process %x: %w", p.ID, err)
+		}
+		if _, err := queries.SetProcessEnvelopeType(ctx, scrutinizerdb.SetProcessEnvelopeTypeParams{
+			EnvelopeType: indexertypes.EnvelopeTypeBits(envelope),
+			ID:           p.ID,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(procs) > 0 {
+		log.Infof("indexed the envelope type of %d processes", len(procs))
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package scrutinizer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/data"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	models "go.vocdoni.io/proto/build/go/models"
+)
+
+func TestSearchProcesses(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	sc, err := NewScrutinizer(t.TempDir(), app, true)
+	qt.Assert(t, err, qt.IsNil)
+
+	// five processes of two entities, one per block
+	eid1, eid2 := util.RandomBytes(20), util.RandomBytes(20)
+	pids := [][]byte{}
+	for i, p := range []struct {
+		eid       []byte
+		censusURI string
+		envelope  *models.EnvelopeType
+		origin    models.CensusOrigin
+	}{
+		{eid1, "ipfs://alpha", &models.EnvelopeType{}, models.CensusOrigin_OFF_CHAIN_TREE},
+		{eid1, "ipfs://beta", &models.EnvelopeType{EncryptedVotes: true}, models.CensusOrigin_OFF_CHAIN_TREE},
+		{eid1, "https://example.com/gamma", &models.EnvelopeType{Anonymous: true}, models.CensusOrigin_OFF_CHAIN_CA},
+		{eid2, "ipfs://delta", &models.EnvelopeType{EncryptedVotes: true, Serial: true}, models.CensusOrigin_OFF_CHAIN_TREE},
+		{eid2, "ipfs://epsilon", &models.EnvelopeType{}, models.CensusOrigin_OFF_CHAIN_CA},
+	} {
+		pid := util.RandomBytes(32)
+		pids = append(pids, pid)
+		qt.Assert(t, app.State.AddProcess(&models.Process{
+			ProcessId:    pid,
+			EntityId:     p.eid,
+			StartBlock:   uint32(10 - i),
+			BlockCount:   10,
+			CensusURI:    &p.censusURI,
+			CensusOrigin: p.origin,
+			VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+			EnvelopeType: p.envelope,
+			Mode:         &models.ProcessMode{AutoStart: true},
+			Status:       models.ProcessStatus_READY,
+		}), qt.IsNil)
+		app.AdvanceTestBlock()
+	}
+
+	// two votes on the first process and one on the fifth
+	vp, err := json.Marshal(vochain.VotePackage{Votes: []int{1}})
+	qt.Assert(t, err, qt.IsNil)
+	sc.Rollback()
+	for i, pid := range [][]byte{pids[0], pids[0], pids[4]} {
+		sc.OnVote(&models.Vote{ProcessId: pid, VotePackage: vp, Nullifier: util.RandomBytes(32)},
+			types.VoterID{}.Nil(), int32(i))
+	}
+	qt.Assert(t, sc.Commit(app.Height()), qt.IsNil)
+
+	search := func(s indexertypes.ProcessSearch) *indexertypes.ProcessSearchResult {
+		t.Helper()
+		if s.Limit == 0 {
+			s.Limit = 10
+		}
+		result, err := sc.SearchProcesses(&s)
+		qt.Assert(t, err, qt.IsNil)
+		return result
+	}
+	ids := func(indexes ...int) []types.HexBytes {
+		list := []types.HexBytes{}
+		for _, i := range indexes {
+			list = append(list, pids[i])
+		}
+		return list
+	}
+
+	qt.Assert(t, search(indexertypes.ProcessSearch{}).ProcessIDs, qt.DeepEquals, ids(0, 1, 2, 3, 4))
+	qt.Assert(t, search(indexertypes.ProcessSearch{Text: "beta"}).ProcessIDs, qt.DeepEquals, ids(1))
+	qt.Assert(t, search(indexertypes.ProcessSearch{Text: "ipfs"}).ProcessIDs, qt.DeepEquals, ids(0, 1, 3, 4))
+	qt.Assert(t, search(indexertypes.ProcessSearch{Text: fmt.Sprintf("%x*", pids[2][:4])}).ProcessIDs,
+		qt.DeepEquals, ids(2))
+	qt.Assert(t, search(indexertypes.ProcessSearch{EntityID: eid2}).ProcessIDs, qt.DeepEquals, ids(3, 4))
+	qt.Assert(t, search(indexertypes.ProcessSearch{Text: "ipfs", EntityID: eid1}).ProcessIDs,
+		qt.DeepEquals, ids(0, 1))
+	qt.Assert(t, search(indexertypes.ProcessSearch{CensusOrigin: "OFF_CHAIN_CA"}).ProcessIDs,
+		qt.DeepEquals, ids(2, 4))
+	qt.Assert(t, search(indexertypes.ProcessSearch{
+		Envelope: map[string]bool{"encryptedVotes": true},
+	}).ProcessIDs, qt.DeepEquals, ids(1, 3))
+	qt.Assert(t, search(indexertypes.ProcessSearch{
+		Envelope: map[string]bool{"encryptedVotes": true, "serial": false},
+	}).ProcessIDs, qt.DeepEquals, ids(1))
+	qt.Assert(t, search(indexertypes.ProcessSearch{MinVotes: 1}).ProcessIDs, qt.DeepEquals, ids(0, 4))
+	zero := uint64(0)
+	qt.Assert(t, search(indexertypes.ProcessSearch{MaxVotes: &zero}).ProcessIDs, qt.DeepEquals, ids(1, 2, 3))
+
+	// the creation time of the third process
+	proc, err := sc.ProcessInfo(pids[2])
+	qt.Assert(t, err, qt.IsNil)
+	created := proc.CreationTime
+	before := created.Add(time.Second)
+	qt.Assert(t, search(indexertypes.ProcessSearch{CreatedAfter: &created, CreatedBefore: &before}).ProcessIDs,
+		qt.DeepEquals, ids(2))
+
+	// sort orders
+	qt.Assert(t, search(indexertypes.ProcessSearch{Descending: true}).ProcessIDs,
+		qt.DeepEquals, ids(4, 3, 2, 1, 0))
+	qt.Assert(t, search(indexertypes.ProcessSearch{SortBy: indexertypes.SortByStartBlock}).ProcessIDs,
+		qt.DeepEquals, ids(4, 3, 2, 1, 0))
+	qt.Assert(t, search(indexertypes.ProcessSearch{SortBy: indexertypes.SortByVoteCount,
+		Descending: true}).ProcessIDs[:2], qt.DeepEquals, ids(0, 4))
+
+	// cursor pagination, in both orders
+	for _, descending := range []bool{false, true} {
+		all := []types.HexBytes{}
+		s := indexertypes.ProcessSearch{SortBy: indexertypes.SortByVoteCount, Descending: descending, Limit: 2}
+		for pages := 1; ; pages++ {
+			result := search(s)
+			all = append(all, result.ProcessIDs...)
+			if result.NextCursor == "" {
+				qt.Assert(t, pages, qt.Equals, 3)
+				break
+			}
+			s.Cursor = result.NextCursor
+		}
+		qt.Assert(t, all, qt.DeepEquals, search(indexertypes.ProcessSearch{
+			SortBy: indexertypes.SortByVoteCount, Descending: descending}).ProcessIDs)
+	}
+
+	// facets; the unencrypted processes have live results
+	result := search(indexertypes.ProcessSearch{EntityID: eid1, Facets: true})
+	qt.Assert(t, result.Facets, qt.DeepEquals, &indexertypes.ProcessFacets{
+		Status:       map[string]uint64{"READY": 3},
+		CensusOrigin: map[string]uint64{"OFF_CHAIN_TREE": 2, "OFF_CHAIN_CA": 1},
+		Envelope:     map[string]uint64{"encryptedVotes": 1, "anonymous": 1},
+		WithResults:  2,
+		Total:        3,
+	})
+
+	// invalid searches
+	for _, s := range []indexertypes.ProcessSearch{
+		{Limit: MaxSearchLimit + 1},
+		{Limit: 1, SortBy: "unknown"},
+		{Limit: 1, Status: "unknown"},
+		{Limit: 1, Envelope: map[string]bool{"unknown": true}},
+		{Limit: 1, Cursor: "invalid"},
+	} {
+		_, err := sc.SearchProcesses(&s)
+		qt.Assert(t, err, qt.IsNotNil)
+	}
+}
+
+func TestSearchProcessesMetadata(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	sc, err := NewScrutinizer(t.TempDir(), app, true)
+	qt.Assert(t, err, qt.IsNil)
+	storage, err := data.Init(data.FS, &types.DataStore{Datadir: t.TempDir()})
+	qt.Assert(t, err, qt.IsNil)
+	sc.SetMetadataStorage(storage)
+
+	metadata := &processMetadata{
+		Title:       map[string]string{"default": "Budget referendum", "ca": "Referèndum del pressupost"},
+		Description: map[string]string{"default": "Approve the yearly budget"},
+	}
+	content, err := json.Marshal(metadata)
+	qt.Assert(t, err, qt.IsNil)
+	cid, err := storage.Publish(context.Background(), content)
+	qt.Assert(t, err, qt.IsNil)
+	uri := storage.URIprefix() + cid
+
+	pid := util.RandomBytes(32)
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EntityId:     util.RandomBytes(20),
+		BlockCount:   10,
+		Metadata:     &uri,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{AutoStart: true},
+		Status:       models.ProcessStatus_READY,
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	// the metadata is indexed on the first commit after it is retrieved
+	for i := 0; ; i++ {
+		sc.pendingMetadataLock.Lock()
+		_, ok := sc.pendingMetadata[string(pid)]
+		sc.pendingMetadataLock.Unlock()
+		if ok {
+			break
+		}
+		qt.Assert(t, i < 100, qt.IsTrue, qt.Commentf("metadata not retrieved"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	search := func(text string) []types.HexBytes {
+		t.Helper()
+		result, err := sc.SearchProcesses(&indexertypes.ProcessSearch{Text: text, Limit: 10})
+		qt.Assert(t, err, qt.IsNil)
+		return result.ProcessIDs
+	}
+	qt.Assert(t, search("referendum"), qt.HasLen, 0)
+	app.AdvanceTestBlock()
+
+	for _, text := range []string{"referendum", "pressupost", "yearly", "title:budget", "description:approve"} {
+		qt.Assert(t, search(text), qt.DeepEquals, []types.HexBytes{pid}, qt.Commentf("%s", text))
+	}
+	// the metadata URI itself is not indexed
+	qt.Assert(t, search(cid), qt.HasLen, 0)
+	qt.Assert(t, search("title:yearly"), qt.HasLen, 0)
+}
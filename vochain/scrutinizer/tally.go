@@ -0,0 +1,496 @@
+package scrutinizer
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// Names of the built-in tally strategies.
+const (
+	// TallyApproval counts each option chosen (value 1) or not (value 0)
+	// on each question.
+	TallyApproval = "approval"
+	// TallyMultiChoice counts the options chosen out of a list, with a
+	// maximum number of choices (maxTotalCost).
+	TallyMultiChoice = "multichoice"
+	// TallyRateProduct counts the rate (from 0 to maxValue) given to each
+	// question.
+	TallyRateProduct = "rateproduct"
+	// TallyQuadratic aggregates the value given to each question (maxValue
+	// is zero), multiplied by the weight unless costFromWeight.
+	TallyQuadratic = "quadratic"
+	// TallyRankedChoice counts the candidates ranked at each position, and
+	// runs an instant runoff for the final results.
+	TallyRankedChoice = "ranked"
+	// TallyBorda gives to each ranked candidate as many points as
+	// candidates ranked below it.
+	TallyBorda = "borda"
+	// TallyCondorcet builds the pairwise preference matrix of the ranked
+	// candidates.
+	TallyCondorcet = "condorcet"
+	// TallyDHondt allocates a number of seats among parties with the D'Hondt
+	// method.  The strategy name includes the number of seats, see
+	// DHondtTallyName.
+	TallyDHondt = "dhondt"
+)
+
+// TallyStrategy defines how the votes of a process are counted into its
+// results.  Vote values are validated against the process ballot protocol
+// (maxCount, maxValue, uniqueValues, maxTotalCost) before being passed to the
+// strategy.
+type TallyStrategy interface {
+	// NewVotes returns the empty results matrix for the vote options.
+	NewVotes(opts *models.ProcessVoteOptions) [][]*types.BigInt
+	// AddVote counts the vote values with the given weight into the
+	// results.  It is used for both the live and the final results, and it
+	// must not modify the results if the vote is not valid.
+	AddVote(results *indexertypes.Results, values []int, weight *big.Int) error
+	// KeepBallots returns true if the strategy needs the individual ballots
+	// to compute the final results.
+	KeepBallots() bool
+	// FinalResults completes the final results once all the votes have been
+	// counted.  The ballots are only provided if KeepBallots is true.
+	FinalResults(results *indexertypes.Results, ballots []indexertypes.Ballot) error
+}
+
+var (
+	tallyStrategiesLock sync.RWMutex
+	tallyStrategies     = map[string]TallyStrategy{
+		TallyApproval:     &indexWeightedTally{},
+		TallyMultiChoice:  &indexWeightedTally{},
+		TallyRateProduct:  &indexWeightedTally{},
+		TallyQuadratic:    &quadraticTally{},
+		TallyRankedChoice: &rankedChoiceTally{},
+		TallyBorda:        &bordaTally{},
+		TallyCondorcet:    &condorcetTally{},
+	}
+)
+
+// RegisterTallyStrategy registers a tally strategy with the given name, so
+// that it can be picked for a process.  Registering a name again replaces the
+// previous strategy.
+func RegisterTallyStrategy(name string, strategy TallyStrategy) {
+	tallyStrategiesLock.Lock()
+	defer tallyStrategiesLock.Unlock()
+	tallyStrategies[name] = strategy
+}
+
+// DHondtTallyName returns the name of the D'Hondt tally strategy for the given
+// number of seats.
+func DHondtTallyName(seats int) string {
+	return fmt.Sprintf("%s:%d", TallyDHondt, seats)
+}
+
+// GetTallyStrategy returns the tally strategy registered with the given name,
+// or the D'Hondt tally strategy if the name is built by DHondtTallyName.
+func GetTallyStrategy(name string) (TallyStrategy, error) {
+	if strings.HasPrefix(name, TallyDHondt+":") {
+		seats, err := strconv.Atoi(strings.TrimPrefix(name, TallyDHondt+":"))
+		if err != nil || seats <= 0 {
+			return nil, fmt.Errorf("invalid tally strategy %q", name)
+		}
+		return NewDHondtTally(seats), nil
+	}
+	tallyStrategiesLock.RLock()
+	defer tallyStrategiesLock.RUnlock()
+	strategy, ok := tallyStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("tally strategy %q not registered", name)
+	}
+	return strategy, nil
+}
+
+// DefaultTallyStrategy returns the name of the built-in tally strategy that
+// matches the ballot protocol options.  Ranked ballots are never inferred from
+// the options, the ranked strategies are picked by the process metadata.
+func DefaultTallyStrategy(envelope *models.EnvelopeType, opts *models.ProcessVoteOptions) string {
+	switch {
+	case opts.GetMaxValue() == 0:
+		return TallyQuadratic
+	case opts.GetMaxValue() == 1 && opts.GetMaxTotalCost() > 0:
+		return TallyMultiChoice
+	case opts.GetMaxValue() == 1:
+		return TallyApproval
+	default:
+		return TallyRateProduct
+	}
+}
+
+// resultsTallyStrategy returns the tally strategy of the results.  Results
+// indexed without a strategy name use the DefaultTallyStrategy.
+func resultsTallyStrategy(results *indexertypes.Results) (TallyStrategy, error) {
+	name := results.TallyStrategy
+	if name == "" {
+		name = DefaultTallyStrategy(results.EnvelopeType, results.VoteOpts)
+	}
+	return GetTallyStrategy(name)
+}
+
+func init() {
+	indexertypes.SetVoteCounter(addVote)
+}
+
+// addVote validates the vote values against the ballot protocol and counts
+// them into the results using their tally strategy.  If mutex is not nil, it
+// is held while updating the results.
+func addVote(results *indexertypes.Results, values []int, weight *big.Int, mutex *sync.Mutex) error {
+	strategy, err := resultsTallyStrategy(results)
+	if err != nil {
+		return err
+	}
+	if err := checkBallotProtocol(results, values, weight); err != nil {
+		return err
+	}
+
+	// If Mutex provided, Lock it
+	if mutex != nil {
+		mutex.Lock()
+		defer mutex.Unlock()
+	}
+
+	// If weight not provided, assume weight = 1
+	if weight == nil {
+		weight = new(big.Int).SetUint64(1)
+	}
+	if len(results.Votes) == 0 {
+		results.Votes = strategy.NewVotes(results.VoteOpts)
+	}
+	if err := strategy.AddVote(results, values, weight); err != nil {
+		return err
+	}
+	// Add the Election weight (tells how much voting power have already been processed)
+	results.Weight.Add(results.Weight, (*types.BigInt)(weight))
+	// Increase EnvelopeHeight by the number of votes added
+	results.EnvelopeHeight++
+	return nil
+}
+
+// checkBallotProtocol checks the vote values against the ballot protocol
+// options of the results.
+func checkBallotProtocol(r *indexertypes.Results, voteValues []int, weight *big.Int) error {
+	if r.VoteOpts == nil {
+		return fmt.Errorf("addVote: processVoteOptions is nil")
+	}
+	if r.EnvelopeType == nil {
+		return fmt.Errorf("addVote: envelopeType is nil")
+	}
+	// MaxCount
+	if len(voteValues) > int(r.VoteOpts.MaxCount) || len(voteValues) > MaxOptions {
+		return fmt.Errorf("max count overflow %d", len(voteValues))
+	}
+
+	// UniqueValues
+	if r.EnvelopeType.UniqueValues {
+		votes := make(map[int]bool, len(voteValues))
+		for _, v := range voteValues {
+			if votes[v] {
+				return fmt.Errorf("values are not unique")
+			}
+			votes[v] = true
+		}
+	}
+
+	// Max Value, check it only if greather than zero
+	if r.VoteOpts.MaxValue > 0 {
+		for _, v := range voteValues {
+			if uint32(v) > r.VoteOpts.MaxValue {
+				return fmt.Errorf("max value overflow %d", v)
+			}
+		}
+	}
+
+	// Max total cost
+	if r.VoteOpts.MaxTotalCost > 0 || r.EnvelopeType.CostFromWeight {
+		cost := new(big.Int).SetUint64(0)
+		exponent := new(big.Int).SetUint64(uint64(r.VoteOpts.CostExponent))
+		var maxCost *big.Int
+		if r.EnvelopeType.CostFromWeight {
+			maxCost = weight
+			if maxCost == nil {
+				maxCost = new(big.Int).SetUint64(1)
+			}
+		} else {
+			maxCost = new(big.Int).SetUint64(uint64(r.VoteOpts.MaxTotalCost))
+		}
+		for _, v := range voteValues {
+			cost.Add(cost, new(big.Int).Exp(new(big.Int).SetUint64(uint64(v)), exponent, nil))
+			if cost.Cmp(maxCost) > 0 {
+				return fmt.Errorf("max total cost overflow: %s", cost)
+			}
+		}
+	}
+	return nil
+}
+
+// checkVotesSize checks that the results matrix has the expected number of
+// rows.
+func checkVotesSize(results *indexertypes.Results, rows int) error {
+	if len(results.Votes) != rows {
+		return fmt.Errorf("addVote: currentResults size mismatch %d != %d",
+			len(results.Votes), rows)
+	}
+	return nil
+}
+
+// noFinalResults can be embedded by the strategies whose final results are the
+// same as the live results.
+type noFinalResults struct{}
+
+func (noFinalResults) KeepBallots() bool { return false }
+
+func (noFinalResults) FinalResults(*indexertypes.Results, []indexertypes.Ballot) error {
+	return nil
+}
+
+// indexWeightedTally adds the weight of the vote to the option chosen on each
+// question, as described in the Ballot Protocol:
+//
+//	Vote1: [1, 0, 2] w=10
+//	Vote2: [1, 1, 1] w=5
+//	Results: [ [0,15,0], [10,5,0], [0,5,10] ]
+//
+// It is used for approval, multi-choice and rate-product processes.
+type indexWeightedTally struct {
+	noFinalResults
+}
+
+func (*indexWeightedTally) NewVotes(opts *models.ProcessVoteOptions) [][]*types.BigInt {
+	// MaxValue requires +1 since 0 is also an option
+	return indexertypes.NewEmptyVotes(int(opts.MaxCount), int(opts.MaxValue)+1)
+}
+
+func (*indexWeightedTally) AddVote(results *indexertypes.Results, values []int, weight *big.Int) error {
+	if err := checkVotesSize(results, int(results.VoteOpts.MaxCount)); err != nil {
+		return err
+	}
+	for q, opt := range values {
+		if opt < 0 || opt >= len(results.Votes[q]) {
+			return fmt.Errorf("option %d out of range", opt)
+		}
+	}
+	for q, opt := range values {
+		results.Votes[q][opt].Add(results.Votes[q][opt], (*types.BigInt)(weight))
+	}
+	return nil
+}
+
+// quadraticTally aggregates the value of each question multiplied by the
+// weight, using only the first column of the results matrix (maxValue is
+// zero):
+//
+//	Vote1: [1, 2, 3] w=10
+//	Vote2: [0, 3, 1] w=5
+//	Results: [ [1*10+0*5], [2*10+3*5], [3*10+1*5] ] = [ [10], [35], [35] ]
+//
+// If costFromWeight, the weight is used for computing the cost and it is
+// already represented on the vote values, so it is not used as a multiplier.
+type quadraticTally struct {
+	noFinalResults
+}
+
+func (*quadraticTally) NewVotes(opts *models.ProcessVoteOptions) [][]*types.BigInt {
+	return indexertypes.NewEmptyVotes(int(opts.MaxCount), 1)
+}
+
+func (*quadraticTally) AddVote(results *indexertypes.Results, values []int, weight *big.Int) error {
+	if err := checkVotesSize(results, int(results.VoteOpts.MaxCount)); err != nil {
+		return err
+	}
+	if results.EnvelopeType.CostFromWeight {
+		weight = new(big.Int).SetUint64(1)
+	}
+	for q, value := range values {
+		results.Votes[q][0].Add(
+			results.Votes[q][0],
+			new(types.BigInt).Mul(
+				new(types.BigInt).SetUint64(uint64(value)),
+				(*types.BigInt)(weight)),
+		)
+	}
+	return nil
+}
+
+// rankedChoiceTally counts the weight of the candidate chosen at each
+// preference position like indexWeightedTally, and runs an instant runoff
+// with the individual ballots for the final results.  It is only used if
+// picked explicitly for a ranked ballot (see indexertypes.IsRankedChoice).
+type rankedChoiceTally struct {
+	indexWeightedTally
+}
+
+func (*rankedChoiceTally) KeepBallots() bool { return true }
+
+func (*rankedChoiceTally) FinalResults(results *indexertypes.Results,
+	ballots []indexertypes.Ballot) error {
+	results.RankedRounds = indexertypes.InstantRunoff(ballots, int(results.VoteOpts.MaxValue)+1)
+	return nil
+}
+
+// checkRanking checks that the values of a ranked ballot are different
+// candidates, from 0 to candidates-1.
+func checkRanking(values []int, candidates int) error {
+	seen := make([]bool, candidates)
+	for _, c := range values {
+		if c < 0 || c >= candidates {
+			return fmt.Errorf("candidate %d out of range", c)
+		}
+		if seen[c] {
+			return fmt.Errorf("candidate %d ranked twice", c)
+		}
+		seen[c] = true
+	}
+	return nil
+}
+
+// bordaTally counts ranked ballots (the candidates, from 0 to maxValue, in
+// order of preference) giving each candidate as many points as candidates
+// ranked below it, multiplied by the weight.  Unranked candidates get no
+// points.  The results have a single row with the points of each candidate:
+//
+//	Vote1: [2, 0, 1] w=1
+//	Vote2: [0, 1]    w=2
+//	Results: [ [1*1+2*2, 0*1+1*2, 2*1+0*2] ] = [ [5, 2, 2] ]
+type bordaTally struct {
+	noFinalResults
+}
+
+func (*bordaTally) NewVotes(opts *models.ProcessVoteOptions) [][]*types.BigInt {
+	return indexertypes.NewEmptyVotes(1, int(opts.MaxValue)+1)
+}
+
+func (*bordaTally) AddVote(results *indexertypes.Results, values []int, weight *big.Int) error {
+	if err := checkVotesSize(results, 1); err != nil {
+		return err
+	}
+	candidates := len(results.Votes[0])
+	if err := checkRanking(values, candidates); err != nil {
+		return err
+	}
+	for pos, c := range values {
+		points := new(big.Int).SetUint64(uint64(candidates - 1 - pos))
+		results.Votes[0][c].Add(results.Votes[0][c],
+			(*types.BigInt)(points.Mul(points, weight)))
+	}
+	return nil
+}
+
+// condorcetTally counts ranked ballots (the candidates, from 0 to maxValue, in
+// order of preference) into the pairwise preference matrix: the cell [a][b]
+// is the weight of the votes preferring candidate a over candidate b.  Ranked
+// candidates are preferred over the unranked ones.  A Condorcet winner is a
+// candidate a with [a][b] > [b][a] for every other candidate b.
+type condorcetTally struct {
+	noFinalResults
+}
+
+func (*condorcetTally) NewVotes(opts *models.ProcessVoteOptions) [][]*types.BigInt {
+	return indexertypes.NewEmptyVotes(int(opts.MaxValue)+1, int(opts.MaxValue)+1)
+}
+
+func (*condorcetTally) AddVote(results *indexertypes.Results, values []int, weight *big.Int) error {
+	candidates := int(results.VoteOpts.MaxValue) + 1
+	if err := checkVotesSize(results, candidates); err != nil {
+		return err
+	}
+	if err := checkRanking(values, candidates); err != nil {
+		return err
+	}
+	ranked := make([]bool, candidates)
+	for _, a := range values {
+		ranked[a] = true
+		for b := 0; b < candidates; b++ {
+			if !ranked[b] {
+				results.Votes[a][b].Add(results.Votes[a][b], (*types.BigInt)(weight))
+			}
+		}
+	}
+	return nil
+}
+
+// DHondtTally allocates a number of seats among parties with the D'Hondt
+// method.  Each vote chooses a single party (from 0 to maxValue).  The live
+// results have a single row with the votes of each party, and the final
+// results add a second row with the seats allocated to each party:
+//
+//	Votes: [100, 80, 30] seats=5
+//	Results: [ [100, 80, 30], [3, 2, 0] ]
+//
+// DHondtTally is not registered by name, since the number of seats is specific
+// to each election: GetTallyStrategy builds it from its DHondtTallyName.
+type DHondtTally struct {
+	seats int
+}
+
+// NewDHondtTally returns a D'Hondt tally strategy for the given number of
+// seats.
+func NewDHondtTally(seats int) *DHondtTally {
+	return &DHondtTally{seats: seats}
+}
+
+func (*DHondtTally) NewVotes(opts *models.ProcessVoteOptions) [][]*types.BigInt {
+	return indexertypes.NewEmptyVotes(1, int(opts.MaxValue)+1)
+}
+
+func (*DHondtTally) AddVote(results *indexertypes.Results, values []int, weight *big.Int) error {
+	if err := checkVotesSize(results, 1); err != nil {
+		return err
+	}
+	if len(values) != 1 {
+		return fmt.Errorf("a single party must be chosen")
+	}
+	party := values[0]
+	if party < 0 || party >= len(results.Votes[0]) {
+		return fmt.Errorf("party %d out of range", party)
+	}
+	results.Votes[0][party].Add(results.Votes[0][party], (*types.BigInt)(weight))
+	return nil
+}
+
+func (*DHondtTally) KeepBallots() bool { return false }
+
+// FinalResults allocates each seat to the party with the highest quotient
+// votes/(seats+1).  Ties are won by the party with more votes, and then by the
+// lowest party index.
+func (d *DHondtTally) FinalResults(results *indexertypes.Results, _ []indexertypes.Ballot) error {
+	if err := checkVotesSize(results, 1); err != nil {
+		return err
+	}
+	votes := results.Votes[0]
+	seats := make([]int64, len(votes))
+	for i := 0; i < d.seats; i++ {
+		best := -1
+		for p := range votes {
+			if votes[p].ToInt().Sign() == 0 {
+				continue
+			}
+			if best < 0 {
+				best = p
+				continue
+			}
+			// votes[p]/(seats[p]+1) > votes[best]/(seats[best]+1)
+			a := new(big.Int).Mul(votes[p].ToInt(), big.NewInt(seats[best]+1))
+			b := new(big.Int).Mul(votes[best].ToInt(), big.NewInt(seats[p]+1))
+			cmp := a.Cmp(b)
+			if cmp > 0 || (cmp == 0 && votes[p].ToInt().Cmp(votes[best].ToInt()) > 0) {
+				best = p
+			}
+		}
+		if best < 0 {
+			break // no votes
+		}
+		seats[best]++
+	}
+	seatsRow := make([]*types.BigInt, len(votes))
+	for p := range seats {
+		seatsRow[p] = new(types.BigInt).SetUint64(uint64(seats[p]))
+	}
+	results.Votes = append(results.Votes, seatsRow)
+	return nil
+}
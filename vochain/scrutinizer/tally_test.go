@@ -0,0 +1,486 @@
+package scrutinizer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/data"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+type tallyTestVote struct {
+	values []int
+	weight uint64 // 0 means no weight provided
+	err    string // regexp of the expected error, empty if the vote is valid
+}
+
+type tallyTestCase struct {
+	name     string
+	strategy string
+	envelope *models.EnvelopeType
+	opts     *models.ProcessVoteOptions
+	votes    []tallyTestVote
+	live     [][]string
+	final    [][]string
+}
+
+// runTallyTest counts the votes of the test case as live results and as final
+// results, and checks both.  It returns the final results.
+func runTallyTest(t *testing.T, tc tallyTestCase) *indexertypes.Results {
+	if tc.envelope == nil {
+		tc.envelope = &models.EnvelopeType{}
+	}
+	newResults := func() *indexertypes.Results {
+		return &indexertypes.Results{
+			Weight:        new(types.BigInt).SetUint64(0),
+			VoteOpts:      tc.opts,
+			EnvelopeType:  tc.envelope,
+			TallyStrategy: tc.strategy,
+		}
+	}
+	strategy, err := resultsTallyStrategy(newResults())
+	qt.Assert(t, err, qt.IsNil)
+
+	// the live results are created on the first vote, the final results
+	// are created before counting like in computeFinalResults
+	live := newResults()
+	final := newResults()
+	final.Votes = strategy.NewVotes(tc.opts)
+	var ballots []indexertypes.Ballot
+	for _, v := range tc.votes {
+		var weight *big.Int
+		if v.weight > 0 {
+			weight = new(big.Int).SetUint64(v.weight)
+		}
+		err := addVote(live, v.values, weight, nil)
+		if v.err != "" {
+			qt.Assert(t, err, qt.ErrorMatches, v.err, qt.Commentf("vote %v", v.values))
+			continue
+		}
+		qt.Assert(t, err, qt.IsNil, qt.Commentf("vote %v", v.values))
+		qt.Assert(t, addVote(final, v.values, weight, nil), qt.IsNil)
+		if strategy.KeepBallots() {
+			ballots = append(ballots, indexertypes.Ballot{Values: v.values, Weight: weight})
+		}
+	}
+	qt.Assert(t, GetFriendlyResults(live.Votes), qt.DeepEquals, tc.live)
+
+	qt.Assert(t, strategy.FinalResults(final, ballots), qt.IsNil)
+	qt.Assert(t, GetFriendlyResults(final.Votes), qt.DeepEquals, tc.final)
+	qt.Assert(t, final.Weight.String(), qt.Equals, live.Weight.String())
+	return final
+}
+
+func TestTallyApproval(t *testing.T) {
+	for _, tc := range []tallyTestCase{{
+		name:     "Default",
+		strategy: "",
+		opts:     &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 1},
+		votes: []tallyTestVote{
+			{values: []int{1, 0, 1}},
+			{values: []int{1, 1, 0}, weight: 2},
+			{values: []int{0, 0, 1}},
+			{values: []int{2, 0, 0}, err: "max value overflow.*"},
+			{values: []int{1, 1, 1, 1}, err: "max count overflow.*"},
+		},
+		live:  [][]string{{"1", "3"}, {"2", "2"}, {"2", "2"}},
+		final: [][]string{{"1", "3"}, {"2", "2"}, {"2", "2"}},
+	}, {
+		name:     "Explicit",
+		strategy: TallyApproval,
+		opts:     &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 1},
+		votes: []tallyTestVote{
+			{values: []int{1}},
+			{values: []int{0, 1}, weight: 5},
+		},
+		live:  [][]string{{"5", "1"}, {"0", "5"}},
+		final: [][]string{{"5", "1"}, {"0", "5"}},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			qt.Assert(t, DefaultTallyStrategy(&models.EnvelopeType{}, tc.opts), qt.Equals, TallyApproval)
+			runTallyTest(t, tc)
+		})
+	}
+}
+
+func TestTallyMultiChoice(t *testing.T) {
+	for _, tc := range []tallyTestCase{{
+		// choose 2 out of 4
+		name: "TwoOutOfFour",
+		opts: &models.ProcessVoteOptions{MaxCount: 4, MaxValue: 1, MaxTotalCost: 2, CostExponent: 1},
+		votes: []tallyTestVote{
+			{values: []int{1, 1, 0, 0}},
+			{values: []int{0, 1, 1, 0}, weight: 3},
+			{values: []int{1, 1, 1, 0}, err: "max total cost overflow.*"},
+			{values: []int{2, 0, 0, 0}, err: "max value overflow.*"},
+		},
+		live:  [][]string{{"3", "1"}, {"0", "4"}, {"1", "3"}, {"4", "0"}},
+		final: [][]string{{"3", "1"}, {"0", "4"}, {"1", "3"}, {"4", "0"}},
+	}, {
+		name: "NoVotes",
+		opts: &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 1, MaxTotalCost: 1, CostExponent: 1},
+		votes: []tallyTestVote{
+			{values: []int{1, 1}, err: "max total cost overflow.*"},
+		},
+		live:  [][]string{},
+		final: [][]string{{"0", "0"}, {"0", "0"}},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			qt.Assert(t, DefaultTallyStrategy(&models.EnvelopeType{}, tc.opts), qt.Equals, TallyMultiChoice)
+			runTallyTest(t, tc)
+		})
+	}
+}
+
+func TestTallyRateProduct(t *testing.T) {
+	for _, tc := range []tallyTestCase{{
+		// rate 2 products from 0 to 4
+		name: "TwoProducts",
+		opts: &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 4},
+		votes: []tallyTestVote{
+			{values: []int{4, 2}},
+			{values: []int{2, 4}, weight: 2},
+			{values: []int{0, 4}},
+			{values: []int{5, 0}, err: "max value overflow.*"},
+			{values: []int{0, 0, 0}, err: "max count overflow.*"},
+		},
+		live:  [][]string{{"1", "0", "2", "0", "1"}, {"0", "0", "1", "0", "3"}},
+		final: [][]string{{"1", "0", "2", "0", "1"}, {"0", "0", "1", "0", "3"}},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			qt.Assert(t, DefaultTallyStrategy(&models.EnvelopeType{}, tc.opts), qt.Equals, TallyRateProduct)
+			runTallyTest(t, tc)
+		})
+	}
+}
+
+func TestTallyQuadratic(t *testing.T) {
+	for _, tc := range []tallyTestCase{{
+		// the weight is the number of credits
+		name:     "CostFromWeight",
+		envelope: &models.EnvelopeType{CostFromWeight: true},
+		opts:     &models.ProcessVoteOptions{MaxCount: 2, CostExponent: 2},
+		votes: []tallyTestVote{
+			{values: []int{10, 28}, weight: 1000},
+			{values: []int{5, 5}, weight: 50},
+			{values: []int{5, 2}, weight: 25, err: "max total cost overflow.*"},
+		},
+		live:  [][]string{{"15"}, {"33"}},
+		final: [][]string{{"15"}, {"33"}},
+	}, {
+		// 10 credits to distribute, the weight multiplies the values
+		name: "MaxTotalCost",
+		opts: &models.ProcessVoteOptions{MaxCount: 2, MaxTotalCost: 10, CostExponent: 2},
+		votes: []tallyTestVote{
+			{values: []int{1, 3}, weight: 2},
+			{values: []int{2, 2}},
+			{values: []int{3, 2}, err: "max total cost overflow.*"},
+		},
+		live:  [][]string{{"4"}, {"8"}},
+		final: [][]string{{"4"}, {"8"}},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			qt.Assert(t, DefaultTallyStrategy(tc.envelope, tc.opts), qt.Equals, TallyQuadratic)
+			runTallyTest(t, tc)
+		})
+	}
+}
+
+func TestTallyRankedChoice(t *testing.T) {
+	for _, tc := range []struct {
+		tallyTestCase
+		rounds []indexertypes.RankedRound
+	}{{
+		tallyTestCase: tallyTestCase{
+			name:     "SecondRound",
+			strategy: TallyRankedChoice,
+			envelope: &models.EnvelopeType{UniqueValues: true},
+			opts:     &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 2},
+			votes: []tallyTestVote{
+				{values: []int{0, 1, 2}, weight: 2},
+				{values: []int{1, 0}, weight: 2},
+				{values: []int{2, 1}},
+				{values: []int{0, 0}, err: "values are not unique"},
+				{values: []int{3}, err: "max value overflow.*"},
+			},
+			live:  [][]string{{"2", "2", "1"}, {"2", "3", "0"}, {"0", "0", "2"}},
+			final: [][]string{{"2", "2", "1"}, {"2", "3", "0"}, {"0", "0", "2"}},
+		},
+		rounds: []indexertypes.RankedRound{
+			{Eliminated: 2, Winner: -1},
+			{Eliminated: -1, Winner: 1},
+		},
+	}, {
+		tallyTestCase: tallyTestCase{
+			name:     "FirstRound",
+			strategy: TallyRankedChoice,
+			envelope: &models.EnvelopeType{UniqueValues: true},
+			opts:     &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 1},
+			votes: []tallyTestVote{
+				{values: []int{1, 0}, weight: 3},
+				{values: []int{0}, weight: 2},
+			},
+			live:  [][]string{{"2", "3"}, {"3", "0"}},
+			final: [][]string{{"2", "3"}, {"3", "0"}},
+		},
+		rounds: []indexertypes.RankedRound{
+			{Eliminated: -1, Winner: 1},
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			// ranked ballots are never inferred from the options
+			qt.Assert(t, DefaultTallyStrategy(tc.envelope, tc.opts), qt.Not(qt.Equals), TallyRankedChoice)
+			final := runTallyTest(t, tc.tallyTestCase)
+			qt.Assert(t, final.RankedRounds, qt.HasLen, len(tc.rounds))
+			for i, round := range tc.rounds {
+				qt.Assert(t, final.RankedRounds[i].Eliminated, qt.Equals, round.Eliminated)
+				qt.Assert(t, final.RankedRounds[i].Winner, qt.Equals, round.Winner)
+			}
+		})
+	}
+}
+
+func TestTallyBorda(t *testing.T) {
+	for _, tc := range []tallyTestCase{{
+		name:     "PartialRanking",
+		strategy: TallyBorda,
+		envelope: &models.EnvelopeType{UniqueValues: true},
+		opts:     &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 2},
+		votes: []tallyTestVote{
+			{values: []int{2, 0, 1}},
+			{values: []int{0, 1}, weight: 2},
+			{values: []int{3}, err: "max value overflow.*"},
+		},
+		live:  [][]string{{"5", "2", "2"}},
+		final: [][]string{{"5", "2", "2"}},
+	}, {
+		name:     "RankedTwice",
+		strategy: TallyBorda,
+		opts:     &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 1},
+		votes: []tallyTestVote{
+			{values: []int{1, 0}},
+			{values: []int{1, 1}, err: "candidate 1 ranked twice"},
+		},
+		live:  [][]string{{"0", "1"}},
+		final: [][]string{{"0", "1"}},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			runTallyTest(t, tc)
+		})
+	}
+}
+
+func TestTallyCondorcet(t *testing.T) {
+	for _, tc := range []tallyTestCase{{
+		name:     "PairwiseMatrix",
+		strategy: TallyCondorcet,
+		opts:     &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 2},
+		votes: []tallyTestVote{
+			{values: []int{0, 1, 2}, weight: 2},
+			{values: []int{1, 2, 0}},
+			{values: []int{2}},
+			{values: []int{0, 0}, err: "candidate 0 ranked twice"},
+		},
+		live:  [][]string{{"0", "2", "2"}, {"1", "0", "3"}, {"2", "1", "0"}},
+		final: [][]string{{"0", "2", "2"}, {"1", "0", "3"}, {"2", "1", "0"}},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			runTallyTest(t, tc)
+		})
+	}
+}
+
+func TestTallyDHondt(t *testing.T) {
+	RegisterTallyStrategy("dhondt-5", NewDHondtTally(5))
+	for _, tc := range []tallyTestCase{{
+		name:     "FiveSeats",
+		strategy: "dhondt-5",
+		opts:     &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 2},
+		votes: []tallyTestVote{
+			{values: []int{0}, weight: 100},
+			{values: []int{1}, weight: 80},
+			{values: []int{2}, weight: 30},
+			{values: []int{0, 1}, err: "max count overflow.*"},
+			{values: []int{}, err: "a single party must be chosen"},
+		},
+		live:  [][]string{{"100", "80", "30"}},
+		final: [][]string{{"100", "80", "30"}, {"3", "2", "0"}},
+	}, {
+		name:     "Tie",
+		strategy: "dhondt-5",
+		opts:     &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+		votes: []tallyTestVote{
+			{values: []int{0}, weight: 10},
+			{values: []int{1}, weight: 10},
+		},
+		live:  [][]string{{"10", "10"}},
+		final: [][]string{{"10", "10"}, {"3", "2"}},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			runTallyTest(t, tc)
+		})
+	}
+}
+
+// testMetadataStorage returns a storage holding the process metadata with the
+// given results aggregation, and the metadata URI.
+func testMetadataStorage(t *testing.T, aggregation string, seats int) (data.Storage, string) {
+	storage, err := data.Init(data.FS, &types.DataStore{Datadir: t.TempDir()})
+	qt.Assert(t, err, qt.IsNil)
+	metadata := &processMetadata{}
+	metadata.Results.Aggregation = aggregation
+	metadata.Results.Seats = seats
+	content, err := json.Marshal(metadata)
+	qt.Assert(t, err, qt.IsNil)
+	cid, err := storage.Publish(context.Background(), content)
+	qt.Assert(t, err, qt.IsNil)
+	return storage, storage.URIprefix() + cid
+}
+
+func TestTallyStrategyMetadata(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+
+	sc, err := NewScrutinizer(t.TempDir(), app, true)
+	qt.Assert(t, err, qt.IsNil)
+	storage, uri := testMetadataStorage(t, "borda", 0)
+	sc.SetMetadataStorage(storage)
+
+	// ranked ballot options, counted as borda
+	pid := util.RandomBytes(32)
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EnvelopeType: &models.EnvelopeType{UniqueValues: true},
+		Status:       models.ProcessStatus_READY,
+		BlockCount:   10,
+		Mode:         &models.ProcessMode{AutoStart: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 2},
+		Metadata:     &uri,
+	}), qt.IsNil)
+	// same options without metadata, default strategy
+	pid2 := util.RandomBytes(32)
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		ProcessId:    pid2,
+		EnvelopeType: &models.EnvelopeType{UniqueValues: true},
+		Status:       models.ProcessStatus_READY,
+		BlockCount:   10,
+		Mode:         &models.ProcessMode{AutoStart: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 2},
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	// the strategy is set on the first commit after the metadata is retrieved
+	for i := 0; ; i++ {
+		sc.pendingMetadataLock.Lock()
+		_, ok := sc.pendingMetadata[string(pid)]
+		sc.pendingMetadataLock.Unlock()
+		if ok {
+			break
+		}
+		qt.Assert(t, i < 100, qt.IsTrue, qt.Commentf("metadata not retrieved"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	proc, err := sc.ProcessInfo(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proc.TallyStrategy, qt.Equals, TallyRateProduct)
+	app.AdvanceTestBlock()
+
+	proc, err = sc.ProcessInfo(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proc.TallyStrategy, qt.Equals, TallyBorda)
+	proc, err = sc.ProcessInfo(pid2)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proc.TallyStrategy, qt.Equals, TallyRateProduct)
+
+	for _, p := range [][]byte{pid, pid2} {
+		qt.Assert(t, vote([]int{2, 0, 1}, sc, p, nil), qt.IsNil)
+		qt.Assert(t, vote([]int{0, 1}, sc, p, big.NewInt(2)), qt.IsNil)
+	}
+	result, err := sc.GetResults(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, GetFriendlyResults(result.Votes), qt.DeepEquals, [][]string{{"5", "2", "2"}})
+	result, err = sc.GetResults(pid2)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, GetFriendlyResults(result.Votes), qt.DeepEquals,
+		[][]string{{"2", "0", "1"}, {"1", "2", "0"}, {"0", "1", "0"}})
+}
+
+func TestTallyStrategyRecount(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+
+	sc, err := NewScrutinizer(t.TempDir(), app, true)
+	qt.Assert(t, err, qt.IsNil)
+
+	pid := util.RandomBytes(32)
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EnvelopeType: &models.EnvelopeType{UniqueValues: true},
+		Status:       models.ProcessStatus_READY,
+		BlockCount:   10,
+		Mode:         &models.ProcessMode{AutoStart: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 2},
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	// the votes are live counted with the default strategy
+	for i, ballot := range [][]int{{2, 0, 1}, {0, 1}} {
+		sc.Rollback()
+		vp, err := json.Marshal(vochain.VotePackage{
+			Nonce: fmt.Sprintf("%x", util.RandomBytes(32)),
+			Votes: ballot,
+		})
+		qt.Assert(t, err, qt.IsNil)
+		envelope := &models.VoteEnvelope{
+			Nonce:       util.RandomBytes(32),
+			ProcessId:   pid,
+			VotePackage: vp,
+			Nullifier:   util.RandomBytes(32),
+		}
+		voteTx, err := proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: envelope}})
+		qt.Assert(t, err, qt.IsNil)
+		signedTx, err := proto.Marshal(&models.SignedTx{Tx: voteTx, Signature: []byte{}})
+		qt.Assert(t, err, qt.IsNil)
+		_, err = app.SendTx(signedTx)
+		qt.Assert(t, err, qt.IsNil)
+		sc.OnVote(&models.Vote{
+			Nullifier:   envelope.Nullifier,
+			ProcessId:   pid,
+			VotePackage: vp,
+			Weight:      big.NewInt(int64(i + 1)).Bytes(),
+		}, types.VoterID{}.Nil(), 0)
+		qt.Assert(t, sc.Commit(uint32(i)), qt.IsNil)
+	}
+	result, err := sc.GetResults(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, GetFriendlyResults(result.Votes), qt.DeepEquals,
+		[][]string{{"2", "0", "1"}, {"1", "2", "0"}, {"0", "1", "0"}})
+
+	// and counted again once the strategy changes
+	qt.Assert(t, sc.setTallyStrategy(pid, TallyBorda), qt.IsNil)
+	result, err = sc.GetResults(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, result.TallyStrategy, qt.Equals, TallyBorda)
+	qt.Assert(t, GetFriendlyResults(result.Votes), qt.DeepEquals, [][]string{{"5", "2", "2"}})
+	qt.Assert(t, result.Weight.String(), qt.Equals, "3")
+}
+
+func TestTallyDHondtName(t *testing.T) {
+	strategy, err := GetTallyStrategy(DHondtTallyName(5))
+	qt.Assert(t, err, qt.IsNil)
+	dhondt, ok := strategy.(*DHondtTally)
+	qt.Assert(t, ok, qt.IsTrue)
+	qt.Assert(t, dhondt.seats, qt.Equals, 5)
+	for _, name := range []string{"dhondt", "dhondt:0", "dhondt:x"} {
+		_, err := GetTallyStrategy(name)
+		qt.Assert(t, err, qt.Not(qt.IsNil), qt.Commentf("name %q", name))
+	}
+}
@@ -94,11 +94,12 @@ func (s *Scrutinizer) GetEnvelope(nullifier []byte) (*indexertypes.EnvelopePacka
 		Weight:               voteRef.Weight.String(),
 		Signature:            stx.Signature,
 		Meta: indexertypes.EnvelopeMetadata{
-			ProcessId: envelope.ProcessId,
-			Nullifier: nullifier,
-			TxIndex:   voteRef.TxIndex,
-			Height:    voteRef.Height,
-			TxHash:    txHash,
+			ProcessId:      envelope.ProcessId,
+			Nullifier:      nullifier,
+			TxIndex:        voteRef.TxIndex,
+			Height:         voteRef.Height,
+			TxHash:         txHash,
+			OverwriteCount: voteRef.OverwriteCount,
 		},
 	}
 	if len(envelopePackage.Meta.VoterID) > 0 {
@@ -110,11 +111,49 @@ func (s *Scrutinizer) GetEnvelope(nullifier []byte) (*indexertypes.EnvelopePacka
 	return envelopePackage, nil
 }
 
+// committedVote returns the indexed vote of a nullifier, with its vote
+// package and weight.
+func (s *Scrutinizer) committedVote(nullifier []byte) (*models.Vote, error) {
+	voteRef, err := s.GetEnvelopeReference(nullifier)
+	if err != nil {
+		return nil, err
+	}
+	stx, err := s.App.GetTx(voteRef.Height, voteRef.TxIndex)
+	if err != nil {
+		return nil, err
+	}
+	tx := &models.Tx{}
+	if err := proto.Unmarshal(stx.Tx, tx); err != nil {
+		return nil, err
+	}
+	envelope := tx.GetVote()
+	if envelope == nil {
+		return nil, fmt.Errorf("transaction is not an Envelope")
+	}
+	return &models.Vote{
+		ProcessId:   voteRef.ProcessID,
+		Nullifier:   nullifier,
+		VotePackage: envelope.VotePackage,
+		Weight:      voteRef.Weight.Bytes(),
+		Height:      voteRef.Height,
+	}, nil
+}
+
 // WalkEnvelopes executes callback for each envelopes of the ProcessId.
 // The callback function is executed async (in a goroutine) if async=true.
 // The method will return once all goroutines have finished the work.
 func (s *Scrutinizer) WalkEnvelopes(processId []byte, async bool,
 	callback func(*models.VoteEnvelope, *big.Int)) error {
+	return s.walkVotes(processId, async,
+		func(txRef *indexertypes.VoteReference, envelope *models.VoteEnvelope) {
+			callback(envelope, txRef.Weight.ToInt())
+		})
+}
+
+// walkVotes executes callback for each envelope of the ProcessId, along with
+// its vote reference.  It works as WalkEnvelopes.
+func (s *Scrutinizer) walkVotes(processId []byte, async bool,
+	callback func(*indexertypes.VoteReference, *models.VoteEnvelope)) error {
 	wg := sync.WaitGroup{}
 
 	// There might be tens of thousands of votes.
@@ -147,7 +186,7 @@ func (s *Scrutinizer) WalkEnvelopes(processId []byte, async bool,
 					log.Errorf("transaction is not an Envelope")
 					return
 				}
-				callback(envelope, txRef.Weight.ToInt())
+				callback(txRef, envelope)
 			}
 			if async {
 				go func() {
@@ -196,11 +235,12 @@ func (s *Scrutinizer) GetEnvelopes(processId []byte, max, from int,
 				}
 				envelope.Nullifier = txRef.Nullifier
 				envelopeMetadata := &indexertypes.EnvelopeMetadata{
-					ProcessId: processId,
-					Nullifier: txRef.Nullifier,
-					TxIndex:   txRef.TxIndex,
-					Height:    txRef.Height,
-					TxHash:    txHash,
+					ProcessId:      processId,
+					Nullifier:      txRef.Nullifier,
+					TxIndex:        txRef.TxIndex,
+					Height:         txRef.Height,
+					TxHash:         txHash,
+					OverwriteCount: txRef.OverwriteCount,
 				}
 				if len(txRef.VoterID) > 0 {
 					envelopeMetadata.VoterID, err = txRef.VoterID.Address()
@@ -233,11 +273,12 @@ func (s *Scrutinizer) GetEnvelopes(processId []byte, max, from int,
 				}
 				envelope.Nullifier = txRef.Nullifier
 				envelopeMetadata := &indexertypes.EnvelopeMetadata{
-					ProcessId: processId,
-					Nullifier: txRef.Nullifier,
-					TxIndex:   txRef.TxIndex,
-					Height:    txRef.Height,
-					TxHash:    txHash,
+					ProcessId:      processId,
+					Nullifier:      txRef.Nullifier,
+					TxIndex:        txRef.TxIndex,
+					Height:         txRef.Height,
+					TxHash:         txHash,
+					OverwriteCount: txRef.OverwriteCount,
 				}
 				if len(txRef.VoterID) > 0 {
 					envelopeMetadata.VoterID, err = txRef.VoterID.Address()
@@ -298,6 +339,13 @@ func (s *Scrutinizer) ComputeResult(processID []byte) error {
 	if err != nil {
 		return fmt.Errorf("computeResult: cannot load processID %x from database: %w", processID, err)
 	}
+	// The metadata is retrieved again, so the final results don't depend
+	// on whether it was retrieved while the process was live
+	if name, err := s.metadataTallyStrategy(p.Metadata, p.Envelope, p.VoteOpts); err != nil {
+		log.Warnf("cannot pick the tally strategy of process %x: %v", processID, err)
+	} else if name != "" {
+		p.TallyStrategy = name
+	}
 	// Compute the results
 	var results *indexertypes.Results
 	if results, err = s.computeFinalResults(p); err != nil {
@@ -319,6 +367,7 @@ func (s *Scrutinizer) ComputeResult(processID []byte) error {
 					}
 					update.HaveResults = true
 					update.FinalResults = true
+					update.TallyStrategy = p.TallyStrategy
 					return nil
 				},
 			)
@@ -335,6 +384,8 @@ func (s *Scrutinizer) ComputeResult(processID []byte) error {
 		EnvelopeHeight: int64(results.EnvelopeHeight),
 		Signatures:     joinHexBytes(results.Signatures),
 		BlockHeight:    int64(results.BlockHeight),
+		RankedRounds:   encodeRankedRounds(results.RankedRounds),
+		TallyStrategy:  p.TallyStrategy,
 	}); err != nil {
 		return err
 	}
@@ -458,30 +509,57 @@ func unmarshalVote(VotePackage []byte, keys []string) (*vochain.VotePackage, err
 // addLiveVote adds the envelope vote to the results. It does not commit to the database.
 // This method is triggered by OnVote callback for each vote added to the blockchain.
 // If encrypted vote, only weight will be updated.
+// If previous is not nil, the vote overwrites it, so the previous vote is
+// subtracted from the results.
 func (s *Scrutinizer) addLiveVote(pid []byte, VotePackage []byte, weight *big.Int,
+	previous *models.Vote, results *indexertypes.Results) error {
+	open, err := s.isOpenProcess(pid)
+	if err != nil {
+		return fmt.Errorf("cannot check if process is open: %v", err)
+	}
+	addErr := countLiveVote(open, VotePackage, weight, results)
+	if previous == nil {
+		return addErr
+	}
+	// The previous vote is counted apart and then subtracted, if it was not
+	// valid it was never added to the results.
+	old := &indexertypes.Results{
+		Weight:        new(types.BigInt).SetUint64(0),
+		VoteOpts:      results.VoteOpts,
+		EnvelopeType:  results.EnvelopeType,
+		TallyStrategy: results.TallyStrategy,
+	}
+	if err := countLiveVote(open, previous.VotePackage,
+		new(big.Int).SetBytes(previous.GetWeight()), old); err != nil {
+		log.Debugf("overwritten vote %x was not counted: %v", previous.Nullifier, err)
+		return addErr
+	}
+	if err := results.Sub(old); err != nil {
+		return err
+	}
+	return addErr
+}
+
+// countLiveVote counts the vote into the results.  If the process is not open
+// or the vote can't be decoded, only the weight is counted.
+func countLiveVote(open bool, VotePackage []byte, weight *big.Int,
 	results *indexertypes.Results) error {
-	// If live process, add vote to temporary results
 	var vote *vochain.VotePackage
-	if open, err := s.isOpenProcess(pid); open && err == nil {
+	if open {
+		var err error
 		vote, err = unmarshalVote(VotePackage, []string{})
 		if err != nil {
 			log.Warnf("cannot unmarshal vote: %v", err)
 			vote = nil
 		}
-	} else if err != nil {
-		return fmt.Errorf("cannot check if process is open: %v", err)
 	}
-
 	// Add the vote only if the election is unencrypted
 	if vote != nil {
-		if err := results.AddVote(vote.Votes, weight, nil); err != nil {
-			return err
-		}
-	} else {
-		// If encrypted, just add the weight
-		results.Weight.Add(results.Weight, (*types.BigInt)(weight))
-		results.EnvelopeHeight++
+		return addVote(results, vote.Votes, weight, nil)
 	}
+	// If encrypted, just add the weight
+	results.Weight.Add(results.Weight, (*types.BigInt)(weight))
+	results.EnvelopeHeight++
 	return nil
 }
 
@@ -489,7 +567,8 @@ func (s *Scrutinizer) addLiveVote(pid []byte, VotePackage []byte, weight *big.In
 // This method is triggered by Commit callback for each vote added to the blockchain.
 // If txn is provided the vote will be added on the transaction (without performing a commit).
 func (s *Scrutinizer) addVoteIndex(nullifier, pid []byte, blockHeight uint32,
-	weight []byte, txIndex int32, voterID types.VoterID, txn *badger.Txn) error {
+	weight []byte, txIndex int32, voterID types.VoterID, overwriteCount uint32,
+	txn *badger.Txn) error {
 	weightInt := new(types.BigInt).SetBytes(weight)
 	weightStr, err := weightInt.MarshalText()
 	if err != nil {
@@ -498,21 +577,23 @@ func (s *Scrutinizer) addVoteIndex(nullifier, pid []byte, blockHeight uint32,
 	creationTime := time.Now()
 	if enableBadgerhold {
 		bhVoteRef := &indexertypes.VoteReference{
-			Nullifier:    nullifier,
-			ProcessID:    pid,
-			VoterID:      voterID,
-			Height:       blockHeight,
-			Weight:       weightInt,
-			TxIndex:      txIndex,
-			CreationTime: creationTime,
+			Nullifier:      nullifier,
+			ProcessID:      pid,
+			VoterID:        voterID,
+			Height:         blockHeight,
+			Weight:         weightInt,
+			TxIndex:        txIndex,
+			CreationTime:   creationTime,
+			OverwriteCount: overwriteCount,
 		}
+		// an overwritten vote replaces the reference of the previous one
 		if txn != nil {
-			if err := s.db.TxInsert(txn, nullifier, bhVoteRef); err != nil {
+			if err := s.db.TxUpsert(txn, nullifier, bhVoteRef); err != nil {
 				return err
 			}
 		} else {
 			if err := s.queryWithRetries(func() error {
-				return s.db.Insert(nullifier, bhVoteRef)
+				return s.db.Upsert(nullifier, bhVoteRef)
 			}); err != nil {
 				return err
 			}
@@ -524,13 +605,14 @@ func (s *Scrutinizer) addVoteIndex(nullifier, pid []byte, blockHeight uint32,
 	queries, ctx, cancel := s.timeoutQueries()
 	defer cancel()
 	if _, err := queries.CreateVoteReference(ctx, scrutinizerdb.CreateVoteReferenceParams{
-		Nullifier:    nullifier,
-		ProcessID:    pid,
-		Height:       int64(blockHeight),
-		Weight:       string(weightStr),
-		TxIndex:      int64(txIndex),
-		VoterID:      voterID,
-		CreationTime: creationTime,
+		Nullifier:      nullifier,
+		ProcessID:      pid,
+		Height:         int64(blockHeight),
+		Weight:         string(weightStr),
+		TxIndex:        int64(txIndex),
+		VoterID:        voterID,
+		CreationTime:   creationTime,
+		OverwriteCount: int64(overwriteCount),
 	}); err != nil {
 		return err
 	}
@@ -632,21 +714,46 @@ func (s *Scrutinizer) computeFinalResults(p *indexertypes.Process) (*indexertype
 		return nil, fmt.Errorf("maxCount and/or maxValue overflows hardcoded maximum")
 	}
 	results := &indexertypes.Results{
-		Votes:        indexertypes.NewEmptyVotes(int(p.VoteOpts.MaxCount), int(p.VoteOpts.MaxValue)+1),
-		ProcessID:    p.ID,
-		Weight:       new(types.BigInt).SetUint64(0),
-		Final:        true,
-		VoteOpts:     p.VoteOpts,
-		EnvelopeType: p.Envelope,
-		BlockHeight:  s.App.Height(),
+		ProcessID:     p.ID,
+		Weight:        new(types.BigInt).SetUint64(0),
+		Final:         true,
+		VoteOpts:      p.VoteOpts,
+		EnvelopeType:  p.Envelope,
+		BlockHeight:   s.App.Height(),
+		TallyStrategy: p.TallyStrategy,
+	}
+	strategy, err := resultsTallyStrategy(results)
+	if err != nil {
+		return nil, err
+	}
+	results.Votes = strategy.NewVotes(p.VoteOpts)
+
+	// Some strategies need the individual ballots for the final results
+	keepBallots := strategy.KeepBallots()
+	var ballots []indexertypes.Ballot
+
+	// On delegated voting processes, the weight of the ballots depends on
+	// the other ballots of the process
+	var delegatedWeights map[string]*big.Int
+	if vochain.ProcessDelegatedVoting(p.Mode) {
+		if delegatedWeights, err = s.delegatedWeights(p.ID); err != nil {
+			return nil, fmt.Errorf("cannot resolve delegated votes: %w", err)
+		}
 	}
 
 	var nvotes uint64
-	var err error
 	lock := sync.Mutex{}
 
-	if err = s.WalkEnvelopes(p.ID, true, func(vote *models.VoteEnvelope,
-		weight *big.Int) {
+	if err = s.walkVotes(p.ID, true, func(txRef *indexertypes.VoteReference,
+		vote *models.VoteEnvelope) {
+		weight := txRef.Weight.ToInt()
+		if delegatedWeights != nil {
+			var ok bool
+			if weight, ok = delegatedWeights[string(txRef.Nullifier)]; !ok {
+				log.Warnf("vote %x not found on the delegated votes", txRef.Nullifier)
+				return
+			}
+		}
 		var vp *vochain.VotePackage
 		var err error
 		if p.Envelope.GetEncryptedVotes() {
@@ -675,12 +782,20 @@ func (s *Scrutinizer) computeFinalResults(p *indexertypes.Process) (*indexertype
 			return
 		}
 
-		if err = results.AddVote(vp.Votes, weight, &lock); err != nil {
+		if err = addVote(results, vp.Votes, weight, &lock); err != nil {
 			log.Warnf("addVote failed: %v", err)
 			return
 		}
+		if keepBallots {
+			lock.Lock()
+			ballots = append(ballots, indexertypes.Ballot{Values: vp.Votes, Weight: weight})
+			lock.Unlock()
+		}
 		atomic.AddUint64(&nvotes, 1)
 	}); err == nil {
+		if err := strategy.FinalResults(results, ballots); err != nil {
+			return nil, fmt.Errorf("cannot compute final results: %w", err)
+		}
 		log.Infof("computed results for process %x with %d votes", p.ID, nvotes)
 		log.Debugf("results: %s", results)
 	}
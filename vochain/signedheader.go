@@ -0,0 +1,72 @@
+package vochain
+
+import (
+	"bytes"
+	"fmt"
+
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// EncodeSignedHeader returns the protobuf encoding of a block header with its
+// commit, and of the validator set of the block.  They are included in the
+// proofs verified offline, such as the results certificates.
+func EncodeSignedHeader(header *tmtypes.SignedHeader, validators *tmtypes.ValidatorSet) ([]byte, []byte, error) {
+	headerBytes, err := header.ToProto().Marshal()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot encode signed header: %w", err)
+	}
+	validatorsProto, err := validators.ToProto()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot encode validator set: %w", err)
+	}
+	validatorsBytes, err := validatorsProto.Marshal()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot encode validator set: %w", err)
+	}
+	return headerBytes, validatorsBytes, nil
+}
+
+// VerifySignedHeader decodes a block header and validator set encoded by
+// EncodeSignedHeader, and checks that it is the header of the block at height
+// committed by +2/3 of the voting power of the validator set.  The validator
+// set comes with the header, so its hash must be the trusted validatorsHash.
+func VerifySignedHeader(chainID string, height int64, headerBytes,
+	validatorsBytes, validatorsHash []byte) (*tmtypes.SignedHeader, error) {
+	if len(validatorsHash) == 0 {
+		return nil, fmt.Errorf("a trusted validators hash is required")
+	}
+	var headerProto tmproto.SignedHeader
+	if err := headerProto.Unmarshal(headerBytes); err != nil {
+		return nil, fmt.Errorf("cannot decode signed header: %w", err)
+	}
+	header, err := tmtypes.SignedHeaderFromProto(&headerProto)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signed header: %w", err)
+	}
+	if err := header.ValidateBasic(chainID); err != nil {
+		return nil, fmt.Errorf("invalid signed header: %w", err)
+	}
+	if header.Height != height {
+		return nil, fmt.Errorf("header height is %d, expected %d", header.Height, height)
+	}
+	var validatorsProto tmproto.ValidatorSet
+	if err := validatorsProto.Unmarshal(validatorsBytes); err != nil {
+		return nil, fmt.Errorf("cannot decode validator set: %w", err)
+	}
+	validators, err := tmtypes.ValidatorSetFromProto(&validatorsProto)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validator set: %w", err)
+	}
+	if !bytes.Equal(header.ValidatorsHash, validators.Hash()) {
+		return nil, fmt.Errorf("validator set does not match the header validators hash")
+	}
+	if !bytes.Equal(header.ValidatorsHash, validatorsHash) {
+		return nil, fmt.Errorf("validator set hash %X is not the trusted one", header.ValidatorsHash)
+	}
+	if err := validators.VerifyCommitLight(chainID, header.Commit.BlockID,
+		header.Height, header.Commit); err != nil {
+		return nil, fmt.Errorf("invalid block commit: %w", err)
+	}
+	return header, nil
+}
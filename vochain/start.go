@@ -25,6 +25,7 @@ import (
 	tmlog "github.com/tendermint/tendermint/libs/log"
 	tmos "github.com/tendermint/tendermint/libs/os"
 	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/statedb"
 )
 
 const downloadZkVKsTimeout = 1 * time.Minute
@@ -65,6 +66,11 @@ func NewVochain(vochaincfg *config.VochainCfg, genesis []byte) *BaseApplication
 	if err != nil {
 		log.Fatalf("cannot initialize vochain application: %s", err)
 	}
+	app.SetSnapshotInterval(uint32(vochaincfg.SnapshotInterval))
+	app.State.SetPruning(statedb.PruneMode{
+		KeepRecent: uint32(vochaincfg.StatePruning.KeepRecent),
+		KeepEvery:  uint32(vochaincfg.StatePruning.KeepEvery),
+	})
 	log.Info("creating tendermint node and application")
 	err = app.SetNode(vochaincfg, genesis)
 	if err != nil {
@@ -457,13 +463,28 @@ func newTendermint(app *BaseApplication,
 	log.Infof("consensus block time target: commit=%.2fs propose=%.2fs",
 		tconfig.Consensus.TimeoutCommit.Seconds(), tconfig.Consensus.TimeoutPropose.Seconds())
 
-	// disable transaction indexer (we don't use it)
+	// state sync config
+	tconfig.StateSync.Enable = localConfig.StateSync.Enabled
+	if tconfig.StateSync.Enable {
+		tconfig.StateSync.RPCServers = localConfig.StateSync.RPCServers
+		tconfig.StateSync.TrustHeight = localConfig.StateSync.TrustHeight
+		tconfig.StateSync.TrustHash = localConfig.StateSync.TrustHash
+		log.Infof("state sync enabled, trusting height %d with hash %s",
+			tconfig.StateSync.TrustHeight, tconfig.StateSync.TrustHash)
+	}
+
+	// transaction indexer, disabled unless the events are to be searched
 	tconfig.TxIndex.Indexer = "null"
+	if localConfig.TxIndexer {
+		tconfig.TxIndex.Indexer = "kv"
+	}
 
-	// mempool config
+	// mempool config, the transactions are rechecked after each block so
+	// the invalid ones are evicted, and they are not kept in the cache so
+	// they can be sent again (i.e. after a nonce gap is filled)
 	tconfig.Mempool.Size = localConfig.MempoolSize
-	tconfig.Mempool.Recheck = false
-	tconfig.Mempool.KeepInvalidTxsInCache = true
+	tconfig.Mempool.Recheck = true
+	tconfig.Mempool.KeepInvalidTxsInCache = false
 	tconfig.Mempool.MaxTxsBytes = int64(tconfig.Mempool.Size * tconfig.Mempool.MaxTxBytes)
 	tconfig.Mempool.CacheSize = 100000
 
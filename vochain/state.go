@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
@@ -16,7 +17,9 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/vocdoni/arbo"
+	"go.vocdoni.io/dvote/crypto/dkg"
 	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/crypto/nacl"
 	"go.vocdoni.io/dvote/db"
 	"go.vocdoni.io/dvote/db/metadb"
 	"go.vocdoni.io/dvote/log"
@@ -24,6 +27,7 @@ import (
 
 	"go.vocdoni.io/dvote/types"
 	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -36,6 +40,9 @@ const (
 	storageDirectory = "vcstate"
 	// snapshotsDirectory is the final directory name where the snapshots are stored.
 	snapshotsDirectory = "snapshots"
+	// pruneTempDirectory is the directory name where the temporary databases
+	// used while pruning the state are created.
+	pruneTempDirectory = "prune"
 	// treasurerKey is the key representing the Treasurer entry on the Extra subtree
 	treasurerKey = "treasurer"
 )
@@ -234,6 +241,9 @@ type State struct {
 	mempoolRemoveTxKeys func([][32]byte, bool)
 	txCounter           int32
 	eventListeners      []EventListener
+	// validatorUpdates are the validator changes of the current block, to be
+	// sent to Tendermint on EndBlock.  Must be accessed via the Tx mutex.
+	validatorUpdates []*models.Validator
 	// currentHeight is the height of the current started block
 	currentHeight uint32
 	// chainID identifies the blockchain
@@ -387,6 +397,28 @@ func (v *State) mainTreeViewer(committed bool) statedb.TreeViewer {
 	return v.Tx.AsTreeView()
 }
 
+// SetPruning enables the pruning of the old state versions following mode.
+// The versions not kept can't be queried anymore.
+func (v *State) SetPruning(mode statedb.PruneMode) {
+	if mode.TempDir == "" {
+		mode.TempDir = filepath.Join(v.dataDir, storageDirectory, pruneTempDirectory)
+	}
+	v.Store.SetPruning(mode, stateTreesSchema())
+}
+
+// mainTreeViewAt returns the mainTree committed at the given height as a
+// TreeView.  If height is 0, the last committed mainTree is returned.
+func (v *State) mainTreeViewAt(height uint32) (*statedb.TreeView, error) {
+	if height == 0 {
+		return v.MainTreeView(), nil
+	}
+	root, err := v.Store.VersionRoot(height)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get state root at height %d: %w", height, err)
+	}
+	return v.Store.TreeView(root)
+}
+
 // AddEventListener adds a new event listener, to receive method calls on block
 // events as documented in EventListener.
 func (v *State) AddEventListener(l EventListener) {
@@ -427,8 +459,21 @@ func (v *State) Oracles(committed bool) ([]common.Address, error) {
 		v.Tx.RLock()
 		defer v.Tx.RUnlock()
 	}
+	return getOracles(v.mainTreeViewer(committed))
+}
+
+// OraclesAt returns the oracles list as it was committed at the given height.
+// If height is 0, the last committed version is used.
+func (v *State) OraclesAt(height uint32) ([]common.Address, error) {
+	mainTreeView, err := v.mainTreeViewAt(height)
+	if err != nil {
+		return nil, err
+	}
+	return getOracles(mainTreeView)
+}
 
-	oraclesTree, err := v.mainTreeViewer(committed).SubTree(StateTreeCfg(TreeOracles))
+func getOracles(mainTreeView statedb.TreeViewer) ([]common.Address, error) {
+	oraclesTree, err := mainTreeView.SubTree(StateTreeCfg(TreeOracles))
 	if err != nil {
 		return nil, err
 	}
@@ -437,10 +482,10 @@ func (v *State) Oracles(committed bool) ([]common.Address, error) {
 	if err := oraclesTree.Iterate(func(key, value []byte) bool {
 		// removed oracles are still in the tree but with value set to nil
 		if len(value) == 0 {
-			return true
+			return false
 		}
 		oracles = append(oracles, common.BytesToAddress(key))
-		return true
+		return false
 	}); err != nil {
 		return nil, err
 	}
@@ -518,15 +563,20 @@ func (v *State) IncrementTreasurerNonce() error {
 	if err != nil {
 		return fmt.Errorf("incrementTreasurerNonce(): %w", err)
 	}
-	v.Tx.Lock()
-	defer v.Tx.Unlock()
 	t.Nonce++
 	tBytes, err := proto.Marshal(t)
 	if err != nil {
 		return fmt.Errorf("incrementTreasurerNonce(): %w", err)
 	}
 	log.Debugf("incrementing treasurer nonce, new nonce is %d", t.Nonce)
-	return v.Tx.DeepSet([]byte(treasurerKey), tBytes, StateTreeCfg(TreeExtra))
+	v.Tx.Lock()
+	err = v.Tx.DeepSet([]byte(treasurerKey), tBytes, StateTreeCfg(TreeExtra))
+	v.Tx.Unlock()
+	if err != nil {
+		return fmt.Errorf("incrementTreasurerNonce(): %w", err)
+	}
+	// the multisig proposals with the previous nonces cannot be executed
+	return v.pruneMultisigProposals(t.Nonce)
 }
 
 // SetTxCost sets the given transaction cost
@@ -548,15 +598,29 @@ func (v *State) SetTxCost(txType models.TxType, cost uint64) error {
 // data from the currently open StateDB transaction.
 // When committed is true, the operation is executed on the last commited version.
 func (v *State) TxCost(txType models.TxType, committed bool) (uint64, error) {
-	key, ok := TxTypeCostToStateKey[txType]
-	if !ok {
-		return 0, fmt.Errorf("txType %v shouldn't cost anything", txType)
-	}
 	if !committed {
 		v.Tx.RLock()
 		defer v.Tx.RUnlock()
 	}
-	extraTree, err := v.mainTreeViewer(committed).SubTree(StateTreeCfg(TreeExtra))
+	return getTxCost(v.mainTreeViewer(committed), txType)
+}
+
+// TxCostAt returns the cost of a given transaction as it was committed at the
+// given height.  If height is 0, the last committed version is used.
+func (v *State) TxCostAt(txType models.TxType, height uint32) (uint64, error) {
+	mainTreeView, err := v.mainTreeViewAt(height)
+	if err != nil {
+		return 0, err
+	}
+	return getTxCost(mainTreeView, txType)
+}
+
+func getTxCost(mainTreeView statedb.TreeViewer, txType models.TxType) (uint64, error) {
+	key, ok := TxTypeCostToStateKey[txType]
+	if !ok {
+		return 0, fmt.Errorf("txType %v shouldn't cost anything", txType)
+	}
+	extraTree, err := mainTreeView.SubTree(StateTreeCfg(TreeExtra))
 	if err != nil {
 		return 0, err
 	}
@@ -645,6 +709,103 @@ func (v *State) RemoveValidator(address []byte) error {
 	return validators.Set(address, nil)
 }
 
+// Validator returns the chain validator identified by its address, or nil if
+// it does not exist.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) Validator(address []byte, committed bool) (*models.Validator, error) {
+	validators, err := v.Validators(committed)
+	if err != nil {
+		return nil, err
+	}
+	for _, validator := range validators {
+		if bytes.Equal(validator.Address, address) {
+			return validator, nil
+		}
+	}
+	return nil, nil
+}
+
+// queueValidatorUpdate adds the validator to the updates of the current block.
+// A previous update of the same validator is replaced, since Tendermint does
+// not accept duplicates.  A validator with power 0 is removed.
+func (v *State) queueValidatorUpdate(validator *models.Validator) {
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	for i, update := range v.validatorUpdates {
+		if bytes.Equal(update.Address, validator.Address) {
+			v.validatorUpdates[i] = validator
+			return
+		}
+	}
+	v.validatorUpdates = append(v.validatorUpdates, validator)
+}
+
+// ValidatorUpdates returns the validator changes of the current block
+func (v *State) ValidatorUpdates() []*models.Validator {
+	v.Tx.RLock()
+	defer v.Tx.RUnlock()
+	return append([]*models.Validator{}, v.validatorUpdates...)
+}
+
+// validatorsPower returns the total voting power of the validator set once the
+// updates queued in the current block are applied, without the validator at
+// address.
+func (v *State) validatorsPower(address []byte) (uint64, error) {
+	validators, err := v.Validators(false)
+	if err != nil {
+		return 0, err
+	}
+	powers := make(map[string]uint64, len(validators))
+	for _, validator := range validators {
+		powers[string(validator.Address)] = validator.Power
+	}
+	for _, update := range v.ValidatorUpdates() {
+		powers[string(update.Address)] = update.Power
+	}
+	delete(powers, string(address))
+	var total uint64
+	for _, power := range powers {
+		total += power
+	}
+	return total, nil
+}
+
+// pathValidatorTxApprovals is the db path prefix of the oracle approvals of the
+// pending validator transactions.
+const pathValidatorTxApprovals = "validatorTxApprovals/"
+
+// validatorTxApprovals returns the oracles that approved the pending validator
+// transaction identified by id.
+func (v *State) validatorTxApprovals(id []byte) ([]common.Address, error) {
+	v.Tx.RLock()
+	defer v.Tx.RUnlock()
+	approvals, err := v.Tx.NoState().Get(append([]byte(pathValidatorTxApprovals), id...))
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var oracles []common.Address
+	for i := 0; i+common.AddressLength <= len(approvals); i += common.AddressLength {
+		oracles = append(oracles, common.BytesToAddress(approvals[i:i+common.AddressLength]))
+	}
+	return oracles, nil
+}
+
+// setValidatorTxApprovals stores the oracles that approved the pending
+// validator transaction identified by id.  No approvals clear the record.
+func (v *State) setValidatorTxApprovals(id []byte, oracles []common.Address) error {
+	approvals := []byte{}
+	for _, oracle := range oracles {
+		approvals = append(approvals, oracle.Bytes()...)
+	}
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	return v.Tx.NoState().Set(append([]byte(pathValidatorTxApprovals), id...), approvals)
+}
+
 // Validators returns a list of the chain validators
 // When committed is false, the operation is executed also on not yet commited
 // data from the currently open StateDB transaction.
@@ -684,7 +845,9 @@ func (v *State) Validators(committed bool) ([]*models.Validator, error) {
 	return validators, nil
 }
 
-// AddProcessKeys adds the keys to the process
+// AddProcessKeys adds the keys to the process.  If the key is a threshold key
+// dealing, the joint public key is stored on index 0 once frozen, when the
+// process starts.
 func (v *State) AddProcessKeys(tx *models.AdminTx) error {
 	if tx.ProcessId == nil || tx.KeyIndex == nil {
 		return fmt.Errorf("no processId or keyIndex provided on AddProcessKeys")
@@ -711,7 +874,11 @@ func (v *State) AddProcessKeys(tx *models.AdminTx) error {
 	return nil
 }
 
-// RevealProcessKeys reveals the keys of a process
+// RevealProcessKeys reveals the keys of a process.  If the key is a threshold
+// key share and there are enough shares, the joint private key is recovered
+// and stored on index 0, and no more keys are required.  The shares of the
+// threshold keys are not counted by the process KeyIndex, since the key
+// keepers without a dealing reveal their share too.
 func (v *State) RevealProcessKeys(tx *models.AdminTx) error {
 	if tx.ProcessId == nil || tx.KeyIndex == nil {
 		return fmt.Errorf("no processId or keyIndex provided on AddProcessKeys")
@@ -730,7 +897,22 @@ func (v *State) RevealProcessKeys(tx *models.AdminTx) error {
 		log.Debugf("revealed encryption key %d for process %x: %x",
 			*tx.KeyIndex, tx.ProcessId, tx.EncryptionPrivateKey)
 	}
-	*process.KeyIndex--
+	// on threshold keys, recover the joint private key once there are
+	// enough shares and store it on index 0
+	if IsThresholdKeyProcess(process) {
+		priv, err := recoverThresholdKey(process)
+		if err != nil {
+			return err
+		}
+		if priv != nil {
+			ekey = fmt.Sprintf("%x", priv)
+			process.EncryptionPrivateKeys[0] = ekey
+			*process.KeyIndex = 0
+			log.Debugf("recovered threshold encryption key for process %x", tx.ProcessId)
+		}
+	} else {
+		*process.KeyIndex--
+	}
 	if err := v.updateProcess(process, tx.ProcessId); err != nil {
 		return err
 	}
@@ -740,6 +922,67 @@ func (v *State) RevealProcessKeys(tx *models.AdminTx) error {
 	return nil
 }
 
+// isKeyDealing returns true if the hex encoded process key is a threshold key
+// dealing instead of a regular nacl public key.
+func isKeyDealing(key string) bool {
+	return len(key) > 0 && len(key) != nacl.KeyLength*2
+}
+
+// processKeyDealings returns the threshold key dealings added to the process
+// and the number of regular keys.  Index 0 holds the joint key, so it is
+// skipped.
+func processKeyDealings(process *models.Process) ([]*dkg.Dealing, int, error) {
+	dealings := []*dkg.Dealing{}
+	regular := 0
+	for i := 1; i < len(process.EncryptionPublicKeys); i++ {
+		key := process.EncryptionPublicKeys[i]
+		if len(key) == 0 {
+			continue
+		}
+		if !isKeyDealing(key) {
+			regular++
+			continue
+		}
+		data, err := hex.DecodeString(key)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot decode threshold key dealing %d: %w", i, err)
+		}
+		dealing, err := dkg.UnmarshalDealing(data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot decode threshold key dealing %d: %w", i, err)
+		}
+		dealings = append(dealings, dealing)
+	}
+	return dealings, regular, nil
+}
+
+// recoverThresholdKey combines the revealed threshold key shares of the
+// process.  Returns nil if there are not enough shares yet.
+func recoverThresholdKey(process *models.Process) ([]byte, error) {
+	dealings, _, err := processKeyDealings(process)
+	if err != nil {
+		return nil, err
+	}
+	if len(dealings) == 0 {
+		return nil, fmt.Errorf("process has no threshold key dealings")
+	}
+	shares := make(map[int][]byte)
+	for i := 1; i < len(process.EncryptionPrivateKeys); i++ {
+		if len(process.EncryptionPrivateKeys[i]) == 0 {
+			continue
+		}
+		share, err := hex.DecodeString(process.EncryptionPrivateKeys[i])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode threshold key share %d: %w", i, err)
+		}
+		shares[i] = share
+	}
+	if len(shares) < dealings[0].Threshold() {
+		return nil, nil
+	}
+	return dkg.Combine(dealings, shares)
+}
+
 // VoteCount return the global vote count.
 // When committed is false, the operation is executed also on not yet commited
 // data from the currently open StateDB transaction.
@@ -775,12 +1018,28 @@ func (v *State) voteCountInc() error {
 }
 
 // AddVote adds a new vote to a process and call the even listeners to OnVote.
-// This method does not check if the vote already exist!
+// If the nullifier already voted, the vote is overwritten as long as the
+// process allows more vote overwrites, and its overwrite counter is increased.
 func (v *State) AddVote(vote *models.Vote, voterID types.VoterID) error {
-	vid, err := v.voteID(vote.ProcessId, vote.Nullifier)
+	vid, err := VoteID(vote.ProcessId, vote.Nullifier)
 	if err != nil {
 		return err
 	}
+	overwrite := false
+	overwriteCount, err := v.VoteOverwriteCount(vote.ProcessId, vote.Nullifier, false)
+	if err == nil {
+		process, err := v.Process(vote.ProcessId, false)
+		if err != nil {
+			return err
+		}
+		if err := checkVoteOverwrite(process, vote.Nullifier, overwriteCount); err != nil {
+			return err
+		}
+		overwrite = true
+		overwriteCount++
+	} else if !errors.Is(err, ErrVoteDoesNotExist) && !errors.Is(err, ErrProcessNotFound) {
+		return err
+	}
 	// save block number
 	vote.Height = v.CurrentHeight()
 	voteBytes, err := proto.Marshal(vote)
@@ -793,6 +1052,7 @@ func (v *State) AddVote(vote *models.Vote, voterID types.VoterID) error {
 		ProcessId: vote.ProcessId,
 		Nullifier: vote.Nullifier,
 	}
+	setVoteOverwriteCount(&sdbVote, overwriteCount)
 	sdbVoteBytes, err := proto.Marshal(&sdbVote)
 	if err != nil {
 		return fmt.Errorf("cannot marshal sdbVote: %w", err)
@@ -800,6 +1060,10 @@ func (v *State) AddVote(vote *models.Vote, voterID types.VoterID) error {
 	v.Tx.Lock()
 	err = func() error {
 		treeCfg := StateChildTreeCfg(ChildTreeVotes)
+		if overwrite {
+			return v.Tx.DeepSet(vid, sdbVoteBytes,
+				StateTreeCfg(TreeProcess), treeCfg.WithKey(vote.ProcessId))
+		}
 		if err := v.Tx.DeepAdd(vid, sdbVoteBytes,
 			StateTreeCfg(TreeProcess), treeCfg.WithKey(vote.ProcessId)); err != nil {
 			return err
@@ -818,8 +1082,9 @@ func (v *State) AddVote(vote *models.Vote, voterID types.VoterID) error {
 
 // NOTE(Edu): Changed this from byte(processID+nullifier) to
 // hash(processID+nullifier) to allow using it as a key in Arbo tree.
+// VoteID returns the key of a vote in the votes tree of its process,
 // voteID = hash(processID+nullifier)
-func (v *State) voteID(pid, nullifier []byte) ([]byte, error) {
+func VoteID(pid, nullifier []byte) ([]byte, error) {
 	if len(pid) != types.ProcessIDsize {
 		return nil, fmt.Errorf("wrong processID size %d", len(pid))
 	}
@@ -837,7 +1102,7 @@ func (v *State) voteID(pid, nullifier []byte) ([]byte, error) {
 // data from the currently open StateDB transaction.
 // When committed is true, the operation is executed on the last commited version.
 func (v *State) Envelope(processID, nullifier []byte, committed bool) (_ []byte, err error) {
-	vid, err := v.voteID(processID, nullifier)
+	vid, err := VoteID(processID, nullifier)
 	if err != nil {
 		return nil, err
 	}
@@ -845,8 +1110,34 @@ func (v *State) Envelope(processID, nullifier []byte, committed bool) (_ []byte,
 		v.Tx.RLock()
 		defer v.Tx.RUnlock() // needs to be deferred due to the recover above
 	}
+	return getEnvelope(v.mainTreeViewer(committed), processID, vid)
+}
+
+// EnvelopeAt returns the hash of a stored vote as it was committed at the
+// given height.  If height is 0, the last committed version is used.
+func (v *State) EnvelopeAt(processID, nullifier []byte, height uint32) ([]byte, error) {
+	vid, err := VoteID(processID, nullifier)
+	if err != nil {
+		return nil, err
+	}
+	mainTreeView, err := v.mainTreeViewAt(height)
+	if err != nil {
+		return nil, err
+	}
+	return getEnvelope(mainTreeView, processID, vid)
+}
+
+func getEnvelope(mainTreeView statedb.TreeViewer, processID, vid []byte) ([]byte, error) {
+	sdbVote, err := getStateDBVote(mainTreeView, processID, vid)
+	if err != nil {
+		return nil, err
+	}
+	return sdbVote.VoteHash, nil
+}
+
+func getStateDBVote(mainTreeView statedb.TreeViewer, processID, vid []byte) (*models.StateDBVote, error) {
 	treeCfg := StateChildTreeCfg(ChildTreeVotes)
-	votesTree, err := v.mainTreeViewer(committed).DeepSubTree(
+	votesTree, err := mainTreeView.DeepSubTree(
 		StateTreeCfg(TreeProcess), treeCfg.WithKey(processID))
 	if errors.Is(err, arbo.ErrKeyNotFound) {
 		return nil, ErrProcessNotFound
@@ -863,7 +1154,86 @@ func (v *State) Envelope(processID, nullifier []byte, committed bool) (_ []byte,
 	if err := proto.Unmarshal(sdbVoteBytes, &sdbVote); err != nil {
 		return nil, fmt.Errorf("cannot unmarshal sdbVote: %w", err)
 	}
-	return sdbVote.VoteHash, nil
+	return &sdbVote, nil
+}
+
+// VoteOverwriteCount returns the number of times the vote of a nullifier has
+// been overwritten, or ErrVoteDoesNotExist if the nullifier has not voted.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) VoteOverwriteCount(processID, nullifier []byte, committed bool) (uint32, error) {
+	vid, err := VoteID(processID, nullifier)
+	if err != nil {
+		return 0, err
+	}
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	sdbVote, err := getStateDBVote(v.mainTreeViewer(committed), processID, vid)
+	if err != nil {
+		return 0, err
+	}
+	return voteOverwriteCount(sdbVote), nil
+}
+
+// CheckVoteOverwrite returns an error if the nullifier has already voted in
+// the process and it can't overwrite its vote anymore.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) CheckVoteOverwrite(process *models.Process, nullifier []byte, committed bool) error {
+	overwriteCount, err := v.VoteOverwriteCount(process.ProcessId, nullifier, committed)
+	if errors.Is(err, ErrVoteDoesNotExist) || errors.Is(err, ErrProcessNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return checkVoteOverwrite(process, nullifier, overwriteCount)
+}
+
+// checkVoteOverwrite returns an error if a vote already overwritten
+// overwriteCount times can't be overwritten again.
+func checkVoteOverwrite(process *models.Process, nullifier []byte, overwriteCount uint32) error {
+	maxOverwrites := process.GetVoteOptions().GetMaxVoteOverwrites()
+	if maxOverwrites == 0 {
+		return fmt.Errorf("vote %x already exists", nullifier)
+	}
+	if overwriteCount >= maxOverwrites {
+		return fmt.Errorf("vote %x reached the maximum number of overwrites (%d)", nullifier, maxOverwrites)
+	}
+	return nil
+}
+
+// sdbVoteOverwriteCountField is the protobuf field number of the vote
+// overwrite counter in the StateDBVote leaf.  The counter is not part of the
+// models.StateDBVote message yet, so it is kept as an unknown field, and it is
+// omitted while zero so the leafs of the votes never overwritten don't change.
+const sdbVoteOverwriteCountField = 4
+
+// setVoteOverwriteCount sets the overwrite counter of a StateDBVote leaf.
+func setVoteOverwriteCount(sdbVote *models.StateDBVote, count uint32) {
+	b := withoutUnknownField(sdbVote, sdbVoteOverwriteCountField)
+	if count > 0 {
+		b = protowire.AppendTag(b, sdbVoteOverwriteCountField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(count))
+	}
+	sdbVote.ProtoReflect().SetUnknown(b)
+}
+
+// voteOverwriteCount returns the overwrite counter of a StateDBVote leaf.
+func voteOverwriteCount(sdbVote *models.StateDBVote) uint32 {
+	var count uint32
+	_ = walkUnknownFields(sdbVote, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != sdbVoteOverwriteCountField || typ != protowire.VarintType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		v, n := protowire.ConsumeVarint(b)
+		count = uint32(v)
+		return n, nil
+	})
+	return count
 }
 
 // EnvelopeExists returns true if the envelope identified with voteID exists
@@ -1024,6 +1394,33 @@ func (v *State) setRollingCensusSize(pids [][]byte) error {
 	return nil
 }
 
+// setAccountsCensusRoot loads all processes from pids, and for those whose
+// census is backed by the Vochain accounts, it sets the CensusRoot to the
+// current root of the Accounts tree.
+func (v *State) setAccountsCensusRoot(pids [][]byte) error {
+	mainTreeView := v.mainTreeViewer(false)
+	for _, pid := range pids {
+		process, err := getProcess(mainTreeView, pid)
+		if err != nil {
+			return err
+		}
+		if !IsVochainAccountsCensus(process.CensusOrigin) {
+			continue
+		}
+		accounts, err := mainTreeView.SubTree(StateTreeCfg(TreeAccounts))
+		if err != nil {
+			return err
+		}
+		if process.CensusRoot, err = accounts.Root(); err != nil {
+			return err
+		}
+		if err := updateProcess(&v.Tx, process, pid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Save persistent save of vochain mem trees
 func (v *State) Save() ([]byte, error) {
 	height := v.CurrentHeight()
@@ -1038,10 +1435,17 @@ func (v *State) Save() ([]byte, error) {
 		if err = v.setRollingCensusSize(pidsStartNextBlock); err != nil {
 			return fmt.Errorf("cannot set rollingCensusSize for processes")
 		}
+		if err = v.setAccountsCensusRoot(pidsStartNextBlock); err != nil {
+			return fmt.Errorf("cannot set accounts censusRoot for processes: %w", err)
+		}
+		if err = v.freezeThresholdKeys(pidsStartNextBlock); err != nil {
+			return fmt.Errorf("cannot freeze threshold keys for processes: %w", err)
+		}
 
 		if err := v.Tx.Commit(height); err != nil {
 			return fmt.Errorf("cannot commit statedb tx: %w", err)
 		}
+		v.validatorUpdates = nil
 		if v.Tx.TreeTx, err = v.Store.BeginTx(); err != nil {
 			return fmt.Errorf("cannot begin statedb tx: %w", err)
 		}
@@ -1091,6 +1495,7 @@ func (v *State) Rollback() {
 	if v.Tx.TreeTx, err = v.Store.BeginTx(); err != nil {
 		log.Fatalf("cannot begin statedb tx: %s", err)
 	}
+	v.validatorUpdates = nil
 	atomic.StoreInt32(&v.txCounter, 0)
 }
 
@@ -1098,6 +1503,7 @@ func (v *State) Close() error {
 	v.Tx.Lock()
 	v.Tx.Discard()
 	v.Tx.Unlock()
+	v.Store.WaitPrune()
 
 	return v.db.Close()
 }
@@ -2,6 +2,7 @@ package vochain
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/gob"
 	"errors"
@@ -10,20 +11,40 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/vocdoni/arbo"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/statedb"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
-	snapshotHeaderVersion = 1
+	snapshotHeaderVersion = 2
 	snapshotHeaderLenSize = 32
+	// defaultSnapshotInterval is the default number of blocks between state
+	// snapshots.
+	defaultSnapshotInterval = 50000
+	// snapshotsToKeep is the number of most recent snapshots kept in disk,
+	// older ones are removed after a new snapshot is created.
+	snapshotsToKeep = 3
+	// snapshotRestoreFile is the file name used to store the chunks of a
+	// snapshot received from peers while it is being restored.
+	snapshotRestoreFile = "restore"
+	// snapshotPartialSuffix is appended to the file name of a snapshot while
+	// it is being written, it is renamed once complete.
+	snapshotPartialSuffix = ".part"
 )
 
+// snapshotChunkSize is the maximum size of each chunk a snapshot is split into
+// for state sync.  Tendermint limits the chunk size to 16 MiB.
+var snapshotChunkSize int64 = 10 << 20
+
 // A StateSnapshot is a copy in a specific point in time of the blockchain state.
 // The state is supposed to be a list of nested merkle trees.
 // The StateSnapshot contains the methods for building a single file snapshot of
@@ -59,10 +80,12 @@ type SnapshotHeader struct {
 }
 
 // SnapshotHeaderTree represents a merkle tree of the StateSnapshot.
+// For child trees, Key is the leaf of the Parent tree that holds the tree root.
 type SnapshotHeaderTree struct {
 	Name   string
 	Size   uint32
 	Parent string
+	Key    []byte
 	Root   []byte
 }
 
@@ -176,6 +199,13 @@ func (s *StateSnapshot) TreeHeader() *SnapshotHeaderTree {
 	return &s.header.Trees[s.currentTree]
 }
 
+// Close closes the snapshot file.
+func (s *StateSnapshot) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
 // Path returns the file path of the snapshot file currently used.
 func (s *StateSnapshot) Path() string {
 	return s.path
@@ -194,11 +224,13 @@ func (s *StateSnapshot) Create(filePath string) error {
 }
 
 // AddTree adds a new tree to the snapshot. `Create` needs to be called first.
-func (s *StateSnapshot) AddTree(name, parent string, root []byte) {
+// The key is the parent leaf key holding the tree root, nil for main trees.
+func (s *StateSnapshot) AddTree(name, parent string, key, root []byte) {
 	s.lock.Lock() // only 1 tree at time is allowed
 	s.header.Trees = append(s.header.Trees, SnapshotHeaderTree{
 		Name:   name,
 		Parent: parent,
+		Key:    key,
 		Root:   root,
 		Size:   0,
 	})
@@ -217,8 +249,9 @@ func (s *StateSnapshot) Save() error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	// create the final file
-	finalFile, err := os.Create(s.path)
+	// create the final file under a temporary name, so a partial snapshot
+	// is never listed nor served
+	finalFile, err := os.Create(s.path + snapshotPartialSuffix)
 	if err != nil {
 		return err
 	}
@@ -263,7 +296,10 @@ func (s *StateSnapshot) Save() error {
 	if err := finalFile.Close(); err != nil {
 		return err
 	}
-	return os.Remove(s.file.Name())
+	if err := os.Remove(s.file.Name()); err != nil {
+		return err
+	}
+	return os.Rename(finalFile.Name(), s.path)
 }
 
 // Write implements the io.Writer interface.
@@ -287,32 +323,24 @@ func (v *State) snapshot() (string, error) {
 		return "", err
 	}
 
-	if err := os.MkdirAll(filepath.Join(
-		v.dataDir,
-		storageDirectory,
-		snapshotsDirectory), 0750); err != nil {
+	if err := os.MkdirAll(v.snapshotsDir(), 0750); err != nil {
 		return "", err
 	}
 
 	var snap StateSnapshot
-	if err := snap.Create(filepath.Join(
-		v.dataDir,
-		storageDirectory,
-		snapshotsDirectory,
-		fmt.Sprintf("%d", height),
-	)); err != nil {
+	if err := snap.Create(v.snapshotPath(height)); err != nil {
 		return "", err
 	}
 	snap.SetMainRoot(root)
 	snap.SetHeight(height)
 	snap.SetChainID(v.chainID)
 
-	dumpTree := func(name, parent string, tr statedb.TreeViewer) error {
+	dumpTree := func(name, parent string, key []byte, tr statedb.TreeViewer) error {
 		root, err := tr.Root()
 		if err != nil {
 			return err
 		}
-		snap.AddTree(name, parent, root)
+		snap.AddTree(name, parent, key, root)
 		if err := tr.Dump(&snap); err != nil {
 			return fmt.Errorf("cannot dump tree: %w", err)
 		}
@@ -321,7 +349,7 @@ func (v *State) snapshot() (string, error) {
 	}
 
 	// dump main tree
-	if err := dumpTree("Main", "", v.mainTreeViewer(true)); err != nil {
+	if err := dumpTree("Main", "", nil, v.mainTreeViewer(true)); err != nil {
 		return "", err
 	}
 
@@ -331,7 +359,7 @@ func (v *State) snapshot() (string, error) {
 		if err != nil {
 			return "", err
 		}
-		if err := dumpTree(k, "", t); err != nil {
+		if err := dumpTree(k, "", nil, t); err != nil {
 			return "", err
 		}
 	}
@@ -359,7 +387,7 @@ func (v *State) snapshot() (string, error) {
 				}
 				continue
 			}
-			if err := dumpTree(name, TreeProcess, childTree); err != nil {
+			if err := dumpTree(name, TreeProcess, p, childTree); err != nil {
 				return "", err
 			}
 		}
@@ -367,22 +395,188 @@ func (v *State) snapshot() (string, error) {
 	return snap.Path(), snap.Save()
 }
 
-/*
-// TODO install Snapshot
-func (v *State) installSnapshot(height uint32) error {
+// installSnapshot replaces the current state with the contents of the snapshot
+// file found at filePath.  The snapshot must correspond to height and its main
+// root must be equal to root, otherwise an error is returned and the current
+// state is left untouched.  The NoState values required by the State (vote
+// count, rolling census sizes and processes indexed by start block) are
+// rebuilt from the imported trees.
+func (v *State) installSnapshot(filePath string, height uint32, root []byte) error {
 	var snap StateSnapshot
-	if err := snap.Open(filepath.Join(
-		v.dataDir,
-		storageDirectory,
-		snapshotsDirectory,
-		fmt.Sprintf("%d", height),
-	)); err != nil {
+	if err := snap.Open(filePath); err != nil {
+		return err
+	}
+	defer snap.Close()
+	header := snap.Header()
+	log.Infof("installing snapshot at height %d with root %x and %d trees",
+		header.Height, header.Root, len(header.Trees))
+	if header.Height != height {
+		return fmt.Errorf("snapshot height mismatch: %d != %d", header.Height, height)
+	}
+	if !bytes.Equal(header.Root, root) {
+		return fmt.Errorf("snapshot root mismatch: %x != %x", header.Root, root)
+	}
+	if v.chainID != "" && header.ChainID != v.chainID {
+		return fmt.Errorf("snapshot chainID mismatch: %s != %s", header.ChainID, v.chainID)
+	}
+
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	// Discard any pending change, the whole state is going to be replaced
+	v.Tx.Discard()
+	tx, err := v.Store.BeginTx()
+	if err != nil {
+		return fmt.Errorf("cannot begin statedb tx: %w", err)
+	}
+	v.Tx.TreeTx = tx
+	err = func() error {
+		for {
+			if err := v.importSnapshotTree(snap.TreeHeader(), &snap); err != nil {
+				return err
+			}
+			if err := snap.FetchNextTree(); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := v.rebuildNoState(height); err != nil {
+			return fmt.Errorf("cannot rebuild nostate: %w", err)
+		}
+		return v.Tx.Commit(height)
+	}()
+	if err != nil {
+		v.Tx.Discard()
+	}
+	// Begin a new tx, either on top of the installed snapshot or the
+	// previous state if the installation failed.
+	var txErr error
+	if v.Tx.TreeTx, txErr = v.Store.BeginTx(); txErr != nil {
+		return fmt.Errorf("cannot begin statedb tx: %w", txErr)
+	}
+	if err != nil {
 		return err
 	}
-	log.Infof("installing snapshot %+v", snap.Header())
-	return fmt.Errorf("unimplemented")
+	hash, err := v.Store.Hash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, root) {
+		return fmt.Errorf("installed state root mismatch: %x != %x", hash, root)
+	}
+	mainTreeView, err := v.Store.TreeView(nil)
+	if err != nil {
+		return fmt.Errorf("cannot get statdeb mainTreeView: %w", err)
+	}
+	v.setMainTreeView(mainTreeView)
+	atomic.StoreUint32(&v.currentHeight, height)
+	log.Infof("snapshot installed, state is at height %d with hash %x", height, hash)
+	return nil
+}
+
+// importSnapshotTree imports the tree described by th reading its dump from r.
+// The trees must be imported in the same order they are stored by snapshot,
+// so parents are always imported before their children.
+func (v *State) importSnapshotTree(th *SnapshotHeaderTree, r io.Reader) error {
+	switch {
+	case th.Name == "Main" && th.Parent == "":
+		return v.Tx.Import(r)
+	case th.Parent == "":
+		cfg, ok := MainTrees[th.Name]
+		if !ok {
+			return fmt.Errorf("unknown main tree %s", th.Name)
+		}
+		if err := v.Tx.ImportSubTree(cfg, r); err != nil {
+			return fmt.Errorf("cannot import tree %s: %w", th.Name, err)
+		}
+	default:
+		childCfg, ok := ChildTrees[th.Name]
+		if !ok {
+			return fmt.Errorf("unknown child tree %s", th.Name)
+		}
+		parent, err := v.Tx.SubTree(StateTreeCfg(th.Parent))
+		if err != nil {
+			return fmt.Errorf("cannot open parent tree %s: %w", th.Parent, err)
+		}
+		if err := parent.ImportSubTree(childCfg.WithKey(th.Key), r); err != nil {
+			return fmt.Errorf("cannot import child tree %s with key %x: %w", th.Name, th.Key, err)
+		}
+	}
+	return nil
+}
+
+// rebuildNoState computes again the NoState values of a freshly imported
+// state, since they are not part of the merkle trees and thus not included in
+// the snapshot.
+func (v *State) rebuildNoState(height uint32) error {
+	processes, err := v.Tx.SubTree(StateTreeCfg(TreeProcess))
+	if err != nil {
+		return err
+	}
+	// startBlocks contains the start block of each process
+	startBlocks := make(map[string]uint32)
+	// timeBasedPids contains the time-based processes not yet ended
+	var timeBasedPids [][]byte
+	var callbackErr error
+	if err := processes.Iterate(func(key, value []byte) bool {
+		var sdbProc models.StateDBProcess
+		if err := proto.Unmarshal(value, &sdbProc); err != nil {
+			callbackErr = fmt.Errorf("cannot unmarshal process %x: %w", key, err)
+			return true
+		}
+		startBlocks[string(key)] = sdbProc.Process.StartBlock
+		switch sdbProc.Process.Status {
+		case models.ProcessStatus_READY, models.ProcessStatus_PAUSED:
+			if IsTimeBasedProcess(sdbProc.Process) {
+				timeBasedPids = append(timeBasedPids, append([]byte{}, key...))
+			}
+		}
+		return false
+	}); err != nil {
+		return err
+	}
+	if callbackErr != nil {
+		return callbackErr
+	}
+	if err := v.setTimeBasedProcessIDs(timeBasedPids); err != nil {
+		return err
+	}
+	countLeafs := func(tree *statedb.TreeUpdate) (uint64, error) {
+		count := uint64(0)
+		err := tree.Iterate(func(key, value []byte) bool {
+			count++
+			return false
+		})
+		return count, err
+	}
+	voteCount := uint64(0)
+	for p, startBlock := range startBlocks {
+		pid := []byte(p)
+		if startBlock > height {
+			if err := v.setProcessIDByStartBlock(pid, startBlock); err != nil {
+				return err
+			}
+		}
+		if votes, err := processes.SubTree(StateChildTreeCfg(ChildTreeVotes).WithKey(pid)); err == nil {
+			count, err := countLeafs(votes)
+			if err != nil {
+				return err
+			}
+			voteCount += count
+		}
+		if census, err := processes.SubTree(
+			StateChildTreeCfg(ChildTreeCensusPoseidon).WithKey(pid)); err == nil {
+			censusLen, err := countLeafs(census)
+			if err != nil {
+				return err
+			}
+			if err := statedb.SetUint64(census.NoState(), keyCensusLen, censusLen); err != nil {
+				return err
+			}
+		}
+	}
+	return statedb.SetUint64(v.Tx.NoState(), voteCountKey, voteCount)
 }
-*/
 
 type diskSnapshotInfo struct {
 	ModTime time.Time
@@ -392,10 +586,7 @@ type diskSnapshotInfo struct {
 
 // listSnapshots returns the list of the current state snapshots stored in disk.
 func (v *State) listSnapshots() []diskSnapshotInfo {
-	files, err := ioutil.ReadDir(filepath.Join(
-		v.dataDir,
-		storageDirectory,
-		snapshotsDirectory))
+	files, err := ioutil.ReadDir(v.snapshotsDir())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -415,3 +606,78 @@ func (v *State) listSnapshots() []diskSnapshotInfo {
 	}
 	return list
 }
+
+// snapshotsDir returns the directory where the state snapshots are stored.
+func (v *State) snapshotsDir() string {
+	return filepath.Join(v.dataDir, storageDirectory, snapshotsDirectory)
+}
+
+// snapshotPath returns the file path of the snapshot for height.
+func (v *State) snapshotPath(height uint32) string {
+	return filepath.Join(v.snapshotsDir(), fmt.Sprintf("%d", height))
+}
+
+// removeOldSnapshots deletes from disk all the snapshots except the most
+// recent `keep` ones.
+func (v *State) removeOldSnapshots(keep int) error {
+	list := v.listSnapshots()
+	if len(list) <= keep {
+		return nil
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Height > list[j].Height })
+	for _, snap := range list[keep:] {
+		log.Debugf("removing old snapshot for height %d", snap.Height)
+		if err := os.Remove(v.snapshotPath(snap.Height)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotChunkHashes returns the sha256 hash of each chunk of the snapshot
+// stored for height.  The snapshot file is split in chunks of
+// snapshotChunkSize bytes, the last one might be smaller.
+func (v *State) snapshotChunkHashes(height uint32) ([][]byte, error) {
+	file, err := os.Open(v.snapshotPath(height))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var hashes [][]byte
+	for {
+		h := sha256.New()
+		n, err := io.CopyN(h, file, snapshotChunkSize)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		hashes = append(hashes, h.Sum(nil))
+		if n < snapshotChunkSize {
+			break
+		}
+	}
+	return hashes, nil
+}
+
+// snapshotChunk returns the chunk number index of the snapshot stored for
+// height.
+func (v *State) snapshotChunk(height, index uint32) ([]byte, error) {
+	file, err := os.Open(v.snapshotPath(height))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(int64(index)*snapshotChunkSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	chunk, err := io.ReadAll(io.LimitReader(file, snapshotChunkSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(chunk) == 0 {
+		return nil, fmt.Errorf("chunk %d not found for snapshot %d", index, height)
+	}
+	return chunk, nil
+}
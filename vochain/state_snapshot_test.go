@@ -2,15 +2,22 @@ package vochain
 
 import (
 	"io"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	qt "github.com/frankban/quicktest"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmprototypes "github.com/tendermint/tendermint/proto/tendermint/types"
 	"github.com/vocdoni/arbo"
 	"go.vocdoni.io/dvote/db"
 	"go.vocdoni.io/dvote/db/metadb"
 	"go.vocdoni.io/dvote/test/testcommon/testutil"
 	"go.vocdoni.io/dvote/tree"
+	"go.vocdoni.io/dvote/types"
+	models "go.vocdoni.io/proto/build/go/models"
 )
 
 func TestStateSnapshot(t *testing.T) {
@@ -25,7 +32,7 @@ func TestStateSnapshot(t *testing.T) {
 	tree1 := newTreeForTest(t, 0)
 	root1, err := tree1.Root(tree1.DB().ReadTx())
 	qt.Assert(t, err, qt.IsNil)
-	snap.AddTree("Tree1", "", root1)
+	snap.AddTree("Tree1", "", nil, root1)
 	err = tree1.DumpWriter(&snap)
 	qt.Assert(t, err, qt.IsNil)
 	snap.EndTree()
@@ -33,7 +40,7 @@ func TestStateSnapshot(t *testing.T) {
 	tree2 := newTreeForTest(t, 1)
 	root2, err := tree2.Root(tree2.DB().ReadTx())
 	qt.Assert(t, err, qt.IsNil)
-	snap.AddTree("Tree2", "", root2)
+	snap.AddTree("Tree2", "", nil, root2)
 	err = tree2.DumpWriter(&snap)
 	qt.Assert(t, err, qt.IsNil)
 	snap.EndTree()
@@ -41,7 +48,7 @@ func TestStateSnapshot(t *testing.T) {
 	tree3 := newTreeForTest(t, 2)
 	root3, err := tree3.Root(tree3.DB().ReadTx())
 	qt.Assert(t, err, qt.IsNil)
-	snap.AddTree("Tree3", "Tree1", root3)
+	snap.AddTree("Tree3", "Tree1", []byte("key3"), root3)
 	err = tree3.DumpWriter(&snap)
 	qt.Assert(t, err, qt.IsNil)
 	snap.EndTree()
@@ -83,6 +90,8 @@ func TestStateSnapshot(t *testing.T) {
 	// tree3
 	err = snap2.FetchNextTree()
 	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, snap2.TreeHeader().Parent, qt.Equals, "Tree1")
+	qt.Assert(t, snap2.TreeHeader().Key, qt.DeepEquals, []byte("key3"))
 	treeImp = newEmptyTreeForTest(t)
 	b, err = snap2.ReadAll()
 	qt.Assert(t, err, qt.IsNil)
@@ -125,3 +134,204 @@ func newEmptyTreeForTest(t *testing.T) *tree.Tree {
 	qt.Assert(t, err, qt.IsNil)
 	return tr
 }
+
+// stateSyncForTest transfers the first snapshot listed by src to dst using
+// the ABCI state sync methods, as Tendermint would do between two peers.
+func stateSyncForTest(t *testing.T, src, dst *BaseApplication,
+	appHash []byte) abcitypes.ResponseApplySnapshotChunk_Result {
+	snapshots := src.ListSnapshots(abcitypes.RequestListSnapshots{}).Snapshots
+	qt.Assert(t, snapshots, qt.Not(qt.HasLen), 0)
+	snapshot := snapshots[len(snapshots)-1]
+	offer := dst.OfferSnapshot(abcitypes.RequestOfferSnapshot{
+		Snapshot: snapshot,
+		AppHash:  appHash,
+	})
+	qt.Assert(t, offer.Result, qt.Equals, abcitypes.ResponseOfferSnapshot_ACCEPT)
+	var result abcitypes.ResponseApplySnapshotChunk_Result
+	for i := uint32(0); i < snapshot.Chunks; i++ {
+		chunk := src.LoadSnapshotChunk(abcitypes.RequestLoadSnapshotChunk{
+			Height: snapshot.Height,
+			Format: snapshot.Format,
+			Chunk:  i,
+		})
+		qt.Assert(t, chunk.Chunk, qt.Not(qt.HasLen), 0)
+		result = dst.ApplySnapshotChunk(abcitypes.RequestApplySnapshotChunk{
+			Index:  i,
+			Chunk:  chunk.Chunk,
+			Sender: "peer1",
+		}).Result
+		if result != abcitypes.ResponseApplySnapshotChunk_ACCEPT {
+			return result
+		}
+	}
+	return result
+}
+
+func TestStateSync(t *testing.T) {
+	// use small chunks to make sure the snapshot is split
+	defer func(size int64) { snapshotChunkSize = size }(snapshotChunkSize)
+	snapshotChunkSize = 1024
+
+	rng := testutil.NewRandom(0)
+	app := TestBaseApplication(t)
+	app.SetSnapshotInterval(4)
+
+	// populate the state with accounts, processes, votes and a rolling census
+	oracle := common.BytesToAddress(rng.RandomBytes(20))
+	qt.Assert(t, app.State.AddOracle(oracle), qt.IsNil)
+	account := common.BytesToAddress(rng.RandomBytes(20))
+	qt.Assert(t, app.State.CreateAccount(account, "ipfs://account", nil, 100), qt.IsNil)
+
+	pid := rng.RandomBytes(32)
+	censusURI := "ipfs://foobar"
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		EntityId:     rng.RandomBytes(20),
+		CensusURI:    &censusURI,
+		ProcessId:    pid,
+		StartBlock:   100,
+		Mode:         &models.ProcessMode{},
+		EnvelopeType: &models.EnvelopeType{},
+	}), qt.IsNil)
+	var nullifiers [][]byte
+	for i := 0; i < 20; i++ {
+		nullifiers = append(nullifiers, rng.RandomBytes(32))
+		qt.Assert(t, app.State.AddVote(&models.Vote{
+			ProcessId:   pid,
+			Nullifier:   nullifiers[i],
+			VotePackage: rng.RandomBytes(64),
+		}, types.VoterID{}.Nil()), qt.IsNil)
+	}
+
+	rollingPid := rng.RandomBytes(32)
+	maxCensusSize := uint64(16)
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		EntityId:      rng.RandomBytes(20),
+		CensusURI:     &censusURI,
+		ProcessId:     rollingPid,
+		StartBlock:    100,
+		Mode:          &models.ProcessMode{PreRegister: true},
+		EnvelopeType:  &models.EnvelopeType{Anonymous: true},
+		MaxCensusSize: &maxCensusSize,
+	}), qt.IsNil)
+	for i := 0; i < 5; i++ {
+		qt.Assert(t, app.State.AddToRollingCensus(rollingPid, rng.RandomInZKField(), nil), qt.IsNil)
+	}
+
+	// advance until the snapshot at height 4 is taken
+	for app.Height() < 4 {
+		app.AdvanceTestBlock()
+	}
+	qt.Assert(t, app.State.listSnapshots(), qt.HasLen, 1)
+	appHash, err := app.State.Store.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	snapshots := app.ListSnapshots(abcitypes.RequestListSnapshots{}).Snapshots
+	qt.Assert(t, snapshots, qt.HasLen, 1)
+	qt.Assert(t, snapshots[0].Height, qt.Equals, uint64(4))
+	qt.Assert(t, snapshots[0].Chunks > 1, qt.IsTrue)
+
+	// a snapshot not matching the trusted app hash is rejected
+	app2 := TestBaseApplication(t)
+	qt.Assert(t, stateSyncForTest(t, app, app2, rng.RandomBytes(32)),
+		qt.Equals, abcitypes.ResponseApplySnapshotChunk_REJECT_SNAPSHOT)
+
+	// a corrupted chunk must be fetched again from another peer
+	offer := app2.OfferSnapshot(abcitypes.RequestOfferSnapshot{
+		Snapshot: snapshots[0],
+		AppHash:  appHash,
+	})
+	qt.Assert(t, offer.Result, qt.Equals, abcitypes.ResponseOfferSnapshot_ACCEPT)
+	resp := app2.ApplySnapshotChunk(abcitypes.RequestApplySnapshotChunk{
+		Index:  0,
+		Chunk:  rng.RandomBytes(1024),
+		Sender: "badpeer",
+	})
+	qt.Assert(t, resp.Result, qt.Equals, abcitypes.ResponseApplySnapshotChunk_RETRY)
+	qt.Assert(t, resp.RefetchChunks, qt.DeepEquals, []uint32{0})
+	qt.Assert(t, resp.RejectSenders, qt.DeepEquals, []string{"badpeer"})
+
+	// bootstrap the second node from the snapshot
+	qt.Assert(t, stateSyncForTest(t, app, app2, appHash),
+		qt.Equals, abcitypes.ResponseApplySnapshotChunk_ACCEPT)
+	info := app2.Info(abcitypes.RequestInfo{})
+	qt.Assert(t, info.LastBlockHeight, qt.Equals, int64(4))
+	qt.Assert(t, info.LastBlockAppHash, qt.DeepEquals, appHash)
+
+	// check the restored contents
+	isOracle, err := app2.State.IsOracle(oracle)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, isOracle, qt.IsTrue)
+	acc, err := app2.State.GetAccount(account, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, acc.Balance, qt.Equals, uint64(100))
+	process, err := app2.State.Process(pid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, process.ProcessId, qt.DeepEquals, pid)
+	qt.Assert(t, app2.State.CountVotes(pid, true), qt.Equals, uint32(20))
+	_, err = app2.State.Envelope(pid, nullifiers[3], true)
+	qt.Assert(t, err, qt.IsNil)
+	voteCount, err := app2.State.VoteCount(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, voteCount, qt.Equals, uint64(20))
+	censusSize, err := app2.State.GetRollingCensusSize(rollingPid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, censusSize, qt.Equals, uint64(5))
+	censusRoot, err := app.State.GetRollingCensusRoot(rollingPid, true)
+	qt.Assert(t, err, qt.IsNil)
+	censusRoot2, err := app2.State.GetRollingCensusRoot(rollingPid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, censusRoot2, qt.DeepEquals, censusRoot)
+	pids, err := app2.State.processIDsByStartBlock(100)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pids, qt.HasLen, 2)
+
+	// both nodes must keep producing the same state
+	app2.BeginBlock(abcitypes.RequestBeginBlock{Header: tmprototypes.Header{
+		Time:   time.Now(),
+		Height: 5,
+	}})
+	nullifier := rng.RandomBytes(32)
+	censusKey := rng.RandomInZKField()
+	for _, a := range []*BaseApplication{app, app2} {
+		qt.Assert(t, a.State.AddVote(&models.Vote{
+			ProcessId:   pid,
+			Nullifier:   nullifier,
+			VotePackage: []byte("vote"),
+		}, types.VoterID{}.Nil()), qt.IsNil)
+		qt.Assert(t, a.State.AddToRollingCensus(rollingPid, censusKey, nil), qt.IsNil)
+		a.AdvanceTestBlock()
+	}
+	hash1, err := app.State.Store.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	hash2, err := app2.State.Store.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, hash2, qt.DeepEquals, hash1)
+	qt.Assert(t, app2.Height(), qt.Equals, app.Height())
+}
+
+func TestSnapshotDescriptionCache(t *testing.T) {
+	app := TestBaseApplication(t)
+	app.SetSnapshotInterval(2)
+	for app.Height() < 2 {
+		app.AdvanceTestBlock()
+	}
+	snapshots := app.ListSnapshots(abcitypes.RequestListSnapshots{}).Snapshots
+	qt.Assert(t, snapshots, qt.HasLen, 1)
+	// no partial snapshot file is left behind
+	qt.Assert(t, app.State.listSnapshots(), qt.HasLen, 1)
+
+	// the description is computed again if the snapshot file changes
+	path := app.State.snapshotPath(2)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = f.Write([]byte("more data"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, f.Close(), qt.IsNil)
+	changed := app.ListSnapshots(abcitypes.RequestListSnapshots{}).Snapshots
+	qt.Assert(t, changed, qt.HasLen, 1)
+	qt.Assert(t, changed[0].Hash, qt.Not(qt.DeepEquals), snapshots[0].Hash)
+
+	// and evicted once the snapshot is removed
+	qt.Assert(t, os.Remove(path), qt.IsNil)
+	qt.Assert(t, app.ListSnapshots(abcitypes.RequestListSnapshots{}).Snapshots, qt.HasLen, 0)
+	qt.Assert(t, app.snapshots, qt.HasLen, 0)
+}
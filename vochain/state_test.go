@@ -10,6 +10,7 @@ import (
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/db"
 	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/statedb"
 	"go.vocdoni.io/dvote/test/testcommon/testutil"
 	"go.vocdoni.io/dvote/types"
 	models "go.vocdoni.io/proto/build/go/models"
@@ -364,6 +365,31 @@ func TestIncrementTreasurerNonce(t *testing.T) {
 	qt.Assert(t, treasurer.Nonce, qt.Equals, uint32(1))
 }
 
+func TestStateOracles(t *testing.T) {
+	log.Init("info", "stdout")
+	s, err := NewState(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer s.Close()
+
+	var height uint32 = 1
+	s.Rollback()
+	s.SetHeight(height)
+
+	rng := testutil.NewRandom(0)
+	var oracles []common.Address
+	for i := 0; i < 3; i++ {
+		oracles = append(oracles, common.BytesToAddress(rng.RandomBytes(20)))
+		qt.Assert(t, s.AddOracle(oracles[i]), qt.IsNil)
+	}
+	// all the oracles are listed, except the removed ones
+	qt.Assert(t, s.RemoveOracle(oracles[1]), qt.IsNil)
+	list, err := s.Oracles(false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, list, qt.HasLen, 2)
+	qt.Assert(t, list, qt.Contains, oracles[0])
+	qt.Assert(t, list, qt.Contains, oracles[2])
+}
+
 func TestStateSetGetTxCostByTxType(t *testing.T) {
 	log.Init("info", "stdout")
 	s, err := NewState(db.TypePebble, t.TempDir())
@@ -379,3 +405,204 @@ func TestStateSetGetTxCostByTxType(t *testing.T) {
 	qt.Assert(t, err, qt.IsNil)
 	qt.Assert(t, cost, qt.Equals, uint64(100))
 }
+
+func TestStateAtHeight(t *testing.T) {
+	rng := testutil.NewRandom(0)
+	log.Init("info", "stdout")
+	s, err := NewState(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer s.Close()
+
+	oracle1 := common.BytesToAddress(rng.RandomBytes(20))
+	oracle2 := common.BytesToAddress(rng.RandomBytes(20))
+	account := common.BytesToAddress(rng.RandomBytes(20))
+	pid := rng.RandomBytes(32)
+	nullifier := rng.RandomBytes(32)
+	censusURI := "ipfs://foobar"
+
+	// height 1
+	s.Rollback()
+	s.SetHeight(1)
+	qt.Assert(t, s.AddOracle(oracle1), qt.IsNil)
+	qt.Assert(t, s.SetAccount(account, &Account{models.Account{Balance: 10}}), qt.IsNil)
+	qt.Assert(t, s.SetTxCost(models.TxType_NEW_PROCESS, 100), qt.IsNil)
+	qt.Assert(t, s.AddProcess(&models.Process{
+		EntityId:  rng.RandomBytes(20),
+		CensusURI: &censusURI,
+		ProcessId: pid,
+		Status:    models.ProcessStatus_READY,
+	}), qt.IsNil)
+	_, err = s.Save()
+	qt.Assert(t, err, qt.IsNil)
+
+	// height 2
+	s.Rollback()
+	s.SetHeight(2)
+	qt.Assert(t, s.AddOracle(oracle2), qt.IsNil)
+	qt.Assert(t, s.SetAccount(account, &Account{models.Account{Balance: 20}}), qt.IsNil)
+	qt.Assert(t, s.SetTxCost(models.TxType_NEW_PROCESS, 200), qt.IsNil)
+	qt.Assert(t, s.AddVote(&models.Vote{
+		ProcessId:   pid,
+		Nullifier:   nullifier,
+		VotePackage: []byte("vote"),
+	}, types.VoterID{}.Nil()), qt.IsNil)
+	_, err = s.Save()
+	qt.Assert(t, err, qt.IsNil)
+
+	// the state at height 1
+	oracles, err := s.OraclesAt(1)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, oracles, qt.DeepEquals, []common.Address{oracle1})
+	acc, err := s.GetAccountAt(account, 1)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, acc.Balance, qt.Equals, uint64(10))
+	cost, err := s.TxCostAt(models.TxType_NEW_PROCESS, 1)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, cost, qt.Equals, uint64(100))
+	process, err := s.ProcessAt(pid, 1)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, process.ProcessId, qt.DeepEquals, pid)
+	_, err = s.EnvelopeAt(pid, nullifier, 1)
+	qt.Assert(t, err, qt.ErrorIs, ErrVoteDoesNotExist)
+
+	// the state at height 2 and the last committed one must be the same
+	for _, height := range []uint32{2, 0} {
+		oracles, err := s.OraclesAt(height)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, oracles, qt.HasLen, 2)
+		acc, err := s.GetAccountAt(account, height)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, acc.Balance, qt.Equals, uint64(20))
+		cost, err := s.TxCostAt(models.TxType_NEW_PROCESS, height)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, cost, qt.Equals, uint64(200))
+		_, err = s.EnvelopeAt(pid, nullifier, height)
+		qt.Assert(t, err, qt.IsNil)
+	}
+
+	// an account that did not exist yet
+	acc, err = s.GetAccountAt(common.BytesToAddress(rng.RandomBytes(20)), 1)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, acc, qt.IsNil)
+
+	// a height not yet committed
+	_, err = s.ProcessAt(pid, 3)
+	qt.Assert(t, err, qt.IsNotNil)
+}
+
+func TestVoteOverwrite(t *testing.T) {
+	rng := testutil.NewRandom(0)
+	s, err := NewState(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer s.Close()
+
+	pid, pidNoOverwrite := rng.RandomBytes(32), rng.RandomBytes(32)
+	s.Rollback()
+	for _, p := range []*models.Process{
+		{ProcessId: pid, VoteOptions: &models.ProcessVoteOptions{MaxVoteOverwrites: 2}},
+		{ProcessId: pidNoOverwrite, VoteOptions: &models.ProcessVoteOptions{}},
+	} {
+		p.EntityId = rng.RandomBytes(20)
+		p.Status = models.ProcessStatus_READY
+		qt.Assert(t, s.AddProcess(p), qt.IsNil)
+	}
+	nullifier := rng.RandomBytes(32)
+	addVote := func(pid []byte, votePackage string) error {
+		return s.AddVote(&models.Vote{
+			ProcessId:   pid,
+			Nullifier:   nullifier,
+			VotePackage: []byte(votePackage),
+		}, types.VoterID{}.Nil())
+	}
+	qt.Assert(t, addVote(pid, "first"), qt.IsNil)
+	qt.Assert(t, addVote(pidNoOverwrite, "first"), qt.IsNil)
+	_, err = s.Save()
+	qt.Assert(t, err, qt.IsNil)
+
+	// a new vote has not been overwritten
+	count, err := s.VoteOverwriteCount(pid, nullifier, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, count, qt.Equals, uint32(0))
+	firstHash, err := s.Envelope(pid, nullifier, true)
+	qt.Assert(t, err, qt.IsNil)
+
+	s.Rollback()
+	qt.Assert(t, addVote(pidNoOverwrite, "second"), qt.ErrorMatches, ".*already exists")
+	qt.Assert(t, addVote(pid, "second"), qt.IsNil)
+	qt.Assert(t, addVote(pid, "third"), qt.IsNil)
+	qt.Assert(t, addVote(pid, "fourth"), qt.ErrorMatches, ".*maximum number of overwrites.*")
+	process, err := s.Process(pid, false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, s.CheckVoteOverwrite(process, nullifier, false), qt.IsNotNil)
+	qt.Assert(t, s.CheckVoteOverwrite(process, nullifier, true), qt.IsNil)
+	_, err = s.Save()
+	qt.Assert(t, err, qt.IsNil)
+
+	count, err = s.VoteOverwriteCount(pid, nullifier, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, count, qt.Equals, uint32(2))
+	lastHash, err := s.Envelope(pid, nullifier, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, lastHash, qt.Not(qt.DeepEquals), firstHash)
+	// an overwrite is not a new vote
+	qt.Assert(t, s.CountVotes(pid, true), qt.Equals, uint32(1))
+	voteCount, err := s.VoteCount(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, voteCount, qt.Equals, uint64(2))
+
+	_, err = s.VoteOverwriteCount(pid, rng.RandomBytes(32), true)
+	qt.Assert(t, err, qt.ErrorIs, ErrVoteDoesNotExist)
+}
+
+func TestStatePruning(t *testing.T) {
+	rng := testutil.NewRandom(0)
+	log.Init("info", "stdout")
+	s, err := NewState(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer s.Close()
+	s.SetPruning(statedb.PruneMode{KeepRecent: 3})
+
+	pid := rng.RandomBytes(32)
+	censusURI := "ipfs://foobar"
+	var nullifiers [][]byte
+	for height := uint32(1); height <= 10; height++ {
+		s.Rollback()
+		s.SetHeight(height)
+		if height == 1 {
+			qt.Assert(t, s.AddProcess(&models.Process{
+				EntityId:  rng.RandomBytes(20),
+				CensusURI: &censusURI,
+				ProcessId: pid,
+			}), qt.IsNil)
+		}
+		for i := 0; i < 5; i++ {
+			nullifiers = append(nullifiers, rng.RandomBytes(32))
+			qt.Assert(t, s.AddVote(&models.Vote{
+				ProcessId:   pid,
+				Nullifier:   nullifiers[len(nullifiers)-1],
+				VotePackage: []byte("vote"),
+			}, types.VoterID{}.Nil()), qt.IsNil)
+		}
+		_, err = s.Save()
+		qt.Assert(t, err, qt.IsNil)
+	}
+	s.Store.WaitPrune()
+	root, err := s.Store.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	_, err = s.Store.Prune()
+	qt.Assert(t, err, qt.IsNil)
+	root2, err := s.Store.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, root2, qt.DeepEquals, root)
+
+	// the votes of the kept versions are still there
+	for _, height := range []uint32{8, 9, 10} {
+		for i := 0; i < int(height)*5; i++ {
+			_, err := s.EnvelopeAt(pid, nullifiers[i], height)
+			qt.Assert(t, err, qt.IsNil)
+		}
+	}
+	qt.Assert(t, s.CountVotes(pid, true), qt.Equals, uint32(50))
+	_, err = s.ProcessAt(pid, 7)
+	qt.Assert(t, err, qt.IsNotNil)
+}
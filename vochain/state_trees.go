@@ -137,6 +137,22 @@ var (
 	}
 )
 
+// stateTreesSchema returns the hierarchy of the state trees hanging from the
+// mainTree, used to prune the old versions of the StateDB.
+func stateTreesSchema() []statedb.SubTreeSchema {
+	var schema []statedb.SubTreeSchema
+	for name, cfg := range MainTrees {
+		sub := statedb.SubTreeSchema{Singleton: cfg}
+		if name == TreeProcess {
+			for _, childCfg := range ChildTrees {
+				sub.SubTrees = append(sub.SubTrees, statedb.SubTreeSchema{NonSingleton: childCfg})
+			}
+		}
+		schema = append(schema, sub)
+	}
+	return schema
+}
+
 // StateTree returns the state merkle tree with name.
 func StateTreeCfg(name string) statedb.TreeConfig {
 	t, ok := MainTrees[name]
@@ -7,12 +7,13 @@ import (
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	tmcrypto "github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/crypto/ed25519"
 	tmtypes "github.com/tendermint/tendermint/types"
-	"github.com/vocdoni/arbo"
-	"github.com/vocdoni/go-snark/verifier"
+	"go.vocdoni.io/dvote/crypto/dkg"
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/crypto/nacl"
-	"go.vocdoni.io/dvote/crypto/zk"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
 	models "go.vocdoni.io/proto/build/go/models"
@@ -36,6 +37,7 @@ type AddTxResponse struct {
 	TxHash []byte
 	Data   []byte
 	Log    string
+	Events []abcitypes.Event
 }
 
 // Unmarshal unarshal the content of a bytes serialized transaction.
@@ -65,6 +67,7 @@ func TxKey(tx tmtypes.Tx) [32]byte {
 // It returns a bytes value which depends on the transaction type:
 //  Tx_Vote: vote nullifier
 //  default: []byte{}
+// The response also holds the indexed events of the transaction, see TxEventType.
 func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse, error) {
 	if vtx.Tx == nil || app.State == nil || vtx.Tx.Payload == nil {
 		return nil, fmt.Errorf("transaction, state, and/or transaction payload is nil")
@@ -79,17 +82,39 @@ func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse,
 			return nil, fmt.Errorf("voteTxCheck: %w", err)
 		}
 		response.Data = v.Nullifier
+		// the voter address is unknown on anonymous votes
+		var voterAddr []byte
+		if !voterID.IsNil() {
+			voterAddr, _ = voterID.Address()
+		}
+		response.Events = txEvents(models.TxType_VOTE,
+			txEventAttr{TxEventKeyProcessID, v.ProcessId},
+			txEventAttr{TxEventKeyNullifier, v.Nullifier},
+			txEventAttr{TxEventKeyFrom, voterAddr},
+		)
 		if commit {
-			return response, app.State.AddVote(v, voterID)
+			if err := app.State.AddVote(v, voterID); err != nil {
+				return nil, err
+			}
+			return response, app.setVoteDelegationHolders(txVote, v)
 		}
 
 	case *models.Tx_Admin:
-		_, err := AdminTxCheck(vtx.Tx, vtx.SignedBody, vtx.Signature, app.State)
+		signer, err := AdminTxCheck(vtx.Tx, vtx.SignedBody, vtx.Signature, app.State)
 		if err != nil {
 			return nil, fmt.Errorf("adminTxCheck: %w", err)
 		}
+		tx := vtx.Tx.GetAdmin()
+		to := tx.Address
+		if tx.Txtype == models.TxType_ADD_VALIDATOR {
+			to = ed25519.PubKey(tx.PublicKey).Address()
+		}
+		response.Events = txEvents(tx.Txtype,
+			txEventAttr{TxEventKeyProcessID, tx.ProcessId},
+			txEventAttr{TxEventKeyFrom, signer.Bytes()},
+			txEventAttr{TxEventKeyTo, to},
+		)
 		if commit {
-			tx := vtx.Tx.GetAdmin()
 			switch tx.Txtype {
 			case models.TxType_ADD_ORACLE:
 				if err := app.State.AddOracle(common.BytesToAddress(tx.Address)); err != nil {
@@ -101,16 +126,110 @@ func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse,
 					return nil, fmt.Errorf("removeOracle: %w", err)
 				}
 				return response, app.State.IncrementTreasurerNonce()
+			case models.TxType_ADD_VALIDATOR:
+				approved, err := approveValidatorTx(app.State, vtx.Tx, signer)
+				if err != nil {
+					return nil, fmt.Errorf("addValidator: %w", err)
+				}
+				if !approved {
+					// waiting for the approval of the oracle quorum
+					return response, nil
+				}
+				validator := &models.Validator{
+					Address: ed25519.PubKey(tx.PublicKey).Address(),
+					PubKey:  tx.PublicKey,
+					Power:   *tx.Power,
+				}
+				if err := app.State.AddValidator(validator); err != nil {
+					return nil, fmt.Errorf("addValidator: %w", err)
+				}
+				app.State.queueValidatorUpdate(validator)
+				return response, app.State.IncrementTreasurerNonce()
+			case models.TxType_REMOVE_VALIDATOR:
+				approved, err := approveValidatorTx(app.State, vtx.Tx, signer)
+				if err != nil {
+					return nil, fmt.Errorf("removeValidator: %w", err)
+				}
+				if !approved {
+					// waiting for the approval of the oracle quorum
+					return response, nil
+				}
+				validator, err := app.State.Validator(tx.Address, false)
+				if err != nil || validator == nil {
+					return nil, fmt.Errorf("removeValidator: cannot get validator %x: %v", tx.Address, err)
+				}
+				if err := app.State.RemoveValidator(tx.Address); err != nil {
+					return nil, fmt.Errorf("removeValidator: %w", err)
+				}
+				// tendermint removes the validators updated with power 0
+				validator.Power = 0
+				app.State.queueValidatorUpdate(validator)
+				return response, app.State.IncrementTreasurerNonce()
+			case TxTypeAddZkCircuit:
+				circuit, err := AdminTxZkCircuit(tx)
+				if err != nil {
+					return nil, fmt.Errorf("addZkCircuit: %w", err)
+				}
+				if err := app.State.SetZkCircuit(*tx.KeyIndex, circuit); err != nil {
+					return nil, fmt.Errorf("addZkCircuit: %w", err)
+				}
+				return response, app.State.IncrementTreasurerNonce()
+			case TxTypeRemoveZkCircuit:
+				if err := app.State.RemoveZkCircuit(*tx.KeyIndex); err != nil {
+					return nil, fmt.Errorf("removeZkCircuit: %w", err)
+				}
+				return response, app.State.IncrementTreasurerNonce()
+			case TxTypeSetTreasurerMultisig:
+				multisig, err := AdminTxMultisig(tx)
+				if err != nil {
+					return nil, fmt.Errorf("setTreasurerMultisig: %w", err)
+				}
+				if err := app.State.SetTreasurerMultisig(multisig); err != nil {
+					return nil, fmt.Errorf("setTreasurerMultisig: %w", err)
+				}
+				return response, app.State.IncrementTreasurerNonce()
+			case TxTypeSetOracleMultisig:
+				multisig, err := AdminTxMultisig(tx)
+				if err != nil {
+					return nil, fmt.Errorf("setOracleMultisig: %w", err)
+				}
+				if err := app.State.SetOracleMultisig(multisig); err != nil {
+					return nil, fmt.Errorf("setOracleMultisig: %w", err)
+				}
+				return response, app.State.IncrementTreasurerNonce()
+			case TxTypeProposeMultisigTx, TxTypeApproveMultisigTx:
+				id, err := app.State.addMultisigApproval(tx, signer)
+				if err != nil {
+					return nil, fmt.Errorf("approveMultisigTx: %w", err)
+				}
+				response.Data = id
+				executed, err := app.executeOracleProposal(id)
+				if err != nil {
+					return nil, fmt.Errorf("approveMultisigTx: %w", err)
+				}
+				if executed != nil {
+					response.Events = append(response.Events, executed.Events...)
+				}
 			// TODO: @jordipainan No cost applied, no nonce increased
 			case models.TxType_ADD_PROCESS_KEYS:
 				if err := app.State.AddProcessKeys(tx); err != nil {
 					return nil, fmt.Errorf("addProcessKeys: %w", err)
 				}
+				// the dealers are identified by their oracle to complain
+				if len(tx.EncryptionPublicKey) != nacl.KeyLength {
+					if err := app.State.addProcessKeyDealer(tx.ProcessId, *tx.KeyIndex, signer); err != nil {
+						return nil, fmt.Errorf("addProcessKeys: %w", err)
+					}
+				}
 			// TODO: @jordipainan No cost applied, no nonce increased
 			case models.TxType_REVEAL_PROCESS_KEYS:
 				if err := app.State.RevealProcessKeys(tx); err != nil {
 					return nil, fmt.Errorf("revealProcessKeys: %w", err)
 				}
+			case TxTypeComplainProcessKeys, TxTypeAnswerProcessKeysComplaint:
+				if err := app.State.ProcessKeysComplaint(tx, signer); err != nil {
+					return nil, fmt.Errorf("processKeysComplaint: %w", err)
+				}
 			default:
 				return nil, fmt.Errorf("tx not supported")
 			}
@@ -122,6 +241,11 @@ func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse,
 			return nil, fmt.Errorf("newProcess: %w", err)
 		}
 		response.Data = p.ProcessId
+		response.Events = txEvents(models.TxType_NEW_PROCESS,
+			txEventAttr{TxEventKeyProcessID, p.ProcessId},
+			txEventAttr{TxEventKeyEntityID, p.EntityId},
+			txEventAttr{TxEventKeyFrom, txSender.Bytes()},
+		)
 		if commit {
 			tx := vtx.Tx.GetNewProcess()
 			if tx.Process == nil {
@@ -143,6 +267,16 @@ func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse,
 		}
 		if commit {
 			tx := vtx.Tx.GetSetProcess()
+			// the sender might be a delegate, so the entity is taken from the process
+			process, err := app.State.Process(tx.ProcessId, false)
+			if err != nil {
+				return nil, fmt.Errorf("setProcess: %w", err)
+			}
+			response.Events = txEvents(tx.Txtype,
+				txEventAttr{TxEventKeyProcessID, tx.ProcessId},
+				txEventAttr{TxEventKeyEntityID, process.EntityId},
+				txEventAttr{TxEventKeyFrom, txSender.Bytes()},
+			)
 			switch tx.Txtype {
 			case models.TxType_SET_PROCESS_STATUS:
 				if tx.GetStatus() == models.ProcessStatus_PROCESS_UNKNOWN {
@@ -176,8 +310,11 @@ func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse,
 			commit); err != nil {
 			return nil, fmt.Errorf("registerKeyTx %w", err)
 		}
+		tx := vtx.Tx.GetRegisterKey()
+		response.Events = txEvents(models.TxType_REGISTER_VOTER_KEY,
+			txEventAttr{TxEventKeyProcessID, tx.ProcessId},
+		)
 		if commit {
-			tx := vtx.Tx.GetRegisterKey()
 			weight, ok := new(big.Int).SetString(tx.Weight, 10)
 			if !ok {
 				return nil, fmt.Errorf("cannot parse weight %s", weight)
@@ -190,6 +327,10 @@ func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse,
 		if err != nil {
 			return nil, fmt.Errorf("setAccountInfoTxCheck: %w", err)
 		}
+		response.Events = txEvents(models.TxType_SET_ACCOUNT_INFO,
+			txEventAttr{TxEventKeyFrom, txValues.TxSender.Bytes()},
+			txEventAttr{TxEventKeyTo, txValues.Account.Bytes()},
+		)
 		if commit {
 			tx := vtx.Tx.GetSetAccountInfo()
 			// create account
@@ -236,6 +377,7 @@ func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse,
 		if err != nil {
 			return nil, fmt.Errorf("setTransactionCostsTx: %w", err)
 		}
+		response.Events = txEvents(models.TxType_SET_TRANSACTION_COSTS)
 		if commit {
 			if err := app.State.SetTxCost(vtx.Tx.GetSetTransactionCosts().Txtype, cost); err != nil {
 				return nil, fmt.Errorf("setTransactionCosts: %w", err)
@@ -248,6 +390,9 @@ func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse,
 		if err != nil {
 			return nil, fmt.Errorf("mintTokensTx: %w", err)
 		}
+		response.Events = txEvents(models.TxType_MINT_TOKENS,
+			txEventAttr{TxEventKeyTo, address.Bytes()},
+		)
 		if commit {
 			if err := app.State.MintBalance(address, amount); err != nil {
 				return nil, fmt.Errorf("mintTokensTx: %w", err)
@@ -260,6 +405,10 @@ func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse,
 		if err != nil {
 			return nil, fmt.Errorf("sendTokensTxCheck: %w", err)
 		}
+		response.Events = txEvents(models.TxType_SEND_TOKENS,
+			txEventAttr{TxEventKeyFrom, txValues.From.Bytes()},
+			txEventAttr{TxEventKeyTo, txValues.To.Bytes()},
+		)
 		if commit {
 			err := app.State.TransferBalance(txValues.From, txValues.To, txValues.Value)
 			if err != nil {
@@ -274,6 +423,10 @@ func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse,
 		if err != nil {
 			return nil, fmt.Errorf("setAccountDelegateTxCheck: %w", err)
 		}
+		response.Events = txEvents(vtx.Tx.GetSetAccountDelegateTx().Txtype,
+			txEventAttr{TxEventKeyFrom, txValues.From.Bytes()},
+			txEventAttr{TxEventKeyTo, txValues.Delegate.Bytes()},
+		)
 		if commit {
 			switch vtx.Tx.GetSetAccountDelegateTx().Txtype {
 			case models.TxType_ADD_DELEGATE_FOR_ACCOUNT:
@@ -305,8 +458,12 @@ func (app *BaseApplication) AddTx(vtx *VochainTx, commit bool) (*AddTxResponse,
 		if err != nil {
 			return nil, fmt.Errorf("collectFaucetTxCheck: %w", err)
 		}
+		txValues := vtx.Tx.GetCollectFaucet()
+		response.Events = txEvents(models.TxType_COLLECT_FAUCET,
+			txEventAttr{TxEventKeyFrom, fromAcc.Bytes()},
+			txEventAttr{TxEventKeyTo, txValues.GetFaucetPackage().GetPayload().GetTo()},
+		)
 		if commit {
-			txValues := vtx.Tx.GetCollectFaucet()
 			if err := app.State.ConsumeFaucetPayload(
 				*fromAcc,
 				&models.FaucetPayload{
@@ -365,8 +522,19 @@ func (app *BaseApplication) VoteEnvelopeCheck(ve *models.VoteEnvelope, txBytes,
 		return nil, voterID.Nil(), fmt.Errorf("no keys available, voting is not possible")
 	}
 
+	delegations, err := VoteEnvelopeDelegations(ve)
+	if err != nil {
+		return nil, voterID.Nil(), err
+	}
+	if len(delegations) > 0 && !ProcessDelegatedVoting(process.Mode) {
+		return nil, voterID.Nil(), fmt.Errorf("process %x does not allow delegated votes", ve.ProcessId)
+	}
+
 	var vote *models.Vote
 	if process.EnvelopeType.Anonymous {
+		if len(delegations) > 0 {
+			return nil, voterID.Nil(), fmt.Errorf("anonymous votes cannot carry delegations")
+		}
 		// In order to avoid double vote check (on checkTx and deliverTx), we use a memory vote cache.
 		// An element can only be added to the vote cache during checkTx.
 		// Every N seconds the old votes which are not yet in the blockchain will be removed from cache.
@@ -389,12 +557,8 @@ func (app *BaseApplication) VoteEnvelopeCheck(ve *models.VoteEnvelope, txBytes,
 
 			vote.Height = height // update vote height
 			defer app.State.CacheDel(txID)
-			if exist, err := app.State.EnvelopeExists(vote.ProcessId,
-				vote.Nullifier, false); err != nil || exist {
-				if err != nil {
-					return nil, voterID.Nil(), err
-				}
-				return nil, voterID.Nil(), fmt.Errorf("vote %x already exists", vote.Nullifier)
+			if err := app.State.CheckVoteOverwrite(process, vote.Nullifier, false); err != nil {
+				return nil, voterID.Nil(), err
 			}
 			return vote, voterID.Nil(), nil
 		}
@@ -405,51 +569,29 @@ func (app *BaseApplication) VoteEnvelopeCheck(ve *models.VoteEnvelope, txBytes,
 		if proofZkSNARK == nil {
 			return nil, voterID.Nil(), fmt.Errorf("zkSNARK proof is empty")
 		}
-		proof, _, err := zk.ProtobufZKProofToCircomProof(proofZkSNARK)
-		if err != nil {
-			return nil, voterID.Nil(), fmt.Errorf("failed on zk.ProtobufZKProofToCircomProof: %w", err)
+		if len(process.ProcessId) != 32 {
+			return nil, voterID.Nil(), fmt.Errorf("invalid processId length: %d", len(process.ProcessId))
 		}
 
-		// ve.Nullifier is encoded in little-endian
-		nullifierBI := arbo.BytesToBigInt(ve.Nullifier)
-
-		// check if vote already exists
-		if exist, err := app.State.EnvelopeExists(ve.ProcessId,
-			ve.Nullifier, false); err != nil || exist {
-			if err != nil {
-				return nil, voterID.Nil(), err
-			}
-			return nil, voterID.Nil(), fmt.Errorf("vote %x already exists", ve.Nullifier)
+		// check if vote already exists and cannot be overwritten
+		if err := app.State.CheckVoteOverwrite(process, ve.Nullifier, false); err != nil {
+			return nil, voterID.Nil(), err
 		}
 		log.Debugf("new zk vote %x for process %x", ve.Nullifier, ve.ProcessId)
 
-		if int(proofZkSNARK.CircuitParametersIndex) >= len(app.ZkVKs) ||
-			int(proofZkSNARK.CircuitParametersIndex) < 0 {
-			return nil, voterID.Nil(), fmt.Errorf("invalid CircuitParametersIndex: %d of %d", proofZkSNARK.CircuitParametersIndex, len(app.ZkVKs))
-		}
-		verificationKey := app.ZkVKs[proofZkSNARK.CircuitParametersIndex]
-
-		// prepare the publicInputs that are defined by the process.
-		// publicInputs contains: processId0, processId1, censusRoot,
-		// nullifier, voteHash0, voteHash1.
-		processId0BI := arbo.BytesToBigInt(process.ProcessId[:16])
-		processId1BI := arbo.BytesToBigInt(process.ProcessId[16:])
-		censusRootBI := arbo.BytesToBigInt(process.RollingCensusRoot)
-		// voteHash from the user voteValue to the publicInputs
-		voteValueHash := sha256.Sum256(ve.VotePackage)
-		voteHash0 := arbo.BytesToBigInt(voteValueHash[:16])
-		voteHash1 := arbo.BytesToBigInt(voteValueHash[16:])
-		publicInputs := []*big.Int{
-			processId0BI,
-			processId1BI,
-			censusRootBI,
-			nullifierBI,
-			voteHash0,
-			voteHash1,
-		}
-
-		// check zkSnark proof
-		if !verifier.Verify(verificationKey, proof, publicInputs) {
+		verificationKey, err := app.zkVerificationKey(proofZkSNARK.CircuitParametersIndex, false)
+		if err != nil {
+			return nil, voterID.Nil(), err
+		}
+
+		// check zkSnark proof, which may be already verified in parallel
+		// with the other votes of the block
+		valid, err := app.zkVerifier.verify(verificationKey, proofZkSNARK,
+			zkVoteInputs(process, ve))
+		if err != nil {
+			return nil, voterID.Nil(), err
+		}
+		if !valid {
 			return nil, voterID.Nil(), fmt.Errorf("zkSNARK proof verification failed")
 		}
 
@@ -501,12 +643,8 @@ func (app *BaseApplication) VoteEnvelopeCheck(ve *models.VoteEnvelope, txBytes,
 			// if we are on DeliverTx and the vote is in cache, lazy check
 			defer app.State.CacheDel(txID)
 			vote.Height = height // update vote height
-			if exist, err := app.State.EnvelopeExists(vote.ProcessId,
-				vote.Nullifier, false); err != nil || exist {
-				if err != nil {
-					return nil, voterID.Nil(), err
-				}
-				return nil, voterID.Nil(), fmt.Errorf("vote %x already exists", vote.Nullifier)
+			if err := app.State.CheckVoteOverwrite(process, vote.Nullifier, false); err != nil {
+				return nil, voterID.Nil(), err
 			}
 			if height > process.GetStartBlock()+process.GetBlockCount() ||
 				process.GetStatus() != models.ProcessStatus_READY {
@@ -524,8 +662,9 @@ func (app *BaseApplication) VoteEnvelopeCheck(ve *models.VoteEnvelope, txBytes,
 			VotePackage: ve.VotePackage,
 		}
 
-		// check proof is nil
-		if ve.Proof == nil {
+		// check proof is nil, a delegate voting only on behalf of its
+		// delegators does not need to be in the census
+		if ve.Proof == nil && len(delegations) == 0 {
 			return nil, voterID.Nil(), fmt.Errorf("proof not found on transaction")
 		}
 
@@ -549,24 +688,34 @@ func (app *BaseApplication) VoteEnvelopeCheck(ve *models.VoteEnvelope, txBytes,
 		// assign a nullifier
 		vote.Nullifier = GenerateNullifier(addr, vote.ProcessId)
 
-		// check if vote already exists
-		if exist, err := app.State.EnvelopeExists(vote.ProcessId,
-			vote.Nullifier, false); err != nil || exist {
-			if err != nil {
-				return nil, voterID.Nil(), err
-			}
-			return nil, voterID.Nil(), fmt.Errorf("vote %x already exists", vote.Nullifier)
+		// check if vote already exists and cannot be overwritten
+		if err := app.State.CheckVoteOverwrite(process, vote.Nullifier, false); err != nil {
+			return nil, voterID.Nil(), err
 		}
 		log.Debugf("new vote %x for address %s and process %x", vote.Nullifier, addr.Hex(), ve.ProcessId)
 
-		valid, weight, err := VerifyProof(process, ve.Proof,
-			process.CensusOrigin, process.CensusRoot, process.ProcessId,
-			pubKey, addr)
-		if err != nil {
-			return nil, voterID.Nil(), err
+		weight := new(big.Int)
+		if ve.Proof != nil {
+			valid, censusWeight, err := VerifyProof(process, ve.Proof,
+				process.CensusOrigin, process.CensusRoot, process.ProcessId,
+				pubKey, addr)
+			if err != nil {
+				return nil, voterID.Nil(), err
+			}
+			if !valid {
+				return nil, voterID.Nil(), fmt.Errorf("proof not valid")
+			}
+			weight.Set(censusWeight)
 		}
-		if !valid {
-			return nil, voterID.Nil(), fmt.Errorf("proof not valid")
+		// a delegate votes with the census weight of its delegators too
+		if len(delegations) > 0 {
+			delegatedWeight, err := app.State.VoteDelegationsWeight(process, addr, delegations, false)
+			if err != nil {
+				return nil, voterID.Nil(), fmt.Errorf("invalid delegations: %w", err)
+			}
+			weight.Add(weight, delegatedWeight)
+			log.Debugf("vote %x carries %d delegations with weight %s",
+				vote.Nullifier, len(delegations), delegatedWeight)
 		}
 		vote.Weight = weight.Bytes()
 	}
@@ -600,7 +749,8 @@ func AdminTxCheck(vtx *models.Tx, txBytes, signature []byte, state *State) (comm
 
 	switch tx.Txtype {
 	// TODO: @jordipainan make keykeeper independent of oracles
-	case models.TxType_ADD_PROCESS_KEYS, models.TxType_REVEAL_PROCESS_KEYS:
+	case models.TxType_ADD_PROCESS_KEYS, models.TxType_REVEAL_PROCESS_KEYS,
+		TxTypeComplainProcessKeys, TxTypeAnswerProcessKeysComplaint:
 		if tx.ProcessId == nil {
 			return common.Address{}, fmt.Errorf("missing processId on AdminTxCheck")
 		}
@@ -629,6 +779,12 @@ func AdminTxCheck(vtx *models.Tx, txBytes, signature []byte, state *State) (comm
 		if !authorized {
 			return common.Address{}, fmt.Errorf("unauthorized to perform an adminTx, address: %s", addr.Hex())
 		}
+		// the process keys are governed by the oracle multisig, if any
+		if IsOracleProcessTx(vtx) {
+			if err := state.VerifyOracleTx(vtx, addr); err != nil {
+				return common.Address{}, fmt.Errorf("tx sender not authorized: %w", err)
+			}
+		}
 		// check oracle account
 		oracleAcc, err := state.GetAccount(addr, false)
 		if err != nil {
@@ -677,6 +833,20 @@ func AdminTxCheck(vtx *models.Tx, txBytes, signature []byte, state *State) (comm
 			if err := checkAddProcessKeys(tx, process); err != nil {
 				return common.Address{}, err
 			}
+			// the threshold key is frozen at the end of the block before
+			// the start, and each oracle deals once
+			if len(tx.EncryptionPublicKey) != nacl.KeyLength {
+				if height >= process.StartBlock {
+					return common.Address{}, fmt.Errorf("threshold key for process %x already frozen", tx.ProcessId)
+				}
+				_, dealt, err := state.processKeyDealer(tx.ProcessId, addr)
+				if err != nil {
+					return common.Address{}, fmt.Errorf("cannot get process key dealers: %w", err)
+				}
+				if dealt {
+					return common.Address{}, fmt.Errorf("%s already published a threshold key dealing", addr.Hex())
+				}
+			}
 		case models.TxType_REVEAL_PROCESS_KEYS:
 			if tx.KeyIndex == nil {
 				return common.Address{}, fmt.Errorf("missing keyIndex on AdminTxCheck")
@@ -694,9 +864,13 @@ func AdminTxCheck(vtx *models.Tx, txBytes, signature []byte, state *State) (comm
 			if err := checkRevealProcessKeys(tx, process); err != nil {
 				return common.Address{}, err
 			}
+		case TxTypeComplainProcessKeys, TxTypeAnswerProcessKeysComplaint:
+			if _, _, err := checkProcessKeysComplaint(state, tx, process, addr); err != nil {
+				return common.Address{}, err
+			}
 		}
 	case models.TxType_ADD_ORACLE:
-		err := state.VerifyTreasurer(addr, tx.Nonce)
+		err := state.VerifyTreasurerTx(vtx, addr, tx.Nonce)
 		if err != nil {
 			return common.Address{}, fmt.Errorf("tx sender not authorized: %w", err)
 		}
@@ -716,7 +890,7 @@ func AdminTxCheck(vtx *models.Tx, txBytes, signature []byte, state *State) (comm
 			}
 		}
 	case models.TxType_REMOVE_ORACLE:
-		err := state.VerifyTreasurer(addr, tx.Nonce)
+		err := state.VerifyTreasurerTx(vtx, addr, tx.Nonce)
 		if err != nil {
 			return common.Address{}, fmt.Errorf("tx sender not authorized: %w", err)
 		}
@@ -740,12 +914,216 @@ func AdminTxCheck(vtx *models.Tx, txBytes, signature []byte, state *State) (comm
 		if !found {
 			return common.Address{}, fmt.Errorf("cannot remove oracle, not found")
 		}
+	case models.TxType_ADD_VALIDATOR:
+		// adding an existing validator updates its power
+		err := verifyValidatorTx(state, vtx, addr)
+		if err != nil {
+			return common.Address{}, fmt.Errorf("tx sender not authorized: %w", err)
+		}
+		if len(tx.PublicKey) != ed25519.PubKeySize {
+			return common.Address{}, fmt.Errorf("invalid validator ed25519 public key: %x", tx.PublicKey)
+		}
+		if tx.Power == nil || *tx.Power == 0 || *tx.Power > uint64(tmtypes.MaxTotalVotingPower) {
+			return common.Address{}, fmt.Errorf("invalid validator power")
+		}
+		address := ed25519.PubKey(tx.PublicKey).Address()
+		if tx.Address != nil && !bytes.Equal(tx.Address, address) {
+			return common.Address{}, fmt.Errorf("validator address %x does not match its public key", tx.Address)
+		}
+		validator, err := state.Validator(address, false)
+		if err != nil {
+			return common.Address{}, fmt.Errorf("cannot get validators: %w", err)
+		}
+		if validator != nil && validator.Power == *tx.Power {
+			return common.Address{}, fmt.Errorf("validator %x already added with power %d", address, validator.Power)
+		}
+		// tendermint halts if the total power of the updated set overflows
+		total, err := state.validatorsPower(address)
+		if err != nil {
+			return common.Address{}, fmt.Errorf("cannot get validators: %w", err)
+		}
+		if total+*tx.Power > uint64(tmtypes.MaxTotalVotingPower) {
+			return common.Address{}, fmt.Errorf("validators total power %d exceeds the maximum %d",
+				total+*tx.Power, tmtypes.MaxTotalVotingPower)
+		}
+	case models.TxType_REMOVE_VALIDATOR:
+		err := verifyValidatorTx(state, vtx, addr)
+		if err != nil {
+			return common.Address{}, fmt.Errorf("tx sender not authorized: %w", err)
+		}
+		if len(tx.Address) != tmcrypto.AddressSize {
+			return common.Address{}, fmt.Errorf("invalid validator address: %x", tx.Address)
+		}
+		validators, err := state.Validators(false)
+		if err != nil {
+			return common.Address{}, fmt.Errorf("cannot get validators: %w", err)
+		}
+		var found bool
+		for _, validator := range validators {
+			if bytes.Equal(validator.Address, tx.Address) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return common.Address{}, fmt.Errorf("cannot remove validator, not found")
+		}
+		// tendermint halts if the validator set becomes empty
+		if len(validators) == 1 {
+			return common.Address{}, fmt.Errorf("cannot remove the last validator")
+		}
+	case TxTypeAddZkCircuit:
+		// adding an existing circuit replaces its verification key
+		err := state.VerifyTreasurerTx(vtx, addr, tx.Nonce)
+		if err != nil {
+			return common.Address{}, fmt.Errorf("tx sender not authorized: %w", err)
+		}
+		if tx.KeyIndex == nil {
+			return common.Address{}, fmt.Errorf("missing circuit parameters index")
+		}
+		circuit, err := AdminTxZkCircuit(tx)
+		if err != nil {
+			return common.Address{}, err
+		}
+		if circuit == nil {
+			return common.Address{}, fmt.Errorf("missing zk circuit")
+		}
+		if err := circuit.Check(); err != nil {
+			return common.Address{}, fmt.Errorf("invalid zk circuit: %w", err)
+		}
+	case TxTypeRemoveZkCircuit:
+		err := state.VerifyTreasurerTx(vtx, addr, tx.Nonce)
+		if err != nil {
+			return common.Address{}, fmt.Errorf("tx sender not authorized: %w", err)
+		}
+		if tx.KeyIndex == nil {
+			return common.Address{}, fmt.Errorf("missing circuit parameters index")
+		}
+		circuit, registered, err := state.ZkCircuit(*tx.KeyIndex, false)
+		if err != nil {
+			return common.Address{}, fmt.Errorf("cannot get zk circuit: %w", err)
+		}
+		if registered && circuit == nil {
+			return common.Address{}, fmt.Errorf("zk circuit %d already removed", *tx.KeyIndex)
+		}
+	case TxTypeSetTreasurerMultisig, TxTypeSetOracleMultisig,
+		TxTypeProposeMultisigTx, TxTypeApproveMultisigTx:
+		if err := multisigTxCheck(vtx, addr, state); err != nil {
+			return common.Address{}, err
+		}
 	default:
 		return common.Address{}, fmt.Errorf("tx not supported")
 	}
 	return addr, nil
 }
 
+// The validator transactions are executed if signed by the treasurer, or once
+// approved by a quorum of more than two thirds of the oracles.  Each oracle
+// approves the transaction by sending the same one, with the treasurer nonce,
+// and the approvals are kept in the state until the quorum is reached.
+
+// validatorTxQuorum returns the number of approvals required out of the given
+// number of oracles.
+func validatorTxQuorum(oracles int) int {
+	return oracles*2/3 + 1
+}
+
+// validatorTxID returns the identifier of a validator transaction, shared by
+// all the oracles approving it.
+func validatorTxID(tx *models.AdminTx) ([]byte, error) {
+	txBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
+	id := sha256.Sum256(txBytes)
+	return id[:], nil
+}
+
+func containsAddress(addrs []common.Address, addr common.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyValidatorTx checks the validator transaction is authorized as a
+// treasurer one, see VerifyTreasurerTx, or signed by an oracle that did not
+// approve it yet, and its nonce is the treasurer nonce.
+func verifyValidatorTx(state *State, vtx *models.Tx, addr common.Address) error {
+	tx := vtx.GetAdmin()
+	treasurerErr := state.VerifyTreasurerTx(vtx, addr, tx.Nonce)
+	if treasurerErr == nil {
+		return nil
+	}
+	oracles, err := state.Oracles(false)
+	if err != nil {
+		return fmt.Errorf("cannot get oracles: %w", err)
+	}
+	if !containsAddress(oracles, addr) {
+		return treasurerErr
+	}
+	treasurer, err := state.Treasurer(false)
+	if err != nil {
+		return fmt.Errorf("cannot get treasurer: %w", err)
+	}
+	if treasurer.Nonce != tx.Nonce {
+		return ErrAccountNonceInvalid
+	}
+	id, err := validatorTxID(tx)
+	if err != nil {
+		return err
+	}
+	approvals, err := state.validatorTxApprovals(id)
+	if err != nil {
+		return fmt.Errorf("cannot get approvals: %w", err)
+	}
+	if containsAddress(approvals, addr) {
+		return fmt.Errorf("validator transaction %x already approved by %s", id, addr)
+	}
+	return nil
+}
+
+// approveValidatorTx records the approval of the validator transaction by
+// signer, checked by verifyValidatorTx, and returns true if the transaction is
+// to be executed: if authorized as a treasurer one or approved by the oracle
+// quorum.
+// Only the current oracles count for the quorum, and the approvals are
+// cleared once the transaction is executed.
+func approveValidatorTx(state *State, vtx *models.Tx, signer common.Address) (bool, error) {
+	tx := vtx.GetAdmin()
+	id, err := validatorTxID(tx)
+	if err != nil {
+		return false, err
+	}
+	approvals, err := state.validatorTxApprovals(id)
+	if err != nil {
+		return false, fmt.Errorf("cannot get approvals: %w", err)
+	}
+	if state.VerifyTreasurerTx(vtx, signer, tx.Nonce) != nil {
+		oracles, err := state.Oracles(false)
+		if err != nil {
+			return false, fmt.Errorf("cannot get oracles: %w", err)
+		}
+		approved := []common.Address{}
+		for _, oracle := range approvals {
+			if containsAddress(oracles, oracle) {
+				approved = append(approved, oracle)
+			}
+		}
+		approved = append(approved, signer)
+		if len(approved) < validatorTxQuorum(len(oracles)) {
+			log.Infof("validator transaction %x approved by %d oracles, %d required",
+				id, len(approved), validatorTxQuorum(len(oracles)))
+			return false, state.setValidatorTxApprovals(id, approved)
+		}
+	} else if len(approvals) == 0 {
+		return true, nil
+	}
+	return true, state.setValidatorTxApprovals(id, nil)
+}
+
 func checkAddProcessKeys(tx *models.AdminTx, process *models.Process) error {
 	if tx == nil {
 		return ErrNilTx
@@ -762,7 +1140,38 @@ func checkAddProcessKeys(tx *models.AdminTx, process *models.Process) error {
 	if len(process.EncryptionPublicKeys[*tx.KeyIndex]) > 0 {
 		return fmt.Errorf("key index %d already exists", tx.KeyIndex)
 	}
-	// TBD check that provided keys are correct (ed25519 for encryption and size for Commitment)
+	// threshold keys are dealings of a distributed key generation and
+	// cannot be mixed with regular keys
+	dealings, regular, err := processKeyDealings(process)
+	if err != nil {
+		return err
+	}
+	if len(tx.EncryptionPublicKey) == nacl.KeyLength {
+		if len(dealings) > 0 {
+			return fmt.Errorf("cannot add a regular key to a process with threshold keys")
+		}
+		return nil
+	}
+	dealing, err := dkg.UnmarshalDealing(tx.EncryptionPublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid threshold key dealing: %w", err)
+	}
+	if regular > 0 {
+		return fmt.Errorf("cannot add a threshold key to a process with regular keys")
+	}
+	if _, ok := dealing.Shares[int(*tx.KeyIndex)]; !ok {
+		return fmt.Errorf("threshold key dealing %d is not a participant", *tx.KeyIndex)
+	}
+	if len(dealings) > 0 {
+		if dealings[0].Threshold() != dealing.Threshold() {
+			return fmt.Errorf("threshold key dealing has threshold %d, expected %d",
+				dealing.Threshold(), dealings[0].Threshold())
+		}
+		if fmt.Sprint(dealings[0].Participants()) != fmt.Sprint(dealing.Participants()) {
+			return fmt.Errorf("threshold key dealing has participants %v, expected %v",
+				dealing.Participants(), dealings[0].Participants())
+		}
+	}
 	return nil
 }
 
@@ -781,6 +1190,21 @@ func checkRevealProcessKeys(tx *models.AdminTx, process *models.Process) error {
 		*tx.KeyIndex < 1 || *tx.KeyIndex > types.KeyKeeperMaxKeyIndex {
 		return fmt.Errorf("no keys provided or invalid key index")
 	}
+	// check the threshold key share against the dealings, any participant
+	// reveals its share even if its own dealing is missing
+	if IsThresholdKeyProcess(process) {
+		if len(process.EncryptionPrivateKeys[0]) > 0 {
+			return fmt.Errorf("threshold key for process %x already recovered", tx.ProcessId)
+		}
+		dealings, _, err := processKeyDealings(process)
+		if err != nil {
+			return err
+		}
+		if _, ok := dealings[0].Shares[int(*tx.KeyIndex)]; !ok {
+			return fmt.Errorf("key index %d is not a threshold key participant", *tx.KeyIndex)
+		}
+		return dkg.VerifyShare(dealings, int(*tx.KeyIndex), tx.EncryptionPrivateKey)
+	}
 	// check if provided keyIndex exists
 	if len(process.EncryptionPublicKeys[*tx.KeyIndex]) < 1 {
 		return fmt.Errorf("key index %d does not exist", *tx.KeyIndex)
@@ -833,9 +1257,10 @@ func SetTransactionCostsTxCheck(vtx *models.Tx, txBytes, signature []byte, state
 	if err != nil {
 		return 0, fmt.Errorf("cannot extract address from public key: %w", err)
 	}
-	// check signature recovered address
-	if common.BytesToAddress(treasurer.Address) != sigAddress {
-		return 0, fmt.Errorf("address recovered not treasurer: expected %s got %s", common.BytesToAddress(treasurer.Address), sigAddress.String())
+	// check signature recovered address is the treasurer, or a multisig
+	// signer executing an approved proposal
+	if err := state.VerifyTreasurerTx(vtx, sigAddress, tx.Nonce); err != nil {
+		return 0, fmt.Errorf("address recovered %s not authorized: %w", sigAddress, err)
 	}
 	return tx.Value, nil
 }
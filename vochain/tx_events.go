@@ -0,0 +1,55 @@
+package vochain
+
+import (
+	"fmt"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	models "go.vocdoni.io/proto/build/go/models"
+)
+
+// TxEventType is the type of the event attached to every delivered
+// transaction.  When the Tendermint transaction indexer is enabled, the
+// transactions can be searched by its attributes, i.e. via /tx_search with
+// the query "vochain.process_id='<hex>'".
+const TxEventType = "vochain"
+
+// Indexed attributes of the transaction event.  The binary values are hex
+// encoded, lowercase and without the 0x prefix.
+const (
+	TxEventKeyTxType    = "tx_type"
+	TxEventKeyProcessID = "process_id"
+	TxEventKeyEntityID  = "entity_id"
+	TxEventKeyNullifier = "nullifier"
+	TxEventKeyFrom      = "from"
+	TxEventKeyTo        = "to"
+)
+
+// txEventAttr is a key and value pair of the transaction event
+type txEventAttr struct {
+	key   string
+	value []byte
+}
+
+// txEvents returns the event of a transaction of type txType.  The attributes
+// with an empty value are omitted.
+func txEvents(txType models.TxType, attrs ...txEventAttr) []abcitypes.Event {
+	event := abcitypes.Event{
+		Type: TxEventType,
+		Attributes: []abcitypes.EventAttribute{{
+			Key:   []byte(TxEventKeyTxType),
+			Value: []byte(txType.String()),
+			Index: true,
+		}},
+	}
+	for _, attr := range attrs {
+		if len(attr.value) == 0 {
+			continue
+		}
+		event.Attributes = append(event.Attributes, abcitypes.EventAttribute{
+			Key:   []byte(attr.key),
+			Value: []byte(fmt.Sprintf("%x", attr.value)),
+			Index: true,
+		})
+	}
+	return []abcitypes.Event{event}
+}
@@ -0,0 +1,69 @@
+package vochain
+
+import (
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTxEvents(t *testing.T) {
+	app := TestBaseApplication(t)
+	signer := ethereum.SignKeys{}
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	to := ethereum.SignKeys{}
+	qt.Assert(t, to.Generate(), qt.IsNil)
+	app.State.SetAccount(BurnAddress, &Account{})
+	qt.Assert(t, app.State.SetTreasurer(signer.Address(), 0), qt.IsNil)
+	qt.Assert(t, app.State.SetTxCost(models.TxType_SEND_TOKENS, 10), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(signer.Address(), "", nil, 0), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(to.Address(), "", nil, 0), qt.IsNil)
+	qt.Assert(t, app.State.MintBalance(signer.Address(), 100), qt.IsNil)
+	app.Commit()
+
+	deliver := func(tx *models.Tx) map[string]string {
+		t.Helper()
+		stx := &models.SignedTx{}
+		var err error
+		stx.Tx, err = proto.Marshal(tx)
+		qt.Assert(t, err, qt.IsNil)
+		stx.Signature, err = signer.SignVocdoniTx(stx.Tx, app.chainID)
+		qt.Assert(t, err, qt.IsNil)
+		stxBytes, err := proto.Marshal(stx)
+		qt.Assert(t, err, qt.IsNil)
+		resp := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: stxBytes})
+		qt.Assert(t, resp.Code, qt.Equals, uint32(0), qt.Commentf("%s", resp.Data))
+		qt.Assert(t, resp.Events, qt.HasLen, 1)
+		qt.Assert(t, resp.Events[0].Type, qt.Equals, TxEventType)
+		attrs := make(map[string]string)
+		for _, attr := range resp.Events[0].Attributes {
+			qt.Assert(t, attr.Index, qt.IsTrue)
+			attrs[string(attr.Key)] = string(attr.Value)
+		}
+		return attrs
+	}
+
+	qt.Assert(t, deliver(&models.Tx{Payload: &models.Tx_SendTokens{SendTokens: &models.SendTokensTx{
+		Txtype: models.TxType_SEND_TOKENS,
+		From:   signer.Address().Bytes(),
+		To:     to.Address().Bytes(),
+		Value:  10,
+	}}}), qt.DeepEquals, map[string]string{
+		TxEventKeyTxType: "SEND_TOKENS",
+		TxEventKeyFrom:   fmt.Sprintf("%x", signer.Address()),
+		TxEventKeyTo:     fmt.Sprintf("%x", to.Address()),
+	})
+
+	qt.Assert(t, deliver(&models.Tx{Payload: &models.Tx_Admin{Admin: &models.AdminTx{
+		Txtype:  models.TxType_ADD_ORACLE,
+		Address: to.Address().Bytes(),
+	}}}), qt.DeepEquals, map[string]string{
+		TxEventKeyTxType: "ADD_ORACLE",
+		TxEventKeyFrom:   fmt.Sprintf("%x", signer.Address()),
+		TxEventKeyTo:     fmt.Sprintf("%x", to.Address()),
+	})
+}
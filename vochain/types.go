@@ -229,6 +229,24 @@ type TendermintPubKey struct {
 
 // _________________________ CENSUS ORIGINS __________________________
 
+// The census origins backed by the Vochain accounts are not part of the models
+// yet.  The census of these processes is the Accounts tree, snapshotted when the
+// process starts, so any account registered before the StartBlock can vote with
+// a proof of its account leaf.
+const (
+	// CensusOriginVochainAccounts gives a weight of 1 to every account.
+	CensusOriginVochainAccounts models.CensusOrigin = 21
+	// CensusOriginVochainAccountsWeighted weights every account by its
+	// balance.  Accounts without balance cannot vote.
+	CensusOriginVochainAccountsWeighted models.CensusOrigin = 22
+)
+
+// IsVochainAccountsCensus returns true if the census origin is backed by the
+// Vochain accounts.
+func IsVochainAccountsCensus(origin models.CensusOrigin) bool {
+	return origin == CensusOriginVochainAccounts || origin == CensusOriginVochainAccountsWeighted
+}
+
 type CensusProperties struct {
 	Name              string
 	AllowCensusUpdate bool
@@ -249,4 +267,6 @@ var CensusOrigins = map[models.CensusOrigin]CensusProperties{
 		WeightedSupport: true, NeedsIndexSlot: true},
 	models.CensusOrigin_OFF_CHAIN_CA: {Name: "ca", WeightedSupport: true,
 		NeedsURI: true, AllowCensusUpdate: true},
+	CensusOriginVochainAccounts:         {Name: "vochain accounts"},
+	CensusOriginVochainAccountsWeighted: {Name: "vochain weighted accounts", WeightedSupport: true},
 }
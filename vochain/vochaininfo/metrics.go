@@ -5,6 +5,7 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"go.vocdoni.io/dvote/metrics"
+	"go.vocdoni.io/dvote/vochain"
 )
 
 // Vochain collectors
@@ -62,6 +63,7 @@ func (vi *VochainInfo) registerMetrics(ma *metrics.Agent) {
 	ma.Register(VochainVoteTree)
 	ma.Register(VochainVotesPerMinute)
 	ma.Register(VochainVoteCache)
+	ma.Register(vochain.ZkVerificationTime)
 }
 
 // getMetrics updates the metrics values to the current state
@@ -0,0 +1,831 @@
+// Package webhooks delivers the lifecycle events of the elections (start, end,
+// cancel and results) to the HTTP endpoints registered by the API users.
+// Each payload is signed with the node key and the deliveries are stored on
+// disk, so they survive restarts and are retried with exponential backoff.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/metadb"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/scrutinizer"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+/*
+ KV database scheme:
+   w_{webhookID} = {Webhook} // the registered webhooks
+   q_{deliveryID} = {Delivery} // the deliveries pending to be sent
+   d_{deliveryID} = {Delivery} // the finished deliveries, without payload
+*/
+
+const (
+	dbPrefixWebhook  = "w_"
+	dbPrefixQueue    = "q_"
+	dbPrefixDelivery = "d_"
+)
+
+// Event types
+const (
+	EventElectionStarted  = "election.started"
+	EventElectionEnded    = "election.ended"
+	EventElectionCanceled = "election.canceled"
+	EventElectionResults  = "election.results"
+)
+
+// EventTypes are the types of the events delivered to the webhooks.
+var EventTypes = []string{
+	EventElectionStarted,
+	EventElectionEnded,
+	EventElectionCanceled,
+	EventElectionResults,
+}
+
+// Delivery status
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// HTTP headers set on each delivery
+const (
+	HeaderSignature = "X-Vocdoni-Signature"
+	HeaderSigner    = "X-Vocdoni-Signer"
+	HeaderDelivery  = "X-Vocdoni-Delivery"
+	HeaderEvent     = "X-Vocdoni-Event"
+)
+
+const (
+	// DefaultRetryBase is the delay before the first retry
+	DefaultRetryBase = 10 * time.Second
+	// DefaultRetryMax is the maximum delay between retries
+	DefaultRetryMax = time.Hour
+	// DefaultMaxAttempts is the number of attempts before a delivery fails
+	DefaultMaxAttempts = 15
+	// DefaultMaxPerOwner is the maximum number of webhooks of an owner
+	DefaultMaxPerOwner = 10
+	// DefaultWorkers is the number of deliveries sent concurrently
+	DefaultWorkers = 8
+
+	deliveryTimeout = 10 * time.Second
+	resolveTimeout  = 5 * time.Second
+	pollInterval    = time.Second
+)
+
+// Webhook is an HTTP endpoint subscribed to the election events.  The empty
+// filters match any value.
+type Webhook struct {
+	ID        string         `json:"webhookId"`
+	URL       string         `json:"url"`
+	EntityID  types.HexBytes `json:"entityId,omitempty"`
+	ProcessID types.HexBytes `json:"processId,omitempty"`
+	Events    []string       `json:"events,omitempty"`
+	// Owner identifies the API user who registered the webhook
+	Owner   string    `json:"owner,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// matches returns true if the event passes the webhook filters.
+func (w *Webhook) matches(e *Event) bool {
+	if len(w.EntityID) > 0 && !bytes.Equal(w.EntityID, e.EntityID) {
+		return false
+	}
+	if len(w.ProcessID) > 0 && !bytes.Equal(w.ProcessID, e.ProcessID) {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, t := range w.Events {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the payload sent to the webhooks.
+type Event struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Height    uint32            `json:"height"`
+	ProcessID types.HexBytes    `json:"processId"`
+	EntityID  types.HexBytes    `json:"entityId"`
+	Results   [][]*types.BigInt `json:"results,omitempty"`
+	Weight    *types.BigInt     `json:"weight,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Delivery is the state of an event sent to a webhook.
+type Delivery struct {
+	ID          string    `json:"deliveryId"`
+	WebhookID   string    `json:"webhookId"`
+	Event       string    `json:"event"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+	Payload     []byte    `json:"payload,omitempty"`
+}
+
+// Webhooks stores the registered webhooks and delivers the election events
+// to them.  It listens to the vochain state for the start, end and cancel of
+// the processes, and to the scrutinizer for the results.
+type Webhooks struct {
+	// RetryBase is the delay before the first retry, doubled on each attempt
+	RetryBase time.Duration
+	// RetryMax is the maximum delay between retries
+	RetryMax time.Duration
+	// MaxAttempts is the number of attempts before a delivery fails
+	MaxAttempts int
+	// MaxPerOwner is the maximum number of webhooks an owner can register
+	MaxPerOwner int
+	// Workers is the number of deliveries sent concurrently.  It must be set
+	// before Start.
+	Workers int
+	// AllowPrivateAddresses allows the webhooks to point to loopback,
+	// private and link-local addresses.  It must be only enabled for testing.
+	AllowPrivateAddresses bool
+
+	db       db.Database
+	signer   *ethereum.SignKeys
+	state    *vochain.State
+	client   *http.Client
+	webhooks map[string]*Webhook
+	// pending are the events of the block being processed
+	pending []*Event
+	lock    sync.RWMutex
+	// dbLock serializes the enqueue and delivery updates
+	dbLock sync.Mutex
+	// sending are the webhooks with a delivery being sent, so each webhook
+	// holds at most one worker and a slow endpoint does not delay the others
+	sending     map[string]bool
+	sendingLock sync.Mutex
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// NewWebhooks creates a new Webhooks, storing its state on dataDir, and
+// registers it as a vochain event listener and, if not nil, as a scrutinizer
+// event listener.  Start must be called to begin the deliveries.
+func NewWebhooks(dataDir string, signer *ethereum.SignKeys, v *vochain.BaseApplication,
+	scr *scrutinizer.Scrutinizer) (*Webhooks, error) {
+	if v == nil {
+		return nil, fmt.Errorf("missing vochain for creating webhooks")
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("missing signer for creating webhooks")
+	}
+	database, err := metadb.New(db.TypePebble, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	wh := &Webhooks{
+		RetryBase:   DefaultRetryBase,
+		RetryMax:    DefaultRetryMax,
+		MaxAttempts: DefaultMaxAttempts,
+		MaxPerOwner: DefaultMaxPerOwner,
+		Workers:     DefaultWorkers,
+		db:          database,
+		signer:      signer,
+		state:       v.State,
+		webhooks:    make(map[string]*Webhook),
+		sending:     make(map[string]bool),
+	}
+	// the addresses are checked again when dialing, since the host could
+	// resolve to a different address than when the webhook was added
+	dialer := &net.Dialer{
+		Timeout: deliveryTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return wh.checkAddress(net.ParseIP(host))
+		},
+	}
+	wh.client = &http.Client{
+		Timeout:   deliveryTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+	if err := database.Iterate([]byte(dbPrefixWebhook), func(_, value []byte) bool {
+		w := &Webhook{}
+		if err = json.Unmarshal(value, w); err != nil {
+			return false
+		}
+		wh.webhooks[w.ID] = w
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode webhook: %w", err)
+	}
+	v.State.AddEventListener(wh)
+	if scr != nil {
+		scr.AddEventListener(wh)
+	}
+	return wh, nil
+}
+
+// Start begins delivering the pending events, until Close is called.
+func (wh *Webhooks) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	wh.cancel = cancel
+	workers := wh.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan *Delivery, workers)
+	for i := 0; i < workers; i++ {
+		wh.wg.Add(1)
+		go func() {
+			defer wh.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case d := <-queue:
+					wh.deliver(ctx, d)
+				}
+			}
+		}()
+	}
+	wh.wg.Add(1)
+	go func() {
+		defer wh.wg.Done()
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				wh.dispatchPending(ctx, queue)
+			}
+		}
+	}()
+}
+
+// Close stops the deliveries and closes the database.
+func (wh *Webhooks) Close() error {
+	if wh.cancel != nil {
+		wh.cancel()
+		wh.wg.Wait()
+	}
+	return wh.db.Close()
+}
+
+// Signer returns the address of the key signing the payloads.
+func (wh *Webhooks) Signer() string {
+	return wh.signer.AddressString()
+}
+
+// Add registers a new webhook and returns its ID.
+func (wh *Webhooks) Add(w *Webhook) (string, error) {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "", fmt.Errorf("invalid webhook url %q", w.URL)
+	}
+	for _, t := range w.Events {
+		if !validEventType(t) {
+			return "", fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	if err := wh.checkHost(u.Hostname()); err != nil {
+		return "", fmt.Errorf("invalid webhook url %q: %w", w.URL, err)
+	}
+	w.ID = uuid.New().String()
+	w.Created = time.Now().UTC()
+	data, err := json.Marshal(w)
+	if err != nil {
+		return "", err
+	}
+	wh.lock.Lock()
+	defer wh.lock.Unlock()
+	if wh.MaxPerOwner > 0 && wh.count(w.Owner) >= wh.MaxPerOwner {
+		return "", fmt.Errorf("maximum number of webhooks (%d) reached", wh.MaxPerOwner)
+	}
+	wTx := wh.db.WriteTx()
+	defer wTx.Discard()
+	if err := wTx.Set([]byte(dbPrefixWebhook+w.ID), data); err != nil {
+		return "", err
+	}
+	if err := wTx.Commit(); err != nil {
+		return "", err
+	}
+	wh.webhooks[w.ID] = w
+	return w.ID, nil
+}
+
+// count returns the number of webhooks registered by owner.
+// The caller must hold lock.
+func (wh *Webhooks) count(owner string) int {
+	n := 0
+	for _, w := range wh.webhooks {
+		if w.Owner == owner {
+			n++
+		}
+	}
+	return n
+}
+
+// checkHost resolves the host of a webhook url and returns an error if any of
+// its addresses is not allowed.
+func (wh *Webhooks) checkHost(host string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve host %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if err := wh.checkAddress(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAddress returns an error if the webhooks cannot be delivered to ip,
+// which are the loopback, private, link-local and unspecified addresses,
+// unless AllowPrivateAddresses is set.
+func (wh *Webhooks) checkAddress(ip net.IP) error {
+	if ip == nil {
+		return fmt.Errorf("invalid address")
+	}
+	if wh.AllowPrivateAddresses {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("address %s not allowed", ip)
+	}
+	return nil
+}
+
+// Remove deletes a webhook.  Its pending deliveries are discarded.
+func (wh *Webhooks) Remove(id string) error {
+	wh.lock.Lock()
+	defer wh.lock.Unlock()
+	if _, ok := wh.webhooks[id]; !ok {
+		return fmt.Errorf("webhook %s not found", id)
+	}
+	wTx := wh.db.WriteTx()
+	defer wTx.Discard()
+	if err := wTx.Delete([]byte(dbPrefixWebhook + id)); err != nil {
+		return err
+	}
+	if err := wTx.Commit(); err != nil {
+		return err
+	}
+	delete(wh.webhooks, id)
+	return nil
+}
+
+// Webhook returns a registered webhook.
+func (wh *Webhooks) Webhook(id string) (*Webhook, error) {
+	wh.lock.RLock()
+	defer wh.lock.RUnlock()
+	w, ok := wh.webhooks[id]
+	if !ok {
+		return nil, fmt.Errorf("webhook %s not found", id)
+	}
+	return w, nil
+}
+
+// List returns the webhooks registered by owner, sorted by creation time.
+func (wh *Webhooks) List(owner string) []*Webhook {
+	wh.lock.RLock()
+	defer wh.lock.RUnlock()
+	list := []*Webhook{}
+	for _, w := range wh.webhooks {
+		if w.Owner == owner {
+			list = append(list, w)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// Deliveries returns the pending and finished deliveries of a webhook,
+// without their payload.
+func (wh *Webhooks) Deliveries(webhookID string) ([]*Delivery, error) {
+	deliveries := []*Delivery{}
+	for _, prefix := range []string{dbPrefixQueue, dbPrefixDelivery} {
+		var decodeErr error
+		if err := wh.db.Iterate([]byte(prefix), func(_, value []byte) bool {
+			d := &Delivery{}
+			if decodeErr = json.Unmarshal(value, d); decodeErr != nil {
+				return false
+			}
+			if d.WebhookID == webhookID {
+				d.Payload = nil
+				deliveries = append(deliveries, d)
+			}
+			return true
+		}); err != nil {
+			return nil, err
+		}
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+	}
+	return deliveries, nil
+}
+
+func validEventType(t string) bool {
+	for _, et := range EventTypes {
+		if t == et {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue stores a delivery of the event for each matching webhook.  The
+// delivery ID is derived from the event and the webhook, so an event seen
+// twice (i.e. a block replayed after a restart) is only delivered once.
+func (wh *Webhooks) enqueue(e *Event) error {
+	wh.lock.RLock()
+	matching := []*Webhook{}
+	for _, w := range wh.webhooks {
+		if w.matches(e) {
+			matching = append(matching, w)
+		}
+	}
+	wh.lock.RUnlock()
+	if len(matching) == 0 {
+		return nil
+	}
+
+	wh.dbLock.Lock()
+	defer wh.dbLock.Unlock()
+	wTx := wh.db.WriteTx()
+	defer wTx.Discard()
+	e.ID = fmt.Sprintf("%s-%x", e.Type, e.ProcessID)
+	e.Timestamp = time.Now().UTC()
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	for _, w := range matching {
+		id := e.ID + "-" + w.ID
+		if exists(wTx, dbPrefixQueue+id) || exists(wTx, dbPrefixDelivery+id) {
+			continue
+		}
+		data, err := json.Marshal(&Delivery{
+			ID:          id,
+			WebhookID:   w.ID,
+			Event:       e.Type,
+			Status:      DeliveryPending,
+			NextAttempt: e.Timestamp,
+			Payload:     payload,
+		})
+		if err != nil {
+			return err
+		}
+		if err := wTx.Set([]byte(dbPrefixQueue+id), data); err != nil {
+			return err
+		}
+	}
+	return wTx.Commit()
+}
+
+func exists(tx db.WriteTx, key string) bool {
+	_, err := tx.Get([]byte(key))
+	return err == nil
+}
+
+// dispatchPending queues to the workers the pending deliveries whose retry
+// time is due.  The deliveries of a webhook already being sent are left for a
+// later poll, as well as the ones not fitting the queue.
+func (wh *Webhooks) dispatchPending(ctx context.Context, queue chan<- *Delivery) {
+	now := time.Now()
+	due := []*Delivery{}
+	if err := wh.db.Iterate([]byte(dbPrefixQueue), func(_, value []byte) bool {
+		d := &Delivery{}
+		if err := json.Unmarshal(value, d); err != nil {
+			log.Warnf("cannot decode webhook delivery: %v", err)
+			return true
+		}
+		if !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+		return true
+	}); err != nil {
+		log.Warnf("cannot read webhook deliveries: %v", err)
+		return
+	}
+	for _, d := range due {
+		if !wh.startSending(d.WebhookID) {
+			continue
+		}
+		select {
+		case queue <- d:
+		case <-ctx.Done():
+			wh.endSending(d.WebhookID)
+			return
+		default:
+			// all the workers are busy
+			wh.endSending(d.WebhookID)
+			return
+		}
+	}
+}
+
+// startSending marks the webhook as being sent a delivery.  It returns false
+// if the webhook was already marked.
+func (wh *Webhooks) startSending(webhookID string) bool {
+	wh.sendingLock.Lock()
+	defer wh.sendingLock.Unlock()
+	if wh.sending[webhookID] {
+		return false
+	}
+	wh.sending[webhookID] = true
+	return true
+}
+
+// endSending unmarks the webhook marked by startSending.
+func (wh *Webhooks) endSending(webhookID string) {
+	wh.sendingLock.Lock()
+	defer wh.sendingLock.Unlock()
+	delete(wh.sending, webhookID)
+}
+
+// dueDelivery returns the delivery from the queue if it is still due, or nil
+// if it was already sent since it was queued to the workers.
+func (wh *Webhooks) dueDelivery(id string) (*Delivery, error) {
+	rTx := wh.db.ReadTx()
+	defer rTx.Discard()
+	value, err := rTx.Get([]byte(dbPrefixQueue + id))
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := &Delivery{}
+	if err := json.Unmarshal(value, d); err != nil {
+		return nil, err
+	}
+	if d.NextAttempt.After(time.Now()) {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// deliver sends a delivery to its webhook and stores the result.
+func (wh *Webhooks) deliver(ctx context.Context, queued *Delivery) {
+	defer wh.endSending(queued.WebhookID)
+	d, err := wh.dueDelivery(queued.ID)
+	if err != nil {
+		log.Warnf("cannot read webhook delivery %s: %v", queued.ID, err)
+		return
+	}
+	if d == nil {
+		return
+	}
+	w, err := wh.Webhook(d.WebhookID)
+	if err != nil {
+		// the webhook was removed
+		if err := wh.update(d, true); err != nil {
+			log.Warnf("cannot discard webhook delivery %s: %v", d.ID, err)
+		}
+		return
+	}
+	d.Attempts++
+	done := true
+	if err := wh.send(ctx, w, d); err != nil {
+		if ctx.Err() != nil {
+			// closing, the attempt is not counted
+			return
+		}
+		log.Debugf("webhook delivery %s failed (attempt %d): %v", d.ID, d.Attempts, err)
+		d.LastError = err.Error()
+		d.Status = DeliveryFailed
+		if d.Attempts < wh.MaxAttempts {
+			d.Status = DeliveryPending
+			d.NextAttempt = time.Now().Add(wh.backoff(d.Attempts))
+			done = false
+		}
+	} else {
+		d.Status = DeliveryDelivered
+		d.LastError = ""
+	}
+	if err := wh.update(d, done); err != nil {
+		log.Warnf("cannot update webhook delivery %s: %v", d.ID, err)
+	}
+}
+
+// backoff returns the delay before the next attempt.
+func (wh *Webhooks) backoff(attempts int) time.Duration {
+	delay := wh.RetryBase
+	for i := 1; i < attempts && delay < wh.RetryMax; i++ {
+		delay *= 2
+	}
+	if delay > wh.RetryMax {
+		delay = wh.RetryMax
+	}
+	return delay
+}
+
+// update stores the delivery state.  If done, the delivery is moved out of
+// the queue, unless its webhook was removed, in which case it is deleted.
+func (wh *Webhooks) update(d *Delivery, done bool) error {
+	wh.dbLock.Lock()
+	defer wh.dbLock.Unlock()
+	wTx := wh.db.WriteTx()
+	defer wTx.Discard()
+	if !done {
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		if err := wTx.Set([]byte(dbPrefixQueue+d.ID), data); err != nil {
+			return err
+		}
+		return wTx.Commit()
+	}
+	if err := wTx.Delete([]byte(dbPrefixQueue + d.ID)); err != nil {
+		return err
+	}
+	if d.Status != DeliveryPending {
+		d.Payload = nil
+		d.NextAttempt = time.Time{}
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		if err := wTx.Set([]byte(dbPrefixDelivery+d.ID), data); err != nil {
+			return err
+		}
+	}
+	return wTx.Commit()
+}
+
+// send posts the delivery payload to the webhook.  The payload is signed as
+// a vocdoni message with the node key.
+func (wh *Webhooks) send(ctx context.Context, w *Webhook, d *Delivery) error {
+	signature, err := wh.signer.SignVocdoniMsg(d.Payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderSignature, fmt.Sprintf("%x", signature))
+	req.Header.Set(HeaderSigner, wh.signer.AddressString())
+	req.Header.Set(HeaderDelivery, d.ID)
+	req.Header.Set(HeaderEvent, d.Event)
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// add appends an event to the pending block.
+func (wh *Webhooks) add(e *Event) {
+	wh.lock.Lock()
+	defer wh.lock.Unlock()
+	wh.pending = append(wh.pending, e)
+}
+
+// Rollback discards the events of the block being processed.
+func (wh *Webhooks) Rollback() {
+	wh.lock.Lock()
+	defer wh.lock.Unlock()
+	wh.pending = nil
+}
+
+// Commit enqueues the events of the committed block.
+func (wh *Webhooks) Commit(height uint32) error {
+	wh.lock.Lock()
+	pending := wh.pending
+	wh.pending = nil
+	wh.lock.Unlock()
+	for _, e := range pending {
+		e.Height = height
+		if err := wh.setEntityID(e); err != nil {
+			log.Warnf("cannot get webhook event entity: %v", err)
+			continue
+		}
+		if err := wh.enqueue(e); err != nil {
+			return fmt.Errorf("cannot enqueue webhook event: %w", err)
+		}
+	}
+	return nil
+}
+
+// setEntityID sets the entity of the event process from the committed state.
+func (wh *Webhooks) setEntityID(e *Event) error {
+	if e.EntityID != nil {
+		return nil
+	}
+	p, err := wh.state.Process(e.ProcessID, true)
+	if err != nil {
+		return err
+	}
+	e.EntityID = p.EntityId
+	return nil
+}
+
+// OnProcessStatusChange adds an ended or canceled event.
+func (wh *Webhooks) OnProcessStatusChange(pid []byte, status models.ProcessStatus, txIndex int32) {
+	switch status {
+	case models.ProcessStatus_ENDED:
+		wh.add(&Event{Type: EventElectionEnded, ProcessID: pid})
+	case models.ProcessStatus_CANCELED:
+		wh.add(&Event{Type: EventElectionCanceled, ProcessID: pid})
+	}
+}
+
+// OnCancel adds a canceled event.
+func (wh *Webhooks) OnCancel(pid []byte, txIndex int32) {
+	wh.add(&Event{Type: EventElectionCanceled, ProcessID: pid})
+}
+
+// OnProcessesStart enqueues a started event for each process.  It is called
+// once the block is committed, with the processes starting on the next one.
+func (wh *Webhooks) OnProcessesStart(pids [][]byte) {
+	for _, pid := range pids {
+		p, err := wh.state.Process(pid, true)
+		if err != nil {
+			log.Warnf("cannot get webhook event process: %v", err)
+			continue
+		}
+		if err := wh.enqueue(&Event{
+			Type:      EventElectionStarted,
+			Height:    p.StartBlock,
+			ProcessID: pid,
+			EntityID:  p.EntityId,
+		}); err != nil {
+			log.Warnf("cannot enqueue webhook event: %v", err)
+		}
+	}
+}
+
+// OnComputeResults enqueues a results event.
+func (wh *Webhooks) OnComputeResults(results *indexertypes.Results,
+	process *indexertypes.Process, height uint32) {
+	if err := wh.enqueue(&Event{
+		Type:      EventElectionResults,
+		Height:    height,
+		ProcessID: process.ID,
+		EntityID:  process.EntityID,
+		Results:   results.Votes,
+		Weight:    results.Weight,
+	}); err != nil {
+		log.Warnf("cannot enqueue webhook event: %v", err)
+	}
+}
+
+// OnVote is not used by the Webhooks
+func (wh *Webhooks) OnVote(v *models.Vote, voterID types.VoterID, txIndex int32) {}
+
+// OnNewTx is not used by the Webhooks
+func (wh *Webhooks) OnNewTx(hash []byte, blockHeight uint32, txIndex int32) {}
+
+// OnProcess is not used by the Webhooks
+func (wh *Webhooks) OnProcess(pid, eid []byte, censusRoot, censusURI string, txIndex int32) {}
+
+// OnProcessKeys is not used by the Webhooks
+func (wh *Webhooks) OnProcessKeys(pid []byte, encryptionPub string, txIndex int32) {}
+
+// OnRevealKeys is not used by the Webhooks
+func (wh *Webhooks) OnRevealKeys(pid []byte, encryptionPriv string, txIndex int32) {}
+
+// OnProcessResults is not used by the Webhooks, the results are taken from
+// the scrutinizer
+func (wh *Webhooks) OnProcessResults(pid []byte, results *models.ProcessResult, txIndex int32) error {
+	return nil
+}
+
+// OnOracleResults is not used by the Webhooks
+func (wh *Webhooks) OnOracleResults(oracleResults *models.ProcessResult, pid []byte, height uint32) {}
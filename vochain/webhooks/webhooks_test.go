@@ -0,0 +1,260 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/scrutinizer/indexertypes"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+func TestWebhooks(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	signer := ethereum.NewSignKeys()
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	dataDir := t.TempDir()
+	wh, err := NewWebhooks(dataDir, signer, app, nil)
+	qt.Assert(t, err, qt.IsNil)
+	wh.RetryBase = 10 * time.Millisecond
+	// the test server listens on the loopback address
+	wh.AllowPrivateAddresses = true
+
+	// the receiver fails the first request
+	var lock sync.Mutex
+	received := []*Event{}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		qt.Check(t, err, qt.IsNil)
+		signature, err := hex.DecodeString(r.Header.Get(HeaderSignature))
+		qt.Check(t, err, qt.IsNil)
+		addr, err := ethereum.AddrFromSignature(ethereum.BuildVocdoniMessage(body), signature)
+		qt.Check(t, err, qt.IsNil)
+		qt.Check(t, addr, qt.Equals, signer.Address())
+		e := &Event{}
+		qt.Check(t, json.Unmarshal(body, e), qt.IsNil)
+		received = append(received, e)
+	}))
+	defer server.Close()
+
+	_, err = wh.Add(&Webhook{URL: "ftp://example.com"})
+	qt.Assert(t, err, qt.IsNotNil)
+	_, err = wh.Add(&Webhook{URL: server.URL, Events: []string{"unknown"}})
+	qt.Assert(t, err, qt.IsNotNil)
+
+	eid := util.RandomBytes(types.EntityIDsize)
+	id, err := wh.Add(&Webhook{URL: server.URL, EntityID: eid, Owner: "owner"})
+	qt.Assert(t, err, qt.IsNil)
+	otherID, err := wh.Add(&Webhook{URL: server.URL, EntityID: util.RandomBytes(types.EntityIDsize),
+		Owner: "owner"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, wh.List("owner"), qt.HasLen, 2)
+	qt.Assert(t, wh.List("other"), qt.HasLen, 0)
+
+	pid := util.RandomBytes(types.ProcessIDsize)
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EntityId:     eid,
+		StartBlock:   1,
+		BlockCount:   100,
+		Status:       models.ProcessStatus_READY,
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{Interruptible: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+	}), qt.IsNil)
+	app.State.SetHeight(1)
+	_, err = app.State.Save()
+	qt.Assert(t, err, qt.IsNil)
+
+	// a discarded block is not delivered
+	wh.OnCancel(pid, 0)
+	wh.Rollback()
+	qt.Assert(t, app.State.SetProcessStatus(pid, models.ProcessStatus_ENDED, true), qt.IsNil)
+	app.State.SetHeight(2)
+	_, err = app.State.Save()
+	qt.Assert(t, err, qt.IsNil)
+	wh.OnComputeResults(&indexertypes.Results{Votes: [][]*types.BigInt{{new(types.BigInt).SetUint64(3)}}},
+		&indexertypes.Process{ID: pid, EntityID: eid}, 3)
+
+	wh.Start()
+	waitDelivered := func() []*Delivery {
+		for i := 0; i < 100; i++ {
+			deliveries, err := wh.Deliveries(id)
+			qt.Assert(t, err, qt.IsNil)
+			delivered := 0
+			for _, d := range deliveries {
+				if d.Status == DeliveryDelivered {
+					delivered++
+				}
+			}
+			if delivered == len(deliveries) {
+				return deliveries
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatal("timeout waiting for the deliveries")
+		return nil
+	}
+	deliveries := waitDelivered()
+	qt.Assert(t, deliveries, qt.HasLen, 2)
+	others, err := wh.Deliveries(otherID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, others, qt.HasLen, 0)
+
+	lock.Lock()
+	qt.Assert(t, requests, qt.Equals, 3)
+	qt.Assert(t, received, qt.HasLen, 2)
+	byType := map[string]*Event{}
+	for _, e := range received {
+		qt.Assert(t, []byte(e.ProcessID), qt.DeepEquals, pid)
+		qt.Assert(t, []byte(e.EntityID), qt.DeepEquals, eid)
+		byType[e.Type] = e
+	}
+	qt.Assert(t, byType[EventElectionEnded].Height, qt.Equals, uint32(2))
+	qt.Assert(t, byType[EventElectionResults].Results[0][0].String(), qt.Equals, "3")
+	lock.Unlock()
+
+	// after a restart the webhooks are kept and the events are not repeated
+	qt.Assert(t, wh.Close(), qt.IsNil)
+	wh, err = NewWebhooks(dataDir, signer, app, nil)
+	qt.Assert(t, err, qt.IsNil)
+	defer wh.Close()
+	qt.Assert(t, wh.List("owner"), qt.HasLen, 2)
+	wh.OnComputeResults(&indexertypes.Results{}, &indexertypes.Process{ID: pid, EntityID: eid}, 3)
+	deliveries, err = wh.Deliveries(id)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, deliveries, qt.HasLen, 2)
+
+	// remove a webhook
+	qt.Assert(t, wh.Remove(otherID), qt.IsNil)
+	qt.Assert(t, wh.Remove(otherID), qt.IsNotNil)
+	qt.Assert(t, wh.List("owner"), qt.HasLen, 1)
+}
+
+func TestWebhooksAddresses(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	signer := ethereum.NewSignKeys()
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	wh, err := NewWebhooks(t.TempDir(), signer, app, nil)
+	qt.Assert(t, err, qt.IsNil)
+	defer wh.Close()
+
+	for _, u := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.1.2.3/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		_, err := wh.Add(&Webhook{URL: u, Owner: "owner"})
+		qt.Assert(t, err, qt.IsNotNil, qt.Commentf("%s", u))
+	}
+	_, err = wh.Add(&Webhook{URL: "http://93.184.216.34/hook", Owner: "owner"})
+	qt.Assert(t, err, qt.IsNil)
+
+	// the address is checked again when dialing
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	d := &Delivery{ID: "id", Event: EventElectionEnded, Payload: []byte("{}")}
+	wh.AllowPrivateAddresses = true
+	qt.Assert(t, wh.send(context.Background(), &Webhook{URL: server.URL}, d), qt.IsNil)
+	wh.AllowPrivateAddresses = false
+	wh.client.CloseIdleConnections()
+	err = wh.send(context.Background(), &Webhook{URL: server.URL}, d)
+	qt.Assert(t, err, qt.ErrorMatches, ".*not allowed")
+}
+
+func TestWebhooksMaxPerOwner(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	signer := ethereum.NewSignKeys()
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	wh, err := NewWebhooks(t.TempDir(), signer, app, nil)
+	qt.Assert(t, err, qt.IsNil)
+	defer wh.Close()
+	wh.MaxPerOwner = 2
+
+	for i := 0; i < 2; i++ {
+		_, err := wh.Add(&Webhook{URL: "http://93.184.216.34/hook", Owner: "owner"})
+		qt.Assert(t, err, qt.IsNil)
+	}
+	_, err = wh.Add(&Webhook{URL: "http://93.184.216.34/hook", Owner: "owner"})
+	qt.Assert(t, err, qt.ErrorMatches, "maximum number of webhooks.*")
+	id, err := wh.Add(&Webhook{URL: "http://93.184.216.34/hook", Owner: "other"})
+	qt.Assert(t, err, qt.IsNil)
+
+	// a removed webhook frees its place
+	qt.Assert(t, wh.Remove(id), qt.IsNil)
+	qt.Assert(t, wh.Remove(wh.List("owner")[0].ID), qt.IsNil)
+	_, err = wh.Add(&Webhook{URL: "http://93.184.216.34/hook", Owner: "owner"})
+	qt.Assert(t, err, qt.IsNil)
+}
+
+func TestWebhooksConcurrentDeliveries(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	signer := ethereum.NewSignKeys()
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	wh, err := NewWebhooks(t.TempDir(), signer, app, nil)
+	qt.Assert(t, err, qt.IsNil)
+	defer wh.Close()
+	wh.AllowPrivateAddresses = true
+
+	// a slow endpoint does not delay the deliveries to the others
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	received := make(chan struct{}, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case received <- struct{}{}:
+		default:
+			t.Errorf("delivery sent twice")
+		}
+	}))
+	defer fast.Close()
+
+	_, err = wh.Add(&Webhook{URL: slow.URL})
+	qt.Assert(t, err, qt.IsNil)
+	fastID, err := wh.Add(&Webhook{URL: fast.URL})
+	qt.Assert(t, err, qt.IsNil)
+	wh.OnComputeResults(&indexertypes.Results{}, &indexertypes.Process{ID: util.RandomBytes(types.ProcessIDsize)}, 1)
+	wh.Start()
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the delivery")
+	}
+	for i := 0; i < 100; i++ {
+		deliveries, err := wh.Deliveries(fastID)
+		qt.Assert(t, err, qt.IsNil)
+		if len(deliveries) == 1 && deliveries[0].Status == DeliveryDelivered {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("delivery not stored as delivered")
+}
@@ -0,0 +1,473 @@
+package vochain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vocdoni/arbo"
+	snarkParsers "github.com/vocdoni/go-snark/parsers"
+	snarkTypes "github.com/vocdoni/go-snark/types"
+	"github.com/vocdoni/go-snark/verifier"
+	"go.vocdoni.io/dvote/crypto/zk"
+	zkartifacts "go.vocdoni.io/dvote/crypto/zk/artifacts"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/statedb"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The zk-SNARK circuits used by the anonymous votes are the ones of the
+// genesis CircuitsConfig, extended or replaced by the circuits registered in
+// the state by the treasurer, so new circuit sizes can be rolled out without
+// upgrading the nodes.  The registry admin transactions and their circuit are
+// not part of the models yet, so the circuit is kept as an unknown field of
+// the AdminTx message.
+
+const (
+	// TxTypeAddZkCircuit registers a circuit verification key at the
+	// circuit parameters index given by the AdminTx KeyIndex.
+	TxTypeAddZkCircuit models.TxType = 23
+	// TxTypeRemoveZkCircuit disables the circuit at the circuit parameters
+	// index given by the AdminTx KeyIndex, even if it is a genesis circuit.
+	TxTypeRemoveZkCircuit models.TxType = 24
+
+	// adminTxZkCircuitField is the protobuf field number of the circuit in
+	// the AdminTx message.
+	adminTxZkCircuitField = 12
+	// zkCircuitParametersField, zkCircuitVKHashField and
+	// zkCircuitVerificationKeyField are the protobuf field numbers of the
+	// ZkCircuit message.
+	zkCircuitParametersField      = 1
+	zkCircuitVKHashField          = 2
+	zkCircuitVerificationKeyField = 3
+
+	// zkCircuitKeyPrefix is the prefix of the Extra tree keys of the
+	// registered circuits, followed by the circuit parameters index.
+	zkCircuitKeyPrefix = "zkCircuit/"
+)
+
+// ZkVerificationTime is the latency of the zk-SNARK proof verifications.
+var ZkVerificationTime = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "vochain",
+	Name:      "zk_verification_seconds",
+	Help:      "Latency of the zk-SNARK census proof verifications",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+})
+
+// ZkCircuit is a zk-SNARK circuit of the registry.  The verification key is
+// the verification_key.json of the circuit, pinned by its sha256 VKHash.
+type ZkCircuit struct {
+	Parameters      []int64
+	VKHash          []byte
+	VerificationKey []byte
+}
+
+// Check verifies the circuit parameters and that the verification key
+// matches its hash and can be parsed.
+func (c *ZkCircuit) Check() error {
+	if len(c.Parameters) == 0 || c.Parameters[0] <= 0 {
+		return fmt.Errorf("circuit parameters must start with the census size")
+	}
+	hash := sha256.Sum256(c.VerificationKey)
+	if !bytes.Equal(hash[:], c.VKHash) {
+		return fmt.Errorf("verification key hash %x does not match %x", hash, c.VKHash)
+	}
+	if _, err := snarkParsers.ParseVk(c.VerificationKey); err != nil {
+		return fmt.Errorf("cannot parse verification key: %w", err)
+	}
+	return nil
+}
+
+// Marshal encodes the circuit as a ZkCircuit message.
+func (c *ZkCircuit) Marshal() []byte {
+	var b []byte
+	for _, p := range c.Parameters {
+		b = protowire.AppendTag(b, zkCircuitParametersField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(p))
+	}
+	b = protowire.AppendTag(b, zkCircuitVKHashField, protowire.BytesType)
+	b = protowire.AppendBytes(b, c.VKHash)
+	b = protowire.AppendTag(b, zkCircuitVerificationKeyField, protowire.BytesType)
+	b = protowire.AppendBytes(b, c.VerificationKey)
+	return b
+}
+
+// Unmarshal decodes a ZkCircuit message.
+func (c *ZkCircuit) Unmarshal(b []byte) error {
+	*c = ZkCircuit{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("cannot decode circuit: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch {
+		case num == zkCircuitParametersField && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			c.Parameters = append(c.Parameters, int64(v))
+		case num == zkCircuitVKHashField && typ == protowire.BytesType:
+			c.VKHash, n = protowire.ConsumeBytes(b)
+		case num == zkCircuitVerificationKeyField && typ == protowire.BytesType:
+			c.VerificationKey, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("cannot decode circuit: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// AdminTxZkCircuit returns the circuit carried by the admin transaction, or nil
+// if there is none.
+func AdminTxZkCircuit(tx *models.AdminTx) (*ZkCircuit, error) {
+	var circuit *ZkCircuit
+	err := walkUnknownFields(tx, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != adminTxZkCircuitField || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+		circuit = &ZkCircuit{}
+		return n, circuit.Unmarshal(v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return circuit, nil
+}
+
+// SetAdminTxZkCircuit sets the circuit carried by the admin transaction.
+func SetAdminTxZkCircuit(tx *models.AdminTx, circuit *ZkCircuit) {
+	b := withoutUnknownField(tx, adminTxZkCircuitField)
+	if circuit != nil {
+		b = protowire.AppendTag(b, adminTxZkCircuitField, protowire.BytesType)
+		b = protowire.AppendBytes(b, circuit.Marshal())
+	}
+	tx.ProtoReflect().SetUnknown(b)
+}
+
+func zkCircuitKey(index uint32) []byte {
+	return []byte(fmt.Sprintf("%s%d", zkCircuitKeyPrefix, index))
+}
+
+// SetZkCircuit registers the circuit at the circuit parameters index.
+func (v *State) SetZkCircuit(index uint32, circuit *ZkCircuit) error {
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	return v.Tx.DeepSet(zkCircuitKey(index), circuit.Marshal(), StateTreeCfg(TreeExtra))
+}
+
+// RemoveZkCircuit disables the circuit at the circuit parameters index.  The
+// removed circuits are kept in the registry with an empty value, so they
+// override the genesis circuits too.
+func (v *State) RemoveZkCircuit(index uint32) error {
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	return v.Tx.DeepSet(zkCircuitKey(index), nil, StateTreeCfg(TreeExtra))
+}
+
+// ZkCircuit returns the circuit of the registry at the circuit parameters
+// index, and whether the index is registered.  A removed circuit is returned
+// as nil.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) ZkCircuit(index uint32, committed bool) (*ZkCircuit, bool, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	value, err := v.mainTreeViewer(committed).DeepGet(zkCircuitKey(index), StateTreeCfg(TreeExtra))
+	if errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if len(value) == 0 {
+		return nil, true, nil
+	}
+	circuit := &ZkCircuit{}
+	if err := circuit.Unmarshal(value); err != nil {
+		return nil, false, err
+	}
+	return circuit, true, nil
+}
+
+// ZkCircuits returns the circuits of the registry by circuit parameters index.
+// The removed circuits are returned as nil.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) ZkCircuits(committed bool) (map[uint32]*ZkCircuit, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	return getZkCircuits(v.mainTreeViewer(committed))
+}
+
+func getZkCircuits(mainTreeView statedb.TreeViewer) (map[uint32]*ZkCircuit, error) {
+	extraTree, err := mainTreeView.SubTree(StateTreeCfg(TreeExtra))
+	if err != nil {
+		return nil, err
+	}
+	circuits := make(map[uint32]*ZkCircuit)
+	var ierr error
+	if err := extraTree.Iterate(func(key, value []byte) bool {
+		if !strings.HasPrefix(string(key), zkCircuitKeyPrefix) {
+			return false
+		}
+		var index uint32
+		if _, ierr = fmt.Sscanf(string(key[len(zkCircuitKeyPrefix):]), "%d", &index); ierr != nil {
+			return true
+		}
+		if len(value) == 0 {
+			circuits[index] = nil
+			return false
+		}
+		circuit := &ZkCircuit{}
+		if ierr = circuit.Unmarshal(value); ierr != nil {
+			return true
+		}
+		circuits[index] = circuit
+		return false
+	}); err != nil {
+		return nil, err
+	}
+	if ierr != nil {
+		return nil, fmt.Errorf("cannot decode zk circuit: %w", ierr)
+	}
+	return circuits, nil
+}
+
+// genesisCircuits returns the circuits configuration of the chain genesis.
+func (app *BaseApplication) genesisCircuits() []zkartifacts.CircuitConfig {
+	if genesis, ok := Genesis[app.chainID]; ok {
+		return genesis.CircuitsConfig
+	}
+	log.Warn("using dev network genesis CircuitsConfig")
+	return Genesis["dev"].CircuitsConfig
+}
+
+// zkVerificationKey returns the verification key of the circuit at the circuit
+// parameters index.  The registered circuits take precedence over the genesis
+// ones.
+func (app *BaseApplication) zkVerificationKey(index int32, committed bool) (*snarkTypes.Vk, error) {
+	if index < 0 {
+		return nil, fmt.Errorf("invalid CircuitParametersIndex: %d", index)
+	}
+	circuit, registered, err := app.State.ZkCircuit(uint32(index), committed)
+	if err != nil {
+		return nil, err
+	}
+	if !registered {
+		if int(index) >= len(app.ZkVKs) {
+			return nil, fmt.Errorf("invalid CircuitParametersIndex: %d of %d", index, len(app.ZkVKs))
+		}
+		return app.ZkVKs[index], nil
+	}
+	if circuit == nil {
+		return nil, fmt.Errorf("circuit %d has been removed", index)
+	}
+	return app.zkVerifier.parseVK(circuit)
+}
+
+// zkMaxCensusSize returns the biggest census size supported by the circuits.
+func (app *BaseApplication) zkMaxCensusSize() (uint64, error) {
+	circuits, err := app.State.ZkCircuits(false)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for i, cc := range app.genesisCircuits() {
+		if _, registered := circuits[uint32(i)]; !registered && cc.Parameters[0] > size {
+			size = cc.Parameters[0]
+		}
+	}
+	for _, circuit := range circuits {
+		if circuit != nil && circuit.Parameters[0] > size {
+			size = circuit.Parameters[0]
+		}
+	}
+	return uint64(size), nil
+}
+
+// zkVoteInputs returns the public inputs of the zk-SNARK census proof of an
+// anonymous vote: processId0, processId1, censusRoot, nullifier, voteHash0
+// and voteHash1.
+func zkVoteInputs(process *models.Process, ve *models.VoteEnvelope) []*big.Int {
+	voteValueHash := sha256.Sum256(ve.VotePackage)
+	return []*big.Int{
+		arbo.BytesToBigInt(process.ProcessId[:16]),
+		arbo.BytesToBigInt(process.ProcessId[16:]),
+		arbo.BytesToBigInt(process.RollingCensusRoot),
+		// ve.Nullifier is encoded in little-endian
+		arbo.BytesToBigInt(ve.Nullifier),
+		arbo.BytesToBigInt(voteValueHash[:16]),
+		arbo.BytesToBigInt(voteValueHash[16:]),
+	}
+}
+
+// zkVerification is a zk-SNARK proof verification, which may be in progress.
+type zkVerification struct {
+	vk    *snarkTypes.Vk
+	done  chan struct{}
+	valid bool
+}
+
+// zkVerifier verifies zk-SNARK proofs.  The proofs of the anonymous votes of a
+// block are verified in parallel when the block begins, so their checks only
+// wait for the results.
+type zkVerifier struct {
+	lock sync.Mutex
+	// verifications of the current block, by proof and public inputs hash
+	verifications map[[sha256.Size]byte]*zkVerification
+	// vks caches the parsed verification keys of the registry, by hash
+	vks sync.Map
+	// workers limits the number of concurrent verifications
+	workers chan struct{}
+}
+
+func newZkVerifier() *zkVerifier {
+	return &zkVerifier{
+		verifications: make(map[[sha256.Size]byte]*zkVerification),
+		workers:       make(chan struct{}, runtime.NumCPU()),
+	}
+}
+
+// parseVK returns the parsed verification key of a registered circuit.
+func (z *zkVerifier) parseVK(circuit *ZkCircuit) (*snarkTypes.Vk, error) {
+	if vk, ok := z.vks.Load(string(circuit.VKHash)); ok {
+		return vk.(*snarkTypes.Vk), nil
+	}
+	vk, err := snarkParsers.ParseVk(circuit.VerificationKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse verification key: %w", err)
+	}
+	z.vks.Store(string(circuit.VKHash), vk)
+	return vk, nil
+}
+
+// zkVerificationID identifies the verification of a proof with its inputs.
+func zkVerificationID(proof *models.ProofZkSNARK, inputs []*big.Int) [sha256.Size]byte {
+	h := sha256.New()
+	var index [4]byte
+	binary.LittleEndian.PutUint32(index[:], uint32(proof.CircuitParametersIndex))
+	h.Write(index[:])
+	for _, s := range [][]string{proof.A, proof.B, proof.C} {
+		for _, v := range s {
+			h.Write([]byte(v))
+			h.Write([]byte{0})
+		}
+	}
+	for _, input := range inputs {
+		h.Write(arbo.BigIntToBytes(32, input))
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// verify checks the zk-SNARK proof with its public inputs.  If the proof is
+// already being verified by the block, its result is awaited.
+func (z *zkVerifier) verify(vk *snarkTypes.Vk, proof *models.ProofZkSNARK, inputs []*big.Int) (bool, error) {
+	z.lock.Lock()
+	v, ok := z.verifications[zkVerificationID(proof, inputs)]
+	z.lock.Unlock()
+	if ok && v.vk == vk {
+		<-v.done
+		return v.valid, nil
+	}
+	circomProof, _, err := zk.ProtobufZKProofToCircomProof(proof)
+	if err != nil {
+		return false, fmt.Errorf("failed on zk.ProtobufZKProofToCircomProof: %w", err)
+	}
+	return z.run(vk, circomProof, inputs), nil
+}
+
+// run verifies a proof and observes its latency.
+func (z *zkVerifier) run(vk *snarkTypes.Vk, proof *snarkTypes.Proof, inputs []*big.Int) bool {
+	start := time.Now()
+	valid := verifier.Verify(vk, proof, inputs)
+	ZkVerificationTime.Observe(time.Since(start).Seconds())
+	return valid
+}
+
+// prefetch starts the verification of a proof in the background.
+func (z *zkVerifier) prefetch(vk *snarkTypes.Vk, proof *models.ProofZkSNARK, inputs []*big.Int) {
+	circomProof, _, err := zk.ProtobufZKProofToCircomProof(proof)
+	if err != nil {
+		return
+	}
+	key := zkVerificationID(proof, inputs)
+	v := &zkVerification{vk: vk, done: make(chan struct{})}
+	z.lock.Lock()
+	if _, ok := z.verifications[key]; ok {
+		z.lock.Unlock()
+		return
+	}
+	z.verifications[key] = v
+	z.lock.Unlock()
+	go func() {
+		z.workers <- struct{}{}
+		defer func() { <-z.workers }()
+		v.valid = z.run(vk, circomProof, inputs)
+		close(v.done)
+	}()
+}
+
+// reset forgets the verifications of the previous block.
+func (z *zkVerifier) reset() {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	z.verifications = make(map[[sha256.Size]byte]*zkVerification)
+}
+
+// prefetchZkProofs starts verifying in parallel the zk-SNARK proofs of the
+// anonymous votes of the block at height, if it is already in the block store.
+func (app *BaseApplication) prefetchZkProofs(height int64) {
+	app.zkVerifier.reset()
+	if app.fnGetBlockByHeight == nil {
+		return
+	}
+	block := app.fnGetBlockByHeight(height)
+	if block == nil {
+		return
+	}
+	for _, txBytes := range block.Txs {
+		tx := new(VochainTx)
+		if err := tx.Unmarshal(txBytes, app.ChainID()); err != nil {
+			continue
+		}
+		ve := tx.Tx.GetVote()
+		proof := ve.GetProof().GetZkSnark()
+		if proof == nil {
+			continue
+		}
+		process, err := app.State.Process(ve.ProcessId, true)
+		if err != nil || !process.GetEnvelopeType().GetAnonymous() || len(process.ProcessId) != 32 {
+			continue
+		}
+		vk, err := app.zkVerificationKey(proof.CircuitParametersIndex, true)
+		if err != nil {
+			log.Debugf("cannot prefetch zk proof of vote %x: %v", ve.Nullifier, err)
+			continue
+		}
+		app.zkVerifier.prefetch(vk, proof, zkVoteInputs(process, ve))
+	}
+}
@@ -0,0 +1,174 @@
+package vocone
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/vochain"
+	models "go.vocdoni.io/proto/build/go/models"
+)
+
+// Mempool priority of each kind of transaction.  Within the same kind, the
+// transactions with a higher cost go first.
+const (
+	txPriorityAccount = iota + 1
+	txPriorityProcess
+	txPriorityVote
+	txPriorityOracle
+
+	// txPriorityStep is the priority range of each kind of transaction
+	txPriorityStep = 1 << 32
+)
+
+// txPriority returns the mempool priority of a checked transaction, so the
+// oracle results and process keys go first, then the votes, the process
+// management and finally the account and token transactions.  It also
+// returns whether the transaction uses the nonce of the sender account.
+//
+// Tendermint 0.34 does not support mempool priorities, so the vochain node
+// mempool keeps the FIFO order and only vocone sorts its mempool by priority.
+func txPriority(state *vochain.State, tx *models.Tx) (int64, bool) {
+	var txType models.TxType
+	priority, nonce := int64(txPriorityAccount), true
+	switch t := tx.Payload.(type) {
+	case *models.Tx_Vote:
+		return txPriorityVote * txPriorityStep, false
+	case *models.Tx_Admin:
+		txType, nonce = t.Admin.Txtype, false
+		if txType == models.TxType_ADD_PROCESS_KEYS || txType == models.TxType_REVEAL_PROCESS_KEYS {
+			priority = txPriorityOracle
+		}
+	case *models.Tx_SetProcess:
+		txType = t.SetProcess.Txtype
+		priority = txPriorityProcess
+		if txType == models.TxType_SET_PROCESS_RESULTS {
+			priority = txPriorityOracle
+		}
+	case *models.Tx_NewProcess:
+		txType = models.TxType_NEW_PROCESS
+		priority = txPriorityProcess
+	case *models.Tx_RegisterKey:
+		txType, nonce = models.TxType_REGISTER_VOTER_KEY, false
+		priority = txPriorityProcess
+	case *models.Tx_SendTokens:
+		txType = models.TxType_SEND_TOKENS
+	case *models.Tx_SetAccountInfo:
+		txType = models.TxType_SET_ACCOUNT_INFO
+	case *models.Tx_SetAccountDelegateTx:
+		txType = t.SetAccountDelegateTx.Txtype
+	case *models.Tx_CollectFaucet:
+		txType, nonce = models.TxType_COLLECT_FAUCET, false
+	default:
+		nonce = false
+	}
+	// transactions without cost have the lowest priority of their kind
+	cost, err := state.TxCost(txType, false)
+	if err != nil || cost >= txPriorityStep {
+		cost = 0
+	}
+	return priority*txPriorityStep + int64(cost), nonce
+}
+
+// mempoolTx is a transaction waiting in the mempool
+type mempoolTx struct {
+	tx       []byte
+	priority int64
+	// sender is the account whose nonce is used by the transaction, if any
+	sender *common.Address
+	// seq keeps the arrival order of the transactions with the same priority
+	seq uint64
+}
+
+// txHeap implements heap.Interface, the first element is the transaction with
+// the highest priority that arrived first.
+type txHeap []*mempoolTx
+
+func (h txHeap) Len() int { return len(h) }
+func (h txHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h txHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *txHeap) Push(x interface{}) { *h = append(*h, x.(*mempoolTx)) }
+func (h *txHeap) Pop() interface{} {
+	old := *h
+	tx := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return tx
+}
+
+// pendingSender is the number of transactions of an account in the mempool
+// and the priority of the last one.
+type pendingSender struct {
+	count    int
+	priority int64
+}
+
+// priorityMempool is a fixed size mempool which returns the transactions
+// ordered by priority, and by arrival order for the same priority.
+type priorityMempool struct {
+	lock    sync.Mutex
+	txs     txHeap
+	senders map[common.Address]*pendingSender
+	size    int
+	seq     uint64
+}
+
+func newPriorityMempool(size int) *priorityMempool {
+	return &priorityMempool{size: size, senders: make(map[common.Address]*pendingSender)}
+}
+
+// Enqueue adds a transaction to the mempool.  If the transaction uses the
+// nonce of the sender account, it never has a higher priority than the
+// previous transaction of the same account, so they are delivered in order.
+func (m *priorityMempool) Enqueue(tx []byte, sender *common.Address, priority int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.txs) >= m.size {
+		return fmt.Errorf("mempool is full")
+	}
+	if sender != nil {
+		pending, ok := m.senders[*sender]
+		if !ok {
+			pending = &pendingSender{priority: priority}
+			m.senders[*sender] = pending
+		}
+		if pending.priority < priority {
+			priority = pending.priority
+		}
+		pending.count++
+		pending.priority = priority
+	}
+	m.seq++
+	heap.Push(&m.txs, &mempoolTx{tx: tx, priority: priority, sender: sender, seq: m.seq})
+	return nil
+}
+
+// Dequeue removes and returns the transaction with the highest priority
+func (m *priorityMempool) Dequeue() ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.txs) == 0 {
+		return nil, fmt.Errorf("mempool is empty")
+	}
+	tx := heap.Pop(&m.txs).(*mempoolTx)
+	if tx.sender != nil {
+		pending := m.senders[*tx.sender]
+		if pending.count--; pending.count == 0 {
+			delete(m.senders, *tx.sender)
+		}
+	}
+	return tx.tx, nil
+}
+
+// GetLen returns the number of transactions in the mempool
+func (m *priorityMempool) GetLen() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.txs)
+}
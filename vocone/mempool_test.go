@@ -0,0 +1,70 @@
+package vocone
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/vochain"
+	models "go.vocdoni.io/proto/build/go/models"
+)
+
+func TestPriorityMempool(t *testing.T) {
+	m := newPriorityMempool(4)
+	for i, priority := range []int64{1, 3, 1, 2} {
+		qt.Assert(t, m.Enqueue([]byte{byte(i)}, nil, priority), qt.IsNil)
+	}
+	qt.Assert(t, m.Enqueue([]byte{4}, nil, 5), qt.IsNotNil)
+	qt.Assert(t, m.GetLen(), qt.Equals, 4)
+
+	// by priority, and by arrival order for the same priority
+	for _, expected := range []byte{1, 3, 0, 2} {
+		tx, err := m.Dequeue()
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, tx, qt.DeepEquals, []byte{expected})
+	}
+	_, err := m.Dequeue()
+	qt.Assert(t, err, qt.IsNotNil)
+
+	// a transaction does not go ahead of the previous one of the same sender
+	signer := ethereum.SignKeys{}
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	sender := signer.Address()
+	qt.Assert(t, m.Enqueue([]byte{0}, &sender, 1), qt.IsNil)
+	qt.Assert(t, m.Enqueue([]byte{1}, nil, 2), qt.IsNil)
+	qt.Assert(t, m.Enqueue([]byte{2}, &sender, 3), qt.IsNil)
+	for _, expected := range []byte{1, 0, 2} {
+		tx, err := m.Dequeue()
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, tx, qt.DeepEquals, []byte{expected})
+	}
+	qt.Assert(t, m.senders, qt.HasLen, 0)
+}
+
+func TestTxPriority(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	qt.Assert(t, app.State.SetTxCost(models.TxType_SEND_TOKENS, 10), qt.IsNil)
+	qt.Assert(t, app.State.SetTxCost(models.TxType_SET_ACCOUNT_INFO, 20), qt.IsNil)
+
+	priority := func(tx *models.Tx) int64 {
+		p, _ := txPriority(app.State, tx)
+		return p
+	}
+	vote := priority(&models.Tx{Payload: &models.Tx_Vote{Vote: &models.VoteEnvelope{}}})
+	results := priority(&models.Tx{Payload: &models.Tx_SetProcess{SetProcess: &models.SetProcessTx{
+		Txtype: models.TxType_SET_PROCESS_RESULTS,
+	}}})
+	process := priority(&models.Tx{Payload: &models.Tx_NewProcess{NewProcess: &models.NewProcessTx{
+		Txtype: models.TxType_NEW_PROCESS,
+	}}})
+	sendTokens, nonce := txPriority(app.State, &models.Tx{Payload: &models.Tx_SendTokens{
+		SendTokens: &models.SendTokensTx{Txtype: models.TxType_SEND_TOKENS}}})
+	qt.Assert(t, nonce, qt.IsTrue)
+	accountInfo := priority(&models.Tx{Payload: &models.Tx_SetAccountInfo{
+		SetAccountInfo: &models.SetAccountInfoTx{Txtype: models.TxType_SET_ACCOUNT_INFO}}})
+	qt.Assert(t, results > vote, qt.IsTrue)
+	qt.Assert(t, vote > process, qt.IsTrue)
+	qt.Assert(t, process > accountInfo, qt.IsTrue)
+	// within the same kind, by cost
+	qt.Assert(t, accountInfo > sendTokens, qt.IsTrue)
+}
@@ -8,7 +8,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/enriquebris/goconcurrentqueue"
 	"github.com/ethereum/go-ethereum/common"
 	abcitypes "github.com/tendermint/tendermint/abci/types"
 	tmprototypes "github.com/tendermint/tendermint/proto/tendermint/types"
@@ -45,7 +44,7 @@ const (
 type Vocone struct {
 	sc              *scrutinizer.Scrutinizer
 	kk              *keykeeper.KeyKeeper
-	mempool         *goconcurrentqueue.FixedFIFO
+	mempool         *priorityMempool
 	blockStore      db.Database
 	height          int64
 	appInfo         *vochaininfo.VochainInfo
@@ -69,7 +68,7 @@ func NewVocone(dataDir string, oracleKey *ethereum.SignKeys, disableIpfs bool) (
 	if err != nil {
 		return nil, err
 	}
-	vc.mempool = goconcurrentqueue.NewFixedFIFO(mempoolSize)
+	vc.mempool = newPriorityMempool(mempoolSize)
 	vc.blockTimeTarget = DefaultBlockTimeTarget
 	vc.txsPerBlock = DefaultTxsPerBlock
 	version, err := vc.app.State.Store.Version()
@@ -138,8 +137,12 @@ func NewVocone(dataDir string, oracleKey *ethereum.SignKeys, disableIpfs bool) (
 	vc.storage, err = service.IPFS(&config.IPFSCfg{
 		ConfigPath: filepath.Join(dataDir, "ipfs"), NoInit: disableIpfs,
 	}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	vc.sc.SetMetadataStorage(vc.storage)
 
-	return vc, err
+	return vc, nil
 }
 
 // EnableAPI starts the HTTP API
@@ -315,7 +318,20 @@ func (vc *Vocone) setDefaultMethods() {
 func (vc *Vocone) addTx(tx []byte) (*tmcoretypes.ResultBroadcastTx, error) {
 	resp := vc.app.CheckTx(abcitypes.RequestCheckTx{Tx: tx})
 	if resp.Code == 0 {
-		if err := vc.mempool.Enqueue(tx); err != nil {
+		vtx := new(vochain.VochainTx)
+		if err := vtx.Unmarshal(tx, vc.app.ChainID()); err != nil {
+			return nil, err
+		}
+		priority, nonce := txPriority(vc.app.State, vtx.Tx)
+		var sender *common.Address
+		if nonce {
+			addr, err := ethereum.AddrFromSignature(vtx.SignedBody, vtx.Signature)
+			if err != nil {
+				return nil, err
+			}
+			sender = &addr
+		}
+		if err := vc.mempool.Enqueue(tx, sender, priority); err != nil {
 			return &tmcoretypes.ResultBroadcastTx{
 				Code: 1,
 				Data: []byte("mempool is full"),
@@ -340,11 +356,11 @@ func (vc *Vocone) commitBlock() {
 		if err != nil {
 			break
 		}
-		resp := vc.app.DeliverTx(abcitypes.RequestDeliverTx{Tx: tx.([]byte)})
+		resp := vc.app.DeliverTx(abcitypes.RequestDeliverTx{Tx: tx})
 		if resp.Code == 0 {
 			blockStoreTx.Set(
 				[]byte(fmt.Sprintf("%d_%d", vc.height, txCount)),
-				tx.([]byte),
+				tx,
 			)
 			txCount++
 		} else {